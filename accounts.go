@@ -0,0 +1,550 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/password"
+	"github.com/johscheuer/todo-app-web/tododb"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// accountKey holds the name of the account a request is signed in with
+	accountKey = "account"
+
+	maxPasswordLength = 256
+	// maxResetMails is how many reset mails an account gets within the
+	// lifetime of a token
+	maxResetMails = 3
+)
+
+var accountNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{1,31}$`)
+
+// account is a tododb.User without its secrets.
+type account struct {
+	Name      string    `json:"name"`
+	Email     string    `json:"email,omitempty"`
+	Disabled  bool      `json:"disabled"`
+	Created   time.Time `json:"created"`
+	TwoFactor bool      `json:"twoFactor"`
+}
+
+func accountOf(user tododb.User) account {
+	return account{
+		Name:      user.Name,
+		Email:     user.Email,
+		Disabled:  user.Disabled,
+		Created:   user.Created,
+		TwoFactor: user.TOTPEnabled,
+	}
+}
+
+// passwordHasher hashes new passwords with the Hasher of the config.
+func passwordHasher() (password.Hasher, error) {
+	config := appConfig.Accounts
+	if config.Hasher == "" || config.Hasher == "argon2id" {
+		return password.Argon2id{
+			Memory:  config.Argon2.MemoryKiB,
+			Time:    config.Argon2.Iterations,
+			Threads: config.Argon2.Parallelism,
+		}, nil
+	}
+
+	return password.Lookup(config.Hasher)
+}
+
+func checkPassword(secret string) error {
+	if len(secret) < appConfig.Accounts.MinPasswordLength || len(secret) > maxPasswordLength {
+		return fmt.Errorf("password must be %d to %d characters long", appConfig.Accounts.MinPasswordLength, maxPasswordLength)
+	}
+
+	return nil
+}
+
+// registerAccountHandler lets everyone create an account with
+// OpenRegistration, else the admins have to.
+func registerAccountHandler(c *gin.Context) {
+	if !appConfig.Accounts.OpenRegistration {
+		c.JSON(http.StatusForbidden, gin.H{
+			"errors": "registration is closed, ask an admin for an account",
+		})
+		return
+	}
+
+	createAccount(c)
+}
+
+func createAccountHandler(c *gin.Context) {
+	createAccount(c)
+}
+
+// createAccount refuses accounts on backends without a KV of their own, like
+// git and memory, which would lose them on restart.
+func createAccount(c *gin.Context) {
+	if !tododb.PersistentKV(database) {
+		c.JSON(http.StatusNotImplemented, gin.H{
+			"errors": fmt.Sprintf("accounts need a backend that keeps them, %s loses them on restart", appConfig.Backend),
+		})
+		return
+	}
+
+	var request struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+		Email    string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	name := strings.ToLower(request.Name)
+	if !accountNamePattern.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "name must be 2 to 32 letters, digits, ., - or _",
+		})
+		return
+	}
+	if err := checkPassword(request.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	if strings.ContainsAny(request.Email, "\r\n") || (request.Email != "" && !strings.Contains(request.Email, "@")) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("invalid email %q", request.Email),
+		})
+		return
+	}
+
+	hasher, err := passwordHasher()
+	if err == nil {
+		request.Password, err = hasher.Hash(request.Password)
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	user := tododb.User{
+		Name:         name,
+		Email:        request.Email,
+		PasswordHash: request.Password,
+		Created:      time.Now().UTC(),
+	}
+	if err := tododb.UsersOf(database).CreateUser(user); err == tododb.ErrUserExists {
+		c.JSON(http.StatusConflict, gin.H{
+			"errors": "name " + name + " is already taken",
+		})
+		return
+	} else if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	logger.Infof("Created account %s", name)
+	c.JSON(http.StatusCreated, accountOf(user))
+}
+
+func getAccountHandler(c *gin.Context) {
+	user, err := tododb.UsersOf(database).GetUser(c.Param("name"))
+	if err == tododb.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": "account " + c.Param("name") + " not found",
+		})
+		return
+	} else if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, accountOf(user))
+}
+
+// setAccountDisabledHandler disables or enables an account. Disabled
+// accounts can't sign in, even with the right password.
+func setAccountDisabledHandler(disabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+		err := tododb.UsersOf(database).UpdateUser(name, func(user *tododb.User) {
+			user.Disabled = disabled
+		})
+		if err == tododb.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"errors": "account " + name + " not found",
+			})
+			return
+		} else if err != nil {
+			logger.Errorf("%v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+
+		if disabled {
+			if err := revokeSessions(name); err != nil {
+				logger.Errorf("%v", err)
+			}
+		}
+
+		logger.Infof("Set disabled of account %s to %t", name, disabled)
+		getAccountHandler(c)
+	}
+}
+
+// accountAuthMiddleware signs requests in with the token of a session, an
+// access token or the basic auth of a local account, and the code in X-OTP for accounts with
+// two-factor authentication. Wrong passwords and codes are counted per
+// account and client IP (maxFailures), per client IP (ipFailures) and per
+// account (accountFailures), see loginGuard. With optional requests without
+// any credentials pass anonymously.
+func accountAuthMiddleware(options map[string]string, failuresTotal, lockoutsTotal *prometheus.CounterVec) (gin.HandlerFunc, error) {
+	if database == nil {
+		return nil, errors.New("accountAuth needs a backend, it can't run in the frontend role")
+	}
+	if !tododb.PersistentKV(database) {
+		logger.Warnf("Accounts are disabled, the %s backend doesn't keep them across restarts", appConfig.Backend)
+	}
+
+	maxFailures, err := intOption(options, "maxFailures", 5)
+	if err != nil {
+		return nil, err
+	}
+	ipFailures, err := intOption(options, "ipFailures", 4*maxFailures)
+	if err != nil {
+		return nil, err
+	}
+	accountFailures, err := intOption(options, "accountFailures", 3*maxFailures)
+	if err != nil {
+		return nil, err
+	}
+	lockoutMinutes, err := intOption(options, "lockoutMinutes", 15)
+	if err != nil {
+		return nil, err
+	}
+	maxLockoutMinutes, err := intOption(options, "maxLockoutMinutes", int(lockoutMemory.Minutes()))
+	if err != nil {
+		return nil, err
+	}
+	if maxFailures <= 0 || ipFailures <= 0 || accountFailures <= 0 || lockoutMinutes <= 0 {
+		return nil, errors.New("maxFailures, ipFailures, accountFailures and lockoutMinutes must be positive")
+	}
+	optional := false
+	if value, exists := options["optional"]; exists {
+		if optional, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid optional %q", value)
+		}
+	}
+	if maxLockoutMinutes < lockoutMinutes || maxLockoutMinutes > int(lockoutMemory.Minutes()) {
+		return nil, fmt.Errorf("maxLockoutMinutes must be between lockoutMinutes and %d", int(lockoutMemory.Minutes()))
+	}
+
+	guard := loginGuard{
+		maxFailures:     maxFailures,
+		ipFailures:      ipFailures,
+		accountFailures: accountFailures,
+		lockout:         time.Duration(lockoutMinutes) * time.Minute,
+		maxLockout:      time.Duration(maxLockoutMinutes) * time.Minute,
+		failuresTotal:   failuresTotal,
+		lockoutsTotal:   lockoutsTotal,
+	}
+
+	return func(c *gin.Context) {
+		if token := bearerToken(c); token != "" {
+			authenticate := authenticateSession
+			if isJWT(token) {
+				authenticate = authenticateJWT
+			}
+			if authenticate(c, token) {
+				c.Next()
+			}
+			return
+		}
+
+		name, secret, ok := c.Request.BasicAuth()
+		if !ok && optional {
+			c.Next()
+			return
+		}
+		if !ok {
+			c.Header("WWW-Authenticate", `Basic realm="todo-app"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"errors": "sign in with the name and password of your account",
+			})
+			return
+		}
+		name = strings.ToLower(name)
+
+		ip := c.ClientIP()
+		remaining, err := guard.lockedFor(name, ip)
+		if err != nil {
+			logger.Errorf("%v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+		if remaining > 0 {
+			retryAfter(c, remaining)
+			return
+		}
+
+		user, valid, err := verifyAccount(name, secret)
+		if err != nil {
+			logger.Errorf("%v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+
+		fail := func(reason, message string) {
+			guard.failed(name, ip, reason)
+			c.Header("WWW-Authenticate", `Basic realm="todo-app"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"errors": message,
+			})
+		}
+
+		if !valid {
+			fail("password", "invalid name or password")
+			return
+		}
+
+		// a disabled account gets the same answer as a wrong password, so
+		// it doesn't confirm the password
+		if user.Disabled {
+			fail("disabled", "invalid name or password")
+			return
+		}
+
+		if user.TOTPEnabled {
+			code := c.GetHeader(otpHeader)
+			if code == "" {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+					"errors": "two-factor code required, send it in " + otpHeader,
+				})
+				return
+			}
+
+			valid, err := checkSecondFactor(user, code, ip)
+			if err != nil {
+				logger.Errorf("%v", err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+					"errors": err.Error(),
+				})
+				return
+			}
+			if !valid {
+				fail("otp", "invalid two-factor code")
+				return
+			}
+		}
+
+		guard.succeeded(name, ip)
+		rehashPassword(user, secret)
+		c.Set(accountKey, name)
+		c.Next()
+	}, nil
+}
+
+var (
+	unknownUserHash     string
+	unknownUserHashOnce sync.Once
+)
+
+// verifyAccount checks the password of an account. Unknown accounts are
+// checked against a throwaway hash, so they take as long as known ones.
+func verifyAccount(name, secret string) (tododb.User, bool, error) {
+	user, err := tododb.UsersOf(database).GetUser(name)
+	if err == tododb.ErrNotFound {
+		unknownUserHashOnce.Do(func() {
+			if hasher, err := passwordHasher(); err == nil {
+				unknownUserHash, _ = hasher.Hash("unknown user")
+			}
+		})
+		password.Verify(secret, unknownUserHash)
+		return user, false, nil
+	} else if err != nil {
+		return user, false, err
+	}
+
+	valid, err := password.Verify(secret, user.PasswordHash)
+	return user, valid, err
+}
+
+// rehashPassword upgrades the hash of a user after a sign in, if the hasher
+// or its parameters were changed since the password was set.
+func rehashPassword(user tododb.User, secret string) {
+	hasher, err := passwordHasher()
+	if err != nil || !password.NeedsRehash(hasher, user.PasswordHash) {
+		return
+	}
+
+	hash, err := hasher.Hash(secret)
+	if err == nil {
+		err = tododb.UsersOf(database).UpdateUser(user.Name, func(user *tododb.User) {
+			user.PasswordHash = hash
+		})
+	}
+	if err != nil {
+		logger.Errorf("rehash password of %s: %v", user.Name, err)
+	}
+}
+
+func passwordResetKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "password-reset:" + hex.EncodeToString(sum[:])
+}
+
+// requestPasswordResetHandler mails a reset token to the email of the
+// account. It answers the same whether the account exists or not.
+func requestPasswordResetHandler(c *gin.Context) {
+	if appConfig.Mail.SMTPAddr == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"errors": "password resets need mail, ask an admin",
+		})
+		return
+	}
+
+	var request struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	// The link must not come from the Host of the request, anyone could
+	// point it to their own server
+	go sendPasswordReset(strings.ToLower(request.Name), strings.TrimSuffix(appConfig.PublicURL, "/"))
+	c.JSON(http.StatusAccepted, gin.H{
+		"status": "if the account has an email, a reset token is on its way",
+	})
+}
+
+func sendPasswordReset(name, baseURL string) {
+	user, err := tododb.UsersOf(database).GetUser(name)
+	if err != nil || user.Disabled || user.Email == "" {
+		if err != nil && err != tododb.ErrNotFound {
+			logger.Errorf("%v", err)
+		}
+		return
+	}
+
+	kv := tododb.KVOf(database)
+	validity := time.Duration(appConfig.Accounts.ResetTokenMinutes) * time.Minute
+	sent, err := kv.IncrValue("password-reset:sent:"+name, validity)
+	if err != nil {
+		logger.Errorf("%v", err)
+		return
+	}
+	if sent > maxResetMails {
+		logger.Warnf("Dropped password reset of %s, %d mails were sent already", name, maxResetMails)
+		return
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		logger.Errorf("%v", err)
+		return
+	}
+	token := hex.EncodeToString(raw)
+	if err := kv.SetValue(passwordResetKey(token), name, validity); err != nil {
+		logger.Errorf("%v", err)
+		return
+	}
+
+	body := fmt.Sprintf("Someone asked to reset the password of your todo app account %s.\n\n"+
+		"Set a new one within %d minutes with\n\n"+
+		"    curl -XPUT -d '{\"password\": \"...\"}' %s/api/v1/password-resets/%s\n\n"+
+		"If it wasn't you, ignore this mail.\n",
+		name, appConfig.Accounts.ResetTokenMinutes, baseURL, token)
+	if err := sendMail(appConfig.Mail, user.Email, "Reset your todo app password", body); err != nil {
+		logger.Errorf("mail password reset of %s: %v", name, err)
+	}
+}
+
+// resetPasswordHandler sets a new password with a token of a reset mail and
+// ends all sessions of the account. Every token works once.
+func resetPasswordHandler(c *gin.Context) {
+	var request struct {
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	if err := checkPassword(request.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	kv := tododb.KVOf(database)
+	key := passwordResetKey(c.Param("token"))
+	// the token is taken in one step, so it resets the password only once
+	name, err := kv.TakeValue(key)
+	if err == tododb.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": "invalid or expired reset token",
+		})
+		return
+	}
+
+	var hash string
+	if err == nil {
+		var hasher password.Hasher
+		if hasher, err = passwordHasher(); err == nil {
+			hash, err = hasher.Hash(request.Password)
+		}
+	}
+	if err == nil {
+		err = tododb.UsersOf(database).UpdateUser(name, func(user *tododb.User) {
+			user.PasswordHash = hash
+		})
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	if err := revokeSessions(name); err != nil {
+		logger.Errorf("%v", err)
+	}
+
+	recordSecurityEvent(securityEvent{Type: eventPasswordReset, Account: name, IP: c.ClientIP(), Detail: "password set with the token of a reset mail"})
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+func TestAccountAuth(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+	server.createAccount("jane")
+	server.createAccount("bob")
+	server.expect(http.StatusOK, "POST", "/admin/accounts/bob/disable", asAdmin, nil, nil)
+
+	wrongPassword := func(req *http.Request) {
+		req.SetBasicAuth("jane", "wrong horse battery")
+	}
+	tests := []struct {
+		name      string
+		authorize authorization
+		want      int
+	}{
+		{name: "right password", authorize: asAccount("jane"), want: http.StatusOK},
+		{name: "name in capitals", authorize: asAccount("JANE"), want: http.StatusOK},
+		{name: "wrong password", authorize: wrongPassword, want: http.StatusUnauthorized},
+		{name: "unknown account", authorize: asAccount("alice"), want: http.StatusUnauthorized},
+		{name: "disabled account", authorize: asAccount("bob"), want: http.StatusUnauthorized},
+		{name: "no credentials", authorize: anonymous, want: http.StatusUnauthorized},
+		{name: "unknown session", authorize: withToken("0123abcd"), want: http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		recorder := server.serve("GET", "/api/v1/account", test.authorize, nil)
+		if recorder.Code != test.want {
+			t.Errorf("%s: answered %d, want %d", test.name, recorder.Code, test.want)
+		}
+	}
+
+	// a disabled account can't tell its password is right
+	disabled := server.serve("GET", "/api/v1/account", asAccount("bob"), nil).Body.String()
+	wrong := server.serve("GET", "/api/v1/account", wrongPassword, nil).Body.String()
+	if disabled != wrong {
+		t.Errorf("a disabled account is answered %s, a wrong password %s", disabled, wrong)
+	}
+}
+
+func TestAccountLockout(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+	server.createAccount("jane")
+
+	wrongPassword := func(req *http.Request) {
+		req.SetBasicAuth("jane", "wrong horse battery")
+	}
+	for i := 0; i < 5; i++ {
+		server.expect(http.StatusUnauthorized, "GET", "/api/v1/account", wrongPassword, nil, nil)
+	}
+	recorder := server.serve("GET", "/api/v1/account", asAccount("jane"), nil)
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") == "" {
+		t.Errorf("the right password after 5 failures answered %d, want 429 with Retry-After", recorder.Code)
+	}
+
+	server.expect(http.StatusNoContent, "DELETE", "/admin/accounts/jane/lockout", asAdmin, nil, nil)
+	server.expect(http.StatusOK, "GET", "/api/v1/account", asAccount("jane"), nil, nil)
+}
+
+func TestRegisterAccount(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+	request := gin.H{"name": "jane", "password": testPassword}
+
+	server.expect(http.StatusForbidden, "POST", "/api/v1/accounts", anonymous, request, nil)
+	appConfig.Accounts.OpenRegistration = true
+	server.expect(http.StatusBadRequest, "POST", "/api/v1/accounts", anonymous, gin.H{"name": "jane", "password": "short"}, nil)
+	server.expect(http.StatusBadRequest, "POST", "/api/v1/accounts", anonymous, gin.H{"name": "-jane", "password": testPassword}, nil)
+	server.expect(http.StatusCreated, "POST", "/api/v1/accounts", anonymous, request, nil)
+	server.expect(http.StatusConflict, "POST", "/api/v1/accounts", anonymous, gin.H{"name": "Jane", "password": testPassword}, nil)
+
+	var own account
+	server.expect(http.StatusOK, "GET", "/api/v1/account", asAccount("jane"), nil, &own)
+	if own.Name != "jane" || own.Disabled {
+		t.Errorf("the own account is %+v, want jane enabled", own)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+	server.createAccount("jane")
+	var tokens tokenResponse
+	server.expect(http.StatusCreated, "POST", "/api/v1/tokens", asAccount("jane"), nil, &tokens)
+	if err := tododb.KVOf(database).SetValue(passwordResetKey("reset-token"), "jane", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	server.expect(http.StatusBadRequest, "PUT", "/api/v1/password-resets/reset-token", anonymous, gin.H{"password": "short"}, nil)
+	server.expect(http.StatusNoContent, "PUT", "/api/v1/password-resets/reset-token", anonymous, gin.H{"password": "a new passphrase"}, nil)
+	server.expect(http.StatusNotFound, "PUT", "/api/v1/password-resets/reset-token", anonymous, gin.H{"password": "another passphrase"}, nil)
+
+	server.expect(http.StatusUnauthorized, "GET", "/api/v1/account", asAccount("jane"), nil, nil)
+	server.expect(http.StatusOK, "GET", "/api/v1/account", func(req *http.Request) {
+		req.SetBasicAuth("jane", "a new passphrase")
+	}, nil, nil)
+	// the reset signed the other devices out
+	server.expect(http.StatusUnauthorized, "GET", "/api/v1/account", withToken(tokens.AccessToken), nil, nil)
+}
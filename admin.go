@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminAuth protects the /admin endpoints with the AdminToken of the config,
+// sent as bearer token. Without a configured token the endpoints stay closed.
+func adminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if appConfig.AdminToken == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"errors": "admin endpoints are disabled, set AdminToken to enable them",
+			})
+			return
+		}
+
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(appConfig.AdminToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"errors": "invalid admin token",
+			})
+			return
+		}
+
+		c.Set(adminKey, true)
+		c.Next()
+	}
+}
+
+func deleteAllTodosHandler(c *gin.Context) {
+	if err := database.ReplaceAllTodos(c.Request.Context(), nil); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	publishChange(changeReset)
+
+	c.JSON(http.StatusOK, []string{})
+}
@@ -0,0 +1,118 @@
+// Package api defines the HTTP API of the todo app in one place, see
+// routes.go. The route registration and the handler stubs of the server, the
+// types of the request and response bodies with their validation and the Go
+// and TypeScript clients are generated from it by apigen:
+//
+//	go generate github.com/johscheuer/todo-app-web
+//
+// Add a route or change a schema here, never in the generated files.
+package api
+
+// Kind is the JSON type of a field.
+type Kind string
+
+const (
+	String Kind = "string"
+	Int    Kind = "int"
+	Bool   Kind = "bool"
+	// Time is an RFC 3339 string
+	Time Kind = "time"
+	// Object is another schema, named by Field.Schema
+	Object Kind = "object"
+	// Any takes every JSON value, e.g. a priority given as name or number
+	Any Kind = "any"
+)
+
+// Field is a member of a schema.
+type Field struct {
+	// Name is the JSON name, the Go name is derived from it
+	Name string
+	Kind Kind
+	// Schema names the schema of an Object field
+	Schema string
+	// List makes the field a list of its kind
+	List bool
+	// Required fields have to be set and not empty in requests
+	Required bool
+	// Optional fields are left out when not set, in requests they tell "not
+	// set" and "empty" apart
+	Optional bool
+	// OmitEmpty fields are left out when empty, like the tags of a todo
+	// without any
+	OmitEmpty bool
+	// Enum lists the allowed values of a string field
+	Enum []string
+	// Min is the smallest allowed value of an int field
+	Min *int
+	Doc string
+}
+
+// Schema is a JSON object of a request or response body.
+type Schema struct {
+	Name string
+	// Extends names a schema whose fields come first
+	Extends string
+	Fields  []Field
+	Doc     string
+}
+
+// Route is one operation of the API.
+type Route struct {
+	// Name is the name of the operation. The server handles it with
+	// <Name>Handler unless Handler is set, the clients with a method Name.
+	Name string
+	// Group is the middleware group of the route, see Middleware in the
+	// config
+	Group  string
+	Method string
+	// Path is the whole path with gin parameters like :id, they become
+	// arguments of the client methods in their order
+	Path string
+	// Handler is the Go expression of the handler if it isn't
+	// <Name>Handler
+	Handler string
+	// Middleware are Go expressions of handlers run before it
+	Middleware []string
+	// Request is the schema of the JSON body, it is validated before the
+	// handler runs
+	Request string
+	// Body is set for routes that take a JSON body without a schema, those
+	// with POST, PUT and PATCH always do
+	Body bool
+	// Response is the schema of the JSON answer, a list of them with
+	// ResponseList. Routes without one answer with JSON the clients return
+	// as it is.
+	Response     string
+	ResponseList bool
+	// Query lists the query parameters the clients can pass on
+	Query []string
+	// Paged routes answer with a page of their list for ?page= and
+	// ?per_page=, the Go client iterates over all pages
+	Paged bool
+	// Raw routes don't answer with JSON, e.g. HTML pages and downloads, the
+	// clients leave them out
+	Raw bool
+	Doc string
+}
+
+// Validator is implemented by the generated types of the schemas, Validate
+// checks a request body.
+type Validator interface {
+	Validate() error
+}
+
+// Min returns a pointer to min, for Field.Min.
+func Min(min int) *int {
+	return &min
+}
+
+// SchemaByName returns the schema called name.
+func SchemaByName(name string) (Schema, bool) {
+	for _, schema := range Schemas {
+		if schema.Name == name {
+			return schema, true
+		}
+	}
+
+	return Schema{}, false
+}
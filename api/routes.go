@@ -0,0 +1,344 @@
+package api
+
+// Schemas are the bodies of the requests and answers of the todo API.
+var Schemas = []Schema{
+	{
+		Name: "Todo",
+		Doc:  "Todo is a single entry of the list.",
+		Fields: []Field{
+			{Name: "id", Kind: String},
+			{Name: "title", Kind: String},
+			{Name: "description", Kind: String, OmitEmpty: true},
+			{Name: "createdAt", Kind: Time},
+			{Name: "updatedAt", Kind: Time},
+			{Name: "done", Kind: Bool},
+			{Name: "due", Kind: Time, Optional: true},
+			{Name: "priority", Kind: Int, OmitEmpty: true, Doc: "from 0 (none) to 9 (high)"},
+			{Name: "tags", Kind: String, List: true, OmitEmpty: true},
+			{Name: "subTasks", Kind: Object, Schema: "SubTask", List: true, OmitEmpty: true},
+			{Name: "recurrence", Kind: String, OmitEmpty: true, Doc: "daily, weekly or a cron expression"},
+			{Name: "attachments", Kind: Object, Schema: "Attachment", List: true, OmitEmpty: true},
+			{Name: "estimate", Kind: Int, OmitEmpty: true, Doc: "the expected effort in minutes"},
+			{Name: "remote", Kind: Object, Schema: "RemoteLink", List: true, OmitEmpty: true, Doc: "the issues the todo is mirrored to"},
+			{Name: "owner", Kind: String, OmitEmpty: true, Doc: "the account that created the todo, empty for todos everyone can see"},
+		},
+	},
+	{
+		Name: "RemoteLink",
+		Doc:  "RemoteLink points to the issue a todo is mirrored to.",
+		Fields: []Field{
+			{Name: "tracker", Kind: String, Doc: "like github:owner/repo or jira:PROJ"},
+			{Name: "id", Kind: String, Doc: "the issue number or key"},
+			{Name: "url", Kind: String, OmitEmpty: true},
+			{Name: "hash", Kind: String, OmitEmpty: true},
+			{Name: "syncedAt", Kind: Time},
+		},
+	},
+	{
+		Name: "Attachment",
+		Doc:  "Attachment describes a file attached to a todo.",
+		Fields: []Field{
+			{Name: "id", Kind: String},
+			{Name: "name", Kind: String},
+			{Name: "contentType", Kind: String},
+			{Name: "size", Kind: Int, Doc: "in bytes"},
+			{Name: "sha256", Kind: String, OmitEmpty: true, Doc: "the hex SHA-256 digest of the content, attachments with the same one share it"},
+			{Name: "scan", Kind: String, OmitEmpty: true, Doc: "clean or quarantined, empty if the upload wasn't scanned"},
+			{Name: "threat", Kind: String, OmitEmpty: true, Doc: "what the scan found in a quarantined attachment"},
+			{Name: "createdAt", Kind: Time},
+		},
+	},
+	{
+		Name: "SubTask",
+		Doc:  "SubTask is an item of the checklist of a todo.",
+		Fields: []Field{
+			{Name: "id", Kind: String},
+			{Name: "title", Kind: String},
+			{Name: "done", Kind: Bool},
+		},
+	},
+	{
+		Name: "TodoUpdate",
+		Doc:  "TodoUpdate holds the fields of a todo to change.",
+		Fields: []Field{
+			{Name: "title", Kind: String, Optional: true},
+			{Name: "description", Kind: String, Optional: true, Doc: "replace the description, empty removes it"},
+			{Name: "done", Kind: Bool, Optional: true},
+			{Name: "due", Kind: String, Optional: true, Doc: "a day or time, empty removes the due date"},
+			{Name: "priority", Kind: Any, Optional: true, Doc: "none, low, medium, high or 0 to 9"},
+			{Name: "tags", Kind: String, List: true, Optional: true, Doc: "replace the tags, empty removes them"},
+			{Name: "position", Kind: Int, Optional: true, Min: Min(0), Doc: "the new place in the whole list, counted from 0"},
+			{Name: "recurrence", Kind: String, Optional: true, Doc: "daily, weekly or a cron expression, empty ends the recurrence"},
+			{Name: "estimate", Kind: String, Optional: true, Doc: "the expected effort like 30m or 1h30m, empty removes the estimate"},
+		},
+	},
+	{
+		Name: "Tag",
+		Doc:  "Tag is a tag in use and the number of todos tagged with it.",
+		Fields: []Field{
+			{Name: "name", Kind: String},
+			{Name: "todos", Kind: Int},
+		},
+	},
+	{
+		Name: "NewSubTask",
+		Doc:  "NewSubTask adds an open sub-task to the end of a checklist.",
+		Fields: []Field{
+			{Name: "title", Kind: String, Required: true},
+		},
+	},
+	{
+		Name: "Grant",
+		Doc:  "Grant is the permission of an account on a single todo.",
+		Fields: []Field{
+			{Name: "account", Kind: String},
+			{Name: "permission", Kind: String, Enum: []string{"read", "write"}},
+		},
+	},
+	{
+		Name: "GrantRequest",
+		Doc:  "GrantRequest shares a todo with an account.",
+		Fields: []Field{
+			{Name: "permission", Kind: String, Required: true, Enum: []string{"read", "write"}},
+		},
+	},
+	{
+		Name:    "SharedTodo",
+		Extends: "Todo",
+		Doc:     "SharedTodo is a todo as seen by an account it is shared with.",
+		Fields: []Field{
+			{Name: "permission", Kind: String, Enum: []string{"read", "write"}},
+		},
+	},
+	{
+		Name: "SharedTodoUpdate",
+		Doc:  "SharedTodoUpdate changes a shared todo, with the write permission.",
+		Fields: []Field{
+			{Name: "title", Kind: String, Optional: true},
+			{Name: "done", Kind: Bool, Optional: true},
+		},
+	},
+	{
+		Name: "Draft",
+		Doc:  "Draft is an unsaved edit of a todo, the fields of the edit form.",
+		Fields: []Field{
+			{Name: "title", Kind: String, OmitEmpty: true},
+			{Name: "description", Kind: String, OmitEmpty: true},
+			{Name: "due", Kind: String, OmitEmpty: true},
+			{Name: "tags", Kind: String, List: true, OmitEmpty: true},
+			{Name: "savedAt", Kind: Time, Doc: "set by the server"},
+		},
+	},
+	{
+		Name:    "TrashedTodo",
+		Extends: "Todo",
+		Doc:     "TrashedTodo is a deleted todo in the trash, until it is restored or purged.",
+		Fields: []Field{
+			{Name: "deletedAt", Kind: Time},
+		},
+	},
+	{
+		Name: "ViewPreference",
+		Doc:  "ViewPreference is the view of the UI the account or the browser chose.",
+		Fields: []Field{
+			{Name: "view", Kind: String, Required: true, Enum: []string{"default", "basic", "high-contrast", "screen-reader"}},
+		},
+	},
+	{
+		Name: "LocalePreference",
+		Doc:  "LocalePreference is the locale the account or the browser sorts titles in.",
+		Fields: []Field{
+			{Name: "locale", Kind: String, Required: true, Doc: "a language tag like de or sv-FI"},
+		},
+	},
+	{
+		Name: "UndoResult",
+		Doc:  "UndoResult names the undone operation and has the todos it put back.",
+		Fields: []Field{
+			{Name: "undone", Kind: String, Enum: []string{"deleted", "updated", "bulk"}},
+			{Name: "at", Kind: Time},
+			{Name: "todos", Kind: Object, Schema: "Todo", List: true},
+		},
+	},
+	{
+		Name: "Tokens",
+		Doc:  "Tokens are an access token and the refresh token to get the next one.",
+		Fields: []Field{
+			{Name: "access_token", Kind: String},
+			{Name: "token_type", Kind: String},
+			{Name: "expires_in", Kind: Int, Doc: "seconds until the access token expires"},
+			{Name: "refresh_token", Kind: String},
+		},
+	},
+	{
+		Name: "RefreshTokens",
+		Doc:  "RefreshTokens exchanges a refresh token for new tokens.",
+		Fields: []Field{
+			{Name: "refresh_token", Kind: String, Required: true},
+		},
+	},
+	{
+		Name: "BulkTodosRequest",
+		Doc:  "BulkTodosRequest creates todos from their titles and completes and deletes todos by their ids.",
+		Fields: []Field{
+			{Name: "create", Kind: String, List: true, OmitEmpty: true},
+			{Name: "delete", Kind: String, List: true, OmitEmpty: true},
+			{Name: "complete", Kind: String, List: true, OmitEmpty: true},
+		},
+	},
+	{
+		Name: "BulkTodosResponse",
+		Doc:  "BulkTodosResponse has the created todos and the ids that had a todo to complete or delete.",
+		Fields: []Field{
+			{Name: "created", Kind: Object, Schema: "Todo", List: true},
+			{Name: "completed", Kind: String, List: true},
+			{Name: "deleted", Kind: String, List: true},
+		},
+	},
+}
+
+// Routes are all operations of the server, in the order they are
+// registered.
+var Routes = []Route{
+	// The list as the UI uses it
+	{Name: "readTodo", Group: "todo", Method: "GET", Path: "/todo", Doc: "returns the titles of the todos"},
+	{Name: "todoFragment", Group: "todo", Method: "GET", Path: "/todo/fragment", Raw: true},
+	{Name: "exportTodo", Group: "todo", Method: "GET", Path: "/todo/export", Raw: true},
+	{Name: "printTodo", Group: "todo", Method: "GET", Path: "/todo/print", Raw: true},
+	{Name: "basicTodo", Group: "todo", Method: "GET", Path: "/basic", Raw: true},
+	{Name: "basicTodoForm", Group: "todo", Method: "POST", Path: "/basic", Raw: true},
+	{Name: "importTodo", Group: "todo", Method: "POST", Path: "/import", Raw: true},
+	{Name: "insertTodo", Group: "todo", Method: "POST", Path: "/todo/:value", Doc: "adds a todo and returns the titles of the todos"},
+	{Name: "deleteTodo", Group: "todo", Method: "DELETE", Path: "/todo/:value", Doc: "deletes the first todo with the title and returns the titles of the todos"},
+
+	// Todos
+	{Name: "listTodos", Group: "todo", Method: "GET", Path: "/api/v1/todos", Response: "Todo", ResponseList: true, Query: []string{"status", "tag", "q", "sort", "locale", "page", "per_page"}, Paged: true},
+	{Name: "updateTodo", Group: "todo", Method: "PATCH", Path: "/api/v1/todos/:id", Request: "TodoUpdate", Response: "Todo"},
+	{Name: "deleteTodoByID", Group: "todo", Method: "DELETE", Path: "/api/v1/todos/:id"},
+	{Name: "listGrants", Group: "account", Method: "GET", Path: "/api/v1/todos/:id/grants", Response: "Grant", ResponseList: true},
+	{Name: "setGrant", Group: "account", Method: "PUT", Path: "/api/v1/todos/:id/grants/:account", Request: "GrantRequest", Response: "Grant"},
+	{Name: "deleteGrant", Group: "account", Method: "DELETE", Path: "/api/v1/todos/:id/grants/:account"},
+	{Name: "listTags", Group: "todo", Method: "GET", Path: "/api/v1/tags", Response: "Tag", ResponseList: true},
+	{Name: "changes", Group: "todo", Method: "GET", Path: "/api/v1/changes", Query: []string{"list", "since", "timeout", "client"}},
+	// ":stream" and ":bulk" behind /api/v1/todos
+	{Name: "todoAction", Group: "todo", Method: "POST", Path: "/api/v1/todos:action", Raw: true},
+	{Name: "bulkTodos", Group: "todo", Method: "POST", Path: "/api/todos/bulk", Request: "BulkTodosRequest", Response: "BulkTodosResponse"},
+	{Name: "undo", Group: "account", Method: "POST", Path: "/api/undo", Response: "UndoResult", Doc: "reverts the latest delete, update or bulk operation of the account"},
+	// Not below /api/v1/todos/:id, POST /api/v1/todos:action takes that path
+	{Name: "listSubTasks", Group: "todo", Method: "GET", Path: "/api/v1/checklists/:id", Response: "SubTask", ResponseList: true},
+	{Name: "addSubTask", Group: "todo", Method: "POST", Path: "/api/v1/checklists/:id", Request: "NewSubTask", Response: "SubTask"},
+	{Name: "completeSubTask", Group: "todo", Method: "POST", Path: "/api/v1/checklists/:id/:subtask/complete"},
+	{Name: "listAttachments", Group: "todo", Method: "GET", Path: "/api/v1/attachments/:id", Response: "Attachment", ResponseList: true},
+	{Name: "uploadAttachment", Group: "todo", Method: "POST", Path: "/api/v1/attachments/:id", Raw: true, Doc: "attaches the multipart field file to a todo"},
+	{Name: "downloadAttachment", Group: "todo", Method: "GET", Path: "/api/v1/attachments/:id/:attachment", Raw: true},
+	{Name: "deleteAttachment", Group: "todo", Method: "DELETE", Path: "/api/v1/attachments/:id/:attachment"},
+	{Name: "thumbnail", Group: "todo", Method: "GET", Path: "/thumb/:id", Raw: true, Doc: "the PNG thumbnail of an image attachment"},
+	{Name: "getDraft", Group: "todo", Method: "GET", Path: "/api/v1/drafts/:id", Response: "Draft", Doc: "returns the unsaved edit of a todo"},
+	{Name: "saveDraft", Group: "todo", Method: "PUT", Path: "/api/v1/drafts/:id", Request: "Draft", Response: "Draft"},
+	{Name: "deleteDraft", Group: "todo", Method: "DELETE", Path: "/api/v1/drafts/:id"},
+	{Name: "getView", Group: "todo", Method: "GET", Path: "/api/v1/view", Response: "ViewPreference", Doc: "returns the view of the UI the account or the browser chose"},
+	{Name: "setView", Group: "todo", Method: "PUT", Path: "/api/v1/view", Request: "ViewPreference", Response: "ViewPreference"},
+	{Name: "getLocale", Group: "todo", Method: "GET", Path: "/api/v1/locale", Response: "LocalePreference", Doc: "returns the locale titles are sorted in with ?sort=title"},
+	{Name: "setLocale", Group: "todo", Method: "PUT", Path: "/api/v1/locale", Request: "LocalePreference", Response: "LocalePreference"},
+	{Name: "listTrash", Group: "todo", Method: "GET", Path: "/api/v1/trash", Response: "TrashedTodo", ResponseList: true, Doc: "returns the deleted todos of the account, the last deleted first"},
+	{Name: "restoreTodo", Group: "todo", Method: "POST", Path: "/api/v1/trash/:id/restore", Response: "Todo", Doc: "adds a deleted todo to the end of the list again"},
+
+	// Sharing and links
+	{Name: "createShare", Group: "todo", Method: "POST", Path: "/share", Query: []string{"hours"}},
+	{Name: "shareInfo", Group: "todo", Method: "GET", Path: "/share/:id"},
+	{Name: "revokeShare", Group: "todo", Method: "DELETE", Path: "/share/:id"},
+	{Name: "sharedList", Group: "public", Method: "GET", Path: "/shared/:id", Raw: true},
+	{Name: "createShortLink", Group: "todo", Method: "POST", Path: "/links"},
+	{Name: "shortLink", Group: "todo", Method: "GET", Path: "/links/:code"},
+	{Name: "deleteShortLink", Group: "todo", Method: "DELETE", Path: "/links/:code"},
+	{Name: "resolveShortLink", Group: "public", Method: "GET", Path: "/s/:code", Raw: true},
+	{Name: "publicBoard", Group: "public", Method: "GET", Path: "/board", Raw: true},
+	{Name: "embedTodo", Group: "public", Method: "GET", Path: "/embed/:list", Raw: true},
+
+	// Views of the todos
+	{Name: "listSmartLists", Group: "todo", Method: "GET", Path: "/api/v1/smartlists"},
+	{Name: "setSmartList", Group: "todo", Method: "PUT", Path: "/api/v1/smartlists/:id"},
+	{Name: "deleteSmartList", Group: "todo", Method: "DELETE", Path: "/api/v1/smartlists/:id"},
+	{Name: "smartListTodos", Group: "todo", Method: "GET", Path: "/api/v1/smartlists/:id/todos"},
+	{Name: "dependencyGraph", Group: "todo", Method: "GET", Path: "/api/v1/dependencies"},
+	{Name: "addDependency", Group: "todo", Method: "PUT", Path: "/api/v1/dependencies"},
+	{Name: "deleteDependency", Group: "todo", Method: "DELETE", Path: "/api/v1/dependencies", Body: true},
+	{Name: "stats", Group: "todo", Method: "GET", Path: "/api/v1/stats"},
+	{Name: "workload", Group: "todo", Method: "GET", Path: "/api/v1/workload"},
+	{Name: "calendar", Group: "todo", Method: "GET", Path: "/api/v1/calendar"},
+	{Name: "overdueTodos", Group: "todo", Method: "GET", Path: "/api/v1/overdue", Response: "Todo", ResponseList: true},
+	{Name: "dueTodos", Group: "todo", Method: "GET", Path: "/api/v1/due", Response: "Todo", ResponseList: true, Query: []string{"before"}},
+	{Name: "listTimers", Group: "todo", Method: "GET", Path: "/api/v1/timers"},
+	{Name: "startTimer", Group: "todo", Method: "PUT", Path: "/api/v1/timers"},
+	{Name: "stopTimer", Group: "todo", Method: "DELETE", Path: "/api/v1/timers", Body: true},
+
+	// Integrations
+	{Name: "newTodoTrigger", Group: "integrations", Method: "GET", Path: "/api/v1/integrations/triggers/new-todo", Query: []string{"cursor", "limit"}},
+	{Name: "completedTodoTrigger", Group: "integrations", Method: "GET", Path: "/api/v1/integrations/triggers/completed-todo", Query: []string{"cursor", "limit"}},
+	{Name: "createTodoAction", Group: "integrations", Method: "POST", Path: "/api/v1/integrations/actions/create-todo"},
+
+	// Administration
+	{Name: "deleteAllTodos", Group: "admin", Method: "DELETE", Path: "/admin/todos", Middleware: []string{"forbidInDemoMode()"}},
+	{Name: "purgeTrash", Group: "admin", Method: "DELETE", Path: "/admin/trash", Query: []string{"days"}, Doc: "empties the trash of every account from the todos deleted more than days ago"},
+	{Name: "seedProfiles", Group: "admin", Method: "GET", Path: "/admin/seed"},
+	{Name: "seed", Group: "admin", Method: "POST", Path: "/admin/seed"},
+	{Name: "listFeatures", Group: "admin", Method: "GET", Path: "/admin/features"},
+	{Name: "setFeature", Group: "admin", Method: "PUT", Path: "/admin/features/:name"},
+	{Name: "logs", Group: "admin", Method: "GET", Path: "/admin/logs"},
+	{Name: "getLogLevel", Group: "admin", Method: "GET", Path: "/admin/loglevel"},
+	{Name: "setLogLevel", Group: "admin", Method: "PUT", Path: "/admin/loglevel"},
+	{Name: "slowLog", Group: "admin", Method: "GET", Path: "/admin/slowlog"},
+	{Name: "failoverDrill", Group: "admin", Method: "POST", Path: "/admin/drills/failover", Middleware: []string{"forbidInDemoMode()"}},
+	{Name: "getBoard", Group: "admin", Method: "GET", Path: "/admin/board"},
+	{Name: "setBoard", Group: "admin", Method: "PUT", Path: "/admin/board"},
+	{Name: "previewDigest", Group: "admin", Method: "GET", Path: "/admin/digest", Raw: true},
+	{Name: "sendDigest", Group: "admin", Method: "POST", Path: "/admin/digest"},
+	{Name: "telemetryPreview", Group: "admin", Method: "GET", Path: "/admin/telemetry"},
+	{Name: "sbom", Group: "admin", Method: "GET", Path: "/admin/sbom"},
+	{Name: "createAccount", Group: "admin", Method: "POST", Path: "/admin/accounts"},
+	{Name: "getAccount", Group: "admin", Method: "GET", Path: "/admin/accounts/:name"},
+	{Name: "disableAccount", Group: "admin", Method: "POST", Path: "/admin/accounts/:name/disable", Handler: "setAccountDisabledHandler(true)"},
+	{Name: "enableAccount", Group: "admin", Method: "POST", Path: "/admin/accounts/:name/enable", Handler: "setAccountDisabledHandler(false)"},
+	{Name: "resetTwoFactor", Group: "admin", Method: "DELETE", Path: "/admin/accounts/:name/2fa"},
+	{Name: "accountSessions", Group: "admin", Method: "GET", Path: "/admin/accounts/:name/sessions"},
+	{Name: "revokeAccountSessions", Group: "admin", Method: "DELETE", Path: "/admin/accounts/:name/sessions"},
+	{Name: "unlockAccount", Group: "admin", Method: "DELETE", Path: "/admin/accounts/:name/lockout"},
+	{Name: "unlockIP", Group: "admin", Method: "DELETE", Path: "/admin/lockouts/:ip"},
+	{Name: "auditLog", Group: "admin", Method: "GET", Path: "/admin/audit"},
+	{Name: "rotateSigningKey", Group: "admin", Method: "POST", Path: "/admin/jwt/rotate"},
+	{Name: "listHooks", Group: "admin", Method: "GET", Path: "/admin/hooks"},
+	{Name: "uploadHook", Group: "admin", Method: "PUT", Path: "/admin/hooks/:name", Query: []string{"events"}, Raw: true, Doc: "stores the WebAssembly module in the body as a hook on the events"},
+	{Name: "deleteHook", Group: "admin", Method: "DELETE", Path: "/admin/hooks/:name"},
+
+	// Accounts, without being signed in
+	{Name: "registerAccount", Group: "accounts", Method: "POST", Path: "/api/v1/accounts"},
+	{Name: "requestPasswordReset", Group: "accounts", Method: "POST", Path: "/api/v1/password-resets"},
+	{Name: "resetPassword", Group: "accounts", Method: "PUT", Path: "/api/v1/password-resets/:token"},
+	{Name: "unlockWithToken", Group: "accounts", Method: "POST", Path: "/api/v1/unlocks/:token"},
+	{Name: "refreshTokens", Group: "accounts", Method: "POST", Path: "/api/v1/tokens/refresh", Request: "RefreshTokens", Response: "Tokens"},
+
+	// The signed in account
+	{Name: "ownAccount", Group: "account", Method: "GET", Path: "/api/v1/account"},
+	{Name: "accountUsage", Group: "account", Method: "GET", Path: "/api/v1/account/usage", Doc: "reports the number and size of the todos of the account"},
+	{Name: "enrollTwoFactor", Group: "account", Method: "POST", Path: "/api/v1/account/2fa"},
+	{Name: "confirmTwoFactor", Group: "account", Method: "POST", Path: "/api/v1/account/2fa/confirm"},
+	{Name: "newRecoveryCodes", Group: "account", Method: "POST", Path: "/api/v1/account/2fa/recovery-codes"},
+	{Name: "disableTwoFactor", Group: "account", Method: "DELETE", Path: "/api/v1/account/2fa"},
+	{Name: "createSession", Group: "account", Method: "POST", Path: "/api/v1/sessions"},
+	{Name: "listSessions", Group: "account", Method: "GET", Path: "/api/v1/sessions"},
+	{Name: "revokeSessions", Group: "account", Method: "DELETE", Path: "/api/v1/sessions"},
+	{Name: "revokeSession", Group: "account", Method: "DELETE", Path: "/api/v1/sessions/:id"},
+	{Name: "createTokens", Group: "account", Method: "POST", Path: "/api/v1/tokens", Response: "Tokens"},
+	{Name: "listSharedTodos", Group: "account", Method: "GET", Path: "/api/v1/granted/todos", Response: "SharedTodo", ResponseList: true},
+	{Name: "getSharedTodo", Group: "account", Method: "GET", Path: "/api/v1/granted/todos/:id", Response: "SharedTodo"},
+	{Name: "updateSharedTodo", Group: "account", Method: "PATCH", Path: "/api/v1/granted/todos/:id", Request: "SharedTodoUpdate", Response: "SharedTodo"},
+
+	// Operations
+	{Name: "usage", Group: "ops", Method: "GET", Path: "/usage"},
+	{Name: "healthCheck", Group: "ops", Method: "GET", Path: "/health"},
+	{Name: "whoAmI", Group: "ops", Method: "GET", Path: "/whoami"},
+	{Name: "version", Group: "ops", Method: "GET", Path: "/version"},
+	{Name: "qr", Group: "ops", Method: "GET", Path: "/qr", Raw: true},
+	{Name: "jwks", Group: "ops", Method: "GET", Path: "/.well-known/jwks.json"},
+	{Name: "self", Group: "ops", Method: "GET", Path: "/api/v1/debug/self"},
+}
@@ -0,0 +1,336 @@
+// Code generated by apigen from package api. DO NOT EDIT.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Todo is a single entry of the list.
+type Todo struct {
+	ID          string     `json:"id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	Done        bool       `json:"done"`
+	Due         *time.Time `json:"due,omitempty"`
+	// from 0 (none) to 9 (high)
+	Priority int       `json:"priority,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+	SubTasks []SubTask `json:"subTasks,omitempty"`
+	// daily, weekly or a cron expression
+	Recurrence  string       `json:"recurrence,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// the expected effort in minutes
+	Estimate int `json:"estimate,omitempty"`
+	// the issues the todo is mirrored to
+	Remote []RemoteLink `json:"remote,omitempty"`
+	// the account that created the todo, empty for todos everyone can see
+	Owner string `json:"owner,omitempty"`
+}
+
+// Validate checks a Todo request body.
+func (body *Todo) Validate() error {
+	return nil
+}
+
+// RemoteLink points to the issue a todo is mirrored to.
+type RemoteLink struct {
+	// like github:owner/repo or jira:PROJ
+	Tracker string `json:"tracker"`
+	// the issue number or key
+	ID       string    `json:"id"`
+	URL      string    `json:"url,omitempty"`
+	Hash     string    `json:"hash,omitempty"`
+	SyncedAt time.Time `json:"syncedAt"`
+}
+
+// Validate checks a RemoteLink request body.
+func (body *RemoteLink) Validate() error {
+	return nil
+}
+
+// Attachment describes a file attached to a todo.
+type Attachment struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	// in bytes
+	Size int `json:"size"`
+	// the hex SHA-256 digest of the content, attachments with the same one
+	// share it
+	Sha256 string `json:"sha256,omitempty"`
+	// clean or quarantined, empty if the upload wasn't scanned
+	Scan string `json:"scan,omitempty"`
+	// what the scan found in a quarantined attachment
+	Threat    string    `json:"threat,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Validate checks a Attachment request body.
+func (body *Attachment) Validate() error {
+	return nil
+}
+
+// SubTask is an item of the checklist of a todo.
+type SubTask struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Done  bool   `json:"done"`
+}
+
+// Validate checks a SubTask request body.
+func (body *SubTask) Validate() error {
+	return nil
+}
+
+// TodoUpdate holds the fields of a todo to change.
+type TodoUpdate struct {
+	Title *string `json:"title,omitempty"`
+	// replace the description, empty removes it
+	Description *string `json:"description,omitempty"`
+	Done        *bool   `json:"done,omitempty"`
+	// a day or time, empty removes the due date
+	Due *string `json:"due,omitempty"`
+	// none, low, medium, high or 0 to 9
+	Priority interface{} `json:"priority,omitempty"`
+	// replace the tags, empty removes them
+	Tags *[]string `json:"tags,omitempty"`
+	// the new place in the whole list, counted from 0
+	Position *int `json:"position,omitempty"`
+	// daily, weekly or a cron expression, empty ends the recurrence
+	Recurrence *string `json:"recurrence,omitempty"`
+	// the expected effort like 30m or 1h30m, empty removes the estimate
+	Estimate *string `json:"estimate,omitempty"`
+}
+
+// Validate checks a TodoUpdate request body.
+func (body *TodoUpdate) Validate() error {
+	if body.Position != nil {
+		if *body.Position < 0 {
+			return errors.New("position must not be negative")
+		}
+	}
+
+	return nil
+}
+
+// Tag is a tag in use and the number of todos tagged with it.
+type Tag struct {
+	Name  string `json:"name"`
+	Todos int    `json:"todos"`
+}
+
+// Validate checks a Tag request body.
+func (body *Tag) Validate() error {
+	return nil
+}
+
+// NewSubTask adds an open sub-task to the end of a checklist.
+type NewSubTask struct {
+	Title string `json:"title"`
+}
+
+// Validate checks a NewSubTask request body.
+func (body *NewSubTask) Validate() error {
+	if strings.TrimSpace(body.Title) == "" {
+		return errors.New("title must not be empty")
+	}
+
+	return nil
+}
+
+// Grant is the permission of an account on a single todo.
+type Grant struct {
+	Account    string `json:"account"`
+	Permission string `json:"permission"`
+}
+
+// Validate checks a Grant request body.
+func (body *Grant) Validate() error {
+	switch body.Permission {
+	case "read", "write":
+	default:
+		return fmt.Errorf("unknown permission %q, use read or write", body.Permission)
+	}
+
+	return nil
+}
+
+// GrantRequest shares a todo with an account.
+type GrantRequest struct {
+	Permission string `json:"permission"`
+}
+
+// Validate checks a GrantRequest request body.
+func (body *GrantRequest) Validate() error {
+	switch body.Permission {
+	case "read", "write":
+	default:
+		return fmt.Errorf("unknown permission %q, use read or write", body.Permission)
+	}
+
+	return nil
+}
+
+// SharedTodo is a todo as seen by an account it is shared with.
+type SharedTodo struct {
+	Todo
+	Permission string `json:"permission"`
+}
+
+// Validate checks a SharedTodo request body.
+func (body *SharedTodo) Validate() error {
+	switch body.Permission {
+	case "read", "write":
+	default:
+		return fmt.Errorf("unknown permission %q, use read or write", body.Permission)
+	}
+
+	return nil
+}
+
+// SharedTodoUpdate changes a shared todo, with the write permission.
+type SharedTodoUpdate struct {
+	Title *string `json:"title,omitempty"`
+	Done  *bool   `json:"done,omitempty"`
+}
+
+// Validate checks a SharedTodoUpdate request body.
+func (body *SharedTodoUpdate) Validate() error {
+	return nil
+}
+
+// Draft is an unsaved edit of a todo, the fields of the edit form.
+type Draft struct {
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Due         string   `json:"due,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	// set by the server
+	SavedAt time.Time `json:"savedAt"`
+}
+
+// Validate checks a Draft request body.
+func (body *Draft) Validate() error {
+	return nil
+}
+
+// TrashedTodo is a deleted todo in the trash, until it is restored or
+// purged.
+type TrashedTodo struct {
+	Todo
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// Validate checks a TrashedTodo request body.
+func (body *TrashedTodo) Validate() error {
+	return nil
+}
+
+// ViewPreference is the view of the UI the account or the browser chose.
+type ViewPreference struct {
+	View string `json:"view"`
+}
+
+// Validate checks a ViewPreference request body.
+func (body *ViewPreference) Validate() error {
+	switch body.View {
+	case "default", "basic", "high-contrast", "screen-reader":
+	default:
+		return fmt.Errorf("unknown view %q, use default, basic, high-contrast or screen-reader", body.View)
+	}
+
+	return nil
+}
+
+// LocalePreference is the locale the account or the browser sorts titles in.
+type LocalePreference struct {
+	// a language tag like de or sv-FI
+	Locale string `json:"locale"`
+}
+
+// Validate checks a LocalePreference request body.
+func (body *LocalePreference) Validate() error {
+	if strings.TrimSpace(body.Locale) == "" {
+		return errors.New("locale must not be empty")
+	}
+
+	return nil
+}
+
+// UndoResult names the undone operation and has the todos it put back.
+type UndoResult struct {
+	Undone string    `json:"undone"`
+	At     time.Time `json:"at"`
+	Todos  []Todo    `json:"todos"`
+}
+
+// Validate checks a UndoResult request body.
+func (body *UndoResult) Validate() error {
+	switch body.Undone {
+	case "deleted", "updated", "bulk":
+	default:
+		return fmt.Errorf("unknown undone %q, use deleted, updated or bulk", body.Undone)
+	}
+
+	return nil
+}
+
+// Tokens are an access token and the refresh token to get the next one.
+type Tokens struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	// seconds until the access token expires
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Validate checks a Tokens request body.
+func (body *Tokens) Validate() error {
+	return nil
+}
+
+// RefreshTokens exchanges a refresh token for new tokens.
+type RefreshTokens struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Validate checks a RefreshTokens request body.
+func (body *RefreshTokens) Validate() error {
+	if strings.TrimSpace(body.RefreshToken) == "" {
+		return errors.New("refresh_token must not be empty")
+	}
+
+	return nil
+}
+
+// BulkTodosRequest creates todos from their titles and completes and deletes
+// todos by their ids.
+type BulkTodosRequest struct {
+	Create   []string `json:"create,omitempty"`
+	Delete   []string `json:"delete,omitempty"`
+	Complete []string `json:"complete,omitempty"`
+}
+
+// Validate checks a BulkTodosRequest request body.
+func (body *BulkTodosRequest) Validate() error {
+	return nil
+}
+
+// BulkTodosResponse has the created todos and the ids that had a todo to
+// complete or delete.
+type BulkTodosResponse struct {
+	Created   []Todo   `json:"created"`
+	Completed []string `json:"completed"`
+	Deleted   []string `json:"deleted"`
+}
+
+// Validate checks a BulkTodosResponse request body.
+func (body *BulkTodosResponse) Validate() error {
+	return nil
+}
@@ -0,0 +1,691 @@
+// apigen generates the code of the todo API from its definition in package
+// api: the route registration and the stubs of missing handlers of the
+// server, the types of the bodies with their validation and the Go and
+// TypeScript clients. It is run by go generate in the root of the module.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/johscheuer/todo-app-web/api"
+)
+
+const (
+	generatedHeader = "Code generated by apigen from package api. DO NOT EDIT."
+
+	routesFile = "routes_gen.go"
+	stubsFile  = "stubs_gen.go"
+	typesFile  = "api/types_gen.go"
+	clientFile = "client/client_gen.go"
+	tsFile     = "client/todoapp.ts"
+)
+
+// pathParam matches the gin parameters of a path.
+var pathParam = regexp.MustCompile(`:(\w+)`)
+
+// initialisms are written in capitals in Go names.
+var initialisms = map[string]string{"id": "ID", "ip": "IP", "url": "URL"}
+
+func main() {
+	root := flag.String("root", ".", "root of the module")
+	flag.Parse()
+
+	if err := check(); err != nil {
+		log.Fatal(err)
+	}
+
+	handlers, err := declaredFuncs(*root)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		routesFile: goSource(routes()),
+		stubsFile:  goSource(stubs(handlers)),
+		typesFile:  goSource(types()),
+		clientFile: goSource(client()),
+		tsFile:     []byte(typeScript()),
+	}
+	for name, content := range files {
+		path := filepath.Join(*root, name)
+		if content == nil {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				log.Fatal(err)
+			}
+			continue
+		}
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// check makes sure the definition is complete, the generated code would not
+// compile otherwise.
+func check() error {
+	names := map[string]bool{}
+	for _, route := range api.Routes {
+		if route.Name == "" || route.Group == "" || route.Method == "" || route.Path == "" {
+			return fmt.Errorf("route %s %s needs a name, group, method and path", route.Method, route.Path)
+		}
+		if names[route.Name] {
+			return fmt.Errorf("route %s is defined twice", route.Name)
+		}
+		names[route.Name] = true
+
+		if route.Paged && (route.Response == "" || !route.ResponseList) {
+			return fmt.Errorf("route %s is paged, it needs a response list", route.Name)
+		}
+		for _, schema := range []string{route.Request, route.Response} {
+			if _, found := api.SchemaByName(schema); schema != "" && !found {
+				return fmt.Errorf("route %s: unknown schema %s", route.Name, schema)
+			}
+		}
+	}
+
+	for _, schema := range api.Schemas {
+		if _, found := api.SchemaByName(schema.Extends); schema.Extends != "" && !found {
+			return fmt.Errorf("schema %s: unknown schema %s", schema.Name, schema.Extends)
+		}
+		for _, field := range schema.Fields {
+			if _, found := api.SchemaByName(field.Schema); field.Kind == api.Object && !found {
+				return fmt.Errorf("schema %s: field %s: unknown schema %q", schema.Name, field.Name, field.Schema)
+			}
+		}
+	}
+
+	return nil
+}
+
+// declaredFuncs returns the functions of package main, except the stubs of
+// an earlier run.
+func declaredFuncs(root string) (map[string]bool, error) {
+	funcs := map[string]bool{}
+	packages, err := parser.ParseDir(token.NewFileSet(), root, func(info os.FileInfo) bool {
+		return info.Name() != stubsFile && !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range packages["main"].Files {
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil {
+				funcs[fn.Name.Name] = true
+			}
+		}
+	}
+
+	return funcs, nil
+}
+
+// goSource formats the generated Go code, nil stays nil.
+func goSource(source []byte) []byte {
+	if source == nil {
+		return nil
+	}
+
+	formatted, err := format.Source(source)
+	if err != nil {
+		log.Fatalf("generated invalid code: %v\n%s", err, source)
+	}
+
+	return formatted
+}
+
+func handlerOf(route api.Route) string {
+	if route.Handler != "" {
+		return route.Handler
+	}
+
+	return route.Name + "Handler"
+}
+
+// routes generates registerRoutes of the server.
+func routes() []byte {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// %s\n\npackage main\n\n", generatedHeader)
+	fmt.Fprintf(&out, "import (\n\t\"github.com/gin-gonic/gin\"\n\t\"github.com/johscheuer/todo-app-web/api\"\n)\n\n")
+	fmt.Fprintf(&out, "// registerRoutes registers the routes of api.Routes with the middleware\n// of their groups. A route with a request schema validates the body first.\n")
+	fmt.Fprintf(&out, "func registerRoutes(router *gin.Engine, middleware map[string][]gin.HandlerFunc) {\n")
+
+	var groups []string
+	for _, route := range api.Routes {
+		if !contains(groups, route.Group) {
+			groups = append(groups, route.Group)
+			fmt.Fprintf(&out, "\t%s := router.Group(\"/\", middleware[%q]...)\n", groupVar(route.Group), route.Group)
+		}
+	}
+	out.WriteString("\n")
+
+	for _, route := range api.Routes {
+		handlers := append([]string{}, route.Middleware...)
+		if route.Request != "" {
+			handlers = append(handlers, fmt.Sprintf("validateBody(func() api.Validator { return &api.%s{} })", route.Request))
+		}
+		handlers = append(handlers, handlerOf(route))
+		fmt.Fprintf(&out, "\t%s.%s(%q, %s)\n", groupVar(route.Group), route.Method, route.Path, strings.Join(handlers, ", "))
+	}
+	out.WriteString("}\n")
+
+	return out.Bytes()
+}
+
+func groupVar(group string) string {
+	return group + "Routes"
+}
+
+// stubs generates handlers for the routes without one yet, they answer with
+// 501 until they are written. There is no file if every route has one.
+func stubs(declared map[string]bool) []byte {
+	var missing []api.Route
+	for _, route := range api.Routes {
+		if route.Handler == "" && !declared[handlerOf(route)] {
+			missing = append(missing, route)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// %s\n\npackage main\n\n", generatedHeader)
+	fmt.Fprintf(&out, "import (\n\t\"net/http\"\n\n\t\"github.com/gin-gonic/gin\"\n)\n\n")
+	for _, route := range missing {
+		fmt.Fprintf(&out, "// %s serves %s %s. Write it in another file, the stub is\n// dropped by the next go generate.\n", handlerOf(route), route.Method, route.Path)
+		fmt.Fprintf(&out, "func %s(c *gin.Context) {\n", handlerOf(route))
+		fmt.Fprintf(&out, "\tc.JSON(http.StatusNotImplemented, gin.H{\n\t\t\"errors\": %q,\n\t})\n}\n\n", route.Name+" is not implemented yet")
+	}
+
+	return out.Bytes()
+}
+
+// goName returns the exported Go name of a JSON name, in camel case or with
+// underscores.
+func goName(name string) string {
+	if strings.Contains(name, "_") {
+		var parts []string
+		for _, part := range strings.Split(name, "_") {
+			parts = append(parts, goName(part))
+		}
+		return strings.Join(parts, "")
+	}
+
+	if initialism, found := initialisms[name]; found {
+		return initialism
+	}
+
+	for suffix, initialism := range initialisms {
+		capitalized := strings.ToUpper(suffix[:1]) + suffix[1:]
+		if strings.HasSuffix(name, capitalized) {
+			name = strings.TrimSuffix(name, capitalized) + initialism
+		}
+	}
+
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func goType(field api.Field) string {
+	var typ string
+	switch field.Kind {
+	case api.String:
+		typ = "string"
+	case api.Int:
+		typ = "int"
+	case api.Bool:
+		typ = "bool"
+	case api.Time:
+		typ = "time.Time"
+	case api.Object:
+		typ = field.Schema
+	case api.Any:
+		typ = "interface{}"
+	default:
+		log.Fatalf("unknown kind %q of field %s", field.Kind, field.Name)
+	}
+
+	if field.List {
+		typ = "[]" + typ
+	}
+	if field.Optional && field.Kind != api.Any {
+		return "*" + typ
+	}
+
+	return typ
+}
+
+// types generates the types of the schemas and their Validate methods.
+func types() []byte {
+	var body bytes.Buffer
+	imports := map[string]bool{}
+	for _, schema := range api.Schemas {
+		if schema.Doc != "" {
+			fmt.Fprintf(&body, "%s\n", comment(schema.Doc, ""))
+		}
+		fmt.Fprintf(&body, "type %s struct {\n", schema.Name)
+		if schema.Extends != "" {
+			fmt.Fprintf(&body, "\t%s\n", schema.Extends)
+		}
+		for _, field := range schema.Fields {
+			tag := field.Name
+			if field.Optional || field.OmitEmpty {
+				tag += ",omitempty"
+			}
+			if field.Doc != "" {
+				fmt.Fprintf(&body, "%s\n", comment(field.Doc, "\t"))
+			}
+			fmt.Fprintf(&body, "\t%s %s `json:%q`\n", goName(field.Name), goType(field), tag)
+			imports["time"] = imports["time"] || field.Kind == api.Time
+		}
+		body.WriteString("}\n\n")
+
+		fmt.Fprintf(&body, "// Validate checks a %s request body.\n", schema.Name)
+		fmt.Fprintf(&body, "func (body *%s) Validate() error {\n", schema.Name)
+		checks := body.Len()
+		for _, field := range schema.Fields {
+			value := "body." + goName(field.Name)
+			// An enum doesn't allow empty values anyway
+			if empty := emptyCheck(field, value); field.Required && empty != "" && len(field.Enum) == 0 {
+				fmt.Fprintf(&body, "\tif %s {\n\t\treturn errors.New(%q)\n\t}\n", empty, field.Name+" must not be empty")
+				imports["errors"] = true
+				imports["strings"] = imports["strings"] || strings.HasPrefix(empty, "strings.")
+			}
+			if field.List || len(field.Enum) == 0 && field.Min == nil {
+				continue
+			}
+
+			if field.Optional {
+				fmt.Fprintf(&body, "\tif %s != nil {\n", value)
+				value = "*" + value
+			}
+			if len(field.Enum) > 0 {
+				var cases []string
+				for _, allowed := range field.Enum {
+					cases = append(cases, fmt.Sprintf("%q", allowed))
+				}
+				fmt.Fprintf(&body, "\tswitch %s {\n\tcase %s:\n\tdefault:\n", value, strings.Join(cases, ", "))
+				fmt.Fprintf(&body, "\t\treturn fmt.Errorf(\"unknown %s %%q, use %s\", %s)\n\t}\n", field.Name, orList(field.Enum), value)
+				imports["fmt"] = true
+			}
+			if field.Min != nil {
+				message := fmt.Sprintf("%s must be at least %d", field.Name, *field.Min)
+				if *field.Min == 0 {
+					message = field.Name + " must not be negative"
+				}
+				fmt.Fprintf(&body, "\tif %s < %d {\n\t\treturn errors.New(%q)\n\t}\n", value, *field.Min, message)
+				imports["errors"] = true
+			}
+			if field.Optional {
+				body.WriteString("\t}\n")
+			}
+		}
+		if body.Len() > checks {
+			body.WriteString("\n")
+		}
+		body.WriteString("\treturn nil\n}\n\n")
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// %s\n\npackage api\n\n", generatedHeader)
+	writeImports(&out, imports)
+	out.Write(body.Bytes())
+
+	return out.Bytes()
+}
+
+// emptyCheck returns the condition of an empty field, the empty string if
+// it can't be told, like for a number.
+func emptyCheck(field api.Field, value string) string {
+	switch {
+	case field.Optional || field.Kind == api.Any:
+		return value + " == nil"
+	case field.List:
+		return fmt.Sprintf("len(%s) == 0", value)
+	case field.Kind == api.String:
+		return fmt.Sprintf("strings.TrimSpace(%s) == \"\"", value)
+	}
+
+	return ""
+}
+
+// writeImports writes the import declaration of the used packages.
+func writeImports(out *bytes.Buffer, imports map[string]bool) {
+	var std, module []string
+	for path, used := range imports {
+		if !used {
+			continue
+		}
+		if strings.Contains(path, ".") {
+			module = append(module, path)
+		} else {
+			std = append(std, path)
+		}
+	}
+	if len(std)+len(module) == 0 {
+		return
+	}
+	sort.Strings(std)
+	sort.Strings(module)
+
+	out.WriteString("import (\n")
+	for _, path := range std {
+		fmt.Fprintf(out, "\t%q\n", path)
+	}
+	if len(std) > 0 && len(module) > 0 {
+		out.WriteString("\n")
+	}
+	for _, path := range module {
+		fmt.Fprintf(out, "\t%q\n", path)
+	}
+	out.WriteString(")\n\n")
+}
+
+// orList joins values like "a, b or c".
+func orList(values []string) string {
+	if len(values) == 1 {
+		return values[0]
+	}
+
+	return strings.Join(values[:len(values)-1], ", ") + " or " + values[len(values)-1]
+}
+
+// comment formats doc as a Go comment with indent, wrapped after 77
+// columns.
+func comment(doc, indent string) string {
+	var lines []string
+	line := indent + "//"
+	for _, word := range strings.Fields(doc) {
+		if len(line)+1+len(word) > 77 && line != indent+"//" {
+			lines = append(lines, line)
+			line = indent + "//"
+		}
+		line += " " + word
+	}
+
+	return strings.Join(append(lines, line), "\n")
+}
+
+// clientRoutes returns the routes of the clients, those answering with JSON.
+func clientRoutes() []api.Route {
+	var routes []api.Route
+	for _, route := range api.Routes {
+		if !route.Raw {
+			routes = append(routes, route)
+		}
+	}
+
+	return routes
+}
+
+func takesBody(route api.Route) bool {
+	switch route.Method {
+	case "POST", "PUT", "PATCH":
+		return true
+	}
+
+	return route.Request != "" || route.Body
+}
+
+// client generates the methods of the Go client.
+func client() []byte {
+	var out bytes.Buffer
+	imports := map[string]bool{"context": true}
+	iterators := map[string]bool{}
+	for _, route := range clientRoutes() {
+		name := goName(route.Name)
+		params := []string{"ctx context.Context"}
+		path := fmt.Sprintf("%q", route.Path)
+		for _, match := range pathParam.FindAllStringSubmatch(route.Path, -1) {
+			params = append(params, match[1]+" string")
+		}
+		if len(pathParam.FindAllString(route.Path, -1)) > 0 {
+			path = pathParam.ReplaceAllString(fmt.Sprintf("%q", route.Path), `"+url.PathEscape($1)+"`)
+			path = strings.TrimPrefix(strings.TrimSuffix(path, `+""`), `""+`)
+			imports["net/url"] = true
+		}
+
+		query := "nil"
+		if len(route.Query) > 0 {
+			params = append(params, "query url.Values")
+			query = "query"
+			imports["net/url"] = true
+		}
+
+		body := "nil"
+		if route.Request != "" {
+			params = append(params, "body api."+route.Request)
+			body = "body"
+		} else if takesBody(route) {
+			params = append(params, "body interface{}")
+			body = "body"
+		}
+
+		result := "json.RawMessage"
+		if route.Response != "" {
+			result = "api." + route.Response
+			if route.ResponseList {
+				result = "[]" + result
+			}
+		}
+		imports["encoding/json"] = imports["encoding/json"] || route.Response == ""
+		imports["github.com/johscheuer/todo-app-web/api"] = imports["github.com/johscheuer/todo-app-web/api"] || route.Response != "" || route.Request != ""
+
+		doc := fmt.Sprintf("%s calls %s %s", name, route.Method, route.Path)
+		if route.Doc != "" {
+			doc += ", it " + route.Doc
+		}
+		if len(route.Query) > 0 {
+			doc += ". It takes the query parameters " + orList(route.Query)
+		}
+		fmt.Fprintf(&out, "%s.\n", comment(doc, ""))
+		fmt.Fprintf(&out, "func (client *Client) %s(%s) (%s, error) {\n", name, strings.Join(params, ", "), result)
+		fmt.Fprintf(&out, "\tvar result %s\n", result)
+		fmt.Fprintf(&out, "\terr := client.do(ctx, %q, %s, %s, %s, &result)\n", route.Method, path, query, body)
+		out.WriteString("\treturn result, err\n}\n\n")
+
+		if route.Paged {
+			all := "All" + strings.TrimPrefix(name, "List")
+			fmt.Fprintf(&out, "%s.\n", comment(fmt.Sprintf("%s iterates over all pages of %s, query is passed on to each of them", all, name), ""))
+			fmt.Fprintf(&out, "func (client *Client) %s(%s) *%sIterator {\n", all, strings.Join(params[1:], ", "), route.Response)
+			fmt.Fprintf(&out, "\treturn &%sIterator{pages: client.pages(%s, %s)}\n}\n\n", route.Response, path, query)
+			if !iterators[route.Response] {
+				iterator(&out, route.Response)
+			}
+			iterators[route.Response] = true
+		}
+	}
+
+	var source bytes.Buffer
+	fmt.Fprintf(&source, "// %s\n\npackage client\n\n", generatedHeader)
+	writeImports(&source, imports)
+	source.Write(out.Bytes())
+
+	return source.Bytes()
+}
+
+// iterator generates the iterator over the pages of a list of schema.
+func iterator(out *bytes.Buffer, schema string) {
+	fmt.Fprintf(out, "%s\n", comment(fmt.Sprintf("%sIterator reads a list of %s page by page, see Next.", schema, schema), ""))
+	fmt.Fprintf(out, "type %sIterator struct {\n\tpages   *pages\n\tpage    []api.%s\n\tcurrent api.%s\n}\n\n", schema, schema, schema)
+
+	out.WriteString("// Next reads the next entry, the next page when the current one is done.\n// It returns false at the end of the list or on an error, see Err.\n")
+	fmt.Fprintf(out, "func (it *%sIterator) Next(ctx context.Context) bool {\n", schema)
+	out.WriteString("\tfor len(it.page) == 0 {\n\t\tit.page = nil\n\t\tif !it.pages.read(ctx, &it.page) {\n\t\t\treturn false\n\t\t}\n\t}\n\n")
+	out.WriteString("\tit.current, it.page = it.page[0], it.page[1:]\n\treturn true\n}\n\n")
+
+	fmt.Fprintf(out, "// %s returns the entry read by Next.\n", schema)
+	fmt.Fprintf(out, "func (it *%sIterator) %s() api.%s {\n\treturn it.current\n}\n\n", schema, schema, schema)
+
+	out.WriteString("// Total returns the length of the whole list, after the first Next.\n")
+	fmt.Fprintf(out, "func (it *%sIterator) Total() int {\n\treturn it.pages.total\n}\n\n", schema)
+
+	out.WriteString("// Err returns the error that ended Next, nil at the end of the list.\n")
+	fmt.Fprintf(out, "func (it *%sIterator) Err() error {\n\treturn it.pages.err\n}\n\n", schema)
+}
+
+func tsType(field api.Field) string {
+	var typ string
+	switch field.Kind {
+	case api.String, api.Time:
+		typ = "string"
+		if len(field.Enum) > 0 {
+			var values []string
+			for _, value := range field.Enum {
+				values = append(values, fmt.Sprintf("%q", value))
+			}
+			typ = strings.Join(values, " | ")
+			if field.List {
+				typ = "(" + typ + ")"
+			}
+		}
+	case api.Int:
+		typ = "number"
+	case api.Bool:
+		typ = "boolean"
+	case api.Object:
+		typ = field.Schema
+	case api.Any:
+		typ = "unknown"
+	}
+
+	if field.List {
+		return typ + "[]"
+	}
+
+	return typ
+}
+
+// typeScript generates the TypeScript client, it only needs fetch.
+func typeScript() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "// %s\n\n", generatedHeader)
+
+	for _, schema := range api.Schemas {
+		if schema.Doc != "" {
+			fmt.Fprintf(&out, "/** %s */\n", schema.Doc)
+		}
+		extends := ""
+		if schema.Extends != "" {
+			extends = " extends " + schema.Extends
+		}
+		fmt.Fprintf(&out, "export interface %s%s {\n", schema.Name, extends)
+		for _, field := range schema.Fields {
+			if field.Doc != "" {
+				fmt.Fprintf(&out, "  /** %s */\n", field.Doc)
+			}
+			optional := ""
+			if field.Optional || field.OmitEmpty {
+				optional = "?"
+			}
+			fmt.Fprintf(&out, "  %s%s: %s;\n", field.Name, optional, tsType(field))
+		}
+		out.WriteString("}\n\n")
+	}
+
+	out.WriteString(`/** TodoAppError is an answer other than 2xx, with the message of the server. */
+export class TodoAppError extends Error {
+  constructor(public status: number, message: string) {
+    super(message);
+  }
+}
+
+/** TodoAppClient calls the todo API, baseURL is empty for the same origin. */
+export class TodoAppClient {
+  constructor(private baseURL: string = "", private headers: Record<string, string> = {}) {}
+
+  private async request<T>(method: string, path: string, query?: Record<string, string>, body?: unknown): Promise<T> {
+    let url = this.baseURL + path;
+    if (query && Object.keys(query).length > 0) {
+      url += "?" + new URLSearchParams(query).toString();
+    }
+    const headers: Record<string, string> = { Accept: "application/json", ...this.headers };
+    if (body !== undefined) {
+      headers["Content-Type"] = "application/json";
+    }
+    const response = await fetch(url, { method, headers, body: body === undefined ? undefined : JSON.stringify(body) });
+    const text = await response.text();
+    const value = text ? JSON.parse(text) : undefined;
+    if (!response.ok) {
+      throw new TodoAppError(response.status, (value && value.errors) || response.statusText);
+    }
+    return value as T;
+  }
+`)
+
+	for _, route := range clientRoutes() {
+		var params []string
+		path := "\"" + route.Path + "\""
+		if pathParam.MatchString(route.Path) {
+			path = "`" + pathParam.ReplaceAllString(route.Path, "$${encodeURIComponent($1)}") + "`"
+		}
+		for _, match := range pathParam.FindAllStringSubmatch(route.Path, -1) {
+			params = append(params, match[1]+": string")
+		}
+
+		query := "undefined"
+		if len(route.Query) > 0 {
+			var keys []string
+			for _, key := range route.Query {
+				keys = append(keys, fmt.Sprintf("%q", key))
+			}
+			sort.Strings(keys)
+			params = append(params, fmt.Sprintf("query: Partial<Record<%s, string>> = {}", strings.Join(keys, " | ")))
+			query = "query as Record<string, string>"
+		}
+
+		body := ""
+		if route.Request != "" {
+			params = append(params, "body: "+route.Request)
+			body = ", body"
+		} else if takesBody(route) {
+			params = append(params, "body?: unknown")
+			body = ", body"
+		}
+
+		result := "unknown"
+		if route.Response != "" {
+			result = route.Response
+			if route.ResponseList {
+				result += "[]"
+			}
+		}
+
+		doc := fmt.Sprintf("%s %s", route.Method, route.Path)
+		if route.Doc != "" {
+			doc += ", " + route.Doc
+		}
+		fmt.Fprintf(&out, "\n  /** %s */\n", doc)
+		fmt.Fprintf(&out, "  %s(%s): Promise<%s> {\n", route.Name, strings.Join(params, ", "), result)
+		if body == "" && query == "undefined" {
+			fmt.Fprintf(&out, "    return this.request(%q, %s);\n  }\n", route.Method, path)
+		} else {
+			fmt.Fprintf(&out, "    return this.request(%q, %s, %s%s);\n  }\n", route.Method, path, query, body)
+		}
+	}
+	out.WriteString("}\n")
+
+	return out.String()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	defaultAttachmentMaxBytes = 1 << 20
+	defaultAttachmentsPerTodo = 10
+	// multipartOverhead is allowed on top of MaxBytes for the boundaries and
+	// headers of an upload
+	multipartOverhead = 16 << 10
+	// blobCollectionInterval is how often the blobs no attachment refers to
+	// anymore are deleted
+	blobCollectionInterval = 10 * time.Minute
+	// unreferencedBlobsKey is the KV key of the digests of the blobs that
+	// lost their last reference, with the time of the loss
+	unreferencedBlobsKey = "attachments:unreferenced"
+)
+
+// attachmentStore keeps the content of the attachments, see Attachments in
+// the config.
+var attachmentStore tododb.AttachmentStore
+
+// The content of attachments is stored once per SHA-256 digest, as a blob
+// shared by every attachment with the same content. Each blob counts the
+// attachments referring to it with two KV counters that only count up, one
+// for the references and one for their releases, so replicas can count
+// concurrently. A blob is unreferenced when both are equal.
+func blobCounterKey(sum, counter string) string {
+	return "attachments:blob:" + sum + ":" + counter
+}
+
+// blobCount reads a counter of the blob sum, a missing one is zero.
+func blobCount(sum, counter string) (int64, error) {
+	value, err := tododb.KVOf(database).GetValue(blobCounterKey(sum, counter))
+	if err == tododb.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// blobReferenced reports whether an attachment still refers to the blob sum.
+func blobReferenced(sum string) (bool, error) {
+	refs, err := blobCount(sum, "refs")
+	if err != nil {
+		return false, err
+	}
+	releases, err := blobCount(sum, "releases")
+	if err != nil {
+		return false, err
+	}
+
+	return refs > releases, nil
+}
+
+// unreferencedBlobsMu serializes the collection with the uploads, the
+// releases and the thumbnails, so a blob isn't deleted while it's stored
+// again.
+var unreferencedBlobsMu sync.Mutex
+
+// storeBlob counts another attachment with the content of attachment and
+// stores the content as its blob, or in the quarantine.
+func storeBlob(ctx context.Context, attachment tododb.Attachment, content []byte) error {
+	unreferencedBlobsMu.Lock()
+	defer unreferencedBlobsMu.Unlock()
+
+	if _, err := tododb.KVOf(database).IncrValue(blobCounterKey(attachment.SHA256, "refs"), 0); err != nil {
+		return err
+	}
+
+	return attachmentStore.Put(ctx, attachment.Key(""), content, attachment.ContentType)
+}
+
+// releaseBlob counts an attachment with the content sum as gone. The blob is
+// left to runBlobCollections once it lost its last reference.
+func releaseBlob(sum string) error {
+	unreferencedBlobsMu.Lock()
+	defer unreferencedBlobsMu.Unlock()
+
+	releases, err := tododb.KVOf(database).IncrValue(blobCounterKey(sum, "releases"), 0)
+	if err != nil {
+		return err
+	}
+	refs, err := blobCount(sum, "refs")
+	if err != nil || releases < refs {
+		return err
+	}
+
+	unreferenced, err := loadUnreferencedBlobs()
+	if err != nil {
+		return err
+	}
+	unreferenced[sum] = time.Now().UTC()
+	return saveUnreferencedBlobs(unreferenced)
+}
+
+func loadUnreferencedBlobs() (map[string]time.Time, error) {
+	unreferenced := map[string]time.Time{}
+	value, err := tododb.KVOf(database).GetValue(unreferencedBlobsKey)
+	if err == tododb.ErrNotFound {
+		return unreferenced, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return unreferenced, json.Unmarshal([]byte(value), &unreferenced)
+}
+
+func saveUnreferencedBlobs(unreferenced map[string]time.Time) error {
+	value, err := json.Marshal(unreferenced)
+	if err != nil {
+		return err
+	}
+
+	return tododb.KVOf(database).SetValue(unreferencedBlobsKey, string(value), 0)
+}
+
+// collectBlobs deletes the blobs that lost their last reference and weren't
+// referenced again since. It returns how many it deleted.
+func collectBlobs(ctx context.Context) (int, error) {
+	unreferencedBlobsMu.Lock()
+	defer unreferencedBlobsMu.Unlock()
+
+	unreferenced, err := loadUnreferencedBlobs()
+	if err != nil || len(unreferenced) == 0 {
+		return 0, err
+	}
+
+	collected := 0
+	for sum := range unreferenced {
+		referenced, err := blobReferenced(sum)
+		if err != nil {
+			return collected, err
+		}
+		if !referenced {
+			for _, key := range []string{tododb.BlobKey(sum), tododb.QuarantineKey(sum), tododb.ThumbnailKey(sum)} {
+				if err := attachmentStore.Delete(ctx, key); err != nil {
+					return collected, err
+				}
+			}
+			collected++
+		}
+		delete(unreferenced, sum)
+	}
+
+	return collected, saveUnreferencedBlobs(unreferenced)
+}
+
+// runBlobCollections deletes the unreferenced blobs every
+// blobCollectionInterval.
+func runBlobCollections() {
+	for range time.Tick(blobCollectionInterval) {
+		collected, err := collectBlobs(context.Background())
+		if err != nil {
+			logger.Errorf("Blob collection failed: %v", err)
+		} else if collected > 0 {
+			logger.Infof("Deleted %d unreferenced attachment blobs", collected)
+		}
+	}
+}
+
+// dropContent lets go of the content of attachment of the todo with todoID.
+// Blobs are released for the next collection, content of older versions is
+// deleted right away.
+func dropContent(ctx context.Context, todoID string, attachment tododb.Attachment) error {
+	if attachment.SHA256 == "" {
+		return attachmentStore.Delete(ctx, tododb.AttachmentKey(todoID, attachment.ID))
+	}
+
+	return releaseBlob(attachment.SHA256)
+}
+
+// dropAttachments lets go of the content of the attachments of todos that
+// are gone for good.
+func dropAttachments(todos []tododb.TrashedTodo) {
+	for _, todo := range todos {
+		for _, attachment := range todo.Attachments {
+			if err := dropContent(context.Background(), todo.ID, attachment); err != nil {
+				logger.Errorf("%v", err)
+			}
+		}
+	}
+}
+
+// attachmentOf looks up the attachment of the attachment parameter, it
+// answers the request itself if the todo has none.
+func attachmentOf(c *gin.Context, todo tododb.Todo) (tododb.Attachment, bool) {
+	id := c.Param("attachment")
+	for _, attachment := range todo.Attachments {
+		if attachment.ID == id {
+			return attachment, true
+		}
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{
+		"errors": fmt.Sprintf("no attachment with id %q", id),
+	})
+	return tododb.Attachment{}, false
+}
+
+func listAttachmentsHandler(c *gin.Context) {
+	todo, ok := todoByID(c)
+	if !ok {
+		return
+	}
+	attachments := todo.Attachments
+	if attachments == nil {
+		attachments = []tododb.Attachment{}
+	}
+
+	c.JSON(http.StatusOK, attachments)
+}
+
+// uploadAttachmentHandler attaches the multipart field file to a todo. With
+// a scanner the content is scanned first, files with a threat are attached
+// but quarantined. The content is stored before the metadata, so the
+// metadata never points to nothing.
+func uploadAttachmentHandler(c *gin.Context) {
+	todo, ok := permittedTodo(c, permissionWrite)
+	if !ok {
+		return
+	}
+	config := appConfig.Attachments
+	if len(todo.Attachments) >= config.MaxPerTodo {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("a todo can have at most %d attachments", config.MaxPerTodo),
+		})
+		return
+	}
+
+	tooLarge := func() {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"errors": fmt.Sprintf("an attachment must not be larger than %d bytes", config.MaxBytes),
+		})
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, config.MaxBytes+multipartOverhead)
+	file, header, err := c.Request.FormFile("file")
+	if err != nil && strings.Contains(err.Error(), "request body too large") {
+		tooLarge()
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "upload the file as the multipart field file: " + err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	content, err := ioutil.ReadAll(io.LimitReader(file, config.MaxBytes+1))
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	if int64(len(content)) > config.MaxBytes {
+		tooLarge()
+		return
+	}
+
+	name := filepath.Base(strings.Replace(header.Filename, `\`, "/", -1))
+	if name == "." || name == "/" {
+		name = "attachment"
+	}
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(content)
+	}
+
+	ctx := c.Request.Context()
+	attachment := tododb.NewAttachment(name, contentType, content)
+	if attachmentScanner != nil {
+		threat, err := attachmentScanner.Scan(ctx, content)
+		if err != nil {
+			logger.Errorf("Attachment scan failed: %v", err)
+			c.JSON(http.StatusBadGateway, gin.H{
+				"errors": "the attachment couldn't be scanned: " + err.Error(),
+			})
+			return
+		}
+		attachment.Scan, attachment.Threat = scanClean, threat
+		if threat != "" {
+			attachment.Scan = scanQuarantined
+			logger.Warnf("Quarantined attachment %s of todo %s: %s", attachment.ID, todo.ID, threat)
+		}
+	}
+	err = storeBlob(ctx, attachment, content)
+	if err == nil {
+		if err = todosOf(c).AddAttachment(ctx, todo.ID, attachment); err != nil {
+			if err := releaseBlob(attachment.SHA256); err != nil {
+				logger.Errorf("%v", err)
+			}
+		}
+	}
+	if err != nil {
+		answerTodoError(c, todo.ID, err)
+		return
+	}
+	todo.Attachments = append(todo.Attachments, attachment)
+	publishChange(changeUpdated, todo)
+	queueThumbnail(attachment, content)
+
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// downloadAttachmentHandler answers with the content of an attachment. It is
+// always a download and sandboxed, uploaded HTML never runs in the app.
+// Quarantined attachments aren't served.
+func downloadAttachmentHandler(c *gin.Context) {
+	todo, ok := todoByID(c)
+	if !ok {
+		return
+	}
+	attachment, ok := attachmentOf(c, todo)
+	if !ok {
+		return
+	}
+	if attachment.Scan == scanQuarantined {
+		c.JSON(http.StatusForbidden, gin.H{
+			"errors": fmt.Sprintf("the attachment is quarantined, the scan found %s", attachment.Threat),
+		})
+		return
+	}
+
+	content, err := attachmentStore.Get(c.Request.Context(), attachment.Key(todo.ID))
+	if err == tododb.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": "the content of the attachment is gone",
+		})
+		return
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	c.Header("Content-Disposition", disposition)
+	c.Header("Content-Security-Policy", "sandbox")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Data(http.StatusOK, attachment.ContentType, content)
+}
+
+// deleteAttachmentHandler drops the metadata first, content without it is
+// only a leftover. Other attachments can still share the content.
+func deleteAttachmentHandler(c *gin.Context) {
+	todo, ok := permittedTodo(c, permissionWrite)
+	if !ok {
+		return
+	}
+	attachment, ok := attachmentOf(c, todo)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	err := todosOf(c).RemoveAttachment(ctx, todo.ID, attachment.ID)
+	if err == tododb.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": fmt.Sprintf("no attachment with id %q", attachment.ID),
+		})
+		return
+	}
+	if err != nil {
+		answerTodoError(c, todo.ID, err)
+		return
+	}
+	if err := dropContent(ctx, todo.ID, attachment); err != nil {
+		logger.Errorf("%v", err)
+	}
+	for i := range todo.Attachments {
+		if todo.Attachments[i].ID == attachment.ID {
+			todo.Attachments = append(todo.Attachments[:i:i], todo.Attachments[i+1:]...)
+			break
+		}
+	}
+	publishChange(changeUpdated, todo)
+
+	c.Status(http.StatusNoContent)
+}
+
+// attachmentBadge shows how many files are attached to a todo.
+func attachmentBadge(todo tododb.Todo) template.HTML {
+	if len(todo.Attachments) == 0 {
+		return ""
+	}
+
+	return template.HTML(fmt.Sprintf(` <span class="label label-default" title="%d attachment(s)">&#x1f4ce; %d</span>`, len(todo.Attachments), len(todo.Attachments)))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/logging"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	auditLogKey = "audit:security"
+	// maxAuditEvents is how many events the audit log keeps, the oldest are
+	// dropped first
+	maxAuditEvents = 1000
+)
+
+// The types of the security events.
+const (
+	eventLockout       = "lockout"
+	eventUnlock        = "unlock"
+	eventNewIP         = "new-ip"
+	eventPasswordReset = "password-reset"
+	eventTokenReuse    = "token-reuse"
+)
+
+var auditLogger = logging.New("audit")
+
+// auditLogMu serializes the changes to the audit log, it is read, changed
+// and written back like the session lists.
+var auditLogMu sync.Mutex
+
+// securityEvent is an entry of the audit log.
+type securityEvent struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Account string    `json:"account,omitempty"`
+	IP      string    `json:"ip,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+}
+
+func loadAuditLog() ([]securityEvent, error) {
+	value, err := tododb.KVOf(database).GetValue(auditLogKey)
+	if err == tododb.ErrNotFound {
+		return []securityEvent{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var events []securityEvent
+	err = json.Unmarshal([]byte(value), &events)
+	return events, err
+}
+
+// recordSecurityEvent logs event and appends it to the audit log. Failures
+// are logged only, they must not fail the request that caused the event.
+func recordSecurityEvent(event securityEvent) {
+	event.Time = time.Now().UTC()
+	auditLogger.Warnf("%s account=%q ip=%s %s", event.Type, event.Account, event.IP, event.Detail)
+
+	auditLogMu.Lock()
+	defer auditLogMu.Unlock()
+
+	events, err := loadAuditLog()
+	if err != nil {
+		logger.Errorf("audit log: %v", err)
+		return
+	}
+	events = append(events, event)
+	if len(events) > maxAuditEvents {
+		events = events[len(events)-maxAuditEvents:]
+	}
+
+	value, err := json.Marshal(events)
+	if err == nil {
+		err = tododb.KVOf(database).SetValue(auditLogKey, string(value), 0)
+	}
+	if err != nil {
+		logger.Errorf("audit log: %v", err)
+	}
+}
+
+// auditLogHandler returns the security events, the newest first, filtered
+// by ?type= and ?account=.
+func auditLogHandler(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil || limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("invalid limit: %q", c.Query("limit")),
+		})
+		return
+	}
+
+	events, err := loadAuditLog()
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	eventType, account := c.Query("type"), c.Query("account")
+	matches := []securityEvent{}
+	for i := len(events) - 1; i >= 0; i-- {
+		event := events[i]
+		if (eventType == "" || event.Type == eventType) && (account == "" || event.Account == account) {
+			matches = append(matches, event)
+		}
+		if limit > 0 && len(matches) == limit {
+			break
+		}
+	}
+
+	c.JSON(http.StatusOK, matches)
+}
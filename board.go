@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	boardPublicKey = "board:public"
+	// boardPreviewSize is the number of todos in the description for crawlers
+	boardPreviewSize = 5
+)
+
+var boardTemplate = template.Must(template.New("board").Funcs(brandingFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>Todo list - {{appName}}</title>
+    <meta name="description" content="{{len .Todos}} open todo(s){{range $i, $todo := .Preview}}{{if $i}},{{else}}:{{end}} {{$todo}}{{end}}">
+    <link rel="canonical" href="{{.Canonical}}">
+    <style>
+      body { font-family: Georgia, serif; max-width: 40em; margin: 2em auto; padding: 0 1em; }
+      h1 { color: {{primaryColor}}; }
+      .meta { color: #555; font-size: 0.9em; }
+      ol { padding-left: 1.5em; }
+      li { padding: 0.3em 0; border-bottom: 1px dotted #999; }
+    </style>
+  </head>
+  <body>
+    <main>
+      <h1>Todo list</h1>
+      <p class="meta">{{len .Todos}} open todo(s), as of <time datetime="{{.Updated.Format "2006-01-02T15:04:05Z07:00"}}">{{.Updated.Format "2006-01-02 15:04"}}</time></p>
+      <ol>
+      {{range .Todos}}  <li>{{.}}</li>
+      {{else}}  <li>Nothing to do.</li>
+      {{end}}</ol>
+    </main>
+  </body>
+</html>
+`))
+
+func boardIsPublic() (bool, error) {
+	value, err := tododb.KVOf(database).GetValue(boardPublicKey)
+	if err == tododb.ErrNotFound {
+		return false, nil
+	}
+
+	return value == "true", err
+}
+
+// publicBoardHandler renders the todos without an owner without scripts for
+// anyone, as long as the board was made public. Unlike the app views it may be cached for
+// BoardCacheSeconds, by browsers and proxies.
+func publicBoardHandler(c *gin.Context) {
+	public, err := boardIsPublic()
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	if !public {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": "the board is not public",
+		})
+		return
+	}
+
+	all, err := publicTodos().GetAllTodos(c.Request.Context())
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	todos := tododb.Titles(all)
+	preview := todos
+	if len(preview) > boardPreviewSize {
+		preview = preview[:boardPreviewSize]
+	}
+
+	var buf bytes.Buffer
+	err = boardTemplate.Execute(&buf, struct {
+		Todos     []string
+		Preview   []string
+		Canonical string
+		Updated   time.Time
+	}{
+		Todos:     todos,
+		Preview:   preview,
+		Canonical: publicURL(c) + "/board",
+		Updated:   time.Now().UTC(),
+	})
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", appConfig.BoardCacheSeconds))
+	writeWithETag(c, "text/html; charset=utf-8", buf.Bytes())
+}
+
+func getBoardHandler(c *gin.Context) {
+	public, err := boardIsPublic()
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"public": public,
+	})
+}
+
+func setBoardHandler(c *gin.Context) {
+	var body struct {
+		Public bool `json:"public"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	if err := tododb.KVOf(database).SetValue(boardPublicKey, fmt.Sprint(body.Public), 0); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	logger.Infof("Board public: %v", body.Public)
+	c.JSON(http.StatusOK, gin.H{
+		"public": body.Public,
+	})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPublicBoard(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+	server.createAccount("jane")
+	server.createTodo(asAccount("jane"), "Renew the passport")
+	server.createTodo(anonymous, "Buy milk")
+
+	server.expect(http.StatusNotFound, "GET", "/board", anonymous, nil, nil)
+	server.expect(http.StatusUnauthorized, "PUT", "/admin/board", asAccount("jane"), gin.H{"public": true}, nil)
+	server.expect(http.StatusOK, "PUT", "/admin/board", asAdmin, gin.H{"public": true}, nil)
+
+	recorder := server.serve("GET", "/board", anonymous, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("the public board answered %d, want 200", recorder.Code)
+	}
+	// the board shows the todos without an owner only
+	page := recorder.Body.String()
+	if !strings.Contains(page, "Buy milk") || strings.Contains(page, "passport") {
+		t.Errorf("the board is %s, want Buy milk without the todo of jane", page)
+	}
+	if cache, want := recorder.Header().Get("Cache-Control"), fmt.Sprintf("public, max-age=%d", defaultBoardCacheSeconds); cache != want {
+		t.Errorf("the board is cached with %q, want %q", cache, want)
+	}
+
+	server.expect(http.StatusOK, "PUT", "/admin/board", asAdmin, gin.H{"public": false}, nil)
+	server.expect(http.StatusNotFound, "GET", "/board", anonymous, nil, nil)
+}
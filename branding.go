@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+
+	"github.com/gin-gonic/contrib/static"
+	"github.com/gin-gonic/gin"
+)
+
+// The branding of the cat todo list, used for what the config leaves empty.
+const (
+	defaultBrandName       = "Awesome Todo App"
+	defaultBrandHeadline   = "Cat Todo list!"
+	defaultBrandLogoURL    = "cat_img.jpg"
+	defaultPrimaryColor    = "#337ab7"
+	defaultBackgroundColor = "#ffffff"
+
+	uiDir = "./public"
+)
+
+var hexColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// brandingFuncs brand the pages rendered by the server.
+var brandingFuncs = template.FuncMap{
+	"appName": func() string {
+		return appConfig.Branding.Name
+	},
+	"primaryColor": func() template.CSS {
+		return template.CSS(appConfig.Branding.PrimaryColor)
+	},
+}
+
+// checkBranding rejects colors that aren't hex colors and links that aren't
+// relative or http(s), the templates would drop them silently.
+func checkBranding(branding BrandingConfig) error {
+	for name, color := range map[string]string{"PrimaryColor": branding.PrimaryColor, "BackgroundColor": branding.BackgroundColor} {
+		if !hexColorPattern.MatchString(color) {
+			return fmt.Errorf("Branding.%s %q isn't a hex color like #1a5fb4", name, color)
+		}
+	}
+
+	links := []string{branding.LogoURL}
+	for _, link := range branding.FooterLinks {
+		if link.Title == "" {
+			return fmt.Errorf("Branding: the footer link %q has no Title", link.URL)
+		}
+		links = append(links, link.URL)
+	}
+	for _, link := range links {
+		u, err := url.Parse(link)
+		if err != nil {
+			return fmt.Errorf("Branding: %v", err)
+		}
+		if u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("Branding: %q must be relative or use http or https", link)
+		}
+	}
+
+	return nil
+}
+
+// serveUI serves the files of the UI, index.html rendered as a template with
+// the branding, and the web app manifest.
+func serveUI(router *gin.Engine, branding BrandingConfig) error {
+	index, err := template.ParseFiles(filepath.Join(uiDir, "index.html"))
+	if err != nil {
+		return err
+	}
+
+	// Not part of the API, they belong to the UI
+	router.GET("/", func(c *gin.Context) {
+		var buf bytes.Buffer
+		if err := index.Execute(&buf, branding); err != nil {
+			logger.Errorf("%v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+
+		c.Header("Cache-Control", "no-cache")
+		c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+	})
+	router.GET("/manifest.webmanifest", func(c *gin.Context) {
+		c.Header("Content-Type", "application/manifest+json")
+		c.JSON(http.StatusOK, gin.H{
+			"name":             branding.Name,
+			"short_name":       branding.ShortName,
+			"start_url":        ".",
+			"display":          "standalone",
+			"theme_color":      branding.PrimaryColor,
+			"background_color": branding.BackgroundColor,
+			"icons": []gin.H{
+				{"src": branding.LogoURL, "sizes": "any"},
+			},
+		})
+	})
+	router.Use(static.Serve("/", static.LocalFile(uiDir, true)))
+
+	return nil
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// lockoutMemory is how long lockouts count for the next one, and the
+	// longest a lockout can last
+	lockoutMemory = 24 * time.Hour
+	// maxKnownIPs is how many client IPs of an account are remembered to
+	// tell sign ins from new places
+	maxKnownIPs = 10
+)
+
+// lockScope is what failed sign ins are counted for: an account from one
+// client IP, a client IP for any accounts or an account from anywhere.
+type lockScope struct {
+	name    string
+	id      string
+	limit   int
+	account bool
+}
+
+func (scope lockScope) failuresKey() string {
+	return "login:failures:" + scope.id
+}
+
+func (scope lockScope) lockedKey() string {
+	return "login:locked:" + scope.id
+}
+
+func (scope lockScope) lockoutsKey() string {
+	return "login:lockouts:" + scope.id
+}
+
+// lock is a lockout as stored, since when and until when it lasts.
+type lock struct {
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+}
+
+func unlockedKey(name string) string {
+	return "login:unlocked:" + name
+}
+
+func knownIPsKey(name string) string {
+	return "login:ips:" + name
+}
+
+func accountUnlockKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "account-unlock:" + hex.EncodeToString(sum[:])
+}
+
+// loginGuard throttles failed sign ins. Reaching the limit of a scope locks
+// it out for lockout, every further lockout within lockoutMemory lasts
+// twice as long as the one before, up to maxLockout.
+type loginGuard struct {
+	maxFailures     int
+	ipFailures      int
+	accountFailures int
+	lockout         time.Duration
+	maxLockout      time.Duration
+
+	failuresTotal *prometheus.CounterVec
+	lockoutsTotal *prometheus.CounterVec
+}
+
+func (guard loginGuard) scopes(name, ip string) []lockScope {
+	return []lockScope{
+		{name: "account-ip", id: ip + ":" + name, limit: guard.maxFailures, account: true},
+		{name: "ip", id: ip, limit: guard.ipFailures},
+		{name: "account", id: "account:" + name, limit: guard.accountFailures, account: true},
+	}
+}
+
+// lockedFor returns how long the sign in of name from ip is still locked
+// out, zero if it isn't. Lockouts of the account from before an unlock are
+// ignored.
+func (guard loginGuard) lockedFor(name, ip string) (time.Duration, error) {
+	kv := tododb.KVOf(database)
+	var unlocked time.Time
+	if value, err := kv.GetValue(unlockedKey(name)); err == nil {
+		unlocked, _ = time.Parse(time.RFC3339Nano, value)
+	} else if err != tododb.ErrNotFound {
+		return 0, err
+	}
+
+	now := time.Now()
+	for _, scope := range guard.scopes(name, ip) {
+		value, err := kv.GetValue(scope.lockedKey())
+		if err == tododb.ErrNotFound {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+
+		var l lock
+		if err := json.Unmarshal([]byte(value), &l); err != nil {
+			return 0, err
+		}
+		if scope.account && l.Since.Before(unlocked) {
+			continue
+		}
+		if remaining := l.Until.Sub(now); remaining > 0 {
+			return remaining, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// failed counts a failed sign in of name from ip in every scope and locks
+// those that reached their limit.
+func (guard loginGuard) failed(name, ip, reason string) {
+	guard.failuresTotal.WithLabelValues(reason).Inc()
+	logger.Warnf("Failed sign in of %q from %s: %s", name, ip, reason)
+
+	kv := tododb.KVOf(database)
+	for _, scope := range guard.scopes(name, ip) {
+		failures, err := kv.IncrValue(scope.failuresKey(), guard.lockout)
+		if err != nil {
+			logger.Errorf("%v", err)
+			continue
+		}
+		if failures >= int64(scope.limit) {
+			guard.lock(scope, name, ip)
+		}
+	}
+}
+
+func (guard loginGuard) lock(scope lockScope, name, ip string) {
+	kv := tododb.KVOf(database)
+	lockouts, err := kv.IncrValue(scope.lockoutsKey(), lockoutMemory)
+	if err != nil {
+		logger.Errorf("%v", err)
+		lockouts = 1
+	}
+
+	duration := guard.lockout
+	for i := int64(1); i < lockouts && duration < guard.maxLockout; i++ {
+		duration *= 2
+	}
+	if duration > guard.maxLockout {
+		duration = guard.maxLockout
+	}
+
+	now := time.Now()
+	value, _ := json.Marshal(lock{Since: now, Until: now.Add(duration)})
+	if err := kv.SetValue(scope.lockedKey(), string(value), duration); err != nil {
+		logger.Errorf("%v", err)
+		return
+	}
+	if err := kv.DeleteValue(scope.failuresKey()); err != nil && err != tododb.ErrNotFound {
+		logger.Errorf("%v", err)
+	}
+
+	guard.lockoutsTotal.WithLabelValues(scope.name).Inc()
+	event := securityEvent{
+		Type:   eventLockout,
+		IP:     ip,
+		Detail: fmt.Sprintf("%s locked out for %s after %d failed sign ins, lockout %d", scope.name, duration, scope.limit, lockouts),
+	}
+	if scope.account {
+		event.Account = name
+		go sendUnlockMail(name)
+	}
+	recordSecurityEvent(event)
+}
+
+// succeeded resets the failures of name from ip and records sign ins from
+// client IPs the account wasn't seen with before.
+func (guard loginGuard) succeeded(name, ip string) {
+	kv := tododb.KVOf(database)
+	if err := kv.DeleteValue(guard.scopes(name, ip)[0].failuresKey()); err != nil && err != tododb.ErrNotFound {
+		logger.Errorf("%v", err)
+	}
+
+	var ips []string
+	value, err := kv.GetValue(knownIPsKey(name))
+	if err == nil {
+		err = json.Unmarshal([]byte(value), &ips)
+	}
+	if err != nil && err != tododb.ErrNotFound {
+		logger.Errorf("%v", err)
+		return
+	}
+	if contains(ips, ip) {
+		return
+	}
+
+	if len(ips) > 0 {
+		recordSecurityEvent(securityEvent{
+			Type:    eventNewIP,
+			Account: name,
+			IP:      ip,
+			Detail:  "sign in from a client IP not seen before",
+		})
+	}
+	ips = append(ips, ip)
+	if len(ips) > maxKnownIPs {
+		ips = ips[len(ips)-maxKnownIPs:]
+	}
+	updated, _ := json.Marshal(ips)
+	if err := kv.SetValue(knownIPsKey(name), string(updated), 0); err != nil {
+		logger.Errorf("%v", err)
+	}
+}
+
+// unlockAccount ends the lockouts of name from all client IPs.
+func unlockAccount(name string) error {
+	kv := tododb.KVOf(database)
+	if err := kv.SetValue(unlockedKey(name), time.Now().Format(time.RFC3339Nano), lockoutMemory); err != nil {
+		return err
+	}
+
+	scope := lockScope{id: "account:" + name}
+	for _, key := range []string{scope.failuresKey(), scope.lockedKey(), scope.lockoutsKey()} {
+		if err := kv.DeleteValue(key); err != nil && err != tododb.ErrNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unlockIP ends the lockout of a client IP for any accounts, the lockouts
+// of single accounts from it stay.
+func unlockIP(ip string) error {
+	kv := tododb.KVOf(database)
+	scope := lockScope{id: ip}
+	for _, key := range []string{scope.failuresKey(), scope.lockedKey(), scope.lockoutsKey()} {
+		if err := kv.DeleteValue(key); err != nil && err != tododb.ErrNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendUnlockMail mails the owner of a locked out account a token to unlock
+// it, so a lockout by someone else doesn't keep them out.
+func sendUnlockMail(name string) {
+	if appConfig.Mail.SMTPAddr == "" {
+		return
+	}
+	user, err := tododb.UsersOf(database).GetUser(name)
+	if err != nil || user.Disabled || user.Email == "" {
+		if err != nil && err != tododb.ErrNotFound {
+			logger.Errorf("%v", err)
+		}
+		return
+	}
+
+	kv := tododb.KVOf(database)
+	sent, err := kv.IncrValue("account-unlock:sent:"+name, lockoutMemory)
+	if err != nil {
+		logger.Errorf("%v", err)
+		return
+	}
+	if sent > maxResetMails {
+		logger.Warnf("Dropped unlock mail of %s, %d mails were sent already", name, maxResetMails)
+		return
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		logger.Errorf("%v", err)
+		return
+	}
+	token := hex.EncodeToString(raw)
+	if err := kv.SetValue(accountUnlockKey(token), name, lockoutMemory); err != nil {
+		logger.Errorf("%v", err)
+		return
+	}
+
+	body := fmt.Sprintf("Your todo app account %s was locked after too many failed sign ins.\n\n"+
+		"If that was you, unlock it with\n\n"+
+		"    curl -XPOST %s/api/v1/unlocks/%s\n\n"+
+		"If it wasn't you, someone tries to guess your password. Unlocking is safe,\n"+
+		"but consider a longer password and two-factor authentication.\n",
+		name, strings.TrimSuffix(appConfig.PublicURL, "/"), token)
+	if err := sendMail(appConfig.Mail, user.Email, "Your todo app account was locked", body); err != nil {
+		logger.Errorf("mail unlock of %s: %v", name, err)
+	}
+}
+
+// unlockWithTokenHandler unlocks the account of the token of an unlock
+// mail. Every token works once.
+func unlockWithTokenHandler(c *gin.Context) {
+	kv := tododb.KVOf(database)
+	key := accountUnlockKey(c.Param("token"))
+	name, err := kv.GetValue(key)
+	if err == tododb.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": "invalid or expired unlock token",
+		})
+		return
+	}
+	if err == nil {
+		err = kv.DeleteValue(key)
+	}
+	if err == nil {
+		err = unlockAccount(name)
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	recordSecurityEvent(securityEvent{Type: eventUnlock, Account: name, IP: c.ClientIP(), Detail: "unlocked with the token of the unlock mail"})
+	c.Status(http.StatusNoContent)
+}
+
+// unlockAccountHandler lets the admins unlock an account.
+func unlockAccountHandler(c *gin.Context) {
+	name := c.Param("name")
+	if err := unlockAccount(name); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	recordSecurityEvent(securityEvent{Type: eventUnlock, Account: name, IP: c.ClientIP(), Detail: "unlocked by an admin"})
+	c.Status(http.StatusNoContent)
+}
+
+// unlockIPHandler lets the admins unlock a client IP.
+func unlockIPHandler(c *gin.Context) {
+	ip := c.Param("ip")
+	if err := unlockIP(ip); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	recordSecurityEvent(securityEvent{Type: eventUnlock, IP: ip, Detail: "client IP unlocked by an admin"})
+	c.Status(http.StatusNoContent)
+}
+
+// retryAfter answers a locked out sign in.
+func retryAfter(c *gin.Context, remaining time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"errors": "too many failed sign ins, try again later",
+	})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newTestGuard() loginGuard {
+	return loginGuard{
+		maxFailures:     3,
+		ipFailures:      5,
+		accountFailures: 8,
+		lockout:         time.Minute,
+		maxLockout:      5 * time.Minute,
+		failuresTotal:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "failures"}, []string{"reason"}),
+		lockoutsTotal:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "lockouts"}, []string{"scope"}),
+	}
+}
+
+// guardKeys are the KV keys the guard uses for the sign ins of names from
+// ips.
+func guardKeys(guard loginGuard, names, ips []string) []string {
+	keys := []string{auditLogKey}
+	for _, name := range names {
+		keys = append(keys, unlockedKey(name), knownIPsKey(name))
+		for _, ip := range ips {
+			for _, scope := range guard.scopes(name, ip) {
+				keys = append(keys, scope.failuresKey(), scope.lockedKey(), scope.lockoutsKey())
+			}
+		}
+	}
+
+	return keys
+}
+
+func lockedFor(t *testing.T, guard loginGuard, name, ip string) time.Duration {
+	t.Helper()
+	remaining, err := guard.lockedFor(name, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return remaining
+}
+
+func TestLoginGuardLockout(t *testing.T) {
+	guard := newTestGuard()
+	_, restore := useMemoryDatabase(t, guardKeys(guard, []string{"jane", "bob"}, []string{"10.0.0.1", "10.0.0.2"}))
+	defer restore()
+
+	for i := 1; i < guard.maxFailures; i++ {
+		guard.failed("jane", "10.0.0.1", "wrong password")
+	}
+	if remaining := lockedFor(t, guard, "jane", "10.0.0.1"); remaining != 0 {
+		t.Fatalf("locked for %s before reaching the limit", remaining)
+	}
+
+	guard.failed("jane", "10.0.0.1", "wrong password")
+	if remaining := lockedFor(t, guard, "jane", "10.0.0.1"); remaining <= 0 || remaining > guard.lockout {
+		t.Errorf("locked for %s, want up to %s", remaining, guard.lockout)
+	}
+	if remaining := lockedFor(t, guard, "jane", "10.0.0.2"); remaining != 0 {
+		t.Errorf("jane from another client IP is locked for %s", remaining)
+	}
+	if remaining := lockedFor(t, guard, "bob", "10.0.0.1"); remaining != 0 {
+		t.Errorf("bob from the same client IP is locked for %s", remaining)
+	}
+
+	// Two more failures reach the limit of the client IP for any accounts
+	guard.failed("bob", "10.0.0.1", "wrong password")
+	guard.failed("bob", "10.0.0.1", "wrong password")
+	if remaining := lockedFor(t, guard, "someone", "10.0.0.1"); remaining <= 0 {
+		t.Error("the client IP isn't locked after reaching its limit")
+	}
+
+	events, err := loadAuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Type != eventLockout || events[0].Account != "jane" || events[1].Account != "" {
+		t.Errorf("the audit log is %+v, want the lockouts of jane and the client IP", events)
+	}
+}
+
+func TestLoginGuardEscalation(t *testing.T) {
+	guard := newTestGuard()
+	_, restore := useMemoryDatabase(t, guardKeys(guard, []string{"jane"}, []string{"10.0.0.1"}))
+	defer restore()
+
+	scope := guard.scopes("jane", "10.0.0.1")[0]
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		guard.lock(scope, "jane", "10.0.0.1")
+
+		value, err := tododb.KVOf(database).GetValue(scope.lockedKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var l lock
+		if err := json.Unmarshal([]byte(value), &l); err != nil {
+			t.Fatal(err)
+		}
+		if got := l.Until.Sub(l.Since); got != want {
+			t.Errorf("lockout %s, want %s", got, want)
+		}
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	guard := newTestGuard()
+	_, restore := useMemoryDatabase(t, guardKeys(guard, []string{"jane", "bob"}, []string{"10.0.0.1"}))
+	defer restore()
+
+	for i := 0; i < guard.maxFailures; i++ {
+		guard.failed("jane", "10.0.0.1", "wrong password")
+	}
+	if err := unlockAccount("jane"); err != nil {
+		t.Fatal(err)
+	}
+	if remaining := lockedFor(t, guard, "jane", "10.0.0.1"); remaining != 0 {
+		t.Errorf("jane is locked for %s after the unlock", remaining)
+	}
+
+	for _, scope := range guard.scopes("bob", "10.0.0.1")[1:2] {
+		guard.lock(scope, "bob", "10.0.0.1")
+	}
+	if err := unlockAccount("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if remaining := lockedFor(t, guard, "bob", "10.0.0.1"); remaining == 0 {
+		t.Error("unlocking the account ended the lockout of the client IP")
+	}
+	if err := unlockIP("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if remaining := lockedFor(t, guard, "bob", "10.0.0.1"); remaining != 0 {
+		t.Errorf("bob is locked for %s after unlocking the client IP", remaining)
+	}
+}
+
+func TestLoginGuardSucceeded(t *testing.T) {
+	guard := newTestGuard()
+	ips := []string{"10.0.0.1", "10.0.0.2"}
+	for i := 0; i < maxKnownIPs; i++ {
+		ips = append(ips, fmt.Sprintf("10.0.1.%d", i))
+	}
+	_, restore := useMemoryDatabase(t, guardKeys(guard, []string{"jane"}, ips))
+	defer restore()
+
+	guard.failed("jane", "10.0.0.1", "wrong password")
+	guard.failed("jane", "10.0.0.1", "wrong password")
+	guard.succeeded("jane", "10.0.0.1")
+	guard.failed("jane", "10.0.0.1", "wrong password")
+	if remaining := lockedFor(t, guard, "jane", "10.0.0.1"); remaining != 0 {
+		t.Errorf("locked for %s, the sign in didn't reset the failures", remaining)
+	}
+
+	guard.succeeded("jane", "10.0.0.1")
+	guard.succeeded("jane", "10.0.0.2")
+	events, err := loadAuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != eventNewIP || events[0].IP != "10.0.0.2" {
+		t.Errorf("the audit log is %+v, want the sign in from 10.0.0.2", events)
+	}
+
+	for _, ip := range ips[2:] {
+		guard.succeeded("jane", ip)
+	}
+	value, err := tododb.KVOf(database).GetValue(knownIPsKey("jane"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var known []string
+	if err := json.Unmarshal([]byte(value), &known); err != nil {
+		t.Fatal(err)
+	}
+	if len(known) != maxKnownIPs || known[0] != ips[2] {
+		t.Errorf("the known IPs are %q, want the last %d", known, maxKnownIPs)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+
+	retryAfter(c, 90*time.Second+time.Millisecond)
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") != "91" {
+		t.Errorf("retryAfter() answered %d with Retry-After %q, want 429 and 91", recorder.Code, recorder.Header().Get("Retry-After"))
+	}
+}
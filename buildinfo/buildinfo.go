@@ -0,0 +1,30 @@
+// Package buildinfo holds what the binary was built from. The values are set
+// at link time, e.g.
+//
+//	go build -ldflags "-X github.com/johscheuer/todo-app-web/buildinfo.Version=v1.2.0"
+package buildinfo
+
+import "runtime"
+
+var (
+	Version   = "dev"
+	GitCommit = "unknown"
+	// BuildDate in RFC 3339, e.g. from date -u +%Y-%m-%dT%H:%M:%SZ
+	BuildDate = "unknown"
+)
+
+type Info struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"gitCommit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+}
+
+func Get() Info {
+	return Info{
+		Version:   Version,
+		GitCommit: GitCommit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}
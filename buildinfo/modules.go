@@ -0,0 +1,61 @@
+package buildinfo
+
+import (
+	"runtime/debug"
+	"sort"
+)
+
+type Module struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	// Sum is the go.sum hash, empty for the main module
+	Sum     string  `json:"sum,omitempty"`
+	Replace *Module `json:"replace,omitempty"`
+}
+
+// Modules are the module versions the Go toolchain embedded in the binary.
+type Modules struct {
+	GoVersion    string   `json:"goVersion"`
+	Path         string   `json:"path"`
+	Main         Module   `json:"main"`
+	Dependencies []Module `json:"dependencies"`
+	// Settings are the build flags like CGO_ENABLED or vcs.revision
+	Settings map[string]string `json:"settings"`
+}
+
+func module(m *debug.Module) Module {
+	result := Module{Path: m.Path, Version: m.Version, Sum: m.Sum}
+	if m.Replace != nil {
+		replace := module(m.Replace)
+		result.Replace = &replace
+	}
+
+	return result
+}
+
+// GetModules returns false if the binary was built without module support.
+func GetModules() (Modules, bool) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return Modules{}, false
+	}
+
+	modules := Modules{
+		GoVersion:    info.GoVersion,
+		Path:         info.Path,
+		Main:         module(&info.Main),
+		Dependencies: make([]Module, 0, len(info.Deps)),
+		Settings:     map[string]string{},
+	}
+	for _, dep := range info.Deps {
+		modules.Dependencies = append(modules.Dependencies, module(dep))
+	}
+	sort.Slice(modules.Dependencies, func(i, j int) bool {
+		return modules.Dependencies[i].Path < modules.Dependencies[j].Path
+	})
+	for _, setting := range info.Settings {
+		modules.Settings[setting.Key] = setting.Value
+	}
+
+	return modules, true
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+type bulkEditRequest struct {
+	Filter smartFilter `json:"filter"`
+	// SmartList selects the todos by a saved filter instead of Filter
+	SmartList string `json:"smartlist"`
+	// All is needed to edit every todo with an empty filter
+	All        bool     `json:"all"`
+	AddTags    []string `json:"addTags"`
+	RemoveTags []string `json:"removeTags"`
+	// Priority from tododb.PriorityNone to tododb.PriorityHigh
+	Priority *int `json:"priority"`
+}
+
+type bulkEditResult struct {
+	Todo    string `json:"todo"`
+	Updated string `json:"updated,omitempty"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (request bulkEditRequest) validate() error {
+	if request.Priority != nil && (*request.Priority < tododb.PriorityNone || *request.Priority > tododb.PriorityHigh) {
+		return fmt.Errorf("priority must be between %d and %d", tododb.PriorityNone, tododb.PriorityHigh)
+	}
+
+	for _, tag := range append(append([]string{}, request.AddTags...), request.RemoveTags...) {
+		if !todoTagPattern.MatchString("#" + strings.TrimPrefix(tag, "#")) {
+			return fmt.Errorf("invalid tag %q", tag)
+		}
+	}
+
+	return request.Filter.validate()
+}
+
+// apply returns the title of a todo with the tags of the request added and
+// removed, they are separate words of the title, like in the seed profiles.
+func (request bulkEditRequest) apply(todo string) string {
+	words := strings.Fields(todo)
+	result := make([]string, 0, len(words)+len(request.AddTags))
+	for _, word := range words {
+		removed := false
+		for _, tag := range request.RemoveTags {
+			removed = removed || strings.EqualFold(word, "#"+strings.TrimPrefix(tag, "#"))
+		}
+		if !removed {
+			result = append(result, word)
+		}
+	}
+
+	for _, tag := range request.AddTags {
+		tag = "#" + strings.TrimPrefix(tag, "#")
+		present := false
+		for _, word := range result {
+			present = present || strings.EqualFold(word, tag)
+		}
+		if !present {
+			result = append(result, tag)
+		}
+	}
+
+	if strings.Join(result, " ") == strings.Join(words, " ") {
+		return todo
+	}
+
+	return strings.Join(result, " ")
+}
+
+// applyTags returns tags with the changes of the request, to keep the tags
+// of a todo in line with its text.
+func (request bulkEditRequest) applyTags(tags []string) []string {
+	result := []string{}
+	for _, tag := range tags {
+		removed := false
+		for _, remove := range request.RemoveTags {
+			removed = removed || tododb.NormalizeTag(remove) == tag
+		}
+		if !removed {
+			result = append(result, tag)
+		}
+	}
+	for _, tag := range request.AddTags {
+		if tag = tododb.NormalizeTag(tag); !contains(result, tag) {
+			result = append(result, tag)
+		}
+	}
+
+	return result
+}
+
+// bulkEditHandler changes all todos matching a filter or smart list, in one
+// batch of the backend. The todos keep their id and their place in the list,
+// those deleted in the meantime are skipped. Todos the account may only read
+// are reported as errors and left as they are.
+func bulkEditHandler(c *gin.Context) {
+	var request bulkEditRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	if request.SmartList != "" {
+		list, ok := findSmartList(c, request.SmartList)
+		if !ok {
+			return
+		}
+		request.Filter = list.Filter
+	}
+
+	if err := request.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	if !request.All && request.SmartList == "" && isEmptyFilter(request.Filter) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "the filter is empty, set all to edit every todo",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	todos, err := todosOf(c).GetAllTodos(ctx)
+	if err == nil {
+		var grants accountGrants
+		if grants, err = permissionsOf(c); err == nil {
+			err = bulkEdit(c, request, todos, grants)
+		}
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+	}
+}
+
+// bulkEdit applies the request to the todos matching its filter and answers
+// with the result of each.
+func bulkEdit(c *gin.Context, request bulkEditRequest, todos []tododb.Todo, grants accountGrants) error {
+	edit := func(todo *tododb.Todo) {
+		todo.Title = request.apply(todo.Title)
+		if len(request.AddTags) > 0 || len(request.RemoveTags) > 0 {
+			todo.Tags = request.applyTags(todo.Tags)
+		}
+		if request.Priority != nil {
+			todo.Priority = *request.Priority
+		}
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	results := []bulkEditResult{}
+	var ids []string
+	var edited []tododb.Todo
+	for _, todo := range todos {
+		if !request.Filter.matches(todo, today) {
+			continue
+		}
+
+		result := bulkEditResult{Todo: todo.Title, Status: "unchanged"}
+		changed := todo
+		edit(&changed)
+		switch {
+		case changed.Title == todo.Title && changed.Priority == todo.Priority && strings.Join(changed.Tags, " ") == strings.Join(todo.Tags, " "):
+		case grants.access(c.GetString(accountKey), todo, permissionWrite) != nil:
+			result.Status = "error"
+			result.Error = errNoPermission.Error()
+		default:
+			if changed.Title != todo.Title {
+				result.Updated = changed.Title
+			}
+			result.Status = "updated"
+			changed.UpdatedAt = now.UTC()
+			ids = append(ids, todo.ID)
+			edited = append(edited, changed)
+		}
+		results = append(results, result)
+	}
+
+	if len(ids) > 0 {
+		if err := todosOf(c).UpdateTodos(c.Request.Context(), ids, edit); err != nil {
+			return err
+		}
+		publishChange(changeUpdated, edited...)
+		dropCachedSmartLists()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"matched": len(results),
+		"updated": len(ids),
+		"results": results,
+	})
+	return nil
+}
+
+func isEmptyFilter(filter smartFilter) bool {
+	return filter.Text == "" && len(filter.Tags) == 0 && filter.Due == "" && filter.Status == ""
+}
+
+// bulkMaxTodos limits the todos of one request to /api/todos/bulk.
+const bulkMaxTodos = 1000
+
+// bulkTodosRequest creates todos from their titles and deletes and completes
+// todos by their ids, each in one batch of the backend.
+type bulkTodosRequest struct {
+	Create   []string `json:"create"`
+	Delete   []string `json:"delete"`
+	Complete []string `json:"complete"`
+}
+
+// bulkTodosHandler creates, completes and deletes many todos in a few round
+// trips to the backend. Unknown ids are skipped, the response only has the
+// ids that had a todo. Completing a blocked todo fails the whole request
+// before anything is changed.
+func bulkTodosHandler(c *gin.Context) {
+	var request bulkTodosRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	total := len(request.Create) + len(request.Delete) + len(request.Complete)
+	if total == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "set create, delete or complete",
+		})
+		return
+	}
+	if total > bulkMaxTodos {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("at most %d todos per request", bulkMaxTodos),
+		})
+		return
+	}
+	for _, title := range request.Create {
+		if strings.TrimSpace(title) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": "title must not be empty",
+			})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	existing := map[string]tododb.Todo{}
+	if len(request.Delete) > 0 || len(request.Complete) > 0 {
+		todos, err := todosOf(c).GetAllTodos(ctx)
+		if err != nil {
+			logger.Errorf("%v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+		for _, todo := range todos {
+			if _, seen := existing[todo.ID]; !seen {
+				existing[todo.ID] = todo
+			}
+		}
+	}
+
+	var completed, deleted []tododb.Todo
+	for _, id := range request.Complete {
+		if todo, found := existing[id]; found && !todo.Done {
+			if !authorizeTodos(c, permissionWrite, todo) || !checkBlockers(c, todo) {
+				return
+			}
+			completed = append(completed, todo)
+		}
+	}
+	for _, id := range request.Delete {
+		if todo, found := existing[id]; found {
+			if !authorizeTodos(c, permissionOwner, todo) {
+				return
+			}
+			deleted = append(deleted, todo)
+		}
+	}
+
+	created := newTodos(request.Create)
+	claimTodos(c, created)
+	// bulkTodos marks the completed todos as done
+	before := append(append([]tododb.Todo{}, completed...), deleted...)
+	if err := bulkTodos(ctx, todosOf(c), created, completed, deleted); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	dropCachedSmartLists()
+	grants := takeGrants(deleted...)
+	recordOperation(c, tododb.Operation{Kind: operationBulk, Before: before, Created: todoIDs(created), Grants: grants})
+
+	namespace := recordsNamespace(c)
+	for _, todo := range completed {
+		finishTodo(namespace, todo)
+	}
+	if len(deleted) > 0 {
+		trashTodos(c, grants, deleted...)
+	}
+	for _, todo := range deleted {
+		releaseTodo(todo.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"created":   created,
+		"completed": todoIDs(completed),
+		"deleted":   todoIDs(deleted),
+	})
+}
+
+// bulkTodos stores the batches of a bulk request in todos and publishes each
+// once it is stored.
+func bulkTodos(ctx context.Context, todos tododb.TodoDB, created, completed, deleted []tododb.Todo) error {
+	if len(created) > 0 {
+		if err := todos.SaveTodos(ctx, created); err != nil {
+			return err
+		}
+		publishChange(changeCreated, created...)
+		queueHooks(hookCreate, created...)
+	}
+
+	if len(completed) > 0 {
+		if err := todos.CompleteTodos(ctx, todoIDs(completed)); err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		for i := range completed {
+			completed[i].Done = true
+			completed[i].UpdatedAt = now
+		}
+		publishChange(changeUpdated, completed...)
+	}
+
+	if len(deleted) > 0 {
+		if err := todos.DeleteTodos(ctx, todoIDs(deleted)); err != nil {
+			return err
+		}
+		publishChange(changeDeleted, deleted...)
+	}
+
+	return nil
+}
+
+func todoIDs(todos []tododb.Todo) []string {
+	ids := make([]string, len(todos))
+	for i, todo := range todos {
+		ids[i] = todo.ID
+	}
+
+	return ids
+}
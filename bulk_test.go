@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type bulkResponse struct {
+	Completed []string `json:"completed"`
+	Deleted   []string `json:"deleted"`
+}
+
+func TestBulkTodosPermissions(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+	server.createAccount("jane")
+	server.createAccount("bob")
+	jane, bob := asAccount("jane"), asAccount("bob")
+	todo := server.createTodo(jane, "Renew the passport")
+	own := server.createTodo(bob, "Fix the bike")
+
+	// without a grant the todo of jane is an unknown id for bob
+	var response bulkResponse
+	server.expect(http.StatusOK, "POST", "/api/todos/bulk", bob, gin.H{"complete": []string{todo.ID}, "delete": []string{todo.ID}}, &response)
+	if len(response.Completed) != 0 || len(response.Deleted) != 0 {
+		t.Errorf("bob changed the todo of jane without a grant: %+v", response)
+	}
+
+	server.grant(jane, todo.ID, "bob", permissionRead)
+	server.expect(http.StatusForbidden, "POST", "/api/todos/bulk", bob, gin.H{"complete": []string{own.ID, todo.ID}}, nil)
+	server.grant(jane, todo.ID, "bob", permissionWrite)
+	// a refused todo stops the whole request, the own todo is kept as well
+	server.expect(http.StatusForbidden, "POST", "/api/todos/bulk", bob, gin.H{"delete": []string{own.ID, todo.ID}}, nil)
+	if _, found := findTodoIn(server.todos(bob), own.ID); !found {
+		t.Errorf("the refused request deleted the own todo of bob")
+	}
+
+	server.expect(http.StatusOK, "POST", "/api/todos/bulk", bob, gin.H{"complete": []string{todo.ID}, "delete": []string{own.ID}}, &response)
+	if !reflect.DeepEqual(response, bulkResponse{Completed: []string{todo.ID}, Deleted: []string{own.ID}}) {
+		t.Errorf("the bulk request answered %+v", response)
+	}
+	completed, found := findTodoIn(server.todos(jane), todo.ID)
+	if !found || !completed.Done || completed.Owner != "jane" {
+		t.Errorf("the todo of jane is %+v, want it done and owned by jane", completed)
+	}
+}
+
+func TestBulkTodosLimits(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+
+	titles := make([]string, bulkMaxTodos+1)
+	for i := range titles {
+		titles[i] = "todo"
+	}
+	tests := map[string]gin.H{
+		"nothing":     {},
+		"empty title": {"create": []string{" "}},
+		"too many":    {"create": titles},
+	}
+	for name, request := range tests {
+		if recorder := server.serve("POST", "/api/todos/bulk", anonymous, request); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: answered %d, want 400", name, recorder.Code)
+		}
+	}
+}
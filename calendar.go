@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const defaultCalendarDays = 31
+
+type calendarDay struct {
+	Day   string   `json:"day"`
+	Todos []string `json:"todos"`
+}
+
+// calendarHandler returns the todos due from ?from= to ?to=, grouped by their
+// due day. Days without todos are left out. The todos are streamed from the
+// backend and only the ones in the range are kept.
+func calendarHandler(c *gin.Context) {
+	_, from, to, ok := dayRange(c, defaultCalendarDays, maxWorkloadDays)
+	if !ok {
+		return
+	}
+
+	first, last := from.Format(dayFormat), to.Format(dayFormat)
+	byDay := map[string]*calendarDay{}
+	err := todosOf(c).ForEachTodo(c.Request.Context(), func(todo tododb.Todo) error {
+		day, ok := dueDay(todo)
+		if todo.Done || !ok || day < first || day > last {
+			return nil
+		}
+
+		if byDay[day] == nil {
+			byDay[day] = &calendarDay{Day: day}
+		}
+		byDay[day].Todos = append(byDay[day].Todos, todo.Title)
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	days := make([]*calendarDay, 0, len(byDay))
+	for _, day := range byDay {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Day < days[j].Day
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"list": defaultListName,
+		"from": first,
+		"to":   last,
+		"days": days,
+	})
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	changeCreated = "created"
+	changeUpdated = "updated"
+	changeDeleted = "deleted"
+	// changeReset means the whole list was replaced, clients read it again
+	changeReset = "reset"
+	// changeQuota warns once that a quota is almost used up
+	changeQuota = "quota"
+
+	// changeBufferSize is the number of changes kept for clients that are
+	// behind, older clients get a reset
+	changeBufferSize = 1000
+
+	defaultLongPollSeconds = 30
+	// changeClientTTL is how long the cursor of a client is kept after its
+	// last poll
+	changeClientTTL = 24 * time.Hour
+)
+
+type change struct {
+	Seq   int64         `json:"seq"`
+	List  string        `json:"list"`
+	Type  string        `json:"type"`
+	Todo  *tododb.Todo  `json:"todo,omitempty"`
+	Quota *quotaWarning `json:"quota,omitempty"`
+	Time  time.Time     `json:"time"`
+}
+
+// changeStream keeps the latest changes of a list made through this
+// instance. The sequence numbers come from a counter in the backend, so they
+// keep growing over restarts and are shared by the replicas. Waiting clients
+// hold the notify channel, which is closed and replaced by every publish.
+type changeStream struct {
+	mu      sync.Mutex
+	list    string
+	changes []change
+	// broken is set while changes can't be numbered, every client resyncs
+	broken bool
+	notify chan struct{}
+}
+
+var changeFeed = newChangeStream(defaultListName)
+
+func newChangeStream(list string) *changeStream {
+	return &changeStream{list: list, notify: make(chan struct{})}
+}
+
+func changeSeqKey(list string) string {
+	return "changes:seq:" + list
+}
+
+func (stream *changeStream) publish(kind string, todos ...tododb.Todo) {
+	changes := []change{}
+	if len(todos) == 0 {
+		changes = append(changes, change{Type: kind})
+	}
+	for i := range todos {
+		changes = append(changes, change{Type: kind, Todo: &todos[i]})
+	}
+
+	stream.add(changes...)
+}
+
+// add numbers the changes and wakes up the waiting clients.
+func (stream *changeStream) add(changes ...change) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	now := time.Now().UTC()
+	kv := tododb.KVOf(database)
+	for _, c := range changes {
+		seq, err := kv.IncrValue(changeSeqKey(stream.list), 0)
+		if err != nil {
+			logger.Errorf("Numbering a change of %s: %v", stream.list, err)
+			stream.broken = true
+			continue
+		}
+		stream.broken = false
+		c.Seq, c.List, c.Time = seq, stream.list, now
+		stream.changes = append(stream.changes, c)
+	}
+	if overflow := len(stream.changes) - changeBufferSize; overflow > 0 {
+		stream.changes = append([]change{}, stream.changes[overflow:]...)
+	}
+
+	close(stream.notify)
+	stream.notify = make(chan struct{})
+}
+
+// current returns the sequence number of the latest change of the list.
+func (stream *changeStream) current() (int64, error) {
+	value, err := tododb.KVOf(database).GetValue(changeSeqKey(stream.list))
+	if err == tododb.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// since returns the changes after seq and the channel closed by the next
+// change. reset is set if not every change after seq is at hand: it is too
+// old, was made through another replica, couldn't be numbered, or the
+// sequence started over because the backend lost its counter.
+func (stream *changeStream) since(seq int64) (result []change, next int64, reset bool, wait <-chan struct{}, err error) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	if next, err = stream.current(); err != nil {
+		return nil, 0, false, nil, err
+	}
+	if stream.broken || seq > next {
+		return []change{}, next, true, stream.notify, nil
+	}
+
+	result = []change{}
+	last := seq
+	for _, c := range stream.changes {
+		if c.Seq <= seq {
+			continue
+		}
+		if c.Seq != last+1 {
+			return []change{}, next, true, stream.notify, nil
+		}
+		result = append(result, c)
+		last = c.Seq
+	}
+	if last != next {
+		return []change{}, next, true, stream.notify, nil
+	}
+
+	return result, next, false, stream.notify, nil
+}
+
+func publishChange(kind string, todos ...tododb.Todo) {
+	changeFeed.publish(kind, todos...)
+	queueWebhooks(kind, todos...)
+}
+
+func changeClientKey(client string) string {
+	return "changes:client:" + client
+}
+
+// changesHandler answers as soon as there are changes after ?since=, or
+// with none after ?timeout= seconds. A client that sends ?client= instead of
+// since continues where its last poll ended, the cursor is kept in the
+// backend.
+func changesHandler(c *gin.Context) {
+	if list := c.DefaultQuery("list", defaultListName); list != changeFeed.list {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": fmt.Sprintf("unknown list %q", list),
+		})
+		return
+	}
+
+	timeout := appConfig.LongPollSeconds
+	if value := c.Query("timeout"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 || seconds > appConfig.LongPollSeconds {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": "timeout must be between 0 and " + strconv.Itoa(appConfig.LongPollSeconds),
+			})
+			return
+		}
+		timeout = seconds
+	}
+
+	client := c.Query("client")
+	kv := tododb.KVOf(database)
+
+	seq := int64(-1)
+	if value := c.Query("since"); value != "" {
+		var err error
+		if seq, err = strconv.ParseInt(value, 10, 64); err != nil || seq < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": "since must be a sequence number",
+			})
+			return
+		}
+	} else if client != "" {
+		value, err := kv.GetValue(changeClientKey(client))
+		if err != nil && err != tododb.ErrNotFound {
+			logger.Errorf("%v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+		if err == nil {
+			seq, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+
+	result, next, reset, wait, err := changeFeed.since(seq)
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	if seq < 0 {
+		// A new client only learns where the stream is
+		result, reset = []change{}, false
+	} else if len(result) == 0 && !reset && timeout > 0 {
+		timer := time.NewTimer(time.Duration(timeout) * time.Second)
+		select {
+		case <-wait:
+			if result, next, reset, _, err = changeFeed.since(seq); err != nil {
+				timer.Stop()
+				logger.Errorf("%v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"errors": err.Error(),
+				})
+				return
+			}
+		case <-timer.C:
+		case <-c.Request.Context().Done():
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+
+	reader, err := readerOf(c)
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	// The changes of todos the account may not read are skipped, the
+	// sequence numbers still tell where the stream is
+	visible := []change{}
+	for _, change := range result {
+		if change.Todo == nil || reader.Matches(*change.Todo) {
+			visible = append(visible, change)
+		}
+	}
+
+	if client != "" {
+		if err := kv.SetValue(changeClientKey(client), strconv.FormatInt(next, 10), changeClientTTL); err != nil {
+			logger.Errorf("%v", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"list":    changeFeed.list,
+		"changes": visible,
+		"next":    next,
+		"reset":   reset,
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// chaosMiddleware delays requests by up to latencyMs and fails errorRate of
+// them with a 503, to show how clients and dashboards cope.
+func chaosMiddleware(options map[string]string) (gin.HandlerFunc, error) {
+	latency, err := intOption(options, "latencyMs", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	errorRate, err := floatOption(options, "errorRate", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if latency < 0 || errorRate < 0 || errorRate > 1 {
+		return nil, errors.New("latencyMs must not be negative and errorRate must be between 0 and 1")
+	}
+
+	return func(c *gin.Context) {
+		if latency > 0 {
+			time.Sleep(time.Duration(rand.Intn(latency+1)) * time.Millisecond)
+		}
+
+		if rand.Float64() < errorRate {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"errors": "injected by the chaos middleware",
+			})
+			return
+		}
+
+		c.Next()
+	}, nil
+}
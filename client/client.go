@@ -0,0 +1,350 @@
+// Package client calls the todo API. Its methods are generated from the
+// definition of the API in package api, one per route that answers with
+// JSON, see client_gen.go.
+//
+// A client signs in with one of SetBasicAuth, SetToken, SetAPIKey or
+// SignIn, repeats requests that failed on the way and iterates over paged
+// lists:
+//
+//	todos := client.New("http://localhost:3000")
+//	if err := todos.SignIn(ctx, "alice", password, ""); err != nil {
+//		return err
+//	}
+//	open := todos.AllTodos(url.Values{"status": {"open"}})
+//	for open.Next(ctx) {
+//		fmt.Println(open.Todo().Title)
+//	}
+//	return open.Err()
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/api"
+)
+
+const (
+	defaultRetries   = 2
+	defaultRetryWait = 500 * time.Millisecond
+
+	apiKeyHeader = "X-API-Key"
+	otpHeader    = "X-OTP"
+)
+
+// errNotSignedIn is returned by a refresh without tokens.
+var errNotSignedIn = errors.New("the client has no tokens, SignIn first")
+
+// Client calls the API of the todo app at BaseURL. It is safe for
+// concurrent use once set up.
+type Client struct {
+	// BaseURL is where the app is served, like http://localhost:3000
+	BaseURL string
+	// HTTPClient sends the requests, http.DefaultClient if nil
+	HTTPClient *http.Client
+	// Header is added to every request, e.g. Authorization
+	Header http.Header
+	// Retries is how often a request is repeated after a network error or
+	// an answer of 429, 502, 503 or 504. Only GET, PUT and DELETE are
+	// repeated, they do the same the second time.
+	Retries int
+	// RetryWait is the wait before the first retry, it doubles with every
+	// further one. Retry-After of the answer is used instead if it has one.
+	RetryWait time.Duration
+
+	// mu guards tokens, it is held during a refresh
+	mu     sync.Mutex
+	tokens *api.Tokens
+}
+
+// New returns a client of the app at baseURL that repeats failed requests
+// twice.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:   strings.TrimSuffix(baseURL, "/"),
+		Header:    http.Header{},
+		Retries:   defaultRetries,
+		RetryWait: defaultRetryWait,
+	}
+}
+
+// Error is an answer other than 2xx, Message is the error of the app.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", err.StatusCode, http.StatusText(err.StatusCode), err.Message)
+}
+
+// SetBasicAuth signs every request in with the name and password of an
+// account.
+func (client *Client) SetBasicAuth(name, password string) {
+	client.setHeader("Authorization", basicAuth(name, password))
+}
+
+// SetToken signs every request in with the token of a session or an access
+// token, which is not refreshed.
+func (client *Client) SetToken(token string) {
+	client.setHeader("Authorization", "Bearer "+token)
+}
+
+// SetAPIKey authenticates the requests to the integrations.
+func (client *Client) SetAPIKey(key string) {
+	client.setHeader(apiKeyHeader, key)
+}
+
+func (client *Client) setHeader(name, value string) {
+	if client.Header == nil {
+		client.Header = http.Header{}
+	}
+	client.Header.Set(name, value)
+}
+
+func basicAuth(name, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(name+":"+password))
+}
+
+// SignIn gets tokens with the name and password of an account, and the
+// two-factor code if it has one enabled. The following requests are signed
+// in with the access token, it is refreshed when it expires.
+func (client *Client) SignIn(ctx context.Context, name, password, otp string) error {
+	header := http.Header{}
+	header.Set("Authorization", basicAuth(name, password))
+	if otp != "" {
+		header.Set(otpHeader, otp)
+	}
+
+	var tokens api.Tokens
+	if _, err := client.send(ctx, request{method: "POST", path: "/api/v1/tokens", header: header, anonymous: true}, &tokens); err != nil {
+		return err
+	}
+	client.SetTokens(tokens)
+
+	return nil
+}
+
+// SetTokens signs the following requests in with tokens, e.g. those of an
+// earlier SignIn.
+func (client *Client) SetTokens(tokens api.Tokens) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.tokens = &tokens
+}
+
+// Tokens returns the current tokens, to be kept for SetTokens. They change
+// with every refresh.
+func (client *Client) Tokens() (api.Tokens, bool) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.tokens == nil {
+		return api.Tokens{}, false
+	}
+
+	return *client.tokens, true
+}
+
+func (client *Client) accessToken() string {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.tokens == nil {
+		return ""
+	}
+
+	return client.tokens.AccessToken
+}
+
+// refresh replaces the expired access token used, unless another request
+// did already. A refresh token works once, using it twice revokes the
+// session.
+func (client *Client) refresh(ctx context.Context, used string) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.tokens == nil {
+		return errNotSignedIn
+	}
+	if client.tokens.AccessToken != used {
+		return nil
+	}
+
+	var tokens api.Tokens
+	body := api.RefreshTokens{RefreshToken: client.tokens.RefreshToken}
+	if _, err := client.send(ctx, request{method: "POST", path: "/api/v1/tokens/refresh", body: body, anonymous: true}, &tokens); err != nil {
+		return err
+	}
+	client.tokens = &tokens
+
+	return nil
+}
+
+// request is a call of the API.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   interface{}
+	// header is added to Client.Header
+	header http.Header
+	// anonymous requests are not signed in with the tokens
+	anonymous bool
+}
+
+// do sends a request with body as JSON, if it isn't nil, and decodes the
+// answer into result.
+func (client *Client) do(ctx context.Context, method, path string, query url.Values, body interface{}, result interface{}) error {
+	_, err := client.send(ctx, request{method: method, path: path, query: query, body: body}, result)
+	return err
+}
+
+// send sends req, repeats it if it failed on the way and refreshes an
+// expired access token once. It returns the header of the answer.
+func (client *Client) send(ctx context.Context, req request, result interface{}) (http.Header, error) {
+	var encoded []byte
+	if req.body != nil {
+		var err error
+		if encoded, err = json.Marshal(req.body); err != nil {
+			return nil, err
+		}
+	}
+
+	token := ""
+	if !req.anonymous {
+		token = client.accessToken()
+	}
+
+	refreshed := false
+	for attempt := 0; ; attempt++ {
+		resp, content, err := client.roundTrip(ctx, req, encoded, token)
+		if err == nil && resp.StatusCode == http.StatusUnauthorized && token != "" && !refreshed {
+			if err := client.refresh(ctx, token); err != nil {
+				return nil, err
+			}
+			token, refreshed = client.accessToken(), true
+			attempt--
+			continue
+		}
+
+		if attempt < client.Retries && repeatable(req.method) {
+			if wait, retry := client.retryWait(attempt, resp, err); retry {
+				select {
+				case <-time.After(wait):
+					continue
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return resp.Header, errorOf(resp.StatusCode, content)
+		}
+		if len(bytes.TrimSpace(content)) == 0 {
+			return resp.Header, nil
+		}
+
+		return resp.Header, json.Unmarshal(content, result)
+	}
+}
+
+// roundTrip sends req once and reads the whole answer.
+func (client *Client) roundTrip(ctx context.Context, req request, body []byte, token string) (*http.Response, []byte, error) {
+	target := client.BaseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequest(req.method, target, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, header := range []http.Header{client.Header, req.header} {
+		for name, values := range header {
+			httpReq.Header[name] = values
+		}
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	httpClient := client.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resp, content, nil
+}
+
+// repeatable reports whether a request with method does the same when it is
+// sent again.
+func repeatable(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE":
+		return true
+	}
+
+	return false
+}
+
+// retryWait tells whether an attempt is repeated and how long to wait
+// before.
+func (client *Client) retryWait(attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	if err == nil {
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		default:
+			return 0, false
+		}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+
+	return client.RetryWait << uint(attempt), true
+}
+
+// errorOf reads the error of the app, {"errors": "..."}, from an answer.
+func errorOf(statusCode int, content []byte) error {
+	var answer struct {
+		Errors interface{} `json:"errors"`
+	}
+	message := strings.TrimSpace(string(content))
+	if json.Unmarshal(content, &answer) == nil && answer.Errors != nil {
+		message = fmt.Sprint(answer.Errors)
+	}
+
+	return &Error{StatusCode: statusCode, Message: message}
+}
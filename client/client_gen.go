@@ -0,0 +1,804 @@
+// Code generated by apigen from package api. DO NOT EDIT.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+
+	"github.com/johscheuer/todo-app-web/api"
+)
+
+// ReadTodo calls GET /todo, it returns the titles of the todos.
+func (client *Client) ReadTodo(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/todo", nil, nil, &result)
+	return result, err
+}
+
+// InsertTodo calls POST /todo/:value, it adds a todo and returns the titles
+// of the todos.
+func (client *Client) InsertTodo(ctx context.Context, value string, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "POST", "/todo/"+url.PathEscape(value), nil, body, &result)
+	return result, err
+}
+
+// DeleteTodo calls DELETE /todo/:value, it deletes the first todo with the
+// title and returns the titles of the todos.
+func (client *Client) DeleteTodo(ctx context.Context, value string) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/todo/"+url.PathEscape(value), nil, nil, &result)
+	return result, err
+}
+
+// ListTodos calls GET /api/v1/todos. It takes the query parameters status,
+// tag, q, sort, locale, page or per_page.
+func (client *Client) ListTodos(ctx context.Context, query url.Values) ([]api.Todo, error) {
+	var result []api.Todo
+	err := client.do(ctx, "GET", "/api/v1/todos", query, nil, &result)
+	return result, err
+}
+
+// AllTodos iterates over all pages of ListTodos, query is passed on to each
+// of them.
+func (client *Client) AllTodos(query url.Values) *TodoIterator {
+	return &TodoIterator{pages: client.pages("/api/v1/todos", query)}
+}
+
+// TodoIterator reads a list of Todo page by page, see Next.
+type TodoIterator struct {
+	pages   *pages
+	page    []api.Todo
+	current api.Todo
+}
+
+// Next reads the next entry, the next page when the current one is done.
+// It returns false at the end of the list or on an error, see Err.
+func (it *TodoIterator) Next(ctx context.Context) bool {
+	for len(it.page) == 0 {
+		it.page = nil
+		if !it.pages.read(ctx, &it.page) {
+			return false
+		}
+	}
+
+	it.current, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Todo returns the entry read by Next.
+func (it *TodoIterator) Todo() api.Todo {
+	return it.current
+}
+
+// Total returns the length of the whole list, after the first Next.
+func (it *TodoIterator) Total() int {
+	return it.pages.total
+}
+
+// Err returns the error that ended Next, nil at the end of the list.
+func (it *TodoIterator) Err() error {
+	return it.pages.err
+}
+
+// UpdateTodo calls PATCH /api/v1/todos/:id.
+func (client *Client) UpdateTodo(ctx context.Context, id string, body api.TodoUpdate) (api.Todo, error) {
+	var result api.Todo
+	err := client.do(ctx, "PATCH", "/api/v1/todos/"+url.PathEscape(id), nil, body, &result)
+	return result, err
+}
+
+// DeleteTodoByID calls DELETE /api/v1/todos/:id.
+func (client *Client) DeleteTodoByID(ctx context.Context, id string) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/api/v1/todos/"+url.PathEscape(id), nil, nil, &result)
+	return result, err
+}
+
+// ListGrants calls GET /api/v1/todos/:id/grants.
+func (client *Client) ListGrants(ctx context.Context, id string) ([]api.Grant, error) {
+	var result []api.Grant
+	err := client.do(ctx, "GET", "/api/v1/todos/"+url.PathEscape(id)+"/grants", nil, nil, &result)
+	return result, err
+}
+
+// SetGrant calls PUT /api/v1/todos/:id/grants/:account.
+func (client *Client) SetGrant(ctx context.Context, id string, account string, body api.GrantRequest) (api.Grant, error) {
+	var result api.Grant
+	err := client.do(ctx, "PUT", "/api/v1/todos/"+url.PathEscape(id)+"/grants/"+url.PathEscape(account), nil, body, &result)
+	return result, err
+}
+
+// DeleteGrant calls DELETE /api/v1/todos/:id/grants/:account.
+func (client *Client) DeleteGrant(ctx context.Context, id string, account string) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/api/v1/todos/"+url.PathEscape(id)+"/grants/"+url.PathEscape(account), nil, nil, &result)
+	return result, err
+}
+
+// ListTags calls GET /api/v1/tags.
+func (client *Client) ListTags(ctx context.Context) ([]api.Tag, error) {
+	var result []api.Tag
+	err := client.do(ctx, "GET", "/api/v1/tags", nil, nil, &result)
+	return result, err
+}
+
+// Changes calls GET /api/v1/changes. It takes the query parameters list,
+// since, timeout or client.
+func (client *Client) Changes(ctx context.Context, query url.Values) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/api/v1/changes", query, nil, &result)
+	return result, err
+}
+
+// BulkTodos calls POST /api/todos/bulk.
+func (client *Client) BulkTodos(ctx context.Context, body api.BulkTodosRequest) (api.BulkTodosResponse, error) {
+	var result api.BulkTodosResponse
+	err := client.do(ctx, "POST", "/api/todos/bulk", nil, body, &result)
+	return result, err
+}
+
+// Undo calls POST /api/undo, it reverts the latest delete, update or bulk
+// operation of the account.
+func (client *Client) Undo(ctx context.Context, body interface{}) (api.UndoResult, error) {
+	var result api.UndoResult
+	err := client.do(ctx, "POST", "/api/undo", nil, body, &result)
+	return result, err
+}
+
+// ListSubTasks calls GET /api/v1/checklists/:id.
+func (client *Client) ListSubTasks(ctx context.Context, id string) ([]api.SubTask, error) {
+	var result []api.SubTask
+	err := client.do(ctx, "GET", "/api/v1/checklists/"+url.PathEscape(id), nil, nil, &result)
+	return result, err
+}
+
+// AddSubTask calls POST /api/v1/checklists/:id.
+func (client *Client) AddSubTask(ctx context.Context, id string, body api.NewSubTask) (api.SubTask, error) {
+	var result api.SubTask
+	err := client.do(ctx, "POST", "/api/v1/checklists/"+url.PathEscape(id), nil, body, &result)
+	return result, err
+}
+
+// CompleteSubTask calls POST /api/v1/checklists/:id/:subtask/complete.
+func (client *Client) CompleteSubTask(ctx context.Context, id string, subtask string, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "POST", "/api/v1/checklists/"+url.PathEscape(id)+"/"+url.PathEscape(subtask)+"/complete", nil, body, &result)
+	return result, err
+}
+
+// ListAttachments calls GET /api/v1/attachments/:id.
+func (client *Client) ListAttachments(ctx context.Context, id string) ([]api.Attachment, error) {
+	var result []api.Attachment
+	err := client.do(ctx, "GET", "/api/v1/attachments/"+url.PathEscape(id), nil, nil, &result)
+	return result, err
+}
+
+// DeleteAttachment calls DELETE /api/v1/attachments/:id/:attachment.
+func (client *Client) DeleteAttachment(ctx context.Context, id string, attachment string) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/api/v1/attachments/"+url.PathEscape(id)+"/"+url.PathEscape(attachment), nil, nil, &result)
+	return result, err
+}
+
+// GetDraft calls GET /api/v1/drafts/:id, it returns the unsaved edit of a
+// todo.
+func (client *Client) GetDraft(ctx context.Context, id string) (api.Draft, error) {
+	var result api.Draft
+	err := client.do(ctx, "GET", "/api/v1/drafts/"+url.PathEscape(id), nil, nil, &result)
+	return result, err
+}
+
+// SaveDraft calls PUT /api/v1/drafts/:id.
+func (client *Client) SaveDraft(ctx context.Context, id string, body api.Draft) (api.Draft, error) {
+	var result api.Draft
+	err := client.do(ctx, "PUT", "/api/v1/drafts/"+url.PathEscape(id), nil, body, &result)
+	return result, err
+}
+
+// DeleteDraft calls DELETE /api/v1/drafts/:id.
+func (client *Client) DeleteDraft(ctx context.Context, id string) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/api/v1/drafts/"+url.PathEscape(id), nil, nil, &result)
+	return result, err
+}
+
+// GetView calls GET /api/v1/view, it returns the view of the UI the account
+// or the browser chose.
+func (client *Client) GetView(ctx context.Context) (api.ViewPreference, error) {
+	var result api.ViewPreference
+	err := client.do(ctx, "GET", "/api/v1/view", nil, nil, &result)
+	return result, err
+}
+
+// SetView calls PUT /api/v1/view.
+func (client *Client) SetView(ctx context.Context, body api.ViewPreference) (api.ViewPreference, error) {
+	var result api.ViewPreference
+	err := client.do(ctx, "PUT", "/api/v1/view", nil, body, &result)
+	return result, err
+}
+
+// GetLocale calls GET /api/v1/locale, it returns the locale titles are
+// sorted in with ?sort=title.
+func (client *Client) GetLocale(ctx context.Context) (api.LocalePreference, error) {
+	var result api.LocalePreference
+	err := client.do(ctx, "GET", "/api/v1/locale", nil, nil, &result)
+	return result, err
+}
+
+// SetLocale calls PUT /api/v1/locale.
+func (client *Client) SetLocale(ctx context.Context, body api.LocalePreference) (api.LocalePreference, error) {
+	var result api.LocalePreference
+	err := client.do(ctx, "PUT", "/api/v1/locale", nil, body, &result)
+	return result, err
+}
+
+// ListTrash calls GET /api/v1/trash, it returns the deleted todos of the
+// account, the last deleted first.
+func (client *Client) ListTrash(ctx context.Context) ([]api.TrashedTodo, error) {
+	var result []api.TrashedTodo
+	err := client.do(ctx, "GET", "/api/v1/trash", nil, nil, &result)
+	return result, err
+}
+
+// RestoreTodo calls POST /api/v1/trash/:id/restore, it adds a deleted todo
+// to the end of the list again.
+func (client *Client) RestoreTodo(ctx context.Context, id string, body interface{}) (api.Todo, error) {
+	var result api.Todo
+	err := client.do(ctx, "POST", "/api/v1/trash/"+url.PathEscape(id)+"/restore", nil, body, &result)
+	return result, err
+}
+
+// CreateShare calls POST /share. It takes the query parameters hours.
+func (client *Client) CreateShare(ctx context.Context, query url.Values, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "POST", "/share", query, body, &result)
+	return result, err
+}
+
+// ShareInfo calls GET /share/:id.
+func (client *Client) ShareInfo(ctx context.Context, id string) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/share/"+url.PathEscape(id), nil, nil, &result)
+	return result, err
+}
+
+// RevokeShare calls DELETE /share/:id.
+func (client *Client) RevokeShare(ctx context.Context, id string) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/share/"+url.PathEscape(id), nil, nil, &result)
+	return result, err
+}
+
+// CreateShortLink calls POST /links.
+func (client *Client) CreateShortLink(ctx context.Context, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "POST", "/links", nil, body, &result)
+	return result, err
+}
+
+// ShortLink calls GET /links/:code.
+func (client *Client) ShortLink(ctx context.Context, code string) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/links/"+url.PathEscape(code), nil, nil, &result)
+	return result, err
+}
+
+// DeleteShortLink calls DELETE /links/:code.
+func (client *Client) DeleteShortLink(ctx context.Context, code string) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/links/"+url.PathEscape(code), nil, nil, &result)
+	return result, err
+}
+
+// ListSmartLists calls GET /api/v1/smartlists.
+func (client *Client) ListSmartLists(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/api/v1/smartlists", nil, nil, &result)
+	return result, err
+}
+
+// SetSmartList calls PUT /api/v1/smartlists/:id.
+func (client *Client) SetSmartList(ctx context.Context, id string, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "PUT", "/api/v1/smartlists/"+url.PathEscape(id), nil, body, &result)
+	return result, err
+}
+
+// DeleteSmartList calls DELETE /api/v1/smartlists/:id.
+func (client *Client) DeleteSmartList(ctx context.Context, id string) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/api/v1/smartlists/"+url.PathEscape(id), nil, nil, &result)
+	return result, err
+}
+
+// SmartListTodos calls GET /api/v1/smartlists/:id/todos.
+func (client *Client) SmartListTodos(ctx context.Context, id string) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/api/v1/smartlists/"+url.PathEscape(id)+"/todos", nil, nil, &result)
+	return result, err
+}
+
+// DependencyGraph calls GET /api/v1/dependencies.
+func (client *Client) DependencyGraph(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/api/v1/dependencies", nil, nil, &result)
+	return result, err
+}
+
+// AddDependency calls PUT /api/v1/dependencies.
+func (client *Client) AddDependency(ctx context.Context, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "PUT", "/api/v1/dependencies", nil, body, &result)
+	return result, err
+}
+
+// DeleteDependency calls DELETE /api/v1/dependencies.
+func (client *Client) DeleteDependency(ctx context.Context, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/api/v1/dependencies", nil, body, &result)
+	return result, err
+}
+
+// Stats calls GET /api/v1/stats.
+func (client *Client) Stats(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/api/v1/stats", nil, nil, &result)
+	return result, err
+}
+
+// Workload calls GET /api/v1/workload.
+func (client *Client) Workload(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/api/v1/workload", nil, nil, &result)
+	return result, err
+}
+
+// Calendar calls GET /api/v1/calendar.
+func (client *Client) Calendar(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/api/v1/calendar", nil, nil, &result)
+	return result, err
+}
+
+// OverdueTodos calls GET /api/v1/overdue.
+func (client *Client) OverdueTodos(ctx context.Context) ([]api.Todo, error) {
+	var result []api.Todo
+	err := client.do(ctx, "GET", "/api/v1/overdue", nil, nil, &result)
+	return result, err
+}
+
+// DueTodos calls GET /api/v1/due. It takes the query parameters before.
+func (client *Client) DueTodos(ctx context.Context, query url.Values) ([]api.Todo, error) {
+	var result []api.Todo
+	err := client.do(ctx, "GET", "/api/v1/due", query, nil, &result)
+	return result, err
+}
+
+// ListTimers calls GET /api/v1/timers.
+func (client *Client) ListTimers(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/api/v1/timers", nil, nil, &result)
+	return result, err
+}
+
+// StartTimer calls PUT /api/v1/timers.
+func (client *Client) StartTimer(ctx context.Context, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "PUT", "/api/v1/timers", nil, body, &result)
+	return result, err
+}
+
+// StopTimer calls DELETE /api/v1/timers.
+func (client *Client) StopTimer(ctx context.Context, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/api/v1/timers", nil, body, &result)
+	return result, err
+}
+
+// NewTodoTrigger calls GET /api/v1/integrations/triggers/new-todo. It takes
+// the query parameters cursor or limit.
+func (client *Client) NewTodoTrigger(ctx context.Context, query url.Values) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/api/v1/integrations/triggers/new-todo", query, nil, &result)
+	return result, err
+}
+
+// CompletedTodoTrigger calls GET
+// /api/v1/integrations/triggers/completed-todo. It takes the query
+// parameters cursor or limit.
+func (client *Client) CompletedTodoTrigger(ctx context.Context, query url.Values) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/api/v1/integrations/triggers/completed-todo", query, nil, &result)
+	return result, err
+}
+
+// CreateTodoAction calls POST /api/v1/integrations/actions/create-todo.
+func (client *Client) CreateTodoAction(ctx context.Context, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "POST", "/api/v1/integrations/actions/create-todo", nil, body, &result)
+	return result, err
+}
+
+// DeleteAllTodos calls DELETE /admin/todos.
+func (client *Client) DeleteAllTodos(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/admin/todos", nil, nil, &result)
+	return result, err
+}
+
+// PurgeTrash calls DELETE /admin/trash, it empties the trash of every
+// account from the todos deleted more than days ago. It takes the query
+// parameters days.
+func (client *Client) PurgeTrash(ctx context.Context, query url.Values) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/admin/trash", query, nil, &result)
+	return result, err
+}
+
+// SeedProfiles calls GET /admin/seed.
+func (client *Client) SeedProfiles(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/admin/seed", nil, nil, &result)
+	return result, err
+}
+
+// Seed calls POST /admin/seed.
+func (client *Client) Seed(ctx context.Context, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "POST", "/admin/seed", nil, body, &result)
+	return result, err
+}
+
+// ListFeatures calls GET /admin/features.
+func (client *Client) ListFeatures(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/admin/features", nil, nil, &result)
+	return result, err
+}
+
+// SetFeature calls PUT /admin/features/:name.
+func (client *Client) SetFeature(ctx context.Context, name string, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "PUT", "/admin/features/"+url.PathEscape(name), nil, body, &result)
+	return result, err
+}
+
+// Logs calls GET /admin/logs.
+func (client *Client) Logs(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/admin/logs", nil, nil, &result)
+	return result, err
+}
+
+// GetLogLevel calls GET /admin/loglevel.
+func (client *Client) GetLogLevel(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/admin/loglevel", nil, nil, &result)
+	return result, err
+}
+
+// SetLogLevel calls PUT /admin/loglevel.
+func (client *Client) SetLogLevel(ctx context.Context, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "PUT", "/admin/loglevel", nil, body, &result)
+	return result, err
+}
+
+// SlowLog calls GET /admin/slowlog.
+func (client *Client) SlowLog(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/admin/slowlog", nil, nil, &result)
+	return result, err
+}
+
+// FailoverDrill calls POST /admin/drills/failover.
+func (client *Client) FailoverDrill(ctx context.Context, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "POST", "/admin/drills/failover", nil, body, &result)
+	return result, err
+}
+
+// GetBoard calls GET /admin/board.
+func (client *Client) GetBoard(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/admin/board", nil, nil, &result)
+	return result, err
+}
+
+// SetBoard calls PUT /admin/board.
+func (client *Client) SetBoard(ctx context.Context, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "PUT", "/admin/board", nil, body, &result)
+	return result, err
+}
+
+// SendDigest calls POST /admin/digest.
+func (client *Client) SendDigest(ctx context.Context, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "POST", "/admin/digest", nil, body, &result)
+	return result, err
+}
+
+// TelemetryPreview calls GET /admin/telemetry.
+func (client *Client) TelemetryPreview(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/admin/telemetry", nil, nil, &result)
+	return result, err
+}
+
+// Sbom calls GET /admin/sbom.
+func (client *Client) Sbom(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/admin/sbom", nil, nil, &result)
+	return result, err
+}
+
+// CreateAccount calls POST /admin/accounts.
+func (client *Client) CreateAccount(ctx context.Context, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "POST", "/admin/accounts", nil, body, &result)
+	return result, err
+}
+
+// GetAccount calls GET /admin/accounts/:name.
+func (client *Client) GetAccount(ctx context.Context, name string) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/admin/accounts/"+url.PathEscape(name), nil, nil, &result)
+	return result, err
+}
+
+// DisableAccount calls POST /admin/accounts/:name/disable.
+func (client *Client) DisableAccount(ctx context.Context, name string, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "POST", "/admin/accounts/"+url.PathEscape(name)+"/disable", nil, body, &result)
+	return result, err
+}
+
+// EnableAccount calls POST /admin/accounts/:name/enable.
+func (client *Client) EnableAccount(ctx context.Context, name string, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "POST", "/admin/accounts/"+url.PathEscape(name)+"/enable", nil, body, &result)
+	return result, err
+}
+
+// ResetTwoFactor calls DELETE /admin/accounts/:name/2fa.
+func (client *Client) ResetTwoFactor(ctx context.Context, name string) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/admin/accounts/"+url.PathEscape(name)+"/2fa", nil, nil, &result)
+	return result, err
+}
+
+// AccountSessions calls GET /admin/accounts/:name/sessions.
+func (client *Client) AccountSessions(ctx context.Context, name string) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/admin/accounts/"+url.PathEscape(name)+"/sessions", nil, nil, &result)
+	return result, err
+}
+
+// RevokeAccountSessions calls DELETE /admin/accounts/:name/sessions.
+func (client *Client) RevokeAccountSessions(ctx context.Context, name string) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/admin/accounts/"+url.PathEscape(name)+"/sessions", nil, nil, &result)
+	return result, err
+}
+
+// UnlockAccount calls DELETE /admin/accounts/:name/lockout.
+func (client *Client) UnlockAccount(ctx context.Context, name string) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/admin/accounts/"+url.PathEscape(name)+"/lockout", nil, nil, &result)
+	return result, err
+}
+
+// UnlockIP calls DELETE /admin/lockouts/:ip.
+func (client *Client) UnlockIP(ctx context.Context, ip string) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/admin/lockouts/"+url.PathEscape(ip), nil, nil, &result)
+	return result, err
+}
+
+// AuditLog calls GET /admin/audit.
+func (client *Client) AuditLog(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/admin/audit", nil, nil, &result)
+	return result, err
+}
+
+// RotateSigningKey calls POST /admin/jwt/rotate.
+func (client *Client) RotateSigningKey(ctx context.Context, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "POST", "/admin/jwt/rotate", nil, body, &result)
+	return result, err
+}
+
+// ListHooks calls GET /admin/hooks.
+func (client *Client) ListHooks(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/admin/hooks", nil, nil, &result)
+	return result, err
+}
+
+// DeleteHook calls DELETE /admin/hooks/:name.
+func (client *Client) DeleteHook(ctx context.Context, name string) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/admin/hooks/"+url.PathEscape(name), nil, nil, &result)
+	return result, err
+}
+
+// RegisterAccount calls POST /api/v1/accounts.
+func (client *Client) RegisterAccount(ctx context.Context, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "POST", "/api/v1/accounts", nil, body, &result)
+	return result, err
+}
+
+// RequestPasswordReset calls POST /api/v1/password-resets.
+func (client *Client) RequestPasswordReset(ctx context.Context, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "POST", "/api/v1/password-resets", nil, body, &result)
+	return result, err
+}
+
+// ResetPassword calls PUT /api/v1/password-resets/:token.
+func (client *Client) ResetPassword(ctx context.Context, token string, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "PUT", "/api/v1/password-resets/"+url.PathEscape(token), nil, body, &result)
+	return result, err
+}
+
+// UnlockWithToken calls POST /api/v1/unlocks/:token.
+func (client *Client) UnlockWithToken(ctx context.Context, token string, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "POST", "/api/v1/unlocks/"+url.PathEscape(token), nil, body, &result)
+	return result, err
+}
+
+// RefreshTokens calls POST /api/v1/tokens/refresh.
+func (client *Client) RefreshTokens(ctx context.Context, body api.RefreshTokens) (api.Tokens, error) {
+	var result api.Tokens
+	err := client.do(ctx, "POST", "/api/v1/tokens/refresh", nil, body, &result)
+	return result, err
+}
+
+// OwnAccount calls GET /api/v1/account.
+func (client *Client) OwnAccount(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/api/v1/account", nil, nil, &result)
+	return result, err
+}
+
+// AccountUsage calls GET /api/v1/account/usage, it reports the number and
+// size of the todos of the account.
+func (client *Client) AccountUsage(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/api/v1/account/usage", nil, nil, &result)
+	return result, err
+}
+
+// EnrollTwoFactor calls POST /api/v1/account/2fa.
+func (client *Client) EnrollTwoFactor(ctx context.Context, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "POST", "/api/v1/account/2fa", nil, body, &result)
+	return result, err
+}
+
+// ConfirmTwoFactor calls POST /api/v1/account/2fa/confirm.
+func (client *Client) ConfirmTwoFactor(ctx context.Context, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "POST", "/api/v1/account/2fa/confirm", nil, body, &result)
+	return result, err
+}
+
+// NewRecoveryCodes calls POST /api/v1/account/2fa/recovery-codes.
+func (client *Client) NewRecoveryCodes(ctx context.Context, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "POST", "/api/v1/account/2fa/recovery-codes", nil, body, &result)
+	return result, err
+}
+
+// DisableTwoFactor calls DELETE /api/v1/account/2fa.
+func (client *Client) DisableTwoFactor(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/api/v1/account/2fa", nil, nil, &result)
+	return result, err
+}
+
+// CreateSession calls POST /api/v1/sessions.
+func (client *Client) CreateSession(ctx context.Context, body interface{}) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "POST", "/api/v1/sessions", nil, body, &result)
+	return result, err
+}
+
+// ListSessions calls GET /api/v1/sessions.
+func (client *Client) ListSessions(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/api/v1/sessions", nil, nil, &result)
+	return result, err
+}
+
+// RevokeSessions calls DELETE /api/v1/sessions.
+func (client *Client) RevokeSessions(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/api/v1/sessions", nil, nil, &result)
+	return result, err
+}
+
+// RevokeSession calls DELETE /api/v1/sessions/:id.
+func (client *Client) RevokeSession(ctx context.Context, id string) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "DELETE", "/api/v1/sessions/"+url.PathEscape(id), nil, nil, &result)
+	return result, err
+}
+
+// CreateTokens calls POST /api/v1/tokens.
+func (client *Client) CreateTokens(ctx context.Context, body interface{}) (api.Tokens, error) {
+	var result api.Tokens
+	err := client.do(ctx, "POST", "/api/v1/tokens", nil, body, &result)
+	return result, err
+}
+
+// ListSharedTodos calls GET /api/v1/granted/todos.
+func (client *Client) ListSharedTodos(ctx context.Context) ([]api.SharedTodo, error) {
+	var result []api.SharedTodo
+	err := client.do(ctx, "GET", "/api/v1/granted/todos", nil, nil, &result)
+	return result, err
+}
+
+// GetSharedTodo calls GET /api/v1/granted/todos/:id.
+func (client *Client) GetSharedTodo(ctx context.Context, id string) (api.SharedTodo, error) {
+	var result api.SharedTodo
+	err := client.do(ctx, "GET", "/api/v1/granted/todos/"+url.PathEscape(id), nil, nil, &result)
+	return result, err
+}
+
+// UpdateSharedTodo calls PATCH /api/v1/granted/todos/:id.
+func (client *Client) UpdateSharedTodo(ctx context.Context, id string, body api.SharedTodoUpdate) (api.SharedTodo, error) {
+	var result api.SharedTodo
+	err := client.do(ctx, "PATCH", "/api/v1/granted/todos/"+url.PathEscape(id), nil, body, &result)
+	return result, err
+}
+
+// Usage calls GET /usage.
+func (client *Client) Usage(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/usage", nil, nil, &result)
+	return result, err
+}
+
+// HealthCheck calls GET /health.
+func (client *Client) HealthCheck(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/health", nil, nil, &result)
+	return result, err
+}
+
+// WhoAmI calls GET /whoami.
+func (client *Client) WhoAmI(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/whoami", nil, nil, &result)
+	return result, err
+}
+
+// Version calls GET /version.
+func (client *Client) Version(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/version", nil, nil, &result)
+	return result, err
+}
+
+// Jwks calls GET /.well-known/jwks.json.
+func (client *Client) Jwks(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/.well-known/jwks.json", nil, nil, &result)
+	return result, err
+}
+
+// Self calls GET /api/v1/debug/self.
+func (client *Client) Self(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := client.do(ctx, "GET", "/api/v1/debug/self", nil, nil, &result)
+	return result, err
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// pages reads a paged list, the iterators of client_gen.go take their
+// entries from it.
+type pages struct {
+	client *Client
+	path   string
+	query  url.Values
+	// page is the next page to read, 0 after the last one
+	page  int
+	total int
+	err   error
+}
+
+// pages starts reading the list at path from the page in query, the first
+// one if it has none.
+func (client *Client) pages(path string, query url.Values) *pages {
+	copied := url.Values{}
+	for name, values := range query {
+		copied[name] = values
+	}
+
+	page, err := strconv.Atoi(copied.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	return &pages{client: client, path: path, query: copied, page: page}
+}
+
+// read decodes the next page into list, it returns false after the last
+// one or on an error.
+func (p *pages) read(ctx context.Context, list interface{}) bool {
+	if p.err != nil || p.page == 0 {
+		return false
+	}
+
+	p.query.Set("page", strconv.Itoa(p.page))
+	header, err := p.client.send(ctx, request{method: "GET", path: p.path, query: p.query}, list)
+	if err != nil {
+		p.err = err
+		return false
+	}
+
+	p.total, _ = strconv.Atoi(header.Get("X-Total-Count"))
+	p.page++
+	if !hasNext(header) {
+		p.page = 0
+	}
+
+	return true
+}
+
+// hasNext reports whether the Link header of a page points to a next one.
+func hasNext(header http.Header) bool {
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		if strings.HasSuffix(strings.TrimSpace(link), `rel="next"`) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,728 @@
+// Code generated by apigen from package api. DO NOT EDIT.
+
+/** Todo is a single entry of the list. */
+export interface Todo {
+  id: string;
+  title: string;
+  description?: string;
+  createdAt: string;
+  updatedAt: string;
+  done: boolean;
+  due?: string;
+  /** from 0 (none) to 9 (high) */
+  priority?: number;
+  tags?: string[];
+  subTasks?: SubTask[];
+  /** daily, weekly or a cron expression */
+  recurrence?: string;
+  attachments?: Attachment[];
+  /** the expected effort in minutes */
+  estimate?: number;
+  /** the issues the todo is mirrored to */
+  remote?: RemoteLink[];
+  /** the account that created the todo, empty for todos everyone can see */
+  owner?: string;
+}
+
+/** RemoteLink points to the issue a todo is mirrored to. */
+export interface RemoteLink {
+  /** like github:owner/repo or jira:PROJ */
+  tracker: string;
+  /** the issue number or key */
+  id: string;
+  url?: string;
+  hash?: string;
+  syncedAt: string;
+}
+
+/** Attachment describes a file attached to a todo. */
+export interface Attachment {
+  id: string;
+  name: string;
+  contentType: string;
+  /** in bytes */
+  size: number;
+  /** the hex SHA-256 digest of the content, attachments with the same one share it */
+  sha256?: string;
+  /** clean or quarantined, empty if the upload wasn't scanned */
+  scan?: string;
+  /** what the scan found in a quarantined attachment */
+  threat?: string;
+  createdAt: string;
+}
+
+/** SubTask is an item of the checklist of a todo. */
+export interface SubTask {
+  id: string;
+  title: string;
+  done: boolean;
+}
+
+/** TodoUpdate holds the fields of a todo to change. */
+export interface TodoUpdate {
+  title?: string;
+  /** replace the description, empty removes it */
+  description?: string;
+  done?: boolean;
+  /** a day or time, empty removes the due date */
+  due?: string;
+  /** none, low, medium, high or 0 to 9 */
+  priority?: unknown;
+  /** replace the tags, empty removes them */
+  tags?: string[];
+  /** the new place in the whole list, counted from 0 */
+  position?: number;
+  /** daily, weekly or a cron expression, empty ends the recurrence */
+  recurrence?: string;
+  /** the expected effort like 30m or 1h30m, empty removes the estimate */
+  estimate?: string;
+}
+
+/** Tag is a tag in use and the number of todos tagged with it. */
+export interface Tag {
+  name: string;
+  todos: number;
+}
+
+/** NewSubTask adds an open sub-task to the end of a checklist. */
+export interface NewSubTask {
+  title: string;
+}
+
+/** Grant is the permission of an account on a single todo. */
+export interface Grant {
+  account: string;
+  permission: "read" | "write";
+}
+
+/** GrantRequest shares a todo with an account. */
+export interface GrantRequest {
+  permission: "read" | "write";
+}
+
+/** SharedTodo is a todo as seen by an account it is shared with. */
+export interface SharedTodo extends Todo {
+  permission: "read" | "write";
+}
+
+/** SharedTodoUpdate changes a shared todo, with the write permission. */
+export interface SharedTodoUpdate {
+  title?: string;
+  done?: boolean;
+}
+
+/** Draft is an unsaved edit of a todo, the fields of the edit form. */
+export interface Draft {
+  title?: string;
+  description?: string;
+  due?: string;
+  tags?: string[];
+  /** set by the server */
+  savedAt: string;
+}
+
+/** TrashedTodo is a deleted todo in the trash, until it is restored or purged. */
+export interface TrashedTodo extends Todo {
+  deletedAt: string;
+}
+
+/** ViewPreference is the view of the UI the account or the browser chose. */
+export interface ViewPreference {
+  view: "default" | "basic" | "high-contrast" | "screen-reader";
+}
+
+/** LocalePreference is the locale the account or the browser sorts titles in. */
+export interface LocalePreference {
+  /** a language tag like de or sv-FI */
+  locale: string;
+}
+
+/** UndoResult names the undone operation and has the todos it put back. */
+export interface UndoResult {
+  undone: "deleted" | "updated" | "bulk";
+  at: string;
+  todos: Todo[];
+}
+
+/** Tokens are an access token and the refresh token to get the next one. */
+export interface Tokens {
+  access_token: string;
+  token_type: string;
+  /** seconds until the access token expires */
+  expires_in: number;
+  refresh_token: string;
+}
+
+/** RefreshTokens exchanges a refresh token for new tokens. */
+export interface RefreshTokens {
+  refresh_token: string;
+}
+
+/** BulkTodosRequest creates todos from their titles and completes and deletes todos by their ids. */
+export interface BulkTodosRequest {
+  create?: string[];
+  delete?: string[];
+  complete?: string[];
+}
+
+/** BulkTodosResponse has the created todos and the ids that had a todo to complete or delete. */
+export interface BulkTodosResponse {
+  created: Todo[];
+  completed: string[];
+  deleted: string[];
+}
+
+/** TodoAppError is an answer other than 2xx, with the message of the server. */
+export class TodoAppError extends Error {
+  constructor(public status: number, message: string) {
+    super(message);
+  }
+}
+
+/** TodoAppClient calls the todo API, baseURL is empty for the same origin. */
+export class TodoAppClient {
+  constructor(private baseURL: string = "", private headers: Record<string, string> = {}) {}
+
+  private async request<T>(method: string, path: string, query?: Record<string, string>, body?: unknown): Promise<T> {
+    let url = this.baseURL + path;
+    if (query && Object.keys(query).length > 0) {
+      url += "?" + new URLSearchParams(query).toString();
+    }
+    const headers: Record<string, string> = { Accept: "application/json", ...this.headers };
+    if (body !== undefined) {
+      headers["Content-Type"] = "application/json";
+    }
+    const response = await fetch(url, { method, headers, body: body === undefined ? undefined : JSON.stringify(body) });
+    const text = await response.text();
+    const value = text ? JSON.parse(text) : undefined;
+    if (!response.ok) {
+      throw new TodoAppError(response.status, (value && value.errors) || response.statusText);
+    }
+    return value as T;
+  }
+
+  /** GET /todo, returns the titles of the todos */
+  readTodo(): Promise<unknown> {
+    return this.request("GET", "/todo");
+  }
+
+  /** POST /todo/:value, adds a todo and returns the titles of the todos */
+  insertTodo(value: string, body?: unknown): Promise<unknown> {
+    return this.request("POST", `/todo/${encodeURIComponent(value)}`, undefined, body);
+  }
+
+  /** DELETE /todo/:value, deletes the first todo with the title and returns the titles of the todos */
+  deleteTodo(value: string): Promise<unknown> {
+    return this.request("DELETE", `/todo/${encodeURIComponent(value)}`);
+  }
+
+  /** GET /api/v1/todos */
+  listTodos(query: Partial<Record<"locale" | "page" | "per_page" | "q" | "sort" | "status" | "tag", string>> = {}): Promise<Todo[]> {
+    return this.request("GET", "/api/v1/todos", query as Record<string, string>);
+  }
+
+  /** PATCH /api/v1/todos/:id */
+  updateTodo(id: string, body: TodoUpdate): Promise<Todo> {
+    return this.request("PATCH", `/api/v1/todos/${encodeURIComponent(id)}`, undefined, body);
+  }
+
+  /** DELETE /api/v1/todos/:id */
+  deleteTodoByID(id: string): Promise<unknown> {
+    return this.request("DELETE", `/api/v1/todos/${encodeURIComponent(id)}`);
+  }
+
+  /** GET /api/v1/todos/:id/grants */
+  listGrants(id: string): Promise<Grant[]> {
+    return this.request("GET", `/api/v1/todos/${encodeURIComponent(id)}/grants`);
+  }
+
+  /** PUT /api/v1/todos/:id/grants/:account */
+  setGrant(id: string, account: string, body: GrantRequest): Promise<Grant> {
+    return this.request("PUT", `/api/v1/todos/${encodeURIComponent(id)}/grants/${encodeURIComponent(account)}`, undefined, body);
+  }
+
+  /** DELETE /api/v1/todos/:id/grants/:account */
+  deleteGrant(id: string, account: string): Promise<unknown> {
+    return this.request("DELETE", `/api/v1/todos/${encodeURIComponent(id)}/grants/${encodeURIComponent(account)}`);
+  }
+
+  /** GET /api/v1/tags */
+  listTags(): Promise<Tag[]> {
+    return this.request("GET", "/api/v1/tags");
+  }
+
+  /** GET /api/v1/changes */
+  changes(query: Partial<Record<"client" | "list" | "since" | "timeout", string>> = {}): Promise<unknown> {
+    return this.request("GET", "/api/v1/changes", query as Record<string, string>);
+  }
+
+  /** POST /api/todos/bulk */
+  bulkTodos(body: BulkTodosRequest): Promise<BulkTodosResponse> {
+    return this.request("POST", "/api/todos/bulk", undefined, body);
+  }
+
+  /** POST /api/undo, reverts the latest delete, update or bulk operation of the account */
+  undo(body?: unknown): Promise<UndoResult> {
+    return this.request("POST", "/api/undo", undefined, body);
+  }
+
+  /** GET /api/v1/checklists/:id */
+  listSubTasks(id: string): Promise<SubTask[]> {
+    return this.request("GET", `/api/v1/checklists/${encodeURIComponent(id)}`);
+  }
+
+  /** POST /api/v1/checklists/:id */
+  addSubTask(id: string, body: NewSubTask): Promise<SubTask> {
+    return this.request("POST", `/api/v1/checklists/${encodeURIComponent(id)}`, undefined, body);
+  }
+
+  /** POST /api/v1/checklists/:id/:subtask/complete */
+  completeSubTask(id: string, subtask: string, body?: unknown): Promise<unknown> {
+    return this.request("POST", `/api/v1/checklists/${encodeURIComponent(id)}/${encodeURIComponent(subtask)}/complete`, undefined, body);
+  }
+
+  /** GET /api/v1/attachments/:id */
+  listAttachments(id: string): Promise<Attachment[]> {
+    return this.request("GET", `/api/v1/attachments/${encodeURIComponent(id)}`);
+  }
+
+  /** DELETE /api/v1/attachments/:id/:attachment */
+  deleteAttachment(id: string, attachment: string): Promise<unknown> {
+    return this.request("DELETE", `/api/v1/attachments/${encodeURIComponent(id)}/${encodeURIComponent(attachment)}`);
+  }
+
+  /** GET /api/v1/drafts/:id, returns the unsaved edit of a todo */
+  getDraft(id: string): Promise<Draft> {
+    return this.request("GET", `/api/v1/drafts/${encodeURIComponent(id)}`);
+  }
+
+  /** PUT /api/v1/drafts/:id */
+  saveDraft(id: string, body: Draft): Promise<Draft> {
+    return this.request("PUT", `/api/v1/drafts/${encodeURIComponent(id)}`, undefined, body);
+  }
+
+  /** DELETE /api/v1/drafts/:id */
+  deleteDraft(id: string): Promise<unknown> {
+    return this.request("DELETE", `/api/v1/drafts/${encodeURIComponent(id)}`);
+  }
+
+  /** GET /api/v1/view, returns the view of the UI the account or the browser chose */
+  getView(): Promise<ViewPreference> {
+    return this.request("GET", "/api/v1/view");
+  }
+
+  /** PUT /api/v1/view */
+  setView(body: ViewPreference): Promise<ViewPreference> {
+    return this.request("PUT", "/api/v1/view", undefined, body);
+  }
+
+  /** GET /api/v1/locale, returns the locale titles are sorted in with ?sort=title */
+  getLocale(): Promise<LocalePreference> {
+    return this.request("GET", "/api/v1/locale");
+  }
+
+  /** PUT /api/v1/locale */
+  setLocale(body: LocalePreference): Promise<LocalePreference> {
+    return this.request("PUT", "/api/v1/locale", undefined, body);
+  }
+
+  /** GET /api/v1/trash, returns the deleted todos of the account, the last deleted first */
+  listTrash(): Promise<TrashedTodo[]> {
+    return this.request("GET", "/api/v1/trash");
+  }
+
+  /** POST /api/v1/trash/:id/restore, adds a deleted todo to the end of the list again */
+  restoreTodo(id: string, body?: unknown): Promise<Todo> {
+    return this.request("POST", `/api/v1/trash/${encodeURIComponent(id)}/restore`, undefined, body);
+  }
+
+  /** POST /share */
+  createShare(query: Partial<Record<"hours", string>> = {}, body?: unknown): Promise<unknown> {
+    return this.request("POST", "/share", query as Record<string, string>, body);
+  }
+
+  /** GET /share/:id */
+  shareInfo(id: string): Promise<unknown> {
+    return this.request("GET", `/share/${encodeURIComponent(id)}`);
+  }
+
+  /** DELETE /share/:id */
+  revokeShare(id: string): Promise<unknown> {
+    return this.request("DELETE", `/share/${encodeURIComponent(id)}`);
+  }
+
+  /** POST /links */
+  createShortLink(body?: unknown): Promise<unknown> {
+    return this.request("POST", "/links", undefined, body);
+  }
+
+  /** GET /links/:code */
+  shortLink(code: string): Promise<unknown> {
+    return this.request("GET", `/links/${encodeURIComponent(code)}`);
+  }
+
+  /** DELETE /links/:code */
+  deleteShortLink(code: string): Promise<unknown> {
+    return this.request("DELETE", `/links/${encodeURIComponent(code)}`);
+  }
+
+  /** GET /api/v1/smartlists */
+  listSmartLists(): Promise<unknown> {
+    return this.request("GET", "/api/v1/smartlists");
+  }
+
+  /** PUT /api/v1/smartlists/:id */
+  setSmartList(id: string, body?: unknown): Promise<unknown> {
+    return this.request("PUT", `/api/v1/smartlists/${encodeURIComponent(id)}`, undefined, body);
+  }
+
+  /** DELETE /api/v1/smartlists/:id */
+  deleteSmartList(id: string): Promise<unknown> {
+    return this.request("DELETE", `/api/v1/smartlists/${encodeURIComponent(id)}`);
+  }
+
+  /** GET /api/v1/smartlists/:id/todos */
+  smartListTodos(id: string): Promise<unknown> {
+    return this.request("GET", `/api/v1/smartlists/${encodeURIComponent(id)}/todos`);
+  }
+
+  /** GET /api/v1/dependencies */
+  dependencyGraph(): Promise<unknown> {
+    return this.request("GET", "/api/v1/dependencies");
+  }
+
+  /** PUT /api/v1/dependencies */
+  addDependency(body?: unknown): Promise<unknown> {
+    return this.request("PUT", "/api/v1/dependencies", undefined, body);
+  }
+
+  /** DELETE /api/v1/dependencies */
+  deleteDependency(body?: unknown): Promise<unknown> {
+    return this.request("DELETE", "/api/v1/dependencies", undefined, body);
+  }
+
+  /** GET /api/v1/stats */
+  stats(): Promise<unknown> {
+    return this.request("GET", "/api/v1/stats");
+  }
+
+  /** GET /api/v1/workload */
+  workload(): Promise<unknown> {
+    return this.request("GET", "/api/v1/workload");
+  }
+
+  /** GET /api/v1/calendar */
+  calendar(): Promise<unknown> {
+    return this.request("GET", "/api/v1/calendar");
+  }
+
+  /** GET /api/v1/overdue */
+  overdueTodos(): Promise<Todo[]> {
+    return this.request("GET", "/api/v1/overdue");
+  }
+
+  /** GET /api/v1/due */
+  dueTodos(query: Partial<Record<"before", string>> = {}): Promise<Todo[]> {
+    return this.request("GET", "/api/v1/due", query as Record<string, string>);
+  }
+
+  /** GET /api/v1/timers */
+  listTimers(): Promise<unknown> {
+    return this.request("GET", "/api/v1/timers");
+  }
+
+  /** PUT /api/v1/timers */
+  startTimer(body?: unknown): Promise<unknown> {
+    return this.request("PUT", "/api/v1/timers", undefined, body);
+  }
+
+  /** DELETE /api/v1/timers */
+  stopTimer(body?: unknown): Promise<unknown> {
+    return this.request("DELETE", "/api/v1/timers", undefined, body);
+  }
+
+  /** GET /api/v1/integrations/triggers/new-todo */
+  newTodoTrigger(query: Partial<Record<"cursor" | "limit", string>> = {}): Promise<unknown> {
+    return this.request("GET", "/api/v1/integrations/triggers/new-todo", query as Record<string, string>);
+  }
+
+  /** GET /api/v1/integrations/triggers/completed-todo */
+  completedTodoTrigger(query: Partial<Record<"cursor" | "limit", string>> = {}): Promise<unknown> {
+    return this.request("GET", "/api/v1/integrations/triggers/completed-todo", query as Record<string, string>);
+  }
+
+  /** POST /api/v1/integrations/actions/create-todo */
+  createTodoAction(body?: unknown): Promise<unknown> {
+    return this.request("POST", "/api/v1/integrations/actions/create-todo", undefined, body);
+  }
+
+  /** DELETE /admin/todos */
+  deleteAllTodos(): Promise<unknown> {
+    return this.request("DELETE", "/admin/todos");
+  }
+
+  /** DELETE /admin/trash, empties the trash of every account from the todos deleted more than days ago */
+  purgeTrash(query: Partial<Record<"days", string>> = {}): Promise<unknown> {
+    return this.request("DELETE", "/admin/trash", query as Record<string, string>);
+  }
+
+  /** GET /admin/seed */
+  seedProfiles(): Promise<unknown> {
+    return this.request("GET", "/admin/seed");
+  }
+
+  /** POST /admin/seed */
+  seed(body?: unknown): Promise<unknown> {
+    return this.request("POST", "/admin/seed", undefined, body);
+  }
+
+  /** GET /admin/features */
+  listFeatures(): Promise<unknown> {
+    return this.request("GET", "/admin/features");
+  }
+
+  /** PUT /admin/features/:name */
+  setFeature(name: string, body?: unknown): Promise<unknown> {
+    return this.request("PUT", `/admin/features/${encodeURIComponent(name)}`, undefined, body);
+  }
+
+  /** GET /admin/logs */
+  logs(): Promise<unknown> {
+    return this.request("GET", "/admin/logs");
+  }
+
+  /** GET /admin/loglevel */
+  getLogLevel(): Promise<unknown> {
+    return this.request("GET", "/admin/loglevel");
+  }
+
+  /** PUT /admin/loglevel */
+  setLogLevel(body?: unknown): Promise<unknown> {
+    return this.request("PUT", "/admin/loglevel", undefined, body);
+  }
+
+  /** GET /admin/slowlog */
+  slowLog(): Promise<unknown> {
+    return this.request("GET", "/admin/slowlog");
+  }
+
+  /** POST /admin/drills/failover */
+  failoverDrill(body?: unknown): Promise<unknown> {
+    return this.request("POST", "/admin/drills/failover", undefined, body);
+  }
+
+  /** GET /admin/board */
+  getBoard(): Promise<unknown> {
+    return this.request("GET", "/admin/board");
+  }
+
+  /** PUT /admin/board */
+  setBoard(body?: unknown): Promise<unknown> {
+    return this.request("PUT", "/admin/board", undefined, body);
+  }
+
+  /** POST /admin/digest */
+  sendDigest(body?: unknown): Promise<unknown> {
+    return this.request("POST", "/admin/digest", undefined, body);
+  }
+
+  /** GET /admin/telemetry */
+  telemetryPreview(): Promise<unknown> {
+    return this.request("GET", "/admin/telemetry");
+  }
+
+  /** GET /admin/sbom */
+  sbom(): Promise<unknown> {
+    return this.request("GET", "/admin/sbom");
+  }
+
+  /** POST /admin/accounts */
+  createAccount(body?: unknown): Promise<unknown> {
+    return this.request("POST", "/admin/accounts", undefined, body);
+  }
+
+  /** GET /admin/accounts/:name */
+  getAccount(name: string): Promise<unknown> {
+    return this.request("GET", `/admin/accounts/${encodeURIComponent(name)}`);
+  }
+
+  /** POST /admin/accounts/:name/disable */
+  disableAccount(name: string, body?: unknown): Promise<unknown> {
+    return this.request("POST", `/admin/accounts/${encodeURIComponent(name)}/disable`, undefined, body);
+  }
+
+  /** POST /admin/accounts/:name/enable */
+  enableAccount(name: string, body?: unknown): Promise<unknown> {
+    return this.request("POST", `/admin/accounts/${encodeURIComponent(name)}/enable`, undefined, body);
+  }
+
+  /** DELETE /admin/accounts/:name/2fa */
+  resetTwoFactor(name: string): Promise<unknown> {
+    return this.request("DELETE", `/admin/accounts/${encodeURIComponent(name)}/2fa`);
+  }
+
+  /** GET /admin/accounts/:name/sessions */
+  accountSessions(name: string): Promise<unknown> {
+    return this.request("GET", `/admin/accounts/${encodeURIComponent(name)}/sessions`);
+  }
+
+  /** DELETE /admin/accounts/:name/sessions */
+  revokeAccountSessions(name: string): Promise<unknown> {
+    return this.request("DELETE", `/admin/accounts/${encodeURIComponent(name)}/sessions`);
+  }
+
+  /** DELETE /admin/accounts/:name/lockout */
+  unlockAccount(name: string): Promise<unknown> {
+    return this.request("DELETE", `/admin/accounts/${encodeURIComponent(name)}/lockout`);
+  }
+
+  /** DELETE /admin/lockouts/:ip */
+  unlockIP(ip: string): Promise<unknown> {
+    return this.request("DELETE", `/admin/lockouts/${encodeURIComponent(ip)}`);
+  }
+
+  /** GET /admin/audit */
+  auditLog(): Promise<unknown> {
+    return this.request("GET", "/admin/audit");
+  }
+
+  /** POST /admin/jwt/rotate */
+  rotateSigningKey(body?: unknown): Promise<unknown> {
+    return this.request("POST", "/admin/jwt/rotate", undefined, body);
+  }
+
+  /** GET /admin/hooks */
+  listHooks(): Promise<unknown> {
+    return this.request("GET", "/admin/hooks");
+  }
+
+  /** DELETE /admin/hooks/:name */
+  deleteHook(name: string): Promise<unknown> {
+    return this.request("DELETE", `/admin/hooks/${encodeURIComponent(name)}`);
+  }
+
+  /** POST /api/v1/accounts */
+  registerAccount(body?: unknown): Promise<unknown> {
+    return this.request("POST", "/api/v1/accounts", undefined, body);
+  }
+
+  /** POST /api/v1/password-resets */
+  requestPasswordReset(body?: unknown): Promise<unknown> {
+    return this.request("POST", "/api/v1/password-resets", undefined, body);
+  }
+
+  /** PUT /api/v1/password-resets/:token */
+  resetPassword(token: string, body?: unknown): Promise<unknown> {
+    return this.request("PUT", `/api/v1/password-resets/${encodeURIComponent(token)}`, undefined, body);
+  }
+
+  /** POST /api/v1/unlocks/:token */
+  unlockWithToken(token: string, body?: unknown): Promise<unknown> {
+    return this.request("POST", `/api/v1/unlocks/${encodeURIComponent(token)}`, undefined, body);
+  }
+
+  /** POST /api/v1/tokens/refresh */
+  refreshTokens(body: RefreshTokens): Promise<Tokens> {
+    return this.request("POST", "/api/v1/tokens/refresh", undefined, body);
+  }
+
+  /** GET /api/v1/account */
+  ownAccount(): Promise<unknown> {
+    return this.request("GET", "/api/v1/account");
+  }
+
+  /** GET /api/v1/account/usage, reports the number and size of the todos of the account */
+  accountUsage(): Promise<unknown> {
+    return this.request("GET", "/api/v1/account/usage");
+  }
+
+  /** POST /api/v1/account/2fa */
+  enrollTwoFactor(body?: unknown): Promise<unknown> {
+    return this.request("POST", "/api/v1/account/2fa", undefined, body);
+  }
+
+  /** POST /api/v1/account/2fa/confirm */
+  confirmTwoFactor(body?: unknown): Promise<unknown> {
+    return this.request("POST", "/api/v1/account/2fa/confirm", undefined, body);
+  }
+
+  /** POST /api/v1/account/2fa/recovery-codes */
+  newRecoveryCodes(body?: unknown): Promise<unknown> {
+    return this.request("POST", "/api/v1/account/2fa/recovery-codes", undefined, body);
+  }
+
+  /** DELETE /api/v1/account/2fa */
+  disableTwoFactor(): Promise<unknown> {
+    return this.request("DELETE", "/api/v1/account/2fa");
+  }
+
+  /** POST /api/v1/sessions */
+  createSession(body?: unknown): Promise<unknown> {
+    return this.request("POST", "/api/v1/sessions", undefined, body);
+  }
+
+  /** GET /api/v1/sessions */
+  listSessions(): Promise<unknown> {
+    return this.request("GET", "/api/v1/sessions");
+  }
+
+  /** DELETE /api/v1/sessions */
+  revokeSessions(): Promise<unknown> {
+    return this.request("DELETE", "/api/v1/sessions");
+  }
+
+  /** DELETE /api/v1/sessions/:id */
+  revokeSession(id: string): Promise<unknown> {
+    return this.request("DELETE", `/api/v1/sessions/${encodeURIComponent(id)}`);
+  }
+
+  /** POST /api/v1/tokens */
+  createTokens(body?: unknown): Promise<Tokens> {
+    return this.request("POST", "/api/v1/tokens", undefined, body);
+  }
+
+  /** GET /api/v1/granted/todos */
+  listSharedTodos(): Promise<SharedTodo[]> {
+    return this.request("GET", "/api/v1/granted/todos");
+  }
+
+  /** GET /api/v1/granted/todos/:id */
+  getSharedTodo(id: string): Promise<SharedTodo> {
+    return this.request("GET", `/api/v1/granted/todos/${encodeURIComponent(id)}`);
+  }
+
+  /** PATCH /api/v1/granted/todos/:id */
+  updateSharedTodo(id: string, body: SharedTodoUpdate): Promise<SharedTodo> {
+    return this.request("PATCH", `/api/v1/granted/todos/${encodeURIComponent(id)}`, undefined, body);
+  }
+
+  /** GET /usage */
+  usage(): Promise<unknown> {
+    return this.request("GET", "/usage");
+  }
+
+  /** GET /health */
+  healthCheck(): Promise<unknown> {
+    return this.request("GET", "/health");
+  }
+
+  /** GET /whoami */
+  whoAmI(): Promise<unknown> {
+    return this.request("GET", "/whoami");
+  }
+
+  /** GET /version */
+  version(): Promise<unknown> {
+    return this.request("GET", "/version");
+  }
+
+  /** GET /.well-known/jwks.json */
+  jwks(): Promise<unknown> {
+    return this.request("GET", "/.well-known/jwks.json");
+  }
+
+  /** GET /api/v1/debug/self */
+  self(): Promise<unknown> {
+    return this.request("GET", "/api/v1/debug/self");
+  }
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	// localeCookie keeps the locale of browsers without an account
+	localeCookie = "todo_locale"
+	localeMaxAge = 365 * 24 * time.Hour
+	// collationCacheSize bounds the cached keys of a collator, it starts over
+	// when full
+	collationCacheSize = 4096
+)
+
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}([-_][a-zA-Z0-9]{1,8})*$`)
+
+// baseLetters folds the accented latin letters to the letter they sort
+// with, so "Äpfel" sorts next to "Apfel" and not after "Zucker".
+var baseLetters = foldLetters(map[rune]string{
+	'a': "àáâãäåāăą",
+	'c': "çćĉċč",
+	'd': "ďđð",
+	'e': "èéêëēĕėęě",
+	'g': "ĝğġģ",
+	'h': "ĥħ",
+	'i': "ìíîïĩīĭįı",
+	'j': "ĵ",
+	'k': "ķ",
+	'l': "ĺļľŀł",
+	'n': "ñńņňŉ",
+	'o': "òóôõöøōŏő",
+	'r': "ŕŗř",
+	's': "śŝşšș",
+	't': "ţťŧț",
+	'u': "ùúûüũūŭůűų",
+	'w': "ŵ",
+	'y': "ýÿŷ",
+	'z': "źżž",
+	'α': "ά",
+	'ε': "έ",
+	'η': "ή",
+	'ι': "ίϊΐ",
+	'ο': "ό",
+	'υ': "ύϋΰ",
+	'ω': "ώ",
+	'е': "ё",
+})
+
+// expansions sort like the letters they are written as.
+var expansions = map[rune]string{'ß': "ss", 'æ': "ae", 'œ': "oe", 'þ': "th"}
+
+// tailorings are the letters a language sorts as letters of their own,
+// after the letter they follow. Letters in one group sort equal.
+var tailorings = map[string]map[rune]uint32{
+	"sv": lettersAfter('z', "å", "äæ", "öø"),
+	"fi": lettersAfter('z', "å", "äæ", "öø"),
+	"da": lettersAfter('z', "æä", "øö", "å"),
+	"nb": lettersAfter('z', "æä", "øö", "å"),
+	"nn": lettersAfter('z', "æä", "øö", "å"),
+	"no": lettersAfter('z', "æä", "øö", "å"),
+	"es": lettersAfter('n', "ñ"),
+}
+
+func foldLetters(letters map[rune]string) map[rune]rune {
+	folded := map[rune]rune{}
+	for base, accented := range letters {
+		for _, r := range accented {
+			folded[r] = base
+		}
+	}
+
+	return folded
+}
+
+// lettersAfter weighs the groups in their order right after letter.
+func lettersAfter(letter rune, groups ...string) map[rune]uint32 {
+	weights := map[rune]uint32{}
+	for i, group := range groups {
+		for _, r := range group {
+			weights[r] = weight(letter) + uint32(i) + 1
+		}
+	}
+
+	return weights
+}
+
+// weight of a letter, the lower 8 bits are left for the tailored letters
+// following it.
+func weight(r rune) uint32 {
+	return uint32(r) << 8
+}
+
+// collator compares titles in the order of a language. Letters are compared
+// without case and accents first, then with accents and at last with case,
+// like "apfel" < "Apfel" < "Äpfel" < "Birne".
+type collator struct {
+	tailoring map[rune]uint32
+
+	mu sync.Mutex
+	// keys caches the weights of the titles seen, lists are sorted again on
+	// every poll of the UI
+	keys map[string][]uint32
+}
+
+// collators has a collator per tailored language and the root one, all
+// other languages use, under "".
+var collators = struct {
+	sync.Mutex
+	byLanguage map[string]*collator
+}{byLanguage: map[string]*collator{}}
+
+// collatorFor returns the collator of locale, a language tag like de-AT.
+func collatorFor(locale string) *collator {
+	language := strings.ToLower(locale)
+	if i := strings.IndexAny(language, "-_"); i >= 0 {
+		language = language[:i]
+	}
+	if _, ok := tailorings[language]; !ok {
+		language = ""
+	}
+
+	collators.Lock()
+	defer collators.Unlock()
+	col, ok := collators.byLanguage[language]
+	if !ok {
+		col = &collator{tailoring: tailorings[language], keys: map[string][]uint32{}}
+		collators.byLanguage[language] = col
+	}
+
+	return col
+}
+
+// key returns the weights of the letters of s, ignoring case and accents.
+func (col *collator) key(s string) []uint32 {
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	if key, ok := col.keys[s]; ok {
+		return key
+	}
+
+	key := make([]uint32, 0, len(s))
+	for _, r := range strings.TrimSpace(s) {
+		r = unicode.ToLower(r)
+		if w, ok := col.tailoring[r]; ok {
+			key = append(key, w)
+			continue
+		}
+		if expanded, ok := expansions[r]; ok {
+			for _, letter := range expanded {
+				key = append(key, weight(letter))
+			}
+			continue
+		}
+		if base, ok := baseLetters[r]; ok {
+			r = base
+		}
+		key = append(key, weight(r))
+	}
+
+	if len(col.keys) >= collationCacheSize {
+		col.keys = map[string][]uint32{}
+	}
+	col.keys[s] = key
+
+	return key
+}
+
+func compareKeys(a, b []uint32) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return len(a) - len(b)
+}
+
+// compare returns a negative number if a sorts before b, a positive one if
+// after and 0 if both are the same.
+func (col *collator) compare(a, b string) int {
+	if cmp := compareKeys(col.key(a), col.key(b)); cmp != 0 {
+		return cmp
+	}
+	if cmp := strings.Compare(strings.ToLower(a), strings.ToLower(b)); cmp != 0 {
+		return cmp
+	}
+
+	return -strings.Compare(a, b)
+}
+
+// sortByTitle returns a copy of todos ordered by their titles, todos with
+// the same title stay in the order of the list.
+func (col *collator) sortByTitle(todos []tododb.Todo) []tododb.Todo {
+	sorted := append([]tododb.Todo{}, todos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return col.compare(sorted[i].Title, sorted[j].Title) < 0
+	})
+
+	return sorted
+}
+
+func localeKey(account string) string {
+	return "locale:" + account
+}
+
+// localeOf returns the locale to sort the list of the request in. ?locale=
+// selects one for the request, without it the preference of the account or
+// the browser is used and then the first language the browser accepts. It
+// answers invalid locales itself.
+func localeOf(c *gin.Context) (string, bool) {
+	if locale := c.Query("locale"); locale != "" {
+		if !localePattern.MatchString(locale) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": fmt.Sprintf("invalid locale %q, use a language tag like de or sv-FI", locale),
+			})
+			return "", false
+		}
+		return locale, true
+	}
+
+	return preferredLocale(c), true
+}
+
+// preferredLocale is the locale the account or the browser chose last, else
+// the first the browser accepts. It is "" if there is none.
+func preferredLocale(c *gin.Context) string {
+	if account := c.GetString(accountKey); account != "" {
+		locale, err := tododb.KVOf(database).GetValue(localeKey(account))
+		if err == nil && localePattern.MatchString(locale) {
+			return locale
+		}
+		if err != nil && err != tododb.ErrNotFound {
+			logger.Errorf("%v", err)
+		}
+	}
+
+	if locale, err := c.Cookie(localeCookie); err == nil && localePattern.MatchString(locale) {
+		return locale
+	}
+
+	for _, accepted := range strings.Split(c.GetHeader("Accept-Language"), ",") {
+		locale := strings.TrimSpace(strings.SplitN(accepted, ";", 2)[0])
+		if localePattern.MatchString(locale) {
+			return locale
+		}
+	}
+
+	return ""
+}
+
+func getLocaleHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"locale": preferredLocale(c),
+	})
+}
+
+func setLocaleHandler(c *gin.Context) {
+	var request struct {
+		Locale string `json:"locale"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || !localePattern.MatchString(request.Locale) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "locale must be a language tag like de or sv-FI",
+		})
+		return
+	}
+
+	c.SetCookie(localeCookie, request.Locale, int(localeMaxAge.Seconds()), "/", "", false, true)
+	if account := c.GetString(accountKey); account != "" {
+		if err := tododb.KVOf(database).SetValue(localeKey(account), request.Locale, 0); err != nil {
+			logger.Errorf("%v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"locale": request.Locale,
+	})
+}
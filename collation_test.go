@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	// lockouts read the mail config from another goroutine, an empty config
+	// sends no mails
+	appConfig = &TodoAppConfig{}
+}
+
+func TestSortByTitle(t *testing.T) {
+	tests := []struct {
+		locale string
+		titles []string
+		want   []string
+	}{
+		{
+			locale: "",
+			titles: []string{"Zucker", "Äpfel", "apfel", "Birne", "Apfel", "straße", "strasse", "Strauß"},
+			want:   []string{"apfel", "Apfel", "Äpfel", "Birne", "strasse", "straße", "Strauß", "Zucker"},
+		},
+		{
+			locale: "de-AT",
+			titles: []string{"Öl", "Zebra", "Åsa", "Ärt", "Apa"},
+			want:   []string{"Apa", "Ärt", "Åsa", "Öl", "Zebra"},
+		},
+		{
+			locale: "sv",
+			titles: []string{"Öl", "Zebra", "Åsa", "Ärt", "Apa"},
+			want:   []string{"Apa", "Zebra", "Åsa", "Ärt", "Öl"},
+		},
+		{
+			locale: "da_DK",
+			titles: []string{"Öl", "Zebra", "Åsa", "Ærø", "Apa"},
+			want:   []string{"Apa", "Zebra", "Ærø", "Öl", "Åsa"},
+		},
+		{
+			locale: "es",
+			titles: []string{"oso", "ñu", "nube"},
+			want:   []string{"nube", "ñu", "oso"},
+		},
+		{
+			locale: "el",
+			titles: []string{"όμικρον", "άλφα", "βήτα"},
+			want:   []string{"άλφα", "βήτα", "όμικρον"},
+		},
+		{
+			locale: "",
+			titles: []string{"  b", "a", "ab", "A"},
+			want:   []string{"a", "A", "ab", "  b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.locale, func(t *testing.T) {
+			todos := []tododb.Todo{}
+			for _, title := range test.titles {
+				todos = append(todos, tododb.Todo{Title: title})
+			}
+
+			got := []string{}
+			for _, todo := range collatorFor(test.locale).sortByTitle(todos) {
+				got = append(got, todo.Title)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("sortByTitle() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSortByTitleStable(t *testing.T) {
+	todos := []tododb.Todo{{ID: "1", Title: "Milk"}, {ID: "2", Title: "Bread"}, {ID: "3", Title: "Milk"}}
+
+	sorted := collatorFor("en").sortByTitle(todos)
+	if sorted[1].ID != "1" || sorted[2].ID != "3" {
+		t.Errorf("sortByTitle() = %+v, want the two Milk in the order of the list", sorted)
+	}
+	if todos[0].ID != "1" || todos[1].ID != "2" {
+		t.Error("sortByTitle() changed the list")
+	}
+}
+
+func TestCollatorFor(t *testing.T) {
+	root := collatorFor("")
+	tests := []struct {
+		locale string
+		same   string
+	}{
+		{"de-AT", ""},
+		{"en", ""},
+		{"SV_fi", "sv"},
+		{"nb-NO", "nb"},
+	}
+
+	for _, test := range tests {
+		if collatorFor(test.locale) != collatorFor(test.same) {
+			t.Errorf("collatorFor(%q) isn't the collator of %q", test.locale, test.same)
+		}
+	}
+	if collatorFor("sv") == root {
+		t.Error("collatorFor(sv) is the root collator")
+	}
+}
+
+func TestCollationCache(t *testing.T) {
+	col := &collator{keys: map[string][]uint32{}}
+	for i := 0; i <= collationCacheSize; i++ {
+		col.key(fmt.Sprint(i))
+	}
+
+	if len(col.keys) > collationCacheSize {
+		t.Errorf("the cache has %d keys, want at most %d", len(col.keys), collationCacheSize)
+	}
+}
+
+func TestPreferredLocale(t *testing.T) {
+	tests := []struct {
+		name           string
+		cookie         string
+		acceptLanguage string
+		want           string
+	}{
+		{name: "nothing"},
+		{name: "cookie", cookie: "sv-FI", acceptLanguage: "de", want: "sv-FI"},
+		{name: "invalid cookie", cookie: "<script>", acceptLanguage: "de", want: "de"},
+		{name: "accept language", acceptLanguage: "*, de-AT;q=0.9, en;q=0.5", want: "de-AT"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/todos", nil)
+			if test.cookie != "" {
+				c.Request.AddCookie(&http.Cookie{Name: localeCookie, Value: test.cookie})
+			}
+			if test.acceptLanguage != "" {
+				c.Request.Header.Set("Accept-Language", test.acceptLanguage)
+			}
+
+			if got := preferredLocale(c); got != test.want {
+				t.Errorf("preferredLocale() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLocaleOf(t *testing.T) {
+	tests := []struct {
+		query  string
+		locale string
+		ok     bool
+		status int
+	}{
+		{query: "?locale=es", locale: "es", ok: true, status: http.StatusOK},
+		{query: "?locale=sv_FI", locale: "sv_FI", ok: true, status: http.StatusOK},
+		{query: "?locale=x", status: http.StatusBadRequest},
+		{query: "?locale=de%20AT", status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodGet, "/todos"+test.query, nil)
+
+			locale, ok := localeOf(c)
+			if locale != test.locale || ok != test.ok {
+				t.Errorf("localeOf() = %q, %v, want %q, %v", locale, ok, test.locale, test.ok)
+			}
+			if recorder.Code != test.status {
+				t.Errorf("localeOf() answered %d, want %d", recorder.Code, test.status)
+			}
+		})
+	}
+}
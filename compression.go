@@ -0,0 +1,134 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rawSizeKey holds the size of the body before compression, set by the gzip
+// middleware for responseSizeMiddleware.
+const rawSizeKey = "rawSize"
+
+// gzipWriter compresses the body and counts the bytes before compression,
+// Size still reports the compressed bytes that were sent.
+type gzipWriter struct {
+	gin.ResponseWriter
+	writer *gzip.Writer
+	raw    int
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	w.Header().Del("Content-Length")
+	n, err := w.writer.Write(data)
+	w.raw += n
+	return n, err
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush pushes the compressed data out, so streamed exports still arrive in
+// pieces.
+func (w *gzipWriter) Flush() {
+	w.writer.Flush()
+	w.ResponseWriter.Flush()
+}
+
+// gzipHandler compresses responses for clients that accept gzip. Images are
+// already compressed and passed through.
+func gzipHandler(level int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !shouldCompress(c) {
+			c.Next()
+			return
+		}
+
+		writer, err := gzip.NewWriterLevel(c.Writer, level)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		c.Header("Content-Encoding", "gzip")
+		c.Header("Vary", "Accept-Encoding")
+
+		original := c.Writer
+		compressed := &gzipWriter{ResponseWriter: original, writer: writer}
+		c.Writer = compressed
+		c.Next()
+		c.Writer = original
+
+		// An empty body like of a 304 must stay empty
+		if compressed.raw == 0 && !original.Written() {
+			original.Header().Del("Content-Encoding")
+		} else if err := writer.Close(); err != nil {
+			logger.Warnf("Closing gzip writer: %v", err)
+		}
+		c.Set(rawSizeKey, compressed.raw)
+	}
+}
+
+func shouldCompress(c *gin.Context) bool {
+	if !strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") ||
+		strings.Contains(c.GetHeader("Connection"), "Upgrade") {
+		return false
+	}
+
+	switch strings.ToLower(filepath.Ext(c.Request.URL.Path)) {
+	case ".png", ".gif", ".jpeg", ".jpg":
+		return false
+	}
+
+	return true
+}
+
+// responseSizeMiddleware observes the size of every response before and
+// after compression. It has to run before the gzip middleware to see the
+// compressed size.
+func responseSizeMiddleware(metrics *Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		sent := c.Writer.Size()
+		if sent < 0 {
+			// Nothing was written
+			sent = 0
+		}
+
+		raw := sent
+		if value, exists := c.Get(rawSizeKey); exists {
+			raw = value.(int)
+		}
+
+		encoding := c.Writer.Header().Get("Content-Encoding")
+		if encoding == "" {
+			encoding = "identity"
+		}
+
+		// Unmatched paths would create a series per path
+		route := routeOf(c)
+		if c.Writer.Status() == http.StatusNotFound {
+			route = "unmatched"
+		}
+		content := contentKind(c.Writer.Header().Get("Content-Type"))
+
+		metrics.responseRawBytes.WithLabelValues(route, content).Observe(float64(raw))
+		metrics.responseSentBytes.WithLabelValues(route, content, encoding).Observe(float64(sent))
+	}
+}
+
+func contentKind(contentType string) string {
+	switch {
+	case strings.Contains(contentType, "html"):
+		return "html"
+	case strings.Contains(contentType, "json"):
+		return "json"
+	default:
+		return "other"
+	}
+}
@@ -1,44 +1,498 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
+	"sort"
 
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	defaultRenderBudget          = 100
+	defaultDemoResetMinutes      = 15
+	defaultLatencyWindowMinutes  = 15
+	defaultBoardCacheSeconds     = 60
+	defaultSmartListCacheSeconds = 10
+	defaultWorkloadHoursPerDay   = 8
+
+	// profileEnv selects one of the Profiles of the config file
+	profileEnv = "TODOAPP_PROFILE"
+	// backendEnv overrides the Backend of the config file
+	backendEnv = "DB_BACKEND"
+
+	defaultWatchdogIntervalSeconds = 30
+	defaultMaxGoroutines           = 10000
+	defaultMaxOpenFDs              = 1000
+	defaultMaxBackendConnections   = 100
+
+	defaultMinPasswordLength  = 8
+	defaultResetTokenMinutes  = 60
+	defaultTOTPIssuer         = "todo-app"
+	defaultSessionDays        = 30
+	defaultAccessTokenMinutes = 15
+	defaultSigningKeyDays     = 30
+)
+
 type TodoAppConfig struct {
 	HealthCheckTime int
-	DBDriver        string
-	DBConfig        map[string]string
-	ReleaseMode     string
+	// Backend is the name the backend is registered with, like redis or
+	// postgres
+	Backend string
+	// DBDriver is the deprecated name of Backend
+	DBDriver string
+	// DBConfig holds the options of the backend, for redis only the tuning
+	// options, the connections are configured in Redis
+	DBConfig     map[string]string
+	Redis        RedisConfig
+	ReleaseMode  string
+	RenderBudget int
+	Integrations map[string]string
+	// ImporterPlugins maps import formats to external programs
+	ImporterPlugins map[string]string
+	AdminToken      string
+	// PublicURL is the address of the instance, e.g. in QR codes
+	PublicURL string
+	// APIURL is where the frontend role forwards the API requests to
+	APIURL string
+	// BoardCacheSeconds is how long proxies may cache the public board
+	BoardCacheSeconds int
+	// SmartListCacheSeconds is how long evaluated smart lists are reused
+	SmartListCacheSeconds int
+	// LongPollSeconds is the longest /api/v1/changes waits for a change
+	LongPollSeconds int
+	// EmbedFrameAncestors are the sources allowed to frame /embed, like
+	// https://wiki.example.com
+	EmbedFrameAncestors []string
+	// WorkloadHoursPerDay is the effort per day before /api/v1/workload
+	// warns about an over-scheduled day
+	WorkloadHoursPerDay int
+	// AllowBlockedCompletion allows to complete todos with open blockers
+	AllowBlockedCompletion bool
+	// TrashDays is how long deleted todos are kept in the trash
+	TrashDays int
+	// DraftHours is how long unsaved edits are kept after their last change
+	DraftHours int
+	// UndoDepth is the number of operations of an account that can be undone
+	UndoDepth int
+	// ShareSecret signs the links of shared snapshots
+	ShareSecret string
+	Features    map[string]bool
+	// FeatureProvider serves the flags from outside, Features are the
+	// defaults then
+	FeatureProvider FeatureProviderConfig
+	// LatencyWindowMinutes is how long per route latencies are kept
+	LatencyWindowMinutes int
+	// LogBufferSize is the number of log entries kept for /admin/logs
+	LogBufferSize int
+	LogLevel      string
+	// LogModules overrides LogLevel for single modules like tododb
+	LogModules map[string]string
+	Demo       DemoConfig
+	// FailoverDrills allows /admin/drills/failover, never enable it in
+	// production
+	FailoverDrills bool
+	// Middleware replaces the middleware chain of a route group
+	Middleware map[string][]MiddlewareConfig
+	Watchdog   WatchdogConfig
+	Digest     DigestConfig
+	// Webhooks are sent the changes of the todos and the digests
+	Webhooks []WebhookConfig
+	// WasmHooks runs the WebAssembly hooks uploaded by admins
+	WasmHooks WasmHooksConfig
+	// IssueSync mirrors tagged todos to GitHub issues or Jira tickets
+	IssueSync  []IssueSyncConfig
+	Telemetry  TelemetryConfig
+	Accounts   AccountsConfig
+	Mail       MailConfig
+	GC         GCConfig
+	Recurrence RecurrenceConfig
+	Branding   BrandingConfig
+	// Attachments selects where the files attached to todos are stored
+	Attachments AttachmentsConfig
+	// Profiles are partial configs like dev or prod, the one named by
+	// TODOAPP_PROFILE is applied on top of the settings above
+	Profiles map[string]json.RawMessage
+	// Profile is the applied profile
+	Profile string `json:"-"`
+}
+
+// RedisConfig are the connections of the redis backend, the accepted formats
+// are listed in the README.
+type RedisConfig struct {
+	Master         string
+	MasterPassword string
+	Slave          string
+	SlavePassword  string
+}
+
+type DemoConfig struct {
+	Enabled bool
+	// ResetMinutes is the interval between two resets of the dataset
+	ResetMinutes int
+	// SeedFile is a JSON array of todos, if empty the todos are generated
+	// from SeedProfile or the built-in seed is used
+	SeedFile    string
+	SeedProfile string
+}
+
+// DigestConfig schedules the summaries of created, completed and overdue
+// todos, they are only sent with a WebhookURL.
+type DigestConfig struct {
+	// Period is daily or weekly, weekly digests are sent on Mondays
+	Period string
+	// Hour of the day the digest is sent, in local time
+	Hour       int
+	WebhookURL string
+	// Template is a text/template of the digest, the built-in one is used
+	// if empty
+	Template string
+}
+
+// FeatureProviderConfig selects the OpenFeature provider of the flags,
+// builtin or ofrep.
+type FeatureProviderConfig struct {
+	Name string
+	// URL of the OFREP service, like http://flagd:8016
+	URL string
+	// Headers are sent with every request, e.g. an Authorization
+	Headers     map[string]string
+	PollSeconds int
+}
+
+// RecurrenceConfig schedules the next occurrences of completed recurring
+// todos.
+type RecurrenceConfig struct {
+	// Disabled stops the scheduler of this instance, like on read-only
+	// replicas. Another instance adds the occurrences then
+	Disabled bool
+	// IntervalSeconds is how often completed recurring todos are looked for
+	IntervalSeconds int
+}
+
+// AttachmentsConfig stores the content of the attachments on disk or in an
+// S3-compatible object storage, their metadata is stored with the todos.
+type AttachmentsConfig struct {
+	// Store is disk, the default, or s3
+	Store string
+	// Options of the store, dir for disk; bucket, region, endpoint, prefix,
+	// profile and timeout for s3
+	Options map[string]string
+	// MaxBytes is the largest file that can be attached
+	MaxBytes int64
+	// MaxPerTodo is the number of files a todo can have
+	MaxPerTodo int
+	// Scan scans the uploads for malware, off by default
+	Scan ScanConfig
+}
+
+// BrandingConfig renames and restyles the UI, like for internal trainings.
+// Empty fields keep the branding of the cat todo list.
+type BrandingConfig struct {
+	// Name is the name of the app in the titles and the manifest, ShortName
+	// the one under the icon of the installed app
+	Name      string
+	ShortName string
+	// Headline is shown above the list
+	Headline string
+	// LogoURL is the image next to the headline and the icon of the
+	// installed app, relative to the UI or an http(s) URL
+	LogoURL string
+	// PrimaryColor styles the headline and the buttons, BackgroundColor the
+	// page, both are hex colors like #1a5fb4
+	PrimaryColor    string
+	BackgroundColor string
+	FooterLinks     []FooterLink
+}
+
+// FooterLink is a link in the footer of the UI, like to the imprint.
+type FooterLink struct {
+	Title string
+	URL   string
+}
+
+// TelemetryConfig is off by default, /admin/telemetry shows what would be
+// sent.
+type TelemetryConfig struct {
+	Enabled       bool
+	Endpoint      string
+	IntervalHours int
+}
+
+// WatchdogConfig thresholds of zero use the defaults, negative thresholds
+// disable the check.
+type WatchdogConfig struct {
+	IntervalSeconds       int
+	MaxGoroutines         int
+	MaxOpenFDs            int
+	MaxBackendConnections int
+}
+
+// AccountsConfig are the local accounts checked by the accountAuth
+// middleware.
+type AccountsConfig struct {
+	// OpenRegistration lets everyone register at /api/v1/accounts, else
+	// only the admins create accounts
+	OpenRegistration  bool
+	MinPasswordLength int
+	// Hasher is the algorithm of new password hashes, argon2id if empty.
+	// Hashes of the other registered algorithms are still accepted
+	Hasher string
+	Argon2 Argon2Config
+	// ResetTokenMinutes is how long a password reset token is valid
+	ResetTokenMinutes int
+	// TOTPIssuer names the app in the authenticator apps
+	TOTPIssuer string
+	// SessionDays is how long an unused session stays valid
+	SessionDays int
+	// AccessTokenMinutes is how long an access token (JWT) is valid, the
+	// refresh tokens live as long as their session
+	AccessTokenMinutes int
+	// SigningKeyDays is how often the key signing the access tokens is
+	// rotated
+	SigningKeyDays int
+}
+
+// Argon2Config of zero use the OWASP recommendation of 19 MiB, 2 iterations
+// and a parallelism of 1.
+type Argon2Config struct {
+	MemoryKiB   uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// GCConfig overrides GOGC and GOMEMLIMIT from the environment if set.
+type GCConfig struct {
+	// Percent like GOGC, -1 turns the GC off
+	Percent       int
+	MemoryLimitMB int
+	// BallastMB allocates a never used buffer of this size
+	BallastMB int
+}
+
+// backendOrDefault returns the backend of DB_BACKEND, otherwise backend or
+// redis if that is empty.
+func backendOrDefault(backend string) string {
+	if env := os.Getenv(backendEnv); env != "" {
+		return env
+	}
+	if backend == "" {
+		log.Println("Use redis as default")
+		return "redis"
+	}
+
+	return backend
 }
 
 func readConfig(configFile string) (*TodoAppConfig, error) {
 	file, err := ioutil.ReadFile(configFile)
 	if err != nil {
 		return &TodoAppConfig{
-			DBDriver:    "redis",
-			DBConfig:    map[string]string{},
-			ReleaseMode: gin.DebugMode,
+			Backend:      backendOrDefault(""),
+			DBConfig:     map[string]string{},
+			ReleaseMode:  gin.DebugMode,
+			RenderBudget: defaultRenderBudget,
 		}, err
 	}
 	config := &TodoAppConfig{}
-	json.Unmarshal(file, config)
+	if err := decodeConfig(file, config); err != nil {
+		if strictConfig {
+			return config, fmt.Errorf("Config %s: %v", configFile, err)
+		}
+		log.Printf("Config %s: %v", configFile, err)
+	}
 
-	if config.DBDriver == "" {
-		log.Println("Use redis as default")
-		config.DBDriver = "redis"
+	if profile := os.Getenv(profileEnv); profile != "" {
+		if err := applyProfile(config, profile); err != nil {
+			return config, fmt.Errorf("Config %s: %v", configFile, err)
+		}
 	}
 
+	migrateDBDriver(config)
+	config.Backend = backendOrDefault(config.Backend)
+
 	if config.DBConfig == nil {
 		config.DBConfig = map[string]string{}
 	}
 
+	migrateLegacyConfig(config)
+	migrateWasmHooksRuntime(config)
+	if strictConfig {
+		if err := checkDBConfigKeys(config); err != nil {
+			return config, fmt.Errorf("Config %s: %v", configFile, err)
+		}
+	}
+
 	if config.ReleaseMode == "" {
 		config.ReleaseMode = gin.DebugMode
 	}
 
+	if config.APIURL == "" {
+		config.APIURL = defaultAPIURL
+	}
+
+	if config.LatencyWindowMinutes <= 0 {
+		config.LatencyWindowMinutes = defaultLatencyWindowMinutes
+	}
+
+	if config.BoardCacheSeconds <= 0 {
+		config.BoardCacheSeconds = defaultBoardCacheSeconds
+	}
+
+	if config.SmartListCacheSeconds <= 0 {
+		config.SmartListCacheSeconds = defaultSmartListCacheSeconds
+	}
+
+	if config.LongPollSeconds <= 0 {
+		config.LongPollSeconds = defaultLongPollSeconds
+	}
+
+	if config.WorkloadHoursPerDay <= 0 {
+		config.WorkloadHoursPerDay = defaultWorkloadHoursPerDay
+	}
+
+	if config.TrashDays <= 0 {
+		config.TrashDays = defaultTrashDays
+	}
+
+	if config.DraftHours <= 0 {
+		config.DraftHours = defaultDraftHours
+	}
+
+	if config.UndoDepth <= 0 {
+		config.UndoDepth = defaultUndoDepth
+	}
+
+	if config.Recurrence.IntervalSeconds <= 0 {
+		config.Recurrence.IntervalSeconds = defaultRecurrenceIntervalSeconds
+	}
+
+	if config.Attachments.MaxBytes <= 0 {
+		config.Attachments.MaxBytes = defaultAttachmentMaxBytes
+	}
+
+	if config.Attachments.MaxPerTodo <= 0 {
+		config.Attachments.MaxPerTodo = defaultAttachmentsPerTodo
+	}
+
+	if config.Branding.Name == "" {
+		config.Branding.Name = defaultBrandName
+	}
+
+	if config.Branding.ShortName == "" {
+		config.Branding.ShortName = config.Branding.Name
+	}
+
+	if config.Branding.Headline == "" {
+		config.Branding.Headline = defaultBrandHeadline
+	}
+
+	if config.Branding.LogoURL == "" {
+		config.Branding.LogoURL = defaultBrandLogoURL
+	}
+
+	if config.Branding.PrimaryColor == "" {
+		config.Branding.PrimaryColor = defaultPrimaryColor
+	}
+
+	if config.Branding.BackgroundColor == "" {
+		config.Branding.BackgroundColor = defaultBackgroundColor
+	}
+
+	if len(config.EmbedFrameAncestors) == 0 {
+		config.EmbedFrameAncestors = []string{"'self'"}
+	}
+
+	if config.Digest.Period == "" {
+		config.Digest.Period = defaultDigestPeriod
+	}
+
+	if config.Telemetry.IntervalHours <= 0 {
+		config.Telemetry.IntervalHours = defaultTelemetryIntervalHour
+	}
+
+	if config.Accounts.MinPasswordLength <= 0 {
+		config.Accounts.MinPasswordLength = defaultMinPasswordLength
+	}
+
+	if config.Accounts.ResetTokenMinutes <= 0 {
+		config.Accounts.ResetTokenMinutes = defaultResetTokenMinutes
+	}
+
+	if config.Accounts.TOTPIssuer == "" {
+		config.Accounts.TOTPIssuer = defaultTOTPIssuer
+	}
+
+	if config.Accounts.SessionDays <= 0 {
+		config.Accounts.SessionDays = defaultSessionDays
+	}
+
+	if config.Accounts.AccessTokenMinutes <= 0 {
+		config.Accounts.AccessTokenMinutes = defaultAccessTokenMinutes
+	}
+
+	if config.Accounts.SigningKeyDays <= 0 {
+		config.Accounts.SigningKeyDays = defaultSigningKeyDays
+	}
+
+	if config.Demo.ResetMinutes <= 0 {
+		config.Demo.ResetMinutes = defaultDemoResetMinutes
+	}
+
+	if config.Watchdog.IntervalSeconds <= 0 {
+		config.Watchdog.IntervalSeconds = defaultWatchdogIntervalSeconds
+	}
+
+	if config.Watchdog.MaxGoroutines == 0 {
+		config.Watchdog.MaxGoroutines = defaultMaxGoroutines
+	}
+
+	if config.Watchdog.MaxOpenFDs == 0 {
+		config.Watchdog.MaxOpenFDs = defaultMaxOpenFDs
+	}
+
+	if config.Watchdog.MaxBackendConnections == 0 {
+		config.Watchdog.MaxBackendConnections = defaultMaxBackendConnections
+	}
+
+	// A negative budget disables paging of the HTML view
+	if config.RenderBudget == 0 {
+		config.RenderBudget = defaultRenderBudget
+	}
+
 	return config, err
 }
+
+func decodeConfig(data []byte, config *TodoAppConfig) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if strictConfig {
+		decoder.DisallowUnknownFields()
+	}
+	return decoder.Decode(config)
+}
+
+// applyProfile decodes the profile over the base config, settings it leaves
+// out keep their base value and maps are merged key by key.
+func applyProfile(config *TodoAppConfig, profile string) error {
+	raw, exists := config.Profiles[profile]
+	if !exists {
+		names := make([]string, 0, len(config.Profiles))
+		for name := range config.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("profile %q of %s doesn't exist, use one of %v", profile, profileEnv, names)
+	}
+
+	if err := decodeConfig(raw, config); err != nil {
+		return fmt.Errorf("profile %s: %v", profile, err)
+	}
+	config.Profile = profile
+	log.Printf("Using config profile %s", profile)
+
+	return nil
+}
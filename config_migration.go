@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+// strictConfig rejects unknown keys in the config file instead of ignoring
+// them.
+var strictConfig bool
+
+// legacyRedisKeys are the DBConfig keys the Redis section replaces. The
+// hyphenated ones were shipped in the example configs but never read.
+var legacyRedisKeys = []struct {
+	key   string
+	field string
+	value func(*RedisConfig) *string
+}{
+	{"master", "Master", func(r *RedisConfig) *string { return &r.Master }},
+	{"masterPassword", "MasterPassword", func(r *RedisConfig) *string { return &r.MasterPassword }},
+	{"master-password", "MasterPassword", func(r *RedisConfig) *string { return &r.MasterPassword }},
+	{"slave", "Slave", func(r *RedisConfig) *string { return &r.Slave }},
+	{"slavePassword", "SlavePassword", func(r *RedisConfig) *string { return &r.SlavePassword }},
+	{"slave-password", "SlavePassword", func(r *RedisConfig) *string { return &r.SlavePassword }},
+}
+
+// migrateDBDriver takes the backend of the deprecated DBDriver key, unless
+// Backend is set as well.
+func migrateDBDriver(config *TodoAppConfig) {
+	if config.DBDriver == "" {
+		return
+	}
+
+	if config.Backend != "" {
+		log.Printf("DBDriver is deprecated and ignored because Backend is set")
+		return
+	}
+
+	log.Printf("DBDriver is deprecated, use Backend instead")
+	config.Backend = config.DBDriver
+}
+
+// migrateLegacyConfig moves the connection settings of the redis backend
+// from DBConfig into the Redis section. Values already set in the Redis
+// section win.
+func migrateLegacyConfig(config *TodoAppConfig) {
+	if !strings.EqualFold(config.Backend, "redis") {
+		return
+	}
+
+	for _, legacy := range legacyRedisKeys {
+		value, exists := config.DBConfig[legacy.key]
+		if !exists {
+			continue
+		}
+		delete(config.DBConfig, legacy.key)
+
+		target := legacy.value(&config.Redis)
+		if *target != "" {
+			log.Printf("DBConfig.%s is deprecated and ignored because Redis.%s is set", legacy.key, legacy.field)
+			continue
+		}
+
+		log.Printf("DBConfig.%s is deprecated, use Redis.%s instead", legacy.key, legacy.field)
+		*target = value
+	}
+}
+
+// migrateWasmHooksRuntime enables the hooks of configs that set the external
+// runtime, they run in the embedded one now.
+func migrateWasmHooksRuntime(config *TodoAppConfig) {
+	hooks := &config.WasmHooks
+	if hooks.Runtime == "" && len(hooks.Args) == 0 {
+		return
+	}
+
+	log.Printf("WasmHooks.Runtime and WasmHooks.Args are deprecated and ignored, the hooks run in the embedded runtime")
+	if hooks.Runtime != "" {
+		hooks.Enabled = true
+	}
+	hooks.Runtime, hooks.Args = "", nil
+}
+
+// checkDBConfigKeys rejects DBConfig keys the backend doesn't know. Backends
+// that don't declare their keys are not checked.
+func checkDBConfigKeys(config *TodoAppConfig) error {
+	known, declared := tododb.Options(config.Backend)
+	if !declared {
+		return nil
+	}
+
+	isKnown := map[string]bool{}
+	for _, key := range known {
+		isKnown[key] = true
+	}
+
+	unknown := []string{}
+	for key := range config.DBConfig {
+		if !isKnown[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	sort.Strings(unknown)
+	return fmt.Errorf("unknown DBConfig keys %v for %s, use %v", unknown, config.Backend, known)
+}
+
+// backendOptions is the option map handed to tododb.Open, for redis the
+// Redis section is merged into it.
+func backendOptions(config *TodoAppConfig) map[string]string {
+	options := map[string]string{}
+	for key, value := range config.DBConfig {
+		options[key] = value
+	}
+
+	if !strings.EqualFold(config.Backend, "redis") {
+		return options
+	}
+
+	for key, value := range map[string]string{
+		"master":         config.Redis.Master,
+		"masterPassword": config.Redis.MasterPassword,
+		"slave":          config.Redis.Slave,
+		"slavePassword":  config.Redis.SlavePassword,
+	} {
+		if value != "" {
+			options[key] = value
+		}
+	}
+
+	return options
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsMiddleware answers preflight requests itself, so it only works for
+// them in the global group. Other groups are never reached by a preflight
+// because there are no OPTIONS routes.
+func corsMiddleware(options map[string]string) (gin.HandlerFunc, error) {
+	origins := splitOption(options, "origins", "*")
+	methods := strings.Join(splitOption(options, "methods", "GET,POST,PUT,PATCH,DELETE"), ", ")
+	headers := strings.Join(splitOption(options, "headers", "Authorization,Content-Type"), ", ")
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		if !originAllowed(origins, origin) {
+			if c.Request.Method == http.MethodOptions {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		c.Header("Access-Control-Allow-Origin", origin)
+		c.Header("Vary", "Origin")
+		if c.Request.Method == http.MethodOptions {
+			c.Header("Access-Control-Allow-Methods", methods)
+			c.Header("Access-Control-Allow-Headers", headers)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}, nil
+}
+
+func originAllowed(origins []string, origin string) bool {
+	for _, allowed := range origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitOption reads a comma separated option.
+func splitOption(options map[string]string, key, fallback string) []string {
+	value, exists := options[key]
+	if !exists {
+		value = fallback
+	}
+
+	values := []string{}
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+
+	return values
+}
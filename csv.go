@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	csvPreviewRows = 10
+	utf8BOM        = "\xef\xbb\xbf"
+)
+
+// csvFields are the todo fields a CSV column can be mapped to.
+var csvFields = []string{"title"}
+
+func parseDelimiter(value string) (rune, error) {
+	switch value {
+	case "":
+		return ',', nil
+	case "tab", `\t`:
+		return '\t', nil
+	}
+
+	r, size := utf8.DecodeRuneInString(value)
+	if size != len(value) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+		return 0, fmt.Errorf("invalid delimiter: %q", value)
+	}
+
+	return r, nil
+}
+
+func exportCSV(c *gin.Context) {
+	delimiter, err := parseDelimiter(c.Query("delimiter"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="todos.csv"`)
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Comma = delimiter
+	w.Write(csvFields)
+
+	count := 0
+	err = todosOf(c).ForEachTodo(c.Request.Context(), func(todo tododb.Todo) error {
+		if err := w.Write([]string{todo.Title}); err != nil {
+			return err
+		}
+
+		count++
+		if count%flushEvery == 0 {
+			w.Flush()
+			c.Writer.Flush()
+		}
+		return w.Error()
+	})
+	if err != nil {
+		logger.Errorf("%v", err)
+		return
+	}
+
+	w.Flush()
+	c.Writer.Flush()
+}
+
+// csvMapping resolves which column index feeds which todo field. Columns are
+// selected by their header name with ?title=<column>, without a mapping a
+// column named like the field (or the first column for the title) is used.
+func csvMapping(c *gin.Context, header []string) (map[string]int, error) {
+	mapping := map[string]int{}
+	for _, field := range csvFields {
+		column := c.Query(field)
+		if column == "" {
+			column = field
+		}
+
+		for i, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), column) {
+				mapping[field] = i
+				break
+			}
+		}
+
+		if _, found := mapping[field]; !found && c.Query(field) != "" {
+			return nil, fmt.Errorf("column %q not found in header %v", column, header)
+		}
+	}
+
+	if _, found := mapping["title"]; !found {
+		mapping["title"] = 0
+	}
+
+	return mapping, nil
+}
+
+func importCSV(c *gin.Context, body io.Reader) {
+	delimiter, err := parseDelimiter(c.Query("delimiter"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	// Spreadsheet exports like to start with a byte order mark, which would
+	// otherwise end up in the first column name.
+	buffered := bufio.NewReader(body)
+	if bom, err := buffered.Peek(len(utf8BOM)); err == nil && string(bom) == utf8BOM {
+		buffered.Discard(len(utf8BOM))
+	}
+
+	r := csv.NewReader(buffered)
+	r.Comma = delimiter
+	r.FieldsPerRecord = -1
+
+	header, err := r.Read()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("reading CSV header: %v", err),
+		})
+		return
+	}
+
+	mapping, err := csvMapping(c, header)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	preview := c.Query("preview") == "true"
+	rows := []map[string]string{}
+	batch := make([]tododb.Todo, 0, ingestBatchSize)
+	imported := 0
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors":   err.Error(),
+				"imported": imported,
+			})
+			return
+		}
+
+		row := map[string]string{}
+		for field, index := range mapping {
+			if index < len(record) {
+				row[field] = record[index]
+			}
+		}
+
+		if preview {
+			rows = append(rows, row)
+			if len(rows) == csvPreviewRows {
+				break
+			}
+			continue
+		}
+
+		if strings.TrimSpace(row["title"]) == "" {
+			continue
+		}
+
+		batch = append(batch, newTodo(row["title"]))
+		if len(batch) == ingestBatchSize {
+			claimTodos(c, batch)
+			if err := todosOf(c).SaveTodos(c.Request.Context(), batch); err != nil {
+				logger.Errorf("%v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"errors":   err.Error(),
+					"imported": imported,
+				})
+				return
+			}
+			publishChange(changeCreated, batch...)
+			queueHooks(hookCreate, batch...)
+			imported += len(batch)
+			batch = batch[:0]
+		}
+	}
+
+	columns := map[string]string{}
+	for field, index := range mapping {
+		columns[field] = header[index]
+	}
+
+	if preview {
+		c.JSON(http.StatusOK, gin.H{
+			"columns": header,
+			"mapping": columns,
+			"rows":    rows,
+		})
+		return
+	}
+
+	claimTodos(c, batch)
+	if err := todosOf(c).SaveTodos(c.Request.Context(), batch); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors":   err.Error(),
+			"imported": imported,
+		})
+		return
+	}
+	publishChange(changeCreated, batch...)
+	queueHooks(hookCreate, batch...)
+
+	c.JSON(http.StatusOK, gin.H{
+		"imported": imported + len(batch),
+		"mapping":  columns,
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/seed"
+)
+
+var defaultSeed = []string{"Eat", "Sleep", "Code", "Repeat"}
+
+func loadSeed(demo DemoConfig) ([]string, error) {
+	if demo.SeedFile == "" {
+		if demo.SeedProfile == "" {
+			return defaultSeed, nil
+		}
+
+		profile, err := seed.Get(demo.SeedProfile)
+		if err != nil {
+			return nil, err
+		}
+		return profile.Generate(defaultSeedNumber, time.Now()), nil
+	}
+
+	content, err := ioutil.ReadFile(demo.SeedFile)
+	if err != nil {
+		return nil, err
+	}
+
+	todos := []string{}
+	if err := json.Unmarshal(content, &todos); err != nil {
+		return nil, err
+	}
+
+	return todos, nil
+}
+
+// runDemoResets puts the seed back in place right away and then after every
+// interval, so a public demo instance cleans up after its visitors.
+func runDemoResets(todos []string, interval time.Duration) {
+	for {
+		if err := database.ReplaceAllTodos(context.Background(), newTodos(todos)); err != nil {
+			log.Printf("Demo reset failed: %v", err)
+		} else {
+			publishChange(changeReset)
+			log.Printf("Demo reset to %d seed todos, next reset in %s", len(todos), interval)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// forbidInDemoMode guards destructive admin operations, the periodic reset
+// is the only way the demo dataset gets wiped.
+func forbidInDemoMode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if appConfig.Demo.Enabled {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"errors": "not available in demo mode",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const dependenciesKey = "dependencies"
+
+// dependencies maps the id of a todo to the ids of the todos blocking it.
+type dependencies map[string][]string
+
+// dependencyLink links two todos by their ids.
+type dependencyLink struct {
+	Todo      string `json:"todo"`
+	BlockedBy string `json:"blockedBy"`
+}
+
+type dependencyNode struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	// Open is false for linked todos that were completed or deleted in the
+	// meantime
+	Open    bool `json:"open"`
+	Blocked bool `json:"blocked"`
+}
+
+// dependenciesMu serializes the changes of this instance, the links are
+// read, changed and written back as a whole.
+var dependenciesMu sync.Mutex
+
+func loadDependencies() (dependencies, error) {
+	deps := dependencies{}
+	value, err := tododb.KVOf(database).GetValue(dependenciesKey)
+	if err == tododb.ErrNotFound {
+		return deps, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return deps, json.Unmarshal([]byte(value), &deps)
+}
+
+func saveDependencies(deps dependencies) error {
+	value, err := json.Marshal(deps)
+	if err != nil {
+		return err
+	}
+
+	return tododb.KVOf(database).SetValue(dependenciesKey, string(value), 0)
+}
+
+// blocks reports whether blocker is blocked by todo through any chain of
+// links, linking todo to blocker would then close a cycle.
+func (deps dependencies) blocks(todo, blocker string) bool {
+	seen := map[string]bool{}
+	pending := []string{blocker}
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+		if current == todo {
+			return true
+		}
+		if seen[current] {
+			continue
+		}
+		seen[current] = true
+		pending = append(pending, deps[current]...)
+	}
+
+	return false
+}
+
+// listedTodos returns the todos of list by their ids.
+func listedTodos(ctx context.Context, list tododb.TodoDB) (map[string]tododb.Todo, error) {
+	todos := map[string]tododb.Todo{}
+	err := list.ForEachTodo(ctx, func(todo tododb.Todo) error {
+		if _, exists := todos[todo.ID]; !exists {
+			todos[todo.ID] = todo
+		}
+		return nil
+	})
+
+	return todos, err
+}
+
+// titleIDs tells the ids of the todos from the titles older versions kept
+// their links and timers under.
+type titleIDs struct {
+	ids map[string]bool
+	// byTitle has the id of the first todo with each title
+	byTitle map[string]string
+}
+
+func todoIDsByTitle(ctx context.Context) (titleIDs, error) {
+	titles := titleIDs{ids: map[string]bool{}, byTitle: map[string]string{}}
+	err := database.ForEachTodo(ctx, func(todo tododb.Todo) error {
+		titles.ids[todo.ID] = true
+		if _, exists := titles.byTitle[todo.Title]; !exists {
+			titles.byTitle[todo.Title] = todo.ID
+		}
+		return nil
+	})
+
+	return titles, err
+}
+
+// resolve returns the id key stands for and whether it is one already. It
+// returns "" if key is neither the id nor the title of a todo in the list.
+func (titles titleIDs) resolve(key string) (string, bool) {
+	if titles.ids[key] {
+		return key, true
+	}
+
+	return titles.byTitle[key], false
+}
+
+// openBlockers returns the titles of the blockers of the todo with id that
+// are still open. Blockers reader doesn't match block as well, without their
+// titles.
+func openBlockers(ctx context.Context, id string, reader tododb.TodoFilter) ([]string, error) {
+	deps, err := loadDependencies()
+	if err != nil || len(deps[id]) == 0 {
+		return nil, err
+	}
+
+	todos, err := listedTodos(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+
+	blockers := []string{}
+	for _, blocker := range deps[id] {
+		todo, exists := todos[blocker]
+		switch {
+		case !exists || todo.Done:
+		case reader.Matches(todo):
+			blockers = append(blockers, todo.Title)
+		default:
+			blockers = append(blockers, "a todo that isn't shared with you")
+		}
+	}
+
+	return blockers, nil
+}
+
+// migrateDependencies moves the links of older versions, which linked the
+// todos by their titles, to the ids of the todos. Links of titles that
+// aren't in the list anymore are dropped.
+func migrateDependencies(ctx context.Context) error {
+	dependenciesMu.Lock()
+	defer dependenciesMu.Unlock()
+
+	deps, err := loadDependencies()
+	if err != nil || len(deps) == 0 {
+		return err
+	}
+
+	ids, err := todoIDsByTitle(ctx)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	migrated := dependencies{}
+	for todo, blockers := range deps {
+		todo, ok := ids.resolve(todo)
+		changed = changed || !ok
+		if todo == "" {
+			continue
+		}
+		for _, blocker := range blockers {
+			blocker, ok := ids.resolve(blocker)
+			changed = changed || !ok
+			if blocker != "" && !contains(migrated[todo], blocker) {
+				migrated[todo] = append(migrated[todo], blocker)
+			}
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	logger.Infof("Linked the dependencies of %d todo(s) by id", len(migrated))
+
+	return saveDependencies(migrated)
+}
+
+// forgetDependencies drops the links of the completed or deleted todo with
+// id, it doesn't block anything anymore.
+func forgetDependencies(todo string) error {
+	dependenciesMu.Lock()
+	defer dependenciesMu.Unlock()
+
+	deps, err := loadDependencies()
+	if err != nil {
+		return err
+	}
+
+	changed := len(deps[todo]) > 0
+	delete(deps, todo)
+	for blocked, blockers := range deps {
+		remaining := blockers[:0]
+		for _, blocker := range blockers {
+			if blocker != todo {
+				remaining = append(remaining, blocker)
+			}
+		}
+		if len(remaining) != len(blockers) {
+			changed = true
+			deps[blocked] = remaining
+		}
+		if len(remaining) == 0 {
+			delete(deps, blocked)
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return saveDependencies(deps)
+}
+
+func bindDependencyLink(c *gin.Context) (dependencyLink, bool) {
+	link := dependencyLink{Todo: c.Query("todo"), BlockedBy: c.Query("blockedBy")}
+	if c.Request.Method != http.MethodDelete {
+		if err := c.ShouldBindJSON(&link); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": err.Error(),
+			})
+			return link, false
+		}
+	}
+
+	if link.Todo == "" || link.BlockedBy == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "todo and blockedBy are required",
+		})
+		return link, false
+	}
+
+	if link.Todo == link.BlockedBy {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "a todo can't block itself",
+		})
+		return link, false
+	}
+
+	return link, true
+}
+
+func addDependencyHandler(c *gin.Context) {
+	link, ok := bindDependencyLink(c)
+	if !ok {
+		return
+	}
+
+	todos, err := listedTodos(c.Request.Context(), todosOf(c))
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	for _, id := range []string{link.Todo, link.BlockedBy} {
+		if todo, exists := todos[id]; !exists || todo.Done {
+			c.JSON(http.StatusNotFound, gin.H{
+				"errors": fmt.Sprintf("no open todo with id %q", id),
+			})
+			return
+		}
+	}
+
+	dependenciesMu.Lock()
+	defer dependenciesMu.Unlock()
+
+	deps, err := loadDependencies()
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	if deps.blocks(link.Todo, link.BlockedBy) {
+		c.JSON(http.StatusConflict, gin.H{
+			"errors": fmt.Sprintf("%q is already blocked by %q", todos[link.BlockedBy].Title, todos[link.Todo].Title),
+		})
+		return
+	}
+
+	if !contains(deps[link.Todo], link.BlockedBy) {
+		deps[link.Todo] = append(deps[link.Todo], link.BlockedBy)
+		if err := saveDependencies(deps); err != nil {
+			logger.Errorf("%v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, link)
+}
+
+func deleteDependencyHandler(c *gin.Context) {
+	link, ok := bindDependencyLink(c)
+	if !ok {
+		return
+	}
+
+	dependenciesMu.Lock()
+	defer dependenciesMu.Unlock()
+
+	deps, err := loadDependencies()
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	remaining := []string{}
+	for _, blocker := range deps[link.Todo] {
+		if blocker != link.BlockedBy {
+			remaining = append(remaining, blocker)
+		}
+	}
+	deps[link.Todo] = remaining
+	if len(remaining) == 0 {
+		delete(deps, link.Todo)
+	}
+
+	if err := saveDependencies(deps); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// dependencyGraphHandler returns the linked todos and their links, as JSON
+// or with ?format=dot for Graphviz.
+func dependencyGraphHandler(c *gin.Context) {
+	deps, err := loadDependencies()
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	todos, err := listedTodos(c.Request.Context(), todosOf(c))
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	open := func(id string) bool {
+		todo, exists := todos[id]
+		return exists && !todo.Done
+	}
+	nodes := map[string]*dependencyNode{}
+	node := func(id string) *dependencyNode {
+		if nodes[id] == nil {
+			nodes[id] = &dependencyNode{ID: id, Title: todos[id].Title, Open: open(id)}
+		}
+		return nodes[id]
+	}
+
+	edges := []dependencyLink{}
+	for todo, blockers := range deps {
+		for _, blocker := range blockers {
+			// Links to todos the account may not read aren't shown
+			if _, exists := todos[todo]; !exists {
+				continue
+			}
+			if _, exists := todos[blocker]; !exists {
+				continue
+			}
+			edges = append(edges, dependencyLink{Todo: todo, BlockedBy: blocker})
+			node(todo).Blocked = node(todo).Blocked || open(blocker)
+			node(blocker)
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Todo != edges[j].Todo {
+			return edges[i].Todo < edges[j].Todo
+		}
+		return edges[i].BlockedBy < edges[j].BlockedBy
+	})
+
+	result := make([]dependencyNode, 0, len(nodes))
+	for _, n := range nodes {
+		result = append(result, *n)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Title != result[j].Title {
+			return result[i].Title < result[j].Title
+		}
+		return result[i].ID < result[j].ID
+	})
+
+	if c.Query("format") == "dot" {
+		c.Data(http.StatusOK, "text/vnd.graphviz; charset=utf-8", dependencyDot(result, edges))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"nodes": result,
+		"edges": edges,
+	})
+}
+
+// dependencyDot draws an arrow from every blocker to the todo it blocks. The
+// todos are labeled with their titles, or their ids once they are deleted.
+func dependencyDot(nodes []dependencyNode, edges []dependencyLink) []byte {
+	quote := func(s string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("digraph todos {\n  rankdir=LR;\n")
+	for _, n := range nodes {
+		style := ""
+		if !n.Open {
+			style = ", style=dashed"
+		} else if n.Blocked {
+			style = ", color=red"
+		}
+		label := n.Title
+		if label == "" {
+			label = n.ID
+		}
+		fmt.Fprintf(&buf, "  %s [label=%s%s];\n", quote(n.ID), quote(label), style)
+	}
+	for _, edge := range edges {
+		fmt.Fprintf(&buf, "  %s -> %s;\n", quote(edge.BlockedBy), quote(edge.Todo))
+	}
+	buf.WriteString("}\n")
+
+	return buf.Bytes()
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+// useMemoryDatabase makes database an empty MemoryDB, with todos of the
+// titles, until restore is called. The KV of the MemoryDB is shared by the
+// process, keys are those the test uses and cleared.
+func useMemoryDatabase(t *testing.T, keys []string, titles ...string) (todos []tododb.Todo, restore func()) {
+	t.Helper()
+	db, err := tododb.NewMemoryDB(map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	todos = tododb.NewTodos(titles)
+	if err := db.SaveTodos(context.Background(), todos); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := database
+	database = db
+	clearKeys := func() {
+		for _, key := range keys {
+			tododb.KVOf(db).DeleteValue(key)
+		}
+	}
+	clearKeys()
+
+	return todos, func() {
+		clearKeys()
+		database = previous
+	}
+}
+
+func TestDependenciesBlocks(t *testing.T) {
+	// a blocks b blocks c, d blocks c
+	deps := dependencies{"b": {"a"}, "c": {"b", "d"}}
+
+	tests := []struct {
+		todo, blocker string
+		want          bool
+	}{
+		{"a", "c", true},
+		{"a", "b", true},
+		{"d", "c", true},
+		{"c", "a", false},
+		{"a", "d", false},
+		{"a", "a", true},
+		{"x", "y", false},
+	}
+
+	for _, test := range tests {
+		if got := deps.blocks(test.todo, test.blocker); got != test.want {
+			t.Errorf("blocks(%s, %s) = %v, want %v", test.todo, test.blocker, got, test.want)
+		}
+	}
+}
+
+func TestForgetDependencies(t *testing.T) {
+	_, restore := useMemoryDatabase(t, []string{dependenciesKey})
+	defer restore()
+	if err := saveDependencies(dependencies{"b": {"a"}, "c": {"a", "b"}, "d": {"c"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := forgetDependencies("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := forgetDependencies("d"); err != nil {
+		t.Fatal(err)
+	}
+
+	deps, err := loadDependencies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (dependencies{"c": {"b"}}); !reflect.DeepEqual(deps, want) {
+		t.Errorf("loadDependencies() = %v, want %v", deps, want)
+	}
+}
+
+func TestMigrateDependencies(t *testing.T) {
+	todos, restore := useMemoryDatabase(t, []string{dependenciesKey}, "paint", "buy paint", "paint")
+	defer restore()
+	paint, buy := todos[0].ID, todos[1].ID
+	if err := saveDependencies(dependencies{"paint": {"buy paint", "gone"}, "gone": {"paint"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateDependencies(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	deps, err := loadDependencies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (dependencies{paint: {buy}}); !reflect.DeepEqual(deps, want) {
+		t.Errorf("migrateDependencies() stored %v, want %v", deps, want)
+	}
+}
+
+func TestOpenBlockers(t *testing.T) {
+	todos, restore := useMemoryDatabase(t, []string{dependenciesKey}, "ship", "test", "review")
+	defer restore()
+	if err := database.CompleteTodo(context.Background(), todos[2].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveDependencies(dependencies{todos[0].ID: {todos[1].ID, todos[2].ID, "deleted"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	blockers, err := openBlockers(context.Background(), todos[0].ID, tododb.TodoFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(blockers, []string{"test"}) {
+		t.Errorf("openBlockers() = %q, want [test]", blockers)
+	}
+	if blockers, err := openBlockers(context.Background(), todos[1].ID, tododb.TodoFilter{}); err != nil || len(blockers) != 0 {
+		t.Errorf("openBlockers() of an unblocked todo = %q, %v", blockers, err)
+	}
+}
+
+func dependencyRouter() *gin.Engine {
+	router := gin.New()
+	router.GET("/api/v1/dependencies", dependencyGraphHandler)
+	router.PUT("/api/v1/dependencies", addDependencyHandler)
+	router.DELETE("/api/v1/dependencies", deleteDependencyHandler)
+
+	return router
+}
+
+func TestDependencyHandlers(t *testing.T) {
+	todos, restore := useMemoryDatabase(t, []string{dependenciesKey}, "a", "b", "c")
+	defer restore()
+	if err := database.CompleteTodo(context.Background(), todos[2].ID); err != nil {
+		t.Fatal(err)
+	}
+	a, b, c := todos[0].ID, todos[1].ID, todos[2].ID
+	link := func(todo, blockedBy string) string {
+		return `{"todo":"` + todo + `","blockedBy":"` + blockedBy + `"}`
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		body   string
+		status int
+	}{
+		{name: "link", method: http.MethodPut, body: link(b, a), status: http.StatusOK},
+		{name: "link again", method: http.MethodPut, body: link(b, a), status: http.StatusOK},
+		{name: "cycle", method: http.MethodPut, body: link(a, b), status: http.StatusConflict},
+		{name: "itself", method: http.MethodPut, body: link(a, a), status: http.StatusBadRequest},
+		{name: "missing blocker", method: http.MethodPut, body: `{"todo":"` + a + `"}`, status: http.StatusBadRequest},
+		{name: "done todo", method: http.MethodPut, body: link(a, c), status: http.StatusNotFound},
+		{name: "unknown todo", method: http.MethodPut, body: link("unknown", a), status: http.StatusNotFound},
+		{name: "unlink", method: http.MethodDelete, query: "?todo=" + b + "&blockedBy=" + a, status: http.StatusNoContent},
+		{name: "cycle after unlink", method: http.MethodPut, body: link(a, b), status: http.StatusOK},
+	}
+
+	router := dependencyRouter()
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(test.method, "/api/v1/dependencies"+test.query, strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != test.status {
+			t.Errorf("%s: answered %d, want %d: %s", test.name, recorder.Code, test.status, recorder.Body)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/dependencies", nil))
+	var graph struct {
+		Nodes []dependencyNode `json:"nodes"`
+		Edges []dependencyLink `json:"edges"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &graph); err != nil {
+		t.Fatal(err)
+	}
+	wantNodes := []dependencyNode{{ID: a, Title: "a", Open: true, Blocked: true}, {ID: b, Title: "b", Open: true}}
+	if !reflect.DeepEqual(graph.Nodes, wantNodes) || !reflect.DeepEqual(graph.Edges, []dependencyLink{{Todo: a, BlockedBy: b}}) {
+		t.Errorf("the graph is %+v", graph)
+	}
+}
+
+func TestDependencyDot(t *testing.T) {
+	nodes := []dependencyNode{
+		{ID: "1", Title: `say "hi"`, Open: true, Blocked: true},
+		{ID: "2", Title: "done"},
+		{ID: "3", Open: false},
+		{ID: "4", Title: "open", Open: true},
+	}
+	edges := []dependencyLink{{Todo: "1", BlockedBy: "4"}, {Todo: "1", BlockedBy: "3"}}
+
+	want := `digraph todos {
+  rankdir=LR;
+  "1" [label="say \"hi\"", color=red];
+  "2" [label="done", style=dashed];
+  "3" [label="3", style=dashed];
+  "4" [label="open"];
+  "4" -> "1";
+  "3" -> "1";
+}
+`
+	if got := string(dependencyDot(nodes, edges)); got != want {
+		t.Errorf("dependencyDot() = %s, want %s", got, want)
+	}
+}
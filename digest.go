@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	activityKey = "activity"
+	// activityDays is how long the activity is kept, longer than the weekly
+	// digest needs
+	activityDays = 35
+
+	defaultDigestPeriod = "daily"
+
+	defaultDigestTemplate = `Todo digest of the {{.List}} list, {{.From}} to {{.To}}
+{{range .Users}}
+{{.User}}: {{.Created}} created, {{.Completed}} completed, {{.Open}} open, {{.Overdue}} overdue
+{{- range .OverdueTodos}}
+  - {{.}}
+{{- end}}
+{{end}}`
+)
+
+var digestPeriods = map[string]int{
+	"daily":  1,
+	"weekly": 7,
+}
+
+// activityCounts are the todos a user created and completed on one day.
+type activityCounts struct {
+	Created   int `json:"created"`
+	Completed int `json:"completed"`
+}
+
+// activity maps days to users to their counts.
+type activity map[string]map[string]activityCounts
+
+type digestEntry struct {
+	User         string   `json:"user"`
+	Created      int      `json:"created"`
+	Completed    int      `json:"completed"`
+	Open         int      `json:"open"`
+	Overdue      int      `json:"overdue"`
+	OverdueTodos []string `json:"overdueTodos"`
+}
+
+// digest summarizes the days from From to To, Open and Overdue are counted
+// when the digest is made.
+type digest struct {
+	Period string        `json:"period"`
+	List   string        `json:"list"`
+	From   string        `json:"from"`
+	To     string        `json:"to"`
+	Total  digestEntry   `json:"total"`
+	Users  []digestEntry `json:"users"`
+}
+
+// activityMu serializes the changes of this instance, the activity is read,
+// changed and written back as a whole.
+var activityMu sync.Mutex
+
+// loadActivity reads the activity kept under the namespace, see
+// recordsNamespace.
+func loadActivity(namespace string) (activity, error) {
+	days := activity{}
+	value, err := tododb.KVOf(database).GetValue(namespace + activityKey)
+	if err == tododb.ErrNotFound {
+		return days, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return days, json.Unmarshal([]byte(value), &days)
+}
+
+func todoUser(todo string) string {
+	if match := todoAssigneePattern.FindStringSubmatch(todo); match != nil {
+		return match[1]
+	}
+	return everyone
+}
+
+// recordActivity counts a created or completed todo for the digests, days
+// older than activityDays are dropped on the way.
+func recordActivity(namespace, todo string, count func(*activityCounts)) error {
+	activityMu.Lock()
+	defer activityMu.Unlock()
+
+	days, err := loadActivity(namespace)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	oldest := now.AddDate(0, 0, -activityDays).Format(dayFormat)
+	for day := range days {
+		if day < oldest {
+			delete(days, day)
+		}
+	}
+
+	today := now.Format(dayFormat)
+	if days[today] == nil {
+		days[today] = map[string]activityCounts{}
+	}
+	user := todoUser(todo)
+	counts := days[today][user]
+	count(&counts)
+	days[today][user] = counts
+
+	value, err := json.Marshal(days)
+	if err != nil {
+		return err
+	}
+
+	return tododb.KVOf(database).SetValue(namespace+activityKey, string(value), 0)
+}
+
+func recordCreated(namespace, todo string) error {
+	return recordActivity(namespace, todo, func(counts *activityCounts) { counts.Created++ })
+}
+
+func recordCompleted(namespace, todo string) error {
+	return recordActivity(namespace, todo, func(counts *activityCounts) { counts.Completed++ })
+}
+
+// makeDigest summarizes the days of period before now, a morning digest
+// covers yesterday or the last week.
+func makeDigest(ctx context.Context, period string, now time.Time) (digest, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	from := today.AddDate(0, 0, -digestPeriods[period])
+	result := digest{
+		Period: period,
+		List:   defaultListName,
+		From:   from.Format(dayFormat),
+		To:     today.AddDate(0, 0, -1).Format(dayFormat),
+		Total:  digestEntry{User: "all", OverdueTodos: []string{}},
+		Users:  []digestEntry{},
+	}
+
+	days, err := loadActivity("")
+	if err != nil {
+		return result, err
+	}
+
+	users := map[string]*digestEntry{}
+	user := func(name string) *digestEntry {
+		if users[name] == nil {
+			users[name] = &digestEntry{User: name, OverdueTodos: []string{}}
+		}
+		return users[name]
+	}
+
+	for day, counts := range days {
+		if day < result.From || day > result.To {
+			continue
+		}
+		for name, c := range counts {
+			user(name).Created += c.Created
+			user(name).Completed += c.Completed
+		}
+	}
+
+	err = database.ForEachTodo(ctx, func(todo tododb.Todo) error {
+		if todo.Done {
+			return nil
+		}
+		entry := user(todoUser(todo.Title))
+		entry.Open++
+		if day, ok := dueDay(todo); ok && day < today.Format(dayFormat) {
+			entry.Overdue++
+			entry.OverdueTodos = append(entry.OverdueTodos, todo.Title)
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	for _, entry := range users {
+		result.Users = append(result.Users, *entry)
+		result.Total.Created += entry.Created
+		result.Total.Completed += entry.Completed
+		result.Total.Open += entry.Open
+		result.Total.Overdue += entry.Overdue
+		result.Total.OverdueTodos = append(result.Total.OverdueTodos, entry.OverdueTodos...)
+	}
+	sort.Slice(result.Users, func(i, j int) bool {
+		return result.Users[i].User < result.Users[j].User
+	})
+
+	return result, nil
+}
+
+func digestTemplate(config DigestConfig) (*template.Template, error) {
+	text := config.Template
+	if text == "" {
+		text = defaultDigestTemplate
+	}
+
+	return template.New("digest").Parse(text)
+}
+
+func renderDigest(config DigestConfig, d digest) (string, error) {
+	tmpl, err := digestTemplate(config)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, d); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// sendDigest posts the rendered digest as text, which chat webhooks like
+// the ones of Slack or Mattermost show, and the digest itself to
+// Digest.WebhookURL. The hooks of the digest event get it queued.
+func sendDigest(config DigestConfig, d digest) error {
+	text, err := renderDigest(config, d)
+	if err != nil {
+		return err
+	}
+	queueWebhookEvent(webhookEvent{Type: webhookDigest, List: d.List, Digest: &d, Text: text, Time: time.Now().UTC()})
+	if config.WebhookURL == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(gin.H{"text": text, "digest": d})
+	if err != nil {
+		return err
+	}
+
+	return postWebhook(config.WebhookURL, "application/json", nil, body)
+}
+
+// digestsWanted reports whether the digests go anywhere, to
+// Digest.WebhookURL or a hook of the digest event.
+func digestsWanted(config DigestConfig) bool {
+	if config.WebhookURL != "" {
+		return true
+	}
+	for _, hook := range webhooks {
+		if hook.events[webhookDigest] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nextDigest returns the next Hour after now, for weekly digests on a
+// Monday.
+func nextDigest(config DigestConfig, now time.Time) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), config.Hour, 0, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	for config.Period == "weekly" && next.Weekday() != time.Monday {
+		next = next.AddDate(0, 0, 1)
+	}
+
+	return next
+}
+
+// runDigests sends a digest to the webhooks at every Hour of the period.
+// Every instance sends its own, run them on one replica only.
+func runDigests(config DigestConfig) {
+	for {
+		next := nextDigest(config, time.Now())
+		logger.Infof("Next %s digest at %s", config.Period, next.Format(time.RFC3339))
+		time.Sleep(time.Until(next))
+
+		d, err := makeDigest(context.Background(), config.Period, time.Now())
+		if err == nil {
+			err = sendDigest(config, d)
+		}
+		if err != nil {
+			logger.Errorf("%s digest: %v", config.Period, err)
+		}
+	}
+}
+
+func digestConfigOf(c *gin.Context) (DigestConfig, bool) {
+	config := appConfig.Digest
+	config.Period = c.DefaultQuery("period", config.Period)
+	if _, exists := digestPeriods[config.Period]; !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("unknown period %q, use daily or weekly", config.Period),
+		})
+		return config, false
+	}
+
+	return config, true
+}
+
+// previewDigestHandler renders the digest of ?period= like it would be sent,
+// with ?format=json the digest itself.
+func previewDigestHandler(c *gin.Context) {
+	config, ok := digestConfigOf(c)
+	if !ok {
+		return
+	}
+
+	d, err := makeDigest(c.Request.Context(), config.Period, time.Now())
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	if c.Query("format") == "json" {
+		c.JSON(http.StatusOK, d)
+		return
+	}
+
+	text, err := renderDigest(config, d)
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.String(http.StatusOK, text)
+}
+
+// sendDigestHandler sends the digest of ?period= right away.
+func sendDigestHandler(c *gin.Context) {
+	config, ok := digestConfigOf(c)
+	if !ok {
+		return
+	}
+
+	if !digestsWanted(config) {
+		c.JSON(http.StatusConflict, gin.H{
+			"errors": "neither Digest.WebhookURL nor a webhook of the digest event is configured",
+		})
+		return
+	}
+
+	d, err := makeDigest(c.Request.Context(), config.Period, time.Now())
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	if err := sendDigest(config, d); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
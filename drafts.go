@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	defaultDraftHours = 24
+	// draftMaxBytes is the largest draft kept, a description of a few pages
+	draftMaxBytes = 64 << 10
+)
+
+// draft is an edit of a todo that isn't saved yet, the fields as they are in
+// the edit form. It is kept per account and todo for DraftHours, so a
+// crashed or closed browser can continue the edit.
+type draft struct {
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Due         string    `json:"due,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	SavedAt     time.Time `json:"savedAt"`
+}
+
+func draftKey(c *gin.Context, id string) string {
+	return "draft:" + c.GetString(accountKey) + ":" + id
+}
+
+// dropDraft forgets the draft of the todo with id, once the edit is saved.
+func dropDraft(c *gin.Context, id string) {
+	if err := tododb.KVOf(database).DeleteValue(draftKey(c, id)); err != nil {
+		logger.Errorf("%v", err)
+	}
+}
+
+func getDraftHandler(c *gin.Context) {
+	value, err := tododb.KVOf(database).GetValue(draftKey(c, c.Param("id")))
+	if err == tododb.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": "no draft of this todo",
+		})
+		return
+	}
+
+	var saved draft
+	if err == nil {
+		err = json.Unmarshal([]byte(value), &saved)
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, saved)
+}
+
+// saveDraftHandler replaces the draft of a todo, the edit form sends it
+// while the user types. Every save keeps it for another DraftHours.
+func saveDraftHandler(c *gin.Context) {
+	var edit draft
+	if err := c.ShouldBindJSON(&edit); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	if _, ok := todoByID(c); !ok {
+		return
+	}
+
+	edit.SavedAt = time.Now().UTC()
+	value, err := json.Marshal(edit)
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	if len(value) > draftMaxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"errors": fmt.Sprintf("a draft must not be larger than %d bytes", draftMaxBytes),
+		})
+		return
+	}
+
+	ttl := time.Duration(appConfig.DraftHours) * time.Hour
+	if err := tododb.KVOf(database).SetValue(draftKey(c, c.Param("id")), string(value), ttl); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, edit)
+}
+
+// deleteDraftHandler drops a draft, when the edit is cancelled.
+func deleteDraftHandler(c *gin.Context) {
+	dropDraft(c, c.Param("id"))
+	c.Status(http.StatusNoContent)
+}
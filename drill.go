@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	defaultDrillSeconds = 30
+	maxDrillDuration    = 10 * time.Minute
+)
+
+// failoverDrillHandler cuts the app off from the primary of the backend for
+// ?seconds=, zero ends a running drill. Only available with FailoverDrills
+// enabled, which must never be done in production.
+func failoverDrillHandler(c *gin.Context) {
+	if !appConfig.FailoverDrills {
+		c.JSON(http.StatusForbidden, gin.H{
+			"errors": "failover drills are disabled, set FailoverDrills to enable them",
+		})
+		return
+	}
+
+	driller, ok := database.(tododb.FailoverDriller)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{
+			"errors": "the database backend doesn't support failover drills",
+		})
+		return
+	}
+
+	seconds, err := strconv.Atoi(c.DefaultQuery("seconds", strconv.Itoa(defaultDrillSeconds)))
+	duration := time.Duration(seconds) * time.Second
+	if err != nil || seconds < 0 || duration > maxDrillDuration {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("seconds must be between 0 and %d", int(maxDrillDuration.Seconds())),
+		})
+		return
+	}
+
+	until := driller.BlockPrimary(duration)
+	logger.Warnf("Failover drill started by %s for %s", c.ClientIP(), duration)
+	c.JSON(http.StatusOK, gin.H{
+		"blockedUntil": until.UTC(),
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+// dueDay returns the local day a todo is due. Todos saved before they had a
+// due date of their own only have it in the title, like "(due 2019-05-01)".
+func dueDay(todo tododb.Todo) (string, bool) {
+	if todo.Due != nil {
+		return todo.Due.In(time.Local).Format(dayFormat), true
+	}
+	if match := todoDuePattern.FindStringSubmatch(todo.Title); match != nil {
+		return match[1], true
+	}
+
+	return "", false
+}
+
+// parseDue reads a due date given as a day, which means its start in local
+// time, or as an RFC 3339 timestamp. An empty value is no due date.
+func parseDue(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	due, err := time.ParseInLocation(dayFormat, value, time.Local)
+	if err != nil {
+		if due, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, fmt.Errorf("invalid due %q, use a day like 2019-05-01 or an RFC 3339 time", value)
+		}
+	}
+
+	return &due, nil
+}
+
+// newTodo is tododb.NewTodo, due on the day of a "(due 2019-05-01)" in the
+// title and tagged with the "#home" tags in it.
+func newTodo(title string) tododb.Todo {
+	todo := tododb.NewTodo(title)
+	todo.Tags = titleTags(title)
+	if match := todoDuePattern.FindStringSubmatch(title); match != nil {
+		todo.Due, _ = parseDue(match[1])
+	}
+
+	return todo
+}
+
+func newTodos(titles []string) []tododb.Todo {
+	todos := make([]tododb.Todo, len(titles))
+	for i, title := range titles {
+		todos[i] = newTodo(title)
+	}
+
+	return todos
+}
+
+// overdueTodosHandler returns the open todos whose due date has passed, the
+// longest overdue first.
+func overdueTodosHandler(c *gin.Context) {
+	todos, err := todosOf(c).GetOverdueTodos(c.Request.Context())
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, todos)
+}
+
+// dueTodosHandler returns the todos due before ?before=, by default the end
+// of today, the soonest due first.
+func dueTodosHandler(c *gin.Context) {
+	now := time.Now()
+	before := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	if value := c.Query("before"); value != "" {
+		due, err := parseDue(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+		before = *due
+	}
+
+	todos, err := todosOf(c).GetTodosDueBefore(c.Request.Context(), before)
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, todos)
+}
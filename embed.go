@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	// defaultListName is the only list until there are more of them
+	defaultListName   = "default"
+	defaultEmbedLimit = 20
+	maxEmbedRefresh   = 3600
+)
+
+var embedTemplate = template.Must(template.New("embed").Funcs(brandingFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    {{if .Refresh}}<meta http-equiv="refresh" content="{{.Refresh}}">{{end}}
+    <title>Todo list - {{appName}}</title>
+    <style>
+      body { font-family: sans-serif; font-size: 14px; margin: 0.5em; }
+      ul { margin: 0; padding-left: 1.2em; }
+      li { padding: 0.15em 0; }
+      .more { color: #666; list-style: none; }
+    </style>
+  </head>
+  <body>
+    <ul>
+    {{range .Todos}}  <li>{{.}}</li>
+    {{else}}  <li class="more">Nothing to do.</li>
+    {{end}}{{if .More}}  <li class="more">and {{.More}} more</li>
+    {{end}}</ul>
+  </body>
+</html>
+`))
+
+// embedTodoHandler renders the todos without an owner in a list small enough
+// for an iframe in a wiki or dashboard. Only the sites in EmbedFrameAncestors may frame it.
+func embedTodoHandler(c *gin.Context) {
+	if c.Param("list") != defaultListName {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": fmt.Sprintf("unknown list %q", c.Param("list")),
+		})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultEmbedLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("invalid limit: %q", c.Query("limit")),
+		})
+		return
+	}
+
+	refresh, err := strconv.Atoi(c.DefaultQuery("refresh", "0"))
+	if err != nil || refresh < 0 || refresh > maxEmbedRefresh {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("refresh must be between 0 and %d seconds", maxEmbedRefresh),
+		})
+		return
+	}
+
+	todos, err := publicTodos().GetAllTodos(c.Request.Context())
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	more := 0
+	if len(todos) > limit {
+		more = len(todos) - limit
+		todos = todos[:limit]
+	}
+
+	var buf bytes.Buffer
+	err = embedTemplate.Execute(&buf, struct {
+		Todos   []string
+		More    int
+		Refresh int
+	}{
+		Todos:   tododb.Titles(todos),
+		More:    more,
+		Refresh: refresh,
+	})
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors "+strings.Join(appConfig.EmbedFrameAncestors, " "))
+	writeWithETag(c, "text/html; charset=utf-8", buf.Bytes())
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	ndjsonContentType = "application/x-ndjson"
+
+	// flushEvery controls how many encoded todos are buffered before they
+	// are pushed to the client.
+	flushEvery = 500
+)
+
+// wantsNDJSON reports whether the client asked for newline delimited JSON,
+// either by ?format=ndjson or by its Accept header.
+func wantsNDJSON(c *gin.Context) bool {
+	if format := c.Query("format"); format != "" {
+		return strings.ToLower(format) == "ndjson"
+	}
+
+	return strings.Contains(c.GetHeader("Accept"), ndjsonContentType)
+}
+
+// streamTodos encodes the todos one by one while they are read from the
+// database, so memory usage doesn't grow with the size of the list. With
+// titlesOnly every todo is just its title, like /todo always answered.
+func streamTodos(c *gin.Context, ndjson, titlesOnly bool, filter tododb.TodoFilter) {
+	contentType := "application/json; charset=utf-8"
+	if ndjson {
+		contentType = ndjsonContentType
+	}
+	c.Header("Content-Type", contentType)
+	c.Status(http.StatusOK)
+
+	enc := json.NewEncoder(c.Writer)
+	count := 0
+	err := todosOf(c).ForEachTodo(c.Request.Context(), func(todo tododb.Todo) error {
+		if !filter.Matches(todo) {
+			return nil
+		}
+		if !ndjson {
+			sep := ","
+			if count == 0 {
+				sep = "["
+			}
+			if _, err := c.Writer.WriteString(sep); err != nil {
+				return err
+			}
+		}
+
+		var value interface{} = todo
+		if titlesOnly {
+			value = todo.Title
+		}
+		if err := enc.Encode(value); err != nil {
+			return err
+		}
+
+		count++
+		if count%flushEvery == 0 {
+			c.Writer.Flush()
+		}
+
+		return nil
+	})
+	if err != nil {
+		// The status line is already sent, all we can do is to cut the
+		// response short and log the reason.
+		logger.Errorf("%v", err)
+		return
+	}
+
+	if !ndjson {
+		closing := "]"
+		if count == 0 {
+			closing = "[]"
+		}
+		c.Writer.WriteString(closing)
+	}
+	c.Writer.Flush()
+}
+
+func exportTodoHandler(c *gin.Context) {
+	if strings.ToLower(c.Query("format")) == "csv" {
+		exportCSV(c)
+		return
+	}
+
+	filename := "todos.json"
+	if wantsNDJSON(c) {
+		filename = "todos.ndjson"
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	streamTodos(c, wantsNDJSON(c), false, tododb.TodoFilter{})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/johscheuer/todo-app-web/features"
+)
+
+var contentionMu sync.Mutex
+
+// lockContentionScenario takes a process wide lock while the
+// perf-lock-contention scenario is enabled, so concurrent list requests
+// queue up behind each other. The returned func releases it.
+func lockContentionScenario() func() {
+	if !features.Enabled(features.PerfLockContention) {
+		return func() {}
+	}
+
+	contentionMu.Lock()
+	return contentionMu.Unlock
+}
+
+// initFeatureProvider switches the flags to the configured provider, every
+// instance is evaluated with its hostname as targeting key.
+func initFeatureProvider(config FeatureProviderConfig) error {
+	switch config.Name {
+	case "", features.BuiltinProvider:
+		return nil
+	case features.OFREPProvider:
+		if config.URL == "" {
+			return fmt.Errorf("the %s feature provider needs a URL", config.Name)
+		}
+		hostname, _ := os.Hostname()
+		evalCtx := features.EvaluationContext{
+			"targetingKey": hostname,
+			"version":      buildinfo.Version,
+		}
+		provider := features.NewOFREPProvider(config.URL, config.Headers, evalCtx, time.Duration(config.PollSeconds)*time.Second)
+		features.SetProvider(provider, evalCtx)
+		logger.Infof("Feature flags are served by %s", config.URL)
+		return nil
+	}
+
+	return fmt.Errorf("unknown feature provider %q, use %s or %s", config.Name, features.BuiltinProvider, features.OFREPProvider)
+}
+
+func listFeaturesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, features.List())
+}
+
+func setFeatureHandler(c *gin.Context) {
+	var body struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	err := features.Set(c.Param("name"), body.Enabled)
+	if err == features.ErrReadOnly {
+		c.JSON(http.StatusConflict, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, features.List())
+}
@@ -0,0 +1,136 @@
+package features
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	// PerfNPlusOne makes backends load the list with one call per todo
+	PerfNPlusOne = "perf-n-plus-one"
+	// PerfLockContention serializes list reads behind a global lock
+	PerfLockContention = "perf-lock-contention"
+	// Gamification tracks streaks and achievements of completed todos
+	Gamification = "gamification"
+
+	// BuiltinProvider serves the flags of the config file and the admin API
+	BuiltinProvider = "builtin"
+)
+
+// ErrReadOnly is returned by Set while another provider serves the flags.
+var ErrReadOnly = errors.New("feature flags are served by another provider, change them there")
+
+type Flag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+	// Reason is why the provider resolved Enabled, like STATIC or ERROR
+	Reason string `json:"reason,omitempty"`
+}
+
+var (
+	mu       sync.RWMutex
+	provider Provider = builtinProvider{}
+	evalCtx  EvaluationContext
+	flags    = map[string]*Flag{
+		PerfNPlusOne: {
+			Name:        PerfNPlusOne,
+			Description: "Performance scenario: load the todo list with one backend call per todo",
+		},
+		PerfLockContention: {
+			Name:        PerfLockContention,
+			Description: "Performance scenario: hold a global lock while reading and rendering the list",
+		},
+		Gamification: {
+			Name:        Gamification,
+			Description: "Track streaks and achievements of completed todos and show them in the UI",
+		},
+	}
+)
+
+// SetProvider makes p serve the flags, the values of the built-in provider
+// are the defaults whenever p can't resolve a flag. evalCtx is passed to
+// every evaluation.
+func SetProvider(p Provider, ctx EvaluationContext) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	provider = p
+	evalCtx = ctx
+}
+
+func ProviderName() string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return provider.Metadata().Name
+}
+
+func evaluate(name string) BoolResolutionDetail {
+	mu.RLock()
+	flag, exists := flags[name]
+	if !exists {
+		mu.RUnlock()
+		return BoolResolutionDetail{ResolutionDetail: ResolutionDetail{Reason: ReasonError, ErrorCode: ErrorFlagNotFound}}
+	}
+	defaultValue := flag.Enabled
+	p, ctx := provider, evalCtx
+	mu.RUnlock()
+
+	return p.BooleanEvaluation(context.Background(), name, defaultValue, ctx)
+}
+
+// Enabled reports whether the flag is switched on. Unknown flags are off.
+func Enabled(name string) bool {
+	return evaluate(name).Value
+}
+
+func Set(name string, enabled bool) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	flag, exists := flags[name]
+	if !exists {
+		return fmt.Errorf("unknown feature flag %q", name)
+	}
+	if _, builtin := provider.(builtinProvider); !builtin {
+		return ErrReadOnly
+	}
+	flag.Enabled = enabled
+
+	return nil
+}
+
+// Init applies the flag values of the config file, with another provider
+// they are the defaults.
+func Init(values map[string]bool) error {
+	for name, enabled := range values {
+		if err := Set(name, enabled); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// List returns the flags with their values as resolved by the provider.
+func List() []Flag {
+	mu.RLock()
+	list := make([]Flag, 0, len(flags))
+	for _, flag := range flags {
+		list = append(list, *flag)
+	}
+	mu.RUnlock()
+
+	for i := range list {
+		detail := evaluate(list[i].Name)
+		list[i].Enabled = detail.Value
+		list[i].Reason = detail.Reason
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list
+}
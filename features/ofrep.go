@@ -0,0 +1,196 @@
+package features
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/logging"
+)
+
+const (
+	// OFREPProvider evaluates the flags with the OpenFeature Remote
+	// Evaluation Protocol, which flagd and the relay proxies of other
+	// vendors serve
+	OFREPProvider = "ofrep"
+
+	defaultOFREPPollInterval = 30 * time.Second
+	ofrepTimeout             = 5 * time.Second
+)
+
+var logger = logging.New("features")
+
+type ofrepFlag struct {
+	Key          string      `json:"key"`
+	Value        interface{} `json:"value"`
+	Variant      string      `json:"variant"`
+	Reason       string      `json:"reason"`
+	ErrorCode    string      `json:"errorCode"`
+	ErrorDetails string      `json:"errorDetails"`
+}
+
+// ofrepProvider polls the bulk evaluation of all flags and answers from the
+// last result, checking a flag must not cost a request. Until the first poll
+// succeeds the defaults are used.
+type ofrepProvider struct {
+	url     string
+	headers map[string]string
+	evalCtx EvaluationContext
+	client  *http.Client
+
+	mu    sync.RWMutex
+	etag  string
+	flags map[string]ofrepFlag
+	err   error
+}
+
+// NewOFREPProvider polls url, e.g. http://flagd:8016, every interval.
+// headers are sent with every request, like an Authorization with the key of
+// the relay proxy.
+func NewOFREPProvider(url string, headers map[string]string, evalCtx EvaluationContext, interval time.Duration) Provider {
+	if interval <= 0 {
+		interval = defaultOFREPPollInterval
+	}
+
+	provider := &ofrepProvider{
+		url:     strings.TrimRight(url, "/"),
+		headers: headers,
+		evalCtx: evalCtx,
+		client:  &http.Client{Timeout: ofrepTimeout},
+	}
+	provider.poll()
+	go func() {
+		for range time.Tick(interval) {
+			provider.poll()
+		}
+	}()
+
+	return provider
+}
+
+func (provider *ofrepProvider) Metadata() ProviderMetadata {
+	return ProviderMetadata{Name: OFREPProvider}
+}
+
+func (provider *ofrepProvider) poll() {
+	flags, etag, err := provider.evaluate()
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if err != nil {
+		if provider.err == nil {
+			logger.Warnf("Polling the feature flags of %s failed: %v", provider.url, err)
+		}
+		provider.err = err
+		return
+	}
+	provider.err = nil
+	if flags != nil {
+		provider.flags = flags
+		provider.etag = etag
+	}
+}
+
+// evaluate returns nil flags if they didn't change since the last poll.
+func (provider *ofrepProvider) evaluate() (map[string]ofrepFlag, string, error) {
+	body, err := json.Marshal(map[string]interface{}{"context": provider.evalCtx})
+	if err != nil {
+		return nil, "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, provider.url+"/ofrep/v1/evaluate/flags", bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range provider.headers {
+		req.Header.Set(name, value)
+	}
+	provider.mu.RLock()
+	if provider.etag != "" {
+		req.Header.Set("If-None-Match", provider.etag)
+	}
+	provider.mu.RUnlock()
+
+	resp, err := provider.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, "", nil
+	}
+	answer, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("%s answered with %s: %s", req.URL, resp.Status, bytes.TrimSpace(answer))
+	}
+
+	var result struct {
+		Flags []ofrepFlag `json:"flags"`
+	}
+	if err := json.Unmarshal(answer, &result); err != nil {
+		return nil, "", err
+	}
+
+	flags := make(map[string]ofrepFlag, len(result.Flags))
+	for _, flag := range result.Flags {
+		flags[flag.Key] = flag
+	}
+
+	return flags, resp.Header.Get("ETag"), nil
+}
+
+func (provider *ofrepProvider) BooleanEvaluation(ctx context.Context, flag string, defaultValue bool, evalCtx EvaluationContext) BoolResolutionDetail {
+	provider.mu.RLock()
+	defer provider.mu.RUnlock()
+
+	failed := func(code, message string) BoolResolutionDetail {
+		return BoolResolutionDetail{
+			Value: defaultValue,
+			ResolutionDetail: ResolutionDetail{
+				Reason:       ReasonError,
+				ErrorCode:    code,
+				ErrorMessage: message,
+			},
+		}
+	}
+
+	if provider.flags == nil {
+		return failed(ErrorNotReady, fmt.Sprintf("no flags from %s yet", provider.url))
+	}
+	f, exists := provider.flags[flag]
+	if !exists {
+		return failed(ErrorFlagNotFound, "")
+	}
+	if f.ErrorCode != "" {
+		return failed(f.ErrorCode, f.ErrorDetails)
+	}
+	value, ok := f.Value.(bool)
+	if !ok {
+		return failed(ErrorTypeMismatch, fmt.Sprintf("%v isn't a boolean", f.Value))
+	}
+
+	reason := f.Reason
+	if provider.err != nil {
+		reason = ReasonCached
+	}
+
+	return BoolResolutionDetail{
+		Value: value,
+		ResolutionDetail: ResolutionDetail{
+			Variant: f.Variant,
+			Reason:  reason,
+		},
+	}
+}
@@ -0,0 +1,77 @@
+package features
+
+import (
+	"context"
+)
+
+// Reasons and error codes of a resolution, as defined by OpenFeature.
+const (
+	ReasonStatic   = "STATIC"
+	ReasonDefault  = "DEFAULT"
+	ReasonDisabled = "DISABLED"
+	ReasonCached   = "CACHED"
+	ReasonError    = "ERROR"
+
+	ErrorFlagNotFound = "FLAG_NOT_FOUND"
+	ErrorTypeMismatch = "TYPE_MISMATCH"
+	ErrorNotReady     = "PROVIDER_NOT_READY"
+	ErrorGeneral      = "GENERAL"
+)
+
+// EvaluationContext is the flattened evaluation context of OpenFeature, the
+// targetingKey entry identifies the subject of the evaluation.
+type EvaluationContext map[string]interface{}
+
+type ProviderMetadata struct {
+	Name string `json:"name"`
+}
+
+// ResolutionDetail explains a resolved value, an ErrorCode means the default
+// value was returned.
+type ResolutionDetail struct {
+	Variant      string `json:"variant,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+	ErrorCode    string `json:"errorCode,omitempty"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+}
+
+type BoolResolutionDetail struct {
+	Value bool
+	ResolutionDetail
+}
+
+// Provider is the boolean part of the OpenFeature provider interface, all
+// flags of the app are booleans. A provider of the OpenFeature Go SDK fits
+// with a small adapter.
+type Provider interface {
+	Metadata() ProviderMetadata
+	BooleanEvaluation(ctx context.Context, flag string, defaultValue bool, evalCtx EvaluationContext) BoolResolutionDetail
+}
+
+// builtinProvider serves the values of the config file and the admin API.
+type builtinProvider struct{}
+
+func (builtinProvider) Metadata() ProviderMetadata {
+	return ProviderMetadata{Name: BuiltinProvider}
+}
+
+func (builtinProvider) BooleanEvaluation(ctx context.Context, flag string, defaultValue bool, evalCtx EvaluationContext) BoolResolutionDetail {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	f, exists := flags[flag]
+	if !exists {
+		return BoolResolutionDetail{
+			Value: defaultValue,
+			ResolutionDetail: ResolutionDetail{
+				Reason:    ReasonError,
+				ErrorCode: ErrorFlagNotFound,
+			},
+		}
+	}
+
+	return BoolResolutionDetail{
+		Value:            f.Enabled,
+		ResolutionDetail: ResolutionDetail{Reason: ReasonStatic},
+	}
+}
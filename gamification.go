@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/features"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	statsKey = "stats"
+	// everyone collects the completions of todos without an assignee
+	everyone  = "everyone"
+	dayFormat = "2006-01-02"
+)
+
+type achievement struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	unlocked    func(userStats) bool
+}
+
+var achievements = []achievement{
+	{Name: "first-todo", Description: "Completed the first todo", unlocked: func(s userStats) bool { return s.Completed >= 1 }},
+	{Name: "ten-todos", Description: "Completed 10 todos", unlocked: func(s userStats) bool { return s.Completed >= 10 }},
+	{Name: "century", Description: "Completed 100 todos", unlocked: func(s userStats) bool { return s.Completed >= 100 }},
+	{Name: "streak-3", Description: "Completed todos 3 days in a row", unlocked: func(s userStats) bool { return s.LongestStreak >= 3 }},
+	{Name: "streak-7", Description: "Completed todos 7 days in a row", unlocked: func(s userStats) bool { return s.LongestStreak >= 7 }},
+	{Name: "streak-30", Description: "Completed todos 30 days in a row", unlocked: func(s userStats) bool { return s.LongestStreak >= 30 }},
+}
+
+type unlockedAchievement struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Unlocked    time.Time `json:"unlocked"`
+}
+
+// userStats counts the completed todos of an assignee. A streak is the
+// number of days in a row with at least one completed todo.
+type userStats struct {
+	User          string                `json:"user"`
+	Completed     int64                 `json:"completed"`
+	CurrentStreak int                   `json:"currentStreak"`
+	LongestStreak int                   `json:"longestStreak"`
+	LastDay       string                `json:"lastDay"`
+	Achievements  []unlockedAchievement `json:"achievements"`
+}
+
+// statsMu serializes the updates of this instance, the stats are read,
+// changed and written back as a whole.
+var statsMu sync.Mutex
+
+// loadStats reads the stats kept under the namespace, see recordsNamespace.
+func loadStats(namespace string) (map[string]userStats, error) {
+	stats := map[string]userStats{}
+	value, err := tododb.KVOf(database).GetValue(namespace + statsKey)
+	if err == tododb.ErrNotFound {
+		return stats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, json.Unmarshal([]byte(value), &stats)
+}
+
+// complete counts a completed todo of user on the day of now.
+func (stats userStats) complete(now time.Time) userStats {
+	today := now.Format(dayFormat)
+	switch stats.LastDay {
+	case today:
+	case now.AddDate(0, 0, -1).Format(dayFormat):
+		stats.CurrentStreak++
+	default:
+		stats.CurrentStreak = 1
+	}
+	stats.LastDay = today
+	stats.Completed++
+	if stats.CurrentStreak > stats.LongestStreak {
+		stats.LongestStreak = stats.CurrentStreak
+	}
+
+	unlocked := map[string]bool{}
+	for _, a := range stats.Achievements {
+		unlocked[a.Name] = true
+	}
+	for _, a := range achievements {
+		if !unlocked[a.Name] && a.unlocked(stats) {
+			stats.Achievements = append(stats.Achievements, unlockedAchievement{
+				Name:        a.Name,
+				Description: a.Description,
+				Unlocked:    now.UTC(),
+			})
+			logger.Infof("%s unlocked %s", stats.User, a.Name)
+		}
+	}
+
+	return stats
+}
+
+// current ends streaks that weren't continued yesterday or today.
+func (stats userStats) current(now time.Time) userStats {
+	if stats.LastDay != now.Format(dayFormat) && stats.LastDay != now.AddDate(0, 0, -1).Format(dayFormat) {
+		stats.CurrentStreak = 0
+	}
+	if stats.Achievements == nil {
+		stats.Achievements = []unlockedAchievement{}
+	}
+
+	return stats
+}
+
+// recordCompletion updates the stats of the assignee of a completed todo, as
+// long as the gamification feature is on.
+func recordCompletion(namespace, todo string) error {
+	if !features.Enabled(features.Gamification) {
+		return nil
+	}
+
+	user := everyone
+	if match := todoAssigneePattern.FindStringSubmatch(todo); match != nil {
+		user = match[1]
+	}
+
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	stats, err := loadStats(namespace)
+	if err != nil {
+		return err
+	}
+
+	counted := stats[user]
+	counted.User = user
+	stats[user] = counted.complete(time.Now())
+
+	value, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+
+	return tododb.KVOf(database).SetValue(namespace+statsKey, string(value), 0)
+}
+
+// statsHandler reports the tracked time, and streaks and achievements with
+// the gamification feature.
+func statsHandler(c *gin.Context) {
+	tracking, err := loadTimeTracking()
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	now := time.Now()
+	todos, days := tracking.report(now)
+	if todos, err = readableTimes(c, todos); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	result := gin.H{
+		"time": gin.H{
+			"todos": todos,
+			"days":  days,
+		},
+	}
+
+	if !features.Enabled(features.Gamification) {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+
+	stats, err := loadStats("")
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	users := make([]userStats, 0, len(stats))
+	for _, s := range stats {
+		users = append(users, s.current(now))
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].CurrentStreak != users[j].CurrentStreak {
+			return users[i].CurrentStreak > users[j].CurrentStreak
+		}
+		return users[i].Completed > users[j].Completed
+	})
+
+	result["users"] = users
+	result["achievements"] = achievements
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const gcSampleInterval = 10 * time.Second
+
+// ballast is never read, it only makes the heap look bigger to the GC so it
+// runs less often.
+var ballast []byte
+
+func applyGCConfig(config GCConfig) {
+	if config.Percent != 0 {
+		previous := debug.SetGCPercent(config.Percent)
+		logger.Infof("GC percent set to %d, was %d", config.Percent, previous)
+	}
+
+	if config.MemoryLimitMB > 0 {
+		if err := setMemoryLimit(int64(config.MemoryLimitMB) << 20); err != nil {
+			logger.Warnf("%v", err)
+		} else {
+			logger.Infof("Memory limit set to %d MB", config.MemoryLimitMB)
+		}
+	}
+
+	if config.BallastMB > 0 {
+		ballast = make([]byte, config.BallastMB<<20)
+		logger.Infof("Allocated a ballast of %d MB", config.BallastMB)
+	}
+}
+
+// recordGCPauses feeds the pauses of all GCs since the last sample into
+// pauses. runtime.MemStats only keeps the last 256 pauses, if more
+// happened between two samples the older ones are lost.
+func recordGCPauses(pauses prometheus.Histogram) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	lastGC := stats.NumGC
+
+	for range time.Tick(gcSampleInterval) {
+		runtime.ReadMemStats(&stats)
+
+		first := lastGC
+		if stats.NumGC-first > uint32(len(stats.PauseNs)) {
+			first = stats.NumGC - uint32(len(stats.PauseNs))
+		}
+
+		for i := first; i < stats.NumGC; i++ {
+			pause := stats.PauseNs[i%uint32(len(stats.PauseNs))]
+			pauses.Observe(time.Duration(pause).Seconds())
+		}
+		lastGC = stats.NumGC
+	}
+}
@@ -6,16 +6,21 @@ require (
 	github.com/gin-contrib/sse v0.1.0 // indirect
 	github.com/gin-gonic/contrib v0.0.0-20190923054218-35076c1b2bea
 	github.com/gin-gonic/gin v1.4.0
+	github.com/go-sql-driver/mysql v1.5.0
+	github.com/gocql/gocql v1.0.0
 	github.com/konsorten/go-windows-terminal-sequences v1.0.2 // indirect
+	github.com/lib/pq v1.3.0
 	github.com/mattn/go-isatty v0.0.10 // indirect
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/mcuadros/go-gin-prometheus v0.1.0
 	github.com/onsi/ginkgo v1.10.2 // indirect
 	github.com/onsi/gomega v1.7.0 // indirect
 	github.com/prometheus/client_golang v1.2.1
+	github.com/redis/go-redis/v9 v9.7.3
 	github.com/uber/tchannel-go v0.0.0-20161130193021-90a659997997
 	github.com/ugorji/go v1.1.7 // indirect
 	golang.org/x/net v0.0.0-20191014212845-da9a3fd4c582 // indirect
 	golang.org/x/sys v0.0.0-20191018095205-727590c5006e // indirect
-	gopkg.in/redis.v5 v5.2.9
+	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22
 	gopkg.in/yaml.v2 v2.2.4 // indirect
 )
@@ -1,58 +1,480 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/johscheuer/todo-app-web/tododb"
 )
 
 func readTodoHandler(c *gin.Context) {
-	todos, err := database.GetAllTodos()
+	defer lockContentionScenario()()
+
+	if c.Request.Method == http.MethodGet && wantsNDJSON(c) {
+		streamTodos(c, true, true, tododb.TodoFilter{})
+		return
+	}
+
+	todos, err := todosOf(c).GetAllTodos(c.Request.Context())
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	logger.Debugf("%v", todos)
+	body, err := json.Marshal(tododb.Titles(todos))
 	if err != nil {
-		fmt.Println(err)
+		logger.Errorf("%v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errors": err.Error(),
 		})
 		return
 	}
-	fmt.Println(todos)
-	c.JSON(http.StatusOK, todos)
+
+	writeWithETag(c, "application/json; charset=utf-8", body)
+}
+
+// listTodosHandler answers with the whole todos, /todo only has their
+// titles. ?status= selects the open or done ones, ?tag= those with a tag,
+// ?q= those with the words, ?page= and ?per_page= a page of them.
+func listTodosHandler(c *gin.Context) {
+	status := tododb.TodoStatus(c.Query("status"))
+	if status != tododb.StatusAny && status != tododb.StatusOpen && status != tododb.StatusDone {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("unknown status %q, use open or done", status),
+		})
+		return
+	}
+
+	order, ok := orderOf(c)
+	if !ok {
+		return
+	}
+	tag, ok := tagQuery(c)
+	if !ok {
+		return
+	}
+	query, ok := searchQuery(c)
+	if !ok {
+		return
+	}
+
+	filter := tododb.TodoFilter{Status: status, Tag: tag, Query: query}
+	filters := []tododb.TodoFilter{}
+	if status != tododb.StatusAny || tag != "" || query != "" {
+		filters = append(filters, filter)
+	}
+
+	// The backends keep indexes of the words and tags, the plain list can
+	// use them
+	if (tag != "" || query != "") && order.by == sortAdded && !wantsPage(c) && !wantsNDJSON(c) {
+		var todos []tododb.Todo
+		var err error
+		if query != "" {
+			todos, err = todosOf(c).SearchTodos(c.Request.Context(), query)
+		} else {
+			todos, err = todosOf(c).GetTodosByTag(c.Request.Context(), tag)
+		}
+		if err != nil {
+			logger.Errorf("%v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, filterTodos(todos, filter))
+		return
+	}
+
+	if order.by != sortAdded {
+		var todos []tododb.Todo
+		var err error
+		if order.by == sortPriority {
+			todos, err = todosOf(c).GetTodosByPriority(c.Request.Context(), filters...)
+		} else {
+			todos, err = todosOf(c).GetAllTodos(c.Request.Context(), filters...)
+			todos = order.apply(todos)
+		}
+		if err != nil {
+			logger.Errorf("%v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+		if wantsPage(c) {
+			listPageOf(c, todos)
+			return
+		}
+		c.JSON(http.StatusOK, todos)
+		return
+	}
+
+	if wantsPage(c) {
+		listTodoPage(c, filters...)
+		return
+	}
+
+	streamTodos(c, wantsNDJSON(c), false, filter)
 }
 
 func insertTodoHandler(c *gin.Context) {
-	if err := database.SaveTodo(c.Param("value")); err != nil {
-		fmt.Println(err)
+	todo := newTodo(c.Param("value"))
+	todo.Owner = c.GetString(accountKey)
+	if err := todosOf(c).SaveTodo(c.Request.Context(), todo); err != nil {
+		logger.Errorf("%v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errors": err.Error(),
 		})
 		return
 	}
+	publishChange(changeCreated, todo)
+	queueHooks(hookCreate, todo)
+	if err := recordCreated(recordsNamespace(c), c.Param("value")); err != nil {
+		logger.Errorf("%v", err)
+	}
 
 	readTodoHandler(c)
 }
 
+// errTodoFound ends the search of findTodo.
+var errTodoFound = errors.New("todo found")
+
+// findTodo returns the first of todos accepted by match.
+func findTodo(ctx context.Context, todos tododb.TodoDB, match func(tododb.Todo) bool) (tododb.Todo, bool, error) {
+	var found tododb.Todo
+	err := todos.ForEachTodo(ctx, func(todo tododb.Todo) error {
+		if match(todo) {
+			found = todo
+			return errTodoFound
+		}
+		return nil
+	})
+	if err == errTodoFound {
+		return found, true, nil
+	}
+
+	return tododb.Todo{}, false, err
+}
+
+// filterTodos returns the todos matching filter, todos is left as it is.
+func filterTodos(todos []tododb.Todo, filter tododb.TodoFilter) []tododb.Todo {
+	kept := []tododb.Todo{}
+	for _, todo := range todos {
+		if filter.Matches(todo) {
+			kept = append(kept, todo)
+		}
+	}
+
+	return kept
+}
+
+// deleteTodoHandler deletes the first todo with the title, for clients that
+// know the todos by their titles only. Like deleteTodoByIDHandler it just
+// removes the todo, it doesn't complete it.
 func deleteTodoHandler(c *gin.Context) {
-	if err := database.DeleteTodo(c.Param("value")); err != nil {
-		fmt.Println(err)
+	title := c.Param("value")
+	todo, found, err := findTodo(c.Request.Context(), todosOf(c), func(todo tododb.Todo) bool {
+		return todo.Title == title
+	})
+	if err != nil {
+		logger.Errorf("%v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errors": err.Error(),
 		})
 		return
 	}
 
+	if found {
+		if !removeTodo(c, todo) {
+			return
+		}
+		releaseTodo(todo.ID)
+	}
+
 	readTodoHandler(c)
 }
 
+func deleteTodoByIDHandler(c *gin.Context) {
+	todo, ok := todoByID(c)
+	if !ok {
+		return
+	}
+
+	if removeTodo(c, todo) {
+		releaseTodo(todo.ID)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// todoByID looks up the todo of the id parameter among the todos the signed
+// in account may read, it answers the request itself if there is none.
+func todoByID(c *gin.Context) (tododb.Todo, bool) {
+	id := c.Param("id")
+	todo, found, err := findTodo(c.Request.Context(), todosOf(c), func(todo tododb.Todo) bool {
+		return todo.ID == id
+	})
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return tododb.Todo{}, false
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": fmt.Sprintf("no todo with id %q", id),
+		})
+		return tododb.Todo{}, false
+	}
+
+	return todo, true
+}
+
+// todoUpdate holds the fields to change, an empty due removes the due date,
+// empty tags the tags and an empty estimate the estimate. Position is the new
+// place in the whole list, counted from 0.
+type todoUpdate struct {
+	Title       *string        `json:"title"`
+	Description *string        `json:"description"`
+	Done        *bool          `json:"done"`
+	Due         *string        `json:"due"`
+	Priority    *priorityValue `json:"priority"`
+	Tags        *[]string      `json:"tags"`
+	Position    *int           `json:"position"`
+	Recurrence  *string        `json:"recurrence"`
+	Estimate    *string        `json:"estimate"`
+}
+
+// updateTodoHandler changes the title and the description of a todo in
+// place, it keeps its id and its place in the list, completes or reopens it,
+// sets its due date, priority, tags, recurrence and estimate and moves it to
+// another place in the list. The draft of the edit is dropped once it is
+// saved.
+func updateTodoHandler(c *gin.Context) {
+	var update todoUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	if update.Title == nil && update.Description == nil && update.Done == nil && update.Due == nil && update.Priority == nil && update.Tags == nil && update.Position == nil && update.Recurrence == nil && update.Estimate == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "set title, description, done, due, priority, tags, position, recurrence or estimate",
+		})
+		return
+	}
+	if update.Title != nil && strings.TrimSpace(*update.Title) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "title must not be empty",
+		})
+		return
+	}
+
+	if update.Position != nil && *update.Position < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": tododb.ErrInvalidPosition.Error(),
+		})
+		return
+	}
+
+	var due *time.Time
+	if update.Due != nil {
+		var err error
+		if due, err = parseDue(*update.Due); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+	}
+
+	var tags []string
+	if update.Tags != nil {
+		var err error
+		if tags, err = parseTags(*update.Tags); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+	}
+
+	if update.Recurrence != nil && *update.Recurrence != "" {
+		if _, err := parseRecurrence(*update.Recurrence); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+	}
+
+	var estimate int
+	if update.Estimate != nil {
+		var err error
+		if estimate, err = parseEstimate(*update.Estimate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+	}
+
+	todo, ok := todoByID(c)
+	if !ok {
+		return
+	}
+	completes := update.Done != nil && *update.Done && !todo.Done
+	if completes && !checkBlockers(c, todo) {
+		return
+	}
+
+	// The fields change in one write, a failed move puts them back
+	ctx := c.Request.Context()
+	err := todosOf(c).UpdateTodo(ctx, todo.ID, func(edited *tododb.Todo) {
+		if update.Title != nil {
+			edited.Title = *update.Title
+		}
+		if update.Description != nil {
+			edited.Description = strings.TrimSpace(*update.Description)
+		}
+		if update.Done != nil {
+			edited.Done = *update.Done
+		}
+		if update.Due != nil {
+			edited.Due = due
+		}
+		if update.Priority != nil {
+			edited.Priority = int(*update.Priority)
+		}
+		if update.Tags != nil {
+			edited.Tags = tags
+		}
+		if update.Recurrence != nil {
+			edited.Recurrence = *update.Recurrence
+		}
+		if update.Estimate != nil {
+			edited.Estimate = estimate
+		}
+	})
+	if err == nil && update.Position != nil {
+		if err = todosOf(c).MoveTodo(ctx, todo.ID, *update.Position); err != nil {
+			if rollbackErr := revertTodo(ctx, todosOf(c), todo); rollbackErr != nil {
+				logger.Errorf("%v", rollbackErr)
+			}
+		}
+	}
+	if err != nil {
+		answerTodoError(c, todo.ID, err)
+		return
+	}
+	dropCachedSmartLists()
+	dropDraft(c, todo.ID)
+	recordOperation(c, tododb.Operation{Kind: operationUpdated, Before: []tododb.Todo{todo}})
+	if completes {
+		finishTodo(recordsNamespace(c), todo)
+	}
+
+	updated, ok := todoByID(c)
+	if !ok {
+		return
+	}
+	publishChange(changeUpdated, updated)
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// checkBlockers refuses to complete todo while it has open blockers, unless
+// AllowBlockedCompletion is set. It answers the request itself if it does.
+func checkBlockers(c *gin.Context, todo tododb.Todo) bool {
+	if appConfig.AllowBlockedCompletion {
+		return true
+	}
+
+	reader, err := readerOf(c)
+	var blockers []string
+	if err == nil {
+		blockers, err = openBlockers(c.Request.Context(), todo.ID, reader)
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return false
+	}
+	if len(blockers) > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"errors":    fmt.Sprintf("%q is blocked by %d open todo(s)", todo.Title, len(blockers)),
+			"blockedBy": blockers,
+		})
+		return false
+	}
+
+	return true
+}
+
+// removeTodo deletes todo, puts it into the trash and records the deletion
+// to be undone. It answers the request itself if it fails.
+func removeTodo(c *gin.Context, todo tododb.Todo) bool {
+	if err := todosOf(c).DeleteTodo(c.Request.Context(), todo.ID); err != nil {
+		answerTodoError(c, todo.ID, err)
+		return false
+	}
+	publishChange(changeDeleted, todo)
+	grants := takeGrants(todo)
+	trashTodos(c, grants, todo)
+	recordOperation(c, tododb.Operation{Kind: operationDeleted, Before: []tododb.Todo{todo}, Grants: grants})
+
+	return true
+}
+
+// releaseTodo drops what refers to the todo with id once it is done or gone.
+func releaseTodo(id string) {
+	if err := forgetDependencies(id); err != nil {
+		logger.Errorf("%v", err)
+	}
+	if err := stopTimer(id); err != nil {
+		logger.Errorf("%v", err)
+	}
+}
+
+// finishTodo releases a todo that was just completed, tells the webhooks, runs
+// the hooks and counts it in the records of the namespace, for the assignee
+// in its title.
+func finishTodo(namespace string, todo tododb.Todo) {
+	releaseTodo(todo.ID)
+	// Callers may pass the todo as it was before
+	todo.Done = true
+	queueWebhooks(webhookCompleted, todo)
+	queueHooks(hookComplete, todo)
+	if err := recordCompletion(namespace, todo.Title); err != nil {
+		logger.Errorf("%v", err)
+	}
+	if err := recordCompleted(namespace, todo.Title); err != nil {
+		logger.Errorf("%v", err)
+	}
+}
+
 func healthCheckHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, database.GetHealthStatus())
+	c.JSON(http.StatusOK, database.GetHealthStatus(c.Request.Context()))
 }
 
 func whoAmIHandler(c *gin.Context) {
 	ifaces, err := net.Interfaces()
 	if err != nil {
-		fmt.Println(err)
+		logger.Errorf("%v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errors": err.Error(),
 		})
@@ -61,7 +483,7 @@ func whoAmIHandler(c *gin.Context) {
 
 	addresses, err := getAllAddresses(ifaces)
 	if err != nil {
-		fmt.Println(err)
+		logger.Errorf("%v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errors": err.Error(),
 		})
@@ -72,7 +494,41 @@ func whoAmIHandler(c *gin.Context) {
 }
 
 func versionHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"version": appVersion,
-	})
+	c.JSON(http.StatusOK, buildinfo.Get())
+}
+
+// usageHandler reports the usage of all todos, or with ?account= the one of
+// the todos owned by that account.
+func usageHandler(c *gin.Context) {
+	var usage tododb.Usage
+	var err error
+	if account, exists := c.GetQuery("account"); exists {
+		usage, err = tododb.AccountUsageOf(c.Request.Context(), database, account)
+	} else {
+		usage, err = database.GetUsage(c.Request.Context())
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+// accountUsageHandler reports the usage of the todos owned by the signed in
+// account.
+func accountUsageHandler(c *gin.Context) {
+	usage, err := todosOf(c).GetUsage(c.Request.Context())
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
 }
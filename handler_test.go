@@ -11,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/johscheuer/todo-app-web/tododb"
 	"github.com/uber/tchannel-go/testutils/goroutines"
 )
 
@@ -105,6 +106,50 @@ func TestInsertReadAndDeleteItem(t *testing.T) {
 
 }
 
+func TestExportNDJSON(t *testing.T) {
+	defer validateGoRoutines()
+	insertItem := "ExportCase"
+
+	if resp, err := getHTTPClient().Post(fmt.Sprintf("%s/todo/%s", todoAppServer, insertItem), "", nil); err != nil || resp.StatusCode != 200 {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	defer func() {
+		req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/todo/%s", todoAppServer, insertItem), nil)
+		if resp, err := getHTTPClient().Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	resp, err := getHTTPClient().Get(fmt.Sprintf("%s/todo/export?format=ndjson", todoAppServer))
+	if err != nil || resp.StatusCode != 200 {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	defer resp.Body.Close()
+	var exported []string
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		var todo tododb.Todo
+		if err := dec.Decode(&todo); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if todo.ID == "" {
+			t.Logf("Exported todo %q has no id", todo.Title)
+			t.Fail()
+		}
+		exported = append(exported, todo.Title)
+	}
+
+	if !reflect.DeepEqual([]string{insertItem}, exported) {
+		t.Logf("Expected: %v \nGot: %v", []string{insertItem}, exported)
+		t.Fail()
+	}
+}
+
 func TestWhoAmI(t *testing.T) {
 	defer validateGoRoutines()
 	readResp, err := getHTTPClient().Get(fmt.Sprintf("%s/whoami", todoAppServer))
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/importer"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+// importTodos runs the registered importer for format and stores the result
+// in todos, owned by owner. Lists and tags of the source service have no
+// counterpart yet and are dropped, only the titles are kept.
+func importTodos(ctx context.Context, todos tododb.TodoDB, owner, format string, r io.Reader) (int, error) {
+	imp, err := importer.Get(format)
+	if err != nil {
+		return 0, err
+	}
+
+	imports, err := imp.Import(r)
+	if err != nil {
+		return 0, fmt.Errorf("reading %s export: %v", format, err)
+	}
+
+	imported := 0
+	for start := 0; start < len(imports); start += ingestBatchSize {
+		end := start + ingestBatchSize
+		if end > len(imports) {
+			end = len(imports)
+		}
+
+		batch := make([]tododb.Todo, 0, end-start)
+		for _, entry := range imports[start:end] {
+			todo := newTodo(entry.Title)
+			todo.Owner = owner
+			batch = append(batch, todo)
+		}
+
+		if err := todos.SaveTodos(ctx, batch); err != nil {
+			return imported, err
+		}
+		publishChange(changeCreated, batch...)
+		queueHooks(hookCreate, batch...)
+		imported += len(batch)
+	}
+
+	return imported, nil
+}
+
+func importFile(ctx context.Context, format, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return importTodos(ctx, database, "", format, f)
+}
+
+func importTodoHandler(c *gin.Context) {
+	body := io.Reader(c.Request.Body)
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	if format == "csv" {
+		importCSV(c, body)
+		return
+	}
+
+	imported, err := importTodos(c.Request.Context(), todosOf(c), c.GetString(accountKey), format, body)
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors":   err.Error(),
+			"imported": imported,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"imported": imported,
+	})
+}
@@ -0,0 +1,65 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const commandTimeout = time.Minute
+
+// Command imports by running an external program with the export on stdin.
+// The program writes one JSON object per todo to stdout, with the fields
+// title, list and tags. This allows formats that aren't compiled in.
+type Command struct {
+	Path string
+	Args []string
+}
+
+type commandTodo struct {
+	Title string   `json:"title"`
+	List  string   `json:"list"`
+	Tags  []string `json:"tags"`
+}
+
+func (cmd Command) Import(r io.Reader) ([]Todo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	process := exec.CommandContext(ctx, cmd.Path, cmd.Args...)
+	process.Stdin = r
+	process.Stdout = &stdout
+	process.Stderr = &stderr
+
+	if err := process.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", cmd.Path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	todos := []Todo{}
+	scanner := bufio.NewScanner(&stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var todo commandTodo
+		if err := json.Unmarshal(scanner.Bytes(), &todo); err != nil {
+			return nil, fmt.Errorf("%s: line %d: %v", cmd.Path, line, err)
+		}
+		if todo.Title == "" {
+			continue
+		}
+
+		todos = append(todos, Todo{Title: todo.Title, List: todo.List, Tags: todo.Tags})
+	}
+
+	return todos, scanner.Err()
+}
@@ -0,0 +1,69 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Todo is a single entry read from a foreign export. List and Tags carry the
+// board/project and labels of the source service.
+type Todo struct {
+	Title string
+	List  string
+	Tags  []string
+}
+
+type Importer interface {
+	Import(io.Reader) ([]Todo, error)
+}
+
+var (
+	importersMu sync.RWMutex
+	importers   = map[string]Importer{}
+)
+
+// Register makes an importer available under name. It panics if the name is
+// already taken, like database/sql does for drivers.
+func Register(name string, importer Importer) {
+	importersMu.Lock()
+	defer importersMu.Unlock()
+
+	if importer == nil {
+		panic("importer: Register importer is nil")
+	}
+	if _, dup := importers[name]; dup {
+		panic("importer: Register called twice for importer " + name)
+	}
+	importers[name] = importer
+}
+
+func Get(name string) (Importer, error) {
+	importersMu.RLock()
+	defer importersMu.RUnlock()
+
+	importer, exists := importers[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown import format %q, supported: %v", name, names())
+	}
+
+	return importer, nil
+}
+
+func Names() []string {
+	importersMu.RLock()
+	defer importersMu.RUnlock()
+
+	return names()
+}
+
+func names() []string {
+	list := make([]string, 0, len(importers))
+	for name := range importers {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+
+	return list
+}
@@ -0,0 +1,62 @@
+package importer
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+func init() {
+	Register("mstodo", msTodoImporter{})
+}
+
+type msTodoImporter struct{}
+
+type msTodoTask struct {
+	Title      string   `json:"title"`
+	Status     string   `json:"status"`
+	Categories []string `json:"categories"`
+}
+
+type msTodoList struct {
+	DisplayName string       `json:"displayName"`
+	Tasks       []msTodoTask `json:"tasks"`
+}
+
+type msTodoExport struct {
+	Lists []msTodoList `json:"lists"`
+	// Value holds the tasks of a single Graph API todoTask collection
+	Value []msTodoTask `json:"value"`
+}
+
+// Import reads Microsoft To Do data in the shape of the Graph API: either a
+// "lists" array with nested tasks or a plain task collection. Completed tasks
+// are skipped, categories become tags.
+func (msTodoImporter) Import(r io.Reader) ([]Todo, error) {
+	var export msTodoExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, err
+	}
+
+	lists := export.Lists
+	if len(export.Value) > 0 {
+		lists = append(lists, msTodoList{Tasks: export.Value})
+	}
+
+	todos := []Todo{}
+	for _, list := range lists {
+		for _, task := range list.Tasks {
+			if strings.EqualFold(task.Status, "completed") || task.Title == "" {
+				continue
+			}
+
+			todos = append(todos, Todo{
+				Title: task.Title,
+				List:  list.DisplayName,
+				Tags:  task.Categories,
+			})
+		}
+	}
+
+	return todos, nil
+}
@@ -0,0 +1,82 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+func init() {
+	Register("todoist", todoistImporter{})
+}
+
+type todoistImporter struct{}
+
+type todoistExport struct {
+	Projects []struct {
+		ID   json.Number `json:"id"`
+		Name string      `json:"name"`
+	} `json:"projects"`
+	Labels []struct {
+		ID   json.Number `json:"id"`
+		Name string      `json:"name"`
+	} `json:"labels"`
+	Items []struct {
+		Content   string        `json:"content"`
+		ProjectID json.Number   `json:"project_id"`
+		Labels    []interface{} `json:"labels"`
+		Checked   interface{}   `json:"checked"`
+	} `json:"items"`
+}
+
+// Import reads a Todoist sync API dump. Older dumps reference labels by ID,
+// newer ones by name, both are resolved to label names.
+func (todoistImporter) Import(r io.Reader) ([]Todo, error) {
+	var export todoistExport
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if err := dec.Decode(&export); err != nil {
+		return nil, err
+	}
+
+	projects := map[string]string{}
+	for _, project := range export.Projects {
+		projects[project.ID.String()] = project.Name
+	}
+
+	labels := map[string]string{}
+	for _, label := range export.Labels {
+		labels[label.ID.String()] = label.Name
+	}
+
+	todos := []Todo{}
+	for _, item := range export.Items {
+		if todoistChecked(item.Checked) || item.Content == "" {
+			continue
+		}
+
+		todo := Todo{Title: item.Content, List: projects[item.ProjectID.String()]}
+		for _, label := range item.Labels {
+			name := fmt.Sprint(label)
+			if resolved, exists := labels[name]; exists {
+				name = resolved
+			}
+			todo.Tags = append(todo.Tags, name)
+		}
+		todos = append(todos, todo)
+	}
+
+	return todos, nil
+}
+
+// todoistChecked handles the API versions that send 0/1 instead of a bool.
+func todoistChecked(checked interface{}) bool {
+	switch value := checked.(type) {
+	case bool:
+		return value
+	case json.Number:
+		return value.String() != "0"
+	}
+
+	return false
+}
@@ -0,0 +1,54 @@
+package importer
+
+import (
+	"encoding/json"
+	"io"
+)
+
+func init() {
+	Register("trello", trelloImporter{})
+}
+
+type trelloImporter struct{}
+
+type trelloBoard struct {
+	Name  string `json:"name"`
+	Cards []struct {
+		Name   string `json:"name"`
+		Closed bool   `json:"closed"`
+		Labels []struct {
+			Name  string `json:"name"`
+			Color string `json:"color"`
+		} `json:"labels"`
+	} `json:"cards"`
+}
+
+// Import reads the JSON export of a Trello board. Archived cards are skipped,
+// the board becomes the list and unnamed labels fall back to their color.
+func (trelloImporter) Import(r io.Reader) ([]Todo, error) {
+	var board trelloBoard
+	if err := json.NewDecoder(r).Decode(&board); err != nil {
+		return nil, err
+	}
+
+	todos := []Todo{}
+	for _, card := range board.Cards {
+		if card.Closed || card.Name == "" {
+			continue
+		}
+
+		todo := Todo{Title: card.Name, List: board.Name}
+		for _, label := range card.Labels {
+			tag := label.Name
+			if tag == "" {
+				tag = label.Color
+			}
+			if tag != "" {
+				todo.Tags = append(todo.Tags, tag)
+			}
+		}
+		todos = append(todos, todo)
+	}
+
+	return todos, nil
+}
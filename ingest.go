@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	ingestBatchSize   = 100
+	ingestMaxLineSize = 1024 * 1024
+)
+
+type ingestResult struct {
+	Line   int    `json:"line"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type pendingTodo struct {
+	line int
+	todo tododb.Todo
+}
+
+// parseIngestLine accepts a todo as written by the NDJSON export, or a
+// plain JSON string as its title. The todo is added as a new one: it gets a
+// new id, so ingesting an export twice doesn't duplicate ids, and its
+// attachments are dropped, their content isn't part of the line. Tags and the
+// due date of the title count when the line has none.
+func parseIngestLine(raw []byte) (tododb.Todo, error) {
+	var line tododb.Todo
+	if err := json.Unmarshal(raw, &line.Title); err != nil {
+		if err := json.Unmarshal(raw, &line); err != nil {
+			return tododb.Todo{}, err
+		}
+	}
+	if strings.TrimSpace(line.Title) == "" {
+		return tododb.Todo{}, errors.New("empty todo")
+	}
+
+	todo := newTodo(line.Title)
+	todo.Description = line.Description
+	todo.Done = line.Done
+	if !line.CreatedAt.IsZero() {
+		todo.CreatedAt = line.CreatedAt.UTC()
+	}
+	if line.Due != nil {
+		todo.Due = line.Due
+	}
+	if line.Priority < tododb.PriorityNone || line.Priority > tododb.PriorityHigh {
+		return tododb.Todo{}, fmt.Errorf("invalid priority %d, use 0 to %d", line.Priority, tododb.PriorityHigh)
+	}
+	todo.Priority = line.Priority
+	if line.Tags != nil {
+		tags, err := parseTags(line.Tags)
+		if err != nil {
+			return tododb.Todo{}, err
+		}
+		todo.Tags = tags
+	}
+	if line.Recurrence != "" {
+		if _, err := parseRecurrence(line.Recurrence); err != nil {
+			return tododb.Todo{}, err
+		}
+		todo.Recurrence = line.Recurrence
+	}
+	if line.Estimate < 0 {
+		return tododb.Todo{}, fmt.Errorf("invalid estimate %d, use minutes", line.Estimate)
+	}
+	todo.Estimate = line.Estimate
+	todo.SubTasks = line.SubTasks
+
+	return todo, nil
+}
+
+// todoActionHandler serves the custom methods of /api/v1/todos. gin can't
+// register a literal colon, ":stream" is matched as a parameter right behind
+// "/todos".
+func todoActionHandler(c *gin.Context) {
+	switch c.Param("action") {
+	case ":stream":
+		ingestTodoHandler(c)
+	case ":bulk":
+		bulkEditHandler(c)
+	default:
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": "not found",
+		})
+	}
+}
+
+// ingestTodoHandler reads newline delimited todos and stores them in batches.
+// The next batch is only read once the previous one was written to the
+// database, so a slow backend throttles the client instead of piling up
+// todos in memory.
+func ingestTodoHandler(c *gin.Context) {
+	c.Header("Content-Type", ndjsonContentType)
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+
+	batch := make([]pendingTodo, 0, ingestBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		todos := make([]tododb.Todo, len(batch))
+		for i, pending := range batch {
+			todos[i] = pending.todo
+		}
+		claimTodos(c, todos)
+
+		result := ingestResult{Status: "ok"}
+		if err := todosOf(c).SaveTodos(c.Request.Context(), todos); err != nil {
+			logger.Errorf("%v", err)
+			result = ingestResult{Status: "error", Error: err.Error()}
+		} else {
+			publishChange(changeCreated, todos...)
+			queueHooks(hookCreate, todos...)
+		}
+
+		for _, pending := range batch {
+			result.Line = pending.line
+			enc.Encode(result)
+		}
+		c.Writer.Flush()
+		batch = batch[:0]
+	}
+
+	scanner := bufio.NewScanner(c.Request.Body)
+	scanner.Buffer(make([]byte, 64*1024), ingestMaxLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := scanner.Bytes()
+		if len(strings.TrimSpace(string(raw))) == 0 {
+			continue
+		}
+
+		todo, err := parseIngestLine(raw)
+		if err != nil {
+			enc.Encode(ingestResult{Line: line, Status: "error", Error: err.Error()})
+			continue
+		}
+
+		batch = append(batch, pendingTodo{line: line, todo: todo})
+		if len(batch) == ingestBatchSize {
+			flush()
+		}
+	}
+	flush()
+
+	if err := scanner.Err(); err != nil {
+		logger.Errorf("%v", err)
+		enc.Encode(ingestResult{Line: line + 1, Status: "error", Error: err.Error()})
+	}
+	c.Writer.Flush()
+}
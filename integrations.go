@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	integrationKey          = "integration"
+	defaultTriggerLimit     = 50
+	maxTriggerLimit         = 500
+	integrationAPIKeyHeader = "X-API-Key"
+	iftttServiceKeyHeader   = "IFTTT-Service-Key"
+)
+
+type integrationTodo struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Position  int       `json:"position"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// integrationAuth accepts the API key of any configured integration, either
+// in X-API-Key (Zapier) or IFTTT-Service-Key (IFTTT).
+func integrationAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(integrationAPIKeyHeader)
+		if key == "" {
+			key = c.GetHeader(iftttServiceKeyHeader)
+		}
+
+		for name, expected := range appConfig.Integrations {
+			if expected != "" && subtle.ConstantTimeCompare([]byte(key), []byte(expected)) == 1 {
+				c.Set(integrationKey, name)
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"errors": "invalid or missing API key",
+		})
+	}
+}
+
+// triggerLimit returns the limit of a polling trigger, by default
+// defaultTriggerLimit and at most maxTriggerLimit.
+func triggerLimit(c *gin.Context) int {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultTriggerLimit)))
+	if err != nil || limit <= 0 {
+		return defaultTriggerLimit
+	}
+	if limit > maxTriggerLimit {
+		return maxTriggerLimit
+	}
+
+	return limit
+}
+
+// newTodoTriggerHandler is a polling trigger returning the todos appended
+// after the cursor, newest first. The cursor is the position in the list, so
+// deleting todos can make the next poll return some todos again, the
+// integrations drop those by their id.
+func newTodoTriggerHandler(c *gin.Context) {
+	cursor, err := strconv.Atoi(c.DefaultQuery("cursor", "0"))
+	if err != nil || cursor < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("invalid cursor: %q", c.Query("cursor")),
+		})
+		return
+	}
+	limit := triggerLimit(c)
+
+	todos, err := todosOf(c).GetAllTodos(c.Request.Context())
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	data := []integrationTodo{}
+	for i := len(todos) - 1; i >= cursor && len(data) < limit; i-- {
+		data = append(data, integrationTodo{
+			ID:        todos[i].ID,
+			Title:     todos[i].Title,
+			Position:  i,
+			UpdatedAt: todos[i].UpdatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":   data,
+		"cursor": strconv.Itoa(len(todos)),
+	})
+}
+
+// completedTodoTriggerHandler is a polling trigger returning the done todos
+// that changed after the cursor, the last changed first. The cursor is the
+// time of the last change it returned, a todo that is changed again after it
+// was completed is returned again, the integrations drop it by its id.
+func completedTodoTriggerHandler(c *gin.Context) {
+	var cursor time.Time
+	if value := c.Query("cursor"); value != "" {
+		var err error
+		if cursor, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": fmt.Sprintf("invalid cursor: %q", value),
+			})
+			return
+		}
+	}
+	limit := triggerLimit(c)
+
+	todos, err := todosOf(c).GetAllTodos(c.Request.Context(), tododb.TodoFilter{Status: tododb.StatusDone})
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	data := []integrationTodo{}
+	for i, todo := range todos {
+		if todo.UpdatedAt.After(cursor) {
+			data = append(data, integrationTodo{
+				ID:        todo.ID,
+				Title:     todo.Title,
+				Position:  i,
+				UpdatedAt: todo.UpdatedAt,
+			})
+		}
+	}
+	sort.SliceStable(data, func(i, j int) bool {
+		return data[i].UpdatedAt.After(data[j].UpdatedAt)
+	})
+	next := cursor
+	if len(data) > 0 {
+		next = data[0].UpdatedAt
+	}
+	if len(data) > limit {
+		data = data[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":   data,
+		"cursor": next.UTC().Format(time.RFC3339Nano),
+	})
+}
+
+// createTodoActionHandler takes the title either flat (Zapier) or wrapped in
+// actionFields (IFTTT).
+func createTodoActionHandler(c *gin.Context) {
+	var body struct {
+		Title        string `json:"title"`
+		ActionFields struct {
+			Title string `json:"title"`
+		} `json:"actionFields"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	title := body.Title
+	if title == "" {
+		title = body.ActionFields.Title
+	}
+	if strings.TrimSpace(title) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "title is required",
+		})
+		return
+	}
+
+	todo := newTodo(title)
+	if err := todosOf(c).SaveTodo(c.Request.Context(), todo); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	publishChange(changeCreated, todo)
+	queueHooks(hookCreate, todo)
+
+	if err := recordCreated(recordsNamespace(c), title); err != nil {
+		logger.Errorf("%v", err)
+	}
+
+	logger.Infof("Integration %s created a todo", c.GetString(integrationKey))
+	c.JSON(http.StatusOK, gin.H{
+		"data": []gin.H{{"id": todo.ID, "title": title}},
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func withAPIKey(header, key string) authorization {
+	return func(req *http.Request) {
+		req.Header.Set(header, key)
+	}
+}
+
+func TestIntegrations(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+	server.createAccount("jane")
+	private := server.createTodo(asAccount("jane"), "Call the tax advisor")
+	zapier := withAPIKey(integrationAPIKeyHeader, testIntegrationKey)
+
+	for _, authorize := range []authorization{anonymous, withAPIKey(integrationAPIKeyHeader, "wrong"), asAccount("jane")} {
+		server.expect(http.StatusUnauthorized, "GET", "/api/v1/integrations/triggers/new-todo", authorize, nil, nil)
+	}
+	server.expect(http.StatusBadRequest, "POST", "/api/v1/integrations/actions/create-todo", zapier, gin.H{"title": " "}, nil)
+	server.expect(http.StatusOK, "POST", "/api/v1/integrations/actions/create-todo", withAPIKey(iftttServiceKeyHeader, testIntegrationKey),
+		gin.H{"actionFields": gin.H{"title": "Buy milk"}}, nil)
+
+	var triggered struct {
+		Data   []integrationTodo `json:"data"`
+		Cursor string            `json:"cursor"`
+	}
+	server.expect(http.StatusOK, "GET", "/api/v1/integrations/triggers/new-todo", zapier, nil, &triggered)
+	if len(triggered.Data) != 1 || triggered.Data[0].Title != "Buy milk" || triggered.Cursor != "1" {
+		t.Errorf("the new todos are %+v with cursor %s, want Buy milk without the todo of jane", triggered.Data, triggered.Cursor)
+	}
+	for _, todo := range triggered.Data {
+		if todo.ID == private.ID {
+			t.Errorf("the integration sees the todo of jane")
+		}
+	}
+
+	// the created todo belongs to no account, anyone sees it
+	todos := server.todos(anonymous)
+	if len(todos) != 1 || todos[0].Title != "Buy milk" || todos[0].Owner != "" {
+		t.Errorf("the anonymous todos are %+v, want Buy milk without an owner", todos)
+	}
+}
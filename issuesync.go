@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	defaultSyncIntervalMinutes = 15
+	defaultGitHubAPI           = "https://api.github.com"
+	defaultJiraIssueType       = "Task"
+	syncTimeout                = 30 * time.Second
+	// syncErrorBodyBytes is how much of an error answer ends up in the log
+	syncErrorBodyBytes = 512
+)
+
+// IssueSyncConfig mirrors the todos with Tag to the issues of a GitHub
+// repository or a Jira project. The sync is one-way, changes made to the
+// issues are overwritten by the next change of the todo.
+type IssueSyncConfig struct {
+	// Tracker is github or jira
+	Tracker string
+	// Tag selects the todos to mirror, without the #
+	Tag string
+	// Repository is the owner/name of the GitHub repository
+	Repository string
+	// Project is the key of the Jira project, like OPS
+	Project string
+	// URL is the base of the API, https://api.github.com by default for
+	// GitHub, the site like https://example.atlassian.net for Jira
+	URL string
+	// IssueType of the Jira tickets, Task by default
+	IssueType string
+	// User is the account of the Jira API token, GitHub tokens need none
+	User  string
+	Token string
+	// IntervalMinutes is how often the todos are reconciled with the issues,
+	// 15 by default
+	IntervalMinutes int
+}
+
+// issue is what the sync writes to a tracker.
+type issue struct {
+	Title  string
+	Body   string
+	Closed bool
+}
+
+// hash is the digest of the fields of the issue, see tododb.RemoteLink.
+func (i issue) hash() string {
+	sum := sha256.Sum256([]byte(i.Title + "\x00" + i.Body + "\x00" + strconv.FormatBool(i.Closed)))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTracker creates and updates the issues in a tracker.
+type issueTracker interface {
+	// name is the Tracker of the links, like github:owner/repo
+	name() string
+	create(ctx context.Context, i issue) (id, url string, err error)
+	update(ctx context.Context, id string, i issue) error
+}
+
+// issueSync is a configured sync, ready to reconcile.
+type issueSync struct {
+	tag      string
+	tracker  issueTracker
+	interval time.Duration
+}
+
+// newIssueSyncs checks configs and creates their trackers.
+func newIssueSyncs(configs []IssueSyncConfig) ([]issueSync, error) {
+	syncs := []issueSync{}
+	for i, config := range configs {
+		tag := tododb.NormalizeTag(config.Tag)
+		if tag == "" {
+			return nil, fmt.Errorf("IssueSync[%d]: Tag is missing", i)
+		}
+		if config.Token == "" {
+			return nil, fmt.Errorf("IssueSync[%d]: Token is missing", i)
+		}
+		interval := time.Duration(config.IntervalMinutes) * time.Minute
+		if config.IntervalMinutes <= 0 {
+			interval = defaultSyncIntervalMinutes * time.Minute
+		}
+
+		var tracker issueTracker
+		switch strings.ToLower(config.Tracker) {
+		case "github":
+			if strings.Count(config.Repository, "/") != 1 {
+				return nil, fmt.Errorf("IssueSync[%d]: Repository must be owner/name", i)
+			}
+			base := config.URL
+			if base == "" {
+				base = defaultGitHubAPI
+			}
+			tracker = gitHubTracker{base: strings.TrimRight(base, "/"), repository: config.Repository, token: config.Token}
+		case "jira":
+			if config.URL == "" || config.Project == "" {
+				return nil, fmt.Errorf("IssueSync[%d]: jira needs a URL and a Project", i)
+			}
+			issueType := config.IssueType
+			if issueType == "" {
+				issueType = defaultJiraIssueType
+			}
+			tracker = jiraTracker{base: strings.TrimRight(config.URL, "/"), project: config.Project, issueType: issueType, user: config.User, token: config.Token}
+		default:
+			return nil, fmt.Errorf("IssueSync[%d]: %s is not supported, use github or jira", i, config.Tracker)
+		}
+
+		syncs = append(syncs, issueSync{tag: tag, tracker: tracker, interval: interval})
+	}
+
+	return syncs, nil
+}
+
+// remoteLink returns the link of todo to tracker, nil if it has none.
+func remoteLink(todo tododb.Todo, tracker string) *tododb.RemoteLink {
+	for i := range todo.Remote {
+		if todo.Remote[i].Tracker == tracker {
+			return &todo.Remote[i]
+		}
+	}
+
+	return nil
+}
+
+// setRemoteLink replaces the link of todo to the tracker of link.
+func setRemoteLink(ctx context.Context, id string, link tododb.RemoteLink) error {
+	return database.UpdateTodo(ctx, id, func(todo *tododb.Todo) {
+		remote := []tododb.RemoteLink{}
+		for _, existing := range todo.Remote {
+			if existing.Tracker != link.Tracker {
+				remote = append(remote, existing)
+			}
+		}
+		todo.Remote = append(remote, link)
+	})
+}
+
+func hasTag(todo tododb.Todo, tag string) bool {
+	for _, candidate := range todo.Tags {
+		if candidate == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func mirroredKey(tracker string) string {
+	return "issuesync:" + tracker
+}
+
+// loadMirrored reads the ids of the mirrored todos with their issues, so the
+// issues of deleted todos can be closed.
+func loadMirrored(tracker string) (map[string]string, error) {
+	mirrored := map[string]string{}
+	value, err := tododb.KVOf(database).GetValue(mirroredKey(tracker))
+	if err == tododb.ErrNotFound {
+		return mirrored, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return mirrored, json.Unmarshal([]byte(value), &mirrored)
+}
+
+func saveMirrored(tracker string, mirrored map[string]string) error {
+	value, err := json.Marshal(mirrored)
+	if err != nil {
+		return err
+	}
+
+	return tododb.KVOf(database).SetValue(mirroredKey(tracker), string(value), 0)
+}
+
+// reconcile creates the issues of the tagged todos without one and writes
+// the todos that changed since to their issues. The issues of todos that
+// are done or lost the tag are closed, those of deleted todos too. It
+// returns how many issues it created and updated.
+func (s issueSync) reconcile(ctx context.Context) (created, updated int, err error) {
+	tracker := s.tracker.name()
+	mirrored, err := loadMirrored(tracker)
+	if err != nil {
+		return 0, 0, err
+	}
+	// The links of the todos are written first, a failed save of mirrored
+	// leaves only deleted todos with open issues
+	defer func() {
+		if saveErr := saveMirrored(tracker, mirrored); err == nil {
+			err = saveErr
+		}
+	}()
+
+	todos, err := database.GetAllTodos(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	listed := map[string]bool{}
+	for _, todo := range todos {
+		listed[todo.ID] = true
+		tagged := hasTag(todo, s.tag)
+		link := remoteLink(todo, tracker)
+		if link == nil && (!tagged || todo.Done) {
+			continue
+		}
+
+		// Restored todos aren't mirrored anymore, their issues were closed
+		want := issue{Title: todo.Title, Body: todo.Description, Closed: todo.Done || !tagged}
+		if link != nil && link.Hash == want.hash() && mirrored[todo.ID] == link.ID {
+			continue
+		}
+
+		var next tododb.RemoteLink
+		if link == nil {
+			id, url, err := s.tracker.create(ctx, want)
+			if err != nil {
+				return created, updated, err
+			}
+			next = tododb.RemoteLink{Tracker: tracker, ID: id, URL: url}
+			created++
+		} else {
+			if err := s.tracker.update(ctx, link.ID, want); err != nil {
+				return created, updated, err
+			}
+			next = *link
+			updated++
+		}
+		next.Hash, next.SyncedAt = want.hash(), time.Now().UTC()
+		if err := setRemoteLink(ctx, todo.ID, next); err != nil && err != tododb.ErrNotFound {
+			return created, updated, err
+		}
+		mirrored[todo.ID] = next.ID
+	}
+
+	for id, issueID := range mirrored {
+		if listed[id] {
+			continue
+		}
+		if err := s.tracker.update(ctx, issueID, issue{Closed: true}); err != nil {
+			return created, updated, err
+		}
+		delete(mirrored, id)
+		updated++
+	}
+
+	return created, updated, nil
+}
+
+// runIssueSync reconciles the todos with the issues every interval of s.
+// Every instance reconciles, run it on one replica only.
+func runIssueSync(s issueSync) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+		created, updated, err := s.reconcile(ctx)
+		cancel()
+		if err != nil {
+			logger.Errorf("Sync with %s: %v", s.tracker.name(), err)
+		}
+		if created > 0 || updated > 0 {
+			logger.Infof("Sync with %s created %d and updated %d issues", s.tracker.name(), created, updated)
+		}
+		time.Sleep(s.interval)
+	}
+}
+
+// trackerRequest sends body as JSON and decodes the answer into result, if
+// it isn't nil.
+func trackerRequest(ctx context.Context, method, url string, auth func(*http.Request), body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	auth(req)
+
+	client := &http.Client{Timeout: syncTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, syncErrorBodyBytes))
+		return fmt.Errorf("%s %s answered with %s: %s", method, url, resp.Status, strings.TrimSpace(string(message)))
+	}
+	if result == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// gitHubTracker mirrors to the issues of a repository with the REST API.
+type gitHubTracker struct {
+	base       string
+	repository string
+	token      string
+}
+
+func (tracker gitHubTracker) name() string {
+	return "github:" + tracker.repository
+}
+
+func (tracker gitHubTracker) auth(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer "+tracker.token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+}
+
+func (tracker gitHubTracker) create(ctx context.Context, i issue) (string, string, error) {
+	var created struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+	url := tracker.base + "/repos/" + tracker.repository + "/issues"
+	err := trackerRequest(ctx, http.MethodPost, url, tracker.auth, map[string]string{"title": i.Title, "body": i.Body}, &created)
+	if err != nil {
+		return "", "", err
+	}
+
+	return strconv.Itoa(created.Number), created.HTMLURL, nil
+}
+
+// update writes the issue, an issue without a title only changes its state.
+func (tracker gitHubTracker) update(ctx context.Context, id string, i issue) error {
+	body := map[string]string{"state": "open"}
+	if i.Closed {
+		body["state"] = "closed"
+	}
+	if i.Title != "" {
+		body["title"], body["body"] = i.Title, i.Body
+	}
+
+	url := tracker.base + "/repos/" + tracker.repository + "/issues/" + id
+	return trackerRequest(ctx, http.MethodPatch, url, tracker.auth, body, nil)
+}
+
+// jiraTracker mirrors to the tickets of a project with the REST API v2 of
+// Jira Cloud and Server. Tickets are closed and reopened with the first
+// transition to a status of the done or to do category.
+type jiraTracker struct {
+	base      string
+	project   string
+	issueType string
+	user      string
+	token     string
+}
+
+func (tracker jiraTracker) name() string {
+	return "jira:" + tracker.project
+}
+
+// auth uses basic authentication with an API token for a user, or the
+// token as a personal access token of Jira Server without one.
+func (tracker jiraTracker) auth(req *http.Request) {
+	if tracker.user == "" {
+		req.Header.Set("Authorization", "Bearer "+tracker.token)
+		return
+	}
+	req.SetBasicAuth(tracker.user, tracker.token)
+}
+
+func (tracker jiraTracker) create(ctx context.Context, i issue) (string, string, error) {
+	fields := map[string]interface{}{
+		"project":     map[string]string{"key": tracker.project},
+		"issuetype":   map[string]string{"name": tracker.issueType},
+		"summary":     i.Title,
+		"description": i.Body,
+	}
+	var created struct {
+		Key string `json:"key"`
+	}
+	err := trackerRequest(ctx, http.MethodPost, tracker.base+"/rest/api/2/issue", tracker.auth, map[string]interface{}{"fields": fields}, &created)
+	if err != nil {
+		return "", "", err
+	}
+
+	return created.Key, tracker.base + "/browse/" + created.Key, nil
+}
+
+// update writes the ticket and moves it to the status of i, a ticket without
+// a title only changes its status.
+func (tracker jiraTracker) update(ctx context.Context, key string, i issue) error {
+	url := tracker.base + "/rest/api/2/issue/" + key
+	if i.Title != "" {
+		fields := map[string]interface{}{"summary": i.Title, "description": i.Body}
+		if err := trackerRequest(ctx, http.MethodPut, url, tracker.auth, map[string]interface{}{"fields": fields}, nil); err != nil {
+			return err
+		}
+	}
+
+	var ticket struct {
+		Fields struct {
+			Status jiraStatus `json:"status"`
+		} `json:"fields"`
+	}
+	if err := trackerRequest(ctx, http.MethodGet, url+"?fields=status", tracker.auth, nil, &ticket); err != nil {
+		return err
+	}
+	done := ticket.Fields.Status.StatusCategory.Key == "done"
+	if done == i.Closed {
+		return nil
+	}
+
+	var transitions struct {
+		Transitions []struct {
+			ID string     `json:"id"`
+			To jiraStatus `json:"to"`
+		} `json:"transitions"`
+	}
+	if err := trackerRequest(ctx, http.MethodGet, url+"/transitions", tracker.auth, nil, &transitions); err != nil {
+		return err
+	}
+	category := "done"
+	if !i.Closed {
+		category = "new"
+	}
+	for _, transition := range transitions.Transitions {
+		if transition.To.StatusCategory.Key == category {
+			body := map[string]interface{}{"transition": map[string]string{"id": transition.ID}}
+			return trackerRequest(ctx, http.MethodPost, url+"/transitions", tracker.auth, body, nil)
+		}
+	}
+
+	return fmt.Errorf("%s has no transition to a status of the %s category", key, category)
+}
+
+type jiraStatus struct {
+	StatusCategory struct {
+		Key string `json:"key"`
+	} `json:"statusCategory"`
+}
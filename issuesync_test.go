@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+func TestNewIssueSyncsRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		config IssueSyncConfig
+	}{
+		{name: "no tag", config: IssueSyncConfig{Tracker: "github", Repository: "o/r", Token: "t"}},
+		{name: "no token", config: IssueSyncConfig{Tracker: "github", Repository: "o/r", Tag: "work"}},
+		{name: "repository", config: IssueSyncConfig{Tracker: "github", Repository: "repo", Tag: "work", Token: "t"}},
+		{name: "jira url", config: IssueSyncConfig{Tracker: "jira", Project: "OPS", Tag: "work", Token: "t"}},
+		{name: "jira project", config: IssueSyncConfig{Tracker: "jira", URL: "https://jira", Tag: "work", Token: "t"}},
+		{name: "tracker", config: IssueSyncConfig{Tracker: "gitlab", Tag: "work", Token: "t"}},
+	}
+
+	for _, test := range tests {
+		if _, err := newIssueSyncs([]IssueSyncConfig{test.config}); err == nil {
+			t.Errorf("%s: newIssueSyncs() = nil, want an error", test.name)
+		}
+	}
+
+	syncs, err := newIssueSyncs([]IssueSyncConfig{
+		{Tracker: "GitHub", Repository: "owner/repo", Tag: "#Work", Token: "t"},
+		{Tracker: "jira", URL: "https://example.atlassian.net/", Project: "OPS", Tag: "ops", Token: "t", IntervalMinutes: 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if syncs[0].tag != "work" || syncs[0].tracker.name() != "github:owner/repo" || syncs[0].interval != defaultSyncIntervalMinutes*time.Minute {
+		t.Errorf("newIssueSyncs()[0] = %+v", syncs[0])
+	}
+	if jira := syncs[1].tracker.(jiraTracker); jira.base != "https://example.atlassian.net" || jira.issueType != defaultJiraIssueType {
+		t.Errorf("newIssueSyncs()[1] = %+v", jira)
+	}
+}
+
+// fakeTracker keeps its issues in memory, numbered from 1.
+type fakeTracker struct {
+	issues map[string]issue
+}
+
+func (tracker *fakeTracker) name() string {
+	return "fake"
+}
+
+func (tracker *fakeTracker) create(ctx context.Context, i issue) (string, string, error) {
+	id := strconv.Itoa(len(tracker.issues) + 1)
+	tracker.issues[id] = i
+	return id, "https://issues/" + id, nil
+}
+
+func (tracker *fakeTracker) update(ctx context.Context, id string, i issue) error {
+	if i.Title == "" {
+		i.Title, i.Body = tracker.issues[id].Title, tracker.issues[id].Body
+	}
+	tracker.issues[id] = i
+	return nil
+}
+
+func TestIssueSyncReconcile(t *testing.T) {
+	todos, restore := useMemoryDatabase(t, []string{mirroredKey("fake")}, "tagged", "untagged", "done")
+	defer restore()
+	ctx := context.Background()
+
+	tag := func(id string) {
+		if err := database.UpdateTodo(ctx, id, func(todo *tododb.Todo) { todo.Tags = append(todo.Tags, "work") }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tag(todos[0].ID)
+	tag(todos[2].ID)
+	if err := database.CompleteTodos(ctx, []string{todos[2].ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	tracker := &fakeTracker{issues: map[string]issue{}}
+	s := issueSync{tag: "work", tracker: tracker}
+	reconcile := func(wantCreated, wantUpdated int) {
+		t.Helper()
+		created, updated, err := s.reconcile(ctx)
+		if err != nil || created != wantCreated || updated != wantUpdated {
+			t.Fatalf("reconcile() = %d, %d, %v, want %d, %d", created, updated, err, wantCreated, wantUpdated)
+		}
+	}
+
+	// only the open tagged todo gets an issue, and only once
+	reconcile(1, 0)
+	reconcile(0, 0)
+	if want := map[string]issue{"1": {Title: "tagged"}}; !reflect.DeepEqual(tracker.issues, want) {
+		t.Errorf("issues = %+v, want %+v", tracker.issues, want)
+	}
+	todo, _, err := findTodo(ctx, database, func(todo tododb.Todo) bool { return todo.ID == todos[0].ID })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link := remoteLink(todo, "fake"); link == nil || link.ID != "1" || link.URL != "https://issues/1" || link.Hash != (issue{Title: "tagged"}).hash() {
+		t.Errorf("remoteLink() = %+v, want the link to issue 1", link)
+	}
+
+	// changes are written to the issue
+	if err := database.UpdateTodo(ctx, todos[0].ID, func(todo *tododb.Todo) { todo.Description = "details" }); err != nil {
+		t.Fatal(err)
+	}
+	tag(todos[1].ID)
+	reconcile(1, 1)
+	if got := tracker.issues["1"]; got.Body != "details" {
+		t.Errorf("issue 1 = %+v, want the description", got)
+	}
+
+	// completing closes the issue, deleting the todo too
+	if err := database.CompleteTodos(ctx, []string{todos[0].ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.DeleteTodos(ctx, []string{todos[1].ID}); err != nil {
+		t.Fatal(err)
+	}
+	reconcile(0, 2)
+	want := map[string]issue{
+		"1": {Title: "tagged", Body: "details", Closed: true},
+		"2": {Title: "untagged", Closed: true},
+	}
+	if !reflect.DeepEqual(tracker.issues, want) {
+		t.Errorf("issues = %+v, want %+v", tracker.issues, want)
+	}
+	reconcile(0, 0)
+}
+
+func TestGitHubTracker(t *testing.T) {
+	var requests []string
+	var bodies []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		if r.Method == http.MethodPost {
+			w.Write([]byte(`{"number": 42, "html_url": "https://github.com/owner/repo/issues/42"}`))
+		}
+	}))
+	defer server.Close()
+
+	tracker := gitHubTracker{base: server.URL, repository: "owner/repo", token: "secret"}
+	id, url, err := tracker.create(context.Background(), issue{Title: "title", Body: "body"})
+	if err != nil || id != "42" || url != "https://github.com/owner/repo/issues/42" {
+		t.Fatalf("create() = %q, %q, %v", id, url, err)
+	}
+	if err := tracker.update(context.Background(), id, issue{Closed: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	wantRequests := []string{"POST /repos/owner/repo/issues", "PATCH /repos/owner/repo/issues/42"}
+	wantBodies := []map[string]string{{"title": "title", "body": "body"}, {"state": "closed"}}
+	if !reflect.DeepEqual(requests, wantRequests) || !reflect.DeepEqual(bodies, wantBodies) {
+		t.Errorf("requests = %v %v, want %v %v", requests, bodies, wantRequests, wantBodies)
+	}
+
+	tracker.token = "wrong"
+	if err := tracker.update(context.Background(), id, issue{Title: "title"}); err == nil {
+		t.Error("update() with a wrong token = nil, want an error")
+	}
+}
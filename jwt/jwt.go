@@ -0,0 +1,146 @@
+// Package jwt signs and verifies JSON web tokens (RFC 7519) with RS256,
+// the only algorithm accepted, and describes the public keys as JWK (RFC
+// 7517).
+package jwt
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Algorithm is the alg of the tokens and the keys.
+const Algorithm = "RS256"
+
+var encoding = base64.RawURLEncoding
+
+var (
+	// ErrMalformed is returned for tokens that aren't three base64url parts
+	// of JSON.
+	ErrMalformed = errors.New("jwt: malformed token")
+	// ErrUnknownKey is returned for tokens of another algorithm or signed
+	// by a key that isn't known.
+	ErrUnknownKey = errors.New("jwt: unknown algorithm or key")
+	// ErrSignature is returned for tokens with a wrong signature.
+	ErrSignature = errors.New("jwt: invalid signature")
+	// ErrExpired is returned for tokens past their expiry.
+	ErrExpired = errors.New("jwt: token expired")
+)
+
+// Claims are the registered claims used by the app and the id of the
+// session a token belongs to.
+type Claims struct {
+	Issuer    string `json:"iss,omitempty"`
+	Subject   string `json:"sub"`
+	Audience  string `json:"aud,omitempty"`
+	ID        string `json:"jti,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	Session   string `json:"sid,omitempty"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// Sign returns the token of claims signed with key, kid names the key in
+// the header.
+func Sign(claims Claims, key *rsa.PrivateKey, kid string) (string, error) {
+	h, err := json.Marshal(header{Algorithm: Algorithm, Type: "JWT", KeyID: kid})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := encoding.EncodeToString(h) + "." + encoding.EncodeToString(c)
+	sum := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + encoding.EncodeToString(signature), nil
+}
+
+// Verify checks the signature and the expiry of token and returns its
+// claims. keyOf returns the public key of a kid, nil if it isn't known.
+func Verify(token string, keyOf func(kid string) *rsa.PublicKey, now time.Time) (Claims, error) {
+	var claims Claims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, ErrMalformed
+	}
+
+	var h header
+	if err := decode(parts[0], &h); err != nil {
+		return claims, err
+	}
+	if h.Algorithm != Algorithm {
+		return claims, ErrUnknownKey
+	}
+	key := keyOf(h.KeyID)
+	if key == nil {
+		return claims, ErrUnknownKey
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, ErrMalformed
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], signature) != nil {
+		return claims, ErrSignature
+	}
+
+	if err := decode(parts[1], &claims); err != nil {
+		return claims, err
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return claims, ErrExpired
+	}
+
+	return claims, nil
+}
+
+func decode(part string, v interface{}) error {
+	raw, err := encoding.DecodeString(part)
+	if err != nil || json.Unmarshal(raw, v) != nil {
+		return ErrMalformed
+	}
+
+	return nil
+}
+
+// JWK is a public key as listed in a JWKS.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+// JWKOf describes the public key kid for verifying signatures.
+func JWKOf(key *rsa.PublicKey, kid string) JWK {
+	return JWK{
+		KeyType:   "RSA",
+		Use:       "sig",
+		Algorithm: Algorithm,
+		KeyID:     kid,
+		N:         encoding.EncodeToString(key.N.Bytes()),
+		E:         encoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
@@ -0,0 +1,100 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func TestVerify(t *testing.T) {
+	key, other := newKey(t), newKey(t)
+	keyOf := func(kid string) *rsa.PublicKey {
+		if kid == "current" {
+			return &key.PublicKey
+		}
+		return nil
+	}
+
+	now := time.Unix(1700000000, 0)
+	claims := Claims{Issuer: "todo-app", Subject: "jane", ID: "1", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix(), Session: "s1"}
+	sign := func(claims Claims, key *rsa.PrivateKey, kid string) string {
+		token, err := Sign(claims, key, kid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	token := sign(claims, key, "current")
+	parts := strings.Split(token, ".")
+
+	tests := []struct {
+		name  string
+		token string
+		now   time.Time
+		err   error
+	}{
+		{name: "valid", token: token, now: now},
+		{name: "a second before the expiry", token: token, now: now.Add(time.Hour - time.Second)},
+		{name: "expired", token: token, now: now.Add(time.Hour), err: ErrExpired},
+		{name: "unknown kid", token: sign(claims, key, "old"), now: now, err: ErrUnknownKey},
+		{name: "other key", token: sign(claims, other, "current"), now: now, err: ErrSignature},
+		{
+			name:  "alg none",
+			token: base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT","kid":"current"}`)) + "." + parts[1] + ".",
+			now:   now, err: ErrUnknownKey,
+		},
+		{
+			name:  "changed claims",
+			token: parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","iat":0,"exp":9999999999}`)) + "." + parts[2],
+			now:   now, err: ErrSignature,
+		},
+		{name: "two parts", token: parts[0] + "." + parts[1], now: now, err: ErrMalformed},
+		{name: "header not base64", token: "!." + parts[1] + "." + parts[2], now: now, err: ErrMalformed},
+		{name: "signature not base64", token: parts[0] + "." + parts[1] + ".!", now: now, err: ErrMalformed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Verify(test.token, keyOf, test.now)
+			if err != test.err {
+				t.Fatalf("Verify() error = %v, want %v", err, test.err)
+			}
+			if err == nil && got != claims {
+				t.Errorf("Verify() = %+v, want %+v", got, claims)
+			}
+		})
+	}
+}
+
+func TestJWKOf(t *testing.T) {
+	key := newKey(t)
+	jwk := JWKOf(&key.PublicKey, "k1")
+
+	if jwk.KeyType != "RSA" || jwk.Use != "sig" || jwk.Algorithm != Algorithm || jwk.KeyID != "k1" {
+		t.Errorf("JWKOf() = %+v", jwk)
+	}
+	// 65537 is AQAB in every JWKS
+	if jwk.E != "AQAB" {
+		t.Errorf("JWKOf().E = %s, want AQAB", jwk.E)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if new(big.Int).SetBytes(n).Cmp(key.N) != 0 {
+		t.Error("JWKOf().N isn't the modulus of the key")
+	}
+}
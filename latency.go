@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Latencies are kept in log-linear buckets like an HDR histogram: exact
+// below 16µs, above that 16 sub-buckets per power of two (about 6% error)
+// up to roughly 67 seconds.
+const (
+	latencySubBuckets = 16
+	latencyMaxShift   = 22
+	latencyBuckets    = latencySubBuckets + (latencyMaxShift+1)*latencySubBuckets
+)
+
+type latencyHistogram struct {
+	counts [latencyBuckets]uint64
+	total  uint64
+	max    int64
+}
+
+func latencyBucket(us int64) int {
+	if us < 0 {
+		us = 0
+	}
+	if us < latencySubBuckets {
+		return int(us)
+	}
+
+	shift := bits.Len64(uint64(us)) - 5
+	if shift > latencyMaxShift {
+		return latencyBuckets - 1
+	}
+
+	return latencySubBuckets + shift*latencySubBuckets + int(us>>uint(shift)) - latencySubBuckets
+}
+
+// latencyUpperBound is the largest value in µs that falls into bucket.
+func latencyUpperBound(bucket int) int64 {
+	if bucket < latencySubBuckets {
+		return int64(bucket)
+	}
+
+	shift := uint((bucket - latencySubBuckets) / latencySubBuckets)
+	sub := int64((bucket-latencySubBuckets)%latencySubBuckets + latencySubBuckets)
+	return (sub+1)<<shift - 1
+}
+
+func (h *latencyHistogram) record(us int64) {
+	h.counts[latencyBucket(us)]++
+	h.total++
+	if us > h.max {
+		h.max = us
+	}
+}
+
+func (h *latencyHistogram) merge(other *latencyHistogram) {
+	for i, count := range other.counts {
+		h.counts[i] += count
+	}
+	h.total += other.total
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+func (h *latencyHistogram) percentile(q float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+
+	rank := uint64(q*float64(h.total) + 0.5)
+	if rank == 0 {
+		rank = 1
+	}
+
+	var seen uint64
+	for bucket, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			if upper := latencyUpperBound(bucket); upper < h.max {
+				return upper
+			}
+			return h.max
+		}
+	}
+
+	return h.max
+}
+
+type latencyRecorder struct {
+	mu      sync.Mutex
+	window  int64
+	minutes map[string]map[int64]*latencyHistogram
+}
+
+func newLatencyRecorder(windowMinutes int) *latencyRecorder {
+	return &latencyRecorder{
+		window:  int64(windowMinutes),
+		minutes: map[string]map[int64]*latencyHistogram{},
+	}
+}
+
+func (r *latencyRecorder) record(route string, latency time.Duration, now time.Time) {
+	minute := now.Unix() / 60
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	slots, exists := r.minutes[route]
+	if !exists {
+		slots = map[int64]*latencyHistogram{}
+		r.minutes[route] = slots
+	}
+
+	h, exists := slots[minute]
+	if !exists {
+		h = &latencyHistogram{}
+		slots[minute] = h
+
+		// A new minute started, drop the slots that left the window
+		for old := range slots {
+			if old <= minute-r.window {
+				delete(slots, old)
+			}
+		}
+	}
+
+	h.record(int64(latency / time.Microsecond))
+}
+
+// routeOf turns the request path back into the route template, gin 1.4
+// doesn't expose it. /todo/Eat becomes /todo/:value.
+func routeOf(c *gin.Context) string {
+	path := c.Request.URL.Path
+	for _, param := range c.Params {
+		// custom methods like :stream are matched as a parameter
+		if strings.HasPrefix(param.Value, ":") {
+			continue
+		}
+		path = strings.Replace(path, param.Value, ":"+param.Key, 1)
+	}
+
+	return c.Request.Method + " " + path
+}
+
+func (r *latencyRecorder) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		r.record(routeOf(c), time.Since(start), time.Now())
+	}
+}
+
+type latencyBucketCount struct {
+	LeMs  float64 `json:"leMs"`
+	Count uint64  `json:"count"`
+}
+
+type latencyMinute struct {
+	Minute  time.Time            `json:"minute"`
+	Buckets []latencyBucketCount `json:"buckets"`
+}
+
+type latencyReport struct {
+	Count   uint64          `json:"count"`
+	P50Ms   float64         `json:"p50Ms"`
+	P90Ms   float64         `json:"p90Ms"`
+	P99Ms   float64         `json:"p99Ms"`
+	P999Ms  float64         `json:"p999Ms"`
+	MaxMs   float64         `json:"maxMs"`
+	Heatmap []latencyMinute `json:"heatmap"`
+}
+
+func microsToMillis(us int64) float64 {
+	return float64(us) / 1000
+}
+
+// report summarizes the last minutes for every route whose name contains
+// filter.
+func (r *latencyRecorder) report(minutes int64, filter string, now time.Time) map[string]latencyReport {
+	current := now.Unix() / 60
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reports := map[string]latencyReport{}
+	for route, slots := range r.minutes {
+		if !strings.Contains(route, filter) {
+			continue
+		}
+
+		keys := []int64{}
+		for minute := range slots {
+			if minute > current-minutes {
+				keys = append(keys, minute)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+		total := &latencyHistogram{}
+		heatmap := []latencyMinute{}
+		for _, minute := range keys {
+			h := slots[minute]
+			total.merge(h)
+
+			row := latencyMinute{Minute: time.Unix(minute*60, 0).UTC(), Buckets: []latencyBucketCount{}}
+			for bucket, count := range h.counts {
+				if count > 0 {
+					row.Buckets = append(row.Buckets, latencyBucketCount{
+						LeMs:  microsToMillis(latencyUpperBound(bucket)),
+						Count: count,
+					})
+				}
+			}
+			heatmap = append(heatmap, row)
+		}
+
+		if total.total == 0 {
+			continue
+		}
+
+		reports[route] = latencyReport{
+			Count:   total.total,
+			P50Ms:   microsToMillis(total.percentile(0.5)),
+			P90Ms:   microsToMillis(total.percentile(0.9)),
+			P99Ms:   microsToMillis(total.percentile(0.99)),
+			P999Ms:  microsToMillis(total.percentile(0.999)),
+			MaxMs:   microsToMillis(total.max),
+			Heatmap: heatmap,
+		}
+	}
+
+	return reports
+}
+
+func (r *latencyRecorder) handler(c *gin.Context) {
+	minutes, err := strconv.ParseInt(c.DefaultQuery("minutes", strconv.FormatInt(r.window, 10)), 10, 64)
+	if err != nil || minutes <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("invalid minutes: %q", c.Query("minutes")),
+		})
+		return
+	}
+	if minutes > r.window {
+		minutes = r.window
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"windowMinutes": minutes,
+		"routes":        r.report(minutes, c.Query("route"), time.Now()),
+	})
+}
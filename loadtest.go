@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// loadTestHeader tags a request as load generated, the frontend passes
+	// it on to the api like every other header
+	loadTestHeader = "X-Load-Test"
+	// recordsNamespaceKey holds the prefix of the stats and activity keys
+	// of a request
+	recordsNamespaceKey = "recordsNamespace"
+)
+
+func isLoadTest(req *http.Request) bool {
+	tagged, _ := strconv.ParseBool(req.Header.Get(loadTestHeader))
+	return tagged
+}
+
+// loadTestMiddleware counts every request in todoapp_requests_total, labeled
+// with whether it was tagged with X-Load-Test. With the namespace option the
+// stats and activity of tagged requests are kept under keys with that
+// prefix, so they don't show up in the streaks and digests.
+func loadTestMiddleware(options map[string]string, requests *prometheus.CounterVec) (gin.HandlerFunc, error) {
+	namespace := options["namespace"]
+
+	return func(c *gin.Context) {
+		tagged := isLoadTest(c.Request)
+		if tagged {
+			c.Header(loadTestHeader, "true")
+			if namespace != "" {
+				c.Set(recordsNamespaceKey, namespace)
+			}
+		}
+
+		c.Next()
+
+		route := routeOf(c)
+		if c.Writer.Status() == http.StatusNotFound {
+			route = "unmatched"
+		}
+		requests.WithLabelValues(route, strconv.Itoa(c.Writer.Status()), strconv.FormatBool(tagged)).Inc()
+	}, nil
+}
+
+// recordsNamespace is the prefix of the stats and activity keys of the
+// request, empty unless it is a load test kept apart.
+func recordsNamespace(c *gin.Context) string {
+	return c.GetString(recordsNamespaceKey)
+}
+
+// accessLogFormatter is the format of gin.Logger, with load tests tagged at
+// the end of the line.
+func accessLogFormatter(param gin.LogFormatterParams) string {
+	var statusColor, methodColor, resetColor string
+	if param.IsOutputColor() {
+		statusColor = param.StatusCodeColor()
+		methodColor = param.MethodColor()
+		resetColor = param.ResetColor()
+	}
+
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency - param.Latency%time.Second
+	}
+
+	tag := ""
+	if isLoadTest(param.Request) {
+		tag = " | load-test"
+	}
+
+	return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %s%s\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		statusColor, param.StatusCode, resetColor,
+		param.Latency,
+		param.ClientIP,
+		methodColor, param.Method, resetColor,
+		param.Path,
+		tag,
+		param.ErrorMessage,
+	)
+}
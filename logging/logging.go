@@ -0,0 +1,226 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+
+	return levelNames[l]
+}
+
+func (l Level) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + l.String() + `"`), nil
+}
+
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+
+	return Info, fmt.Errorf("unknown log level %q, use one of %s", name, strings.Join(levelNames, ", "))
+}
+
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Level   Level     `json:"level"`
+	Module  string    `json:"module"`
+	Message string    `json:"message"`
+}
+
+const defaultBufferSize = 1000
+
+var (
+	mu     sync.Mutex
+	output io.Writer = os.Stderr
+	ring             = make([]Entry, defaultBufferSize)
+	next   int
+	filled bool
+
+	levelMu      sync.RWMutex
+	level        = Info
+	moduleLevels = map[string]Level{}
+)
+
+// SetLevels replaces the global level and the per module overrides.
+func SetLevels(global Level, modules map[string]Level) {
+	levelMu.Lock()
+	defer levelMu.Unlock()
+
+	level = global
+	moduleLevels = map[string]Level{}
+	for module, moduleLevel := range modules {
+		moduleLevels[module] = moduleLevel
+	}
+}
+
+// Levels returns the global level and a copy of the per module overrides.
+func Levels() (Level, map[string]Level) {
+	levelMu.RLock()
+	defer levelMu.RUnlock()
+
+	modules := map[string]Level{}
+	for module, moduleLevel := range moduleLevels {
+		modules[module] = moduleLevel
+	}
+
+	return level, modules
+}
+
+// Enabled reports whether an entry of module at l would be logged.
+func Enabled(module string, l Level) bool {
+	levelMu.RLock()
+	defer levelMu.RUnlock()
+
+	if moduleLevel, exists := moduleLevels[module]; exists {
+		return l >= moduleLevel
+	}
+
+	return l >= level
+}
+
+// SetBufferSize changes how many entries are kept for Entries. The buffer
+// starts over empty.
+func SetBufferSize(size int) {
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	ring = make([]Entry, size)
+	next = 0
+	filled = false
+}
+
+func record(entry Entry) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	fmt.Fprintf(output, "%s [%s] %s: %s\n",
+		entry.Time.Format("2006/01/02 15:04:05"),
+		strings.ToUpper(entry.Level.String()),
+		entry.Module,
+		entry.Message,
+	)
+
+	ring[next] = entry
+	next = (next + 1) % len(ring)
+	if next == 0 {
+		filled = true
+	}
+}
+
+// Entries returns up to limit of the newest buffered entries that match,
+// oldest first. A limit of zero or less returns all matches.
+func Entries(match func(Entry) bool, limit int) []Entry {
+	mu.Lock()
+	defer mu.Unlock()
+
+	ordered := ring[:next]
+	if filled {
+		ordered = append(append([]Entry{}, ring[next:]...), ring[:next]...)
+	}
+
+	result := []Entry{}
+	for i := len(ordered) - 1; i >= 0 && (limit <= 0 || len(result) < limit); i-- {
+		if match(ordered[i]) {
+			result = append(result, ordered[i])
+		}
+	}
+
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+
+	return result
+}
+
+type Logger struct {
+	module string
+}
+
+func New(module string) *Logger {
+	return &Logger{module: module}
+}
+
+func (l *Logger) log(level Level, format string, args ...interface{}) {
+	if !Enabled(l.module, level) {
+		return
+	}
+
+	record(Entry{
+		Time:    time.Now(),
+		Level:   level,
+		Module:  l.module,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// DebugEnabled allows to skip building expensive debug messages.
+func (l *Logger) DebugEnabled() bool {
+	return Enabled(l.module, Debug)
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) { l.log(Debug, format, args...) }
+func (l *Logger) Infof(format string, args ...interface{})  { l.log(Info, format, args...) }
+func (l *Logger) Warnf(format string, args ...interface{})  { l.log(Warn, format, args...) }
+func (l *Logger) Errorf(format string, args ...interface{}) { l.log(Error, format, args...) }
+
+// Writer turns every line written to it into an entry of module, for
+// output of code that doesn't use a Logger like the standard log package or
+// gin's access log.
+func Writer(module string, level Level) io.Writer {
+	return &lineWriter{logger: New(module), level: level}
+}
+
+type lineWriter struct {
+	mu      sync.Mutex
+	logger  *Logger
+	level   Level
+	pending []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := strings.TrimRight(string(w.pending[:i]), "\r")
+		w.pending = w.pending[i+1:]
+		if line != "" {
+			w.logger.log(w.level, "%s", line)
+		}
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/logging"
+)
+
+type logLevels struct {
+	Level   string            `json:"level"`
+	Modules map[string]string `json:"modules"`
+}
+
+// applyLogLevels parses the global level and the per module levels, an
+// empty global level means info.
+func applyLogLevels(levels logLevels) error {
+	global := logging.Info
+	if levels.Level != "" {
+		var err error
+		if global, err = logging.ParseLevel(levels.Level); err != nil {
+			return err
+		}
+	}
+
+	modules := map[string]logging.Level{}
+	for module, name := range levels.Modules {
+		moduleLevel, err := logging.ParseLevel(name)
+		if err != nil {
+			return err
+		}
+		modules[module] = moduleLevel
+	}
+
+	logging.SetLevels(global, modules)
+	return nil
+}
+
+func currentLogLevels() logLevels {
+	global, modules := logging.Levels()
+	levels := logLevels{Level: global.String(), Modules: map[string]string{}}
+	for module, moduleLevel := range modules {
+		levels.Modules[module] = moduleLevel.String()
+	}
+
+	return levels
+}
+
+// reloadLogLevelsOnSIGHUP applies the log levels of the config file again
+// whenever the process receives SIGHUP, e.g. after a ConfigMap update.
+func reloadLogLevelsOnSIGHUP(configFile string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		config, err := readConfig(configFile)
+		if err != nil {
+			logger.Errorf("Reloading log levels: %v", err)
+			continue
+		}
+
+		if err := applyLogLevels(logLevels{Level: config.LogLevel, Modules: config.LogModules}); err != nil {
+			logger.Errorf("Reloading log levels: %v", err)
+			continue
+		}
+		logger.Infof("Reloaded log levels: %+v", currentLogLevels())
+	}
+}
+
+func getLogLevelHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, currentLogLevels())
+}
+
+func setLogLevelHandler(c *gin.Context) {
+	var levels logLevels
+	if err := c.ShouldBindJSON(&levels); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	if err := applyLogLevels(levels); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	logger.Infof("Changed log levels: %+v", currentLogLevels())
+	c.JSON(http.StatusOK, currentLogLevels())
+}
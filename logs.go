@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/logging"
+)
+
+var logger = logging.New("http")
+
+// logsHandler returns the buffered log entries, filtered by minimum level,
+// module and a regular expression on the message.
+func logsHandler(c *gin.Context) {
+	minLevel, err := logging.ParseLevel(c.DefaultQuery("level", "debug"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	pattern, err := regexp.Compile(c.Query("q"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("invalid regular expression: %v", err),
+		})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("invalid limit: %q", c.Query("limit")),
+		})
+		return
+	}
+
+	module := c.Query("module")
+	c.JSON(http.StatusOK, logging.Entries(func(entry logging.Entry) bool {
+		return entry.Level >= minLevel &&
+			(module == "" || entry.Module == module) &&
+			pattern.MatchString(entry.Message)
+	}, limit))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// MailConfig is the SMTP server mails like password resets are sent with.
+// Without SMTPAddr no mails are sent.
+type MailConfig struct {
+	// SMTPAddr is host:port, like smtp.example.com:587. STARTTLS is used if
+	// the server offers it
+	SMTPAddr string
+	From     string
+	Username string
+	Password string
+}
+
+var errMailDisabled = errors.New("mail is not configured, set Mail.SMTPAddr")
+
+// sendMail sends a plain text mail to a single recipient.
+func sendMail(config MailConfig, to, subject, body string) error {
+	if config.SMTPAddr == "" {
+		return errMailDisabled
+	}
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return errors.New("mail headers must not contain line breaks")
+	}
+
+	var auth smtp.Auth
+	if config.Username != "" {
+		host, _, err := net.SplitHostPort(config.SMTPAddr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", config.Username, config.Password, host)
+	}
+
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		config.From, to, subject, time.Now().Format(time.RFC1123Z), strings.Replace(body, "\n", "\r\n", -1))
+
+	return smtp.SendMail(config.SMTPAddr, auth, config.From, []string{to}, []byte(message))
+}
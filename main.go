@@ -1,60 +1,248 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
 	"strings"
+	"time"
 
-	"github.com/gin-gonic/contrib/static"
 	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/johscheuer/todo-app-web/features"
+	"github.com/johscheuer/todo-app-web/importer"
+	"github.com/johscheuer/todo-app-web/logging"
+	"github.com/johscheuer/todo-app-web/seed"
 	"github.com/johscheuer/todo-app-web/tododb"
 	"github.com/mcuadros/go-gin-prometheus"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+// The routes are defined in package api, registerRoutes is generated
+//go:generate go run ./apigen
+
 var (
-	appVersion  string
 	showVersion bool
 	database    tododb.TodoDB
+	appConfig   *TodoAppConfig
 )
 
 func main() {
 	configFile := flag.String("config-file", "./default.config", "Path to the configuration file")
+	importPath := flag.String("import-file", "", "Imports the todos of an export file and exits")
+	importFormat := flag.String("import-format", "trello", "Format of the import file: "+strings.Join(importer.Names(), ", "))
+	seedProfile := flag.String("seed-profile", "", "Generates todos from a seed profile and exits: "+strings.Join(seed.Names(), ", "))
+	seedNumber := flag.Int64("seed", defaultSeedNumber, "Random seed for -seed-profile, the same seed generates the same todos")
+	seedReplace := flag.Bool("seed-replace", false, "Replace the existing todos instead of appending the generated ones")
+	role := flag.String("role", roleAll, "Serves the UI and the API (all), only the UI forwarding to APIURL (frontend) or only the API (api)")
+	listen := flag.String("listen", ":3000", "Address to listen on")
 	flag.BoolVar(&showVersion, "version", false, "Shows the version")
+	flag.BoolVar(&strictConfig, "strict-config", false, "Rejects unknown keys in the config file instead of ignoring them")
 	flag.Parse()
 
 	if showVersion {
-		log.Printf("Version: %s\n", appVersion)
+		info := buildinfo.Get()
+		log.Printf("Version: %s, commit: %s, built: %s, %s\n", info.Version, info.GitCommit, info.BuildDate, info.GoVersion)
 		os.Exit(0)
 	}
 
+	if err := checkRole(*role); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
 	config, err := readConfig(*configFile)
 	if err != nil {
 		log.Println(err)
 		os.Exit(1)
 	}
+	appConfig = config
+
+	logging.SetBufferSize(config.LogBufferSize)
+	log.SetFlags(0)
+	log.SetOutput(logging.Writer("app", logging.Info))
+	gin.DefaultWriter = logging.Writer("http", logging.Info)
+	gin.DefaultErrorWriter = logging.Writer("http", logging.Error)
+	if err := applyLogLevels(logLevels{Level: config.LogLevel, Modules: config.LogModules}); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	go reloadLogLevelsOnSIGHUP(*configFile)
+
+	applyGCConfig(config.GC)
+
+	if err := features.Init(config.Features); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	if err := initFeatureProvider(config.FeatureProvider); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	if err := checkBranding(config.Branding); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	if *role == roleFrontend {
+		if err := runFrontend(config, *listen); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	gin.SetMode(config.ReleaseMode)
-	if strings.ToLower(config.DBDriver) == "redis" {
-		database = tododb.NewRedisDB(config.DBConfig, appVersion)
-	} else {
-		log.Printf("Datebase: %s is not supported", config.DBDriver)
+	initShareSecret(config.ShareSecret)
+
+	if err := registerImporterPlugins(config.ImporterPlugins); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	database, err = tododb.Open(config.Backend, backendOptions(config))
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	attachmentStore, err = tododb.OpenAttachmentStore(config.Attachments.Store, config.Attachments.Options)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	attachmentScanner, err = newScanner(config.Attachments.Scan)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	if *importPath != "" {
+		imported, err := importFile(context.Background(), *importFormat, *importPath)
+		log.Printf("Imported %d todos from %s\n", imported, *importPath)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if *seedProfile != "" {
+		seeded, err := seedTodos(context.Background(), *seedProfile, *seedNumber, *seedReplace)
+		log.Printf("Seeded %d todos from profile %s\n", seeded, *seedProfile)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if config.Demo.Enabled {
+		todos, err := loadSeed(config.Demo)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		go runDemoResets(todos, time.Duration(config.Demo.ResetMinutes)*time.Minute)
+	}
+
+	webhooks, err = newWebhooks(config.Webhooks)
+	if err != nil {
+		log.Println(err)
 		os.Exit(1)
 	}
+	if len(webhooks) > 0 {
+		go runWebhooks()
+	}
+
+	hooksConfig = withHookDefaults(config.WasmHooks)
+	if hooksConfig.Enabled {
+		go runWasmHooks()
+	}
+
+	syncs, err := newIssueSyncs(config.IssueSync)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	for _, s := range syncs {
+		go runIssueSync(s)
+	}
+
+	if digestsWanted(config.Digest) {
+		if _, exists := digestPeriods[config.Digest.Period]; !exists {
+			log.Printf("Unknown digest period %q, use daily or weekly", config.Digest.Period)
+			os.Exit(1)
+		}
+		if config.Digest.Hour < 0 || config.Digest.Hour > 23 {
+			log.Printf("Digest hour %d isn't between 0 and 23", config.Digest.Hour)
+			os.Exit(1)
+		}
+		if _, err := digestTemplate(config.Digest); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		go runDigests(config.Digest)
+	}
+
+	if config.Telemetry.Enabled {
+		if config.Telemetry.Endpoint == "" {
+			log.Println("Telemetry is enabled without an Endpoint")
+			os.Exit(1)
+		}
+		go runTelemetry(config.Telemetry)
+	}
 
 	p := ginprometheus.NewPrometheus("gin")
-	database.RegisterMetrics()
+	metrics := NewMetrics()
+	if err := metrics.Register(prometheus.DefaultRegisterer); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	if err := database.RegisterMetrics(prometheus.DefaultRegisterer); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	// Dependencies and timers of older versions are keyed by the titles of
+	// the todos
+	for _, migrate := range []func(context.Context) error{migrateDependencies, migrateTimeTracking} {
+		if err := migrate(context.Background()); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+	go runTrashPurges(config.TrashDays)
+	go runBlobCollections()
+	go runThumbnails()
+	if !config.Recurrence.Disabled {
+		go runRecurrences(time.Duration(config.Recurrence.IntervalSeconds) * time.Second)
+	}
+	go runWatchdog(config.Watchdog, metrics)
+	go recordGCPauses(metrics.gcPauseSeconds)
+	countStart()
 
-	router := gin.Default()
+	latencies := newLatencyRecorder(config.LatencyWindowMinutes)
+	middleware, err := buildMiddleware(middlewareFactories(p, latencies, metrics), config.Middleware, middlewareGroups)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
 
-	p.Use(router)
-	router.GET("/todo", readTodoHandler)
-	router.POST("/todo/:value", insertTodoHandler)
-	router.DELETE("/todo/:value", deleteTodoHandler)
-	router.GET("/health", healthCheckHandler)
-	router.GET("/whoami", whoAmIHandler)
-	router.GET("/version", versionHandler)
+	router := gin.New()
+	router.Use(middleware["global"]...)
+	router.Use(countRequests)
+	p.SetMetricsPath(router)
 
-	router.Use(static.Serve("/", static.LocalFile("./public", true)))
-	router.Run(":3000")
+	registerRoutes(router, middleware)
+	// Not part of the API, the recorder belongs to this process
+	router.Group("/", middleware["ops"]...).GET("/debug/latency", latencies.handler)
+
+	if *role == roleAll {
+		if err := serveUI(router, config.Branding); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+	router.Run(*listen)
 }
@@ -0,0 +1,10 @@
+//go:build !go1.19
+// +build !go1.19
+
+package main
+
+import "errors"
+
+func setMemoryLimit(limit int64) error {
+	return errors.New("MemoryLimitMB needs a build with Go 1.19 or newer, use GOGC instead")
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are the collectors of the app itself, the backend brings its own
+// with tododb.Metrics. They are created per app instance and registered
+// with a registry passed in, so tests can run several instances without
+// duplicate registrations.
+type Metrics struct {
+	buildInfo      *prometheus.GaugeVec
+	gcPauseSeconds prometheus.Histogram
+	// Goroutines and open file descriptors are already exported as
+	// go_goroutines and process_open_fds by the default collectors.
+	backendConnections   prometheus.Gauge
+	watchdogAlertsTotal  *prometheus.CounterVec
+	policyDecisionsTotal *prometheus.CounterVec
+	responseRawBytes     *prometheus.HistogramVec
+	responseSentBytes    *prometheus.HistogramVec
+	requestsTotal        *prometheus.CounterVec
+	loginFailuresTotal   *prometheus.CounterVec
+	lockoutsTotal        *prometheus.CounterVec
+	accountStoredBytes   *prometheus.GaugeVec
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		buildInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_build_info",
+				Help: "Always 1, the labels describe the running build",
+			},
+			[]string{"version", "commit", "build_date", "go_version"},
+		),
+		gcPauseSeconds: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "todoapp_gc_pause_seconds",
+				Help:    "Distribution of stop the world GC pauses",
+				Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+			},
+		),
+		backendConnections: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "todoapp_backend_connections",
+				Help: "Connections currently held open by the database backend",
+			},
+		),
+		watchdogAlertsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "todoapp_watchdog_alerts_total",
+				Help: "Total count of watchdog samples above a threshold",
+			},
+			[]string{"resource"},
+		),
+		policyDecisionsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "todoapp_policy_decisions_total",
+				Help: "Total count of authorization decisions by the policy engine",
+			},
+			[]string{"decision"},
+		),
+		responseRawBytes: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "todoapp_response_raw_bytes",
+				Help:    "Size of response bodies before compression",
+				Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+			},
+			[]string{"route", "content"},
+		),
+		responseSentBytes: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "todoapp_response_sent_bytes",
+				Help:    "Size of response bodies as sent, after compression",
+				Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+			},
+			[]string{"route", "content", "encoding"},
+		),
+		requestsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "todoapp_requests_total",
+				Help: "Total count of requests, load_test tells the load generated ones apart",
+			},
+			[]string{"route", "code", "load_test"},
+		),
+		loginFailuresTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "todoapp_login_failures_total",
+				Help: "Total count of failed sign ins of local accounts, by what was wrong",
+			},
+			[]string{"reason"},
+		),
+		lockoutsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "todoapp_lockouts_total",
+				Help: "Total count of lockouts after failed sign ins, by what was locked out",
+			},
+			[]string{"scope"},
+		),
+		accountStoredBytes: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_account_storage_stored_bytes",
+				Help: "Size of the todos of an account as stored in the database, as last read by the quota middleware",
+			},
+			[]string{"account"},
+		),
+	}
+
+	info := buildinfo.Get()
+	m.buildInfo.WithLabelValues(info.Version, info.GitCommit, info.BuildDate, info.GoVersion).Set(1)
+
+	return m
+}
+
+func (m *Metrics) Register(registerer prometheus.Registerer) error {
+	collectors := []prometheus.Collector{
+		m.buildInfo,
+		m.gcPauseSeconds,
+		m.backendConnections,
+		m.watchdogAlertsTotal,
+		m.policyDecisionsTotal,
+		m.responseRawBytes,
+		m.responseSentBytes,
+		m.requestsTotal,
+		m.loginFailuresTotal,
+		m.lockoutsTotal,
+		m.accountStoredBytes,
+	}
+	for _, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mcuadros/go-gin-prometheus"
+)
+
+// MiddlewareConfig is one step of the middleware chain of a route group.
+type MiddlewareConfig struct {
+	Name     string
+	Disabled bool
+	Options  map[string]string
+}
+
+type middlewareFactory func(options map[string]string) (gin.HandlerFunc, error)
+
+// middlewareGroups are the route groups whose chains can be configured.
+// global applies to every request, including the static files.
+var middlewareGroups = []string{"global", "todo", "public", "integrations", "admin", "ops", "accounts", "account"}
+
+// defaultMiddleware is used for every group that is missing in the config.
+var defaultMiddleware = map[string][]MiddlewareConfig{
+	"global": {
+		{Name: "logger"},
+		{Name: "recovery"},
+		{Name: "metrics"},
+		{Name: "latency"},
+		{Name: "responseSize"},
+		{Name: "loadTest"},
+	},
+	"todo":         {{Name: "accountAuth", Options: map[string]string{"optional": "true"}}},
+	"integrations": {{Name: "integrationAuth"}},
+	"admin":        {{Name: "adminAuth"}},
+	"account":      {{Name: "accountAuth"}},
+}
+
+func middlewareFactories(p *ginprometheus.Prometheus, latencies *latencyRecorder, metrics *Metrics) map[string]middlewareFactory {
+	return map[string]middlewareFactory{
+		"logger":          fixedMiddleware(gin.LoggerWithFormatter(accessLogFormatter)),
+		"recovery":        fixedMiddleware(gin.Recovery()),
+		"metrics":         fixedMiddleware(p.HandlerFunc()),
+		"latency":         fixedMiddleware(latencies.middleware()),
+		"responseSize":    fixedMiddleware(responseSizeMiddleware(metrics)),
+		"adminAuth":       fixedMiddleware(adminAuth()),
+		"integrationAuth": fixedMiddleware(integrationAuth()),
+		"gzip":            gzipMiddleware,
+		"cors":            corsMiddleware,
+		"ratelimit":       rateLimitMiddleware,
+		"chaos":           chaosMiddleware,
+		"loadTest": func(options map[string]string) (gin.HandlerFunc, error) {
+			return loadTestMiddleware(options, metrics.requestsTotal)
+		},
+		"quota": func(options map[string]string) (gin.HandlerFunc, error) {
+			return quotaMiddleware(options, metrics.accountStoredBytes)
+		},
+		"accountAuth": func(options map[string]string) (gin.HandlerFunc, error) {
+			return accountAuthMiddleware(options, metrics.loginFailuresTotal, metrics.lockoutsTotal)
+		},
+		"opa": func(options map[string]string) (gin.HandlerFunc, error) {
+			return opaMiddleware(options, metrics.policyDecisionsTotal)
+		},
+	}
+}
+
+func fixedMiddleware(handler gin.HandlerFunc) middlewareFactory {
+	return func(map[string]string) (gin.HandlerFunc, error) {
+		return handler, nil
+	}
+}
+
+func gzipMiddleware(options map[string]string) (gin.HandlerFunc, error) {
+	level, err := intOption(options, "level", gzip.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if level < gzip.DefaultCompression || level > gzip.BestCompression {
+		return nil, fmt.Errorf("level must be between %d and %d", gzip.DefaultCompression, gzip.BestCompression)
+	}
+
+	return gzipHandler(level), nil
+}
+
+// buildMiddleware turns the configured chains of the groups into handlers, in
+// the order of the config. Disabled steps are skipped.
+func buildMiddleware(factories map[string]middlewareFactory, configs map[string][]MiddlewareConfig, groups []string) (map[string][]gin.HandlerFunc, error) {
+	for group := range configs {
+		if !isMiddlewareGroup(group) {
+			return nil, fmt.Errorf("unknown middleware group %q, use one of %s", group, strings.Join(middlewareGroups, ", "))
+		}
+	}
+
+	chains := map[string][]gin.HandlerFunc{}
+	for _, group := range groups {
+		steps, configured := configs[group]
+		if !configured {
+			steps = defaultMiddleware[group]
+		}
+
+		chains[group] = []gin.HandlerFunc{}
+		names := []string{}
+		for _, step := range steps {
+			if step.Disabled {
+				continue
+			}
+
+			factory, exists := factories[step.Name]
+			if !exists {
+				return nil, fmt.Errorf("unknown middleware %q in group %s", step.Name, group)
+			}
+
+			handler, err := factory(step.Options)
+			if err != nil {
+				return nil, fmt.Errorf("middleware %s in group %s: %v", step.Name, group, err)
+			}
+
+			chains[group] = append(chains[group], handler)
+			names = append(names, step.Name)
+		}
+		logger.Infof("Middleware of %s: %s", group, strings.Join(names, ", "))
+	}
+
+	if _, built := chains["admin"]; built && !hasMiddleware(configs, "admin", "adminAuth") {
+		logger.Warnf("adminAuth is not part of the admin middleware, the admin endpoints are open")
+	}
+	if _, built := chains["todo"]; built && !hasMiddleware(configs, "todo", "accountAuth") {
+		logger.Warnf("accountAuth is not part of the todo middleware, every caller can read and change every todo")
+	}
+
+	return chains, nil
+}
+
+func isMiddlewareGroup(name string) bool {
+	for _, group := range middlewareGroups {
+		if group == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasMiddleware(configs map[string][]MiddlewareConfig, group, name string) bool {
+	steps, configured := configs[group]
+	if !configured {
+		steps = defaultMiddleware[group]
+	}
+
+	for _, step := range steps {
+		if step.Name == name && !step.Disabled {
+			return true
+		}
+	}
+
+	return false
+}
+
+func intOption(options map[string]string, key string, fallback int) (int, error) {
+	value, exists := options[key]
+	if !exists {
+		return fallback, nil
+	}
+
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", key, value)
+	}
+
+	return result, nil
+}
+
+func floatOption(options map[string]string, key string, fallback float64) (float64, error) {
+	value, exists := options[key]
+	if !exists {
+		return fallback, nil
+	}
+
+	result, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", key, value)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	defaultPerPage = 50
+	maxPerPage     = 100
+	// maxPage keeps the offset of a page, (page-1)*perPage, far from
+	// overflowing
+	maxPage = 1000000
+)
+
+// wantsPage reports whether the request asks for a single page of the
+// todos instead of all of them.
+func wantsPage(c *gin.Context) bool {
+	return c.Query("page") != "" || c.Query("per_page") != ""
+}
+
+// listTodoPage answers with page ?page= (counted from 1) of ?per_page=
+// todos. X-Total-Count is the number of all matching todos, Link points to
+// the neighbouring pages.
+func listTodoPage(c *gin.Context, filters ...tododb.TodoFilter) {
+	page, perPage, ok := pageQuery(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	total, err := todosOf(c).CountTodos(ctx, filters...)
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	todos, err := todosOf(c).GetTodos(ctx, (page-1)*perPage, perPage, filters...)
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	c.Header("Link", pageLinks(c.Request.URL, page, perPage, total))
+	c.JSON(http.StatusOK, todos)
+}
+
+// listPageOf answers with one page of todos that were all read already,
+// like listTodoPage.
+func listPageOf(c *gin.Context, todos []tododb.Todo) {
+	page, perPage, ok := pageQuery(c)
+	if !ok {
+		return
+	}
+
+	total := len(todos)
+	start, end := (page-1)*perPage, page*perPage
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	c.Header("Link", pageLinks(c.Request.URL, page, perPage, total))
+	c.JSON(http.StatusOK, todos[start:end])
+}
+
+// pageQuery reads ?page= and ?per_page=, it answers bad requests itself.
+func pageQuery(c *gin.Context) (int, int, bool) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 || page > maxPage {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("invalid page: %q, use 1 to %d", c.Query("page"), maxPage),
+		})
+		return 0, 0, false
+	}
+
+	perPage, err := strconv.Atoi(c.DefaultQuery("per_page", strconv.Itoa(defaultPerPage)))
+	if err != nil || perPage < 1 || perPage > maxPerPage {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("per_page must be between 1 and %d", maxPerPage),
+		})
+		return 0, 0, false
+	}
+
+	return page, perPage, true
+}
+
+// pageLinks is the Link header of a page, in the format of RFC 8288.
+func pageLinks(current *url.URL, page, perPage, total int) string {
+	last := (total + perPage - 1) / perPage
+	if last < 1 {
+		last = 1
+	}
+
+	link := func(page int, rel string) string {
+		target := *current
+		query := target.Query()
+		query.Set("page", strconv.Itoa(page))
+		query.Set("per_page", strconv.Itoa(perPage))
+		target.RawQuery = query.Encode()
+		return fmt.Sprintf("<%s>; rel=%q", target.RequestURI(), rel)
+	}
+
+	links := []string{link(1, "first")}
+	if page > 1 {
+		links = append(links, link(page-1, "prev"))
+	}
+	if page < last {
+		links = append(links, link(page+1, "next"))
+	}
+	links = append(links, link(last, "last"))
+
+	return strings.Join(links, ", ")
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+func TestPageLinks(t *testing.T) {
+	current, _ := url.Parse("/api/v1/todos?status=open&page=2")
+	tests := []struct {
+		page, perPage, total int
+		want                 string
+	}{
+		{
+			page: 1, perPage: 10, total: 0,
+			want: `</api/v1/todos?page=1&per_page=10&status=open>; rel="first", </api/v1/todos?page=1&per_page=10&status=open>; rel="last"`,
+		},
+		{
+			page: 2, perPage: 10, total: 25,
+			want: `</api/v1/todos?page=1&per_page=10&status=open>; rel="first", </api/v1/todos?page=1&per_page=10&status=open>; rel="prev", ` +
+				`</api/v1/todos?page=3&per_page=10&status=open>; rel="next", </api/v1/todos?page=3&per_page=10&status=open>; rel="last"`,
+		},
+	}
+
+	for _, test := range tests {
+		if got := pageLinks(current, test.page, test.perPage, test.total); got != test.want {
+			t.Errorf("pageLinks(%d, %d, %d) = %s, want %s", test.page, test.perPage, test.total, got, test.want)
+		}
+	}
+}
+
+func TestTodoPages(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+	server.createAccount("jane")
+	server.createAccount("bob")
+	for _, title := range []string{"a", "b", "c"} {
+		server.createTodo(asAccount("jane"), title)
+	}
+	server.createTodo(asAccount("bob"), "d")
+
+	// the pages only count the todos the account may read
+	for _, path := range []string{"/api/v1/todos?page=2&per_page=2", "/api/v1/todos?page=2&per_page=2&sort=title"} {
+		recorder := server.serve("GET", path, asAccount("jane"), nil)
+		var todos []tododb.Todo
+		if err := json.Unmarshal(recorder.Body.Bytes(), &todos); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if total := recorder.Header().Get("X-Total-Count"); total != "3" || len(todos) != 1 || todos[0].Title != "c" {
+			t.Errorf("%s has %+v of %s, want c of 3", path, todos, total)
+		}
+	}
+
+	for _, query := range []string{"page=0", "page=x", "per_page=0", "per_page=101"} {
+		server.expect(http.StatusBadRequest, "GET", "/api/v1/todos?"+query, asAccount("jane"), nil, nil)
+	}
+}
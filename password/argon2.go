@@ -0,0 +1,273 @@
+package password
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// Argon2id (RFC 9106), version 0x13.
+
+const (
+	argon2Version    = 0x13
+	argon2idType     = 2
+	argon2BlockWords = 128
+	argon2SyncPoints = 4
+)
+
+type argon2Block [argon2BlockWords]uint64
+
+// argon2id derives a key of keyLen bytes. memory is in KiB, the lanes are
+// filled in parallel.
+func argon2id(password, salt, secret, data []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+	lanes := uint32(threads)
+	h0 := argon2InitHash(password, salt, secret, data, time, memory, lanes, keyLen)
+
+	memory = memory / (argon2SyncPoints * lanes) * (argon2SyncPoints * lanes)
+	if memory < 2*argon2SyncPoints*lanes {
+		memory = 2 * argon2SyncPoints * lanes
+	}
+
+	blocks := argon2InitBlocks(&h0, memory, lanes)
+	argon2Fill(blocks, time, memory, lanes)
+	return argon2Extract(blocks, memory, lanes, keyLen)
+}
+
+func argon2InitHash(password, salt, secret, data []byte, time, memory, lanes, keyLen uint32) [blake2bSize + 8]byte {
+	var h0 [blake2bSize + 8]byte
+	var word [4]byte
+
+	b := newBlake2b(blake2bSize)
+	for _, value := range []uint32{lanes, keyLen, memory, time, argon2Version, argon2idType} {
+		binary.LittleEndian.PutUint32(word[:], value)
+		b.Write(word[:])
+	}
+	for _, value := range [][]byte{password, salt, secret, data} {
+		binary.LittleEndian.PutUint32(word[:], uint32(len(value)))
+		b.Write(word[:])
+		b.Write(value)
+	}
+	b.Sum(h0[:0])
+
+	return h0
+}
+
+// argon2Hash is the variable length hash H' of the RFC.
+func argon2Hash(out, in []byte) {
+	var word [4]byte
+	binary.LittleEndian.PutUint32(word[:], uint32(len(out)))
+
+	if len(out) <= blake2bSize {
+		b := newBlake2b(len(out))
+		b.Write(word[:])
+		b.Write(in)
+		b.Sum(out[:0])
+		return
+	}
+
+	b := newBlake2b(blake2bSize)
+	b.Write(word[:])
+	b.Write(in)
+	v := b.Sum(nil)
+
+	rest := out
+	for len(rest) > blake2bSize {
+		copy(rest, v[:32])
+		rest = rest[32:]
+		b = newBlake2b(blake2bSize)
+		if len(rest) <= blake2bSize {
+			b = newBlake2b(len(rest))
+		}
+		b.Write(v)
+		v = b.Sum(nil)
+	}
+	copy(rest, v)
+}
+
+func argon2InitBlocks(h0 *[blake2bSize + 8]byte, memory, lanes uint32) []argon2Block {
+	var raw [argon2BlockWords * 8]byte
+	blocks := make([]argon2Block, memory)
+	for lane := uint32(0); lane < lanes; lane++ {
+		start := lane * (memory / lanes)
+		binary.LittleEndian.PutUint32(h0[blake2bSize+4:], lane)
+		for i := uint32(0); i < 2; i++ {
+			binary.LittleEndian.PutUint32(h0[blake2bSize:], i)
+			argon2Hash(raw[:], h0[:])
+			for j := range blocks[start+i] {
+				blocks[start+i][j] = binary.LittleEndian.Uint64(raw[j*8:])
+			}
+		}
+	}
+
+	return blocks
+}
+
+func argon2Fill(blocks []argon2Block, time, memory, lanes uint32) {
+	laneLength := memory / lanes
+	segmentLength := laneLength / argon2SyncPoints
+
+	fillSegment := func(pass, slice, lane uint32, wg *sync.WaitGroup) {
+		defer wg.Done()
+
+		// Argon2id picks the reference blocks independent of the password
+		// in the first half of the first pass, like Argon2i
+		independent := pass == 0 && slice < argon2SyncPoints/2
+		var addresses, input, zero argon2Block
+		if independent {
+			input[0] = uint64(pass)
+			input[1] = uint64(lane)
+			input[2] = uint64(slice)
+			input[3] = uint64(memory)
+			input[4] = uint64(time)
+			input[5] = argon2idType
+		}
+
+		index := uint32(0)
+		if pass == 0 && slice == 0 {
+			// The first two blocks of a lane come from the initial hash
+			index = 2
+			if independent {
+				input[6]++
+				argon2Compress(&addresses, &input, &zero, false)
+				argon2Compress(&addresses, &addresses, &zero, false)
+			}
+		}
+
+		offset := lane*laneLength + slice*segmentLength + index
+		for ; index < segmentLength; index, offset = index+1, offset+1 {
+			prev := offset - 1
+			if index == 0 && slice == 0 {
+				prev += laneLength
+			}
+
+			var random uint64
+			if independent {
+				if index%argon2BlockWords == 0 {
+					input[6]++
+					argon2Compress(&addresses, &input, &zero, false)
+					argon2Compress(&addresses, &addresses, &zero, false)
+				}
+				random = addresses[index%argon2BlockWords]
+			} else {
+				random = blocks[prev][0]
+			}
+
+			ref := argon2Reference(random, laneLength, segmentLength, lanes, pass, slice, lane, index)
+			argon2Compress(&blocks[offset], &blocks[prev], &blocks[ref], true)
+		}
+	}
+
+	for pass := uint32(0); pass < time; pass++ {
+		for slice := uint32(0); slice < argon2SyncPoints; slice++ {
+			var wg sync.WaitGroup
+			for lane := uint32(0); lane < lanes; lane++ {
+				wg.Add(1)
+				go fillSegment(pass, slice, lane, &wg)
+			}
+			wg.Wait()
+		}
+	}
+}
+
+// argon2Reference maps the pseudo random value to the block the current
+// one is computed from.
+func argon2Reference(random uint64, laneLength, segmentLength, lanes, pass, slice, lane, index uint32) uint32 {
+	refLane := uint32(random>>32) % lanes
+	if pass == 0 && slice == 0 {
+		refLane = lane
+	}
+
+	area, start := 3*segmentLength, ((slice+1)%argon2SyncPoints)*segmentLength
+	if lane == refLane {
+		area += index
+	}
+	if pass == 0 {
+		area, start = slice*segmentLength, 0
+		if slice == 0 || lane == refLane {
+			area += index
+		}
+	}
+	if index == 0 || lane == refLane {
+		area--
+	}
+
+	x := random & 0xffffffff
+	x = (x * x) >> 32
+	x = (x * uint64(area)) >> 32
+	position := (uint64(start) + uint64(area) - (x + 1)) % uint64(laneLength)
+
+	return refLane*laneLength + uint32(position)
+}
+
+func argon2Extract(blocks []argon2Block, memory, lanes, keyLen uint32) []byte {
+	laneLength := memory / lanes
+	last := &blocks[memory-1]
+	for lane := uint32(0); lane < lanes-1; lane++ {
+		for i, v := range blocks[lane*laneLength+laneLength-1] {
+			last[i] ^= v
+		}
+	}
+
+	var raw [argon2BlockWords * 8]byte
+	for i, v := range last {
+		binary.LittleEndian.PutUint64(raw[i*8:], v)
+	}
+	key := make([]byte, keyLen)
+	argon2Hash(key, raw[:])
+
+	return key
+}
+
+// argon2Compress is the compression function G of x and y, XORed into out
+// instead of replacing it with xor set.
+func argon2Compress(out, x, y *argon2Block, xor bool) {
+	var r, t argon2Block
+	for i := range r {
+		r[i] = x[i] ^ y[i]
+	}
+	t = r
+
+	for i := 0; i < argon2BlockWords; i += 16 {
+		blamka(&t[i], &t[i+1], &t[i+2], &t[i+3], &t[i+4], &t[i+5], &t[i+6], &t[i+7],
+			&t[i+8], &t[i+9], &t[i+10], &t[i+11], &t[i+12], &t[i+13], &t[i+14], &t[i+15])
+	}
+	for i := 0; i < 16; i += 2 {
+		blamka(&t[i], &t[i+1], &t[16+i], &t[16+i+1], &t[32+i], &t[32+i+1], &t[48+i], &t[48+i+1],
+			&t[64+i], &t[64+i+1], &t[80+i], &t[80+i+1], &t[96+i], &t[96+i+1], &t[112+i], &t[112+i+1])
+	}
+
+	for i := range t {
+		if xor {
+			out[i] ^= r[i] ^ t[i]
+		} else {
+			out[i] = r[i] ^ t[i]
+		}
+	}
+}
+
+// blamka is the round of BLAKE2b with the multiplications of Argon2, on a
+// 4x4 matrix of words.
+func blamka(v0, v1, v2, v3, v4, v5, v6, v7, v8, v9, v10, v11, v12, v13, v14, v15 *uint64) {
+	g := func(a, b, c, d *uint64) {
+		*a += *b + 2*uint64(uint32(*a))*uint64(uint32(*b))
+		*d ^= *a
+		*d = *d>>32 | *d<<32
+		*c += *d + 2*uint64(uint32(*c))*uint64(uint32(*d))
+		*b ^= *c
+		*b = *b>>24 | *b<<40
+		*a += *b + 2*uint64(uint32(*a))*uint64(uint32(*b))
+		*d ^= *a
+		*d = *d>>16 | *d<<48
+		*c += *d + 2*uint64(uint32(*c))*uint64(uint32(*d))
+		*b ^= *c
+		*b = *b>>63 | *b<<1
+	}
+
+	g(v0, v4, v8, v12)
+	g(v1, v5, v9, v13)
+	g(v2, v6, v10, v14)
+	g(v3, v7, v11, v15)
+	g(v0, v5, v10, v15)
+	g(v1, v6, v11, v12)
+	g(v2, v7, v8, v13)
+	g(v3, v4, v9, v14)
+}
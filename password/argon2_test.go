@@ -0,0 +1,154 @@
+package password
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+)
+
+func TestArgon2id(t *testing.T) {
+	tests := []struct {
+		name     string
+		password []byte
+		salt     []byte
+		secret   []byte
+		data     []byte
+		time     uint32
+		memory   uint32
+		threads  uint8
+		hash     string
+	}{
+		{
+			// RFC 9106, section 5.3
+			name:     "rfc 9106",
+			password: bytes.Repeat([]byte{0x01}, 32),
+			salt:     bytes.Repeat([]byte{0x02}, 16),
+			secret:   bytes.Repeat([]byte{0x03}, 8),
+			data:     bytes.Repeat([]byte{0x04}, 12),
+			time:     3, memory: 32, threads: 4,
+			hash: "0d640df58d78766c08c037a34a8b53c9d01ef0452d75b65eb52520e96b01e659",
+		},
+		{name: "t=1 m=64 p=1", time: 1, memory: 64, threads: 1, hash: "655ad15eac652dc59f7170a7332bf49b8469be1fdb9c28bb"},
+		{name: "t=2 m=64 p=1", time: 2, memory: 64, threads: 1, hash: "068d62b26455936aa6ebe60060b0a65870dbfa3ddf8d41f7"},
+		{name: "t=2 m=64 p=2", time: 2, memory: 64, threads: 2, hash: "350ac37222f436ccb5c0972f1ebd3bf6b958bf2071841362"},
+		{name: "t=3 m=256 p=2", time: 3, memory: 256, threads: 2, hash: "4668d30ac4187e6878eedeacf0fd83c5a0a30db2cc16ef0b"},
+		{name: "t=4 m=4096 p=4", time: 4, memory: 4096, threads: 4, hash: "145db9733a9f4ee43edf33c509be96b934d505a4efb33c5a"},
+		{name: "t=4 m=1024 p=8", time: 4, memory: 1024, threads: 8, hash: "8dafa8e004f8ea96bf7c0f93eecf67a6047476143d15577f"},
+		{name: "t=2 m=64 p=3", time: 2, memory: 64, threads: 3, hash: "4a15b31aec7c2590b87d1f520be7d96f56658172deaa3079"},
+		{name: "t=3 m=1024 p=6", time: 3, memory: 1024, threads: 6, hash: "1640b932f4b60e272f5d2207b9a9c626ffa1bd88d2349016"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			password, salt := test.password, test.salt
+			if password == nil {
+				password, salt = []byte("password"), []byte("somesalt")
+			}
+			want, _ := hex.DecodeString(test.hash)
+
+			got := argon2id(password, salt, test.secret, test.data, test.time, test.memory, test.threads, uint32(len(want)))
+			if !bytes.Equal(got, want) {
+				t.Errorf("argon2id() = %x, want %s", got, test.hash)
+			}
+		})
+	}
+}
+
+func TestArgon2idVerify(t *testing.T) {
+	key, _ := hex.DecodeString("068d62b26455936aa6ebe60060b0a65870dbfa3ddf8d41f7")
+	salt := base64.RawStdEncoding.EncodeToString([]byte("somesalt"))
+	encoded := "$argon2id$v=19$m=64,t=2,p=1$" + salt + "$" + base64.RawStdEncoding.EncodeToString(key)
+
+	tests := []struct {
+		name     string
+		password string
+		encoded  string
+		ok       bool
+		err      error
+	}{
+		{name: "match", password: "password", encoded: encoded, ok: true},
+		{name: "mismatch", password: "Password", encoded: encoded},
+		{name: "other algorithm", password: "password", encoded: "$bcrypt$v=19$m=64,t=2,p=1$" + salt + "$AAAAAA", err: ErrMalformed},
+		{name: "missing field", password: "password", encoded: "$argon2id$v=19$m=64,t=2,p=1$" + salt, err: ErrMalformed},
+		{name: "bad parameters", password: "password", encoded: "$argon2id$v=19$m=64$" + salt + "$AAAAAA", err: ErrMalformed},
+		{name: "zero time", password: "password", encoded: "$argon2id$v=19$m=64,t=0,p=1$" + salt + "$AAAAAA", err: ErrMalformed},
+		{name: "bad salt", password: "password", encoded: "$argon2id$v=19$m=64,t=2,p=1$!!$AAAAAA", err: ErrMalformed},
+		{name: "short key", password: "password", encoded: "$argon2id$v=19$m=64,t=2,p=1$" + salt + "$AAA", err: ErrMalformed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ok, err := Argon2id{}.Verify(test.password, test.encoded)
+			if err != test.err {
+				t.Fatalf("Verify() error = %v, want %v", err, test.err)
+			}
+			if ok != test.ok {
+				t.Errorf("Verify() = %v, want %v", ok, test.ok)
+			}
+		})
+	}
+
+	if _, err := (Argon2id{}).Verify("password", "$argon2id$v=16$m=64,t=2,p=1$"+salt+"$AAAAAA"); err == nil {
+		t.Error("Verify() of argon2 version 16 succeeded")
+	}
+}
+
+func TestHashRoundTrip(t *testing.T) {
+	hasher := Argon2id{Memory: 64, Time: 1}
+	encoded, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		ok       bool
+	}{
+		{"correct horse", true},
+		{"correct horse ", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		ok, err := Verify(test.password, encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != test.ok {
+			t.Errorf("Verify(%q) = %v, want %v", test.password, ok, test.ok)
+		}
+	}
+
+	if _, err := Verify("password", "$scrypt$ln=16,r=8,p=1$c2FsdA$aGFzaA"); err != ErrUnknownAlgorithm {
+		t.Errorf("Verify() of scrypt error = %v, want %v", err, ErrUnknownAlgorithm)
+	}
+	if _, err := Verify("password", "plain"); err != ErrMalformed {
+		t.Errorf("Verify() of plain error = %v, want %v", err, ErrMalformed)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	encoded, err := Argon2id{Memory: 64, Time: 1}.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		hasher  Hasher
+		encoded string
+		want    bool
+	}{
+		{name: "same parameters", hasher: Argon2id{Memory: 64, Time: 1}, encoded: encoded},
+		{name: "more memory", hasher: Argon2id{Memory: 128, Time: 1}, encoded: encoded, want: true},
+		{name: "defaults", hasher: Argon2id{}, encoded: encoded, want: true},
+		{name: "malformed", hasher: Argon2id{}, encoded: "plain", want: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := NeedsRehash(test.hasher, test.encoded); got != test.want {
+				t.Errorf("NeedsRehash() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
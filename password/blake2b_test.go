@@ -0,0 +1,70 @@
+package password
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestBlake2b(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		input string
+		hash  string
+	}{
+		{
+			name: "empty", size: 64, input: "",
+			hash: "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce",
+		},
+		{
+			// RFC 7693, appendix A
+			name: "abc", size: 64, input: "abc",
+			hash: "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923",
+		},
+		{
+			name: "fox", size: 64, input: "The quick brown fox jumps over the lazy dog",
+			hash: "a8add4bdddfd93e4877d2746e62817b116364a1fa7bc148d95090bc7333b3673f82401cf7aa2e4cb1ecd90296e3f14cb5413f8ed77be73045b13914cdcd6a918",
+		},
+		{
+			name: "256 bit", size: 32, input: "",
+			hash: "0e5751c026e543b2e8ab2eb06099daa1d1e5df47778f7787faab45cdf12fe3a8",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newBlake2b(test.size)
+			d.Write([]byte(test.input))
+			if got := hex.EncodeToString(d.Sum(nil)); got != test.hash {
+				t.Errorf("Sum() = %s, want %s", got, test.hash)
+			}
+		})
+	}
+}
+
+// TestBlake2bBlocks writes inputs around the block size in pieces, the
+// result must not depend on how they're split.
+func TestBlake2bBlocks(t *testing.T) {
+	for _, length := range []int{127, 128, 129, 256, 257} {
+		input := []byte(strings.Repeat("x", length))
+		whole := newBlake2b(64)
+		whole.Write(input)
+		want := whole.Sum(nil)
+
+		for _, split := range []int{1, 64, 128} {
+			d := newBlake2b(64)
+			for rest := input; len(rest) > 0; {
+				n := split
+				if n > len(rest) {
+					n = len(rest)
+				}
+				d.Write(rest[:n])
+				rest = rest[n:]
+			}
+			if got := d.Sum(nil); hex.EncodeToString(got) != hex.EncodeToString(want) {
+				t.Errorf("%d bytes in pieces of %d: Sum() = %x, want %x", length, split, got, want)
+			}
+		}
+	}
+}
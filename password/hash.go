@@ -0,0 +1,189 @@
+// Package password hashes passwords for storage and verifies them. Hashes
+// are encoded in the PHC string format, like
+// "$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>", so the algorithm and its
+// parameters can change without invalidating the stored ones.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Hasher hashes passwords with one algorithm.
+type Hasher interface {
+	// ID is the algorithm in the encoded hashes, like "argon2id".
+	ID() string
+	Hash(password string) (string, error)
+	// Verify reports whether password matches an encoded hash of this
+	// algorithm.
+	Verify(password, encoded string) (bool, error)
+}
+
+// ErrUnknownAlgorithm is returned for hashes of an algorithm that isn't
+// registered.
+var ErrUnknownAlgorithm = errors.New("password: unknown algorithm")
+
+// ErrMalformed is returned for hashes that can't be decoded.
+var ErrMalformed = errors.New("password: malformed hash")
+
+var (
+	hashersMu sync.RWMutex
+	hashers   = map[string]Hasher{}
+)
+
+func init() {
+	Register(Argon2id{})
+}
+
+// Register makes a hasher available to Verify and Lookup, replacing the one
+// with the same ID.
+func Register(h Hasher) {
+	hashersMu.Lock()
+	defer hashersMu.Unlock()
+	hashers[h.ID()] = h
+}
+
+// Lookup returns the hasher registered for id.
+func Lookup(id string) (Hasher, error) {
+	hashersMu.RLock()
+	defer hashersMu.RUnlock()
+	h, ok := hashers[id]
+	if !ok {
+		return nil, ErrUnknownAlgorithm
+	}
+
+	return h, nil
+}
+
+// Verify checks password against an encoded hash of any registered
+// algorithm.
+func Verify(password, encoded string) (bool, error) {
+	id, err := algorithm(encoded)
+	if err != nil {
+		return false, err
+	}
+	h, err := Lookup(id)
+	if err != nil {
+		return false, err
+	}
+
+	return h.Verify(password, encoded)
+}
+
+// NeedsRehash reports whether encoded was hashed by another algorithm or
+// with other parameters than h would use now.
+func NeedsRehash(h Hasher, encoded string) bool {
+	if a, ok := h.(Argon2id); ok {
+		params, _, _, err := decodeArgon2id(encoded)
+		return err != nil || params != a.withDefaults()
+	}
+
+	id, err := algorithm(encoded)
+	return err != nil || id != h.ID()
+}
+
+func algorithm(encoded string) (string, error) {
+	fields := strings.Split(encoded, "$")
+	if len(fields) < 3 || fields[0] != "" {
+		return "", ErrMalformed
+	}
+
+	return fields[1], nil
+}
+
+// Argon2id hashes with argon2id. Zero fields are the parameters OWASP
+// recommends: 19 MiB of memory, two passes and one thread.
+type Argon2id struct {
+	// Memory in KiB
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+func (a Argon2id) withDefaults() Argon2id {
+	if a.Memory == 0 {
+		a.Memory = 19 * 1024
+	}
+	if a.Time == 0 {
+		a.Time = 2
+	}
+	if a.Threads == 0 {
+		a.Threads = 1
+	}
+	if a.SaltLen == 0 {
+		a.SaltLen = 16
+	}
+	if a.KeyLen == 0 {
+		a.KeyLen = 32
+	}
+
+	return a
+}
+
+func (Argon2id) ID() string {
+	return "argon2id"
+}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	a = a.withDefaults()
+	salt := make([]byte, a.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2id([]byte(password), salt, nil, nil, a.Time, a.Memory, a.Threads, a.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2Version, a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify uses the parameters of the encoded hash, not the ones of a.
+func (Argon2id) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2id([]byte(password), salt, nil, nil, params.Time, params.Memory, params.Threads, params.KeyLen)
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func decodeArgon2id(encoded string) (Argon2id, []byte, []byte, error) {
+	var params Argon2id
+	fields := strings.Split(encoded, "$")
+	if len(fields) != 6 || fields[0] != "" || fields[1] != "argon2id" {
+		return params, nil, nil, ErrMalformed
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(fields[2], "v=%d", &version); err != nil {
+		return params, nil, nil, ErrMalformed
+	}
+	if version != argon2Version {
+		return params, nil, nil, fmt.Errorf("password: unsupported argon2 version %d", version)
+	}
+	if _, err := fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, ErrMalformed
+	}
+	if params.Time == 0 || params.Threads == 0 {
+		return params, nil, nil, ErrMalformed
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(fields[4])
+	if err != nil {
+		return params, nil, nil, ErrMalformed
+	}
+	key, err := base64.RawStdEncoding.DecodeString(fields[5])
+	if err != nil || len(key) < 4 {
+		return params, nil, nil, ErrMalformed
+	}
+	params.SaltLen, params.KeyLen = uint32(len(salt)), uint32(len(key))
+
+	return params, salt, key, nil
+}
@@ -0,0 +1,1106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+// The permissions an account can be granted on a single todo, write
+// includes read. The owner of a todo is kept in the todo itself, it may do
+// everything and is the only one who may delete the todo and change its
+// grants.
+const (
+	permissionRead  = "read"
+	permissionWrite = "write"
+	permissionOwner = "owner"
+)
+
+const (
+	// permissionsKey caches the grants of the signed in account for the
+	// rest of the request, see permissionsOf
+	permissionsKey = "permissions"
+	// todosKey caches the database as the signed in account sees it, see
+	// todosOf
+	todosKey = "todos"
+)
+
+var (
+	// errNoPermission is returned for todos the account may see but not
+	// change
+	errNoPermission = errors.New("no permission to change this todo")
+	// errNotGranted is returned for todos that aren't shared with the
+	// account, it doesn't tell whether they exist
+	errNotGranted = errors.New("todo not shared with this account")
+	// errNotOwner is returned for what only the owner of a todo may do
+	errNotOwner = errors.New("only the owner of this todo may do this")
+)
+
+// grantsMu serializes the changes to the grants, the grants of a todo and
+// the index of an account are read, changed and written back together.
+var grantsMu sync.Mutex
+
+// todoGrants maps the accounts a todo is shared with to their permission.
+type todoGrants map[string]string
+
+// accountGrants maps the ids of the todos shared with an account to its
+// permission, the index for the lookups of the account.
+type accountGrants map[string]string
+
+// allows reports whether the account may do what permission needs with the
+// todo with id as one it is shared with.
+func (grants accountGrants) allows(id, permission string) bool {
+	switch granted := grants[id]; granted {
+	case permissionWrite:
+		return permission != permissionOwner
+	default:
+		return granted != "" && granted == permission
+	}
+}
+
+// access tells whether account, with the todos shared with it in grants,
+// may do what permission needs with todo. Todos without an owner are open to
+// everyone, like all todos before they could be shared. Owned todos are open
+// to their owner and the accounts they are shared with, account is "" for
+// requests that aren't signed in. The todos the account may not read are
+// tododb.ErrNotFound, as if they didn't exist.
+func (grants accountGrants) access(account string, todo tododb.Todo, permission string) error {
+	switch {
+	case todo.Owner == "" || todo.Owner == account:
+		return nil
+	case grants[todo.ID] == "":
+		return tododb.ErrNotFound
+	case permission == permissionOwner:
+		return errNotOwner
+	case !grants.allows(todo.ID, permission):
+		return errNoPermission
+	}
+
+	return nil
+}
+
+type grantInfo struct {
+	Account    string `json:"account"`
+	Permission string `json:"permission"`
+}
+
+// sharedTodo is a todo as seen by an account it is shared with.
+type sharedTodo struct {
+	tododb.Todo
+	Permission string `json:"permission"`
+}
+
+func todoGrantsKey(id string) string {
+	return "grants:todo:" + id
+}
+
+func accountGrantsKey(account string) string {
+	return "grants:account:" + account
+}
+
+// loadGrants reads the map of grants under key, a missing key is an empty
+// map.
+func loadGrants(key string) (map[string]string, error) {
+	grants := map[string]string{}
+	value, err := tododb.KVOf(database).GetValue(key)
+	if err == tododb.ErrNotFound {
+		return grants, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return grants, json.Unmarshal([]byte(value), &grants)
+}
+
+// saveGrants writes grants under key, an empty map removes it.
+func saveGrants(key string, grants map[string]string) error {
+	kv := tododb.KVOf(database)
+	if len(grants) == 0 {
+		return kv.DeleteValue(key)
+	}
+
+	value, err := json.Marshal(grants)
+	if err != nil {
+		return err
+	}
+
+	return kv.SetValue(key, string(value), 0)
+}
+
+func loadTodoGrants(id string) (todoGrants, error) {
+	return loadGrants(todoGrantsKey(id))
+}
+
+func loadAccountGrants(account string) (accountGrants, error) {
+	return loadGrants(accountGrantsKey(account))
+}
+
+// setGrant gives account permission on the todo with id, an empty
+// permission takes it away.
+func setGrant(id, account, permission string) error {
+	grantsMu.Lock()
+	defer grantsMu.Unlock()
+
+	grants, err := loadTodoGrants(id)
+	if err != nil {
+		return err
+	}
+
+	return putGrant(grants, id, account, permission)
+}
+
+// claimTodo makes account the owner of todo unless another account owns it
+// already, then it returns errNotOwner.
+func claimTodo(ctx context.Context, todo tododb.Todo, account string) error {
+	switch todo.Owner {
+	case account:
+		return nil
+	case "":
+	default:
+		return errNotOwner
+	}
+
+	claimed := false
+	err := database.UpdateTodo(ctx, todo.ID, func(stored *tododb.Todo) {
+		if claimed = stored.Owner == "" || stored.Owner == account; claimed {
+			stored.Owner = account
+		}
+	})
+	if err == nil && !claimed {
+		return errNotOwner
+	}
+
+	return err
+}
+
+// putGrant changes the grant of account in grants, the grants of the todo
+// with id, and writes them back with the index of the account.
+func putGrant(grants todoGrants, id, account, permission string) error {
+	index, err := loadAccountGrants(account)
+	if err != nil {
+		return err
+	}
+
+	if permission == "" {
+		delete(grants, account)
+		delete(index, id)
+	} else {
+		grants[account] = permission
+		index[id] = permission
+	}
+
+	if err := saveGrants(todoGrantsKey(id), grants); err != nil {
+		return err
+	}
+
+	return saveGrants(accountGrantsKey(account), index)
+}
+
+// dropGrants takes away all grants of a todo that is gone and returns them,
+// restoreGrants gives them back if the todo comes back.
+func dropGrants(id string) (todoGrants, error) {
+	grantsMu.Lock()
+	defer grantsMu.Unlock()
+
+	grants, err := loadTodoGrants(id)
+	if err != nil || len(grants) == 0 {
+		return nil, err
+	}
+
+	for account := range grants {
+		index, err := loadAccountGrants(account)
+		if err != nil {
+			return nil, err
+		}
+		delete(index, id)
+		if err := saveGrants(accountGrantsKey(account), index); err != nil {
+			return nil, err
+		}
+	}
+
+	return grants, tododb.KVOf(database).DeleteValue(todoGrantsKey(id))
+}
+
+// takeGrants drops the grants of todos that were just deleted and returns
+// them by the id of the todo, for the trash and the undo log. The todos are
+// gone already, a failure is logged and only loses the grants.
+func takeGrants(todos ...tododb.Todo) map[string]map[string]string {
+	taken := map[string]map[string]string{}
+	for _, todo := range todos {
+		grants, err := dropGrants(todo.ID)
+		if err != nil {
+			logger.Errorf("%v", err)
+		}
+		if len(grants) > 0 {
+			taken[todo.ID] = grants
+		}
+	}
+	if len(taken) == 0 {
+		return nil
+	}
+
+	return taken
+}
+
+// restoreGrants gives the grants dropGrants took back to the todo with id,
+// once it is restored.
+func restoreGrants(id string, grants map[string]string) error {
+	grantsMu.Lock()
+	defer grantsMu.Unlock()
+
+	current, err := loadTodoGrants(id)
+	if err != nil {
+		return err
+	}
+	for account, permission := range grants {
+		if err := putGrant(current, id, account, permission); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// claimTodos makes the signed in account the owner of todos it is about to
+// create, requests that aren't signed in create todos without an owner. The
+// owner is set before the todos are saved, so the changes that announce them
+// already tell who may see them.
+func claimTodos(c *gin.Context, todos []tododb.Todo) {
+	for i := range todos {
+		todos[i].Owner = c.GetString(accountKey)
+	}
+}
+
+// permissionsOf returns the grants of the signed in account. They are read
+// once per request, every further check of the request uses the same ones.
+func permissionsOf(c *gin.Context) (accountGrants, error) {
+	if cached, exists := c.Get(permissionsKey); exists {
+		return cached.(accountGrants), nil
+	}
+
+	grants := accountGrants{}
+	if account := c.GetString(accountKey); account != "" {
+		var err error
+		if grants, err = loadAccountGrants(account); err != nil {
+			return nil, err
+		}
+	}
+	c.Set(permissionsKey, grants)
+
+	return grants, nil
+}
+
+// readerOf returns the filter of the todos the signed in account may read.
+func readerOf(c *gin.Context) (tododb.TodoFilter, error) {
+	grants, err := permissionsOf(c)
+	if err != nil {
+		return tododb.TodoFilter{}, err
+	}
+
+	return tododb.TodoFilter{ReadableBy: &tododb.Reader{Account: c.GetString(accountKey), Shared: grants}}, nil
+}
+
+// todosOf returns the database as the signed in account sees it, the
+// handlers read and change the todos through it. Background jobs, which
+// don't act for an account, use the database itself.
+func todosOf(c *gin.Context) tododb.TodoDB {
+	if cached, exists := c.Get(todosKey); exists {
+		return cached.(tododb.TodoDB)
+	}
+
+	todos := accountTodos{
+		TodoDB:  database,
+		account: c.GetString(accountKey),
+		grants: func() (accountGrants, error) {
+			return permissionsOf(c)
+		},
+	}
+	c.Set(todosKey, todos)
+
+	return todos
+}
+
+// publicTodos returns the database as the requests that aren't signed in see
+// it, for the pages anyone may see whoever asks for them.
+func publicTodos() tododb.TodoDB {
+	return accountTodos{
+		TodoDB: database,
+		grants: func() (accountGrants, error) {
+			return accountGrants{}, nil
+		},
+	}
+}
+
+// accountTodos is the database as an account sees it. Reads skip the todos
+// the account may not read, and those are tododb.ErrNotFound for the calls
+// with an id. Changes of todos the account may only read return
+// errNoPermission, deletes of todos it doesn't own errNotOwner. Saved todos
+// belong to the account, the owner of a stored todo doesn't change through
+// it.
+type accountTodos struct {
+	tododb.TodoDB
+	account string
+	grants  func() (accountGrants, error)
+}
+
+// reader appends the filter of the readable todos to filters, the slice of
+// the caller is left as it is.
+func (todos accountTodos) reader(filters []tododb.TodoFilter) ([]tododb.TodoFilter, error) {
+	grants, err := todos.grants()
+	if err != nil {
+		return nil, err
+	}
+
+	reader := tododb.TodoFilter{ReadableBy: &tododb.Reader{Account: todos.account, Shared: grants}}
+	return append(append([]tododb.TodoFilter{}, filters...), reader), nil
+}
+
+// readable keeps the todos the account may read, in place.
+func (todos accountTodos) readable(list []tododb.Todo, err error) ([]tododb.Todo, error) {
+	if err != nil {
+		return nil, err
+	}
+	filters, err := todos.reader(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := list[:0]
+	for _, todo := range list {
+		if filters[0].Matches(todo) {
+			kept = append(kept, todo)
+		}
+	}
+
+	return kept, nil
+}
+
+// check returns the first refusal of permission on the todos with ids, in
+// one pass over the todos. Ids without a todo are left to the backend.
+func (todos accountTodos) check(ctx context.Context, permission string, ids ...string) error {
+	grants, err := todos.grants()
+	if err != nil {
+		return err
+	}
+
+	wanted := map[string]bool{}
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	err = todos.TodoDB.ForEachTodo(ctx, func(todo tododb.Todo) error {
+		if !wanted[todo.ID] {
+			return nil
+		}
+		delete(wanted, todo.ID)
+		if err := grants.access(todos.account, todo, permission); err != nil {
+			return err
+		}
+		if len(wanted) == 0 {
+			return errTodoFound
+		}
+		return nil
+	})
+	if err == errTodoFound {
+		return nil
+	}
+
+	return err
+}
+
+// own makes the account the owner of the todos to save, it refuses todos of
+// other accounts.
+func (todos accountTodos) own(list []tododb.Todo) ([]tododb.Todo, error) {
+	owned := make([]tododb.Todo, len(list))
+	for i, todo := range list {
+		if todo.Owner != "" && todo.Owner != todos.account {
+			return nil, errNotOwner
+		}
+		todo.Owner = todos.account
+		owned[i] = todo
+	}
+
+	return owned, nil
+}
+
+func (todos accountTodos) GetAllTodos(ctx context.Context, filters ...tododb.TodoFilter) ([]tododb.Todo, error) {
+	filters, err := todos.reader(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	return todos.TodoDB.GetAllTodos(ctx, filters...)
+}
+
+func (todos accountTodos) GetTodos(ctx context.Context, offset, limit int, filters ...tododb.TodoFilter) ([]tododb.Todo, error) {
+	filters, err := todos.reader(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	return todos.TodoDB.GetTodos(ctx, offset, limit, filters...)
+}
+
+func (todos accountTodos) CountTodos(ctx context.Context, filters ...tododb.TodoFilter) (int, error) {
+	filters, err := todos.reader(filters)
+	if err != nil {
+		return 0, err
+	}
+
+	return todos.TodoDB.CountTodos(ctx, filters...)
+}
+
+func (todos accountTodos) ForEachTodo(ctx context.Context, fn func(tododb.Todo) error) error {
+	filters, err := todos.reader(nil)
+	if err != nil {
+		return err
+	}
+
+	return todos.TodoDB.ForEachTodo(ctx, func(todo tododb.Todo) error {
+		if !filters[0].Matches(todo) {
+			return nil
+		}
+		return fn(todo)
+	})
+}
+
+func (todos accountTodos) GetOverdueTodos(ctx context.Context) ([]tododb.Todo, error) {
+	return todos.readable(todos.TodoDB.GetOverdueTodos(ctx))
+}
+
+func (todos accountTodos) GetTodosDueBefore(ctx context.Context, t time.Time) ([]tododb.Todo, error) {
+	return todos.readable(todos.TodoDB.GetTodosDueBefore(ctx, t))
+}
+
+func (todos accountTodos) GetTodosByPriority(ctx context.Context, filters ...tododb.TodoFilter) ([]tododb.Todo, error) {
+	filters, err := todos.reader(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	return todos.TodoDB.GetTodosByPriority(ctx, filters...)
+}
+
+func (todos accountTodos) GetTodosByTag(ctx context.Context, tag string) ([]tododb.Todo, error) {
+	return todos.readable(todos.TodoDB.GetTodosByTag(ctx, tag))
+}
+
+// ListTags counts the readable todos only, the index of the backend counts
+// them all.
+func (todos accountTodos) ListTags(ctx context.Context) ([]tododb.Tag, error) {
+	return tododb.CountTags(ctx, todos.ForEachTodo)
+}
+
+func (todos accountTodos) SearchTodos(ctx context.Context, query string) ([]tododb.Todo, error) {
+	return todos.readable(todos.TodoDB.SearchTodos(ctx, query))
+}
+
+func (todos accountTodos) GetSubTasks(ctx context.Context, id string) ([]tododb.SubTask, error) {
+	if err := todos.check(ctx, permissionRead, id); err != nil {
+		return nil, err
+	}
+
+	return todos.TodoDB.GetSubTasks(ctx, id)
+}
+
+func (todos accountTodos) SaveTodo(ctx context.Context, todo tododb.Todo) error {
+	owned, err := todos.own([]tododb.Todo{todo})
+	if err != nil {
+		return err
+	}
+
+	return todos.TodoDB.SaveTodo(ctx, owned[0])
+}
+
+func (todos accountTodos) SaveTodos(ctx context.Context, list []tododb.Todo) error {
+	owned, err := todos.own(list)
+	if err != nil {
+		return err
+	}
+
+	return todos.TodoDB.SaveTodos(ctx, owned)
+}
+
+func (todos accountTodos) DeleteTodo(ctx context.Context, id string) error {
+	if err := todos.check(ctx, permissionOwner, id); err != nil {
+		return err
+	}
+
+	return todos.TodoDB.DeleteTodo(ctx, id)
+}
+
+func (todos accountTodos) DeleteTodos(ctx context.Context, ids []string) error {
+	if err := todos.check(ctx, permissionOwner, ids...); err != nil {
+		return err
+	}
+
+	return todos.TodoDB.DeleteTodos(ctx, ids)
+}
+
+// UpdateTodo keeps the owner of the todo, whatever update does.
+func (todos accountTodos) UpdateTodo(ctx context.Context, id string, update func(*tododb.Todo)) error {
+	if err := todos.check(ctx, permissionWrite, id); err != nil {
+		return err
+	}
+
+	return todos.TodoDB.UpdateTodo(ctx, id, keepOwner(update))
+}
+
+func (todos accountTodos) UpdateTodos(ctx context.Context, ids []string, update func(*tododb.Todo)) error {
+	if err := todos.check(ctx, permissionWrite, ids...); err != nil {
+		return err
+	}
+
+	return todos.TodoDB.UpdateTodos(ctx, ids, keepOwner(update))
+}
+
+// GetUsage counts the todos owned by the account, not the ones shared with
+// it.
+func (todos accountTodos) GetUsage(ctx context.Context) (tododb.Usage, error) {
+	return tododb.AccountUsageOf(ctx, todos.TodoDB, todos.account)
+}
+
+// keepOwner wraps update so that it can't change the owner of a todo.
+func keepOwner(update func(*tododb.Todo)) func(*tododb.Todo) {
+	return func(todo *tododb.Todo) {
+		owner := todo.Owner
+		update(todo)
+		todo.Owner = owner
+	}
+}
+
+func (todos accountTodos) CompleteTodo(ctx context.Context, id string) error {
+	if err := todos.check(ctx, permissionWrite, id); err != nil {
+		return err
+	}
+
+	return todos.TodoDB.CompleteTodo(ctx, id)
+}
+
+func (todos accountTodos) CompleteTodos(ctx context.Context, ids []string) error {
+	if err := todos.check(ctx, permissionWrite, ids...); err != nil {
+		return err
+	}
+
+	return todos.TodoDB.CompleteTodos(ctx, ids)
+}
+
+func (todos accountTodos) ReopenTodo(ctx context.Context, id string) error {
+	if err := todos.check(ctx, permissionWrite, id); err != nil {
+		return err
+	}
+
+	return todos.TodoDB.ReopenTodo(ctx, id)
+}
+
+func (todos accountTodos) SetDescription(ctx context.Context, id string, description string) error {
+	if err := todos.check(ctx, permissionWrite, id); err != nil {
+		return err
+	}
+
+	return todos.TodoDB.SetDescription(ctx, id, description)
+}
+
+func (todos accountTodos) SetDue(ctx context.Context, id string, due *time.Time) error {
+	if err := todos.check(ctx, permissionWrite, id); err != nil {
+		return err
+	}
+
+	return todos.TodoDB.SetDue(ctx, id, due)
+}
+
+func (todos accountTodos) SetPriority(ctx context.Context, id string, priority int) error {
+	if err := todos.check(ctx, permissionWrite, id); err != nil {
+		return err
+	}
+
+	return todos.TodoDB.SetPriority(ctx, id, priority)
+}
+
+func (todos accountTodos) SetTags(ctx context.Context, id string, tags []string) error {
+	if err := todos.check(ctx, permissionWrite, id); err != nil {
+		return err
+	}
+
+	return todos.TodoDB.SetTags(ctx, id, tags)
+}
+
+func (todos accountTodos) SetRecurrence(ctx context.Context, id string, recurrence string) error {
+	if err := todos.check(ctx, permissionWrite, id); err != nil {
+		return err
+	}
+
+	return todos.TodoDB.SetRecurrence(ctx, id, recurrence)
+}
+
+func (todos accountTodos) MoveTodo(ctx context.Context, id string, position int) error {
+	if err := todos.check(ctx, permissionWrite, id); err != nil {
+		return err
+	}
+
+	return todos.TodoDB.MoveTodo(ctx, id, position)
+}
+
+func (todos accountTodos) AddSubTask(ctx context.Context, id string, title string) (tododb.SubTask, error) {
+	if err := todos.check(ctx, permissionWrite, id); err != nil {
+		return tododb.SubTask{}, err
+	}
+
+	return todos.TodoDB.AddSubTask(ctx, id, title)
+}
+
+func (todos accountTodos) CompleteSubTask(ctx context.Context, id string, subTaskID string) error {
+	if err := todos.check(ctx, permissionWrite, id); err != nil {
+		return err
+	}
+
+	return todos.TodoDB.CompleteSubTask(ctx, id, subTaskID)
+}
+
+func (todos accountTodos) AddAttachment(ctx context.Context, id string, attachment tododb.Attachment) error {
+	if err := todos.check(ctx, permissionWrite, id); err != nil {
+		return err
+	}
+
+	return todos.TodoDB.AddAttachment(ctx, id, attachment)
+}
+
+func (todos accountTodos) RemoveAttachment(ctx context.Context, id string, attachmentID string) error {
+	if err := todos.check(ctx, permissionWrite, id); err != nil {
+		return err
+	}
+
+	return todos.TodoDB.RemoveAttachment(ctx, id, attachmentID)
+}
+
+// ReplaceAllTodos only replaces todos the account could delete one by one.
+func (todos accountTodos) ReplaceAllTodos(ctx context.Context, list []tododb.Todo) error {
+	grants, err := todos.grants()
+	if err != nil {
+		return err
+	}
+	err = todos.TodoDB.ForEachTodo(ctx, func(todo tododb.Todo) error {
+		if grants.access(todos.account, todo, permissionOwner) != nil {
+			return errNotOwner
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	owned, err := todos.own(list)
+	if err != nil {
+		return err
+	}
+
+	return todos.TodoDB.ReplaceAllTodos(ctx, owned)
+}
+
+// authorizeTodos checks permission on todos the signed in account read, for
+// the requests that change several of them and shouldn't stop half way. It
+// answers the request itself if one is refused.
+func authorizeTodos(c *gin.Context, permission string, todos ...tododb.Todo) bool {
+	grants, err := permissionsOf(c)
+	if err != nil {
+		answerTodoError(c, "", err)
+		return false
+	}
+
+	for _, todo := range todos {
+		if err := grants.access(c.GetString(accountKey), todo, permission); err != nil {
+			answerTodoError(c, todo.ID, err)
+			return false
+		}
+	}
+
+	return true
+}
+
+// answerTodoError answers the request with the status of an error of
+// todosOf. A todo the account may not read is not found, like one that
+// doesn't exist.
+func answerTodoError(c *gin.Context, id string, err error) {
+	switch err {
+	case tododb.ErrNotFound:
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": fmt.Sprintf("no todo with id %q", id),
+		})
+	case errNoPermission, errNotOwner:
+		c.JSON(http.StatusForbidden, gin.H{
+			"errors": err.Error(),
+		})
+	default:
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+	}
+}
+
+// sharedTodos returns the todos of grants that still exist, in the order of
+// the list.
+func sharedTodos(ctx context.Context, grants accountGrants) ([]sharedTodo, error) {
+	todos := []sharedTodo{}
+	if len(grants) == 0 {
+		return todos, nil
+	}
+
+	err := database.ForEachTodo(ctx, func(todo tododb.Todo) error {
+		if permission := grants[todo.ID]; permission != "" {
+			todos = append(todos, sharedTodo{Todo: todo, Permission: permission})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return todos, nil
+}
+
+// sharedTodoOf returns the todo with id if grants allow permission on it. It
+// returns errNotGranted for todos that aren't shared or gone and
+// errNoPermission if only reading is allowed.
+func sharedTodoOf(ctx context.Context, grants accountGrants, id, permission string) (sharedTodo, error) {
+	if grants[id] == "" {
+		return sharedTodo{}, errNotGranted
+	}
+	if !grants.allows(id, permission) {
+		return sharedTodo{}, errNoPermission
+	}
+
+	todo, found, err := findTodo(ctx, database, func(todo tododb.Todo) bool {
+		return todo.ID == id
+	})
+	if err != nil {
+		return sharedTodo{}, err
+	}
+	if !found {
+		return sharedTodo{}, errNotGranted
+	}
+
+	return sharedTodo{Todo: todo, Permission: grants[id]}, nil
+}
+
+// updateSharedTodo sets the title and completes or reopens the todo with
+// id, if grants allow writing it.
+func updateSharedTodo(ctx context.Context, grants accountGrants, id string, title *string, done *bool) error {
+	if !grants.allows(id, permissionWrite) {
+		if grants[id] == "" {
+			return errNotGranted
+		}
+		return errNoPermission
+	}
+
+	err := database.UpdateTodo(ctx, id, func(todo *tododb.Todo) {
+		if title != nil {
+			todo.Title = *title
+		}
+		if done != nil {
+			todo.Done = *done
+		}
+	})
+	if err == tododb.ErrNotFound {
+		return errNotGranted
+	}
+
+	return err
+}
+
+// answerSharedError answers the request with the status of err.
+func answerSharedError(c *gin.Context, id string, err error) {
+	switch err {
+	case errNotGranted:
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": fmt.Sprintf("no todo with id %q shared with you", id),
+		})
+	case errNoPermission:
+		c.JSON(http.StatusForbidden, gin.H{
+			"errors": err.Error(),
+		})
+	default:
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+	}
+}
+
+// signedInGrants returns the grants of the signed in account, it answers the
+// request itself if there is none or they can't be read.
+func signedInGrants(c *gin.Context) (accountGrants, bool) {
+	if c.GetString(accountKey) == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errors": "sign in with an account, accountAuth is missing in the account middleware",
+		})
+		return nil, false
+	}
+
+	grants, err := permissionsOf(c)
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return nil, false
+	}
+
+	return grants, true
+}
+
+// listSharedTodosHandler answers with the todos shared with the signed in
+// account and its permission on each.
+func listSharedTodosHandler(c *gin.Context) {
+	grants, ok := signedInGrants(c)
+	if !ok {
+		return
+	}
+
+	todos, err := sharedTodos(c.Request.Context(), grants)
+	if err != nil {
+		answerSharedError(c, "", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, todos)
+}
+
+func getSharedTodoHandler(c *gin.Context) {
+	grants, ok := signedInGrants(c)
+	if !ok {
+		return
+	}
+
+	todo, err := sharedTodoOf(c.Request.Context(), grants, c.Param("id"), permissionRead)
+	if err != nil {
+		answerSharedError(c, c.Param("id"), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, todo)
+}
+
+// updateSharedTodoHandler changes the title of a shared todo and completes
+// or reopens it, with the write permission.
+func updateSharedTodoHandler(c *gin.Context) {
+	var update todoUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	if update.Due != nil || update.Priority != nil || update.Tags != nil || update.Position != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "shared todos only take title and done",
+		})
+		return
+	}
+	if update.Title == nil && update.Done == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "set title or done",
+		})
+		return
+	}
+	if update.Title != nil && strings.TrimSpace(*update.Title) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "title must not be empty",
+		})
+		return
+	}
+
+	grants, ok := signedInGrants(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	todo, err := sharedTodoOf(ctx, grants, id, permissionWrite)
+	if err != nil {
+		answerSharedError(c, id, err)
+		return
+	}
+	completes := update.Done != nil && *update.Done && !todo.Done
+	if completes && !checkBlockers(c, todo.Todo) {
+		return
+	}
+
+	if err := updateSharedTodo(ctx, grants, id, update.Title, update.Done); err != nil {
+		answerSharedError(c, id, err)
+		return
+	}
+	dropCachedSmartLists()
+	if completes {
+		finishTodo(recordsNamespace(c), todo.Todo)
+	}
+
+	updated, err := sharedTodoOf(ctx, grants, id, permissionRead)
+	if err != nil {
+		answerSharedError(c, id, err)
+		return
+	}
+	publishChange(changeUpdated, updated.Todo)
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// permittedTodo looks up the todo of the id parameter like todoByID and
+// checks permission on it, for the changes that take some work before they
+// reach the database. It answers the request itself if the todo isn't found
+// or the signed in account may not do what permission needs.
+func permittedTodo(c *gin.Context, permission string) (tododb.Todo, bool) {
+	todo, ok := todoByID(c)
+	if !ok {
+		return tododb.Todo{}, false
+	}
+
+	grants, err := permissionsOf(c)
+	if err == nil {
+		err = grants.access(c.GetString(accountKey), todo, permission)
+	}
+	if err != nil {
+		answerTodoError(c, todo.ID, err)
+		return tododb.Todo{}, false
+	}
+
+	return todo, true
+}
+
+// listGrantsHandler answers with the owner of a todo and the accounts it is
+// shared with, ordered by name.
+func listGrantsHandler(c *gin.Context) {
+	if _, ok := signedInGrants(c); !ok {
+		return
+	}
+	todo, ok := permittedTodo(c, permissionOwner)
+	if !ok {
+		return
+	}
+
+	grants, err := loadTodoGrants(todo.ID)
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	infos := []grantInfo{}
+	if todo.Owner != "" {
+		infos = append(infos, grantInfo{Account: todo.Owner, Permission: permissionOwner})
+	}
+	for account, permission := range grants {
+		infos = append(infos, grantInfo{Account: account, Permission: permission})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Account < infos[j].Account
+	})
+
+	c.JSON(http.StatusOK, infos)
+}
+
+// setGrantHandler shares a todo with an account, with the permission read or
+// write. Only the owner may share a todo, the first account that shares a
+// todo without an owner becomes its owner.
+func setGrantHandler(c *gin.Context) {
+	var request struct {
+		Permission string `json:"permission"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	if request.Permission != permissionRead && request.Permission != permissionWrite {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("unknown permission %q, use %s or %s", request.Permission, permissionRead, permissionWrite),
+		})
+		return
+	}
+
+	if _, ok := signedInGrants(c); !ok {
+		return
+	}
+	todo, ok := permittedTodo(c, permissionOwner)
+	if !ok {
+		return
+	}
+	caller := c.GetString(accountKey)
+	name := c.Param("account")
+	if name == caller {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "you own this todo already",
+		})
+		return
+	}
+	if _, err := tododb.UsersOf(database).GetUser(name); err == tododb.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": "account " + name + " not found",
+		})
+		return
+	} else if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	err := claimTodo(c.Request.Context(), todo, caller)
+	if err == errNotOwner {
+		c.JSON(http.StatusForbidden, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	if err == nil {
+		err = setGrant(todo.ID, name, request.Permission)
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	logger.Infof("Shared todo %s with account %s to %s", todo.ID, name, request.Permission)
+	c.JSON(http.StatusOK, grantInfo{Account: name, Permission: request.Permission})
+}
+
+func deleteGrantHandler(c *gin.Context) {
+	if _, ok := signedInGrants(c); !ok {
+		return
+	}
+	todo, ok := permittedTodo(c, permissionOwner)
+	if !ok {
+		return
+	}
+	if c.Param("account") == c.GetString(accountKey) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "the owner can't be taken away",
+		})
+		return
+	}
+
+	if err := setGrant(todo.ID, c.Param("account"), ""); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+func TestTodoPermissions(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+	server.createAccount("jane")
+	server.createAccount("bob")
+	jane, bob := asAccount("jane"), asAccount("bob")
+	todo := server.createTodo(jane, "Book the venue")
+	if todo.Owner != "jane" {
+		t.Fatalf("the owner of a new todo is %q, want jane", todo.Owner)
+	}
+	path := "/api/v1/todos/" + todo.ID
+	rename := gin.H{"title": "Cancel the venue"}
+
+	// without a grant the todo doesn't exist for bob and anonymous requests
+	for name, authorize := range map[string]authorization{"bob": bob, "anonymous": anonymous} {
+		if _, found := findTodoIn(server.todos(authorize), todo.ID); found {
+			t.Errorf("%s lists the todo of jane without a grant", name)
+		}
+		server.expect(http.StatusNotFound, "PATCH", path, authorize, rename, nil)
+		server.expect(http.StatusNotFound, "DELETE", path, authorize, nil, nil)
+	}
+	server.expect(http.StatusNotFound, "GET", path+"/grants", bob, nil, nil)
+	server.expect(http.StatusNotFound, "GET", "/api/v1/granted/todos/"+todo.ID, bob, nil, nil)
+	server.expect(http.StatusUnauthorized, "PUT", path+"/grants/bob", anonymous, gin.H{"permission": "read"}, nil)
+
+	server.grant(jane, todo.ID, "bob", permissionRead)
+	if _, found := findTodoIn(server.todos(bob), todo.ID); !found {
+		t.Errorf("bob doesn't list the todo shared to read")
+	}
+	server.expect(http.StatusForbidden, "PATCH", path, bob, rename, nil)
+	server.expect(http.StatusForbidden, "PATCH", "/api/v1/granted/todos/"+todo.ID, bob, rename, nil)
+	server.expect(http.StatusForbidden, "GET", path+"/grants", bob, nil, nil)
+
+	server.grant(jane, todo.ID, "bob", permissionWrite)
+	var updated tododb.Todo
+	server.expect(http.StatusOK, "PATCH", path, bob, rename, &updated)
+	if updated.Title != "Cancel the venue" || updated.Owner != "jane" {
+		t.Errorf("bob's update gave %q of %q, want the new title of jane", updated.Title, updated.Owner)
+	}
+	server.expect(http.StatusForbidden, "DELETE", path, bob, nil, nil)
+	server.expect(http.StatusForbidden, "PUT", path+"/grants/jane", bob, gin.H{"permission": "write"}, nil)
+
+	var grants []grantInfo
+	server.expect(http.StatusOK, "GET", path+"/grants", jane, nil, &grants)
+	want := []grantInfo{{Account: "bob", Permission: permissionWrite}, {Account: "jane", Permission: permissionOwner}}
+	if len(grants) != len(want) || grants[0] != want[0] || grants[1] != want[1] {
+		t.Errorf("the grants are %+v, want %+v", grants, want)
+	}
+
+	server.expect(http.StatusNoContent, "DELETE", path+"/grants/bob", jane, nil, nil)
+	server.expect(http.StatusNotFound, "PATCH", path, bob, rename, nil)
+	server.expect(http.StatusNoContent, "DELETE", path, jane, nil, nil)
+}
+
+func TestGrantUnownedTodo(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+	server.createAccount("jane")
+	server.createAccount("bob")
+	todo := server.createTodo(anonymous, "Water the plants")
+
+	server.expect(http.StatusNotFound, "PUT", "/api/v1/todos/"+todo.ID+"/grants/alice", asAccount("jane"), gin.H{"permission": "read"}, nil)
+	server.grant(asAccount("jane"), todo.ID, "bob", permissionRead)
+
+	// sharing claimed the todo for jane, it's gone for anonymous requests
+	if _, found := findTodoIn(server.todos(anonymous), todo.ID); found {
+		t.Errorf("the todo jane shared is still listed anonymously")
+	}
+	server.expect(http.StatusForbidden, "PUT", "/api/v1/todos/"+todo.ID+"/grants/jane", asAccount("bob"), gin.H{"permission": "write"}, nil)
+}
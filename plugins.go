@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/johscheuer/todo-app-web/importer"
+	// Backends and importers living in other modules are compiled in by
+	// importing them here for their init function, e.g.
+	// _ "example.com/todo-dynamodb"
+)
+
+// registerImporterPlugins makes the external programs of ImporterPlugins
+// available as import formats.
+func registerImporterPlugins(plugins map[string]string) error {
+	for name, path := range plugins {
+		if _, err := importer.Get(name); err == nil {
+			return fmt.Errorf("import format %s is already taken", name)
+		}
+		importer.Register(name, importer.Command{Path: path})
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const adminKey = "admin"
+
+var policyLogger = logging.New("policy")
+
+type policySubject struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+type policyInput struct {
+	Method   string        `json:"method"`
+	Path     string        `json:"path"`
+	Route    string        `json:"route"`
+	Action   string        `json:"action"`
+	Subject  policySubject `json:"subject"`
+	ClientIP string        `json:"clientIP"`
+}
+
+// policyResult accepts both a plain boolean and an object with allow and
+// an optional reason as result of the policy.
+type policyResult struct {
+	Allow  bool
+	Reason string
+}
+
+func (r *policyResult) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &r.Allow); err == nil {
+		return nil
+	}
+
+	var result struct {
+		Allow  bool   `json:"allow"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return err
+	}
+	r.Allow, r.Reason = result.Allow, result.Reason
+
+	return nil
+}
+
+// opaMiddleware asks an OPA server whether a request is allowed. It belongs
+// behind the auth middleware of a group, so the subject is known. Every
+// decision is logged in the policy module.
+func opaMiddleware(options map[string]string, decisions *prometheus.CounterVec) (gin.HandlerFunc, error) {
+	url := options["url"]
+	if url == "" {
+		return nil, errors.New("url of the OPA decision, e.g. http://opa:8181/v1/data/todo/allow, is missing")
+	}
+
+	timeout, err := intOption(options, "timeoutMs", 500)
+	if err != nil {
+		return nil, err
+	}
+	failOpen := options["failOpen"] == "true"
+
+	client := &http.Client{Timeout: time.Duration(timeout) * time.Millisecond}
+
+	return func(c *gin.Context) {
+		input := newPolicyInput(c)
+		result, err := queryOPA(client, url, input)
+		if err != nil {
+			decisions.WithLabelValues("error").Inc()
+			policyLogger.Errorf("%s %s by %s: %v", input.Action, input.Route, input.Subject.Type, err)
+			if failOpen {
+				c.Next()
+				return
+			}
+
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"errors": "policy engine unavailable",
+			})
+			return
+		}
+
+		decision := "deny"
+		if result.Allow {
+			decision = "allow"
+		}
+		decisions.WithLabelValues(decision).Inc()
+		policyLogger.Infof("%s %s %s by %s %s from %s %s", decision, input.Action, input.Route,
+			input.Subject.Type, input.Subject.Name, input.ClientIP, result.Reason)
+
+		if !result.Allow {
+			reason := result.Reason
+			if reason == "" {
+				reason = "denied by policy"
+			}
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"errors": reason,
+			})
+			return
+		}
+
+		c.Next()
+	}, nil
+}
+
+func newPolicyInput(c *gin.Context) policyInput {
+	action := "write"
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		action = "read"
+	}
+
+	subject := policySubject{Type: "anonymous"}
+	if name, exists := c.Get(integrationKey); exists {
+		subject = policySubject{Type: "integration", Name: fmt.Sprint(name)}
+	} else if c.GetBool(adminKey) {
+		subject = policySubject{Type: "admin"}
+	} else if name := c.GetString(accountKey); name != "" {
+		subject = policySubject{Type: "account", Name: name}
+	}
+
+	return policyInput{
+		Method:   c.Request.Method,
+		Path:     c.Request.URL.Path,
+		Route:    strings.TrimPrefix(routeOf(c), c.Request.Method+" "),
+		Action:   action,
+		Subject:  subject,
+		ClientIP: c.ClientIP(),
+	}
+}
+
+func queryOPA(client *http.Client, url string, input policyInput) (policyResult, error) {
+	body, err := json.Marshal(map[string]policyInput{"input": input})
+	if err != nil {
+		return policyResult{}, err
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return policyResult{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return policyResult{}, fmt.Errorf("OPA answered with %s", resp.Status)
+	}
+
+	var decision struct {
+		Result *policyResult `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return policyResult{}, err
+	}
+
+	// OPA leaves out the result if the policy doesn't define it
+	if decision.Result == nil {
+		return policyResult{Reason: "policy is undefined"}, nil
+	}
+
+	return *decision.Result, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+var printTemplate = template.Must(template.New("print").Funcs(brandingFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <title>Todo list - {{appName}}</title>
+    <style>
+      body { font-family: Georgia, serif; max-width: 40em; margin: 2em auto; color: #000; }
+      h1 { font-size: 1.6em; margin-bottom: 0; color: {{primaryColor}}; }
+      .meta { color: #555; font-size: 0.9em; margin-top: 0.2em; }
+      h2 { font-size: 1.2em; border-bottom: 1px solid #000; padding-bottom: 0.2em; }
+      ul { list-style: none; padding: 0; }
+      li { padding: 0.3em 0; border-bottom: 1px dotted #999; page-break-inside: avoid; }
+      li:before { content: "\2610"; margin-right: 0.6em; }
+      .actions { margin: 1em 0; }
+      @media print {
+        .actions { display: none; }
+        body { margin: 0; }
+      }
+    </style>
+  </head>
+  <body>
+    <h1>Todo list</h1>
+    <p class="meta">{{len .Todos}} open todo(s), printed {{.Printed.Format "2006-01-02 15:04"}}</p>
+    <p class="actions"><button onclick="window.print()">Print</button></p>
+    <h2>Open</h2>
+    <ul>
+    {{range .Todos}}  <li>{{.}}</li>
+    {{else}}  <li>Nothing to do.</li>
+    {{end}}</ul>
+  </body>
+</html>
+`))
+
+func printTodoHandler(c *gin.Context) {
+	defer lockContentionScenario()()
+
+	todos, err := todosOf(c).GetAllTodos(c.Request.Context())
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	err = printTemplate.Execute(&buf, struct {
+		Todos   []string
+		Printed time.Time
+	}{
+		Todos:   tododb.Titles(todos),
+		Printed: time.Now(),
+	})
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	if c.Query("download") != "" {
+		c.Header("Content-Disposition", `attachment; filename="todos.html"`)
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+var priorityNames = map[string]int{
+	"none":   tododb.PriorityNone,
+	"low":    tododb.PriorityLow,
+	"medium": tododb.PriorityMedium,
+	"high":   tododb.PriorityHigh,
+}
+
+// parsePriority reads a priority given by name, like high, or as a number
+// from 0 to 9.
+func parsePriority(value string) (int, error) {
+	if priority, ok := priorityNames[strings.ToLower(value)]; ok {
+		return priority, nil
+	}
+
+	priority, err := strconv.Atoi(value)
+	if err != nil || priority < tododb.PriorityNone || priority > tododb.PriorityHigh {
+		return 0, fmt.Errorf("invalid priority %q, use none, low, medium, high or 0 to %d", value, tododb.PriorityHigh)
+	}
+
+	return priority, nil
+}
+
+// priorityValue is a priority in a request body, a name or a number.
+type priorityValue int
+
+func (priority *priorityValue) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	parsed, err := parsePriority(fmt.Sprint(value))
+	*priority = priorityValue(parsed)
+	return err
+}
+
+// The orders of ?sort=, without it the list is in the order the todos were
+// added.
+const (
+	sortAdded    = ""
+	sortPriority = "priority"
+	sortTitle    = "title"
+)
+
+// todoOrder is the order a list view is sorted in, it is applied after the
+// todos are fetched and filtered.
+type todoOrder struct {
+	by string
+	// collator compares the titles in the locale of the request, set for
+	// sortTitle
+	collator *collator
+}
+
+// orderOf returns the order of ?sort=, priority or title. It answers unknown
+// orders and locales itself.
+func orderOf(c *gin.Context) (todoOrder, bool) {
+	switch by := c.Query("sort"); by {
+	case sortAdded, sortPriority:
+		return todoOrder{by: by}, true
+	case sortTitle:
+		locale, ok := localeOf(c)
+		if !ok {
+			return todoOrder{}, false
+		}
+		return todoOrder{by: by, collator: collatorFor(locale)}, true
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{
+		"errors": fmt.Sprintf("unknown sort %q, use priority or title", c.Query("sort")),
+	})
+	return todoOrder{}, false
+}
+
+// apply returns todos in the order, a copy unless it is the added one.
+func (order todoOrder) apply(todos []tododb.Todo) []tododb.Todo {
+	switch order.by {
+	case sortPriority:
+		return sortByPriority(todos)
+	case sortTitle:
+		return order.collator.sortByTitle(todos)
+	}
+
+	return todos
+}
+
+// sortByPriority returns a copy of todos, the highest priority first.
+func sortByPriority(todos []tododb.Todo) []tododb.Todo {
+	sorted := append([]tododb.Todo{}, todos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority > sorted[j].Priority
+	})
+
+	return sorted
+}
+
+// priorityBadge labels a todo with a priority, high ones in red.
+func priorityBadge(todo tododb.Todo) template.HTML {
+	if todo.Priority == tododb.PriorityNone {
+		return ""
+	}
+
+	class := "label-default"
+	switch {
+	case todo.Priority >= tododb.PriorityHigh:
+		class = "label-danger"
+	case todo.Priority == tododb.PriorityMedium:
+		class = "label-warning"
+	}
+
+	return template.HTML(fmt.Sprintf(` <span class="label %s">%s</span>`, class, priorityText(todo.Priority)))
+}
+
+// priorityText names a priority for people, high, medium, low or its number.
+func priorityText(priority int) string {
+	switch {
+	case priority >= tododb.PriorityHigh:
+		return "high"
+	case priority == tododb.PriorityMedium:
+		return "medium"
+	case priority == tododb.PriorityLow:
+		return "low"
+	}
+
+	return "priority " + strconv.Itoa(priority)
+}
@@ -1,13 +1,166 @@
 $(document).ready(function() {
   var entryContentElement = $("#todo-input");
+  var smartListElement = $("#smartlist");
+  var tagElement = $("#tag");
+  var searchElement = $("#search");
+  var sortElement = $("#sort");
 
-  var appendTodoList = function(data) {
-    if (data == null) {
-      return
+  // Smart lists are saved filters, the selected one, the tag, the search and
+  // the order are applied by the server.
+  var fragmentParams = function(params) {
+    if (smartListElement.val()) {
+      params.smartlist = smartListElement.val();
+    }
+    if (tagElement.val()) {
+      params.tag = tagElement.val();
     }
-    $("#Todos > tbody").empty();
-    $.each(data, function(key, val) {
-      $("#Todos > tbody").append('<tr><td class="col-xs-10 col-sm-10 col-md-10">'+val+'</td><td align="center" class="col-xs-2 col-sm-2 col-md-2"><input type="checkbox" name="deleteCheck" value="1"/></td></tr>');
+    if ($.trim(searchElement.val()).length >= 2) {
+      params.q = $.trim(searchElement.val());
+    }
+    if (sortElement.val()) {
+      params.sort = sortElement.val();
+    }
+    return params;
+  }
+
+  // The server renders the rows and stops after the render budget, adding a
+  // "load more" row that fetches the next fragment. Refreshes ask for every
+  // page loaded so far, so the list doesn't collapse to the first one.
+  var loadedPages = 1;
+  var renderTodoList = function() {
+    return $.get("todo/fragment", fragmentParams({pages: loadedPages}), function(rows) {
+      $("#Todos > tbody").html(rows);
+    });
+  }
+
+  // Another filter or order starts over with the first page.
+  var renderFirstPage = function() {
+    loadedPages = 1;
+    return renderTodoList();
+  }
+
+  var loadMore = function(e) {
+    e.preventDefault();
+    var row = $(this).closest("tr");
+    $.get("todo/fragment", fragmentParams({offset: $(this).data("offset")}), function(rows) {
+      loadedPages++;
+      row.replaceWith(rows);
+    });
+  }
+
+  // Deleted todos stay in the trash until they are restored or purged, the
+  // last deleted first.
+  var renderTrash = function() {
+    $.getJSON("api/v1/trash", function(todos) {
+      var rows = $.map(todos || [], function(todo) {
+        var restore = $("<button>").addClass("btn btn-default btn-xs restore").text("Restore");
+        return $("<tr>").data("id", todo.id).append(
+          $("<td>").text(todo.title),
+          $("<td>").text(new Date(todo.deletedAt).toLocaleString()),
+          $("<td>").addClass("text-right").append(restore));
+      });
+      $("#Trash > tbody").empty().append(rows);
+      $("#trash").toggleClass("hidden", rows.length == 0);
+    });
+  }
+
+  var handleRestore = function(e) {
+    e.preventDefault();
+    $.post("api/v1/trash/" + encodeURIComponent($(this).closest("tr").data("id")) + "/restore", function() {
+      renderTodoList();
+      renderTrash();
+    });
+  }
+
+  // Double-clicking a todo opens the edit form. The edit is saved as a draft
+  // while typing, a reload or a crashed browser continues it from there, and
+  // leaving the page with an unsaved edit asks first.
+  var editedID = null;
+  var editDirty = false;
+  var draftTimer;
+
+  var openEdit = function() {
+    var row = $(this).closest("tr");
+    editedID = row.data("id");
+    editDirty = false;
+    $("#edit-title").val(row.data("title"));
+    $("#edit-description").val(row.data("description"));
+    $("#edit-draft").text("");
+    $.getJSON("api/v1/drafts/" + encodeURIComponent(editedID), function(draft) {
+      $("#edit-title").val(draft.title);
+      $("#edit-description").val(draft.description);
+      $("#edit-draft").text("Unsaved edit from " + new Date(draft.savedAt).toLocaleString());
+      editDirty = true;
+    });
+    $("#edit").modal("show");
+  }
+
+  var saveDraft = function() {
+    $.ajax({
+      url: "api/v1/drafts/" + encodeURIComponent(editedID),
+      type: 'PUT',
+      contentType: "application/json",
+      data: JSON.stringify({title: $("#edit-title").val(), description: $("#edit-description").val()})
+    });
+  }
+
+  var handleEditInput = function() {
+    editDirty = true;
+    clearTimeout(draftTimer);
+    draftTimer = setTimeout(saveDraft, 1000);
+  }
+
+  var closeEdit = function() {
+    clearTimeout(draftTimer);
+    editDirty = false;
+    editedID = null;
+    $("#edit").modal("hide");
+  }
+
+  var handleEditSave = function(e) {
+    e.preventDefault();
+    clearTimeout(draftTimer);
+    $.ajax({
+      url: "api/v1/todos/" + encodeURIComponent(editedID),
+      type: 'PATCH',
+      contentType: "application/json",
+      data: JSON.stringify({title: $("#edit-title").val(), description: $("#edit-description").val()}),
+      success: function() {
+        closeEdit();
+        renderTodoList();
+      },
+      error: function(xhr) {
+        alert(xhr.responseJSON ? xhr.responseJSON.errors : xhr.statusText);
+      }
+    });
+  }
+
+  var handleEditCancel = function(e) {
+    e.preventDefault();
+    $.ajax({
+      url: "api/v1/drafts/" + encodeURIComponent(editedID),
+      type: 'DELETE'
+    });
+    closeEdit();
+  }
+
+  // After a delete the last operation can be undone, the offer is shown for
+  // a while.
+  var undoTimer;
+  var offerUndo = function(text) {
+    $("#undo-text").text(text);
+    $("#undo").removeClass("hidden");
+    clearTimeout(undoTimer);
+    undoTimer = setTimeout(function() { $("#undo").addClass("hidden"); }, 15000);
+  }
+
+  var handleUndo = function(e) {
+    e.preventDefault();
+    clearTimeout(undoTimer);
+    $("#undo").addClass("hidden");
+    $.post("api/undo", function() {
+      renderTodoList();
+      renderTrash();
     });
   }
 
@@ -21,7 +174,7 @@ $(document).ready(function() {
 
     entryContentElement.val("")
     entryContentElement.parent().removeClass("has-error").addClass("has-success");
-    $.post("todo/" + entryValue, appendTodoList);
+    $.post("todo/" + entryValue, renderTodoList);
   }
 
   var handleDeletion = function(e){
@@ -34,25 +187,160 @@ $(document).ready(function() {
      }
      var checkbox = checkboxes[i];
      $.ajax({
-        url: "todo/" + $(checkbox).closest('tr').text(),
+        url: "api/v1/todos/" + encodeURIComponent($(checkbox).closest('tr').data("id")),
         type: 'DELETE',
-        success: appendTodoList
+        success: function() {
+          renderTodoList();
+          renderTrash();
+          offerUndo("Deleted.");
+        }
       });
     }
   }
 
+  // Done todos stay in the list, struck through, until they are deleted.
+  var handleCompletion = function(e) {
+    var checkbox = this;
+    $.ajax({
+      url: "api/v1/todos/" + encodeURIComponent($(checkbox).closest('tr').data("id")),
+      type: 'PATCH',
+      contentType: "application/json",
+      data: JSON.stringify({done: checkbox.checked}),
+      success: renderTodoList,
+      error: function(xhr) {
+        checkbox.checked = !checkbox.checked;
+        if (xhr.status == 409) {
+          alert(xhr.responseJSON.errors + ":\n" + xhr.responseJSON.blockedBy.join("\n"));
+        }
+      }
+    });
+  }
+
+  // Dragging a row moves the todo to the place of the row it is dropped on.
+  // The positions are those of the whole list, so only unfiltered lists can
+  // be reordered.
+  var draggedRow;
+  var handleDragStart = function(e) {
+    if (!$.isEmptyObject(fragmentParams({}))) {
+      e.preventDefault();
+      return;
+    }
+    draggedRow = $(this);
+    e.originalEvent.dataTransfer.effectAllowed = "move";
+    e.originalEvent.dataTransfer.setData("text/plain", draggedRow.data("id"));
+  }
+
+  var handleDrop = function(e) {
+    e.preventDefault();
+    if (!draggedRow || draggedRow[0] === this) {
+      return;
+    }
+    var position = $("#Todos > tbody > tr[data-id]").index(this);
+    draggedRow = null;
+    $.ajax({
+      url: "api/v1/todos/" + encodeURIComponent(e.originalEvent.dataTransfer.getData("text/plain")),
+      type: 'PATCH',
+      contentType: "application/json",
+      data: JSON.stringify({position: position}),
+      complete: renderTodoList
+    });
+  }
+
   $("#todo-submit").click(handleSubmission);
   $("#todo-delete").click(handleDeletion);
+  $("#Todos > tbody").on("click", ".load-more button", loadMore);
+  $("#Trash > tbody").on("click", "button.restore", handleRestore);
+  $("#Todos > tbody").on("dblclick", "tr[data-id] > td:first-child", openEdit);
+  $("#edit-title, #edit-description").on("input", handleEditInput);
+  $("#edit-save").click(handleEditSave);
+  $("#undo-link").click(handleUndo);
+  $("#edit-cancel").click(handleEditCancel);
+  $(window).on("beforeunload", function(e) {
+    if (editDirty) {
+      e.preventDefault();
+      return "The edit isn't saved yet.";
+    }
+  });
+  $("#Todos > tbody").on("change", "input[name=doneCheck]", handleCompletion);
+  $("#Todos > tbody").on("dragstart", "tr[data-id]", handleDragStart);
+  $("#Todos > tbody").on("dragover", "tr[data-id]", function(e) { e.preventDefault(); });
+  $("#Todos > tbody").on("drop", "tr[data-id]", handleDrop);
+  smartListElement.change(renderFirstPage);
+  tagElement.change(renderFirstPage);
+  sortElement.change(renderFirstPage);
+
+  // Search once typing pauses, not on every key.
+  var searchTimer;
+  searchElement.on("input", function() {
+    clearTimeout(searchTimer);
+    searchTimer = setTimeout(renderFirstPage, 300);
+  });
+
+  $.getJSON("api/v1/smartlists", function(lists) {
+    $.each(lists || [], function(i, list) {
+      smartListElement.append($("<option>").val(list.id).text(list.name));
+    });
+  });
+
+  renderTrash();
+
+  $.getJSON("api/v1/tags", function(tags) {
+    $.each(tags || [], function(i, tag) {
+      tagElement.append($("<option>").val(tag.name).text("#" + tag.name + " (" + tag.todos + ")"));
+    });
+  });
 
   // Poll every second.
   (function fetchTodos() {
-    $.getJSON("todo").done(appendTodoList).always(
+    renderTodoList().always(
       function() {
         setTimeout(fetchTodos, 10000);
       });
   })();
 });
 
+// The view of the account or the browser. The basic and the screen reader
+// views are rendered by the server, high contrast restyles this page.
+$(document).ready(function() {
+  function showView(view) {
+    if (view == "basic" || view == "screen-reader") {
+      window.location.href = "basic?view=" + encodeURIComponent(view);
+      return;
+    }
+    $("body").toggleClass("high-contrast", view == "high-contrast");
+    $("#view").val(view);
+  }
+
+  $.getJSON("api/v1/view", function(data) {
+    showView(data.view);
+  });
+
+  $("#view").change(function() {
+    var view = $(this).val();
+    $.ajax({
+      url: "api/v1/view",
+      type: "PUT",
+      contentType: "application/json",
+      data: JSON.stringify({view: view})
+    }).done(function() {
+      showView(view);
+    });
+  });
+});
+
+// Streaks and achievements, only shown with the gamification feature.
+$(document).ready(function() {
+  $.getJSON("api/v1/stats", function(data) {
+    if (!data.users) {
+      return;
+    }
+    var parts = $.map(data.users.slice(0, 3), function(user) {
+      return user.user + ": " + user.currentStreak + " day streak, " + user.achievements.length + " achievement(s)";
+    });
+    $("#stats").text(parts.join(" \u00b7 "));
+  });
+});
+
 $(document).ready(function() {
    $.getJSON("version", function(data) {
     if (data == null) {
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/qrcode"
+)
+
+const (
+	defaultQRScale = 8
+	maxQRScale     = 32
+)
+
+// publicURL is the address attendees reach the instance at, PublicURL of the
+// config or else derived from the request.
+func publicURL(c *gin.Context) string {
+	if appConfig.PublicURL != "" {
+		return strings.TrimSuffix(appConfig.PublicURL, "/")
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+
+	return scheme + "://" + c.Request.Host
+}
+
+// qrHandler renders a QR code of ?path= on this instance, like a share link,
+// or of the instance itself. Only paths are accepted so the endpoint can't be
+// used to make codes for arbitrary sites.
+func qrHandler(c *gin.Context) {
+	path := c.DefaultQuery("path", "/")
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "path must be a path on this instance",
+		})
+		return
+	}
+
+	code, err := qrcode.Encode([]byte(publicURL(c) + path))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	switch c.DefaultQuery("format", "png") {
+	case "svg":
+		c.Data(http.StatusOK, "image/svg+xml", code.SVG())
+	case "png":
+		scale, err := strconv.Atoi(c.DefaultQuery("scale", strconv.Itoa(defaultQRScale)))
+		if err != nil || scale < 1 || scale > maxQRScale {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": fmt.Sprintf("scale must be between 1 and %d", maxQRScale),
+			})
+			return
+		}
+
+		image, err := code.PNG(scale)
+		if err != nil {
+			logger.Errorf("%v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+		c.Data(http.StatusOK, "image/png", image)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "format must be png or svg",
+		})
+	}
+}
+
+func qrLink(path string) string {
+	return "/qr?path=" + url.QueryEscape(path)
+}
@@ -0,0 +1,473 @@
+// Package qrcode encodes short texts like URLs as QR codes in byte mode with
+// error correction level M, up to version 20 (666 bytes).
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Code is an encoded QR code, a square of Size modules.
+type Code struct {
+	Size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+// ErrTooLong is returned for data that doesn't fit into version 20.
+var ErrTooLong = errors.New("qrcode: data too long")
+
+type blockLayout struct {
+	ecPerBlock             int
+	group1Blocks, group1CW int
+	group2Blocks, group2CW int
+}
+
+// layouts of level M, indexed by version-1
+var layouts = []blockLayout{
+	{10, 1, 16, 0, 0}, {16, 1, 28, 0, 0}, {26, 1, 44, 0, 0}, {18, 2, 32, 0, 0},
+	{24, 2, 43, 0, 0}, {16, 4, 27, 0, 0}, {18, 4, 31, 0, 0}, {22, 2, 38, 2, 39},
+	{22, 3, 36, 2, 37}, {26, 4, 43, 1, 44}, {30, 1, 50, 4, 51}, {22, 6, 36, 2, 37},
+	{22, 8, 37, 1, 38}, {24, 4, 40, 5, 41}, {24, 5, 41, 5, 42}, {28, 7, 45, 3, 46},
+	{28, 10, 46, 1, 47}, {26, 9, 43, 4, 44}, {26, 3, 44, 11, 45}, {26, 3, 41, 13, 42},
+}
+
+func (l blockLayout) dataCodewords() int {
+	return l.group1Blocks*l.group1CW + l.group2Blocks*l.group2CW
+}
+
+// Encode picks the smallest version that fits data and the mask with the
+// lowest penalty.
+func Encode(data []byte) (*Code, error) {
+	version := 0
+	for v := 1; v <= len(layouts); v++ {
+		if (4+countBits(v)+8*len(data)+7)/8 <= layouts[v-1].dataCodewords() {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	codewords := addErrorCorrection(version, dataCodewords(version, data))
+
+	code := newCode(version)
+	code.drawCodewords(codewords)
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		code.applyMask(mask)
+		code.drawFormatBits(mask)
+		if penalty := code.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		// XOR again to undo the mask
+		code.applyMask(mask)
+	}
+	code.applyMask(best)
+	code.drawFormatBits(best)
+
+	return code, nil
+}
+
+// Dark reports whether the module at column x and row y is dark.
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y][x]
+}
+
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+type bitBuffer struct {
+	bytes []byte
+	bits  int
+}
+
+func (b *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		if b.bits%8 == 0 {
+			b.bytes = append(b.bytes, 0)
+		}
+		if (value>>uint(i))&1 != 0 {
+			b.bytes[b.bits/8] |= 0x80 >> uint(b.bits%8)
+		}
+		b.bits++
+	}
+}
+
+// dataCodewords is the byte mode segment followed by terminator and padding.
+func dataCodewords(version int, data []byte) []byte {
+	capacity := layouts[version-1].dataCodewords() * 8
+
+	var buf bitBuffer
+	buf.append(0x4, 4)
+	buf.append(len(data), countBits(version))
+	for _, b := range data {
+		buf.append(int(b), 8)
+	}
+
+	terminator := capacity - buf.bits
+	if terminator > 4 {
+		terminator = 4
+	}
+	buf.append(0, terminator)
+	buf.append(0, (8-buf.bits%8)%8)
+
+	for pad := 0xEC; buf.bits < capacity; pad ^= 0xEC ^ 0x11 {
+		buf.append(pad, 8)
+	}
+
+	return buf.bytes
+}
+
+// addErrorCorrection splits data into blocks, appends the Reed-Solomon
+// codewords of every block and interleaves the result.
+func addErrorCorrection(version int, data []byte) []byte {
+	layout := layouts[version-1]
+	divisor := reedSolomonDivisor(layout.ecPerBlock)
+
+	var blocks, ecBlocks [][]byte
+	for i := 0; i < layout.group1Blocks+layout.group2Blocks; i++ {
+		size := layout.group1CW
+		if i >= layout.group1Blocks {
+			size = layout.group2CW
+		}
+		block := data[:size]
+		data = data[size:]
+
+		blocks = append(blocks, block)
+		ecBlocks = append(ecBlocks, reedSolomonRemainder(block, divisor))
+	}
+
+	result := []byte{}
+	for i := 0; i < layout.group2CW || i < layout.group1CW; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < layout.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+
+	return result
+}
+
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+	return byte(z)
+}
+
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+
+	return result
+}
+
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coefficient := range divisor {
+			result[i] ^= gfMultiply(coefficient, factor)
+		}
+	}
+
+	return result
+}
+
+func newCode(version int) *Code {
+	size := version*4 + 17
+	code := &Code{Size: size}
+	for i := 0; i < size; i++ {
+		code.modules = append(code.modules, make([]bool, size))
+		code.function = append(code.function, make([]bool, size))
+	}
+
+	for i := 0; i < size; i++ {
+		code.setFunction(6, i, i%2 == 0)
+		code.setFunction(i, 6, i%2 == 0)
+	}
+
+	code.drawFinder(3, 3)
+	code.drawFinder(size-4, 3)
+	code.drawFinder(3, size-4)
+
+	positions := alignmentPositions(version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			code.drawAlignment(x, y)
+		}
+	}
+
+	// Reserve the format areas until the mask is known
+	code.drawFormatBits(0)
+	code.drawVersion(version)
+
+	return code
+}
+
+func (c *Code) setFunction(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.function[y][x] = true
+}
+
+func (c *Code) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= c.Size || yy < 0 || yy >= c.Size {
+				continue
+			}
+			distance := max(abs(dx), abs(dy))
+			c.setFunction(xx, yy, distance != 2 && distance != 4)
+		}
+	}
+}
+
+func (c *Code) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+
+	count := version/7 + 2
+	step := (version*8 + count*3 + 5) / (count*4 - 4) * 2
+	positions := make([]int, count)
+	positions[0] = 6
+	for i, pos := count-1, version*4+10; i > 0; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+
+	return positions
+}
+
+// drawFormatBits writes level M and mask with their BCH code to both copies
+// of the format area.
+func (c *Code) drawFormatBits(mask int) {
+	data := mask // level M is 00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>uint(i))&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(i))
+	}
+	c.setFunction(8, 7, bit(6))
+	c.setFunction(8, 8, bit(7))
+	c.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, bit(i))
+	}
+	c.setFunction(8, c.Size-8, true)
+}
+
+func (c *Code) drawVersion(version int) {
+	if version < 7 {
+		return
+	}
+
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := version<<12 | rem
+
+	for i := 0; i < 18; i++ {
+		dark := (bits>>uint(i))&1 != 0
+		a, b := c.Size-11+i%3, i/3
+		c.setFunction(a, b, dark)
+		c.setFunction(b, a, dark)
+	}
+}
+
+// drawCodewords places the bits in the two module wide zigzag columns from
+// the bottom right, skipping the function patterns.
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if c.function[y][x] || i >= len(data)*8 {
+					continue
+				}
+				c.modules[y][x] = (data[i/8]>>uint(7-i%8))&1 != 0
+				i++
+			}
+		}
+	}
+}
+
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.function[y][x] {
+				continue
+			}
+
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			default:
+				panic(fmt.Sprintf("qrcode: invalid mask %d", mask))
+			}
+			c.modules[y][x] = c.modules[y][x] != invert
+		}
+	}
+}
+
+// penalty scores runs of equal modules, 2x2 blocks, finder like patterns and
+// an unbalanced share of dark modules, lower is better.
+func (c *Code) penalty() int {
+	penalty := 0
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		row := make([]bool, c.Size)
+		column := make([]bool, c.Size)
+		for x := 0; x < c.Size; x++ {
+			row[x] = c.modules[y][x]
+			column[x] = c.modules[x][y]
+			if row[x] {
+				dark++
+			}
+			if x > 0 && y > 0 && row[x] == c.modules[y][x-1] &&
+				row[x] == c.modules[y-1][x] && row[x] == c.modules[y-1][x-1] {
+				penalty += 3
+			}
+		}
+		penalty += linePenalty(row) + linePenalty(column)
+	}
+
+	total := c.Size * c.Size
+	percent := dark * 100 / total
+	penalty += abs(percent-50) / 5 * 10
+
+	return penalty
+}
+
+var finderLike = []bool{true, false, true, true, true, false, true}
+
+func linePenalty(line []bool) int {
+	penalty := 0
+	run := 1
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i] == line[i-1] {
+			run++
+			continue
+		}
+		if run >= 5 {
+			penalty += run - 2
+		}
+		run = 1
+	}
+
+	for i := 0; i+len(finderLike) <= len(line); i++ {
+		matches := true
+		for j, dark := range finderLike {
+			if line[i+j] != dark {
+				matches = false
+				break
+			}
+		}
+		if matches && (lightRun(line, i-4, i) || lightRun(line, i+7, i+11)) {
+			penalty += 40
+		}
+	}
+
+	return penalty
+}
+
+// lightRun reports whether line is light from start to end, the area outside
+// of the code counts as light.
+func lightRun(line []bool, start, end int) bool {
+	for i := start; i < end; i++ {
+		if i >= 0 && i < len(line) && line[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,225 @@
+package qrcode
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestEncodeVersion(t *testing.T) {
+	// The byte mode capacities of level M
+	tests := []struct {
+		length int
+		size   int
+		err    error
+	}{
+		{length: 0, size: 21},
+		{length: 14, size: 21},
+		{length: 15, size: 25},
+		{length: 26, size: 25},
+		{length: 27, size: 29},
+		{length: 122, size: 45},
+		{length: 213, size: 57},
+		{length: 214, size: 61},
+		{length: 666, size: 97},
+		{length: 667, err: ErrTooLong},
+	}
+
+	for _, test := range tests {
+		code, err := Encode(bytes.Repeat([]byte("a"), test.length))
+		if err != test.err {
+			t.Fatalf("Encode() of %d bytes error = %v, want %v", test.length, err, test.err)
+		}
+		if err == nil && code.Size != test.size {
+			t.Errorf("Encode() of %d bytes has size %d, want %d", test.length, code.Size, test.size)
+		}
+	}
+}
+
+func TestDataCodewords(t *testing.T) {
+	// Mode 0100, length 00000001, "A" 01000001, terminator 0000 and the
+	// pad bytes
+	want := []byte{0x40, 0x14, 0x10, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC}
+	if got := dataCodewords(1, []byte("A")); !bytes.Equal(got, want) {
+		t.Errorf("dataCodewords() = % x, want % x", got, want)
+	}
+
+	if got := len(dataCodewords(10, bytes.Repeat([]byte("a"), 100))); got != layouts[9].dataCodewords() {
+		t.Errorf("dataCodewords() of version 10 has %d codewords, want %d", got, layouts[9].dataCodewords())
+	}
+}
+
+func TestErrorCorrection(t *testing.T) {
+	// "HELLO WORLD" as 1-M, from the thonky.com QR code tutorial
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	ec := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	if got := addErrorCorrection(1, data); !bytes.Equal(got, append(data, ec...)) {
+		t.Errorf("addErrorCorrection() = %v, want %v", got, append(data, ec...))
+	}
+}
+
+func TestInterleave(t *testing.T) {
+	// Version 5 has two blocks of 43 data codewords, the result starts
+	// with the first codeword of each
+	data := make([]byte, layouts[4].dataCodewords())
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	got := addErrorCorrection(5, data)
+	if len(got) != len(data)+2*layouts[4].ecPerBlock {
+		t.Fatalf("addErrorCorrection() has %d codewords, want %d", len(got), len(data)+2*layouts[4].ecPerBlock)
+	}
+	if !bytes.Equal(got[:4], []byte{0, 43, 1, 44}) {
+		t.Errorf("addErrorCorrection() starts with %v, want [0 43 1 44]", got[:4])
+	}
+}
+
+func TestFunctionPatterns(t *testing.T) {
+	code, err := Encode([]byte(strings.Repeat("x", 107)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code.Size != 45 {
+		t.Fatalf("Encode() has size %d, want 45 for version 7", code.Size)
+	}
+
+	finder := []string{
+		"#######",
+		"#.....#",
+		"#.###.#",
+		"#.###.#",
+		"#.###.#",
+		"#.....#",
+		"#######",
+	}
+	for _, corner := range [][2]int{{0, 0}, {code.Size - 7, 0}, {0, code.Size - 7}} {
+		for y, row := range finder {
+			for x, module := range row {
+				if code.Dark(corner[0]+x, corner[1]+y) != (module == '#') {
+					t.Errorf("the finder at %v differs at %d,%d", corner, x, y)
+				}
+			}
+		}
+	}
+
+	for i := 8; i < code.Size-8; i++ {
+		if code.Dark(i, 6) != (i%2 == 0) || code.Dark(6, i) != (i%2 == 0) {
+			t.Errorf("the timing patterns differ at %d", i)
+		}
+	}
+
+	// Version 7 with its BCH code
+	version := 0
+	for i := 0; i < 18; i++ {
+		if code.Dark(code.Size-11+i%3, i/3) {
+			version |= 1 << uint(i)
+		}
+	}
+	if version != 0x07C94 {
+		t.Errorf("the version bits are %#x, want 0x7c94", version)
+	}
+}
+
+func TestFormatBits(t *testing.T) {
+	// The format bits of level M for the masks 0 to 7
+	valid := map[int]bool{
+		0x5412: true, 0x5125: true, 0x5E7C: true, 0x5B4B: true,
+		0x45F9: true, 0x40CE: true, 0x4F97: true, 0x4AA0: true,
+	}
+
+	for _, data := range []string{"", "https://todo.example.com/share/abc", strings.Repeat("z", 300)} {
+		code, err := Encode([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		first, second := 0, 0
+		for i := 0; i <= 5; i++ {
+			first |= bit(code.Dark(8, i)) << uint(i)
+		}
+		first |= bit(code.Dark(8, 7))<<6 | bit(code.Dark(8, 8))<<7 | bit(code.Dark(7, 8))<<8
+		for i := 9; i < 15; i++ {
+			first |= bit(code.Dark(14-i, 8)) << uint(i)
+		}
+		for i := 0; i < 8; i++ {
+			second |= bit(code.Dark(code.Size-1-i, 8)) << uint(i)
+		}
+		for i := 8; i < 15; i++ {
+			second |= bit(code.Dark(8, code.Size-15+i)) << uint(i)
+		}
+
+		if !valid[first] {
+			t.Errorf("%q: the format bits %015b aren't level M", data, first)
+		}
+		if first != second {
+			t.Errorf("%q: the format copies differ, %015b and %015b", data, first, second)
+		}
+		if !code.Dark(8, code.Size-8) {
+			t.Errorf("%q: the dark module is light", data)
+		}
+	}
+}
+
+func bit(dark bool) int {
+	if dark {
+		return 1
+	}
+	return 0
+}
+
+func TestPNG(t *testing.T) {
+	code, err := Encode([]byte("todo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := code.PNG(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := (code.Size + 2*quietZone) * 3
+	if img.Bounds() != image.Rect(0, 0, size, size) {
+		t.Fatalf("PNG() bounds = %v, want %d pixels square", img.Bounds(), size)
+	}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			mx, my := x/3-quietZone, y/3-quietZone
+			dark := mx >= 0 && my >= 0 && mx < code.Size && my < code.Size && code.Dark(mx, my)
+			r, _, _, _ := img.At(x, y).RGBA()
+			if (r == 0) != dark {
+				t.Fatalf("PNG() pixel %d,%d is wrong", x, y)
+			}
+		}
+	}
+}
+
+func TestSVG(t *testing.T) {
+	code, err := Encode([]byte("todo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg := string(code.SVG())
+
+	if !strings.Contains(svg, `viewBox="0 0 29 29"`) {
+		t.Errorf("SVG() = %s, want a viewBox of 29", svg)
+	}
+	dark := 0
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Dark(x, y) {
+				dark++
+			}
+		}
+	}
+	if got := strings.Count(svg, "h1v1h-1z"); got != dark {
+		t.Errorf("SVG() draws %d modules, want %d", got, dark)
+	}
+}
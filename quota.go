@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultQuotaWarnPercent = 80
+	// quotaUsageInterval is how long the usage of the backend is reused,
+	// GetUsage is too expensive for every request
+	quotaUsageInterval = 30 * time.Second
+	// quotaRequestsTTL keeps the request counter of a day a bit longer than
+	// the day, for clocks that are off
+	quotaRequestsTTL = 48 * time.Hour
+
+	quotaRemainingHeader = "X-Quota-Remaining"
+)
+
+// quotaWarning is sent once as a change when a quota is almost used up.
+// Account is set for the todos and storage quotas, which are counted per
+// account, Client for the requests quota, which is counted per client IP.
+type quotaWarning struct {
+	Quota     string `json:"quota"`
+	Limit     int64  `json:"limit"`
+	Used      int64  `json:"used"`
+	Remaining int64  `json:"remaining"`
+	Account   string `json:"account,omitempty"`
+	Client    string `json:"client,omitempty"`
+}
+
+// quotaChecker warns about quotas that are used up to warnPercent or more.
+// The quotas are soft, no request is refused because of them.
+type quotaChecker struct {
+	todos          int64
+	storageBytes   int64
+	requestsPerDay int64
+	warnPercent    int64
+	// accountStoredBytes exports the usage read for the accounts
+	accountStoredBytes *prometheus.GaugeVec
+
+	mu     sync.Mutex
+	usages map[string]fetchedUsage
+}
+
+// fetchedUsage is the usage of an account and when it was read.
+type fetchedUsage struct {
+	usage   tododb.Usage
+	fetched time.Time
+}
+
+func quotaMiddleware(options map[string]string, accountStoredBytes *prometheus.GaugeVec) (gin.HandlerFunc, error) {
+	if database == nil {
+		return nil, errors.New("quota needs a backend, it can't run in the frontend role")
+	}
+
+	checker := &quotaChecker{accountStoredBytes: accountStoredBytes, usages: map[string]fetchedUsage{}}
+	limits := []struct {
+		key   string
+		value *int64
+	}{
+		{"todos", &checker.todos},
+		{"storageBytes", &checker.storageBytes},
+		{"requestsPerDay", &checker.requestsPerDay},
+	}
+	for _, limit := range limits {
+		value, err := intOption(options, limit.key, 0)
+		if err != nil {
+			return nil, err
+		}
+		if value < 0 {
+			return nil, fmt.Errorf("%s must not be negative", limit.key)
+		}
+		*limit.value = int64(value)
+	}
+
+	warnPercent, err := intOption(options, "warnPercent", defaultQuotaWarnPercent)
+	if err != nil {
+		return nil, err
+	}
+	if warnPercent < 1 || warnPercent > 100 {
+		return nil, errors.New("warnPercent must be between 1 and 100")
+	}
+	checker.warnPercent = int64(warnPercent)
+
+	return checker.handler, nil
+}
+
+// handler sets X-Quota-Remaining to the quotas close to their limit, like
+// "todos=12, requests=40". The usage is the one of the signed in account
+// before the request, requests that aren't signed in share the todos
+// without owner.
+func (checker *quotaChecker) handler(c *gin.Context) {
+	warnings := []quotaWarning{}
+	if checker.todos > 0 || checker.storageBytes > 0 {
+		account := c.GetString(accountKey)
+		usage, err := checker.currentUsage(c.Request.Context(), account)
+		if err != nil {
+			logger.Warnf("Usage of %q for the quotas: %v", account, err)
+		} else {
+			for _, quota := range checker.usageQuotas(account, usage) {
+				warnings = checker.check(warnings, quota)
+			}
+		}
+	}
+
+	if checker.requestsPerDay > 0 {
+		client := c.ClientIP()
+		used, err := tododb.KVOf(database).IncrValue(quotaRequestsKey(client, time.Now().UTC()), quotaRequestsTTL)
+		if err != nil {
+			logger.Warnf("Counting requests for the quotas: %v", err)
+		} else {
+			warnings = checker.check(warnings, quotaWarning{Quota: "requests", Limit: checker.requestsPerDay, Used: used, Client: client})
+		}
+	}
+
+	if len(warnings) > 0 {
+		remaining := make([]string, len(warnings))
+		for i, warning := range warnings {
+			remaining[i] = fmt.Sprintf("%s=%d", warning.Quota, warning.Remaining)
+			notifyQuota(warning)
+		}
+		c.Header(quotaRemainingHeader, strings.Join(remaining, ", "))
+	}
+
+	c.Next()
+}
+
+// check appends warning if its quota is set and used up to warnPercent.
+func (checker *quotaChecker) check(warnings []quotaWarning, warning quotaWarning) []quotaWarning {
+	if warning.Limit <= 0 || warning.Used*100 < warning.Limit*checker.warnPercent {
+		return warnings
+	}
+
+	warning.Remaining = warning.Limit - warning.Used
+	if warning.Remaining < 0 {
+		warning.Remaining = 0
+	}
+
+	return append(warnings, warning)
+}
+
+// usageQuotas are the todos and storage quotas of account.
+func (checker *quotaChecker) usageQuotas(account string, usage tododb.Usage) []quotaWarning {
+	return []quotaWarning{
+		{Quota: "todos", Limit: checker.todos, Used: usage.Todos, Account: account},
+		{Quota: "storage", Limit: checker.storageBytes, Used: usage.StoredBytes, Account: account},
+	}
+}
+
+// currentUsage reads the usage of account at most every quotaUsageInterval.
+// On a fresh read the notifications of the quotas that are below the
+// threshold again are reset, so they are sent again next time.
+func (checker *quotaChecker) currentUsage(ctx context.Context, account string) (tododb.Usage, error) {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+
+	if cached, exists := checker.usages[account]; exists && time.Since(cached.fetched) < quotaUsageInterval {
+		return cached.usage, nil
+	}
+
+	usage, err := tododb.AccountUsageOf(ctx, database, account)
+	if err != nil {
+		return tododb.Usage{}, err
+	}
+	checker.usages[account] = fetchedUsage{usage: usage, fetched: time.Now()}
+	checker.accountStoredBytes.WithLabelValues(account).Set(float64(usage.StoredBytes))
+
+	kv := tododb.KVOf(database)
+	for _, quota := range checker.usageQuotas(account, usage) {
+		if quota.Limit > 0 && len(checker.check(nil, quota)) == 0 {
+			if err := kv.DeleteValue(quotaNotifiedKey(quota)); err != nil && err != tododb.ErrNotFound {
+				logger.Warnf("Resetting the %s quota notification: %v", quota.Quota, err)
+			}
+		}
+	}
+
+	return usage, nil
+}
+
+func quotaRequestsKey(client string, now time.Time) string {
+	return "quota:requests:" + client + ":" + now.Format(dayFormat)
+}
+
+// quotaNotifiedKey is set once warning was sent. The requests quota is
+// notified once per client and day, the others once per account.
+func quotaNotifiedKey(warning quotaWarning) string {
+	if warning.Client != "" {
+		return "quota:notified:" + warning.Quota + ":" + warning.Client + ":" + time.Now().UTC().Format(dayFormat)
+	}
+
+	return "quota:notified:" + warning.Quota + ":" + warning.Account
+}
+
+// notifyQuota publishes warning as a change, unless it was sent already.
+func notifyQuota(warning quotaWarning) {
+	ttl := time.Duration(0)
+	if warning.Client != "" {
+		ttl = quotaRequestsTTL
+	}
+
+	sent, err := tododb.KVOf(database).IncrValue(quotaNotifiedKey(warning), ttl)
+	if err != nil {
+		logger.Warnf("Notifying the %s quota: %v", warning.Quota, err)
+		return
+	}
+	if sent > 1 {
+		return
+	}
+
+	logger.Warnf("Quota %s: %d of %d used", warning.Quota, warning.Used, warning.Limit)
+	changeFeed.add(change{Type: changeQuota, Quota: &warning})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tokenBucket allows burst requests at once and refills with rate per second.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimitMiddleware limits the requests per client IP. Buckets of clients
+// that were quiet long enough to be full again are dropped.
+func rateLimitMiddleware(options map[string]string) (gin.HandlerFunc, error) {
+	rate, err := floatOption(options, "rps", 10)
+	if err != nil {
+		return nil, err
+	}
+
+	burst, err := intOption(options, "burst", 20)
+	if err != nil {
+		return nil, err
+	}
+
+	if rate <= 0 || burst <= 0 {
+		return nil, errors.New("rps and burst must be positive")
+	}
+
+	var mu sync.Mutex
+	buckets := map[string]*tokenBucket{}
+	lastCleanup := time.Now()
+	refill := time.Duration(float64(burst) / rate * float64(time.Second))
+
+	return func(c *gin.Context) {
+		now := time.Now()
+		ip := c.ClientIP()
+
+		mu.Lock()
+		if now.Sub(lastCleanup) > refill {
+			for client, bucket := range buckets {
+				if now.Sub(bucket.last) > refill {
+					delete(buckets, client)
+				}
+			}
+			lastCleanup = now
+		}
+
+		bucket, exists := buckets[ip]
+		if !exists {
+			bucket = &tokenBucket{tokens: float64(burst), last: now}
+			buckets[ip] = bucket
+		}
+
+		bucket.tokens += now.Sub(bucket.last).Seconds() * rate
+		if bucket.tokens > float64(burst) {
+			bucket.tokens = float64(burst)
+		}
+		bucket.last = now
+
+		allowed := bucket.tokens >= 1
+		if allowed {
+			bucket.tokens--
+		}
+		mu.Unlock()
+
+		if !allowed {
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"errors": "rate limit exceeded",
+			})
+			return
+		}
+
+		c.Next()
+	}, nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	defaultRecurrenceIntervalSeconds = 60
+	// recurrenceClaim is how long a replica may take to materialize the next
+	// occurrence of a todo before another one tries it
+	recurrenceClaim = 10 * time.Minute
+	// cronHorizon bounds the search of the next time of a cron expression,
+	// like "0 0 30 2 *" never matches
+	cronHorizon = 5 * 366 * 24 * time.Hour
+)
+
+// recurrence is a parsed recurrence rule of a todo.
+type recurrence interface {
+	// next returns the first occurrence after after of a todo that was due
+	// at start.
+	next(start, after time.Time) time.Time
+}
+
+// parseRecurrence reads a rule: daily, weekly or a cron expression of five
+// fields, minute, hour, day of month, month and day of week, in local time.
+func parseRecurrence(rule string) (recurrence, error) {
+	switch rule {
+	case "daily":
+		return every(24 * time.Hour), nil
+	case "weekly":
+		return every(7 * 24 * time.Hour), nil
+	}
+
+	fields := strings.Fields(rule)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid recurrence %q, use daily, weekly or a cron expression like \"0 9 * * 1-5\"", rule)
+	}
+	var expr cronExpr
+	for i, field := range cronFields {
+		set, err := field.parse(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid recurrence %q: %s: %v", rule, field.name, err)
+		}
+		*field.of(&expr) = set
+	}
+	// Sunday is 0 and 7
+	if expr.weekdays&(1<<7) != 0 {
+		expr.weekdays |= 1
+	}
+	expr.anyDay = fields[2] == "*"
+	expr.anyWeekday = fields[4] == "*"
+
+	return expr, nil
+}
+
+// every repeats in a fixed interval from the due date, the time of day stays
+// the same.
+type every time.Duration
+
+func (interval every) next(start, after time.Time) time.Time {
+	step := time.Duration(interval)
+	if start.After(after) {
+		return start.Add(step)
+	}
+
+	return start.Add((after.Sub(start)/step + 1) * step)
+}
+
+// cronExpr holds the allowed values of each field as bits.
+type cronExpr struct {
+	minutes, hours, days, months, weekdays uint64
+	// anyDay and anyWeekday are set for a *, if only one of both is
+	// restricted it alone decides, else either matches like in cron
+	anyDay, anyWeekday bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	of       func(*cronExpr) *uint64
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59, func(expr *cronExpr) *uint64 { return &expr.minutes }},
+	{"hour", 0, 23, func(expr *cronExpr) *uint64 { return &expr.hours }},
+	{"day of month", 1, 31, func(expr *cronExpr) *uint64 { return &expr.days }},
+	{"month", 1, 12, func(expr *cronExpr) *uint64 { return &expr.months }},
+	{"day of week", 0, 7, func(expr *cronExpr) *uint64 { return &expr.weekdays }},
+}
+
+// parse reads a comma separated list of *, values and ranges, each with an
+// optional /step.
+func (field cronField) parse(value string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(value, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			part = part[:i]
+		}
+
+		low, high := field.min, field.max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", bounds[0])
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", bounds[1])
+				}
+			} else if step > 1 {
+				high = field.max
+			}
+		}
+		if low < field.min || high > field.max || low > high {
+			return 0, fmt.Errorf("%q is not between %d and %d", part, field.min, field.max)
+		}
+		for v := low; v <= high; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+
+	return set, nil
+}
+
+func (expr cronExpr) matchesDay(t time.Time) bool {
+	day := expr.days&(1<<uint(t.Day())) != 0
+	weekday := expr.weekdays&(1<<uint(t.Weekday())) != 0
+	switch {
+	case expr.anyDay && expr.anyWeekday:
+		return true
+	case expr.anyDay:
+		return weekday
+	case expr.anyWeekday:
+		return day
+	}
+
+	return day || weekday
+}
+
+// next of a cron expression is the first matching minute after both the due
+// date and after. It is the zero time if there is none within cronHorizon.
+func (expr cronExpr) next(start, after time.Time) time.Time {
+	if start.After(after) {
+		after = start
+	}
+	t := after.In(time.Local).Truncate(time.Minute).Add(time.Minute)
+	end := t.Add(cronHorizon)
+	for t.Before(end) {
+		switch {
+		case expr.months&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.Local)
+		case !expr.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.Local)
+		case expr.hours&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.Local)
+		case expr.minutes&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t.UTC()
+		}
+	}
+
+	return time.Time{}
+}
+
+// nextOccurrence returns the todo that follows the completed todo, with its
+// title, description, priority, tags, open sub-tasks, rule and owner, due at the
+// next time of the rule after it was completed. It reports false if the rule
+// is invalid or never occurs again.
+func nextOccurrence(todo tododb.Todo) (tododb.Todo, bool) {
+	rule, err := parseRecurrence(todo.Recurrence)
+	if err != nil {
+		return tododb.Todo{}, false
+	}
+	start := todo.UpdatedAt
+	if todo.Due != nil {
+		start = *todo.Due
+	}
+	due := rule.next(start, todo.UpdatedAt).Truncate(time.Second)
+	if due.IsZero() {
+		return tododb.Todo{}, false
+	}
+
+	next := tododb.NewTodo(todo.Title)
+	next.Description = todo.Description
+	next.Due = &due
+	next.Priority = todo.Priority
+	next.Tags = todo.Tags
+	next.Recurrence = todo.Recurrence
+	next.Owner = todo.Owner
+	for _, subTask := range todo.SubTasks {
+		subTask.Done = false
+		next.SubTasks = append(next.SubTasks, subTask)
+	}
+
+	return next, true
+}
+
+// materializeOccurrences adds the next occurrence of each completed recurring
+// todo and ends the recurrence of the completed one, so it is added once.
+// Replicas claim a todo first, to not add it twice.
+func materializeOccurrences(ctx context.Context) (int, error) {
+	todos, err := database.GetAllTodos(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for _, todo := range todos {
+		if !todo.Done || todo.Recurrence == "" {
+			continue
+		}
+		claims, err := tododb.KVOf(database).IncrValue("recurrence:"+todo.ID, recurrenceClaim)
+		if err != nil {
+			return added, err
+		}
+		if claims > 1 {
+			continue
+		}
+
+		next, ok := nextOccurrence(todo)
+		if ok {
+			if err := database.SaveTodo(ctx, next); err != nil {
+				return added, err
+			}
+			publishChange(changeCreated, next)
+			queueHooks(hookCreate, next)
+			added++
+		} else {
+			log.Printf("Recurrence %q of todo %s ends, it doesn't occur again", todo.Recurrence, todo.ID)
+		}
+		if err := database.SetRecurrence(ctx, todo.ID, ""); err != nil {
+			return added, err
+		}
+		todo.Recurrence = ""
+		publishChange(changeUpdated, todo)
+	}
+	if added > 0 {
+		dropCachedSmartLists()
+	}
+
+	return added, nil
+}
+
+// runRecurrences materializes the next occurrences of the completed
+// recurring todos every interval.
+func runRecurrences(interval time.Duration) {
+	for range time.Tick(interval) {
+		added, err := materializeOccurrences(context.Background())
+		if err != nil {
+			log.Printf("Adding occurrences of recurring todos failed: %v", err)
+		}
+		if added > 0 {
+			log.Printf("Added %d occurrences of recurring todos", added)
+		}
+	}
+}
+
+// recurrenceBadge shows the rule of a recurring todo.
+func recurrenceBadge(todo tododb.Todo) template.HTML {
+	if todo.Recurrence == "" {
+		return ""
+	}
+
+	return template.HTML(fmt.Sprintf(` <span class="label label-info" title="repeats %s">&#x21bb; %s</span>`,
+		template.HTMLEscapeString(todo.Recurrence), template.HTMLEscapeString(todo.Recurrence)))
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+// maxRenderPages caps ?pages= of the fragment, the rows of a response stay
+// within maxRenderPages times the RenderBudget.
+const maxRenderPages = 100
+
+// Done todos stay in the list, struck through. The row carries the title and
+// the description for the edit form.
+var todoRowsTemplate = template.Must(template.New("rows").Funcs(template.FuncMap{"dueBadge": dueBadge, "priorityBadge": priorityBadge, "tagBadges": tagBadges, "subTaskBadge": subTaskBadge, "recurrenceBadge": recurrenceBadge, "attachmentBadge": attachmentBadge}).Parse(`{{range .Todos}}<tr data-id="{{.ID}}" data-title="{{.Title}}" data-description="{{.Description}}" draggable="true"{{if .Done}} class="text-muted"{{end}}><td class="col-xs-8 col-sm-8 col-md-8">{{if .Done}}<s>{{.Title}}</s>{{else}}{{.Title}}{{end}}{{priorityBadge .}}{{dueBadge .}}{{tagBadges .}}{{subTaskBadge .}}{{recurrenceBadge .}}{{attachmentBadge .}}</td><td align="center" class="col-xs-2 col-sm-2 col-md-2"><input type="checkbox" name="doneCheck" value="1" aria-label="Done: {{.Title}}"{{if .Done}} checked{{end}}/></td><td align="center" class="col-xs-2 col-sm-2 col-md-2"><input type="checkbox" name="deleteCheck" value="1" aria-label="Delete: {{.Title}}"/></td></tr>
+{{end}}{{if .Remaining}}<tr class="load-more"><td colspan="3" class="text-center"><button class="btn btn-default btn-sm" data-offset="{{.NextOffset}}">Load more ({{.Remaining}} remaining)</button></td></tr>
+{{end}}`))
+
+// dueBadge labels a todo with its due day, red once it is overdue.
+func dueBadge(todo tododb.Todo) template.HTML {
+	day, ok := dueDay(todo)
+	if !ok {
+		return ""
+	}
+
+	class, text := "label-info", "due "+day
+	if !todo.Done && day < time.Now().Format(dayFormat) {
+		class, text = "label-danger", "overdue "+day
+	}
+
+	// day is only digits and dashes
+	return template.HTML(fmt.Sprintf(` <span class="label %s">%s</span>`, class, text))
+}
+
+type todoRows struct {
+	Todos      []tododb.Todo
+	NextOffset int
+	Remaining  int
+}
+
+// pageTodos cuts limit todos out of the full list, starting at offset. A
+// limit below one takes all of them.
+func pageTodos(todos []tododb.Todo, offset, limit int) todoRows {
+	if offset > len(todos) {
+		offset = len(todos)
+	}
+
+	end := len(todos)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+
+	return todoRows{
+		Todos:      todos[offset:end],
+		NextOffset: end,
+		Remaining:  len(todos) - end,
+	}
+}
+
+// todoPage reads only the rows of a fragment from todos, and the count of
+// the todos behind them, instead of the whole list.
+func todoPage(ctx context.Context, todos tododb.TodoDB, offset, limit int, filters ...tododb.TodoFilter) (todoRows, error) {
+	total, err := todos.CountTodos(ctx, filters...)
+	if err != nil {
+		return todoRows{}, err
+	}
+
+	page, err := todos.GetTodos(ctx, offset, limit, filters...)
+	if err != nil {
+		return todoRows{}, err
+	}
+
+	end := offset + len(page)
+	remaining := total - end
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return todoRows{
+		Todos:      page,
+		NextOffset: end,
+		Remaining:  remaining,
+	}, nil
+}
+
+func todoFragmentHandler(c *gin.Context) {
+	defer lockContentionScenario()()
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("invalid offset: %q", c.Query("offset")),
+		})
+		return
+	}
+	// The UI asks for every page it loaded with "load more" when it
+	// refreshes the list, so the rows stay on screen
+	pages, err := strconv.Atoi(c.DefaultQuery("pages", "1"))
+	if err != nil || pages < 1 || pages > maxRenderPages {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("invalid pages: %q, use 1 to %d", c.Query("pages"), maxRenderPages),
+		})
+		return
+	}
+	limit := appConfig.RenderBudget * pages
+
+	order, ok := orderOf(c)
+	if !ok {
+		return
+	}
+	tag, ok := tagQuery(c)
+	if !ok {
+		return
+	}
+	query, ok := searchQuery(c)
+	if !ok {
+		return
+	}
+
+	var rows todoRows
+	if id := c.Query("smartlist"); id == "" && order.by == sortAdded && limit > 0 {
+		filters := []tododb.TodoFilter{}
+		if tag != "" || query != "" {
+			filters = append(filters, tododb.TodoFilter{Tag: tag, Query: query})
+		}
+		rows, err = todoPage(c.Request.Context(), todosOf(c), offset, limit, filters...)
+	} else {
+		var todos []tododb.Todo
+		if id != "" {
+			list, ok := findSmartList(c, id)
+			if !ok {
+				return
+			}
+			var reader tododb.TodoFilter
+			if reader, err = readerOf(c); err == nil {
+				todos, err = smartListTodos(c.Request.Context(), list, reader)
+			}
+			if tag != "" || query != "" {
+				todos = filterTodos(todos, tododb.TodoFilter{Tag: tag, Query: query})
+			}
+		} else if query != "" || tag != "" {
+			if query != "" {
+				todos, err = todosOf(c).SearchTodos(c.Request.Context(), query)
+				todos = filterTodos(todos, tododb.TodoFilter{Tag: tag})
+			} else {
+				todos, err = todosOf(c).GetTodosByTag(c.Request.Context(), tag)
+			}
+		} else if order.by == sortPriority {
+			todos, err = todosOf(c).GetTodosByPriority(c.Request.Context())
+		} else {
+			todos, err = todosOf(c).GetAllTodos(c.Request.Context())
+		}
+		rows = pageTodos(order.apply(todos), offset, limit)
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := todoRowsTemplate.Execute(&buf, rows); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	writeWithETag(c, "text/html; charset=utf-8", buf.Bytes())
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mcuadros/go-gin-prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// roleAll serves the UI and the API from one process
+	roleAll = "all"
+	// roleFrontend serves the UI and forwards everything else to APIURL
+	roleFrontend = "frontend"
+	// roleAPI serves everything but the UI
+	roleAPI = "api"
+
+	defaultAPIURL         = "http://localhost:3001"
+	frontendHealthTimeout = 5 * time.Second
+)
+
+func checkRole(role string) error {
+	switch role {
+	case roleAll, roleFrontend, roleAPI:
+		return nil
+	}
+
+	return fmt.Errorf("unknown role %q, use %s, %s or %s", role, roleAll, roleFrontend, roleAPI)
+}
+
+// newAPIProxy forwards requests to the api process. All headers are passed
+// on, so a trace started in front of the frontend continues in the api.
+func newAPIProxy(apiURL string) (*httputil.ReverseProxy, error) {
+	target, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, err
+	}
+	if target.Scheme == "" || target.Host == "" {
+		return nil, fmt.Errorf("APIURL %q needs a scheme and a host", apiURL)
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		logger.Errorf("%s %s: %v", req.Method, req.URL.Path, err)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(map[string]string{"errors": err.Error()})
+	}
+
+	return proxy, nil
+}
+
+// frontendHealthHandler reports the frontend itself and whether the api
+// answers its own health check.
+func frontendHealthHandler(apiURL string) gin.HandlerFunc {
+	client := &http.Client{Timeout: frontendHealthTimeout}
+	return func(c *gin.Context) {
+		result := map[string]string{"self": "ok", "api": "ok"}
+
+		req, err := http.NewRequest(http.MethodGet, apiURL+"/health", nil)
+		if err == nil {
+			var resp *http.Response
+			resp, err = client.Do(req.WithContext(c.Request.Context()))
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					err = fmt.Errorf("health check answered with %s", resp.Status)
+				}
+			}
+		}
+		if err != nil {
+			result["api"] = err.Error()
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// runFrontend serves the UI and forwards every other request to the api
+// process, it needs no backend of its own.
+func runFrontend(config *TodoAppConfig, listen string) error {
+	proxy, err := newAPIProxy(config.APIURL)
+	if err != nil {
+		return err
+	}
+
+	p := ginprometheus.NewPrometheus("gin")
+	metrics := NewMetrics()
+	if err := metrics.Register(prometheus.DefaultRegisterer); err != nil {
+		return err
+	}
+
+	latencies := newLatencyRecorder(config.LatencyWindowMinutes)
+	middleware, err := buildMiddleware(middlewareFactories(p, latencies, metrics), config.Middleware, []string{"global", "ops"})
+	if err != nil {
+		return err
+	}
+
+	router := gin.New()
+	router.Use(middleware["global"]...)
+	p.SetMetricsPath(router)
+
+	ops := router.Group("/", middleware["ops"]...)
+	ops.GET("/health", frontendHealthHandler(config.APIURL))
+	ops.GET("/whoami", whoAmIHandler)
+	ops.GET("/version", versionHandler)
+	ops.GET("/debug/latency", latencies.handler)
+
+	if err := serveUI(router, config.Branding); err != nil {
+		return err
+	}
+	router.NoRoute(gin.WrapH(proxy))
+
+	logger.Infof("Serving the frontend on %s, the api is %s", listen, config.APIURL)
+	return router.Run(listen)
+}
@@ -0,0 +1,143 @@
+// Code generated by apigen from package api. DO NOT EDIT.
+
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/api"
+)
+
+// registerRoutes registers the routes of api.Routes with the middleware
+// of their groups. A route with a request schema validates the body first.
+func registerRoutes(router *gin.Engine, middleware map[string][]gin.HandlerFunc) {
+	todoRoutes := router.Group("/", middleware["todo"]...)
+	accountRoutes := router.Group("/", middleware["account"]...)
+	publicRoutes := router.Group("/", middleware["public"]...)
+	integrationsRoutes := router.Group("/", middleware["integrations"]...)
+	adminRoutes := router.Group("/", middleware["admin"]...)
+	accountsRoutes := router.Group("/", middleware["accounts"]...)
+	opsRoutes := router.Group("/", middleware["ops"]...)
+
+	todoRoutes.GET("/todo", readTodoHandler)
+	todoRoutes.GET("/todo/fragment", todoFragmentHandler)
+	todoRoutes.GET("/todo/export", exportTodoHandler)
+	todoRoutes.GET("/todo/print", printTodoHandler)
+	todoRoutes.GET("/basic", basicTodoHandler)
+	todoRoutes.POST("/basic", basicTodoFormHandler)
+	todoRoutes.POST("/import", importTodoHandler)
+	todoRoutes.POST("/todo/:value", insertTodoHandler)
+	todoRoutes.DELETE("/todo/:value", deleteTodoHandler)
+	todoRoutes.GET("/api/v1/todos", listTodosHandler)
+	todoRoutes.PATCH("/api/v1/todos/:id", validateBody(func() api.Validator { return &api.TodoUpdate{} }), updateTodoHandler)
+	todoRoutes.DELETE("/api/v1/todos/:id", deleteTodoByIDHandler)
+	accountRoutes.GET("/api/v1/todos/:id/grants", listGrantsHandler)
+	accountRoutes.PUT("/api/v1/todos/:id/grants/:account", validateBody(func() api.Validator { return &api.GrantRequest{} }), setGrantHandler)
+	accountRoutes.DELETE("/api/v1/todos/:id/grants/:account", deleteGrantHandler)
+	todoRoutes.GET("/api/v1/tags", listTagsHandler)
+	todoRoutes.GET("/api/v1/changes", changesHandler)
+	todoRoutes.POST("/api/v1/todos:action", todoActionHandler)
+	todoRoutes.POST("/api/todos/bulk", validateBody(func() api.Validator { return &api.BulkTodosRequest{} }), bulkTodosHandler)
+	accountRoutes.POST("/api/undo", undoHandler)
+	todoRoutes.GET("/api/v1/checklists/:id", listSubTasksHandler)
+	todoRoutes.POST("/api/v1/checklists/:id", validateBody(func() api.Validator { return &api.NewSubTask{} }), addSubTaskHandler)
+	todoRoutes.POST("/api/v1/checklists/:id/:subtask/complete", completeSubTaskHandler)
+	todoRoutes.GET("/api/v1/attachments/:id", listAttachmentsHandler)
+	todoRoutes.POST("/api/v1/attachments/:id", uploadAttachmentHandler)
+	todoRoutes.GET("/api/v1/attachments/:id/:attachment", downloadAttachmentHandler)
+	todoRoutes.DELETE("/api/v1/attachments/:id/:attachment", deleteAttachmentHandler)
+	todoRoutes.GET("/thumb/:id", thumbnailHandler)
+	todoRoutes.GET("/api/v1/drafts/:id", getDraftHandler)
+	todoRoutes.PUT("/api/v1/drafts/:id", validateBody(func() api.Validator { return &api.Draft{} }), saveDraftHandler)
+	todoRoutes.DELETE("/api/v1/drafts/:id", deleteDraftHandler)
+	todoRoutes.GET("/api/v1/view", getViewHandler)
+	todoRoutes.PUT("/api/v1/view", validateBody(func() api.Validator { return &api.ViewPreference{} }), setViewHandler)
+	todoRoutes.GET("/api/v1/locale", getLocaleHandler)
+	todoRoutes.PUT("/api/v1/locale", validateBody(func() api.Validator { return &api.LocalePreference{} }), setLocaleHandler)
+	todoRoutes.GET("/api/v1/trash", listTrashHandler)
+	todoRoutes.POST("/api/v1/trash/:id/restore", restoreTodoHandler)
+	todoRoutes.POST("/share", createShareHandler)
+	todoRoutes.GET("/share/:id", shareInfoHandler)
+	todoRoutes.DELETE("/share/:id", revokeShareHandler)
+	publicRoutes.GET("/shared/:id", sharedListHandler)
+	todoRoutes.POST("/links", createShortLinkHandler)
+	todoRoutes.GET("/links/:code", shortLinkHandler)
+	todoRoutes.DELETE("/links/:code", deleteShortLinkHandler)
+	publicRoutes.GET("/s/:code", resolveShortLinkHandler)
+	publicRoutes.GET("/board", publicBoardHandler)
+	publicRoutes.GET("/embed/:list", embedTodoHandler)
+	todoRoutes.GET("/api/v1/smartlists", listSmartListsHandler)
+	todoRoutes.PUT("/api/v1/smartlists/:id", setSmartListHandler)
+	todoRoutes.DELETE("/api/v1/smartlists/:id", deleteSmartListHandler)
+	todoRoutes.GET("/api/v1/smartlists/:id/todos", smartListTodosHandler)
+	todoRoutes.GET("/api/v1/dependencies", dependencyGraphHandler)
+	todoRoutes.PUT("/api/v1/dependencies", addDependencyHandler)
+	todoRoutes.DELETE("/api/v1/dependencies", deleteDependencyHandler)
+	todoRoutes.GET("/api/v1/stats", statsHandler)
+	todoRoutes.GET("/api/v1/workload", workloadHandler)
+	todoRoutes.GET("/api/v1/calendar", calendarHandler)
+	todoRoutes.GET("/api/v1/overdue", overdueTodosHandler)
+	todoRoutes.GET("/api/v1/due", dueTodosHandler)
+	todoRoutes.GET("/api/v1/timers", listTimersHandler)
+	todoRoutes.PUT("/api/v1/timers", startTimerHandler)
+	todoRoutes.DELETE("/api/v1/timers", stopTimerHandler)
+	integrationsRoutes.GET("/api/v1/integrations/triggers/new-todo", newTodoTriggerHandler)
+	integrationsRoutes.GET("/api/v1/integrations/triggers/completed-todo", completedTodoTriggerHandler)
+	integrationsRoutes.POST("/api/v1/integrations/actions/create-todo", createTodoActionHandler)
+	adminRoutes.DELETE("/admin/todos", forbidInDemoMode(), deleteAllTodosHandler)
+	adminRoutes.DELETE("/admin/trash", purgeTrashHandler)
+	adminRoutes.GET("/admin/seed", seedProfilesHandler)
+	adminRoutes.POST("/admin/seed", seedHandler)
+	adminRoutes.GET("/admin/features", listFeaturesHandler)
+	adminRoutes.PUT("/admin/features/:name", setFeatureHandler)
+	adminRoutes.GET("/admin/logs", logsHandler)
+	adminRoutes.GET("/admin/loglevel", getLogLevelHandler)
+	adminRoutes.PUT("/admin/loglevel", setLogLevelHandler)
+	adminRoutes.GET("/admin/slowlog", slowLogHandler)
+	adminRoutes.POST("/admin/drills/failover", forbidInDemoMode(), failoverDrillHandler)
+	adminRoutes.GET("/admin/board", getBoardHandler)
+	adminRoutes.PUT("/admin/board", setBoardHandler)
+	adminRoutes.GET("/admin/digest", previewDigestHandler)
+	adminRoutes.POST("/admin/digest", sendDigestHandler)
+	adminRoutes.GET("/admin/telemetry", telemetryPreviewHandler)
+	adminRoutes.GET("/admin/sbom", sbomHandler)
+	adminRoutes.POST("/admin/accounts", createAccountHandler)
+	adminRoutes.GET("/admin/accounts/:name", getAccountHandler)
+	adminRoutes.POST("/admin/accounts/:name/disable", setAccountDisabledHandler(true))
+	adminRoutes.POST("/admin/accounts/:name/enable", setAccountDisabledHandler(false))
+	adminRoutes.DELETE("/admin/accounts/:name/2fa", resetTwoFactorHandler)
+	adminRoutes.GET("/admin/accounts/:name/sessions", accountSessionsHandler)
+	adminRoutes.DELETE("/admin/accounts/:name/sessions", revokeAccountSessionsHandler)
+	adminRoutes.DELETE("/admin/accounts/:name/lockout", unlockAccountHandler)
+	adminRoutes.DELETE("/admin/lockouts/:ip", unlockIPHandler)
+	adminRoutes.GET("/admin/audit", auditLogHandler)
+	adminRoutes.POST("/admin/jwt/rotate", rotateSigningKeyHandler)
+	adminRoutes.GET("/admin/hooks", listHooksHandler)
+	adminRoutes.PUT("/admin/hooks/:name", uploadHookHandler)
+	adminRoutes.DELETE("/admin/hooks/:name", deleteHookHandler)
+	accountsRoutes.POST("/api/v1/accounts", registerAccountHandler)
+	accountsRoutes.POST("/api/v1/password-resets", requestPasswordResetHandler)
+	accountsRoutes.PUT("/api/v1/password-resets/:token", resetPasswordHandler)
+	accountsRoutes.POST("/api/v1/unlocks/:token", unlockWithTokenHandler)
+	accountsRoutes.POST("/api/v1/tokens/refresh", validateBody(func() api.Validator { return &api.RefreshTokens{} }), refreshTokensHandler)
+	accountRoutes.GET("/api/v1/account", ownAccountHandler)
+	accountRoutes.GET("/api/v1/account/usage", accountUsageHandler)
+	accountRoutes.POST("/api/v1/account/2fa", enrollTwoFactorHandler)
+	accountRoutes.POST("/api/v1/account/2fa/confirm", confirmTwoFactorHandler)
+	accountRoutes.POST("/api/v1/account/2fa/recovery-codes", newRecoveryCodesHandler)
+	accountRoutes.DELETE("/api/v1/account/2fa", disableTwoFactorHandler)
+	accountRoutes.POST("/api/v1/sessions", createSessionHandler)
+	accountRoutes.GET("/api/v1/sessions", listSessionsHandler)
+	accountRoutes.DELETE("/api/v1/sessions", revokeSessionsHandler)
+	accountRoutes.DELETE("/api/v1/sessions/:id", revokeSessionHandler)
+	accountRoutes.POST("/api/v1/tokens", createTokensHandler)
+	accountRoutes.GET("/api/v1/granted/todos", listSharedTodosHandler)
+	accountRoutes.GET("/api/v1/granted/todos/:id", getSharedTodoHandler)
+	accountRoutes.PATCH("/api/v1/granted/todos/:id", validateBody(func() api.Validator { return &api.SharedTodoUpdate{} }), updateSharedTodoHandler)
+	opsRoutes.GET("/usage", usageHandler)
+	opsRoutes.GET("/health", healthCheckHandler)
+	opsRoutes.GET("/whoami", whoAmIHandler)
+	opsRoutes.GET("/version", versionHandler)
+	opsRoutes.GET("/qr", qrHandler)
+	opsRoutes.GET("/.well-known/jwks.json", jwksHandler)
+	opsRoutes.GET("/api/v1/debug/self", selfHandler)
+}
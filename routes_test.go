@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	testPassword       = "correct horse battery"
+	testAdminToken     = "admin-token"
+	testIntegrationKey = "zapier-key"
+)
+
+// testDB is the memory backend with a KV of its own, so accounts can be
+// created and every test starts without the values of the others.
+type testDB struct {
+	*tododb.MemoryDB
+	*tododb.MemoryKV
+}
+
+// testServer serves the routes of api.Routes with the default middleware
+// of the groups, on an empty testDB.
+type testServer struct {
+	t      *testing.T
+	router *gin.Engine
+}
+
+// authorization signs a request of a testServer in.
+type authorization func(*http.Request)
+
+func anonymous(*http.Request) {}
+
+func asAccount(name string) authorization {
+	return func(req *http.Request) {
+		req.SetBasicAuth(name, testPassword)
+	}
+}
+
+func withToken(token string) authorization {
+	return func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+var asAdmin = withToken(testAdminToken)
+
+func newTestServer(t *testing.T) (*testServer, func()) {
+	t.Helper()
+	memory, err := tododb.NewMemoryDB(map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previousDB, previousConfig := database, appConfig
+	database = testDB{MemoryDB: memory, MemoryKV: tododb.NewMemoryKV()}
+	appConfig = &TodoAppConfig{
+		AdminToken:        testAdminToken,
+		Integrations:      map[string]string{"zapier": testIntegrationKey},
+		TrashDays:         defaultTrashDays,
+		UndoDepth:         defaultUndoDepth,
+		BoardCacheSeconds: defaultBoardCacheSeconds,
+		Accounts: AccountsConfig{
+			MinPasswordLength:  defaultMinPasswordLength,
+			ResetTokenMinutes:  defaultResetTokenMinutes,
+			SessionDays:        defaultSessionDays,
+			AccessTokenMinutes: defaultAccessTokenMinutes,
+			SigningKeyDays:     defaultSigningKeyDays,
+			// cheap hashes, the tests sign in a lot
+			Argon2: Argon2Config{MemoryKiB: 64, Iterations: 1, Parallelism: 1},
+		},
+	}
+	// the keys of an earlier test are gone with its KV
+	signingKeys.set(nil)
+
+	metrics := NewMetrics()
+	factories := map[string]middlewareFactory{
+		"adminAuth":       fixedMiddleware(adminAuth()),
+		"integrationAuth": fixedMiddleware(integrationAuth()),
+		"accountAuth": func(options map[string]string) (gin.HandlerFunc, error) {
+			return accountAuthMiddleware(options, metrics.loginFailuresTotal, metrics.lockoutsTotal)
+		},
+	}
+	middleware, err := buildMiddleware(factories, map[string][]MiddlewareConfig{"global": {}}, middlewareGroups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	registerRoutes(router, middleware)
+
+	return &testServer{t: t, router: router}, func() {
+		database, appConfig = previousDB, previousConfig
+	}
+}
+
+// serve sends a request with body as JSON, unless it is nil.
+func (server *testServer) serve(method, path string, authorize authorization, body interface{}) *httptest.ResponseRecorder {
+	server.t.Helper()
+	var content []byte
+	if body != nil {
+		var err error
+		if content, err = json.Marshal(body); err != nil {
+			server.t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(content))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	authorize(req)
+	recorder := httptest.NewRecorder()
+	server.router.ServeHTTP(recorder, req)
+
+	return recorder
+}
+
+// expect sends a request like serve and fails the test unless it is
+// answered with status. The answer is decoded into result, unless it is nil.
+func (server *testServer) expect(status int, method, path string, authorize authorization, body, result interface{}) {
+	server.t.Helper()
+	recorder := server.serve(method, path, authorize, body)
+	if recorder.Code != status {
+		server.t.Fatalf("%s %s answered %d, want %d: %s", method, path, recorder.Code, status, recorder.Body)
+	}
+	if result != nil {
+		if err := json.Unmarshal(recorder.Body.Bytes(), result); err != nil {
+			server.t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+}
+
+// createAccount creates an account with testPassword as the admin.
+func (server *testServer) createAccount(name string) {
+	server.t.Helper()
+	server.expect(http.StatusCreated, "POST", "/admin/accounts", asAdmin, gin.H{"name": name, "password": testPassword}, nil)
+}
+
+// createTodo creates a todo as authorize and returns it.
+func (server *testServer) createTodo(authorize authorization, title string) tododb.Todo {
+	server.t.Helper()
+	var created struct {
+		Created []tododb.Todo `json:"created"`
+	}
+	server.expect(http.StatusOK, "POST", "/api/todos/bulk", authorize, gin.H{"create": []string{title}}, &created)
+	if len(created.Created) != 1 {
+		server.t.Fatalf("created %d todos, want 1", len(created.Created))
+	}
+
+	return created.Created[0]
+}
+
+// grant shares the todo with id with account as owner.
+func (server *testServer) grant(owner authorization, id, account, permission string) {
+	server.t.Helper()
+	server.expect(http.StatusOK, "PUT", "/api/v1/todos/"+id+"/grants/"+account, owner, gin.H{"permission": permission}, nil)
+}
+
+// todos lists the todos authorize may read.
+func (server *testServer) todos(authorize authorization) []tododb.Todo {
+	server.t.Helper()
+	var todos []tododb.Todo
+	server.expect(http.StatusOK, "GET", "/api/v1/todos", authorize, nil, &todos)
+
+	return todos
+}
+
+// grantedTodo is a sharedTodo as far as the tests read it, the embedded
+// todo would decode the whole answer.
+type grantedTodo struct {
+	ID         string `json:"id"`
+	Permission string `json:"permission"`
+}
+
+// findTodoIn returns the todo with id of todos.
+func findTodoIn(todos []tododb.Todo, id string) (tododb.Todo, bool) {
+	for _, todo := range todos {
+		if todo.ID == id {
+			return todo, true
+		}
+	}
+
+	return tododb.Todo{}, false
+}
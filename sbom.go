@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/buildinfo"
+)
+
+type cycloneDXComponent struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	PURL    string `json:"purl"`
+}
+
+// cycloneDXComponentOf uses the replacement of a replaced module, that is
+// what was built.
+func cycloneDXComponentOf(kind string, module buildinfo.Module) cycloneDXComponent {
+	if module.Replace != nil {
+		module = *module.Replace
+	}
+
+	return cycloneDXComponent{
+		Type:    kind,
+		Name:    module.Path,
+		Version: module.Version,
+		PURL:    "pkg:golang/" + module.Path + "@" + module.Version,
+	}
+}
+
+// cycloneDX turns the modules into a minimal CycloneDX SBOM, which scanners
+// like Trivy or Grype read.
+func cycloneDX(modules buildinfo.Modules) gin.H {
+	main := cycloneDXComponentOf("application", modules.Main)
+	if main.Version == "" || main.Version == "(devel)" {
+		main.Version = buildinfo.Version
+		main.PURL = "pkg:golang/" + main.Name + "@" + main.Version
+	}
+
+	components := make([]cycloneDXComponent, 0, len(modules.Dependencies))
+	for _, dep := range modules.Dependencies {
+		components = append(components, cycloneDXComponentOf("library", dep))
+	}
+
+	return gin.H{
+		"bomFormat":   "CycloneDX",
+		"specVersion": "1.5",
+		"version":     1,
+		"metadata": gin.H{
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+			"component": main,
+		},
+		"components": components,
+	}
+}
+
+// sbomHandler returns the module versions embedded in the binary, with
+// ?format=cyclonedx as CycloneDX SBOM.
+func sbomHandler(c *gin.Context) {
+	modules, ok := buildinfo.GetModules()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": "the binary was built without module information",
+		})
+		return
+	}
+
+	if c.Query("format") == "cyclonedx" {
+		c.JSON(http.StatusOK, cycloneDX(modules))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"build":   buildinfo.Get(),
+		"modules": modules,
+	})
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultScanTimeoutSeconds = 30
+	defaultICAPService        = "avscan"
+	// clamdChunkBytes is the size of the chunks streamed to clamd, well
+	// below its StreamMaxLength
+	clamdChunkBytes = 64 << 10
+)
+
+// The scan outcomes of an attachment, empty if it wasn't scanned.
+const (
+	scanClean       = "clean"
+	scanQuarantined = "quarantined"
+)
+
+// ScanConfig scans uploaded attachments for malware with clamd or an ICAP
+// server. Without Scanner uploads aren't scanned.
+type ScanConfig struct {
+	// Scanner is clamd or icap
+	Scanner string
+	// Address is the host:port of clamd or the ICAP server, like
+	// clamav:3310 or c-icap:1344
+	Address string
+	// Service is the ICAP service, avscan by default
+	Service string
+	// TimeoutSeconds is how long a scan can take, 30 by default
+	TimeoutSeconds int
+}
+
+// scanner checks the content of an attachment. It returns the name of the
+// threat found, empty if the content is clean.
+type scanner interface {
+	Scan(ctx context.Context, content []byte) (string, error)
+}
+
+// attachmentScanner scans the uploads, nil if scanning is off.
+var attachmentScanner scanner
+
+// newScanner creates the scanner of config, nil without one.
+func newScanner(config ScanConfig) (scanner, error) {
+	timeout := time.Duration(config.TimeoutSeconds) * time.Second
+	if config.TimeoutSeconds <= 0 {
+		timeout = defaultScanTimeoutSeconds * time.Second
+	}
+
+	switch strings.ToLower(config.Scanner) {
+	case "":
+		return nil, nil
+	case "clamd":
+		if config.Address == "" {
+			return nil, errors.New("Attachments.Scan: clamd needs an Address")
+		}
+		return clamdScanner{address: config.Address, timeout: timeout}, nil
+	case "icap":
+		if config.Address == "" {
+			return nil, errors.New("Attachments.Scan: icap needs an Address")
+		}
+		service := config.Service
+		if service == "" {
+			service = defaultICAPService
+		}
+		return icapScanner{address: config.Address, service: strings.TrimPrefix(service, "/"), timeout: timeout}, nil
+	}
+
+	return nil, fmt.Errorf("Attachments.Scan: %s is not supported, use clamd or icap", config.Scanner)
+}
+
+// dialScanner connects to address, the connection gives up at the deadline
+// of ctx or after timeout.
+func dialScanner(ctx context.Context, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	return conn, nil
+}
+
+// clamdScanner streams the content to clamd with the INSTREAM command.
+type clamdScanner struct {
+	address string
+	timeout time.Duration
+}
+
+func (scanner clamdScanner) Scan(ctx context.Context, content []byte) (string, error) {
+	conn, err := dialScanner(ctx, scanner.address, scanner.timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	writer := bufio.NewWriter(conn)
+	writer.WriteString("zINSTREAM\x00")
+	for len(content) > 0 {
+		chunk := content
+		if len(chunk) > clamdChunkBytes {
+			chunk = chunk[:clamdChunkBytes]
+		}
+		binary.Write(writer, binary.BigEndian, uint32(len(chunk)))
+		writer.Write(chunk)
+		content = content[len(chunk):]
+	}
+	binary.Write(writer, binary.BigEndian, uint32(0))
+	if err := writer.Flush(); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return parseClamdReply(reply)
+}
+
+// parseClamdReply reads the answer to INSTREAM, "stream: OK" or
+// "stream: <threat> FOUND".
+func parseClamdReply(reply string) (string, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	result := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	}
+
+	return "", fmt.Errorf("clamd: %s", reply)
+}
+
+// icapScanner sends the content as the body of an HTTP response with
+// RESPMOD (RFC 3507). 204 means unmodified and clean, anything the server
+// changed is a threat.
+type icapScanner struct {
+	address string
+	service string
+	timeout time.Duration
+}
+
+func (scanner icapScanner) Scan(ctx context.Context, content []byte) (string, error) {
+	conn, err := dialScanner(ctx, scanner.address, scanner.timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	header := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nContent-Length: %d\r\n\r\n", len(content))
+	var request bytes.Buffer
+	fmt.Fprintf(&request, "RESPMOD icap://%s/%s ICAP/1.0\r\n", scanner.address, scanner.service)
+	fmt.Fprintf(&request, "Host: %s\r\n", scanner.address)
+	request.WriteString("Allow: 204\r\n")
+	fmt.Fprintf(&request, "Encapsulated: res-hdr=0, res-body=%d\r\n\r\n", len(header))
+	request.WriteString(header)
+	if len(content) > 0 {
+		fmt.Fprintf(&request, "%x\r\n", len(content))
+		request.Write(content)
+		request.WriteString("\r\n")
+	}
+	request.WriteString("0\r\n\r\n")
+	if _, err := conn.Write(request.Bytes()); err != nil {
+		return "", err
+	}
+
+	return readICAPResponse(bufio.NewReader(conn))
+}
+
+// readICAPResponse reads the status and headers of an answer to RESPMOD.
+// Servers name the threat in X-Infection-Found, X-Virus-ID or
+// X-Violations-Found.
+func readICAPResponse(reader *bufio.Reader) (string, error) {
+	text := textproto.NewReader(reader)
+	status, err := text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	parts := strings.SplitN(status, " ", 3)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "ICAP/") {
+		return "", fmt.Errorf("icap: invalid status line %q", status)
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("icap: invalid status line %q", status)
+	}
+	header, err := text.ReadMIMEHeader()
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case code == 204:
+		return "", nil
+	case code != 200:
+		return "", fmt.Errorf("icap: %s", status)
+	}
+	if found := header.Get("X-Infection-Found"); found != "" {
+		for _, field := range strings.Split(found, ";") {
+			field = strings.TrimSpace(field)
+			if strings.HasPrefix(field, "Threat=") {
+				return strings.TrimPrefix(field, "Threat="), nil
+			}
+		}
+		return found, nil
+	}
+	for _, name := range []string{"X-Virus-ID", "X-Violations-Found"} {
+		if threat := header.Get(name); threat != "" {
+			return threat, nil
+		}
+	}
+
+	return "unknown threat", nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseClamdReply(t *testing.T) {
+	tests := []struct {
+		reply  string
+		threat string
+		err    bool
+	}{
+		{reply: "stream: OK\x00", threat: ""},
+		{reply: "stream: Eicar-Test-Signature FOUND\x00", threat: "Eicar-Test-Signature"},
+		{reply: "stream: Win.Test.EICAR_HDB-1 FOUND\n", threat: "Win.Test.EICAR_HDB-1"},
+		{reply: "INSTREAM size limit exceeded. ERROR\x00", err: true},
+		{reply: "", err: true},
+	}
+
+	for _, test := range tests {
+		threat, err := parseClamdReply(test.reply)
+		if (err != nil) != test.err || threat != test.threat {
+			t.Errorf("parseClamdReply(%q) = %q, %v, want %q, error %v", test.reply, threat, err, test.threat, test.err)
+		}
+	}
+}
+
+func TestReadICAPResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		threat   string
+		err      bool
+	}{
+		{name: "unmodified", response: "ICAP/1.0 204 No Content\r\nISTag: \"1\"\r\n\r\n"},
+		{
+			name:     "c-icap",
+			response: "ICAP/1.0 200 OK\r\nX-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Test-Signature;\r\n\r\n",
+			threat:   "Eicar-Test-Signature",
+		},
+		{name: "infection without threat", response: "ICAP/1.0 200 OK\r\nX-Infection-Found: Type=0\r\n\r\n", threat: "Type=0"},
+		{name: "virus id", response: "ICAP/1.0 200 OK\r\nX-Virus-ID: EICAR\r\n\r\n", threat: "EICAR"},
+		{name: "violations", response: "ICAP/1.0 200 OK\r\nX-Violations-Found: 1\r\n\r\n", threat: "1"},
+		{name: "modified", response: "ICAP/1.0 200 OK\r\n\r\n", threat: "unknown threat"},
+		{name: "error", response: "ICAP/1.0 500 Server Error\r\n\r\n", err: true},
+		{name: "not icap", response: "HTTP/1.1 200 OK\r\n\r\n", err: true},
+		{name: "bad status", response: "ICAP/1.0 OK\r\n\r\n", err: true},
+		{name: "empty", response: "", err: true},
+	}
+
+	for _, test := range tests {
+		threat, err := readICAPResponse(bufio.NewReader(strings.NewReader(test.response)))
+		if (err != nil) != test.err || threat != test.threat {
+			t.Errorf("%s: readICAPResponse() = %q, %v, want %q, error %v", test.name, threat, err, test.threat, test.err)
+		}
+	}
+}
+
+// fakeClamd answers one INSTREAM with reply and sends the streamed content
+// to received.
+func fakeClamd(t *testing.T, reply string, received chan<- []byte) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		command, _ := reader.ReadString(0)
+		var content bytes.Buffer
+		for command == "zINSTREAM\x00" {
+			var length uint32
+			if binary.Read(reader, binary.BigEndian, &length) != nil || length == 0 {
+				break
+			}
+			io.CopyN(&content, reader, int64(length))
+		}
+		received <- content.Bytes()
+		conn.Write([]byte(reply))
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestClamdScanner(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), clamdChunkBytes*2+10)
+	received := make(chan []byte, 1)
+	address := fakeClamd(t, "stream: Eicar-Test-Signature FOUND\x00", received)
+
+	threat, err := clamdScanner{address: address, timeout: time.Second}.Scan(context.Background(), content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if threat != "Eicar-Test-Signature" {
+		t.Errorf("Scan() = %q, want Eicar-Test-Signature", threat)
+	}
+	if got := <-received; !bytes.Equal(got, content) {
+		t.Errorf("clamd received %d bytes, want %d", len(got), len(content))
+	}
+}
+
+func TestNewScanner(t *testing.T) {
+	tests := []struct {
+		config ScanConfig
+		want   scanner
+		err    bool
+	}{
+		{config: ScanConfig{}},
+		{config: ScanConfig{Scanner: "clamd", Address: "clamav:3310"}, want: clamdScanner{address: "clamav:3310", timeout: 30 * time.Second}},
+		{config: ScanConfig{Scanner: "ICAP", Address: "c-icap:1344", Service: "/srv_clamav", TimeoutSeconds: 5}, want: icapScanner{address: "c-icap:1344", service: "srv_clamav", timeout: 5 * time.Second}},
+		{config: ScanConfig{Scanner: "icap", Address: "c-icap:1344"}, want: icapScanner{address: "c-icap:1344", service: "avscan", timeout: 30 * time.Second}},
+		{config: ScanConfig{Scanner: "clamd"}, err: true},
+		{config: ScanConfig{Scanner: "icap"}, err: true},
+		{config: ScanConfig{Scanner: "virustotal", Address: "x"}, err: true},
+	}
+
+	for _, test := range tests {
+		got, err := newScanner(test.config)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("newScanner(%+v) = %+v, %v, want %+v", test.config, got, err, test.want)
+		}
+	}
+}
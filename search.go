@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+// searchQuery returns the query of ?q=, it answers the request itself if
+// the query has no words to search for.
+func searchQuery(c *gin.Context) (string, bool) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		return "", true
+	}
+	if len(tododb.SearchWords(query)) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": tododb.ErrEmptyQuery.Error() + ", words need at least two letters or digits",
+		})
+		return "", false
+	}
+
+	return query, true
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/seed"
+)
+
+const defaultSeedNumber = 1
+
+// seedTodos generates the todos of a seed profile and appends them, or
+// replaces the whole list with them.
+func seedTodos(ctx context.Context, profileName string, seedNumber int64, replace bool) (int, error) {
+	profile, err := seed.Get(profileName)
+	if err != nil {
+		return 0, err
+	}
+
+	todos := profile.Generate(seedNumber, time.Now())
+	if replace {
+		if err := database.ReplaceAllTodos(ctx, newTodos(todos)); err != nil {
+			return 0, err
+		}
+		publishChange(changeReset)
+		return len(todos), nil
+	}
+
+	for start := 0; start < len(todos); start += ingestBatchSize {
+		end := start + ingestBatchSize
+		if end > len(todos) {
+			end = len(todos)
+		}
+
+		batch := newTodos(todos[start:end])
+		if err := database.SaveTodos(ctx, batch); err != nil {
+			return start, err
+		}
+		publishChange(changeCreated, batch...)
+	}
+
+	return len(todos), nil
+}
+
+func seedProfilesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, seed.Profiles())
+}
+
+func seedHandler(c *gin.Context) {
+	seedNumber, err := strconv.ParseInt(c.DefaultQuery("seed", strconv.Itoa(defaultSeedNumber)), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("invalid seed: %q", c.Query("seed")),
+		})
+		return
+	}
+
+	if _, err := seed.Get(c.Query("profile")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	replace := c.Query("replace") == "true"
+	if replace && appConfig.Demo.Enabled {
+		c.JSON(http.StatusForbidden, gin.H{
+			"errors": "replacing the todos is not available in demo mode",
+		})
+		return
+	}
+
+	seeded, err := seedTodos(c.Request.Context(), c.Query("profile"), seedNumber, replace)
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+			"seeded": seeded,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"seeded": seeded,
+	})
+}
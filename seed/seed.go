@@ -0,0 +1,133 @@
+package seed
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Profile describes a generated dataset. Todos are plain text for now, so
+// tags, due dates and assignees are rendered into the title.
+type Profile struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Count       int     `json:"count"`
+	Tagged      bool    `json:"tagged"`
+	Overdue     float64 `json:"overdue"`
+	Users       int     `json:"users"`
+}
+
+var profiles = map[string]Profile{
+	"small":         {Name: "small", Description: "10 todos", Count: 10},
+	"medium":        {Name: "medium", Description: "200 todos", Count: 200},
+	"huge":          {Name: "huge", Description: "20000 todos", Count: 20000},
+	"tagged":        {Name: "tagged", Description: "100 todos with #tags", Count: 100, Tagged: true},
+	"overdue-heavy": {Name: "overdue-heavy", Description: "100 todos, 80% of them overdue", Count: 100, Overdue: 0.8},
+	"multi-user":    {Name: "multi-user", Description: "150 todos assigned to 8 people", Count: 150, Users: 8, Tagged: true},
+}
+
+var (
+	verbs = []string{
+		"Buy", "Call", "Email", "Fix", "Review", "Plan", "Book", "Clean", "Write",
+		"Update", "Return", "Schedule", "Prepare", "Renew", "Pay", "Order", "Water",
+		"Refactor", "Deploy", "Test", "Read", "Cancel", "Organize", "Pick up",
+	}
+	objects = []string{
+		"groceries", "the dentist", "the landlord", "the bike", "pull request",
+		"team offsite", "train tickets", "the garage", "blog post", "passport",
+		"electricity bill", "new headphones", "the plants", "helm chart",
+		"staging cluster", "load test", "book club novel", "gym membership",
+		"tax return", "birthday present", "conference talk", "Redis upgrade",
+		"parcel", "car insurance", "holiday photos", "quarterly report",
+	}
+	contexts = []string{
+		"", "", "", "before Friday", "for mom", "for the workshop", "again",
+		"with Sam", "after lunch", "tomorrow morning", "this weekend", "asap",
+	}
+	tags = []string{
+		"home", "work", "errand", "urgent", "someday", "finance", "health",
+		"k8s", "demo", "family",
+	}
+	firstNames = []string{
+		"alice", "bob", "carol", "dave", "erin", "frank", "grace", "heidi",
+		"ivan", "judy", "mallory", "niaj", "olivia", "peggy", "rupert", "sybil",
+	}
+)
+
+func Get(name string) (Profile, error) {
+	profile, exists := profiles[name]
+	if !exists {
+		return Profile{}, fmt.Errorf("unknown seed profile %q, available: %s", name, strings.Join(Names(), ", "))
+	}
+
+	return profile, nil
+}
+
+func Names() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func Profiles() []Profile {
+	list := make([]Profile, 0, len(profiles))
+	for _, name := range Names() {
+		list = append(list, profiles[name])
+	}
+
+	return list
+}
+
+// Generate creates the todos of profile. The same seed always yields the
+// same todos relative to now, so workshop setups can be repeated.
+func (profile Profile) Generate(seed int64, now time.Time) []string {
+	rnd := rand.New(rand.NewSource(seed))
+	todos := make([]string, 0, profile.Count)
+	for i := 0; i < profile.Count; i++ {
+		todos = append(todos, profile.todo(rnd, now))
+	}
+
+	return todos
+}
+
+func (profile Profile) todo(rnd *rand.Rand, now time.Time) string {
+	parts := []string{}
+	if profile.Users > 0 {
+		users := profile.Users
+		if users > len(firstNames) {
+			users = len(firstNames)
+		}
+		parts = append(parts, "@"+firstNames[rnd.Intn(users)]+":")
+	}
+
+	parts = append(parts, pick(rnd, verbs), pick(rnd, objects))
+	if context := pick(rnd, contexts); context != "" {
+		parts = append(parts, context)
+	}
+
+	if profile.Overdue > 0 {
+		days := rnd.Intn(14) + 1
+		if rnd.Float64() >= profile.Overdue {
+			days = -days
+		}
+		parts = append(parts, "(due "+now.AddDate(0, 0, -days).Format("2006-01-02")+")")
+	}
+
+	if profile.Tagged {
+		for _, i := range rnd.Perm(len(tags))[:rnd.Intn(3)+1] {
+			parts = append(parts, "#"+tags[i])
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func pick(rnd *rand.Rand, words []string) string {
+	return words[rnd.Intn(len(words))]
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/johscheuer/todo-app-web/features"
+	"github.com/johscheuer/todo-app-web/logging"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const defaultSelfErrors = 20
+
+var startTime = time.Now()
+
+// restarts is how often the instance started before under the same
+// hostname, -1 if it couldn't be counted. With the in memory KV of backends
+// that don't store values it is always 0.
+var restarts int64 = -1
+
+func countStart() {
+	hostname, _ := os.Hostname()
+	starts, err := tododb.KVOf(database).IncrValue("starts:"+hostname, 0)
+	if err != nil {
+		logger.Warnf("Counting starts: %v", err)
+		return
+	}
+
+	restarts = starts - 1
+}
+
+type selfDiagnostics struct {
+	Hostname          string          `json:"hostname"`
+	StartedAt         time.Time       `json:"startedAt"`
+	UptimeSeconds     int64           `json:"uptimeSeconds"`
+	Restarts          int64           `json:"restarts"`
+	Build             buildinfo.Info  `json:"build"`
+	ConfigFingerprint string          `json:"configFingerprint"`
+	Profile           string          `json:"profile,omitempty"`
+	Backend           string          `json:"backend"`
+	Features          []string        `json:"features"`
+	Errors            []logging.Entry `json:"errors"`
+}
+
+// configFingerprint tells apart instances running with different configs
+// without revealing secrets like AdminToken.
+func configFingerprint(config *TodoAppConfig) (string, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:8]), nil
+}
+
+// selfHandler sums up the state of the instance for triage, errors limits
+// the number of recent error log entries.
+func selfHandler(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("errors", strconv.Itoa(defaultSelfErrors)))
+	if err != nil || limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("invalid errors: %q", c.Query("errors")),
+		})
+		return
+	}
+
+	fingerprint, err := configFingerprint(appConfig)
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	enabled := []string{}
+	for _, flag := range features.List() {
+		if flag.Enabled {
+			enabled = append(enabled, flag.Name)
+		}
+	}
+
+	recent := []logging.Entry{}
+	if limit > 0 {
+		recent = logging.Entries(func(entry logging.Entry) bool {
+			return entry.Level >= logging.Error
+		}, limit)
+	}
+
+	hostname, _ := os.Hostname()
+	c.JSON(http.StatusOK, selfDiagnostics{
+		Hostname:          hostname,
+		StartedAt:         startTime,
+		UptimeSeconds:     int64(time.Since(startTime).Seconds()),
+		Restarts:          restarts,
+		Build:             buildinfo.Get(),
+		ConfigFingerprint: fingerprint,
+		Profile:           appConfig.Profile,
+		Backend:           appConfig.Backend,
+		Features:          enabled,
+		Errors:            recent,
+	})
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	// sessionKey holds the id of the session a request is signed in with
+	sessionKey = "session"
+	// sessionTouchInterval is how often the last seen time of a session is
+	// written, not on every request
+	sessionTouchInterval = time.Minute
+)
+
+// session is a sign in of an account on one device, used with its token as
+// bearer token instead of the password. The ID is derived from the token,
+// the token itself is never stored. Revoking all sessions of an account
+// bumps its generation, sessions of older generations are rejected even if
+// they are missing in the index.
+type session struct {
+	ID         string    `json:"id"`
+	Account    string    `json:"account"`
+	Device     string    `json:"device"`
+	IP         string    `json:"ip"`
+	Created    time.Time `json:"created"`
+	LastSeen   time.Time `json:"lastSeen"`
+	Generation int64     `json:"generation"`
+}
+
+// sessionIndexMu serializes the changes to the session lists of the
+// accounts, the lists are read, changed and written back.
+var sessionIndexMu sync.Mutex
+
+func sessionIDOf(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:16])
+}
+
+func sessionKeyOf(id string) string {
+	return "session:" + id
+}
+
+func sessionIndexKey(account string) string {
+	return "sessions:" + account
+}
+
+func sessionGenerationKey(account string) string {
+	return "sessions:generation:" + account
+}
+
+func sessionTTL() time.Duration {
+	return time.Duration(appConfig.Accounts.SessionDays) * 24 * time.Hour
+}
+
+func loadSession(id string) (session, error) {
+	var s session
+	value, err := tododb.KVOf(database).GetValue(sessionKeyOf(id))
+	if err != nil {
+		return s, err
+	}
+
+	err = json.Unmarshal([]byte(value), &s)
+	return s, err
+}
+
+func saveSession(s session) error {
+	value, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return tododb.KVOf(database).SetValue(sessionKeyOf(s.ID), string(value), sessionTTL())
+}
+
+func sessionGeneration(account string) (int64, error) {
+	value, err := tododb.KVOf(database).GetValue(sessionGenerationKey(account))
+	if err == tododb.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
+
+func loadSessionIndex(account string) ([]string, error) {
+	value, err := tododb.KVOf(database).GetValue(sessionIndexKey(account))
+	if err == tododb.ErrNotFound {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	err = json.Unmarshal([]byte(value), &ids)
+	return ids, err
+}
+
+// updateSessionIndex changes the session list of account by fn.
+func updateSessionIndex(account string, fn func([]string) []string) error {
+	sessionIndexMu.Lock()
+	defer sessionIndexMu.Unlock()
+
+	ids, err := loadSessionIndex(account)
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(fn(ids))
+	if err != nil {
+		return err
+	}
+
+	return tododb.KVOf(database).SetValue(sessionIndexKey(account), string(value), 0)
+}
+
+// startSession creates a session of account for the device of the request
+// and returns its token.
+func startSession(c *gin.Context, account string) (string, session, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", session{}, err
+	}
+	token := hex.EncodeToString(raw)
+
+	generation, err := sessionGeneration(account)
+	if err != nil {
+		return "", session{}, err
+	}
+
+	now := time.Now().UTC()
+	s := session{
+		ID:         sessionIDOf(token),
+		Account:    account,
+		Device:     c.Request.UserAgent(),
+		IP:         c.ClientIP(),
+		Created:    now,
+		LastSeen:   now,
+		Generation: generation,
+	}
+	if err := saveSession(s); err != nil {
+		return "", session{}, err
+	}
+
+	err = updateSessionIndex(account, func(ids []string) []string {
+		return append(ids, s.ID)
+	})
+	return token, s, err
+}
+
+// listSessions returns the live sessions of account, the index is cleaned
+// of the expired and revoked ones on the way.
+func listSessions(account string) ([]session, error) {
+	ids, err := loadSessionIndex(account)
+	if err != nil {
+		return nil, err
+	}
+	generation, err := sessionGeneration(account)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []session{}
+	live := map[string]bool{}
+	for _, id := range ids {
+		s, err := loadSession(id)
+		if err == tododb.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if s.Generation == generation {
+			sessions = append(sessions, s)
+			live[id] = true
+		}
+	}
+
+	if len(live) < len(ids) {
+		err = updateSessionIndex(account, func(ids []string) []string {
+			kept := []string{}
+			for _, id := range ids {
+				if live[id] {
+					kept = append(kept, id)
+				}
+			}
+			return kept
+		})
+	}
+
+	return sessions, err
+}
+
+// revokeSession ends a session of account, it reports false for sessions
+// of other accounts.
+func revokeSession(account, id string) (bool, error) {
+	s, err := loadSession(id)
+	if err == tododb.ErrNotFound || (err == nil && s.Account != account) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if err := tododb.KVOf(database).DeleteValue(sessionKeyOf(id)); err != nil && err != tododb.ErrNotFound {
+		return false, err
+	}
+	logger.Infof("Revoked session %s of account %s", id, account)
+
+	return true, updateSessionIndex(account, func(ids []string) []string {
+		kept := []string{}
+		for _, other := range ids {
+			if other != id {
+				kept = append(kept, other)
+			}
+		}
+		return kept
+	})
+}
+
+// revokeSessions ends all sessions of account. The new generation rejects
+// them right away, deleting them only cleans up.
+func revokeSessions(account string) error {
+	kv := tododb.KVOf(database)
+	if _, err := kv.IncrValue(sessionGenerationKey(account), 0); err != nil {
+		return err
+	}
+
+	ids, err := loadSessionIndex(account)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := kv.DeleteValue(sessionKeyOf(id)); err != nil && err != tododb.ErrNotFound {
+			return err
+		}
+	}
+	logger.Infof("Revoked all sessions of account %s", account)
+
+	return updateSessionIndex(account, func([]string) []string {
+		return []string{}
+	})
+}
+
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+
+	return strings.TrimPrefix(header, "Bearer ")
+}
+
+// authenticateSession signs the request in with a session token.
+func authenticateSession(c *gin.Context, token string) bool {
+	s, err := loadSession(sessionIDOf(token))
+	return signInSession(c, s, err)
+}
+
+// signInSession signs the request in with the session s, loading it
+// returned err. The session and the account are read on every request, so
+// revoked sessions and disabled accounts are rejected at once.
+func signInSession(c *gin.Context, s session, err error) bool {
+	var generation int64
+	if err == nil {
+		generation, err = sessionGeneration(s.Account)
+	}
+	if err == tododb.ErrNotFound || (err == nil && s.Generation != generation) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"errors": "session expired or revoked, sign in again",
+		})
+		return false
+	}
+
+	var user tododb.User
+	if err == nil {
+		user, err = tododb.UsersOf(database).GetUser(s.Account)
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return false
+	}
+	if user.Disabled {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"errors": "account " + user.Name + " is disabled",
+		})
+		return false
+	}
+
+	if time.Since(s.LastSeen) > sessionTouchInterval || s.IP != c.ClientIP() {
+		s.LastSeen = time.Now().UTC()
+		s.IP = c.ClientIP()
+		if err := saveSession(s); err != nil {
+			logger.Errorf("%v", err)
+		}
+	}
+
+	c.Set(accountKey, user.Name)
+	c.Set(sessionKey, s.ID)
+	return true
+}
+
+// sessionView is a session as listed to its account.
+type sessionView struct {
+	ID       string    `json:"id"`
+	Device   string    `json:"device"`
+	IP       string    `json:"ip"`
+	Created  time.Time `json:"created"`
+	LastSeen time.Time `json:"lastSeen"`
+	Current  bool      `json:"current,omitempty"`
+}
+
+func sessionViews(c *gin.Context, sessions []session) []sessionView {
+	views := make([]sessionView, len(sessions))
+	for i, s := range sessions {
+		views[i] = sessionView{
+			ID:       s.ID,
+			Device:   s.Device,
+			IP:       s.IP,
+			Created:  s.Created,
+			LastSeen: s.LastSeen,
+			Current:  s.ID == c.GetString(sessionKey),
+		}
+	}
+
+	return views
+}
+
+// createSessionHandler signs in with the password, and the two-factor code
+// if enabled, and returns the token of a new session.
+func createSessionHandler(c *gin.Context) {
+	user, ok := signedInUser(c)
+	if !ok {
+		return
+	}
+
+	token, s, err := startSession(c, user.Name)
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	logger.Infof("Started session %s of account %s", s.ID, user.Name)
+	c.JSON(http.StatusCreated, gin.H{
+		"token":   token,
+		"session": sessionViews(c, []session{s})[0],
+	})
+}
+
+func listSessionsHandler(c *gin.Context) {
+	user, ok := signedInUser(c)
+	if !ok {
+		return
+	}
+
+	listAccountSessions(c, user.Name)
+}
+
+func revokeSessionHandler(c *gin.Context) {
+	user, ok := signedInUser(c)
+	if !ok {
+		return
+	}
+
+	revoked, err := revokeSession(user.Name, c.Param("id"))
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": "no session " + c.Param("id"),
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// revokeSessionsHandler signs the account out everywhere, including the
+// current session.
+func revokeSessionsHandler(c *gin.Context) {
+	user, ok := signedInUser(c)
+	if !ok {
+		return
+	}
+
+	revokeAccountSessions(c, user.Name)
+}
+
+func accountSessionsHandler(c *gin.Context) {
+	if _, err := tododb.UsersOf(database).GetUser(c.Param("name")); err == tododb.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": "account " + c.Param("name") + " not found",
+		})
+		return
+	}
+
+	listAccountSessions(c, c.Param("name"))
+}
+
+func revokeAccountSessionsHandler(c *gin.Context) {
+	revokeAccountSessions(c, c.Param("name"))
+}
+
+func listAccountSessions(c *gin.Context, account string) {
+	sessions, err := listSessions(account)
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessionViews(c, sessions))
+}
+
+func revokeAccountSessions(c *gin.Context, account string) {
+	if err := revokeSessions(account); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+type sessionResponse struct {
+	Token   string      `json:"token"`
+	Session sessionView `json:"session"`
+}
+
+func TestSessions(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+	server.createAccount("jane")
+	server.createAccount("bob")
+	jane := asAccount("jane")
+
+	var phone, laptop sessionResponse
+	server.expect(http.StatusCreated, "POST", "/api/v1/sessions", jane, nil, &phone)
+	server.expect(http.StatusCreated, "POST", "/api/v1/sessions", jane, nil, &laptop)
+	server.expect(http.StatusOK, "GET", "/api/v1/account", withToken(phone.Token), nil, nil)
+	server.expect(http.StatusUnauthorized, "POST", "/api/v1/sessions", anonymous, nil, nil)
+
+	var sessions []sessionView
+	server.expect(http.StatusOK, "GET", "/api/v1/sessions", withToken(laptop.Token), nil, &sessions)
+	if len(sessions) != 2 || sessions[0].Current || !sessions[1].Current {
+		t.Errorf("the sessions are %+v, want both with the laptop current", sessions)
+	}
+
+	// bob can't revoke the sessions of jane
+	server.expect(http.StatusNotFound, "DELETE", "/api/v1/sessions/"+phone.Session.ID, asAccount("bob"), nil, nil)
+	server.expect(http.StatusOK, "GET", "/api/v1/account", withToken(phone.Token), nil, nil)
+
+	server.expect(http.StatusNoContent, "DELETE", "/api/v1/sessions/"+phone.Session.ID, withToken(laptop.Token), nil, nil)
+	server.expect(http.StatusUnauthorized, "GET", "/api/v1/account", withToken(phone.Token), nil, nil)
+	server.expect(http.StatusUnauthorized, "GET", "/api/v1/todos", withToken(phone.Token), nil, nil)
+	server.expect(http.StatusOK, "GET", "/api/v1/account", withToken(laptop.Token), nil, nil)
+
+	server.expect(http.StatusNoContent, "DELETE", "/api/v1/sessions", withToken(laptop.Token), nil, nil)
+	server.expect(http.StatusUnauthorized, "GET", "/api/v1/account", withToken(laptop.Token), nil, nil)
+}
+
+func TestSessionsOfDisabledAccount(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+	server.createAccount("jane")
+
+	var phone sessionResponse
+	server.expect(http.StatusCreated, "POST", "/api/v1/sessions", asAccount("jane"), nil, &phone)
+	var sessions []sessionView
+	server.expect(http.StatusOK, "GET", "/admin/accounts/jane/sessions", asAdmin, nil, &sessions)
+	if len(sessions) != 1 || sessions[0].ID != phone.Session.ID {
+		t.Errorf("the admins see the sessions %+v, want the one of the phone", sessions)
+	}
+
+	server.expect(http.StatusOK, "POST", "/admin/accounts/jane/disable", asAdmin, nil, nil)
+	server.expect(http.StatusUnauthorized, "GET", "/api/v1/account", withToken(phone.Token), nil, nil)
+	server.expect(http.StatusOK, "POST", "/admin/accounts/jane/enable", asAdmin, nil, nil)
+	// enabling doesn't bring the revoked sessions back
+	server.expect(http.StatusUnauthorized, "GET", "/api/v1/account", withToken(phone.Token), nil, nil)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	defaultShareHours = 24
+	maxShareHours     = 30 * 24
+)
+
+type shareSnapshot struct {
+	Todos   []string  `json:"todos"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+type shareInfo struct {
+	ID      string    `json:"id"`
+	URL     string    `json:"url,omitempty"`
+	QR      string    `json:"qr,omitempty"`
+	Expires time.Time `json:"expires"`
+	Views   int64     `json:"views"`
+}
+
+var shareTemplate = template.Must(template.New("share").Funcs(brandingFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <meta name="robots" content="noindex">
+    <title>Shared todo list - {{appName}}</title>
+    <style>
+      body { font-family: Georgia, serif; max-width: 40em; margin: 2em auto; color: #000; }
+      h1 { color: {{primaryColor}}; }
+      .meta { color: #555; font-size: 0.9em; }
+      ul { list-style: none; padding: 0; }
+      li { padding: 0.3em 0; border-bottom: 1px dotted #999; }
+      li:before { content: "\2610"; margin-right: 0.6em; }
+    </style>
+  </head>
+  <body>
+    <h1>Todo list</h1>
+    <p class="meta">Read-only snapshot from {{.Created.Format "2006-01-02 15:04"}}, the link expires {{.Expires.Format "2006-01-02 15:04"}}</p>
+    <ul>
+    {{range .Todos}}  <li>{{.}}</li>
+    {{else}}  <li>Nothing to do.</li>
+    {{end}}</ul>
+  </body>
+</html>
+`))
+
+// shareSecret signs the share links. Without ShareSecret in the config a
+// random one is used, and the links break on restart.
+var shareSecret []byte
+
+func initShareSecret(secret string) {
+	if secret != "" {
+		shareSecret = []byte(secret)
+		return
+	}
+
+	shareSecret = make([]byte, 32)
+	if _, err := rand.Read(shareSecret); err != nil {
+		panic(err)
+	}
+	logger.Warnf("No ShareSecret configured, share links are only valid until restart")
+}
+
+func signShare(id string, expires int64) string {
+	mac := hmac.New(sha256.New, shareSecret)
+	fmt.Fprintf(mac, "%s.%d", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func shareKey(id string) string {
+	return "share:" + id
+}
+
+func shareViewsKey(id string) string {
+	return "share:" + id + ":views"
+}
+
+// createShareHandler stores a snapshot of the list and returns a signed link
+// to it, valid for ?hours=.
+func createShareHandler(c *gin.Context) {
+	hours, err := strconv.Atoi(c.DefaultQuery("hours", strconv.Itoa(defaultShareHours)))
+	if err != nil || hours <= 0 || hours > maxShareHours {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("hours must be between 1 and %d", maxShareHours),
+		})
+		return
+	}
+
+	todos, err := todosOf(c).GetAllTodos(c.Request.Context())
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	idBytes := make([]byte, 12)
+	if _, err := rand.Read(idBytes); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	id := hex.EncodeToString(idBytes)
+
+	ttl := time.Duration(hours) * time.Hour
+	now := time.Now().UTC()
+	snapshot, _ := json.Marshal(shareSnapshot{
+		Todos:   tododb.Titles(todos),
+		Created: now,
+		Expires: now.Add(ttl),
+	})
+	if err := tododb.KVOf(database).SetValue(shareKey(id), string(snapshot), ttl); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	expires := now.Add(ttl).Unix()
+	link := fmt.Sprintf("/shared/%s?expires=%d&sig=%s", id, expires, signShare(id, expires))
+	c.JSON(http.StatusCreated, shareInfo{
+		ID:      id,
+		URL:     link,
+		QR:      qrLink(link),
+		Expires: time.Unix(expires, 0).UTC(),
+	})
+}
+
+func loadShare(id string) (shareSnapshot, error) {
+	var snapshot shareSnapshot
+	value, err := tododb.KVOf(database).GetValue(shareKey(id))
+	if err != nil {
+		return snapshot, err
+	}
+
+	err = json.Unmarshal([]byte(value), &snapshot)
+	return snapshot, err
+}
+
+func shareInfoHandler(c *gin.Context) {
+	id := c.Param("id")
+	snapshot, err := loadShare(id)
+	if err == tododb.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": "share link not found, expired or revoked",
+		})
+		return
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	views, err := tododb.KVOf(database).GetValue(shareViewsKey(id))
+	if err != nil && err != tododb.ErrNotFound {
+		logger.Errorf("%v", err)
+	}
+	count, _ := strconv.ParseInt(views, 10, 64)
+
+	c.JSON(http.StatusOK, shareInfo{
+		ID:      id,
+		Expires: snapshot.Expires,
+		Views:   count,
+	})
+}
+
+func revokeShareHandler(c *gin.Context) {
+	kv := tododb.KVOf(database)
+	id := c.Param("id")
+	for _, key := range []string{shareKey(id), shareViewsKey(id)} {
+		if err := kv.DeleteValue(key); err != nil {
+			logger.Errorf("%v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// sharedListHandler shows a snapshot to anyone with a valid link, as HTML or
+// with ?format=json as JSON.
+func sharedListHandler(c *gin.Context) {
+	id := c.Param("id")
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || !hmac.Equal([]byte(c.Query("sig")), []byte(signShare(id, expires))) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"errors": "invalid share link",
+		})
+		return
+	}
+
+	if time.Now().Unix() > expires {
+		c.JSON(http.StatusGone, gin.H{
+			"errors": "share link expired",
+		})
+		return
+	}
+
+	snapshot, err := loadShare(id)
+	if err == tododb.ErrNotFound {
+		c.JSON(http.StatusGone, gin.H{
+			"errors": "share link revoked",
+		})
+		return
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	if _, err := tododb.KVOf(database).IncrValue(shareViewsKey(id), time.Until(snapshot.Expires)); err != nil {
+		logger.Errorf("%v", err)
+	}
+
+	c.Header("Cache-Control", "no-store")
+	if c.Query("format") == "json" {
+		c.JSON(http.StatusOK, snapshot)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := shareTemplate.Execute(&buf, snapshot); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShareSnapshot(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+	initShareSecret("share-secret")
+	server.createAccount("jane")
+	server.createAccount("bob")
+	server.createTodo(asAccount("jane"), "Renew the passport")
+	server.createTodo(asAccount("bob"), "Fix the bike")
+	server.createTodo(anonymous, "Buy milk")
+
+	server.expect(http.StatusBadRequest, "POST", "/share?hours=0", asAccount("bob"), nil, nil)
+	var share shareInfo
+	server.expect(http.StatusCreated, "POST", "/share?hours=1", asAccount("bob"), nil, &share)
+
+	// the snapshot has the todos bob may read, not those of jane
+	var snapshot shareSnapshot
+	server.expect(http.StatusOK, "GET", share.URL+"&format=json", anonymous, nil, &snapshot)
+	if want := []string{"Fix the bike", "Buy milk"}; !reflect.DeepEqual(snapshot.Todos, want) {
+		t.Errorf("the snapshot has %q, want %q", snapshot.Todos, want)
+	}
+
+	forged := strings.Replace(share.URL, "sig=", "sig=0", 1)
+	server.expect(http.StatusForbidden, "GET", forged, anonymous, nil, nil)
+	var info shareInfo
+	server.expect(http.StatusOK, "GET", "/share/"+share.ID, asAccount("bob"), nil, &info)
+	if info.Views != 1 {
+		t.Errorf("the link was viewed %d times, want 1", info.Views)
+	}
+
+	server.expect(http.StatusNoContent, "DELETE", "/share/"+share.ID, asAccount("bob"), nil, nil)
+	server.expect(http.StatusGone, "GET", share.URL, anonymous, nil, nil)
+}
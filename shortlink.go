@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/rand"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	shortCodeLength   = 6
+	shortCodeAlphabet = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	shortCodeRetries  = 5
+)
+
+var shortCodePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,32}$`)
+
+type shortLink struct {
+	Code string `json:"code"`
+	Path string `json:"path"`
+	URL  string `json:"url"`
+	Hits int64  `json:"hits"`
+}
+
+type shortLinkRequest struct {
+	Path string `json:"path"`
+	Code string `json:"code"`
+}
+
+func shortLinkKey(code string) string {
+	return "short:" + code
+}
+
+func shortLinkHitsKey(code string) string {
+	return "short:" + code + ":hits"
+}
+
+func randomShortCode() (string, error) {
+	code := make([]byte, shortCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(shortCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = shortCodeAlphabet[n.Int64()]
+	}
+
+	return string(code), nil
+}
+
+func shortLinkExists(kv tododb.KV, code string) (bool, error) {
+	_, err := kv.GetValue(shortLinkKey(code))
+	if err == tododb.ErrNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// createShortLinkHandler stores a short code for a path on this instance,
+// like a list view or a share link. Without a code in the request a random
+// one is picked.
+func createShortLinkHandler(c *gin.Context) {
+	var request shortLinkRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	if !strings.HasPrefix(request.Path, "/") || strings.HasPrefix(request.Path, "//") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "path must be a path on this instance",
+		})
+		return
+	}
+
+	if request.Code != "" && !shortCodePattern.MatchString(request.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "code must be 3 to 32 letters, digits, - or _",
+		})
+		return
+	}
+
+	kv := tododb.KVOf(database)
+	code := request.Code
+	for attempt := 0; code == "" && attempt < shortCodeRetries; attempt++ {
+		candidate, err := randomShortCode()
+		if err != nil {
+			logger.Errorf("%v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+
+		taken, err := shortLinkExists(kv, candidate)
+		if err != nil {
+			logger.Errorf("%v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+		if !taken {
+			code = candidate
+		}
+	}
+
+	if code == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"errors": "no free short code found, try again",
+		})
+		return
+	}
+
+	if request.Code != "" {
+		taken, err := shortLinkExists(kv, code)
+		if err != nil {
+			logger.Errorf("%v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+		if taken {
+			c.JSON(http.StatusConflict, gin.H{
+				"errors": "code " + code + " is already taken",
+			})
+			return
+		}
+	}
+
+	if err := kv.SetValue(shortLinkKey(code), request.Path, 0); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, shortLink{
+		Code: code,
+		Path: request.Path,
+		URL:  "/s/" + code,
+	})
+}
+
+func shortLinkHandler(c *gin.Context) {
+	code := c.Param("code")
+	kv := tododb.KVOf(database)
+	path, err := kv.GetValue(shortLinkKey(code))
+	if err == tododb.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": "short link not found",
+		})
+		return
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	hits, err := kv.GetValue(shortLinkHitsKey(code))
+	if err != nil && err != tododb.ErrNotFound {
+		logger.Errorf("%v", err)
+	}
+	count, _ := strconv.ParseInt(hits, 10, 64)
+
+	c.JSON(http.StatusOK, shortLink{
+		Code: code,
+		Path: path,
+		URL:  "/s/" + code,
+		Hits: count,
+	})
+}
+
+func deleteShortLinkHandler(c *gin.Context) {
+	kv := tododb.KVOf(database)
+	code := c.Param("code")
+	for _, key := range []string{shortLinkKey(code), shortLinkHitsKey(code)} {
+		if err := kv.DeleteValue(key); err != nil {
+			logger.Errorf("%v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// resolveShortLinkHandler redirects to the path of a code and counts the hit.
+func resolveShortLinkHandler(c *gin.Context) {
+	code := c.Param("code")
+	kv := tododb.KVOf(database)
+	path, err := kv.GetValue(shortLinkKey(code))
+	if err == tododb.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": "short link not found",
+		})
+		return
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	if _, err := kv.IncrValue(shortLinkHitsKey(code), 0); err != nil {
+		logger.Errorf("%v", err)
+	}
+
+	c.Redirect(http.StatusFound, path)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRandomShortCode(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		code, err := randomShortCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != shortCodeLength || !shortCodePattern.MatchString(code) || strings.ContainsAny(code, "0O1lI") {
+			t.Errorf("randomShortCode() = %q", code)
+		}
+		seen[code] = true
+	}
+	if len(seen) < 99 {
+		t.Errorf("randomShortCode() repeated itself, %d of 100 codes differ", len(seen))
+	}
+}
+
+func TestShortLinks(t *testing.T) {
+	codes := []string{"docs", "a"}
+	keys := []string{}
+	for _, code := range codes {
+		keys = append(keys, shortLinkKey(code), shortLinkHitsKey(code))
+	}
+	_, restore := useMemoryDatabase(t, keys)
+	defer restore()
+
+	router := gin.New()
+	router.POST("/links", createShortLinkHandler)
+	router.GET("/links/:code", shortLinkHandler)
+	router.DELETE("/links/:code", deleteShortLinkHandler)
+	router.GET("/s/:code", resolveShortLinkHandler)
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "chosen code", body: `{"path":"/list?tag=work","code":"docs"}`, status: http.StatusCreated},
+		{name: "taken code", body: `{"path":"/","code":"docs"}`, status: http.StatusConflict},
+		{name: "short code", body: `{"path":"/","code":"a"}`, status: http.StatusBadRequest},
+		{name: "bad code", body: `{"path":"/","code":"a b c"}`, status: http.StatusBadRequest},
+		{name: "other host", body: `{"path":"//evil.example.com/"}`, status: http.StatusBadRequest},
+		{name: "absolute url", body: `{"path":"https://evil.example.com/"}`, status: http.StatusBadRequest},
+		{name: "no json", body: `path=/`, status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if recorder := request(http.MethodPost, "/links", test.body); recorder.Code != test.status {
+			t.Errorf("%s: answered %d, want %d: %s", test.name, recorder.Code, test.status, recorder.Body)
+		}
+	}
+
+	recorder := request(http.MethodPost, "/links", `{"path":"/share/abc"}`)
+	var random shortLink
+	if err := json.Unmarshal(recorder.Body.Bytes(), &random); err != nil || recorder.Code != http.StatusCreated {
+		t.Fatalf("answered %d: %s", recorder.Code, recorder.Body)
+	}
+	defer request(http.MethodDelete, "/links/"+random.Code, "")
+	if random.URL != "/s/"+random.Code || random.Path != "/share/abc" {
+		t.Errorf("created %+v", random)
+	}
+
+	for i := 0; i < 2; i++ {
+		recorder := request(http.MethodGet, "/s/docs", "")
+		if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != "/list?tag=work" {
+			t.Errorf("/s/docs answered %d to %q", recorder.Code, recorder.Header().Get("Location"))
+		}
+	}
+	recorder = request(http.MethodGet, "/links/docs", "")
+	var link shortLink
+	if err := json.Unmarshal(recorder.Body.Bytes(), &link); err != nil {
+		t.Fatal(err)
+	}
+	if want := (shortLink{Code: "docs", Path: "/list?tag=work", URL: "/s/docs", Hits: 2}); link != want {
+		t.Errorf("/links/docs = %+v, want %+v", link, want)
+	}
+
+	if recorder := request(http.MethodDelete, "/links/docs", ""); recorder.Code != http.StatusNoContent {
+		t.Errorf("deleting answered %d", recorder.Code)
+	}
+	for _, path := range []string{"/links/docs", "/s/docs"} {
+		if recorder := request(http.MethodGet, path, ""); recorder.Code != http.StatusNotFound {
+			t.Errorf("%s of a deleted link answered %d", path, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+// slowLogHandler returns the slow commands of the backend, optionally only
+// those of one role like master or slave.
+func slowLogHandler(c *gin.Context) {
+	slowLogger, ok := database.(tododb.SlowLogger)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{
+			"errors": "the database backend has no slowlog",
+		})
+		return
+	}
+
+	role := c.Query("role")
+	entries := []tododb.SlowLogEntry{}
+	for _, entry := range slowLogger.SlowLog() {
+		if role == "" || entry.Role == role {
+			entries = append(entries, entry)
+		}
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
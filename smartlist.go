@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const smartListsKey = "smartlists"
+
+// The titles of the seed profiles carry the tags, the due date and the
+// assignee: "@alice: Call the landlord (due 2019-05-01) #home". New todos get
+// their tags and due date from them, the assignee is only read for the
+// digests and the stats.
+var (
+	todoTagPattern      = regexp.MustCompile(`(?:^|\s)#([\w-]+)`)
+	todoAssigneePattern = regexp.MustCompile(`^@([\w-]+):?`)
+	todoDuePattern      = regexp.MustCompile(`\(due (\d{4}-\d{2}-\d{2})\)`)
+)
+
+var smartListDues = []string{"overdue", "today", "week", "none", "any"}
+
+// smartFilter matches todos that satisfy all of its non-empty fields. They
+// are matched against the fields of the todo, not its title text.
+type smartFilter struct {
+	// Text is matched case insensitive anywhere in the title or the
+	// description
+	Text string   `json:"text,omitempty"`
+	Tags []string `json:"tags,omitempty"`
+	// Due is one of overdue, today, week (the next 7 days), none or any
+	Due string `json:"due,omitempty"`
+	// Status is open or done
+	Status string `json:"status,omitempty"`
+}
+
+type smartList struct {
+	ID     string      `json:"id"`
+	Name   string      `json:"name"`
+	Filter smartFilter `json:"filter"`
+}
+
+func (filter smartFilter) validate() error {
+	if status := tododb.TodoStatus(filter.Status); status != tododb.StatusAny && status != tododb.StatusOpen && status != tododb.StatusDone {
+		return fmt.Errorf("unknown status %q, use open or done", filter.Status)
+	}
+
+	if filter.Due != "" && !contains(smartListDues, filter.Due) {
+		return fmt.Errorf("unknown due %q, use one of %s", filter.Due, strings.Join(smartListDues, ", "))
+	}
+
+	return nil
+}
+
+func (filter smartFilter) matches(item tododb.Todo, today time.Time) bool {
+	if !(tododb.TodoFilter{Status: tododb.TodoStatus(filter.Status)}).Matches(item) {
+		return false
+	}
+
+	if text := strings.ToLower(filter.Text); text != "" &&
+		!strings.Contains(strings.ToLower(item.Title), text) &&
+		!strings.Contains(strings.ToLower(item.Description), text) {
+		return false
+	}
+
+	for _, tag := range filter.Tags {
+		if !contains(item.Tags, tododb.NormalizeTag(tag)) {
+			return false
+		}
+	}
+
+	if filter.Due != "" {
+		var due time.Time
+		if item.Due != nil {
+			day := item.Due.In(today.Location())
+			due = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, today.Location())
+		}
+
+		switch filter.Due {
+		case "overdue":
+			return !due.IsZero() && due.Before(today)
+		case "today":
+			return due.Equal(today)
+		case "week":
+			return !due.Before(today) && due.Before(today.AddDate(0, 0, 7))
+		case "none":
+			return due.IsZero()
+		case "any":
+			return !due.IsZero()
+		}
+	}
+
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func loadSmartLists() (map[string]smartList, error) {
+	lists := map[string]smartList{}
+	value, err := tododb.KVOf(database).GetValue(smartListsKey)
+	if err == tododb.ErrNotFound {
+		return lists, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return lists, json.Unmarshal([]byte(value), &lists)
+}
+
+func saveSmartLists(lists map[string]smartList) error {
+	value, err := json.Marshal(lists)
+	if err != nil {
+		return err
+	}
+
+	return tododb.KVOf(database).SetValue(smartListsKey, string(value), 0)
+}
+
+type cachedSmartList struct {
+	todos   []tododb.Todo
+	expires time.Time
+}
+
+// smartListCache keeps the evaluated smart lists for SmartListCacheSeconds.
+// Changes of the todos show up once the entry expired, changes of the smart
+// list itself right away.
+var smartListCache = struct {
+	sync.Mutex
+	entries map[string]cachedSmartList
+}{entries: map[string]cachedSmartList{}}
+
+func dropCachedSmartList(id string) {
+	smartListCache.Lock()
+	defer smartListCache.Unlock()
+
+	delete(smartListCache.entries, id)
+}
+
+// dropCachedSmartLists is for changes of the todos that should show up right
+// away.
+func dropCachedSmartLists() {
+	smartListCache.Lock()
+	defer smartListCache.Unlock()
+
+	smartListCache.entries = map[string]cachedSmartList{}
+}
+
+// smartListTodos returns the todos of list that reader matches. The cache
+// holds the todos of all accounts, they are filtered for every request.
+func smartListTodos(ctx context.Context, list smartList, reader tododb.TodoFilter) ([]tododb.Todo, error) {
+	smartListCache.Lock()
+	cached, exists := smartListCache.entries[list.ID]
+	smartListCache.Unlock()
+	if exists && time.Now().Before(cached.expires) {
+		return filterTodos(cached.todos, reader), nil
+	}
+
+	todos, err := database.GetAllTodos(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	matches := []tododb.Todo{}
+	for _, todo := range todos {
+		if list.Filter.matches(todo, today) {
+			matches = append(matches, todo)
+		}
+	}
+
+	smartListCache.Lock()
+	smartListCache.entries[list.ID] = cachedSmartList{
+		todos:   matches,
+		expires: now.Add(time.Duration(appConfig.SmartListCacheSeconds) * time.Second),
+	}
+	smartListCache.Unlock()
+
+	return filterTodos(matches, reader), nil
+}
+
+// findSmartList answers with 404 or 500 if the smart list can't be returned.
+func findSmartList(c *gin.Context, id string) (smartList, bool) {
+	lists, err := loadSmartLists()
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return smartList{}, false
+	}
+
+	list, exists := lists[id]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": fmt.Sprintf("unknown smart list %q", id),
+		})
+		return smartList{}, false
+	}
+
+	return list, true
+}
+
+func listSmartListsHandler(c *gin.Context) {
+	lists, err := loadSmartLists()
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	result := make([]smartList, 0, len(lists))
+	for _, list := range lists {
+		result = append(result, list)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	c.JSON(http.StatusOK, result)
+}
+
+// setSmartListHandler creates or replaces the smart list.
+func setSmartListHandler(c *gin.Context) {
+	id := c.Param("id")
+	if !shortCodePattern.MatchString(id) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "id must be 3 to 32 letters, digits, - or _",
+		})
+		return
+	}
+
+	var list smartList
+	if err := c.ShouldBindJSON(&list); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	if err := list.Filter.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	list.ID = id
+	if list.Name == "" {
+		list.Name = id
+	}
+
+	lists, err := loadSmartLists()
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	lists[id] = list
+	if err := saveSmartLists(lists); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	dropCachedSmartList(id)
+
+	c.JSON(http.StatusOK, list)
+}
+
+func deleteSmartListHandler(c *gin.Context) {
+	id := c.Param("id")
+	lists, err := loadSmartLists()
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	delete(lists, id)
+	if err := saveSmartLists(lists); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	dropCachedSmartList(id)
+
+	c.Status(http.StatusNoContent)
+}
+
+func smartListTodosHandler(c *gin.Context) {
+	list, ok := findSmartList(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	reader, err := readerOf(c)
+	var todos []tododb.Todo
+	if err == nil {
+		todos, err = smartListTodos(c.Request.Context(), list, reader)
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, tododb.Titles(todos))
+}
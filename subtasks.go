@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+// listSubTasksHandler answers with the checklist of a todo.
+func listSubTasksHandler(c *gin.Context) {
+	subTasks, err := todosOf(c).GetSubTasks(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		answerTodoError(c, c.Param("id"), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, subTasks)
+}
+
+// addSubTaskHandler adds an open sub-task to the end of the checklist of a
+// todo.
+func addSubTaskHandler(c *gin.Context) {
+	var request struct {
+		Title string `json:"title"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	title := strings.TrimSpace(request.Title)
+	if title == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "title must not be empty",
+		})
+		return
+	}
+
+	subTask, err := todosOf(c).AddSubTask(c.Request.Context(), c.Param("id"), title)
+	if !subTaskChanged(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusCreated, subTask)
+}
+
+// completeSubTaskHandler marks a sub-task done, the todo itself stays open
+// until it is completed on its own.
+func completeSubTaskHandler(c *gin.Context) {
+	err := todosOf(c).CompleteSubTask(c.Request.Context(), c.Param("id"), c.Param("subtask"))
+	if !subTaskChanged(c, err) {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// subTaskChanged publishes the todo whose checklist was changed, it answers
+// the request itself if that failed.
+func subTaskChanged(c *gin.Context, err error) bool {
+	if err == tododb.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": fmt.Sprintf("no todo with id %q or no sub-task with id %q", c.Param("id"), c.Param("subtask")),
+		})
+		return false
+	} else if err != nil {
+		answerTodoError(c, c.Param("id"), err)
+		return false
+	}
+
+	id := c.Param("id")
+	todo, found, err := findTodo(c.Request.Context(), todosOf(c), func(todo tododb.Todo) bool {
+		return todo.ID == id
+	})
+	if err != nil {
+		logger.Errorf("%v", err)
+	} else if found {
+		publishChange(changeUpdated, todo)
+	}
+
+	return true
+}
+
+// subTaskBadge shows the progress of the checklist of a todo that has one.
+func subTaskBadge(todo tododb.Todo) template.HTML {
+	done, total := todo.SubTaskProgress()
+	if total == 0 {
+		return ""
+	}
+
+	class := "label-default"
+	if done == total {
+		class = "label-success"
+	}
+
+	return template.HTML(fmt.Sprintf(` <span class="label %s">%d/%d done</span>`, class, done, total))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+// tagPattern is a tag as written in a todo, the "#" is optional.
+var tagPattern = regexp.MustCompile(`^#?[\w-]+$`)
+
+// parseTags checks and normalizes the tags of a request.
+func parseTags(tags []string) ([]string, error) {
+	parsed := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if !tagPattern.MatchString(strings.TrimSpace(tag)) {
+			return nil, fmt.Errorf("invalid tag %q, use letters, digits, _ and -", tag)
+		}
+		parsed = append(parsed, tododb.NormalizeTag(tag))
+	}
+
+	return parsed, nil
+}
+
+// titleTags returns the "#home" tags written in a title, normalized.
+func titleTags(title string) []string {
+	var tags []string
+	for _, match := range todoTagPattern.FindAllStringSubmatch(title, -1) {
+		tags = append(tags, tododb.NormalizeTag(match[1]))
+	}
+
+	return tags
+}
+
+// todoTags returns the tags of a todo and those in its title, todos saved
+// before they had tags of their own only have the latter.
+func todoTags(todo tododb.Todo) []string {
+	tags := append([]string{}, todo.Tags...)
+	for _, tag := range titleTags(todo.Title) {
+		if !contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+
+	return tags
+}
+
+// tagQuery returns the tag of ?tag=, it answers the request itself if the
+// tag is invalid.
+func tagQuery(c *gin.Context) (string, bool) {
+	tag := c.Query("tag")
+	if tag == "" {
+		return "", true
+	}
+	if !tagPattern.MatchString(tag) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("invalid tag %q, use letters, digits, _ and -", tag),
+		})
+		return "", false
+	}
+
+	return tododb.NormalizeTag(tag), true
+}
+
+// tagBadges labels a todo with the tags that aren't in its title already.
+func tagBadges(todo tododb.Todo) template.HTML {
+	inTitle := titleTags(todo.Title)
+	var badges strings.Builder
+	for _, tag := range todo.Tags {
+		if !contains(inTitle, tag) {
+			fmt.Fprintf(&badges, ` <span class="label label-primary">#%s</span>`, template.HTMLEscapeString(tag))
+		}
+	}
+
+	return template.HTML(badges.String())
+}
+
+// listTagsHandler returns the tags in use with the number of todos of each,
+// for the tag filter of the UI.
+func listTagsHandler(c *gin.Context) {
+	tags, err := todosOf(c).ListTags(c.Request.Context())
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, tags)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/johscheuer/todo-app-web/features"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	telemetryIDKey               = "telemetry-id"
+	defaultTelemetryIntervalHour = 24
+	defaultTelemetryTimeout      = 10 * time.Second
+)
+
+// telemetryRequests counts the requests since the last report, also while
+// telemetry is off so the preview shows real numbers.
+var telemetryRequests uint64
+
+// telemetryReport is everything that is sent, nothing identifies the users
+// or their todos. Counts are only sent as ranges.
+type telemetryReport struct {
+	// InstallationID is random, it only tells reports of different
+	// installations apart
+	InstallationID string   `json:"installationId"`
+	Version        string   `json:"version"`
+	GoVersion      string   `json:"goVersion"`
+	OS             string   `json:"os"`
+	Arch           string   `json:"arch"`
+	Backend        string   `json:"backend"`
+	Features       []string `json:"features"`
+	Requests       string   `json:"requests"`
+	Todos          string   `json:"todos"`
+}
+
+func countRequests(c *gin.Context) {
+	atomic.AddUint64(&telemetryRequests, 1)
+	c.Next()
+}
+
+// volumeBucket turns a count into its order of magnitude, like 100-999.
+func volumeBucket(count uint64) string {
+	if count == 0 {
+		return "0"
+	}
+
+	lower := uint64(1)
+	for count >= lower*10 && lower < 1000000 {
+		lower *= 10
+	}
+	if lower == 1000000 {
+		return ">=1000000"
+	}
+
+	return fmt.Sprintf("%d-%d", lower, lower*10-1)
+}
+
+// installationID is shared by the replicas through the backend, the first
+// one to report creates it.
+func installationID() (string, error) {
+	kv := tododb.KVOf(database)
+	id, err := kv.GetValue(telemetryIDKey)
+	if err != tododb.ErrNotFound {
+		return id, err
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	id = hex.EncodeToString(idBytes)
+
+	return id, kv.SetValue(telemetryIDKey, id, 0)
+}
+
+func makeTelemetryReport(ctx context.Context) (telemetryReport, error) {
+	report := telemetryReport{
+		Version:   buildinfo.Get().Version,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Backend:   appConfig.Backend,
+		Features:  []string{},
+		Requests:  volumeBucket(atomic.LoadUint64(&telemetryRequests)),
+	}
+
+	for _, flag := range features.List() {
+		if flag.Enabled {
+			report.Features = append(report.Features, flag.Name)
+		}
+	}
+
+	id, err := installationID()
+	if err != nil {
+		return report, err
+	}
+	report.InstallationID = id
+
+	usage, err := database.GetUsage(ctx)
+	if err != nil {
+		return report, err
+	}
+	report.Todos = volumeBucket(uint64(usage.Todos))
+
+	return report, nil
+}
+
+func sendTelemetryReport(endpoint string, report telemetryReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: defaultTelemetryTimeout}
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("telemetry endpoint answered with %s", resp.Status)
+	}
+
+	return nil
+}
+
+// runTelemetry reports every IntervalHours, starting one interval after the
+// start. The requests are counted from the last report on.
+func runTelemetry(config TelemetryConfig) {
+	interval := time.Duration(config.IntervalHours) * time.Hour
+	logger.Infof("Telemetry is on, reporting to %s every %s", config.Endpoint, interval)
+	for {
+		time.Sleep(interval)
+
+		requests := atomic.LoadUint64(&telemetryRequests)
+		report, err := makeTelemetryReport(context.Background())
+		if err == nil {
+			err = sendTelemetryReport(config.Endpoint, report)
+		}
+		if err != nil {
+			logger.Warnf("telemetry: %v", err)
+			continue
+		}
+		// subtracts the reported requests, new ones are kept
+		atomic.AddUint64(&telemetryRequests, ^(requests - 1))
+	}
+}
+
+// telemetryPreviewHandler shows the report exactly like it would be sent
+// next, whether telemetry is on or not.
+func telemetryPreviewHandler(c *gin.Context) {
+	report, err := makeTelemetryReport(c.Request.Context())
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":       appConfig.Telemetry.Enabled,
+		"endpoint":      appConfig.Telemetry.Endpoint,
+		"intervalHours": appConfig.Telemetry.IntervalHours,
+		"report":        report,
+	})
+}
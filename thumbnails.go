@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	// thumbnailSize is the longer side of a thumbnail in pixels
+	thumbnailSize = 256
+	// maxThumbnailSourcePixels keeps images that decode to huge bitmaps
+	// from being thumbnailed
+	maxThumbnailSourcePixels = 40 << 20
+	// thumbnailQueueLength is how many uploads can wait for their
+	// thumbnail, more are skipped
+	thumbnailQueueLength = 100
+	// thumbnailCacheControl lets browsers keep a thumbnail, the content of an
+	// attachment never changes
+	thumbnailCacheControl = "private, max-age=31536000, immutable"
+)
+
+// thumbnailTypes are the content types thumbnails are made of.
+var thumbnailTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// thumbnailJob is an uploaded image waiting for its thumbnail.
+type thumbnailJob struct {
+	sum     string
+	content []byte
+}
+
+var thumbnailJobs = make(chan thumbnailJob, thumbnailQueueLength)
+
+// queueThumbnail makes runThumbnails create the thumbnail of attachment, if
+// it's an image that isn't quarantined. A full queue skips it.
+func queueThumbnail(attachment tododb.Attachment, content []byte) {
+	if !thumbnailTypes[attachment.ContentType] || attachment.SHA256 == "" || attachment.Threat != "" {
+		return
+	}
+
+	select {
+	case thumbnailJobs <- thumbnailJob{sum: attachment.SHA256, content: content}:
+	default:
+		logger.Warnf("Skipped the thumbnail of attachment %s, %d are queued already", attachment.ID, thumbnailQueueLength)
+	}
+}
+
+// runThumbnails stores the thumbnails of the queued images, as PNG blobs
+// next to the blob of the image. Images with the same content share their
+// thumbnail.
+func runThumbnails() {
+	for job := range thumbnailJobs {
+		ctx := context.Background()
+		key := tododb.ThumbnailKey(job.sum)
+		if _, err := attachmentStore.Get(ctx, key); err == nil {
+			continue
+		}
+
+		thumbnail, err := makeThumbnail(job.content)
+		if err == nil {
+			err = storeThumbnail(ctx, job.sum, thumbnail)
+		}
+		if err != nil {
+			logger.Errorf("Thumbnail of %s failed: %v", job.sum, err)
+		}
+	}
+}
+
+// storeThumbnail stores the thumbnail of the blob sum, unless the blob was
+// released in the meantime and could be collected already.
+func storeThumbnail(ctx context.Context, sum string, thumbnail []byte) error {
+	unreferencedBlobsMu.Lock()
+	defer unreferencedBlobsMu.Unlock()
+
+	referenced, err := blobReferenced(sum)
+	if err != nil || !referenced {
+		return err
+	}
+
+	return attachmentStore.Put(ctx, tododb.ThumbnailKey(sum), thumbnail, "image/png")
+}
+
+// makeThumbnail scales an image down to at most thumbnailSize pixels on the
+// longer side, averaging the pixels each one covers, and encodes it as PNG.
+// Smaller images keep their size.
+func makeThumbnail(content []byte) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > maxThumbnailSourcePixels {
+		return nil, fmt.Errorf("the image has more than %d pixels", maxThumbnailSourcePixels)
+	}
+	source, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := source.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > thumbnailSize || height > thumbnailSize {
+		if width >= height {
+			width, height = thumbnailSize, maxInt(1, height*thumbnailSize/bounds.Dx())
+		} else {
+			width, height = maxInt(1, width*thumbnailSize/bounds.Dy()), thumbnailSize
+		}
+	}
+
+	thumbnail := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := maxInt(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := maxInt(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pixel := color.NRGBA64Model.Convert(source.At(sx, sy)).(color.NRGBA64)
+					r += uint64(pixel.R)
+					g += uint64(pixel.G)
+					b += uint64(pixel.B)
+					a += uint64(pixel.A)
+					n++
+				}
+			}
+			thumbnail.Set(x, y, color.NRGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, thumbnail); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// thumbnailHandler answers with the thumbnail of the attachment with the id,
+// 404 until it's made or if the attachment isn't an image.
+func thumbnailHandler(c *gin.Context) {
+	id := c.Param("id")
+	var attachment tododb.Attachment
+	_, found, err := findTodo(c.Request.Context(), todosOf(c), func(todo tododb.Todo) bool {
+		for _, candidate := range todo.Attachments {
+			if candidate.ID == id {
+				attachment = candidate
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": fmt.Sprintf("no attachment with id %q", id),
+		})
+		return
+	}
+	if attachment.SHA256 == "" || attachment.Threat != "" {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": "the attachment has no thumbnail",
+		})
+		return
+	}
+
+	thumbnail, err := attachmentStore.Get(c.Request.Context(), tododb.ThumbnailKey(attachment.SHA256))
+	if err == tododb.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": "the attachment has no thumbnail",
+		})
+		return
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.Header("Cache-Control", thumbnailCacheControl)
+	c.Header("X-Content-Type-Options", "nosniff")
+	writeWithETag(c, "image/png", thumbnail)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestMakeThumbnailSize(t *testing.T) {
+	tests := []struct {
+		width, height int
+		want          image.Point
+	}{
+		{width: 512, height: 256, want: image.Pt(256, 128)},
+		{width: 256, height: 1024, want: image.Pt(64, 256)},
+		{width: 300, height: 300, want: image.Pt(256, 256)},
+		{width: 2000, height: 3, want: image.Pt(256, 1)},
+		{width: 100, height: 40, want: image.Pt(100, 40)},
+		{width: 256, height: 256, want: image.Pt(256, 256)},
+	}
+
+	for _, test := range tests {
+		content := encodePNG(t, image.NewNRGBA(image.Rect(0, 0, test.width, test.height)))
+		thumbnail, err := makeThumbnail(content)
+		if err != nil {
+			t.Fatalf("makeThumbnail(%dx%d) = %v", test.width, test.height, err)
+		}
+		config, err := png.DecodeConfig(bytes.NewReader(thumbnail))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := image.Pt(config.Width, config.Height); got != test.want {
+			t.Errorf("makeThumbnail(%dx%d) is %v, want %v", test.width, test.height, got, test.want)
+		}
+	}
+}
+
+func TestMakeThumbnailAverages(t *testing.T) {
+	// stripes of black and white columns average to grey
+	source := image.NewNRGBA(image.Rect(0, 0, 512, 512))
+	for y := 0; y < 512; y++ {
+		for x := 0; x < 512; x++ {
+			if x%2 == 0 {
+				source.Set(x, y, color.White)
+			} else {
+				source.Set(x, y, color.Black)
+			}
+		}
+	}
+
+	thumbnail, err := makeThumbnail(encodePNG(t, source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(thumbnail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := color.NRGBAModel.Convert(img.At(10, 10)).(color.NRGBA)
+	if got.R != 127 || got.G != 127 || got.B != 127 || got.A != 255 {
+		t.Errorf("makeThumbnail() pixel = %v, want grey", got)
+	}
+}
+
+func TestMakeThumbnailRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{name: "not an image", content: []byte("%PDF-1.4")},
+		{name: "empty", content: nil},
+		{name: "too many pixels", content: encodePNG(t, image.NewGray(image.Rect(0, 0, 8000, 8000)))},
+	}
+
+	for _, test := range tests {
+		if _, err := makeThumbnail(test.content); err == nil {
+			t.Errorf("%s: makeThumbnail() = nil, want an error", test.name)
+		}
+	}
+}
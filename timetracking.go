@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	timeTrackingKey = "timetracking"
+	// maxTimerMinutes is the longest pomodoro, open timers have 0
+	maxTimerMinutes = 8 * 60
+)
+
+type runningTimer struct {
+	Started time.Time `json:"started"`
+	// Minutes stops the timer on its own, like a pomodoro
+	Minutes int `json:"minutes,omitempty"`
+}
+
+func (timer *runningTimer) expired(now time.Time) bool {
+	return timer.Minutes > 0 && timer.Started.Add(time.Duration(timer.Minutes)*time.Minute).Before(now)
+}
+
+// trackedTodo is the time spent on a todo, in total and per day. Title is
+// the title when the timer was last started, it stays once the todo is
+// deleted.
+type trackedTodo struct {
+	Title   string           `json:"title"`
+	Seconds int64            `json:"seconds"`
+	Days    map[string]int64 `json:"days"`
+	Running *runningTimer    `json:"running,omitempty"`
+}
+
+// timeTracking maps the ids of the todos to their time.
+type timeTracking map[string]*trackedTodo
+
+// timerRequest starts or stops the timer of the todo with the id Todo.
+type timerRequest struct {
+	Todo    string `json:"todo"`
+	Minutes int    `json:"minutes"`
+}
+
+type todoTime struct {
+	ID      string        `json:"id"`
+	Title   string        `json:"title"`
+	Seconds int64         `json:"seconds"`
+	Running *runningTimer `json:"running,omitempty"`
+}
+
+type dayTime struct {
+	Day     string `json:"day"`
+	Seconds int64  `json:"seconds"`
+}
+
+// timeTrackingMu serializes the changes of this instance, the timers are
+// read, changed and written back as a whole.
+var timeTrackingMu sync.Mutex
+
+func loadTimeTracking() (timeTracking, error) {
+	tracking := timeTracking{}
+	value, err := tododb.KVOf(database).GetValue(timeTrackingKey)
+	if err == tododb.ErrNotFound {
+		return tracking, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return tracking, json.Unmarshal([]byte(value), &tracking)
+}
+
+func saveTimeTracking(tracking timeTracking) error {
+	value, err := json.Marshal(tracking)
+	if err != nil {
+		return err
+	}
+
+	return tododb.KVOf(database).SetValue(timeTrackingKey, string(value), 0)
+}
+
+// add counts the time between from and to, split at midnight.
+func (tracked *trackedTodo) add(from, to time.Time) {
+	if tracked.Days == nil {
+		tracked.Days = map[string]int64{}
+	}
+
+	for from.Before(to) {
+		midnight := time.Date(from.Year(), from.Month(), from.Day()+1, 0, 0, 0, 0, from.Location())
+		end := to
+		if midnight.Before(end) {
+			end = midnight
+		}
+
+		seconds := int64(end.Sub(from) / time.Second)
+		tracked.Days[from.Format(dayFormat)] += seconds
+		tracked.Seconds += seconds
+		from = end
+	}
+}
+
+// stop counts the running timer up to now or the end of the pomodoro.
+func (tracked *trackedTodo) stop(now time.Time) {
+	if tracked.Running == nil {
+		return
+	}
+
+	started := tracked.Running.Started.In(now.Location())
+	end := now
+	if tracked.Running.Minutes > 0 {
+		if due := started.Add(time.Duration(tracked.Running.Minutes) * time.Minute); due.Before(end) {
+			end = due
+		}
+	}
+	tracked.add(started, end)
+	tracked.Running = nil
+}
+
+// settle stops the pomodoros that ran out.
+func (tracking timeTracking) settle(now time.Time) {
+	for _, tracked := range tracking {
+		if tracked.Running != nil && tracked.Running.expired(now) {
+			tracked.stop(now)
+		}
+	}
+}
+
+// report returns the time per todo and per day, counting running timers up
+// to now.
+func (tracking timeTracking) report(now time.Time) ([]todoTime, []dayTime) {
+	todos := []todoTime{}
+	perDay := map[string]int64{}
+	for id, tracked := range tracking {
+		current := *tracked
+		current.Days = map[string]int64{}
+		for day, seconds := range tracked.Days {
+			current.Days[day] = seconds
+		}
+		running := current.Running
+		if running != nil && running.expired(now) {
+			running = nil
+		}
+		current.stop(now)
+
+		todos = append(todos, todoTime{ID: id, Title: tracked.Title, Seconds: current.Seconds, Running: running})
+		for day, seconds := range current.Days {
+			perDay[day] += seconds
+		}
+	}
+	sort.Slice(todos, func(i, j int) bool {
+		return todos[i].Seconds > todos[j].Seconds
+	})
+
+	days := make([]dayTime, 0, len(perDay))
+	for day, seconds := range perDay {
+		days = append(days, dayTime{Day: day, Seconds: seconds})
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Day < days[j].Day
+	})
+
+	return todos, days
+}
+
+// stopTimer is called for completed and deleted todos, it does nothing if no
+// timer runs for the todo with id.
+func stopTimer(id string) error {
+	timeTrackingMu.Lock()
+	defer timeTrackingMu.Unlock()
+
+	tracking, err := loadTimeTracking()
+	if err != nil {
+		return err
+	}
+
+	tracked, exists := tracking[id]
+	if !exists || tracked.Running == nil {
+		return nil
+	}
+	tracked.stop(time.Now())
+
+	return saveTimeTracking(tracking)
+}
+
+// migrateTimeTracking moves the time of older versions, which tracked the
+// todos by their titles, to the ids of the todos. The time of titles that
+// aren't in the list anymore is kept under the title.
+func migrateTimeTracking(ctx context.Context) error {
+	timeTrackingMu.Lock()
+	defer timeTrackingMu.Unlock()
+
+	tracking, err := loadTimeTracking()
+	if err != nil || len(tracking) == 0 {
+		return err
+	}
+
+	ids, err := todoIDsByTitle(ctx)
+	if err != nil {
+		return err
+	}
+
+	legacy := 0
+	for key, tracked := range tracking {
+		id, isID := ids.resolve(key)
+		if tracked.Title != "" || isID {
+			continue
+		}
+		legacy++
+		tracked.Title = key
+		if id != "" && tracking[id] == nil {
+			delete(tracking, key)
+			tracking[id] = tracked
+		}
+	}
+	if legacy == 0 {
+		return nil
+	}
+	logger.Infof("Tracked the time of %d todo(s) by id", legacy)
+
+	return saveTimeTracking(tracking)
+}
+
+func bindTimerRequest(c *gin.Context) (timerRequest, bool) {
+	request := timerRequest{Todo: c.Query("todo")}
+	if c.Request.Method != http.MethodDelete {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": err.Error(),
+			})
+			return request, false
+		}
+	}
+
+	if request.Todo == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "todo is required",
+		})
+		return request, false
+	}
+
+	if request.Minutes < 0 || request.Minutes > maxTimerMinutes {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("minutes must be between 0 and %d", maxTimerMinutes),
+		})
+		return request, false
+	}
+
+	return request, true
+}
+
+// startTimerHandler starts a timer on a todo, with minutes it stops on its
+// own. A running timer of the todo is stopped and counted first.
+func startTimerHandler(c *gin.Context) {
+	request, ok := bindTimerRequest(c)
+	if !ok {
+		return
+	}
+
+	todos, err := listedTodos(c.Request.Context(), todosOf(c))
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	todo, exists := todos[request.Todo]
+	if !exists || todo.Done {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": fmt.Sprintf("no open todo with id %q", request.Todo),
+		})
+		return
+	}
+
+	timeTrackingMu.Lock()
+	defer timeTrackingMu.Unlock()
+
+	tracking, err := loadTimeTracking()
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	now := time.Now()
+	tracking.settle(now)
+	tracked, exists := tracking[request.Todo]
+	if !exists {
+		tracked = &trackedTodo{}
+		tracking[request.Todo] = tracked
+	}
+	tracked.stop(now)
+	tracked.Title = todo.Title
+	tracked.Running = &runningTimer{Started: now.UTC(), Minutes: request.Minutes}
+
+	if err := saveTimeTracking(tracking); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, todoTime{ID: todo.ID, Title: todo.Title, Seconds: tracked.Seconds, Running: tracked.Running})
+}
+
+func stopTimerHandler(c *gin.Context) {
+	request, ok := bindTimerRequest(c)
+	if !ok {
+		return
+	}
+
+	todos, err := listedTodos(c.Request.Context(), todosOf(c))
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	if _, exists := todos[request.Todo]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": fmt.Sprintf("no todo with id %q", request.Todo),
+		})
+		return
+	}
+
+	if err := stopTimer(request.Todo); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// listTimersHandler returns the running timers.
+func listTimersHandler(c *gin.Context) {
+	tracking, err := loadTimeTracking()
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	todos, _ := tracking.report(time.Now())
+	todos, err = readableTimes(c, todos)
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	running := []todoTime{}
+	for _, todo := range todos {
+		if todo.Running != nil {
+			running = append(running, todo)
+		}
+	}
+
+	c.JSON(http.StatusOK, running)
+}
+
+// readableTimes keeps the times of the todos the signed in account may read.
+// The times of todos that are gone are dropped as well, who could read them
+// isn't known anymore.
+func readableTimes(c *gin.Context, times []todoTime) ([]todoTime, error) {
+	todos, err := listedTodos(c.Request.Context(), todosOf(c))
+	if err != nil {
+		return nil, err
+	}
+
+	kept := []todoTime{}
+	for _, entry := range times {
+		if _, exists := todos[entry.ID]; exists {
+			kept = append(kept, entry)
+		}
+	}
+
+	return kept, nil
+}
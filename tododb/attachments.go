@@ -0,0 +1,271 @@
+package tododb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAttachmentDir     = "attachments"
+	defaultS3Region          = "us-east-1"
+	defaultS3TimeoutSeconds  = 30
+	attachmentErrorBodyBytes = 512
+)
+
+// AttachmentStore keeps the content of attachments, their metadata is stored
+// with the todo. Keys are made by BlobKey, or AttachmentKey by older
+// versions.
+type AttachmentStore interface {
+	Put(ctx context.Context, key string, content []byte, contentType string) error
+	// Get returns the content stored under key, or ErrNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete removes the content under key, a missing one is no error.
+	Delete(ctx context.Context, key string) error
+}
+
+// AttachmentKey is where older versions stored the content of an attachment
+// of a todo, once per attachment.
+func AttachmentKey(todoID, attachmentID string) string {
+	return todoID + "/" + attachmentID
+}
+
+// BlobKey is where content with the hex SHA-256 digest sum is stored, once
+// for every attachment with the same content.
+func BlobKey(sum string) string {
+	return "blobs/" + sum[:2] + "/" + sum
+}
+
+// QuarantineKey is where content with the digest sum is stored if a scan
+// found a threat in it, apart from the blobs that are served.
+func QuarantineKey(sum string) string {
+	return "quarantine/" + sum[:2] + "/" + sum
+}
+
+// ThumbnailKey is where the thumbnail of an image with the digest sum is
+// stored.
+func ThumbnailKey(sum string) string {
+	return "thumbs/" + sum[:2] + "/" + sum + ".png"
+}
+
+// OpenAttachmentStore opens the store named by driver, disk or s3, with its
+// options.
+func OpenAttachmentStore(driver string, config map[string]string) (AttachmentStore, error) {
+	switch strings.ToLower(driver) {
+	case "", "disk":
+		dir := config["dir"]
+		if dir == "" {
+			dir = defaultAttachmentDir
+		}
+		return NewDiskStore(dir)
+	case "s3":
+		return NewS3Store(config)
+	}
+
+	return nil, fmt.Errorf("Attachments: %s is not supported, use disk or s3", driver)
+}
+
+// DiskStore keeps every attachment as a file below a directory, one
+// directory per todo. Replicas need to share the directory.
+type DiskStore struct {
+	dir string
+}
+
+var _ AttachmentStore = &DiskStore{}
+
+func NewDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &DiskStore{dir: filepath.Clean(dir)}, nil
+}
+
+// path is the file of key, keys can't leave the directory.
+func (store *DiskStore) path(key string) (string, error) {
+	path := filepath.Join(store.dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, store.dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid attachment key %q", key)
+	}
+
+	return path, nil
+}
+
+// Put writes a temporary file first and renames it, readers never see a
+// partial attachment.
+func (store *DiskStore) Put(ctx context.Context, key string, content []byte, contentType string) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), ".upload-")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+
+	return err
+}
+
+func (store *DiskStore) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := store.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	return content, err
+}
+
+// Delete removes the directory of the todo with its last attachment.
+func (store *DiskStore) Delete(ctx context.Context, key string) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Fails as long as the todo has other attachments
+	os.Remove(filepath.Dir(path))
+
+	return nil
+}
+
+// S3Store keeps every attachment as an object of a bucket in S3 or a
+// compatible service like MinIO, addressed path-style. Requests are signed
+// with the credentials of the usual AWS chain.
+type S3Store struct {
+	bucket      string
+	prefix      string
+	region      string
+	endpoint    *url.URL
+	credentials *awsCredentialChain
+	client      *http.Client
+}
+
+var _ AttachmentStore = &S3Store{}
+
+func NewS3Store(config map[string]string) (*S3Store, error) {
+	bucket := config["bucket"]
+	if bucket == "" {
+		return nil, fmt.Errorf("Attachments: the s3 store needs a bucket")
+	}
+
+	region := config["region"]
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region == "" {
+			region = os.Getenv(env)
+		}
+	}
+	if region == "" {
+		region = defaultS3Region
+	}
+
+	endpoint := fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	if value, exists := config["endpoint"]; exists {
+		endpoint = strings.TrimRight(value, "/")
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Attachments: s3 endpoint: %v", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("Attachments: s3 endpoint %q needs a scheme and a host", endpoint)
+	}
+
+	return &S3Store{
+		bucket:      bucket,
+		prefix:      config["prefix"],
+		region:      region,
+		endpoint:    u,
+		credentials: newAWSCredentialChain(config["profile"]),
+		client:      &http.Client{Timeout: time.Duration(intConfig(config, "timeout", defaultS3TimeoutSeconds)) * time.Second},
+	}, nil
+}
+
+// call sends a signed request for the object of key and returns the body of
+// the answer. Answers other than 2xx are errors, a 404 is ErrNotFound.
+func (store *S3Store) call(ctx context.Context, method, key string, body []byte, contentType string) ([]byte, error) {
+	creds, err := store.credentials.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	u := *store.endpoint
+	u.Path += "/" + store.bucket + "/" + store.prefix + key
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("X-Amz-Content-Sha256", sha256Hex(body))
+	signV4(req, body, creds, store.region, "s3", time.Now())
+
+	resp, err := store.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	answer, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		if len(answer) > attachmentErrorBodyBytes {
+			answer = answer[:attachmentErrorBodyBytes]
+		}
+		return nil, fmt.Errorf("s3 %s %s answered with %s: %s", method, key, resp.Status, answer)
+	}
+
+	return answer, nil
+}
+
+func (store *S3Store) Put(ctx context.Context, key string, content []byte, contentType string) error {
+	_, err := store.call(ctx, http.MethodPut, key, content, contentType)
+	return err
+}
+
+func (store *S3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	return store.call(ctx, http.MethodGet, key, nil, "")
+}
+
+func (store *S3Store) Delete(ctx context.Context, key string) error {
+	_, err := store.call(ctx, http.MethodDelete, key, nil, "")
+	if err == ErrNotFound {
+		return nil
+	}
+
+	return err
+}
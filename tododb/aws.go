@@ -0,0 +1,341 @@
+package tododb
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	awsMetadataTimeout = 2 * time.Second
+	// awsRefreshBefore replaces temporary credentials ahead of their expiry
+	awsRefreshBefore = 5 * time.Minute
+
+	ecsCredentialsHost = "http://169.254.170.2"
+	imdsHost           = "http://169.254.169.254"
+)
+
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Expires is zero for long-lived keys
+	Expires time.Time
+	Source  string
+}
+
+// awsCredentialChain looks up credentials like the AWS SDKs do: environment,
+// shared credentials file, ECS task role and EC2 instance role. Temporary
+// credentials are cached until shortly before they expire.
+type awsCredentialChain struct {
+	profile string
+	client  *http.Client
+
+	mu     sync.Mutex
+	cached awsCredentials
+}
+
+func newAWSCredentialChain(profile string) *awsCredentialChain {
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	return &awsCredentialChain{
+		profile: profile,
+		client:  &http.Client{Timeout: awsMetadataTimeout},
+	}
+}
+
+func (chain *awsCredentialChain) get(ctx context.Context) (awsCredentials, error) {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+
+	if chain.cached.AccessKeyID != "" && (chain.cached.Expires.IsZero() || time.Until(chain.cached.Expires) > awsRefreshBefore) {
+		return chain.cached, nil
+	}
+
+	sources := []func(context.Context) (awsCredentials, bool, error){
+		chain.fromEnv,
+		chain.fromSharedFile,
+		chain.fromECS,
+		chain.fromIMDS,
+	}
+	for _, source := range sources {
+		creds, found, err := source(ctx)
+		if err != nil {
+			return awsCredentials{}, err
+		}
+		if found {
+			if chain.cached.Source != creds.Source {
+				logger.Infof("Using AWS credentials from %s", creds.Source)
+			}
+			chain.cached = creds
+			return creds, nil
+		}
+	}
+
+	return awsCredentials{}, errors.New("no AWS credentials in the environment, the shared credentials file, the ECS task or the EC2 instance")
+}
+
+func (chain *awsCredentialChain) fromEnv(ctx context.Context) (awsCredentials, bool, error) {
+	creds := awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Source:          "environment",
+	}
+
+	return creds, creds.AccessKeyID != "" && creds.SecretAccessKey != "", nil
+}
+
+func (chain *awsCredentialChain) fromSharedFile(ctx context.Context) (awsCredentials, bool, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return awsCredentials{}, false, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return awsCredentials{}, false, nil
+	}
+	if err != nil {
+		return awsCredentials{}, false, err
+	}
+	defer file.Close()
+
+	creds := awsCredentials{Source: fmt.Sprintf("profile %s of %s", chain.profile, path)}
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != chain.profile {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = value
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = value
+		case "aws_session_token":
+			creds.SessionToken = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return awsCredentials{}, false, err
+	}
+
+	return creds, creds.AccessKeyID != "" && creds.SecretAccessKey != "", nil
+}
+
+// awsRoleCredentials is the answer of the ECS and EC2 credential endpoints.
+type awsRoleCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+func (role awsRoleCredentials) credentials(source string) awsCredentials {
+	return awsCredentials{
+		AccessKeyID:     role.AccessKeyID,
+		SecretAccessKey: role.SecretAccessKey,
+		SessionToken:    role.Token,
+		Expires:         role.Expiration,
+		Source:          source,
+	}
+}
+
+// fromECS reads the credentials of the task role, ECS and Fargate set the
+// environment variables.
+func (chain *awsCredentialChain) fromECS(ctx context.Context) (awsCredentials, bool, error) {
+	url := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		url = ecsCredentialsHost + relative
+	}
+	if url == "" {
+		return awsCredentials{}, false, nil
+	}
+
+	header := http.Header{}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		header.Set("Authorization", token)
+	}
+
+	var role awsRoleCredentials
+	if err := chain.getJSON(ctx, url, header, &role); err != nil {
+		return awsCredentials{}, false, fmt.Errorf("ECS task credentials: %v", err)
+	}
+
+	return role.credentials("ECS task role"), true, nil
+}
+
+// fromIMDS reads the credentials of the instance role with IMDSv2. Outside
+// of EC2 the metadata service doesn't answer, which isn't an error.
+func (chain *awsCredentialChain) fromIMDS(ctx context.Context) (awsCredentials, bool, error) {
+	req, err := http.NewRequest(http.MethodPut, imdsHost+"/latest/api/token", nil)
+	if err != nil {
+		return awsCredentials{}, false, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	resp, err := chain.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return awsCredentials{}, false, nil
+	}
+	token, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return awsCredentials{}, false, nil
+	}
+
+	header := http.Header{}
+	header.Set("X-aws-ec2-metadata-token", string(token))
+	roles, err := chain.fetch(ctx, imdsHost+"/latest/meta-data/iam/security-credentials/", header)
+	if err != nil {
+		return awsCredentials{}, false, fmt.Errorf("EC2 instance role: %v", err)
+	}
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if role == "" {
+		return awsCredentials{}, false, nil
+	}
+
+	var creds awsRoleCredentials
+	if err := chain.getJSON(ctx, imdsHost+"/latest/meta-data/iam/security-credentials/"+role, header, &creds); err != nil {
+		return awsCredentials{}, false, fmt.Errorf("EC2 instance role %s: %v", role, err)
+	}
+
+	return creds.credentials("EC2 instance role " + role), true, nil
+}
+
+func (chain *awsCredentialChain) fetch(ctx context.Context, url string, header http.Header) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+
+	resp, err := chain.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered with %s", url, resp.Status)
+	}
+
+	return body, nil
+}
+
+func (chain *awsCredentialChain) getJSON(ctx context.Context, url string, header http.Header, v interface{}) error {
+	body, err := chain.fetch(ctx, url, header)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// signV4 adds the Signature Version 4 headers to req. Every header already
+// set on req is signed, body must be the body of req.
+func signV4(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+
+	scope := strings.Join([]string{day, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
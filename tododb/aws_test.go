@@ -0,0 +1,147 @@
+package tododb
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignV4(t *testing.T) {
+	// The examples of the AWS Signature Version 4 documentation and test
+	// suite
+	creds := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		method        string
+		url           string
+		header        map[string]string
+		service       string
+		authorization string
+	}{
+		{
+			name:    "iam list users",
+			method:  http.MethodGet,
+			url:     "https://iam.amazonaws.com/?Version=2010-05-08&Action=ListUsers",
+			header:  map[string]string{"Content-Type": "application/x-www-form-urlencoded; charset=utf-8"},
+			service: "iam",
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+				"SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+		{
+			name:    "get vanilla",
+			method:  http.MethodGet,
+			url:     "https://example.amazonaws.com/",
+			service: "service",
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:    "post vanilla",
+			method:  http.MethodPost,
+			url:     "https://example.amazonaws.com/",
+			service: "service",
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, test.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range test.header {
+				req.Header.Set(name, value)
+			}
+
+			signV4(req, nil, creds, "us-east-1", test.service, now)
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %s, want 20150830T123600Z", got)
+			}
+			if got := req.Header.Get("Authorization"); got != test.authorization {
+				t.Errorf("Authorization = %s, want %s", got, test.authorization)
+			}
+		})
+	}
+}
+
+func TestSignV4SessionToken(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "https://dynamodb.eu-west-1.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := awsCredentials{AccessKeyID: "ASIA", SecretAccessKey: "secret", SessionToken: "token"}
+
+	signV4(req, []byte("{}"), creds, "eu-west-1", "dynamodb", time.Now())
+	if got := req.Header.Get("X-Amz-Security-Token"); got != "token" {
+		t.Errorf("X-Amz-Security-Token = %q, want token", got)
+	}
+	want := "SignedHeaders=host;x-amz-date;x-amz-security-token,"
+	if got := req.Header.Get("Authorization"); !strings.Contains(got, want) {
+		t.Errorf("Authorization = %s, want %s", got, want)
+	}
+}
+
+func TestAWSSharedCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "credentials")
+	content := `# comment
+[default]
+aws_access_key_id = AKIDDEFAULT
+aws_secret_access_key = default-secret
+
+[ci]
+aws_access_key_id=AKIDCI
+aws_secret_access_key=ci-secret
+aws_session_token=ci-token
+
+[partial]
+aws_access_key_id = AKIDPARTIAL
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("AWS_SHARED_CREDENTIALS_FILE", os.Getenv("AWS_SHARED_CREDENTIALS_FILE"))
+	os.Setenv("AWS_SHARED_CREDENTIALS_FILE", path)
+
+	tests := []struct {
+		profile string
+		key     string
+		secret  string
+		token   string
+		found   bool
+	}{
+		{profile: "default", key: "AKIDDEFAULT", secret: "default-secret", found: true},
+		{profile: "ci", key: "AKIDCI", secret: "ci-secret", token: "ci-token", found: true},
+		{profile: "partial"},
+		{profile: "missing"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.profile, func(t *testing.T) {
+			creds, found, err := newAWSCredentialChain(test.profile).fromSharedFile(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != test.found {
+				t.Fatalf("fromSharedFile() found = %v, want %v", found, test.found)
+			}
+			if found && (creds.AccessKeyID != test.key || creds.SecretAccessKey != test.secret || creds.SessionToken != test.token) {
+				t.Errorf("fromSharedFile() = %+v", creds)
+			}
+		})
+	}
+}
@@ -0,0 +1,669 @@
+package tododb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/johscheuer/todo-app-web/features"
+)
+
+const (
+	defaultCassandraHosts          = "localhost"
+	defaultCassandraKeyspace       = "todo_app"
+	defaultCassandraReplication    = "{'class': 'SimpleStrategy', 'replication_factor': 1}"
+	defaultCassandraConsistency    = "LOCAL_QUORUM"
+	defaultCassandraTimeoutSeconds = 5
+
+	// cassandraList is the partition key of all todos, there is only one list
+	cassandraList = "default"
+)
+
+// CassandraDB keeps every todo as a row of one partition, clustered by a
+// timeuuid so they come back in the order they were added. Reads and writes
+// use their own consistency level, with localDC set the driver prefers the
+// nodes of that datacenter.
+type CassandraDB struct {
+	session          *gocql.Session
+	keyspace         string
+	readConsistency  gocql.Consistency
+	writeConsistency gocql.Consistency
+	nodes            *cassandraNodes
+	metrics          *Metrics
+}
+
+var _ TodoDB = &CassandraDB{}
+
+// cassandraNodes wraps the host selection policy to learn about the nodes
+// and their state from the driver, which watches the cluster anyway.
+type cassandraNodes struct {
+	gocql.HostSelectionPolicy
+
+	mu    sync.Mutex
+	hosts map[string]*gocql.HostInfo
+}
+
+func (nodes *cassandraNodes) AddHost(host *gocql.HostInfo) {
+	nodes.mu.Lock()
+	nodes.hosts[host.ConnectAddress().String()] = host
+	nodes.mu.Unlock()
+	nodes.HostSelectionPolicy.AddHost(host)
+}
+
+func (nodes *cassandraNodes) RemoveHost(host *gocql.HostInfo) {
+	nodes.mu.Lock()
+	delete(nodes.hosts, host.ConnectAddress().String())
+	nodes.mu.Unlock()
+	nodes.HostSelectionPolicy.RemoveHost(host)
+}
+
+func (nodes *cassandraNodes) HostUp(host *gocql.HostInfo) {
+	nodes.mu.Lock()
+	nodes.hosts[host.ConnectAddress().String()] = host
+	nodes.mu.Unlock()
+	nodes.HostSelectionPolicy.HostUp(host)
+}
+
+// list returns the known nodes sorted by datacenter and address.
+func (nodes *cassandraNodes) list() []*gocql.HostInfo {
+	nodes.mu.Lock()
+	defer nodes.mu.Unlock()
+
+	hosts := make([]*gocql.HostInfo, 0, len(nodes.hosts))
+	for _, host := range nodes.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if hosts[i].DataCenter() != hosts[j].DataCenter() {
+			return hosts[i].DataCenter() < hosts[j].DataCenter()
+		}
+		return hosts[i].ConnectAddress().String() < hosts[j].ConnectAddress().String()
+	})
+
+	return hosts
+}
+
+func cassandraConsistency(config map[string]string, key string) (gocql.Consistency, error) {
+	value := defaultCassandraConsistency
+	if v, exists := config[key]; exists {
+		value = v
+	}
+
+	consistency, err := gocql.ParseConsistencyWrapper(strings.ToUpper(value))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", key, err)
+	}
+
+	return consistency, nil
+}
+
+func NewCassandraDB(config map[string]string) (*CassandraDB, error) {
+	hosts := defaultCassandraHosts
+	if value, exists := config["hosts"]; exists {
+		hosts = value
+	}
+	keyspace := defaultCassandraKeyspace
+	if value, exists := config["keyspace"]; exists {
+		keyspace = value
+	}
+	replication := defaultCassandraReplication
+	if value, exists := config["replication"]; exists {
+		replication = value
+	}
+
+	readConsistency, err := cassandraConsistency(config, "readConsistency")
+	if err != nil {
+		return nil, err
+	}
+	writeConsistency, err := cassandraConsistency(config, "writeConsistency")
+	if err != nil {
+		return nil, err
+	}
+
+	cluster := gocql.NewCluster(strings.Split(hosts, ",")...)
+	cluster.Timeout = time.Duration(intConfig(config, "timeout", defaultCassandraTimeoutSeconds)) * time.Second
+	cluster.ConnectTimeout = cluster.Timeout
+	if username, exists := config["username"]; exists {
+		cluster.Authenticator = gocql.PasswordAuthenticator{
+			Username: username,
+			Password: config["password"],
+		}
+	}
+
+	// The keyspace has to exist before a session can use it
+	setup, err := cluster.CreateSession()
+	if err != nil {
+		return nil, fmt.Errorf("cassandra %s: %v", hosts, err)
+	}
+	err = setup.Query(fmt.Sprintf("CREATE KEYSPACE IF NOT EXISTS %s WITH replication = %s", keyspace, replication)).Exec()
+	if err == nil {
+		err = setup.Query(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.todos (
+			list text,
+			id timeuuid,
+			title text,
+			doc text,
+			PRIMARY KEY (list, id)
+		)`, keyspace)).Exec()
+	}
+	if err == nil {
+		err = addCassandraDocColumn(setup, keyspace)
+	}
+	if err == nil {
+		err = setup.Query(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.kv_values (
+			name text PRIMARY KEY,
+			value text
+		)`, keyspace)).Exec()
+	}
+	setup.Close()
+	if err != nil {
+		return nil, fmt.Errorf("cassandra keyspace %s: %v", keyspace, err)
+	}
+
+	policy := gocql.RoundRobinHostPolicy()
+	if localDC, exists := config["localDC"]; exists {
+		policy = gocql.DCAwareRoundRobinPolicy(localDC)
+	}
+	nodes := &cassandraNodes{
+		HostSelectionPolicy: gocql.TokenAwareHostPolicy(policy),
+		hosts:               map[string]*gocql.HostInfo{},
+	}
+	cluster.PoolConfig.HostSelectionPolicy = nodes
+	cluster.Keyspace = keyspace
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return nil, fmt.Errorf("cassandra %s: %v", hosts, err)
+	}
+
+	return &CassandraDB{
+		session:          session,
+		keyspace:         keyspace,
+		readConsistency:  readConsistency,
+		writeConsistency: writeConsistency,
+		nodes:            nodes,
+		metrics:          NewMetrics(),
+	}, nil
+}
+
+func (cassandraDB *CassandraDB) read(ctx context.Context, stmt string, values ...interface{}) *gocql.Query {
+	return cassandraDB.session.Query(stmt, values...).WithContext(ctx).Consistency(cassandraDB.readConsistency)
+}
+
+// addCassandraDocColumn adds the doc column to tables of older versions,
+// which only had a title.
+func addCassandraDocColumn(session *gocql.Session, keyspace string) error {
+	var column string
+	err := session.Query("SELECT column_name FROM system_schema.columns WHERE keyspace_name = ? AND table_name = 'todos' AND column_name = 'doc'", keyspace).Scan(&column)
+	if err != gocql.ErrNotFound {
+		return err
+	}
+
+	return session.Query(fmt.Sprintf("ALTER TABLE %s.todos ADD doc text", keyspace)).Exec()
+}
+
+// cassandraTodo reads the doc column, rows of older versions only have a
+// title and the key of the row is their id.
+func cassandraTodo(id gocql.UUID, title, doc string) Todo {
+	if doc == "" {
+		return Todo{ID: id.String(), Title: title}
+	}
+
+	return unmarshalTodo(doc)
+}
+
+func (cassandraDB *CassandraDB) write(ctx context.Context, stmt string, values ...interface{}) *gocql.Query {
+	return cassandraDB.session.Query(stmt, values...).WithContext(ctx).Consistency(cassandraDB.writeConsistency)
+}
+
+func (cassandraDB *CassandraDB) GetAllTodos(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	todos := []Todo{}
+	err := cassandraDB.ForEachTodo(ctx, func(todo Todo) error {
+		if matchesAll(filters, todo) {
+			todos = append(todos, todo)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return todos, nil
+}
+
+func (cassandraDB *CassandraDB) GetTodos(ctx context.Context, offset, limit int, filters ...TodoFilter) ([]Todo, error) {
+	return pageTodos(ctx, cassandraDB.ForEachTodo, offset, limit, filters)
+}
+
+func (cassandraDB *CassandraDB) CountTodos(ctx context.Context, filters ...TodoFilter) (int, error) {
+	return countTodos(ctx, cassandraDB.ForEachTodo, filters)
+}
+
+func (cassandraDB *CassandraDB) GetOverdueTodos(ctx context.Context) ([]Todo, error) {
+	return todosByDue(ctx, cassandraDB.GetAllTodos, overdueFilter())
+}
+
+func (cassandraDB *CassandraDB) GetTodosDueBefore(ctx context.Context, t time.Time) ([]Todo, error) {
+	return todosByDue(ctx, cassandraDB.GetAllTodos, TodoFilter{DueBefore: t})
+}
+
+func (cassandraDB *CassandraDB) GetTodosByPriority(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	return todosByPriority(ctx, cassandraDB.GetAllTodos, filters)
+}
+
+func (cassandraDB *CassandraDB) GetTodosByTag(ctx context.Context, tag string) ([]Todo, error) {
+	return todosByTag(ctx, cassandraDB.GetAllTodos, tag)
+}
+
+func (cassandraDB *CassandraDB) ListTags(ctx context.Context) ([]Tag, error) {
+	return CountTags(ctx, cassandraDB.ForEachTodo)
+}
+
+func (cassandraDB *CassandraDB) SearchTodos(ctx context.Context, query string) ([]Todo, error) {
+	return searchTodos(ctx, cassandraDB.GetAllTodos, query)
+}
+
+func (cassandraDB *CassandraDB) ForEachTodo(ctx context.Context, fn func(Todo) error) error {
+	if features.Enabled(features.PerfNPlusOne) {
+		return cassandraDB.forEachTodoOneByOne(ctx, fn)
+	}
+
+	iter := cassandraDB.read(ctx, "SELECT id, title, doc FROM todos WHERE list = ?", cassandraList).Iter()
+	var (
+		id         gocql.UUID
+		title, doc string
+	)
+	for iter.Scan(&id, &title, &doc) {
+		if err := fn(cassandraTodo(id, title, doc)); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+
+	return iter.Close()
+}
+
+// forEachTodoOneByOne is the deliberately slow variant of ForEachTodo, with
+// one query per todo.
+func (cassandraDB *CassandraDB) forEachTodoOneByOne(ctx context.Context, fn func(Todo) error) error {
+	ids, err := cassandraDB.ids(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		var title, doc string
+		err := cassandraDB.read(ctx, "SELECT title, doc FROM todos WHERE list = ? AND id = ?", cassandraList, id).Scan(&title, &doc)
+		if err == gocql.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(cassandraTodo(id, title, doc)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cassandraDB *CassandraDB) ids(ctx context.Context) ([]gocql.UUID, error) {
+	ids := []gocql.UUID{}
+	iter := cassandraDB.read(ctx, "SELECT id FROM todos WHERE list = ?", cassandraList).Iter()
+	var id gocql.UUID
+	for iter.Scan(&id) {
+		ids = append(ids, id)
+	}
+
+	return ids, iter.Close()
+}
+
+func (cassandraDB *CassandraDB) SaveTodo(ctx context.Context, todo Todo) error {
+	todo = todo.withDefaults()
+	return cassandraDB.write(ctx, "INSERT INTO todos (list, id, title, doc) VALUES (?, ?, ?, ?)", cassandraList, gocql.TimeUUID(), todo.Title, marshalTodo(todo)).Exec()
+}
+
+// SaveTodos writes all todos in one batch, they only touch one partition.
+func (cassandraDB *CassandraDB) SaveTodos(ctx context.Context, todos []Todo) error {
+	batch := cassandraDB.session.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+	batch.SetConsistency(cassandraDB.writeConsistency)
+	for _, todo := range todos {
+		todo = todo.withDefaults()
+		batch.Query("INSERT INTO todos (list, id, title, doc) VALUES (?, ?, ?, ?)", cassandraList, gocql.TimeUUID(), todo.Title, marshalTodo(todo))
+	}
+
+	return cassandraDB.session.ExecuteBatch(batch)
+}
+
+func (cassandraDB *CassandraDB) DeleteTodo(ctx context.Context, id string) error {
+	key, _, err := cassandraDB.find(ctx, id)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return cassandraDB.write(ctx, "DELETE FROM todos WHERE list = ? AND id = ?", cassandraList, key).Exec()
+}
+
+func (cassandraDB *CassandraDB) DeleteTodos(ctx context.Context, ids []string) error {
+	return deleteEach(ctx, ids, cassandraDB.DeleteTodo)
+}
+
+func (cassandraDB *CassandraDB) UpdateTodo(ctx context.Context, id string, update func(*Todo)) error {
+	return cassandraDB.updateTodo(ctx, id, update)
+}
+
+func (cassandraDB *CassandraDB) UpdateTodos(ctx context.Context, ids []string, update func(*Todo)) error {
+	return updateEach(ctx, ids, update, cassandraDB.UpdateTodo)
+}
+
+func (cassandraDB *CassandraDB) CompleteTodo(ctx context.Context, id string) error {
+	return cassandraDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = true
+	})
+}
+
+func (cassandraDB *CassandraDB) CompleteTodos(ctx context.Context, ids []string) error {
+	return completeEach(ctx, ids, cassandraDB.CompleteTodo)
+}
+
+func (cassandraDB *CassandraDB) ReopenTodo(ctx context.Context, id string) error {
+	return cassandraDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = false
+	})
+}
+
+func (cassandraDB *CassandraDB) SetDescription(ctx context.Context, id string, description string) error {
+	return cassandraDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Description = description
+	})
+}
+
+func (cassandraDB *CassandraDB) SetDue(ctx context.Context, id string, due *time.Time) error {
+	return cassandraDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Due = due
+	})
+}
+
+func (cassandraDB *CassandraDB) SetPriority(ctx context.Context, id string, priority int) error {
+	return cassandraDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Priority = priority
+	})
+}
+
+func (cassandraDB *CassandraDB) SetTags(ctx context.Context, id string, tags []string) error {
+	return cassandraDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Tags = tags
+	})
+}
+
+func (cassandraDB *CassandraDB) SetRecurrence(ctx context.Context, id string, recurrence string) error {
+	return cassandraDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Recurrence = recurrence
+	})
+}
+
+func (cassandraDB *CassandraDB) AddSubTask(ctx context.Context, id string, title string) (SubTask, error) {
+	var subTask SubTask
+	err := cassandraDB.updateTodo(ctx, id, func(todo *Todo) {
+		subTask = todo.addSubTask(title)
+	})
+
+	return subTask, err
+}
+
+func (cassandraDB *CassandraDB) CompleteSubTask(ctx context.Context, id string, subTaskID string) error {
+	found := false
+	err := cassandraDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.completeSubTask(subTaskID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (cassandraDB *CassandraDB) GetSubTasks(ctx context.Context, id string) ([]SubTask, error) {
+	return subTasksOf(ctx, cassandraDB.ForEachTodo, id)
+}
+
+func (cassandraDB *CassandraDB) AddAttachment(ctx context.Context, id string, attachment Attachment) error {
+	return cassandraDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.addAttachment(attachment)
+	})
+}
+
+func (cassandraDB *CassandraDB) RemoveAttachment(ctx context.Context, id string, attachmentID string) error {
+	found := false
+	err := cassandraDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.removeAttachment(attachmentID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// MoveTodo writes the todos from the old to the new position of the todo
+// under the keys of their new rows, in one logged batch. The keys keep the
+// order.
+func (cassandraDB *CassandraDB) MoveTodo(ctx context.Context, id string, position int) error {
+	keys := []gocql.UUID{}
+	todos := []Todo{}
+	iter := cassandraDB.read(ctx, "SELECT id, title, doc FROM todos WHERE list = ?", cassandraList).Iter()
+	var (
+		key        gocql.UUID
+		title, doc string
+	)
+	for iter.Scan(&key, &title, &doc) {
+		keys = append(keys, key)
+		todos = append(todos, cassandraTodo(key, title, doc))
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	order, err := moveOrder(todos, id, position)
+	if err != nil {
+		return err
+	}
+
+	batch := cassandraDB.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	batch.SetConsistency(cassandraDB.writeConsistency)
+	for i, old := range order {
+		if old != i {
+			todo := todos[old].withDefaults()
+			batch.Query("UPDATE todos SET title = ?, doc = ? WHERE list = ? AND id = ?", todo.Title, marshalTodo(todo), cassandraList, keys[i])
+		}
+	}
+
+	return cassandraDB.session.ExecuteBatch(batch)
+}
+
+// updateTodo writes the changed todo under its key with a lightweight
+// transaction, so a todo deleted in between isn't written again.
+func (cassandraDB *CassandraDB) updateTodo(ctx context.Context, id string, fn func(*Todo)) error {
+	key, todo, err := cassandraDB.find(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	todo = todo.edited(fn)
+	applied, err := cassandraDB.write(ctx, "UPDATE todos SET title = ?, doc = ? WHERE list = ? AND id = ? IF EXISTS", todo.Title, marshalTodo(todo), cassandraList, key).ScanCAS()
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// find returns the key and the todo of the first todo with id.
+func (cassandraDB *CassandraDB) find(ctx context.Context, id string) (gocql.UUID, Todo, error) {
+	iter := cassandraDB.read(ctx, "SELECT id, title, doc FROM todos WHERE list = ?", cassandraList).Iter()
+	var (
+		key        gocql.UUID
+		title, doc string
+	)
+	for iter.Scan(&key, &title, &doc) {
+		if todo := cassandraTodo(key, title, doc); todo.ID == id {
+			iter.Close()
+			return key, todo, nil
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return gocql.UUID{}, Todo{}, err
+	}
+
+	return gocql.UUID{}, Todo{}, ErrNotFound
+}
+
+// ReplaceAllTodos deletes the partition and writes the new todos in one
+// logged batch. The inserts get a later timestamp than the delete, otherwise
+// the delete would win.
+func (cassandraDB *CassandraDB) ReplaceAllTodos(ctx context.Context, todos []Todo) error {
+	now := time.Now().UnixNano() / int64(time.Microsecond)
+
+	batch := cassandraDB.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	batch.SetConsistency(cassandraDB.writeConsistency)
+	batch.Query("DELETE FROM todos USING TIMESTAMP ? WHERE list = ?", now, cassandraList)
+	for _, todo := range todos {
+		todo = todo.withDefaults()
+		batch.Query("INSERT INTO todos (list, id, title, doc) VALUES (?, ?, ?, ?) USING TIMESTAMP ?", cassandraList, gocql.TimeUUID(), todo.Title, marshalTodo(todo), now+1)
+	}
+
+	return cassandraDB.session.ExecuteBatch(batch)
+}
+
+// GetUsage reports the estimated size of the table as StoredBytes, which
+// every node updates about every five minutes from its SSTables.
+func (cassandraDB *CassandraDB) GetUsage(ctx context.Context) (Usage, error) {
+	var usage Usage
+	err := cassandraDB.ForEachTodo(ctx, func(todo Todo) error {
+		usage.Todos++
+		usage.RawBytes += int64(len(marshalTodo(todo)))
+		return nil
+	})
+	if err != nil {
+		return Usage{}, err
+	}
+
+	iter := cassandraDB.session.Query("SELECT mean_partition_size, partitions_count FROM system.size_estimates WHERE keyspace_name = ? AND table_name = 'todos'",
+		cassandraDB.keyspace).WithContext(ctx).Consistency(gocql.One).Iter()
+	var meanSize, partitions int64
+	for iter.Scan(&meanSize, &partitions) {
+		usage.StoredBytes += meanSize * partitions
+	}
+	if err := iter.Close(); err != nil {
+		return Usage{}, err
+	}
+
+	hostname := getHostname()
+	cassandraDB.metrics.storageRawBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.RawBytes))
+	cassandraDB.metrics.storageStoredBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.StoredBytes))
+
+	return usage, nil
+}
+
+// The values of the KV are rows of kv_values, expiring with the TTL of
+// Cassandra, which is in seconds.
+func cassandraTTL(ttl time.Duration) int {
+	if ttl <= 0 {
+		return 0
+	}
+
+	return int((ttl + time.Second - 1) / time.Second)
+}
+
+func (cassandraDB *CassandraDB) GetValue(key string) (string, error) {
+	var value string
+	err := cassandraDB.read(context.Background(), "SELECT value FROM kv_values WHERE name = ?", key).Scan(&value)
+	if err == gocql.ErrNotFound {
+		return "", ErrNotFound
+	}
+
+	return value, err
+}
+
+func (cassandraDB *CassandraDB) SetValue(key, value string, ttl time.Duration) error {
+	return cassandraDB.write(context.Background(), "INSERT INTO kv_values (name, value) VALUES (?, ?) USING TTL ?", key, value, cassandraTTL(ttl)).Exec()
+}
+
+func (cassandraDB *CassandraDB) DeleteValue(key string) error {
+	return cassandraDB.write(context.Background(), "DELETE FROM kv_values WHERE name = ?", key).Exec()
+}
+
+// TakeValue deletes the value it read with a lightweight transaction, only
+// if the row still has it, so a single take gets it.
+func (cassandraDB *CassandraDB) TakeValue(key string) (string, error) {
+	ctx := context.Background()
+	var value string
+	err := cassandraDB.read(ctx, "SELECT value FROM kv_values WHERE name = ?", key).Scan(&value)
+	if err == gocql.ErrNotFound {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	applied, err := cassandraDB.write(ctx, "DELETE FROM kv_values WHERE name = ? IF value = ?", key, value).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return "", err
+	}
+	if !applied {
+		return "", ErrNotFound
+	}
+
+	return value, nil
+}
+
+// IncrValue writes the counted up value with a lightweight transaction, only
+// if the row still has the value it was counted up from. Without a ttl the
+// value keeps the rest of its TTL.
+func (cassandraDB *CassandraDB) IncrValue(key string, ttl time.Duration) (int64, error) {
+	ctx := context.Background()
+	for attempt := 1; attempt <= kvIncrAttempts; attempt++ {
+		var old string
+		var remaining *int
+		err := cassandraDB.read(ctx, "SELECT value, TTL(value) FROM kv_values WHERE name = ?", key).Scan(&old, &remaining)
+		if err != nil && err != gocql.ErrNotFound {
+			return 0, err
+		}
+		exists := err == nil
+
+		entry, count, err := kvEntry{Value: old}.incremented(ttl)
+		if err != nil {
+			return 0, err
+		}
+		seconds := cassandraTTL(ttl)
+		if seconds == 0 && remaining != nil {
+			seconds = *remaining
+		}
+
+		var query *gocql.Query
+		if exists {
+			query = cassandraDB.write(ctx, "UPDATE kv_values USING TTL ? SET value = ? WHERE name = ? IF value = ?", seconds, entry.Value, key, old)
+		} else {
+			query = cassandraDB.write(ctx, "INSERT INTO kv_values (name, value) VALUES (?, ?) IF NOT EXISTS USING TTL ?", key, entry.Value, seconds)
+		}
+		applied, err := query.MapScanCAS(map[string]interface{}{})
+		if err != nil {
+			return 0, err
+		}
+		if applied {
+			return count, nil
+		}
+	}
+
+	return 0, fmt.Errorf("value %s kept changing, gave up after %d attempts", key, kvIncrAttempts)
+}
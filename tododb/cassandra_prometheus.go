@@ -0,0 +1,60 @@
+package tododb
+
+import (
+	"context"
+
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func (cassandraDB *CassandraDB) RegisterMetrics(registerer prometheus.Registerer) error {
+	m := cassandraDB.metrics
+	err := register(registerer,
+		m.cassandraNodesTotal,
+		m.cassandraNodesHealthyTotal,
+		m.storageRawBytes,
+		m.storageStoredBytes,
+	)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Registered Cassandra Metrics")
+	return nil
+}
+
+// GetHealthStatus reports every node the driver knows of with its
+// datacenter, and whether a read with the read consistency succeeds.
+func (cassandraDB *CassandraDB) GetHealthStatus(ctx context.Context) map[string]string {
+	result := map[string]string{"self": okString, "cassandra": okString}
+	hostname := getHostname()
+
+	var version string
+	err := cassandraDB.read(ctx, "SELECT release_version FROM system.local").Scan(&version)
+	if err != nil {
+		result["cassandra"] = err.Error()
+	}
+
+	total := map[string]int{}
+	healthy := map[string]int{}
+	for _, host := range cassandraDB.nodes.list() {
+		dc := host.DataCenter()
+		status := okString
+		if !host.IsUp() {
+			status = "down"
+		}
+		result["cassandra-"+dc+"-"+host.ConnectAddress().String()] = status
+
+		total[dc]++
+		if status == okString {
+			healthy[dc]++
+		}
+	}
+
+	for dc := range total {
+		cassandraDB.metrics.cassandraNodesTotal.WithLabelValues(hostname, buildinfo.Version, dc).Set(float64(total[dc]))
+		cassandraDB.metrics.cassandraNodesHealthyTotal.WithLabelValues(hostname, buildinfo.Version, dc).Set(float64(healthy[dc]))
+	}
+
+	return result
+}
@@ -0,0 +1,581 @@
+package tododb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/johscheuer/todo-app-web/features"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultCockroachURL          = "postgresql://root@localhost:26257/todo_app?sslmode=disable"
+	defaultCockroachMaxRetries   = 10
+	cockroachRetryBackoff        = 10 * time.Millisecond
+	cockroachMaxRetryBackoff     = time.Second
+	cockroachRetrySavepoint      = "cockroach_restart"
+	cockroachSerializationFailed = "40001"
+)
+
+// cockroachMigrations are applied in order, each exactly once. Never change
+// an existing entry, append a new one.
+var cockroachMigrations = []string{
+	`CREATE TABLE todos (
+		id INT8 PRIMARY KEY DEFAULT unique_rowid(),
+		title STRING NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`ALTER TABLE todos ADD COLUMN doc JSONB`,
+	`CREATE INDEX todos_doc_id ON todos ((doc->>'id'))`,
+	`CREATE INDEX todos_doc_due ON todos ((doc->>'due'))`,
+	`CREATE TABLE kv_values (
+		name STRING PRIMARY KEY,
+		value STRING NOT NULL,
+		expires_at INT8 NOT NULL DEFAULT 0
+	)`,
+}
+
+// CockroachDB keeps every todo as a row like PostgresDB, ordered by
+// unique_rowid, which grows with the time a row was inserted. Every write is
+// a transaction that is retried when CockroachDB aborts it because of a
+// conflict with another transaction.
+type CockroachDB struct {
+	db         *sql.DB
+	maxRetries int
+	kv         sqlKV
+	metrics    *Metrics
+}
+
+var _ TodoDB = &CockroachDB{}
+
+func NewCockroachDB(config map[string]string) (*CockroachDB, error) {
+	url := defaultCockroachURL
+	if value, exists := config["url"]; exists {
+		url = value
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(intConfig(config, "maxOpenConns", defaultPostgresMaxOpenConns))
+	db.SetMaxIdleConns(intConfig(config, "maxIdleConns", defaultPostgresMaxIdleConns))
+	db.SetConnMaxLifetime(time.Duration(intConfig(config, "connMaxLifetime", defaultPostgresConnMaxLifetime)) * time.Second)
+
+	cockroachDB := &CockroachDB{
+		db:         db,
+		maxRetries: intConfig(config, "maxRetries", defaultCockroachMaxRetries),
+		metrics:    NewMetrics(),
+	}
+	// The queries of postgres work with CockroachDB, increments run in the
+	// retry loop
+	cockroachDB.kv = sqlKV{db: db, inTx: cockroachDB.inTx, queries: postgresKVQueries}
+
+	if err := cockroachDB.migrate(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return cockroachDB, nil
+}
+
+// migrate creates the schema or brings it up to date. CockroachDB has no
+// advisory locks, replicas migrating at once conflict and the retry sees the
+// migrations of the other one. Every migration gets a transaction of its
+// own, a column can't be used in the transaction that added it.
+func (cockroachDB *CockroachDB) migrate(ctx context.Context) error {
+	_, err := cockroachDB.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT8 PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	for i := range cockroachMigrations {
+		err := cockroachDB.inTx(ctx, func(tx *sql.Tx) error {
+			var version int
+			if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+				return err
+			}
+			if version > i {
+				return nil
+			}
+
+			if _, err := tx.ExecContext(ctx, cockroachMigrations[i]); err != nil {
+				return fmt.Errorf("migration %d: %v", i+1, err)
+			}
+			if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", i+1); err != nil {
+				return err
+			}
+			logger.Infof("Applied schema migration %d", i+1)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isCockroachRetry(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == cockroachSerializationFailed
+}
+
+// inTx runs fn in the transaction retry loop recommended by CockroachDB: on
+// a serialization failure fn starts over from the cockroach_restart
+// savepoint, which keeps the priority of the transaction, after a growing
+// backoff. Nothing of fn is kept if it fails for good.
+func (cockroachDB *CockroachDB) inTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := cockroachDB.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+cockroachRetrySavepoint); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	backoff := cockroachRetryBackoff
+	for retry := 0; ; retry++ {
+		err := fn(tx)
+		if err == nil {
+			// Releasing the savepoint commits, conflicts show up here
+			_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT "+cockroachRetrySavepoint)
+		}
+		if err == nil {
+			return tx.Commit()
+		}
+		if !isCockroachRetry(err) || retry >= cockroachDB.maxRetries {
+			tx.Rollback()
+			return err
+		}
+
+		cockroachDB.metrics.cockroachRetriesTotal.WithLabelValues(getHostname(), buildinfo.Version).Inc()
+		logger.Debugf("Retrying transaction after %v: %v", backoff, err)
+		if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+cockroachRetrySavepoint); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			tx.Rollback()
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > cockroachMaxRetryBackoff {
+			backoff = cockroachMaxRetryBackoff
+		}
+	}
+}
+
+func (cockroachDB *CockroachDB) GetAllTodos(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	todos := []Todo{}
+	err := cockroachDB.ForEachTodo(ctx, func(todo Todo) error {
+		if matchesAll(filters, todo) {
+			todos = append(todos, todo)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return todos, nil
+}
+
+// GetTodos lets the database skip to the page, unless filters are given.
+func (cockroachDB *CockroachDB) GetTodos(ctx context.Context, offset, limit int, filters ...TodoFilter) ([]Todo, error) {
+	if len(filters) > 0 || features.Enabled(features.PerfNPlusOne) {
+		return pageTodos(ctx, cockroachDB.ForEachTodo, offset, limit, filters)
+	}
+	if err := checkPage(offset, limit); err != nil {
+		return nil, err
+	}
+
+	rows, err := cockroachDB.db.QueryContext(ctx, "SELECT id, title, doc FROM todos ORDER BY id LIMIT $1 OFFSET $2", limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanSQLTodos(rows)
+}
+
+func (cockroachDB *CockroachDB) CountTodos(ctx context.Context, filters ...TodoFilter) (int, error) {
+	if len(filters) > 0 {
+		return countTodos(ctx, cockroachDB.ForEachTodo, filters)
+	}
+
+	var count int
+	err := cockroachDB.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM todos").Scan(&count)
+	return count, err
+}
+
+// GetOverdueTodos and GetTodosDueBefore compare the due dates of the docs
+// as strings, which sort like the times, see Todo.
+func (cockroachDB *CockroachDB) GetOverdueTodos(ctx context.Context) ([]Todo, error) {
+	rows, err := cockroachDB.db.QueryContext(ctx, "SELECT id, title, doc FROM todos WHERE doc->>'due' < $1 AND NOT COALESCE((doc->>'done')::BOOL, false) ORDER BY doc->>'due', id", dueKey(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+
+	return scanSQLTodos(rows)
+}
+
+func (cockroachDB *CockroachDB) GetTodosDueBefore(ctx context.Context, t time.Time) ([]Todo, error) {
+	rows, err := cockroachDB.db.QueryContext(ctx, "SELECT id, title, doc FROM todos WHERE doc->>'due' < $1 ORDER BY doc->>'due', id", dueKey(t))
+	if err != nil {
+		return nil, err
+	}
+
+	return scanSQLTodos(rows)
+}
+
+func (cockroachDB *CockroachDB) GetTodosByPriority(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	rows, err := cockroachDB.db.QueryContext(ctx, "SELECT id, title, doc FROM todos ORDER BY COALESCE((doc->>'priority')::INT, 0) DESC, id")
+	if err != nil {
+		return nil, err
+	}
+
+	todos, err := scanSQLTodos(rows)
+	return filterTodos(todos, filters), err
+}
+
+func (cockroachDB *CockroachDB) GetTodosByTag(ctx context.Context, tag string) ([]Todo, error) {
+	rows, err := cockroachDB.db.QueryContext(ctx, "SELECT id, title, doc FROM todos WHERE doc->'tags' ? $1 ORDER BY id", NormalizeTag(tag))
+	if err != nil {
+		return nil, err
+	}
+
+	return scanSQLTodos(rows)
+}
+
+func (cockroachDB *CockroachDB) ListTags(ctx context.Context) ([]Tag, error) {
+	rows, err := cockroachDB.db.QueryContext(ctx, "SELECT tag, COUNT(*) FROM (SELECT jsonb_array_elements_text(doc->'tags') AS tag FROM todos) AS tags GROUP BY tag ORDER BY tag")
+	if err != nil {
+		return nil, err
+	}
+
+	return scanSQLTags(rows)
+}
+
+func (cockroachDB *CockroachDB) SearchTodos(ctx context.Context, query string) ([]Todo, error) {
+	return searchTodos(ctx, cockroachDB.GetAllTodos, query)
+}
+
+func (cockroachDB *CockroachDB) ForEachTodo(ctx context.Context, fn func(Todo) error) error {
+	if features.Enabled(features.PerfNPlusOne) {
+		return cockroachDB.forEachTodoOneByOne(ctx, fn)
+	}
+
+	rows, err := cockroachDB.db.QueryContext(ctx, "SELECT id, title, doc FROM todos ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		todo, err := scanSQLTodo(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(todo); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// forEachTodoOneByOne is the deliberately slow variant of ForEachTodo, with
+// one query per todo.
+func (cockroachDB *CockroachDB) forEachTodoOneByOne(ctx context.Context, fn func(Todo) error) error {
+	rows, err := cockroachDB.db.QueryContext(ctx, "SELECT id FROM todos ORDER BY id")
+	if err != nil {
+		return err
+	}
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		todo, err := scanSQLTodo(cockroachDB.db.QueryRowContext(ctx, "SELECT id, title, doc FROM todos WHERE id = $1", id))
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(todo); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cockroachDB *CockroachDB) SaveTodo(ctx context.Context, todo Todo) error {
+	return cockroachDB.SaveTodos(ctx, []Todo{todo})
+}
+
+func (cockroachDB *CockroachDB) SaveTodos(ctx context.Context, todos []Todo) error {
+	if len(todos) == 0 {
+		return nil
+	}
+
+	return cockroachDB.inTx(ctx, func(tx *sql.Tx) error {
+		return cockroachDB.insert(ctx, tx, todos)
+	})
+}
+
+func (cockroachDB *CockroachDB) DeleteTodo(ctx context.Context, id string) error {
+	return cockroachDB.inTx(ctx, func(tx *sql.Tx) error {
+		return cockroachDB.removeInTx(ctx, tx, id)
+	})
+}
+
+// DeleteTodos deletes the todos in one transaction.
+func (cockroachDB *CockroachDB) DeleteTodos(ctx context.Context, ids []string) error {
+	return cockroachDB.inTx(ctx, func(tx *sql.Tx) error {
+		for _, id := range ids {
+			if err := cockroachDB.removeInTx(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (cockroachDB *CockroachDB) removeInTx(ctx context.Context, tx *sql.Tx, id string) error {
+	result, err := tx.ExecContext(ctx, "DELETE FROM todos WHERE id = (SELECT id FROM todos WHERE doc->>'id' = $1 OR (doc IS NULL AND id::STRING = $1) ORDER BY id LIMIT 1)", id)
+	if err != nil {
+		return err
+	}
+
+	removed, _ := result.RowsAffected()
+	logger.Debugf("Deleted %d todos", removed)
+	return nil
+}
+
+func (cockroachDB *CockroachDB) UpdateTodo(ctx context.Context, id string, update func(*Todo)) error {
+	return cockroachDB.updateTodo(ctx, id, update)
+}
+
+func (cockroachDB *CockroachDB) UpdateTodos(ctx context.Context, ids []string, update func(*Todo)) error {
+	return cockroachDB.inTx(ctx, func(tx *sql.Tx) error {
+		for _, id := range ids {
+			if err := cockroachDB.updateInTx(ctx, tx, id, update); err != nil && err != ErrNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (cockroachDB *CockroachDB) CompleteTodo(ctx context.Context, id string) error {
+	return cockroachDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = true
+	})
+}
+
+// CompleteTodos marks the todos done in one transaction.
+func (cockroachDB *CockroachDB) CompleteTodos(ctx context.Context, ids []string) error {
+	return cockroachDB.inTx(ctx, func(tx *sql.Tx) error {
+		for _, id := range ids {
+			err := cockroachDB.updateInTx(ctx, tx, id, func(todo *Todo) {
+				todo.Done = true
+			})
+			if err != nil && err != ErrNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (cockroachDB *CockroachDB) ReopenTodo(ctx context.Context, id string) error {
+	return cockroachDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = false
+	})
+}
+
+func (cockroachDB *CockroachDB) SetDescription(ctx context.Context, id string, description string) error {
+	return cockroachDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Description = description
+	})
+}
+
+func (cockroachDB *CockroachDB) SetDue(ctx context.Context, id string, due *time.Time) error {
+	return cockroachDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Due = due
+	})
+}
+
+func (cockroachDB *CockroachDB) SetPriority(ctx context.Context, id string, priority int) error {
+	return cockroachDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Priority = priority
+	})
+}
+
+func (cockroachDB *CockroachDB) SetTags(ctx context.Context, id string, tags []string) error {
+	return cockroachDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Tags = tags
+	})
+}
+
+func (cockroachDB *CockroachDB) SetRecurrence(ctx context.Context, id string, recurrence string) error {
+	return cockroachDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Recurrence = recurrence
+	})
+}
+
+func (cockroachDB *CockroachDB) AddSubTask(ctx context.Context, id string, title string) (SubTask, error) {
+	var subTask SubTask
+	err := cockroachDB.updateTodo(ctx, id, func(todo *Todo) {
+		subTask = todo.addSubTask(title)
+	})
+
+	return subTask, err
+}
+
+func (cockroachDB *CockroachDB) CompleteSubTask(ctx context.Context, id string, subTaskID string) error {
+	found := false
+	err := cockroachDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.completeSubTask(subTaskID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (cockroachDB *CockroachDB) GetSubTasks(ctx context.Context, id string) ([]SubTask, error) {
+	return subTasksOf(ctx, cockroachDB.ForEachTodo, id)
+}
+
+func (cockroachDB *CockroachDB) AddAttachment(ctx context.Context, id string, attachment Attachment) error {
+	return cockroachDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.addAttachment(attachment)
+	})
+}
+
+func (cockroachDB *CockroachDB) RemoveAttachment(ctx context.Context, id string, attachmentID string) error {
+	found := false
+	err := cockroachDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.removeAttachment(attachmentID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// MoveTodo rewrites the rows from the old to the new position of the todo,
+// the rows are ordered by their id.
+func (cockroachDB *CockroachDB) MoveTodo(ctx context.Context, id string, position int) error {
+	return cockroachDB.inTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, "SELECT id, title, doc FROM todos ORDER BY id FOR UPDATE")
+		if err != nil {
+			return err
+		}
+
+		return moveSQLTodo(rows, id, position, func(todo Todo, rowID int64) error {
+			_, err := tx.ExecContext(ctx, "UPDATE todos SET title = $1, doc = $2 WHERE id = $3", append(sqlTodoArgs(todo), rowID)...)
+			return err
+		})
+	})
+}
+
+func (cockroachDB *CockroachDB) updateTodo(ctx context.Context, id string, fn func(*Todo)) error {
+	return cockroachDB.inTx(ctx, func(tx *sql.Tx) error {
+		return cockroachDB.updateInTx(ctx, tx, id, fn)
+	})
+}
+
+func (cockroachDB *CockroachDB) updateInTx(ctx context.Context, tx *sql.Tx, id string, fn func(*Todo)) error {
+	var rowID int64
+	err := tx.QueryRowContext(ctx, "SELECT id FROM todos WHERE doc->>'id' = $1 OR (doc IS NULL AND id::STRING = $1) ORDER BY id LIMIT 1 FOR UPDATE", id).Scan(&rowID)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	todo, err := scanSQLTodo(tx.QueryRowContext(ctx, "SELECT id, title, doc FROM todos WHERE id = $1", rowID))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE todos SET title = $1, doc = $2 WHERE id = $3", append(sqlTodoArgs(todo.edited(fn)), rowID)...)
+	return err
+}
+
+func (cockroachDB *CockroachDB) ReplaceAllTodos(ctx context.Context, todos []Todo) error {
+	return cockroachDB.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM todos"); err != nil {
+			return err
+		}
+
+		return cockroachDB.insert(ctx, tx, todos)
+	})
+}
+
+func (cockroachDB *CockroachDB) insert(ctx context.Context, tx *sql.Tx, todos []Todo) error {
+	for _, todo := range todos {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO todos (title, doc) VALUES ($1, $2)", sqlTodoArgs(todo)...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetUsage reports the size of the ranges of the table as StoredBytes. Older
+// versions than 23.1 can't show it, the raw size is used then.
+func (cockroachDB *CockroachDB) GetUsage(ctx context.Context) (Usage, error) {
+	var usage Usage
+	err := cockroachDB.db.QueryRowContext(ctx, "SELECT COUNT(*), COALESCE(SUM(OCTET_LENGTH(COALESCE(doc::STRING, title))), 0) FROM todos").Scan(&usage.Todos, &usage.RawBytes)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	err = cockroachDB.db.QueryRowContext(ctx, "SELECT COALESCE(SUM(range_size), 0) FROM [SHOW RANGES FROM TABLE todos WITH DETAILS]").Scan(&usage.StoredBytes)
+	if err != nil {
+		if ctx.Err() != nil {
+			return Usage{}, ctx.Err()
+		}
+		logger.Debugf("Range sizes of todos: %v", err)
+		usage.StoredBytes = usage.RawBytes
+	}
+
+	hostname := getHostname()
+	cockroachDB.metrics.storageRawBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.RawBytes))
+	cockroachDB.metrics.storageStoredBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.StoredBytes))
+
+	return usage, nil
+}
+
+// OpenConnections returns the connections of the pool, in use or idle.
+func (cockroachDB *CockroachDB) OpenConnections() int64 {
+	return int64(cockroachDB.db.Stats().OpenConnections)
+}
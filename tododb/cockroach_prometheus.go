@@ -0,0 +1,73 @@
+package tododb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func (cockroachDB *CockroachDB) RegisterMetrics(registerer prometheus.Registerer) error {
+	m := cockroachDB.metrics
+	err := register(registerer,
+		m.cockroachNodesTotal,
+		m.cockroachNodesLiveTotal,
+		m.cockroachRetriesTotal,
+		m.storageRawBytes,
+		m.storageStoredBytes,
+	)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Registered Cockroach Metrics")
+	return nil
+}
+
+// GetHealthStatus reports every node of the cluster with its liveness, as
+// gossiped to the node this instance is connected to.
+func (cockroachDB *CockroachDB) GetHealthStatus(ctx context.Context) map[string]string {
+	result := map[string]string{"self": okString, "cockroach": okString}
+	hostname := getHostname()
+
+	rows, err := cockroachDB.db.QueryContext(ctx, "SELECT node_id, address, is_live FROM crdb_internal.gossip_nodes ORDER BY node_id")
+	if err != nil {
+		result["cockroach"] = err.Error()
+		return result
+	}
+	defer rows.Close()
+
+	total, live := 0, 0
+	for rows.Next() {
+		var (
+			id      int64
+			address string
+			isLive  bool
+		)
+		if err := rows.Scan(&id, &address, &isLive); err != nil {
+			result["cockroach"] = err.Error()
+			return result
+		}
+
+		status := okString
+		if !isLive {
+			status = "not live"
+		}
+		result[fmt.Sprintf("cockroach-n%d-%s", id, address)] = status
+
+		total++
+		if isLive {
+			live++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		result["cockroach"] = err.Error()
+		return result
+	}
+
+	cockroachDB.metrics.cockroachNodesTotal.WithLabelValues(hostname, buildinfo.Version).Set(float64(total))
+	cockroachDB.metrics.cockroachNodesLiveTotal.WithLabelValues(hostname, buildinfo.Version).Set(float64(live))
+
+	return result
+}
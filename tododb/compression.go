@@ -0,0 +1,60 @@
+package tododb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+)
+
+// compressedMarker prefixes stored values that are gzip compressed. The NUL
+// bytes keep it from clashing with anything typed into the UI.
+const compressedMarker = "\x00gz\x00"
+
+// compressValue compresses values longer than threshold bytes. A threshold
+// of zero or less stores every value as is. gzip output is deterministic for
+// the same input, so compressed values can still be matched for deletion.
+func compressValue(value string, threshold int) string {
+	if threshold <= 0 || len(value) <= threshold {
+		return value
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(value)); err != nil {
+		logger.Errorf("%v", err)
+		return value
+	}
+	if err := w.Close(); err != nil {
+		logger.Errorf("%v", err)
+		return value
+	}
+
+	// Only keep the compressed form if it actually saves space
+	if buf.Len()+len(compressedMarker) >= len(value) {
+		return value
+	}
+
+	return compressedMarker + buf.String()
+}
+
+func decompressValue(stored string) string {
+	if !strings.HasPrefix(stored, compressedMarker) {
+		return stored
+	}
+
+	r, err := gzip.NewReader(strings.NewReader(stored[len(compressedMarker):]))
+	if err != nil {
+		logger.Errorf("%v", err)
+		return stored
+	}
+	defer r.Close()
+
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		logger.Errorf("%v", err)
+		return stored
+	}
+
+	return string(value)
+}
@@ -0,0 +1,816 @@
+package tododb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type conformanceBackend struct {
+	name string
+	open func(dir string) (TodoDB, error)
+}
+
+// conformanceBackends are the backends the conformance tests run against
+// without a server. Each test gets an empty one.
+var conformanceBackends = []conformanceBackend{
+	{"memory", func(dir string) (TodoDB, error) {
+		return NewMemoryDB(map[string]string{})
+	}},
+	{"sqlite", func(dir string) (TodoDB, error) {
+		return NewSQLiteDB(map[string]string{"path": filepath.Join(dir, "todos.db")})
+	}},
+	{"git", func(dir string) (TodoDB, error) {
+		return NewGitDB(map[string]string{"path": filepath.Join(dir, "repo")})
+	}},
+}
+
+// serverBackends are the other registered backends with options in
+// TODODB_TEST_<NAME>, as a JSON object like
+// TODODB_TEST_POSTGRES='{"url":"postgres://localhost/todo_test"}'. The tests
+// replace their todos, point them at databases of their own.
+func serverBackends(t *testing.T) []conformanceBackend {
+	backends := []conformanceBackend{}
+	for _, name := range Names() {
+		value := os.Getenv("TODODB_TEST_" + strings.ToUpper(strings.Replace(name, "-", "_", -1)))
+		if value == "" {
+			continue
+		}
+
+		config := map[string]string{}
+		if err := json.Unmarshal([]byte(value), &config); err != nil {
+			t.Fatalf("the options of %s: %v", name, err)
+		}
+		name := name
+		backends = append(backends, conformanceBackend{name, func(string) (TodoDB, error) {
+			return Open(name, config)
+		}})
+	}
+
+	return backends
+}
+
+// conformanceTests check the behavior the TodoDB interface documents. KV
+// keys, accounts and trash owners are prefixed with the backend and the
+// test, memory and git share the KV of the process.
+var conformanceTests = []struct {
+	name string
+	test func(t *testing.T, db TodoDB, prefix string)
+}{
+	{"SaveTodos", testSaveTodos},
+	{"Filters", testFilters},
+	{"GetTodos", testGetTodos},
+	{"DeleteTodos", testDeleteTodos},
+	{"CompleteTodos", testCompleteTodos},
+	{"UpdateTodo", testUpdateTodo},
+	{"UpdateTodos", testUpdateTodos},
+	{"AccountUsage", testAccountUsage},
+	{"Setters", testSetters},
+	{"DueAndPriority", testDueAndPriority},
+	{"Tags", testTags},
+	{"SearchTodos", testSearchTodos},
+	{"MoveTodo", testMoveTodo},
+	{"SubTasks", testSubTasks},
+	{"Attachments", testAttachments},
+	{"ReplaceAllTodos", testReplaceAllTodos},
+	{"KV", testKV},
+	{"Users", testUsers},
+	{"Trash", testTrash},
+}
+
+func TestConformance(t *testing.T) {
+	for _, backend := range append(conformanceBackends, serverBackends(t)...) {
+		backend := backend
+		t.Run(backend.name, func(t *testing.T) {
+			for _, test := range conformanceTests {
+				test := test
+				t.Run(test.name, func(t *testing.T) {
+					dir, err := ioutil.TempDir("", "tododb")
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer os.RemoveAll(dir)
+
+					db, err := backend.open(dir)
+					if err != nil {
+						t.Fatalf("open %s: %v", backend.name, err)
+					}
+					if err := db.ReplaceAllTodos(ctx, nil); err != nil {
+						t.Fatal(err)
+					}
+					// Keys of earlier runs stay in the databases of servers
+					test.test(t, db, fmt.Sprintf("%s-%s-%d:", backend.name, test.name, time.Now().UnixNano()))
+				})
+			}
+		})
+	}
+}
+
+var ctx = context.Background()
+
+// save stores todos with the titles and returns them as saved.
+func save(t *testing.T, db TodoDB, titles ...string) []Todo {
+	t.Helper()
+	todos := NewTodos(titles)
+	if err := db.SaveTodos(ctx, todos); err != nil {
+		t.Fatalf("SaveTodos() error = %v", err)
+	}
+
+	return todos
+}
+
+func getAll(t *testing.T, db TodoDB, filters ...TodoFilter) []Todo {
+	t.Helper()
+	todos, err := db.GetAllTodos(ctx, filters...)
+	if err != nil {
+		t.Fatalf("GetAllTodos() error = %v", err)
+	}
+
+	return todos
+}
+
+func get(t *testing.T, db TodoDB, id string) Todo {
+	t.Helper()
+	for _, todo := range getAll(t, db) {
+		if todo.ID == id {
+			return todo
+		}
+	}
+	t.Fatalf("no todo with id %s", id)
+
+	return Todo{}
+}
+
+func checkTitles(t *testing.T, what string, todos []Todo, want ...string) {
+	t.Helper()
+	if got := Titles(todos); !reflect.DeepEqual(got, want) && !(len(got) == 0 && len(want) == 0) {
+		t.Errorf("%s = %q, want %q", what, got, want)
+	}
+}
+
+func testSaveTodos(t *testing.T, db TodoDB, prefix string) {
+	due := time.Date(2030, 1, 2, 3, 4, 5, 600, time.FixedZone("CET", 3600))
+	todo := NewTodo("full")
+	todo.Description = "with everything"
+	todo.Due = &due
+	todo.Priority = PriorityHigh
+	todo.Tags = []string{"#Work", "home", "work"}
+	todo.SubTasks = []SubTask{{ID: "s1", Title: "first"}}
+	todo.Recurrence = "weekly"
+	todo.Estimate = 30
+	todo.Remote = []RemoteLink{{Tracker: "github:o/r", ID: "7", URL: "https://github.com/o/r/issues/7"}}
+	todo.Attachments = []Attachment{NewAttachment("a.txt", "text/plain", []byte("a"))}
+	todo.Owner = "alice"
+
+	if err := db.SaveTodo(ctx, Todo{Title: "plain"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveTodos(ctx, []Todo{todo, NewTodo("last")}); err != nil {
+		t.Fatal(err)
+	}
+
+	todos := getAll(t, db)
+	checkTitles(t, "GetAllTodos()", todos, "plain", "full", "last")
+	if todos[0].ID == "" || todos[0].CreatedAt.IsZero() {
+		t.Errorf("SaveTodo() stored %+v without an id or creation time", todos[0])
+	}
+
+	got := todos[1]
+	if got.ID != todo.ID || got.Description != todo.Description || got.Priority != todo.Priority ||
+		got.Recurrence != todo.Recurrence || got.Estimate != todo.Estimate || got.Owner != todo.Owner {
+		t.Errorf("GetAllTodos() = %+v, want %+v", got, todo)
+	}
+	if got.Due == nil || !got.Due.Equal(due.Truncate(time.Second)) {
+		t.Errorf("Due = %v, want %v in whole seconds", got.Due, due)
+	}
+	if !reflect.DeepEqual(got.Tags, []string{"home", "work"}) {
+		t.Errorf("Tags = %q, want [home work]", got.Tags)
+	}
+	if !reflect.DeepEqual(got.SubTasks, todo.SubTasks) {
+		t.Errorf("SubTasks = %+v, want %+v", got.SubTasks, todo.SubTasks)
+	}
+	if len(got.Remote) != 1 || got.Remote[0].ID != "7" || len(got.Attachments) != 1 || got.Attachments[0].SHA256 != todo.Attachments[0].SHA256 {
+		t.Errorf("Remote = %+v, Attachments = %+v", got.Remote, got.Attachments)
+	}
+
+	var each []Todo
+	if err := db.ForEachTodo(ctx, func(todo Todo) error {
+		each = append(each, todo)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	checkTitles(t, "ForEachTodo()", each, "plain", "full", "last")
+
+	usage, err := db.GetUsage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Todos != 3 {
+		t.Errorf("GetUsage().Todos = %d, want 3", usage.Todos)
+	}
+}
+
+func testFilters(t *testing.T, db TodoDB, prefix string) {
+	todos := save(t, db, "buy milk", "call bob", "milk the cow")
+	if err := db.CompleteTodo(ctx, todos[2].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetTags(ctx, todos[1].ID, []string{"phone"}); err != nil {
+		t.Fatal(err)
+	}
+	due := time.Now().Add(-time.Hour)
+	if err := db.SetDue(ctx, todos[0].ID, &due); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateTodo(ctx, todos[1].ID, func(todo *Todo) {
+		todo.Owner = "alice"
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		filters []TodoFilter
+		want    []string
+	}{
+		{name: "none", want: []string{"buy milk", "call bob", "milk the cow"}},
+		{name: "open", filters: []TodoFilter{{Status: StatusOpen}}, want: []string{"buy milk", "call bob"}},
+		{name: "done", filters: []TodoFilter{{Status: StatusDone}}, want: []string{"milk the cow"}},
+		{name: "tag", filters: []TodoFilter{{Tag: "#Phone"}}, want: []string{"call bob"}},
+		{name: "query", filters: []TodoFilter{{Query: "mil"}}, want: []string{"buy milk", "milk the cow"}},
+		{name: "due", filters: []TodoFilter{{DueBefore: time.Now()}}, want: []string{"buy milk"}},
+		{name: "all of them", filters: []TodoFilter{{Query: "milk"}, {Status: StatusDone}}, want: []string{"milk the cow"}},
+		{name: "readable", filters: []TodoFilter{{ReadableBy: &Reader{Account: "bob"}}}, want: []string{"buy milk", "milk the cow"}},
+		{name: "shared", filters: []TodoFilter{{ReadableBy: &Reader{Account: "bob", Shared: map[string]string{todos[1].ID: "read"}}}}, want: []string{"buy milk", "call bob", "milk the cow"}},
+		{name: "owned", filters: []TodoFilter{{ReadableBy: &Reader{Account: "alice"}}}, want: []string{"buy milk", "call bob", "milk the cow"}},
+	}
+
+	for _, test := range tests {
+		checkTitles(t, test.name+": GetAllTodos()", getAll(t, db, test.filters...), test.want...)
+
+		count, err := db.CountTodos(ctx, test.filters...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != len(test.want) {
+			t.Errorf("%s: CountTodos() = %d, want %d", test.name, count, len(test.want))
+		}
+	}
+}
+
+func testGetTodos(t *testing.T, db TodoDB, prefix string) {
+	todos := save(t, db, "a", "b", "c", "d", "e")
+
+	tests := []struct {
+		offset, limit int
+		want          []string
+		err           error
+	}{
+		{offset: 0, limit: 2, want: []string{"a", "b"}},
+		{offset: 2, limit: 2, want: []string{"c", "d"}},
+		{offset: 4, limit: 2, want: []string{"e"}},
+		{offset: 5, limit: 2},
+		{offset: -1, limit: 2, err: ErrInvalidPage},
+		{offset: 0, limit: 0, err: ErrInvalidPage},
+	}
+
+	for _, test := range tests {
+		todos, err := db.GetTodos(ctx, test.offset, test.limit)
+		if err != test.err {
+			t.Errorf("GetTodos(%d, %d) error = %v, want %v", test.offset, test.limit, err, test.err)
+			continue
+		}
+		checkTitles(t, "GetTodos()", todos, test.want...)
+	}
+
+	if err := db.CompleteTodos(ctx, []string{todos[1].ID, todos[3].ID}); err != nil {
+		t.Fatal(err)
+	}
+	open, err := db.GetTodos(ctx, 1, 10, TodoFilter{Status: StatusOpen})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTitles(t, "GetTodos() of the open todos", open, "c", "e")
+}
+
+func testDeleteTodos(t *testing.T, db TodoDB, prefix string) {
+	todos := save(t, db, "a", "b", "c", "d")
+
+	if err := db.DeleteTodo(ctx, todos[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	checkTitles(t, "GetAllTodos() after DeleteTodo()", getAll(t, db), "a", "c", "d")
+
+	if err := db.DeleteTodos(ctx, []string{todos[0].ID, "unknown", todos[3].ID}); err != nil {
+		t.Fatal(err)
+	}
+	checkTitles(t, "GetAllTodos() after DeleteTodos()", getAll(t, db), "c")
+
+	if err := db.DeleteTodos(ctx, nil); err != nil {
+		t.Errorf("DeleteTodos() without ids error = %v", err)
+	}
+}
+
+func testCompleteTodos(t *testing.T, db TodoDB, prefix string) {
+	todos := save(t, db, "a", "b", "c")
+
+	if err := db.CompleteTodos(ctx, []string{todos[0].ID, "unknown", todos[2].ID}); err != nil {
+		t.Fatal(err)
+	}
+	checkTitles(t, "done todos", getAll(t, db, TodoFilter{Status: StatusDone}), "a", "c")
+
+	if err := db.ReopenTodo(ctx, todos[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CompleteTodo(ctx, todos[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	checkTitles(t, "done todos", getAll(t, db, TodoFilter{Status: StatusDone}), "b", "c")
+
+	if err := db.CompleteTodo(ctx, "unknown"); err != ErrNotFound {
+		t.Errorf("CompleteTodo() of an unknown id error = %v, want %v", err, ErrNotFound)
+	}
+	if err := db.ReopenTodo(ctx, "unknown"); err != ErrNotFound {
+		t.Errorf("ReopenTodo() of an unknown id error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func testUpdateTodo(t *testing.T, db TodoDB, prefix string) {
+	todos := save(t, db, "a", "b", "c")
+	before := get(t, db, todos[1].ID)
+
+	err := db.UpdateTodo(ctx, todos[1].ID, func(todo *Todo) {
+		todo.Title = "B"
+		todo.Estimate = 15
+		todo.Tags = []string{"Later"}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkTitles(t, "GetAllTodos()", getAll(t, db), "a", "B", "c")
+	after := get(t, db, todos[1].ID)
+	if after.Estimate != 15 || !reflect.DeepEqual(after.Tags, []string{"later"}) {
+		t.Errorf("UpdateTodo() stored %+v", after)
+	}
+	if !after.CreatedAt.Equal(before.CreatedAt) || after.UpdatedAt.Before(before.UpdatedAt) {
+		t.Errorf("UpdateTodo() times = %v, %v, before %v, %v", after.CreatedAt, after.UpdatedAt, before.CreatedAt, before.UpdatedAt)
+	}
+
+	if err := db.UpdateTodo(ctx, "unknown", func(*Todo) {}); err != ErrNotFound {
+		t.Errorf("UpdateTodo() of an unknown id error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func testUpdateTodos(t *testing.T, db TodoDB, prefix string) {
+	todos := save(t, db, "a", "b", "c")
+
+	err := db.UpdateTodos(ctx, []string{todos[0].ID, "unknown", todos[2].ID}, func(todo *Todo) {
+		todo.Title += "!"
+		todo.Priority = PriorityHigh
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkTitles(t, "GetAllTodos()", getAll(t, db), "a!", "b", "c!")
+	byPriority, err := db.GetTodosByPriority(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTitles(t, "GetTodosByPriority()", byPriority, "a!", "c!", "b")
+}
+
+func testAccountUsage(t *testing.T, db TodoDB, prefix string) {
+	todos := save(t, db, "a", "bb", "ccc")
+	if err := db.UpdateTodos(ctx, []string{todos[0].ID, todos[2].ID}, func(todo *Todo) {
+		todo.Owner = "alice"
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		account string
+		want    int64
+	}{
+		{"alice", 2},
+		{"", 1},
+		{"bob", 0},
+	} {
+		usage, err := AccountUsageOf(ctx, db, test.account)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if usage.Todos != test.want {
+			t.Errorf("AccountUsageOf(%q).Todos = %d, want %d", test.account, usage.Todos, test.want)
+		}
+		if (usage.RawBytes > 0) != (test.want > 0) || usage.StoredBytes > usage.RawBytes {
+			t.Errorf("AccountUsageOf(%q) = %+v, want bytes of %d todos", test.account, usage, test.want)
+		}
+	}
+}
+
+func testSetters(t *testing.T, db TodoDB, prefix string) {
+	todo := save(t, db, "a")[0]
+	due := time.Date(2030, 5, 6, 7, 8, 9, 0, time.UTC)
+
+	setters := []struct {
+		name  string
+		set   func(id string) error
+		check func(todo Todo) bool
+	}{
+		{"SetDescription", func(id string) error { return db.SetDescription(ctx, id, "details") },
+			func(todo Todo) bool { return todo.Description == "details" }},
+		{"SetDue", func(id string) error { return db.SetDue(ctx, id, &due) },
+			func(todo Todo) bool { return todo.Due != nil && todo.Due.Equal(due) }},
+		{"SetPriority", func(id string) error { return db.SetPriority(ctx, id, PriorityMedium) },
+			func(todo Todo) bool { return todo.Priority == PriorityMedium }},
+		{"SetTags", func(id string) error { return db.SetTags(ctx, id, []string{"b", "#A", "b"}) },
+			func(todo Todo) bool { return reflect.DeepEqual(todo.Tags, []string{"a", "b"}) }},
+		{"SetRecurrence", func(id string) error { return db.SetRecurrence(ctx, id, "daily") },
+			func(todo Todo) bool { return todo.Recurrence == "daily" }},
+		{"SetDescription empty", func(id string) error { return db.SetDescription(ctx, id, "") },
+			func(todo Todo) bool { return todo.Description == "" }},
+		{"SetDue nil", func(id string) error { return db.SetDue(ctx, id, nil) },
+			func(todo Todo) bool { return todo.Due == nil }},
+		{"SetTags empty", func(id string) error { return db.SetTags(ctx, id, nil) },
+			func(todo Todo) bool { return len(todo.Tags) == 0 }},
+		{"SetRecurrence empty", func(id string) error { return db.SetRecurrence(ctx, id, "") },
+			func(todo Todo) bool { return todo.Recurrence == "" }},
+	}
+
+	for _, setter := range setters {
+		if err := setter.set(todo.ID); err != nil {
+			t.Errorf("%s() error = %v", setter.name, err)
+			continue
+		}
+		if got := get(t, db, todo.ID); !setter.check(got) {
+			t.Errorf("%s() stored %+v", setter.name, got)
+		}
+		if err := setter.set("unknown"); err != ErrNotFound {
+			t.Errorf("%s() of an unknown id error = %v, want %v", setter.name, err, ErrNotFound)
+		}
+	}
+}
+
+func testDueAndPriority(t *testing.T, db TodoDB, prefix string) {
+	todos := save(t, db, "later", "overdue", "none", "done", "soon")
+	now := time.Now()
+	dues := map[int]time.Time{0: now.Add(48 * time.Hour), 1: now.Add(-time.Hour), 3: now.Add(-2 * time.Hour), 4: now.Add(time.Hour)}
+	for i, due := range dues {
+		due := due
+		if err := db.SetDue(ctx, todos[i].ID, &due); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.CompleteTodo(ctx, todos[3].ID); err != nil {
+		t.Fatal(err)
+	}
+	priorities := []int{PriorityLow, PriorityNone, PriorityHigh, PriorityLow, PriorityHigh}
+	for i, priority := range priorities {
+		if err := db.SetPriority(ctx, todos[i].ID, priority); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	overdue, err := db.GetOverdueTodos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTitles(t, "GetOverdueTodos()", overdue, "overdue")
+
+	dueBefore, err := db.GetTodosDueBefore(ctx, now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTitles(t, "GetTodosDueBefore()", dueBefore, "done", "overdue", "soon")
+
+	byPriority, err := db.GetTodosByPriority(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTitles(t, "GetTodosByPriority()", byPriority, "none", "soon", "later", "done", "overdue")
+
+	byPriority, err = db.GetTodosByPriority(ctx, TodoFilter{Status: StatusOpen})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTitles(t, "GetTodosByPriority() of the open todos", byPriority, "none", "soon", "later", "overdue")
+}
+
+func testTags(t *testing.T, db TodoDB, prefix string) {
+	todos := save(t, db, "a", "b", "c")
+	tags := [][]string{{"work", "home"}, {"Work"}, nil}
+	for i, tags := range tags {
+		if err := db.SetTags(ctx, todos[i].ID, tags); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tagged, err := db.GetTodosByTag(ctx, "#work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTitles(t, "GetTodosByTag()", tagged, "a", "b")
+
+	got, err := db.ListTags(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Tag{{Name: "home", Todos: 1}, {Name: "work", Todos: 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListTags() = %+v, want %+v", got, want)
+	}
+}
+
+func testSearchTodos(t *testing.T, db TodoDB, prefix string) {
+	todos := save(t, db, "Buy milk", "Call the plumber", "Milkshake for Bob")
+	if err := db.SetDescription(ctx, todos[1].ID, "about the leaking pipe"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetTags(ctx, todos[2].ID, []string{"party"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+		err   error
+	}{
+		{query: "milk", want: []string{"Buy milk", "Milkshake for Bob"}},
+		{query: "MILK bob", want: []string{"Milkshake for Bob"}},
+		{query: "pipe", want: []string{"Call the plumber"}},
+		{query: "part", want: []string{"Milkshake for Bob"}},
+		{query: "ilk"},
+		{query: "  ", err: ErrEmptyQuery},
+	}
+
+	for _, test := range tests {
+		found, err := db.SearchTodos(ctx, test.query)
+		if err != test.err {
+			t.Errorf("SearchTodos(%q) error = %v, want %v", test.query, err, test.err)
+			continue
+		}
+		checkTitles(t, "SearchTodos("+test.query+")", found, test.want...)
+	}
+}
+
+func testMoveTodo(t *testing.T, db TodoDB, prefix string) {
+	todos := save(t, db, "a", "b", "c", "d")
+
+	tests := []struct {
+		id       string
+		position int
+		want     []string
+		err      error
+	}{
+		{id: todos[3].ID, position: 0, want: []string{"d", "a", "b", "c"}},
+		{id: todos[3].ID, position: 2, want: []string{"a", "b", "d", "c"}},
+		{id: todos[0].ID, position: 10, want: []string{"b", "d", "c", "a"}},
+		{id: todos[1].ID, position: -1, want: []string{"b", "d", "c", "a"}, err: ErrInvalidPosition},
+		{id: "unknown", position: 0, want: []string{"b", "d", "c", "a"}, err: ErrNotFound},
+	}
+
+	for _, test := range tests {
+		if err := db.MoveTodo(ctx, test.id, test.position); err != test.err {
+			t.Errorf("MoveTodo(%d) error = %v, want %v", test.position, err, test.err)
+		}
+		checkTitles(t, "GetAllTodos() after MoveTodo()", getAll(t, db), test.want...)
+	}
+
+	save(t, db, "e")
+	checkTitles(t, "GetAllTodos() after SaveTodos()", getAll(t, db), "b", "d", "c", "a", "e")
+}
+
+func testSubTasks(t *testing.T, db TodoDB, prefix string) {
+	todo := save(t, db, "a")[0]
+
+	first, err := db.AddSubTask(ctx, todo.ID, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := db.AddSubTask(ctx, todo.ID, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == "" || first.ID == second.ID || first.Done {
+		t.Errorf("AddSubTask() = %+v, %+v", first, second)
+	}
+	if err := db.CompleteSubTask(ctx, todo.ID, second.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	subTasks, err := db.GetSubTasks(ctx, todo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Done = true
+	if want := []SubTask{first, second}; !reflect.DeepEqual(subTasks, want) {
+		t.Errorf("GetSubTasks() = %+v, want %+v", subTasks, want)
+	}
+
+	if _, err := db.AddSubTask(ctx, "unknown", "x"); err != ErrNotFound {
+		t.Errorf("AddSubTask() of an unknown id error = %v, want %v", err, ErrNotFound)
+	}
+	if err := db.CompleteSubTask(ctx, todo.ID, "unknown"); err != ErrNotFound {
+		t.Errorf("CompleteSubTask() of an unknown sub-task error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := db.GetSubTasks(ctx, "unknown"); err != ErrNotFound {
+		t.Errorf("GetSubTasks() of an unknown id error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func testAttachments(t *testing.T, db TodoDB, prefix string) {
+	todo := save(t, db, "a")[0]
+	first := NewAttachment("notes.txt", "text/plain", []byte("notes"))
+	second := NewAttachment("photo.png", "image/png", []byte("png"))
+
+	for _, attachment := range []Attachment{first, second} {
+		if err := db.AddAttachment(ctx, todo.ID, attachment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.RemoveAttachment(ctx, todo.ID, first.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	got := get(t, db, todo.ID).Attachments
+	if len(got) != 1 || got[0].ID != second.ID || got[0].SHA256 != second.SHA256 || got[0].Size != 3 {
+		t.Errorf("Attachments = %+v, want %+v", got, []Attachment{second})
+	}
+
+	if err := db.AddAttachment(ctx, "unknown", first); err != ErrNotFound {
+		t.Errorf("AddAttachment() of an unknown id error = %v, want %v", err, ErrNotFound)
+	}
+	if err := db.RemoveAttachment(ctx, todo.ID, first.ID); err != ErrNotFound {
+		t.Errorf("RemoveAttachment() of a removed attachment error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func testReplaceAllTodos(t *testing.T, db TodoDB, prefix string) {
+	save(t, db, "a", "b")
+
+	if err := db.ReplaceAllTodos(ctx, NewTodos([]string{"x", "y", "z"})); err != nil {
+		t.Fatal(err)
+	}
+	checkTitles(t, "GetAllTodos()", getAll(t, db), "x", "y", "z")
+
+	if err := db.ReplaceAllTodos(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkTitles(t, "GetAllTodos()", getAll(t, db))
+}
+
+func testKV(t *testing.T, db TodoDB, prefix string) {
+	kv := KVOf(db)
+
+	if _, err := kv.GetValue(prefix + "missing"); err != ErrNotFound {
+		t.Errorf("GetValue() of a missing key error = %v, want %v", err, ErrNotFound)
+	}
+
+	if err := kv.SetValue(prefix+"key", "one", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.SetValue(prefix+"key", "two", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := kv.GetValue(prefix + "key"); err != nil || value != "two" {
+		t.Errorf("GetValue() = %q, %v, want two", value, err)
+	}
+
+	for want := int64(1); want <= 3; want++ {
+		if got, err := kv.IncrValue(prefix+"counter", 0); err != nil || got != want {
+			t.Errorf("IncrValue() = %d, %v, want %d", got, err, want)
+		}
+	}
+	if value, err := kv.GetValue(prefix + "counter"); err != nil || value != "3" {
+		t.Errorf("GetValue() of the counter = %q, %v, want 3", value, err)
+	}
+
+	if err := kv.DeleteValue(prefix + "key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.GetValue(prefix + "key"); err != ErrNotFound {
+		t.Errorf("GetValue() of a deleted key error = %v, want %v", err, ErrNotFound)
+	}
+	if err := kv.DeleteValue(prefix + "key"); err != nil {
+		t.Errorf("DeleteValue() of a missing key error = %v", err)
+	}
+
+	if err := kv.SetValue(prefix+"token", "jane", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	taken := make(chan string, 8)
+	for i := 0; i < cap(taken); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, err := kv.TakeValue(prefix + "token"); err == nil {
+				taken <- value
+			} else if err != ErrNotFound {
+				t.Errorf("TakeValue() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(taken)
+	if values := len(taken); values != 1 || <-taken != "jane" {
+		t.Errorf("TakeValue() got the value %d times concurrently, want once", values)
+	}
+	if _, err := kv.GetValue(prefix + "token"); err != ErrNotFound {
+		t.Errorf("GetValue() of a taken key error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func testUsers(t *testing.T, db TodoDB, prefix string) {
+	users := UsersOf(db)
+	user := User{Name: prefix + "jane", Email: "jane@example.com", PasswordHash: "$argon2id$...", Created: time.Now().UTC().Truncate(time.Second)}
+
+	if _, err := users.GetUser(user.Name); err != ErrNotFound {
+		t.Errorf("GetUser() of a missing user error = %v, want %v", err, ErrNotFound)
+	}
+	if err := users.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.CreateUser(user); err != ErrUserExists {
+		t.Errorf("CreateUser() of a taken name error = %v, want %v", err, ErrUserExists)
+	}
+
+	if err := users.UpdateUser(user.Name, func(user *User) { user.Disabled = true }); err != nil {
+		t.Fatal(err)
+	}
+	got, err := users.GetUser(user.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Disabled = true
+	if !reflect.DeepEqual(got, user) {
+		t.Errorf("GetUser() = %+v, want %+v", got, user)
+	}
+
+	if err := users.UpdateUser(prefix+"unknown", func(*User) {}); err != ErrNotFound {
+		t.Errorf("UpdateUser() of a missing user error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func testTrash(t *testing.T, db TodoDB, prefix string) {
+	trash := TrashOf(db)
+	todos := NewTodos([]string{"a", "b"})
+	todos[0].Owner = prefix + "jane"
+	owner := prefix + "jane"
+	grants := map[string]string{prefix + "bob": "read"}
+
+	if err := trash.TrashTodos(owner, []TrashedTodo{{Todo: todos[0], Grants: grants}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := trash.TrashTodos(owner, []TrashedTodo{{Todo: todos[1]}}); err != nil {
+		t.Fatal(err)
+	}
+
+	trashed, err := trash.GetTrash(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trashed) != 2 || trashed[0].ID != todos[1].ID || trashed[1].ID != todos[0].ID {
+		t.Fatalf("GetTrash() = %+v, want b and a", trashed)
+	}
+	if other, err := trash.GetTrash(prefix + "bob"); err != nil || len(other) != 0 {
+		t.Errorf("GetTrash() of another owner = %+v, %v", other, err)
+	}
+
+	restored, err := trash.RestoreTodo(owner, todos[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.ID != todos[0].ID || restored.Title != "a" || restored.Owner != owner || !reflect.DeepEqual(restored.Grants, grants) {
+		t.Errorf("RestoreTodo() = %+v, want a with its owner and grants", restored)
+	}
+	if _, err := trash.RestoreTodo(owner, todos[0].ID); err != ErrNotFound {
+		t.Errorf("RestoreTodo() of a restored todo error = %v, want %v", err, ErrNotFound)
+	}
+
+	purged, err := trash.PurgeTrash(-time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, todo := range purged {
+		found = found || todo.ID == todos[1].ID
+	}
+	if !found {
+		t.Errorf("PurgeTrash() = %+v, want b", purged)
+	}
+	if trashed, err := trash.GetTrash(owner); err != nil || len(trashed) != 0 {
+		t.Errorf("GetTrash() after PurgeTrash() = %+v, %v", trashed, err)
+	}
+}
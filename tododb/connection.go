@@ -0,0 +1,137 @@
+package tododb
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultRedisPort    = "6379"
+	defaultRedisTLSPort = "6380"
+)
+
+// redisEndpoint is a parsed connection string of the DBConfig, shared by the
+// clients, the health checks and the metrics.
+type redisEndpoint struct {
+	Host     string
+	Port     string
+	Password string
+	DB       int
+	TLS      bool
+	// ServerName is checked against the certificate, it stays the configured
+	// name when Host is replaced by a resolved address
+	ServerName string
+}
+
+// parseRedisEndpoint accepts host, host:port, IPv6 literals with or without
+// brackets and redis:// or rediss:// URLs like
+// rediss://:secret@redis.example.com:6380/2. A password in the URL takes
+// precedence over password. Without a port 6379 is used, 6380 for rediss.
+func parseRedisEndpoint(connection, password string) (redisEndpoint, error) {
+	endpoint := redisEndpoint{Password: password, Port: defaultRedisPort}
+	connection = strings.TrimSpace(connection)
+	if connection == "" {
+		return endpoint, fmt.Errorf("empty redis connection")
+	}
+
+	hostPort := connection
+	if strings.Contains(connection, "://") {
+		u, err := url.Parse(connection)
+		if err != nil {
+			return endpoint, fmt.Errorf("invalid redis URL %q: %v", connection, err)
+		}
+
+		switch u.Scheme {
+		case "redis":
+		case "rediss":
+			endpoint.TLS = true
+			endpoint.Port = defaultRedisTLSPort
+		default:
+			return endpoint, fmt.Errorf("unsupported scheme %q in %q, use redis or rediss", u.Scheme, connection)
+		}
+
+		if secret, exists := u.User.Password(); exists {
+			endpoint.Password = secret
+		}
+
+		if db := strings.Trim(u.Path, "/"); db != "" {
+			if endpoint.DB, err = strconv.Atoi(db); err != nil || endpoint.DB < 0 {
+				return endpoint, fmt.Errorf("invalid database %q in %q", db, connection)
+			}
+		}
+		hostPort = u.Host
+	}
+
+	host, port, err := splitHostPort(hostPort)
+	if err != nil {
+		return endpoint, fmt.Errorf("invalid redis connection %q: %v", connection, err)
+	}
+	if port != "" {
+		if number, err := strconv.Atoi(port); err != nil || number <= 0 || number > 65535 {
+			return endpoint, fmt.Errorf("invalid port %q in %q", port, connection)
+		}
+		endpoint.Port = port
+	}
+
+	endpoint.Host = host
+	endpoint.ServerName = host
+	return endpoint, nil
+}
+
+// splitHostPort is net.SplitHostPort with an optional port, so that bare
+// IPv6 literals like ::1 are not mistaken for a host with a port.
+func splitHostPort(hostPort string) (string, string, error) {
+	if isIPLiteral(hostPort) {
+		return hostPort, "", nil
+	}
+
+	if strings.HasPrefix(hostPort, "[") && strings.HasSuffix(hostPort, "]") {
+		host := hostPort[1 : len(hostPort)-1]
+		if !isIPLiteral(host) {
+			return "", "", fmt.Errorf("%q is no IPv6 address", host)
+		}
+		return host, "", nil
+	}
+
+	if !strings.Contains(hostPort, ":") {
+		if hostPort == "" {
+			return "", "", fmt.Errorf("missing host")
+		}
+		return hostPort, "", nil
+	}
+
+	host, port, err := net.SplitHostPort(hostPort)
+	if err == nil && host == "" {
+		err = fmt.Errorf("missing host")
+	}
+
+	return host, port, err
+}
+
+// isIPLiteral accepts IPv6 addresses with a zone like fe80::1%eth0, too.
+func isIPLiteral(host string) bool {
+	return net.ParseIP(strings.SplitN(host, "%", 2)[0]) != nil
+}
+
+func (endpoint redisEndpoint) Addr() string {
+	return net.JoinHostPort(endpoint.Host, endpoint.Port)
+}
+
+// withHost returns the endpoint for one resolved address of it.
+func (endpoint redisEndpoint) withHost(host string) redisEndpoint {
+	endpoint.Host = host
+	return endpoint
+}
+
+// String leaves out the password, for logs and health results.
+func (endpoint redisEndpoint) String() string {
+	scheme := "redis"
+	if endpoint.TLS {
+		scheme = "rediss"
+	}
+
+	return fmt.Sprintf("%s://%s/%d", scheme, endpoint.Addr(), endpoint.DB)
+}
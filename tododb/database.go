@@ -1,9 +1,179 @@
 package tododb
 
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var logger = logging.New("tododb")
+
+// TodoDB is implemented by the backends. A done ctx makes calls return
+// ctx.Err() as soon as the backend allows, writes that already started are
+// completed rather than left half done.
 type TodoDB interface {
-	GetAllTodos() ([]string, error)
-	SaveTodo(string) error
-	DeleteTodo(string) error
-	GetHealthStatus() map[string]string
-	RegisterMetrics()
+	// GetAllTodos returns the todos matching all filters, in the order of
+	// the list: the order they were added unless they were moved
+	GetAllTodos(ctx context.Context, filters ...TodoFilter) ([]Todo, error)
+	// GetTodos returns at most limit todos matching all filters, skipping
+	// the first offset of them. It returns ErrInvalidPage for a negative
+	// offset or a limit below one.
+	GetTodos(ctx context.Context, offset, limit int, filters ...TodoFilter) ([]Todo, error)
+	// CountTodos returns the number of todos matching all filters
+	CountTodos(ctx context.Context, filters ...TodoFilter) (int, error)
+	ForEachTodo(ctx context.Context, fn func(Todo) error) error
+	// GetOverdueTodos returns the open todos whose due date has passed and
+	// GetTodosDueBefore all todos due before t, the soonest due first
+	GetOverdueTodos(ctx context.Context) ([]Todo, error)
+	GetTodosDueBefore(ctx context.Context, t time.Time) ([]Todo, error)
+	// GetTodosByPriority returns the todos matching all filters, the
+	// highest priority first and in the order they were added within a
+	// priority
+	GetTodosByPriority(ctx context.Context, filters ...TodoFilter) ([]Todo, error)
+	// GetTodosByTag returns the todos tagged with tag, in the order they
+	// were added
+	GetTodosByTag(ctx context.Context, tag string) ([]Todo, error)
+	// ListTags returns the tags in use and how many todos have them,
+	// ordered by name
+	ListTags(ctx context.Context) ([]Tag, error)
+	// SearchTodos returns the todos with every word of query at the start
+	// of a word of their title, description or tags, in the order they were
+	// added. It returns ErrEmptyQuery if query has no words to search for.
+	SearchTodos(ctx context.Context, query string) ([]Todo, error)
+	SaveTodo(ctx context.Context, todo Todo) error
+	SaveTodos(ctx context.Context, todos []Todo) error
+	// DeleteTodo removes the todo with the given id, the first one if
+	// several todos of older versions share it
+	DeleteTodo(ctx context.Context, id string) error
+	// DeleteTodos removes the todos with the given ids like DeleteTodo, in
+	// as few round trips as the backend allows. Ids without a todo are
+	// skipped.
+	DeleteTodos(ctx context.Context, ids []string) error
+	// UpdateTodo changes the todo with the given id by update, in one write
+	// that keeps its id and its place in the list. update may be called again
+	// when the backend retries the write. It returns ErrNotFound if there is
+	// no such todo.
+	UpdateTodo(ctx context.Context, id string, update func(*Todo)) error
+	// UpdateTodos changes the todos with the given ids by update like
+	// UpdateTodo, in as few round trips as the backend allows. Ids without a
+	// todo are skipped.
+	UpdateTodos(ctx context.Context, ids []string, update func(*Todo)) error
+	// CompleteTodo and ReopenTodo mark the todo with the given id as done or
+	// open again. They return ErrNotFound if there is no such todo.
+	CompleteTodo(ctx context.Context, id string) error
+	// CompleteTodos marks the todos with the given ids as done, in as few
+	// round trips as the backend allows. Ids without a todo are skipped.
+	CompleteTodos(ctx context.Context, ids []string) error
+	ReopenTodo(ctx context.Context, id string) error
+	// SetDescription replaces the description of the todo with the given
+	// id, an empty one removes it. It returns ErrNotFound if there is no such
+	// todo.
+	SetDescription(ctx context.Context, id string, description string) error
+	// SetDue sets the due date of the todo with the given id, nil removes
+	// it. It returns ErrNotFound if there is no such todo.
+	SetDue(ctx context.Context, id string, due *time.Time) error
+	// SetPriority sets the priority of the todo with the given id. It
+	// returns ErrNotFound if there is no such todo.
+	SetPriority(ctx context.Context, id string, priority int) error
+	// SetTags replaces the tags of the todo with the given id, an empty
+	// list removes them. It returns ErrNotFound if there is no such todo.
+	SetTags(ctx context.Context, id string, tags []string) error
+	// SetRecurrence sets the recurrence rule of the todo with the given id,
+	// an empty one ends the recurrence. It returns ErrNotFound if there is
+	// no such todo.
+	SetRecurrence(ctx context.Context, id string, recurrence string) error
+	// MoveTodo moves the todo with the given id to position in the list,
+	// counted from 0, the todos in between shift by one. A position past the
+	// end moves it to the end. It returns ErrNotFound if there is no such
+	// todo and ErrInvalidPosition for a negative position.
+	MoveTodo(ctx context.Context, id string, position int) error
+	// AddSubTask adds an open sub-task with title to the end of the
+	// checklist of the todo with the given id and returns it. It returns
+	// ErrNotFound if there is no such todo.
+	AddSubTask(ctx context.Context, id string, title string) (SubTask, error)
+	// CompleteSubTask marks the sub-task with subTaskID of the todo with
+	// the given id as done. It returns ErrNotFound if there is no such todo
+	// or sub-task.
+	CompleteSubTask(ctx context.Context, id string, subTaskID string) error
+	// GetSubTasks returns the checklist of the todo with the given id, in
+	// the order the sub-tasks were added. It returns ErrNotFound if there is
+	// no such todo.
+	GetSubTasks(ctx context.Context, id string) ([]SubTask, error)
+	// AddAttachment adds the metadata of an attachment to the todo with the
+	// given id. It returns ErrNotFound if there is no such todo.
+	AddAttachment(ctx context.Context, id string, attachment Attachment) error
+	// RemoveAttachment drops the attachment with attachmentID from the todo
+	// with the given id. It returns ErrNotFound if there is no such todo or
+	// attachment.
+	RemoveAttachment(ctx context.Context, id string, attachmentID string) error
+	ReplaceAllTodos(ctx context.Context, todos []Todo) error
+	GetHealthStatus(ctx context.Context) map[string]string
+	GetUsage(ctx context.Context) (Usage, error)
+	// RegisterMetrics registers the collectors of the backend, an error
+	// means the registry already has collectors of the same name
+	RegisterMetrics(prometheus.Registerer) error
+}
+
+// Usage describes how much space the todos take up in the database.
+// RawBytes is the size before compression, StoredBytes after it.
+type Usage struct {
+	Todos       int64 `json:"todos"`
+	RawBytes    int64 `json:"rawBytes"`
+	StoredBytes int64 `json:"storedBytes"`
+}
+
+// AccountUsager is implemented by backends that know how they store the
+// todos, to tell the usage of the todos of a single account.
+type AccountUsager interface {
+	GetAccountUsage(ctx context.Context, account string) (Usage, error)
+}
+
+// AccountUsageOf returns the usage of the todos owned by account, the todos
+// without owner count for the empty account. The todos of backends that
+// don't implement AccountUsager are counted as uncompressed JSON.
+func AccountUsageOf(ctx context.Context, db TodoDB, account string) (Usage, error) {
+	if usager, ok := db.(AccountUsager); ok {
+		return usager.GetAccountUsage(ctx, account)
+	}
+
+	return accountUsage(ctx, db.ForEachTodo, account, 0)
+}
+
+// accountUsage counts the todos of account and their size, compressed above
+// compressionThreshold.
+func accountUsage(ctx context.Context, forEach func(context.Context, func(Todo) error) error, account string, compressionThreshold int) (Usage, error) {
+	var usage Usage
+	err := forEach(ctx, func(todo Todo) error {
+		if todo.Owner != account {
+			return nil
+		}
+
+		raw := marshalTodo(todo.withDefaults())
+		usage.Todos++
+		usage.RawBytes += int64(len(raw))
+		usage.StoredBytes += int64(len(compressValue(raw, compressionThreshold)))
+		return nil
+	})
+
+	return usage, err
+}
+
+// ConnectionCounter is implemented by backends that can tell how many
+// connections they currently hold open.
+type ConnectionCounter interface {
+	OpenConnections() int64
+}
+
+// todoIDSeq keeps the ids of todos added in the same nanosecond apart.
+var todoIDSeq uint64
+
+// newTodoID is a unique id for backends without ids of their own. The ids
+// sort in the order the todos were added, as long as the clocks of the
+// instances agree.
+func newTodoID() string {
+	return fmt.Sprintf("%019d-%s-%d", time.Now().UnixNano(), getHostname(), atomic.AddUint64(&todoIDSeq, 1))
 }
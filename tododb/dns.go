@@ -0,0 +1,147 @@
+package tododb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Just enough of the DNS wire format (RFC 1035) to ask for A and AAAA
+// records and read their TTLs, which the net package doesn't expose.
+
+const (
+	dnsTypeA    uint16 = 1
+	dnsTypeAAAA uint16 = 28
+	dnsClassIN  uint16 = 1
+
+	dnsHeaderLen     = 12
+	dnsFlagResponse  = 1 << 15
+	dnsFlagRecursion = 1 << 8
+
+	dnsRCodeSuccess   = 0
+	dnsRCodeNameError = 3
+)
+
+var errDNSTruncated = errors.New("DNS message truncated")
+
+func buildDNSQuery(id uint16, name string, queryType uint16) ([]byte, error) {
+	msg := make([]byte, dnsHeaderLen, dnsHeaderLen+len(name)+6)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], dnsFlagRecursion)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid DNS name %q", name)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+
+	msg = append(msg, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(msg[len(msg)-4:], queryType)
+	binary.BigEndian.PutUint16(msg[len(msg)-2:], dnsClassIN)
+
+	return msg, nil
+}
+
+type dnsResponse struct {
+	id    uint16
+	rcode int
+	addrs []string
+	ttl   time.Duration
+}
+
+// parseDNSResponse reads the A and AAAA answers of msg, other records like
+// CNAMEs are skipped. ttl is the smallest TTL of the addresses.
+func parseDNSResponse(msg []byte) (*dnsResponse, error) {
+	if len(msg) < dnsHeaderLen {
+		return nil, errDNSTruncated
+	}
+
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&dnsFlagResponse == 0 {
+		return nil, errors.New("DNS message is no response")
+	}
+
+	response := &dnsResponse{
+		id:    binary.BigEndian.Uint16(msg[0:]),
+		rcode: int(flags & 0xf),
+		addrs: []string{},
+		ttl:   maxResolverTTL,
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:]))
+
+	offset := dnsHeaderLen
+	for i := 0; i < questions; i++ {
+		next, err := skipDNSName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		// Type and class
+		offset = next + 4
+	}
+
+	for i := 0; i < answers; i++ {
+		next, err := skipDNSName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(msg) {
+			return nil, errDNSTruncated
+		}
+
+		recordType := binary.BigEndian.Uint16(msg[next:])
+		ttl := time.Duration(binary.BigEndian.Uint32(msg[next+4:])) * time.Second
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		data := next + 10
+		if data+length > len(msg) {
+			return nil, errDNSTruncated
+		}
+		offset = data + length
+
+		switch {
+		case recordType == dnsTypeA && length == net.IPv4len,
+			recordType == dnsTypeAAAA && length == net.IPv6len:
+			response.addrs = append(response.addrs, net.IP(msg[data:offset]).String())
+		default:
+			continue
+		}
+
+		if ttl < response.ttl {
+			response.ttl = ttl
+		}
+	}
+
+	return response, nil
+}
+
+// skipDNSName returns the offset behind the name starting at offset. A
+// compression pointer ends the name.
+func skipDNSName(msg []byte, offset int) (int, error) {
+	for {
+		if offset >= len(msg) {
+			return 0, errDNSTruncated
+		}
+
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			return offset + 1, nil
+		case length&0xc0 == 0xc0:
+			if offset+2 > len(msg) {
+				return 0, errDNSTruncated
+			}
+			return offset + 2, nil
+		case length&0xc0 != 0:
+			return 0, fmt.Errorf("invalid DNS label type %#x", length)
+		}
+
+		offset += 1 + length
+	}
+}
@@ -0,0 +1,132 @@
+package tododb
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildDNSQuery(t *testing.T) {
+	want := []byte{
+		0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+		0x00, 0x1c, 0x00, 0x01,
+	}
+	for _, name := range []string{"example.com", "example.com."} {
+		got, err := buildDNSQuery(0x1234, name, dnsTypeAAAA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("buildDNSQuery(%q) = % x, want % x", name, got, want)
+		}
+	}
+
+	for _, name := range []string{"", "a..b", string(bytes.Repeat([]byte("a"), 64)) + ".com"} {
+		if _, err := buildDNSQuery(1, name, dnsTypeA); err == nil {
+			t.Errorf("buildDNSQuery(%q) succeeded", name)
+		}
+	}
+}
+
+// dnsAnswer is a resource record whose name is a pointer to the question.
+func dnsAnswer(recordType uint16, ttl uint32, data ...byte) []byte {
+	return append([]byte{
+		0xc0, 0x0c,
+		byte(recordType >> 8), byte(recordType), 0x00, 0x01,
+		byte(ttl >> 24), byte(ttl >> 16), byte(ttl >> 8), byte(ttl),
+		byte(len(data) >> 8), byte(len(data)),
+	}, data...)
+}
+
+func dnsMessage(id uint16, flags uint16, answers ...[]byte) []byte {
+	msg := []byte{
+		byte(id >> 8), byte(id), byte(flags >> 8), byte(flags),
+		0x00, 0x01, 0x00, byte(len(answers)), 0x00, 0x00, 0x00, 0x00,
+		5, 'r', 'e', 'd', 'i', 's', 0, 0x00, 0x01, 0x00, 0x01,
+	}
+	for _, answer := range answers {
+		msg = append(msg, answer...)
+	}
+
+	return msg
+}
+
+func TestParseDNSResponse(t *testing.T) {
+	response := uint16(dnsFlagResponse | dnsFlagRecursion | 0x80)
+	cname := append([]byte{5, 'c', 'a', 'c', 'h', 'e'}, 0xc0, 0x0c)
+
+	tests := []struct {
+		name string
+		msg  []byte
+		want *dnsResponse
+		err  bool
+	}{
+		{
+			name: "addresses",
+			msg: dnsMessage(7, response,
+				dnsAnswer(dnsTypeA, 300, 10, 0, 0, 1),
+				dnsAnswer(dnsTypeA, 60, 10, 0, 0, 2)),
+			want: &dnsResponse{id: 7, addrs: []string{"10.0.0.1", "10.0.0.2"}, ttl: time.Minute},
+		},
+		{
+			name: "cname skipped",
+			msg: dnsMessage(8, response,
+				dnsAnswer(5, 10, cname...),
+				dnsAnswer(dnsTypeAAAA, 120, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1)),
+			want: &dnsResponse{id: 8, addrs: []string{"2001:db8::1"}, ttl: 2 * time.Minute},
+		},
+		{
+			name: "ttl capped",
+			msg:  dnsMessage(9, response, dnsAnswer(dnsTypeA, 86400, 10, 0, 0, 1)),
+			want: &dnsResponse{id: 9, addrs: []string{"10.0.0.1"}, ttl: maxResolverTTL},
+		},
+		{
+			name: "name error",
+			msg:  dnsMessage(10, response|dnsRCodeNameError),
+			want: &dnsResponse{id: 10, rcode: dnsRCodeNameError, addrs: []string{}, ttl: maxResolverTTL},
+		},
+		{
+			name: "A record of the wrong length",
+			msg:  dnsMessage(11, response, dnsAnswer(dnsTypeA, 60, 10, 0, 0)),
+			want: &dnsResponse{id: 11, addrs: []string{}, ttl: maxResolverTTL},
+		},
+		{name: "query", msg: dnsMessage(12, dnsFlagRecursion), err: true},
+		{name: "short header", msg: []byte{0, 1, 0x80}, err: true},
+		{name: "truncated answer", msg: dnsMessage(13, response, dnsAnswer(dnsTypeA, 60, 10, 0, 0, 1))[:37], err: true},
+		{name: "truncated record header", msg: dnsMessage(14, response, dnsAnswer(dnsTypeA, 60, 10, 0, 0, 1))[:30], err: true},
+		{name: "bad label", msg: dnsMessage(15, response, append([]byte{0x80}, dnsAnswer(dnsTypeA, 60, 10, 0, 0, 1)[2:]...)), err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseDNSResponse(test.msg)
+			if (err != nil) != test.err {
+				t.Fatalf("parseDNSResponse() error = %v, want error %v", err, test.err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseDNSResponse() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDNSConfigNames(t *testing.T) {
+	config := dnsConfig{search: []string{"svc.cluster.local", "cluster.local."}, ndots: 2}
+	tests := []struct {
+		host string
+		want []string
+	}{
+		{"redis", []string{"redis.svc.cluster.local.", "redis.cluster.local.", "redis."}},
+		{"redis.db", []string{"redis.db.svc.cluster.local.", "redis.db.cluster.local.", "redis.db."}},
+		{"redis.db.example", []string{"redis.db.example.", "redis.db.example.svc.cluster.local.", "redis.db.example.cluster.local."}},
+		{"redis.", []string{"redis."}},
+	}
+
+	for _, test := range tests {
+		if got := config.names(test.host); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("names(%q) = %v, want %v", test.host, got, test.want)
+		}
+	}
+}
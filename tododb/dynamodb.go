@@ -0,0 +1,837 @@
+package tododb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/johscheuer/todo-app-web/features"
+)
+
+const (
+	defaultDynamoTable          = "todos"
+	defaultDynamoRegion         = "us-east-1"
+	defaultDynamoTimeoutSeconds = 10
+	// dynamoCreateTimeout is how long a new table may take to become active
+	dynamoCreateTimeout = 2 * time.Minute
+	// dynamoBatchSize is the most items BatchWriteItem takes at once
+	dynamoBatchSize    = 25
+	dynamoBatchRetries = 5
+
+	// dynamoList is the partition key of all todos, there is only one list
+	dynamoList = "default"
+	// dynamoKVList is the partition key of the values of the KV
+	dynamoKVList = "kv"
+)
+
+var (
+	errDynamoTableNotFound   = errors.New("table not found")
+	errDynamoConditionFailed = errors.New("condition failed")
+	// errStopIteration ends a query early, it never leaves this file
+	errStopIteration = errors.New("stop iteration")
+)
+
+// DynamoDB keeps every todo as an item of one partition, sorted by an id
+// that grows with the time the todo was added. Requests go to the JSON API
+// of DynamoDB, signed with the credentials of the usual AWS chain.
+type DynamoDB struct {
+	table       string
+	region      string
+	endpoint    string
+	credentials *awsCredentialChain
+	client      *http.Client
+	metrics     *Metrics
+}
+
+var _ TodoDB = &DynamoDB{}
+
+// dynamoValue is an attribute value, todos only use strings.
+type dynamoValue struct {
+	S string `json:"S"`
+}
+
+type dynamoItem map[string]dynamoValue
+
+type dynamoError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func NewDynamoDB(config map[string]string) (*DynamoDB, error) {
+	table := defaultDynamoTable
+	if value, exists := config["table"]; exists {
+		table = value
+	}
+
+	region := config["region"]
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region == "" {
+			region = os.Getenv(env)
+		}
+	}
+	if region == "" {
+		region = defaultDynamoRegion
+	}
+
+	endpoint := fmt.Sprintf("https://dynamodb.%s.amazonaws.com", region)
+	if value, exists := config["endpoint"]; exists {
+		endpoint = strings.TrimRight(value, "/")
+	}
+
+	dynamoDB := &DynamoDB{
+		table:       table,
+		region:      region,
+		endpoint:    endpoint,
+		credentials: newAWSCredentialChain(config["profile"]),
+		client:      &http.Client{Timeout: time.Duration(intConfig(config, "timeout", defaultDynamoTimeoutSeconds)) * time.Second},
+		metrics:     NewMetrics(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoCreateTimeout)
+	defer cancel()
+
+	_, err := dynamoDB.describeTable(ctx)
+	if err == errDynamoTableNotFound && config["createTable"] != "false" {
+		err = dynamoDB.createTable(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dynamodb table %s in %s: %v", table, region, err)
+	}
+
+	return dynamoDB, nil
+}
+
+// call sends operation like Query to DynamoDB and decodes the answer into
+// response.
+func (dynamoDB *DynamoDB) call(ctx context.Context, operation string, request, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	creds, err := dynamoDB.credentials.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, dynamoDB.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "DynamoDB_20120810."+operation)
+	signV4(req, body, creds, dynamoDB.region, "dynamodb", time.Now())
+
+	resp, err := dynamoDB.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	answer, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var dynamoErr dynamoError
+		json.Unmarshal(answer, &dynamoErr)
+		if strings.HasSuffix(dynamoErr.Type, "#ResourceNotFoundException") {
+			return errDynamoTableNotFound
+		}
+		if strings.HasSuffix(dynamoErr.Type, "#ConditionalCheckFailedException") {
+			return errDynamoConditionFailed
+		}
+		if dynamoErr.Message == "" {
+			dynamoErr.Message = string(bytes.TrimSpace(answer))
+		}
+		return fmt.Errorf("dynamodb %s: %s %s", operation, dynamoErr.Type, dynamoErr.Message)
+	}
+
+	if response == nil {
+		return nil
+	}
+
+	return json.Unmarshal(answer, response)
+}
+
+type dynamoTable struct {
+	TableStatus    string `json:"TableStatus"`
+	TableSizeBytes int64  `json:"TableSizeBytes"`
+}
+
+func (dynamoDB *DynamoDB) describeTable(ctx context.Context) (dynamoTable, error) {
+	var response struct {
+		Table dynamoTable `json:"Table"`
+	}
+	err := dynamoDB.call(ctx, "DescribeTable", map[string]string{"TableName": dynamoDB.table}, &response)
+
+	return response.Table, err
+}
+
+// createTable creates the table with on-demand capacity and waits until it
+// can be used.
+func (dynamoDB *DynamoDB) createTable(ctx context.Context) error {
+	logger.Infof("Creating DynamoDB table %s", dynamoDB.table)
+	err := dynamoDB.call(ctx, "CreateTable", map[string]interface{}{
+		"TableName":   dynamoDB.table,
+		"BillingMode": "PAY_PER_REQUEST",
+		"AttributeDefinitions": []map[string]string{
+			{"AttributeName": "list", "AttributeType": "S"},
+			{"AttributeName": "id", "AttributeType": "S"},
+		},
+		"KeySchema": []map[string]string{
+			{"AttributeName": "list", "KeyType": "HASH"},
+			{"AttributeName": "id", "KeyType": "RANGE"},
+		},
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	for {
+		table, err := dynamoDB.describeTable(ctx)
+		if err != nil && err != errDynamoTableNotFound {
+			return err
+		}
+		if table.TableStatus == "ACTIVE" {
+			return dynamoDB.call(ctx, "UpdateTimeToLive", map[string]interface{}{
+				"TableName": dynamoDB.table,
+				"TimeToLiveSpecification": map[string]interface{}{
+					"AttributeName": "expiresAt",
+					"Enabled":       true,
+				},
+			}, nil)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// query calls fn with every page of the todos, in the order they were added.
+func (dynamoDB *DynamoDB) query(ctx context.Context, projection string, fn func([]dynamoItem) error) error {
+	request := map[string]interface{}{
+		"TableName":                 dynamoDB.table,
+		"KeyConditionExpression":    "#list = :list",
+		"ExpressionAttributeNames":  map[string]string{"#list": "list"},
+		"ExpressionAttributeValues": map[string]dynamoValue{":list": {S: dynamoList}},
+		"ConsistentRead":            true,
+	}
+	if projection != "" {
+		request["ProjectionExpression"] = projection
+	}
+
+	for {
+		var response struct {
+			Items            []dynamoItem `json:"Items"`
+			LastEvaluatedKey dynamoItem   `json:"LastEvaluatedKey"`
+		}
+		if err := dynamoDB.call(ctx, "Query", request, &response); err != nil {
+			return err
+		}
+		if err := fn(response.Items); err != nil {
+			return err
+		}
+		if len(response.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		request["ExclusiveStartKey"] = response.LastEvaluatedKey
+	}
+}
+
+// newDynamoItem keys todo with a new sort key, its id might not sort in the
+// order the todos were added.
+func newDynamoItem(todo Todo) dynamoItem {
+	todo = todo.withDefaults()
+	return dynamoItem{"list": {S: dynamoList}, "id": {S: newTodoID()}, "title": {S: todo.Title}, "doc": {S: marshalTodo(todo)}}
+}
+
+// todo reads the doc attribute, items of older versions only have a title
+// and the sort key is their id.
+func (item dynamoItem) todo() Todo {
+	doc, exists := item["doc"]
+	if !exists {
+		return Todo{ID: item["id"].S, Title: item["title"].S}
+	}
+
+	return unmarshalTodo(doc.S)
+}
+
+func (dynamoDB *DynamoDB) GetAllTodos(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	todos := []Todo{}
+	err := dynamoDB.ForEachTodo(ctx, func(todo Todo) error {
+		if matchesAll(filters, todo) {
+			todos = append(todos, todo)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return todos, nil
+}
+
+func (dynamoDB *DynamoDB) GetTodos(ctx context.Context, offset, limit int, filters ...TodoFilter) ([]Todo, error) {
+	return pageTodos(ctx, dynamoDB.ForEachTodo, offset, limit, filters)
+}
+
+func (dynamoDB *DynamoDB) CountTodos(ctx context.Context, filters ...TodoFilter) (int, error) {
+	return countTodos(ctx, dynamoDB.ForEachTodo, filters)
+}
+
+func (dynamoDB *DynamoDB) GetOverdueTodos(ctx context.Context) ([]Todo, error) {
+	return todosByDue(ctx, dynamoDB.GetAllTodos, overdueFilter())
+}
+
+func (dynamoDB *DynamoDB) GetTodosDueBefore(ctx context.Context, t time.Time) ([]Todo, error) {
+	return todosByDue(ctx, dynamoDB.GetAllTodos, TodoFilter{DueBefore: t})
+}
+
+func (dynamoDB *DynamoDB) GetTodosByPriority(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	return todosByPriority(ctx, dynamoDB.GetAllTodos, filters)
+}
+
+func (dynamoDB *DynamoDB) GetTodosByTag(ctx context.Context, tag string) ([]Todo, error) {
+	return todosByTag(ctx, dynamoDB.GetAllTodos, tag)
+}
+
+func (dynamoDB *DynamoDB) ListTags(ctx context.Context) ([]Tag, error) {
+	return CountTags(ctx, dynamoDB.ForEachTodo)
+}
+
+func (dynamoDB *DynamoDB) SearchTodos(ctx context.Context, query string) ([]Todo, error) {
+	return searchTodos(ctx, dynamoDB.GetAllTodos, query)
+}
+
+func (dynamoDB *DynamoDB) ForEachTodo(ctx context.Context, fn func(Todo) error) error {
+	if features.Enabled(features.PerfNPlusOne) {
+		return dynamoDB.forEachTodoOneByOne(ctx, fn)
+	}
+
+	return dynamoDB.query(ctx, "", func(items []dynamoItem) error {
+		for _, item := range items {
+			if err := fn(item.todo()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// forEachTodoOneByOne is the deliberately slow variant of ForEachTodo, with
+// one request per todo.
+func (dynamoDB *DynamoDB) forEachTodoOneByOne(ctx context.Context, fn func(Todo) error) error {
+	ids := []string{}
+	err := dynamoDB.query(ctx, "id", func(items []dynamoItem) error {
+		for _, item := range items {
+			ids = append(ids, item["id"].S)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		var response struct {
+			Item dynamoItem `json:"Item"`
+		}
+		err := dynamoDB.call(ctx, "GetItem", map[string]interface{}{
+			"TableName":      dynamoDB.table,
+			"Key":            dynamoItem{"list": {S: dynamoList}, "id": {S: id}},
+			"ConsistentRead": true,
+		}, &response)
+		if err != nil {
+			return err
+		}
+		if response.Item == nil {
+			continue
+		}
+		if err := fn(response.Item.todo()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (dynamoDB *DynamoDB) SaveTodo(ctx context.Context, todo Todo) error {
+	return dynamoDB.call(ctx, "PutItem", map[string]interface{}{
+		"TableName": dynamoDB.table,
+		"Item":      newDynamoItem(todo),
+	}, nil)
+}
+
+func (dynamoDB *DynamoDB) SaveTodos(ctx context.Context, todos []Todo) error {
+	requests := make([]interface{}, 0, len(todos))
+	for _, todo := range todos {
+		requests = append(requests, map[string]interface{}{
+			"PutRequest": map[string]dynamoItem{
+				"Item": newDynamoItem(todo),
+			},
+		})
+	}
+
+	return dynamoDB.batchWrite(ctx, requests)
+}
+
+// batchWrite sends requests in batches of dynamoBatchSize and retries the
+// items DynamoDB didn't process because of throttling.
+func (dynamoDB *DynamoDB) batchWrite(ctx context.Context, requests []interface{}) error {
+	for start := 0; start < len(requests); start += dynamoBatchSize {
+		end := start + dynamoBatchSize
+		if end > len(requests) {
+			end = len(requests)
+		}
+
+		pending := map[string][]interface{}{dynamoDB.table: requests[start:end]}
+		for retry := 0; len(pending[dynamoDB.table]) > 0; retry++ {
+			if retry > dynamoBatchRetries {
+				return fmt.Errorf("dynamodb: %d writes still unprocessed after %d retries", len(pending[dynamoDB.table]), dynamoBatchRetries)
+			}
+			if retry > 0 {
+				time.Sleep(time.Duration(retry*100) * time.Millisecond)
+			}
+
+			var response struct {
+				UnprocessedItems map[string][]interface{} `json:"UnprocessedItems"`
+			}
+			if err := dynamoDB.call(ctx, "BatchWriteItem", map[string]interface{}{"RequestItems": pending}, &response); err != nil {
+				return err
+			}
+			pending = response.UnprocessedItems
+		}
+	}
+
+	return nil
+}
+
+func (dynamoDB *DynamoDB) DeleteTodo(ctx context.Context, id string) error {
+	item, err := dynamoDB.findItem(ctx, id)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return dynamoDB.call(ctx, "DeleteItem", map[string]interface{}{
+		"TableName": dynamoDB.table,
+		"Key":       dynamoItem{"list": item["list"], "id": item["id"]},
+	}, nil)
+}
+
+func (dynamoDB *DynamoDB) DeleteTodos(ctx context.Context, ids []string) error {
+	return deleteEach(ctx, ids, dynamoDB.DeleteTodo)
+}
+
+func (dynamoDB *DynamoDB) UpdateTodo(ctx context.Context, id string, update func(*Todo)) error {
+	return dynamoDB.updateTodo(ctx, id, update)
+}
+
+func (dynamoDB *DynamoDB) UpdateTodos(ctx context.Context, ids []string, update func(*Todo)) error {
+	return updateEach(ctx, ids, update, dynamoDB.UpdateTodo)
+}
+
+func (dynamoDB *DynamoDB) CompleteTodo(ctx context.Context, id string) error {
+	return dynamoDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = true
+	})
+}
+
+func (dynamoDB *DynamoDB) CompleteTodos(ctx context.Context, ids []string) error {
+	return completeEach(ctx, ids, dynamoDB.CompleteTodo)
+}
+
+func (dynamoDB *DynamoDB) ReopenTodo(ctx context.Context, id string) error {
+	return dynamoDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = false
+	})
+}
+
+func (dynamoDB *DynamoDB) SetDescription(ctx context.Context, id string, description string) error {
+	return dynamoDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Description = description
+	})
+}
+
+func (dynamoDB *DynamoDB) SetDue(ctx context.Context, id string, due *time.Time) error {
+	return dynamoDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Due = due
+	})
+}
+
+func (dynamoDB *DynamoDB) SetPriority(ctx context.Context, id string, priority int) error {
+	return dynamoDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Priority = priority
+	})
+}
+
+func (dynamoDB *DynamoDB) SetTags(ctx context.Context, id string, tags []string) error {
+	return dynamoDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Tags = tags
+	})
+}
+
+func (dynamoDB *DynamoDB) SetRecurrence(ctx context.Context, id string, recurrence string) error {
+	return dynamoDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Recurrence = recurrence
+	})
+}
+
+func (dynamoDB *DynamoDB) AddSubTask(ctx context.Context, id string, title string) (SubTask, error) {
+	var subTask SubTask
+	err := dynamoDB.updateTodo(ctx, id, func(todo *Todo) {
+		subTask = todo.addSubTask(title)
+	})
+
+	return subTask, err
+}
+
+func (dynamoDB *DynamoDB) CompleteSubTask(ctx context.Context, id string, subTaskID string) error {
+	found := false
+	err := dynamoDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.completeSubTask(subTaskID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (dynamoDB *DynamoDB) GetSubTasks(ctx context.Context, id string) ([]SubTask, error) {
+	return subTasksOf(ctx, dynamoDB.ForEachTodo, id)
+}
+
+func (dynamoDB *DynamoDB) AddAttachment(ctx context.Context, id string, attachment Attachment) error {
+	return dynamoDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.addAttachment(attachment)
+	})
+}
+
+func (dynamoDB *DynamoDB) RemoveAttachment(ctx context.Context, id string, attachmentID string) error {
+	found := false
+	err := dynamoDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.removeAttachment(attachmentID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// MoveTodo puts the todos from the old to the new position of the todo
+// under the sort keys of their new items. Like ReplaceAllTodos it isn't
+// atomic.
+func (dynamoDB *DynamoDB) MoveTodo(ctx context.Context, id string, position int) error {
+	items := []dynamoItem{}
+	err := dynamoDB.query(ctx, "", func(page []dynamoItem) error {
+		items = append(items, page...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	todos := make([]Todo, len(items))
+	for i, item := range items {
+		todos[i] = item.todo()
+	}
+	order, err := moveOrder(todos, id, position)
+	if err != nil {
+		return err
+	}
+
+	requests := []interface{}{}
+	for i, old := range order {
+		if old != i {
+			todo := todos[old].withDefaults()
+			requests = append(requests, map[string]interface{}{
+				"PutRequest": map[string]dynamoItem{
+					"Item": {"list": items[i]["list"], "id": items[i]["id"], "title": {S: todo.Title}, "doc": {S: marshalTodo(todo)}},
+				},
+			})
+		}
+	}
+
+	return dynamoDB.batchWrite(ctx, requests)
+}
+
+// updateTodo puts the changed todo under the sort key of the item, only if
+// the item still exists.
+func (dynamoDB *DynamoDB) updateTodo(ctx context.Context, id string, fn func(*Todo)) error {
+	item, err := dynamoDB.findItem(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	todo := item.todo().edited(fn)
+	err = dynamoDB.call(ctx, "PutItem", map[string]interface{}{
+		"TableName":                dynamoDB.table,
+		"Item":                     dynamoItem{"list": item["list"], "id": item["id"], "title": {S: todo.Title}, "doc": {S: marshalTodo(todo)}},
+		"ConditionExpression":      "attribute_exists(#id)",
+		"ExpressionAttributeNames": map[string]string{"#id": "id"},
+	}, nil)
+	if err == errDynamoConditionFailed {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// findItem returns the item of the first todo with id.
+func (dynamoDB *DynamoDB) findItem(ctx context.Context, id string) (dynamoItem, error) {
+	var found dynamoItem
+	err := dynamoDB.query(ctx, "", func(items []dynamoItem) error {
+		for _, item := range items {
+			if item.todo().ID == id {
+				found = item
+				return errStopIteration
+			}
+		}
+		return nil
+	})
+	if err != nil && err != errStopIteration {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+
+	return found, nil
+}
+
+// ReplaceAllTodos isn't atomic, readers can see an empty or partial list
+// while it runs.
+func (dynamoDB *DynamoDB) ReplaceAllTodos(ctx context.Context, todos []Todo) error {
+	requests := []interface{}{}
+	err := dynamoDB.query(ctx, "id", func(items []dynamoItem) error {
+		for _, item := range items {
+			requests = append(requests, map[string]interface{}{
+				"DeleteRequest": map[string]dynamoItem{
+					"Key": {"list": {S: dynamoList}, "id": item["id"]},
+				},
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := dynamoDB.batchWrite(ctx, requests); err != nil {
+		return err
+	}
+
+	return dynamoDB.SaveTodos(ctx, todos)
+}
+
+// GetUsage reports the size of the table as StoredBytes, DynamoDB updates it
+// about every six hours.
+func (dynamoDB *DynamoDB) GetUsage(ctx context.Context) (Usage, error) {
+	var usage Usage
+	err := dynamoDB.ForEachTodo(ctx, func(todo Todo) error {
+		usage.Todos++
+		usage.RawBytes += int64(len(marshalTodo(todo)))
+		return nil
+	})
+	if err != nil {
+		return Usage{}, err
+	}
+
+	table, err := dynamoDB.describeTable(ctx)
+	if err != nil {
+		return Usage{}, err
+	}
+	usage.StoredBytes = table.TableSizeBytes
+
+	hostname := getHostname()
+	dynamoDB.metrics.storageRawBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.RawBytes))
+	dynamoDB.metrics.storageStoredBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.StoredBytes))
+
+	return usage, nil
+}
+
+func (dynamoDB *DynamoDB) GetHealthStatus(ctx context.Context) map[string]string {
+	result := map[string]string{"self": okString, "dynamodb": okString}
+	hostname := getHostname()
+
+	table, err := dynamoDB.describeTable(ctx)
+	if err == nil && table.TableStatus != "ACTIVE" {
+		err = errors.New("table is " + strings.ToLower(table.TableStatus))
+	}
+	if err != nil {
+		result["dynamodb"] = err.Error()
+		if ctx.Err() == nil {
+			dynamoDB.metrics.dynamoUp.WithLabelValues(hostname, buildinfo.Version).Set(0)
+		}
+		return result
+	}
+
+	dynamoDB.metrics.dynamoUp.WithLabelValues(hostname, buildinfo.Version).Set(1)
+	return result
+}
+
+// The values of the KV are items of the kv partition, the value with its
+// expiry is the JSON of a kvEntry in the entry attribute. expiresAt is the
+// expiry in unix seconds for the TTL of DynamoDB, which deletes expired items
+// within a few days, reads skip them before that.
+func (dynamoDB *DynamoDB) kvItem(key string, entry kvEntry) (map[string]interface{}, error) {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	item := map[string]interface{}{
+		"list":  dynamoValue{S: dynamoKVList},
+		"id":    dynamoValue{S: key},
+		"entry": dynamoValue{S: string(value)},
+	}
+	if !entry.Expires.IsZero() {
+		item["expiresAt"] = map[string]string{"N": strconv.FormatInt(entry.Expires.Unix()+1, 10)}
+	}
+
+	return item, nil
+}
+
+// getEntry returns the entry of key, its entry attribute as stored and
+// whether it exists.
+func (dynamoDB *DynamoDB) getEntry(ctx context.Context, key string) (kvEntry, string, bool, error) {
+	var response struct {
+		Item dynamoItem `json:"Item"`
+	}
+	err := dynamoDB.call(ctx, "GetItem", map[string]interface{}{
+		"TableName":      dynamoDB.table,
+		"Key":            dynamoItem{"list": {S: dynamoKVList}, "id": {S: key}},
+		"ConsistentRead": true,
+	}, &response)
+	if err != nil || response.Item == nil {
+		return kvEntry{}, "", false, err
+	}
+
+	stored := response.Item["entry"].S
+	var entry kvEntry
+	if err := json.Unmarshal([]byte(stored), &entry); err != nil {
+		return kvEntry{}, "", false, err
+	}
+
+	return entry, stored, true, nil
+}
+
+func (dynamoDB *DynamoDB) GetValue(key string) (string, error) {
+	entry, _, exists, err := dynamoDB.getEntry(context.Background(), key)
+	if err != nil {
+		return "", err
+	}
+	if !exists || entry.expired() {
+		return "", ErrNotFound
+	}
+
+	return entry.Value, nil
+}
+
+func (dynamoDB *DynamoDB) SetValue(key, value string, ttl time.Duration) error {
+	item, err := dynamoDB.kvItem(key, kvEntry{Value: value, Expires: expiry(ttl)})
+	if err != nil {
+		return err
+	}
+
+	return dynamoDB.call(context.Background(), "PutItem", map[string]interface{}{
+		"TableName": dynamoDB.table,
+		"Item":      item,
+	}, nil)
+}
+
+func (dynamoDB *DynamoDB) DeleteValue(key string) error {
+	return dynamoDB.call(context.Background(), "DeleteItem", map[string]interface{}{
+		"TableName": dynamoDB.table,
+		"Key":       dynamoItem{"list": {S: dynamoKVList}, "id": {S: key}},
+	}, nil)
+}
+
+// TakeValue deletes the item and gets the entry it had in the same request.
+func (dynamoDB *DynamoDB) TakeValue(key string) (string, error) {
+	var response struct {
+		Attributes dynamoItem `json:"Attributes"`
+	}
+	err := dynamoDB.call(context.Background(), "DeleteItem", map[string]interface{}{
+		"TableName":    dynamoDB.table,
+		"Key":          dynamoItem{"list": {S: dynamoKVList}, "id": {S: key}},
+		"ReturnValues": "ALL_OLD",
+	}, &response)
+	if err != nil {
+		return "", err
+	}
+	if response.Attributes == nil {
+		return "", ErrNotFound
+	}
+
+	var entry kvEntry
+	if err := json.Unmarshal([]byte(response.Attributes["entry"].S), &entry); err != nil {
+		return "", err
+	}
+	if entry.expired() {
+		return "", ErrNotFound
+	}
+
+	return entry.Value, nil
+}
+
+// IncrValue puts the counted up value only if the item still has the entry
+// it was counted up from, or still doesn't exist.
+func (dynamoDB *DynamoDB) IncrValue(key string, ttl time.Duration) (int64, error) {
+	ctx := context.Background()
+	for attempt := 1; attempt <= kvIncrAttempts; attempt++ {
+		old, stored, exists, err := dynamoDB.getEntry(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+
+		entry, count, err := old.incremented(ttl)
+		if err != nil {
+			return 0, err
+		}
+		item, err := dynamoDB.kvItem(key, entry)
+		if err != nil {
+			return 0, err
+		}
+
+		request := map[string]interface{}{
+			"TableName":                dynamoDB.table,
+			"Item":                     item,
+			"ConditionExpression":      "attribute_not_exists(#id)",
+			"ExpressionAttributeNames": map[string]string{"#id": "id"},
+		}
+		if exists {
+			request["ConditionExpression"] = "#entry = :old"
+			request["ExpressionAttributeNames"] = map[string]string{"#entry": "entry"}
+			request["ExpressionAttributeValues"] = map[string]dynamoValue{":old": {S: stored}}
+		}
+
+		err = dynamoDB.call(ctx, "PutItem", request, nil)
+		if err == nil {
+			return count, nil
+		}
+		if err != errDynamoConditionFailed {
+			return 0, err
+		}
+	}
+
+	return 0, fmt.Errorf("value %s kept changing, gave up after %d attempts", key, kvIncrAttempts)
+}
@@ -0,0 +1,20 @@
+package tododb
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func (dynamoDB *DynamoDB) RegisterMetrics(registerer prometheus.Registerer) error {
+	m := dynamoDB.metrics
+	err := register(registerer,
+		m.dynamoUp,
+		m.storageRawBytes,
+		m.storageStoredBytes,
+	)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Registered DynamoDB Metrics")
+	return nil
+}
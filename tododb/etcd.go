@@ -0,0 +1,828 @@
+package tododb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/johscheuer/todo-app-web/features"
+)
+
+const (
+	defaultEtcdEndpoints      = "http://localhost:2379"
+	defaultEtcdPrefix         = "/todo-app/"
+	defaultEtcdTimeoutSeconds = 5
+	defaultEtcdLeaseTTL       = 10
+
+	// etcdWatchRetry is the pause before a broken watch is created again
+	etcdWatchRetry = 5 * time.Second
+	// etcdUpdateAttempts is how often an update is tried when the todo keeps
+	// changing under it
+	etcdUpdateAttempts = 5
+)
+
+// EtcdDB keeps every todo as a key below prefix in etcd v3. It talks to the
+// JSON gateway of etcd, keys and values are sent base64 encoded, which
+// encoding/json does for []byte.
+type EtcdDB struct {
+	endpoints []string
+	prefix    string
+	leaseTTL  int
+	client    *http.Client
+	// watchClient has no timeout, a watch runs until it's canceled
+	watchClient *http.Client
+	metrics     *Metrics
+}
+
+var _ TodoDB = &EtcdDB{}
+
+// EtcdEvent is a change of a todo seen by Watch, Type is PUT or DELETE.
+type EtcdEvent struct {
+	Type string
+	Todo Todo
+}
+
+type etcdKeyValue struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	// ModRevision is an int64, which the gateway sends as a string
+	ModRevision string `json:"mod_revision,omitempty"`
+}
+
+type etcdRangeRequest struct {
+	Key        []byte `json:"key"`
+	RangeEnd   []byte `json:"range_end,omitempty"`
+	SortOrder  string `json:"sort_order,omitempty"`
+	SortTarget string `json:"sort_target,omitempty"`
+	KeysOnly   bool   `json:"keys_only,omitempty"`
+}
+
+type etcdRangeResponse struct {
+	Kvs []etcdKeyValue `json:"kvs"`
+}
+
+type etcdPutRequest struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	Lease string `json:"lease,omitempty"`
+	// IgnoreLease keeps the lease the key already has
+	IgnoreLease bool `json:"ignore_lease,omitempty"`
+}
+
+type etcdDeleteRangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end,omitempty"`
+	// PrevKv returns the deleted keys with their values
+	PrevKv bool `json:"prev_kv,omitempty"`
+}
+
+type etcdDeleteRangeResponse struct {
+	PrevKvs []etcdKeyValue `json:"prev_kvs"`
+}
+
+type etcdRequestOp struct {
+	RequestPut         *etcdPutRequest         `json:"request_put,omitempty"`
+	RequestDeleteRange *etcdDeleteRangeRequest `json:"request_delete_range,omitempty"`
+}
+
+type etcdCompare struct {
+	Key         []byte `json:"key"`
+	Target      string `json:"target"`
+	ModRevision string `json:"mod_revision"`
+}
+
+type etcdTxnRequest struct {
+	Compare []etcdCompare   `json:"compare,omitempty"`
+	Success []etcdRequestOp `json:"success"`
+}
+
+type etcdTxnResponse struct {
+	Succeeded bool `json:"succeeded"`
+}
+
+type etcdWatchResponse struct {
+	Result struct {
+		Events []struct {
+			Type   string        `json:"type"`
+			Kv     etcdKeyValue  `json:"kv"`
+			PrevKv *etcdKeyValue `json:"prev_kv"`
+		} `json:"events"`
+	} `json:"result"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func NewEtcdDB(config map[string]string) (*EtcdDB, error) {
+	endpoints := defaultEtcdEndpoints
+	if value, exists := config["endpoints"]; exists {
+		endpoints = value
+	}
+
+	prefix := defaultEtcdPrefix
+	if value, exists := config["prefix"]; exists {
+		prefix = value
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	etcdDB := &EtcdDB{
+		prefix:      prefix,
+		leaseTTL:    intConfig(config, "leaseTTL", defaultEtcdLeaseTTL),
+		client:      &http.Client{Timeout: time.Duration(intConfig(config, "timeout", defaultEtcdTimeoutSeconds)) * time.Second},
+		watchClient: &http.Client{},
+		metrics:     NewMetrics(),
+	}
+	for _, endpoint := range strings.Split(endpoints, ",") {
+		etcdDB.endpoints = append(etcdDB.endpoints, strings.TrimRight(strings.TrimSpace(endpoint), "/"))
+	}
+
+	go etcdDB.watchChanges()
+
+	return etcdDB, nil
+}
+
+// prefixEnd is the end of the range of all keys starting with prefix.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	// every key is larger than prefix
+	return []byte{0}
+}
+
+func (etcdDB *EtcdDB) todosPrefix() string {
+	return etcdDB.prefix + "todos/"
+}
+
+func (etcdDB *EtcdDB) newTodoKey() []byte {
+	return []byte(etcdDB.todosPrefix() + newTodoID())
+}
+
+func etcdPost(ctx context.Context, client *http.Client, endpoint, path string, request interface{}) (*http.Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return client.Do(req.WithContext(ctx))
+}
+
+// call sends request to the first endpoint that answers, errors of etcd
+// itself are returned right away.
+func (etcdDB *EtcdDB) call(ctx context.Context, path string, request, response interface{}) error {
+	var lastErr error
+	for _, endpoint := range etcdDB.endpoints {
+		resp, err := etcdPost(ctx, etcdDB.client, endpoint, path, request)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.Warnf("etcd %s: %v", endpoint, err)
+			lastErr = err
+			continue
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("etcd %s%s answered with %s: %s", endpoint, path, resp.Status, bytes.TrimSpace(body))
+		}
+		if response == nil {
+			return nil
+		}
+
+		return json.Unmarshal(body, response)
+	}
+
+	return fmt.Errorf("no etcd endpoint reachable: %v", lastErr)
+}
+
+func (etcdDB *EtcdDB) rangeTodos(ctx context.Context, keysOnly bool) ([]etcdKeyValue, error) {
+	var response etcdRangeResponse
+	err := etcdDB.call(ctx, "/v3/kv/range", etcdRangeRequest{
+		Key:        []byte(etcdDB.todosPrefix()),
+		RangeEnd:   prefixEnd(etcdDB.todosPrefix()),
+		SortOrder:  "ASCEND",
+		SortTarget: "KEY",
+		KeysOnly:   keysOnly,
+	}, &response)
+
+	return response.Kvs, err
+}
+
+func (etcdDB *EtcdDB) GetAllTodos(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	todos := []Todo{}
+	err := etcdDB.ForEachTodo(ctx, func(todo Todo) error {
+		if matchesAll(filters, todo) {
+			todos = append(todos, todo)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return todos, nil
+}
+
+func (etcdDB *EtcdDB) GetTodos(ctx context.Context, offset, limit int, filters ...TodoFilter) ([]Todo, error) {
+	return pageTodos(ctx, etcdDB.ForEachTodo, offset, limit, filters)
+}
+
+func (etcdDB *EtcdDB) CountTodos(ctx context.Context, filters ...TodoFilter) (int, error) {
+	return countTodos(ctx, etcdDB.ForEachTodo, filters)
+}
+
+func (etcdDB *EtcdDB) GetOverdueTodos(ctx context.Context) ([]Todo, error) {
+	return todosByDue(ctx, etcdDB.GetAllTodos, overdueFilter())
+}
+
+func (etcdDB *EtcdDB) GetTodosDueBefore(ctx context.Context, t time.Time) ([]Todo, error) {
+	return todosByDue(ctx, etcdDB.GetAllTodos, TodoFilter{DueBefore: t})
+}
+
+func (etcdDB *EtcdDB) GetTodosByPriority(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	return todosByPriority(ctx, etcdDB.GetAllTodos, filters)
+}
+
+func (etcdDB *EtcdDB) GetTodosByTag(ctx context.Context, tag string) ([]Todo, error) {
+	return todosByTag(ctx, etcdDB.GetAllTodos, tag)
+}
+
+func (etcdDB *EtcdDB) ListTags(ctx context.Context) ([]Tag, error) {
+	return CountTags(ctx, etcdDB.ForEachTodo)
+}
+
+func (etcdDB *EtcdDB) SearchTodos(ctx context.Context, query string) ([]Todo, error) {
+	return searchTodos(ctx, etcdDB.GetAllTodos, query)
+}
+
+func (etcdDB *EtcdDB) ForEachTodo(ctx context.Context, fn func(Todo) error) error {
+	if features.Enabled(features.PerfNPlusOne) {
+		return etcdDB.forEachTodoOneByOne(ctx, fn)
+	}
+
+	kvs, err := etcdDB.rangeTodos(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	for _, kv := range kvs {
+		if err := fn(unmarshalTodo(string(kv.Value))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// forEachTodoOneByOne is the deliberately slow variant of ForEachTodo, with
+// one request per todo.
+func (etcdDB *EtcdDB) forEachTodoOneByOne(ctx context.Context, fn func(Todo) error) error {
+	keys, err := etcdDB.rangeTodos(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		var response etcdRangeResponse
+		if err := etcdDB.call(ctx, "/v3/kv/range", etcdRangeRequest{Key: key.Key}, &response); err != nil {
+			return err
+		}
+		if len(response.Kvs) == 0 {
+			continue
+		}
+		if err := fn(unmarshalTodo(string(response.Kvs[0].Value))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (etcdDB *EtcdDB) SaveTodo(ctx context.Context, todo Todo) error {
+	return etcdDB.call(ctx, "/v3/kv/put", etcdPutRequest{Key: etcdDB.newTodoKey(), Value: []byte(marshalTodo(todo.withDefaults()))}, nil)
+}
+
+// SaveTodos adds all todos in one transaction. etcd limits the operations
+// of a transaction, 128 by default.
+func (etcdDB *EtcdDB) SaveTodos(ctx context.Context, todos []Todo) error {
+	if len(todos) == 0 {
+		return nil
+	}
+
+	return etcdDB.call(ctx, "/v3/kv/txn", etcdTxnRequest{Success: etcdDB.putOps(todos)}, nil)
+}
+
+func (etcdDB *EtcdDB) putOps(todos []Todo) []etcdRequestOp {
+	ops := make([]etcdRequestOp, 0, len(todos))
+	for _, todo := range todos {
+		ops = append(ops, etcdRequestOp{RequestPut: &etcdPutRequest{Key: etcdDB.newTodoKey(), Value: []byte(marshalTodo(todo.withDefaults()))}})
+	}
+
+	return ops
+}
+
+func (etcdDB *EtcdDB) DeleteTodo(ctx context.Context, id string) error {
+	kvs, err := etcdDB.rangeTodos(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	for _, kv := range kvs {
+		if unmarshalTodo(string(kv.Value)).ID == id {
+			return etcdDB.call(ctx, "/v3/kv/deleterange", etcdDeleteRangeRequest{Key: kv.Key}, nil)
+		}
+	}
+
+	return nil
+}
+
+func (etcdDB *EtcdDB) DeleteTodos(ctx context.Context, ids []string) error {
+	return deleteEach(ctx, ids, etcdDB.DeleteTodo)
+}
+
+func (etcdDB *EtcdDB) UpdateTodo(ctx context.Context, id string, update func(*Todo)) error {
+	return etcdDB.updateTodo(ctx, id, update)
+}
+
+func (etcdDB *EtcdDB) UpdateTodos(ctx context.Context, ids []string, update func(*Todo)) error {
+	return updateEach(ctx, ids, update, etcdDB.UpdateTodo)
+}
+
+func (etcdDB *EtcdDB) CompleteTodo(ctx context.Context, id string) error {
+	return etcdDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = true
+	})
+}
+
+func (etcdDB *EtcdDB) CompleteTodos(ctx context.Context, ids []string) error {
+	return completeEach(ctx, ids, etcdDB.CompleteTodo)
+}
+
+func (etcdDB *EtcdDB) ReopenTodo(ctx context.Context, id string) error {
+	return etcdDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = false
+	})
+}
+
+func (etcdDB *EtcdDB) SetDescription(ctx context.Context, id string, description string) error {
+	return etcdDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Description = description
+	})
+}
+
+func (etcdDB *EtcdDB) SetDue(ctx context.Context, id string, due *time.Time) error {
+	return etcdDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Due = due
+	})
+}
+
+func (etcdDB *EtcdDB) SetPriority(ctx context.Context, id string, priority int) error {
+	return etcdDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Priority = priority
+	})
+}
+
+func (etcdDB *EtcdDB) SetTags(ctx context.Context, id string, tags []string) error {
+	return etcdDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Tags = tags
+	})
+}
+
+func (etcdDB *EtcdDB) SetRecurrence(ctx context.Context, id string, recurrence string) error {
+	return etcdDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Recurrence = recurrence
+	})
+}
+
+func (etcdDB *EtcdDB) AddSubTask(ctx context.Context, id string, title string) (SubTask, error) {
+	var subTask SubTask
+	err := etcdDB.updateTodo(ctx, id, func(todo *Todo) {
+		subTask = todo.addSubTask(title)
+	})
+
+	return subTask, err
+}
+
+func (etcdDB *EtcdDB) CompleteSubTask(ctx context.Context, id string, subTaskID string) error {
+	found := false
+	err := etcdDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.completeSubTask(subTaskID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (etcdDB *EtcdDB) GetSubTasks(ctx context.Context, id string) ([]SubTask, error) {
+	return subTasksOf(ctx, etcdDB.ForEachTodo, id)
+}
+
+func (etcdDB *EtcdDB) AddAttachment(ctx context.Context, id string, attachment Attachment) error {
+	return etcdDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.addAttachment(attachment)
+	})
+}
+
+func (etcdDB *EtcdDB) RemoveAttachment(ctx context.Context, id string, attachmentID string) error {
+	found := false
+	err := etcdDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.removeAttachment(attachmentID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// updateTodo puts the changed todo under its key only if the key wasn't
+// modified since it was read, and starts over otherwise.
+func (etcdDB *EtcdDB) updateTodo(ctx context.Context, id string, fn func(*Todo)) error {
+	for attempt := 1; ; attempt++ {
+		kvs, err := etcdDB.rangeTodos(ctx, false)
+		if err != nil {
+			return err
+		}
+
+		var found *etcdKeyValue
+		for i := range kvs {
+			if unmarshalTodo(string(kvs[i].Value)).ID == id {
+				found = &kvs[i]
+				break
+			}
+		}
+		if found == nil {
+			return ErrNotFound
+		}
+
+		var response etcdTxnResponse
+		err = etcdDB.call(ctx, "/v3/kv/txn", etcdTxnRequest{
+			Compare: []etcdCompare{{Key: found.Key, Target: "MOD", ModRevision: found.ModRevision}},
+			Success: []etcdRequestOp{{RequestPut: &etcdPutRequest{
+				Key:   found.Key,
+				Value: []byte(marshalTodo(unmarshalTodo(string(found.Value)).edited(fn))),
+			}}},
+		}, &response)
+		if err != nil || response.Succeeded {
+			return err
+		}
+		if attempt >= etcdUpdateAttempts {
+			return fmt.Errorf("todo %s kept changing, gave up after %d attempts", id, attempt)
+		}
+		logger.Debugf("Todo %s changed during update, attempt %d", id, attempt)
+	}
+}
+
+// MoveTodo puts the todos from the old to the new position of the todo
+// under the keys of their new places, in one transaction that only succeeds
+// if none of the keys was modified since they were read.
+func (etcdDB *EtcdDB) MoveTodo(ctx context.Context, id string, position int) error {
+	for attempt := 1; ; attempt++ {
+		kvs, err := etcdDB.rangeTodos(ctx, false)
+		if err != nil {
+			return err
+		}
+
+		todos := make([]Todo, len(kvs))
+		for i, kv := range kvs {
+			todos[i] = unmarshalTodo(string(kv.Value))
+		}
+		order, err := moveOrder(todos, id, position)
+		if err != nil {
+			return err
+		}
+
+		request := etcdTxnRequest{}
+		for i, old := range order {
+			if old != i {
+				request.Compare = append(request.Compare, etcdCompare{Key: kvs[i].Key, Target: "MOD", ModRevision: kvs[i].ModRevision})
+				request.Success = append(request.Success, etcdRequestOp{RequestPut: &etcdPutRequest{Key: kvs[i].Key, Value: kvs[old].Value}})
+			}
+		}
+		if len(request.Success) == 0 {
+			return nil
+		}
+
+		var response etcdTxnResponse
+		if err := etcdDB.call(ctx, "/v3/kv/txn", request, &response); err != nil || response.Succeeded {
+			return err
+		}
+		if attempt >= etcdUpdateAttempts {
+			return fmt.Errorf("todos kept changing, gave up moving %s after %d attempts", id, attempt)
+		}
+		logger.Debugf("Todos changed during move of %s, attempt %d", id, attempt)
+	}
+}
+
+// ReplaceAllTodos deletes and adds the todos in one transaction, readers see
+// either the old or the new list.
+func (etcdDB *EtcdDB) ReplaceAllTodos(ctx context.Context, todos []Todo) error {
+	ops := []etcdRequestOp{{RequestDeleteRange: &etcdDeleteRangeRequest{
+		Key:      []byte(etcdDB.todosPrefix()),
+		RangeEnd: prefixEnd(etcdDB.todosPrefix()),
+	}}}
+
+	return etcdDB.call(ctx, "/v3/kv/txn", etcdTxnRequest{Success: append(ops, etcdDB.putOps(todos)...)}, nil)
+}
+
+// GetUsage reports the size of the whole etcd database as StoredBytes, etcd
+// can't tell the size of a range of keys.
+func (etcdDB *EtcdDB) GetUsage(ctx context.Context) (Usage, error) {
+	kvs, err := etcdDB.rangeTodos(ctx, false)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	usage := Usage{Todos: int64(len(kvs))}
+	for _, kv := range kvs {
+		usage.RawBytes += int64(len(kv.Value))
+	}
+
+	var status struct {
+		DbSize string `json:"dbSize"`
+	}
+	if err := etcdDB.call(ctx, "/v3/maintenance/status", struct{}{}, &status); err != nil {
+		logger.Warnf("etcd status: %v", err)
+	}
+	usage.StoredBytes, _ = strconv.ParseInt(status.DbSize, 10, 64)
+
+	hostname := getHostname()
+	etcdDB.metrics.storageRawBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.RawBytes))
+	etcdDB.metrics.storageStoredBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.StoredBytes))
+
+	return usage, nil
+}
+
+// GetHealthStatus grants a lease and writes the health key of this instance
+// with it, which needs a leader. The key expires with the lease, so the
+// keys below health/ are the instances checked within the last leaseTTL
+// seconds.
+func (etcdDB *EtcdDB) GetHealthStatus(ctx context.Context) map[string]string {
+	result := map[string]string{"self": okString, "etcd": okString}
+	hostname := getHostname()
+
+	if err := etcdDB.checkLease(ctx, hostname); err != nil {
+		result["etcd"] = err.Error()
+		if ctx.Err() == nil {
+			etcdDB.metrics.etcdUp.WithLabelValues(hostname, buildinfo.Version).Set(0)
+		}
+	} else {
+		etcdDB.metrics.etcdUp.WithLabelValues(hostname, buildinfo.Version).Set(1)
+	}
+
+	return result
+}
+
+func (etcdDB *EtcdDB) checkLease(ctx context.Context, hostname string) error {
+	lease, err := etcdDB.grantLease(ctx, etcdDB.leaseTTL)
+	if err != nil {
+		return err
+	}
+
+	return etcdDB.call(ctx, "/v3/kv/put", etcdPutRequest{
+		Key:   []byte(etcdDB.prefix + "health/" + hostname),
+		Value: []byte(time.Now().UTC().Format(time.RFC3339)),
+		Lease: lease,
+	}, nil)
+}
+
+// grantLease returns the id of a new lease of ttl seconds.
+func (etcdDB *EtcdDB) grantLease(ctx context.Context, ttl int) (string, error) {
+	var lease struct {
+		ID    string `json:"ID"`
+		Error string `json:"error"`
+	}
+	if err := etcdDB.call(ctx, "/v3/lease/grant", map[string]int{"TTL": ttl}, &lease); err != nil {
+		return "", err
+	}
+	if lease.ID == "" {
+		return "", fmt.Errorf("lease not granted: %s", lease.Error)
+	}
+
+	return lease.ID, nil
+}
+
+// Watch calls fn for every change of the todos until ctx is done or the
+// watch breaks, changes of this instance included.
+func (etcdDB *EtcdDB) Watch(ctx context.Context, fn func(EtcdEvent)) error {
+	request := map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":       []byte(etcdDB.todosPrefix()),
+			"range_end": prefixEnd(etcdDB.todosPrefix()),
+			"prev_kv":   true,
+		},
+	}
+
+	var lastErr error
+	for _, endpoint := range etcdDB.endpoints {
+		resp, err := etcdPost(ctx, etcdDB.watchClient, endpoint, "/v3/watch", request)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("etcd %s/v3/watch answered with %s", endpoint, resp.Status)
+		}
+
+		decoder := json.NewDecoder(resp.Body)
+		for {
+			var message etcdWatchResponse
+			if err := decoder.Decode(&message); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return err
+			}
+			if message.Error != nil {
+				return fmt.Errorf("etcd watch: %s", message.Error.Message)
+			}
+
+			for _, event := range message.Result.Events {
+				// the gateway leaves out PUT, the default of the enum
+				change := EtcdEvent{Type: "PUT", Todo: unmarshalTodo(string(event.Kv.Value))}
+				if event.Type == "DELETE" {
+					change.Type = "DELETE"
+					if event.PrevKv != nil {
+						change.Todo = unmarshalTodo(string(event.PrevKv.Value))
+					}
+				}
+				fn(change)
+			}
+		}
+	}
+
+	return fmt.Errorf("no etcd endpoint reachable: %v", lastErr)
+}
+
+// watchChanges counts the changes of the todos by all instances, and
+// creates the watch again when it breaks.
+func (etcdDB *EtcdDB) watchChanges() {
+	hostname := getHostname()
+	for {
+		err := etcdDB.Watch(context.Background(), func(event EtcdEvent) {
+			logger.Debugf("etcd %s %q", event.Type, event.Todo.Title)
+			etcdDB.metrics.etcdWatchEventsTotal.WithLabelValues(hostname, buildinfo.Version, strings.ToLower(event.Type)).Inc()
+		})
+		logger.Warnf("etcd watch: %v, retrying in %s", err, etcdWatchRetry)
+		time.Sleep(etcdWatchRetry)
+	}
+}
+
+// The values of the KV are kept below <prefix>kv/ as JSON of a kvEntry. A
+// value with a ttl gets a lease of its own, which removes it, reads skip it
+// once it expired even if etcd didn't get to it yet.
+func (etcdDB *EtcdDB) kvKey(key string) []byte {
+	return []byte(etcdDB.prefix + "kv/" + key)
+}
+
+// getEntry returns the entry of key, its ModRevision and whether it exists.
+func (etcdDB *EtcdDB) getEntry(ctx context.Context, key string) (kvEntry, string, bool, error) {
+	var response etcdRangeResponse
+	if err := etcdDB.call(ctx, "/v3/kv/range", etcdRangeRequest{Key: etcdDB.kvKey(key)}, &response); err != nil {
+		return kvEntry{}, "", false, err
+	}
+	if len(response.Kvs) == 0 {
+		return kvEntry{}, "0", false, nil
+	}
+
+	var entry kvEntry
+	if err := json.Unmarshal(response.Kvs[0].Value, &entry); err != nil {
+		return kvEntry{}, "", false, err
+	}
+
+	return entry, response.Kvs[0].ModRevision, true, nil
+}
+
+// putEntry returns the put of entry, with a new lease if it expires.
+// Without one the key keeps its lease if keepLease is set.
+func (etcdDB *EtcdDB) putEntry(ctx context.Context, key string, entry kvEntry, ttl time.Duration, keepLease bool) (*etcdPutRequest, error) {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	put := &etcdPutRequest{Key: etcdDB.kvKey(key), Value: value, IgnoreLease: keepLease && ttl <= 0}
+	if ttl > 0 {
+		// leases are in seconds, rounded up so the key outlives the entry
+		if put.Lease, err = etcdDB.grantLease(ctx, int((ttl+time.Second-1)/time.Second)); err != nil {
+			return nil, err
+		}
+	}
+
+	return put, nil
+}
+
+func (etcdDB *EtcdDB) GetValue(key string) (string, error) {
+	entry, _, exists, err := etcdDB.getEntry(context.Background(), key)
+	if err != nil {
+		return "", err
+	}
+	if !exists || entry.expired() {
+		return "", ErrNotFound
+	}
+
+	return entry.Value, nil
+}
+
+func (etcdDB *EtcdDB) SetValue(key, value string, ttl time.Duration) error {
+	ctx := context.Background()
+	put, err := etcdDB.putEntry(ctx, key, kvEntry{Value: value, Expires: expiry(ttl)}, ttl, false)
+	if err != nil {
+		return err
+	}
+
+	return etcdDB.call(ctx, "/v3/kv/put", put, nil)
+}
+
+func (etcdDB *EtcdDB) DeleteValue(key string) error {
+	return etcdDB.call(context.Background(), "/v3/kv/deleterange", etcdDeleteRangeRequest{Key: etcdDB.kvKey(key)}, nil)
+}
+
+// TakeValue deletes the key and gets the value it had in the same request.
+func (etcdDB *EtcdDB) TakeValue(key string) (string, error) {
+	var response etcdDeleteRangeResponse
+	err := etcdDB.call(context.Background(), "/v3/kv/deleterange", etcdDeleteRangeRequest{Key: etcdDB.kvKey(key), PrevKv: true}, &response)
+	if err != nil {
+		return "", err
+	}
+	if len(response.PrevKvs) == 0 {
+		return "", ErrNotFound
+	}
+
+	var entry kvEntry
+	if err := json.Unmarshal(response.PrevKvs[0].Value, &entry); err != nil {
+		return "", err
+	}
+	if entry.expired() {
+		return "", ErrNotFound
+	}
+
+	return entry.Value, nil
+}
+
+// IncrValue puts the counted up value only if the key wasn't modified since
+// it was read, a missing key has the ModRevision 0.
+func (etcdDB *EtcdDB) IncrValue(key string, ttl time.Duration) (int64, error) {
+	ctx := context.Background()
+	for attempt := 1; attempt <= kvIncrAttempts; attempt++ {
+		old, revision, exists, err := etcdDB.getEntry(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+
+		entry, count, err := old.incremented(ttl)
+		if err != nil {
+			return 0, err
+		}
+		put, err := etcdDB.putEntry(ctx, key, entry, ttl, exists && !old.expired())
+		if err != nil {
+			return 0, err
+		}
+
+		var response etcdTxnResponse
+		err = etcdDB.call(ctx, "/v3/kv/txn", etcdTxnRequest{
+			Compare: []etcdCompare{{Key: put.Key, Target: "MOD", ModRevision: revision}},
+			Success: []etcdRequestOp{{RequestPut: put}},
+		}, &response)
+		if err != nil {
+			return 0, err
+		}
+		if response.Succeeded {
+			return count, nil
+		}
+	}
+
+	return 0, fmt.Errorf("value %s kept changing, gave up after %d attempts", key, kvIncrAttempts)
+}
@@ -0,0 +1,21 @@
+package tododb
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func (etcdDB *EtcdDB) RegisterMetrics(registerer prometheus.Registerer) error {
+	m := etcdDB.metrics
+	err := register(registerer,
+		m.etcdUp,
+		m.etcdWatchEventsTotal,
+		m.storageRawBytes,
+		m.storageStoredBytes,
+	)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Registered etcd Metrics")
+	return nil
+}
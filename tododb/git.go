@@ -0,0 +1,664 @@
+package tododb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/johscheuer/todo-app-web/features"
+)
+
+// GitDB keeps the todo list as a JSON file in a Git repository and commits
+// every change. If a remote is configured, changes are rebased onto and
+// pushed to it, so several instances can share one repository.
+type GitDB struct {
+	mu sync.RWMutex
+
+	path        string
+	file        string
+	remote      string
+	branch      string
+	authorName  string
+	authorEmail string
+	metrics     *Metrics
+}
+
+var _ TodoDB = &GitDB{}
+
+func NewGitDB(config map[string]string) (*GitDB, error) {
+	defaults := map[string]string{
+		"path":        "./todo-data",
+		"remote":      "",
+		"branch":      "master",
+		"authorName":  "todo-app",
+		"authorEmail": "todo-app@localhost",
+	}
+	for key, value := range defaults {
+		if _, exists := config[key]; !exists {
+			config[key] = value
+		}
+	}
+
+	db := &GitDB{
+		path:        config["path"],
+		file:        redisKey + ".json",
+		remote:      config["remote"],
+		branch:      config["branch"],
+		authorName:  config["authorName"],
+		authorEmail: config["authorEmail"],
+		metrics:     NewMetrics(),
+	}
+
+	if err := db.open(); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// open clones the remote or initializes an empty repository unless path
+// already holds one.
+func (db *GitDB) open() error {
+	if _, err := os.Stat(filepath.Join(db.path, ".git")); err == nil {
+		return nil
+	}
+
+	if db.remote != "" {
+		logger.Infof("Cloning %s into %s", db.remote, db.path)
+		if out, err := exec.Command("git", "clone", db.remote, db.path).CombinedOutput(); err != nil {
+			return fmt.Errorf("git clone: %v: %s", err, out)
+		}
+	} else {
+		if err := os.MkdirAll(db.path, 0755); err != nil {
+			return err
+		}
+		if _, err := db.git(context.Background(), "init"); err != nil {
+			return err
+		}
+	}
+
+	if _, err := db.git(context.Background(), "checkout", db.branch); err != nil {
+		// Fresh repositories and remotes without the branch
+		_, err = db.git(context.Background(), "symbolic-ref", "HEAD", "refs/heads/"+db.branch)
+		return err
+	}
+
+	return nil
+}
+
+// git runs a git command in the working copy, it is killed when ctx is done.
+func (db *GitDB) git(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = db.path
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME="+db.authorName,
+		"GIT_AUTHOR_EMAIL="+db.authorEmail,
+		"GIT_COMMITTER_NAME="+db.authorName,
+		"GIT_COMMITTER_EMAIL="+db.authorEmail,
+		"GIT_TERMINAL_PROMPT=0",
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// readTodos also reads files written before todos were documents, they hold
+// an array of titles.
+func (db *GitDB) readTodos() ([]Todo, error) {
+	content, err := ioutil.ReadFile(filepath.Join(db.path, db.file))
+	if os.IsNotExist(err) {
+		return []Todo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	todos := []Todo{}
+	if err := json.Unmarshal(content, &todos); err != nil {
+		return nil, err
+	}
+
+	return todos, nil
+}
+
+// writeTodos replaces the list file and commits it with message. It doesn't
+// take a context, stopping between writing the file and the commit would
+// leave the working copy dirty.
+func (db *GitDB) writeTodos(todos []Todo, message string) error {
+	content, err := json.MarshalIndent(todos, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(db.path, "."+db.file+".tmp")
+	if err := ioutil.WriteFile(tmp, append(content, '\n'), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(db.path, db.file)); err != nil {
+		return err
+	}
+
+	if _, err := db.git(context.Background(), "add", db.file); err != nil {
+		return err
+	}
+	if _, err := db.git(context.Background(), "commit", "--quiet", "-m", message); err != nil {
+		return err
+	}
+	db.metrics.gitCommitsTotal.WithLabelValues(getHostname(), buildinfo.Version).Inc()
+	logger.Debugf("Committed %q", message)
+
+	return db.push()
+}
+
+// pull rebases onto the remote branch before a change is made. A missing
+// remote branch is fine, it gets created by the first push.
+func (db *GitDB) pull(ctx context.Context) error {
+	if db.remote == "" {
+		return nil
+	}
+
+	if _, err := db.git(ctx, "pull", "--quiet", "--rebase", "origin", db.branch); err != nil {
+		if _, statErr := os.Stat(filepath.Join(db.path, ".git", "rebase-merge")); statErr == nil {
+			db.git(context.Background(), "rebase", "--abort")
+			return err
+		}
+		logger.Warnf("%v", err)
+	}
+
+	return nil
+}
+
+func (db *GitDB) push() error {
+	if db.remote == "" {
+		return nil
+	}
+
+	if _, err := db.git(context.Background(), "push", "--quiet", "origin", "HEAD:refs/heads/"+db.branch); err != nil {
+		db.metrics.gitPushFailuresTotal.WithLabelValues(getHostname(), buildinfo.Version).Inc()
+		return err
+	}
+
+	return nil
+}
+
+// update runs change against the current list under the write lock and
+// commits the result if change reports a modification. ctx can only stop it
+// until the pull is done.
+func (db *GitDB) update(ctx context.Context, change func([]Todo) ([]Todo, string)) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := db.pull(ctx); err != nil {
+		return err
+	}
+
+	todos, err := db.readTodos()
+	if err != nil {
+		return err
+	}
+
+	todos, message := change(todos)
+	if message == "" {
+		return nil
+	}
+
+	return db.writeTodos(todos, message)
+}
+
+func (db *GitDB) GetAllTodos(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var (
+		todos []Todo
+		err   error
+	)
+	if features.Enabled(features.PerfNPlusOne) {
+		todos, err = db.readTodosOneByOne(ctx)
+	} else {
+		todos, err = db.readTodos()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return filterTodos(todos, filters), nil
+}
+
+// GetTodos cuts the page out of all todos, the file is read as a whole
+// anyway.
+func (db *GitDB) GetTodos(ctx context.Context, offset, limit int, filters ...TodoFilter) ([]Todo, error) {
+	if err := checkPage(offset, limit); err != nil {
+		return nil, err
+	}
+
+	todos, err := db.GetAllTodos(ctx, filters...)
+	if err != nil {
+		return nil, err
+	}
+
+	return pageOf(todos, offset, limit), nil
+}
+
+func (db *GitDB) CountTodos(ctx context.Context, filters ...TodoFilter) (int, error) {
+	todos, err := db.GetAllTodos(ctx, filters...)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(todos), nil
+}
+
+func (db *GitDB) GetOverdueTodos(ctx context.Context) ([]Todo, error) {
+	return todosByDue(ctx, db.GetAllTodos, overdueFilter())
+}
+
+func (db *GitDB) GetTodosDueBefore(ctx context.Context, t time.Time) ([]Todo, error) {
+	return todosByDue(ctx, db.GetAllTodos, TodoFilter{DueBefore: t})
+}
+
+func (db *GitDB) GetTodosByPriority(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	return todosByPriority(ctx, db.GetAllTodos, filters)
+}
+
+func (db *GitDB) GetTodosByTag(ctx context.Context, tag string) ([]Todo, error) {
+	return todosByTag(ctx, db.GetAllTodos, tag)
+}
+
+func (db *GitDB) ListTags(ctx context.Context) ([]Tag, error) {
+	return CountTags(ctx, db.ForEachTodo)
+}
+
+func (db *GitDB) SearchTodos(ctx context.Context, query string) ([]Todo, error) {
+	return searchTodos(ctx, db.GetAllTodos, query)
+}
+
+// readTodosOneByOne is the deliberately slow variant of readTodos, parsing
+// the whole file again for every single todo.
+func (db *GitDB) readTodosOneByOne(ctx context.Context) ([]Todo, error) {
+	all, err := db.readTodos()
+	if err != nil {
+		return nil, err
+	}
+
+	todos := make([]Todo, 0, len(all))
+	for i := range all {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		current, err := db.readTodos()
+		if err != nil {
+			return nil, err
+		}
+		if i >= len(current) {
+			break
+		}
+		todos = append(todos, current[i])
+	}
+
+	return todos, nil
+}
+
+func (db *GitDB) ForEachTodo(ctx context.Context, fn func(Todo) error) error {
+	todos, err := db.GetAllTodos(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, todo := range todos {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := fn(todo); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *GitDB) SaveTodo(ctx context.Context, todo Todo) error {
+	return db.SaveTodos(ctx, []Todo{todo})
+}
+
+func (db *GitDB) SaveTodos(ctx context.Context, todos []Todo) error {
+	if len(todos) == 0 {
+		return nil
+	}
+
+	added := make([]Todo, len(todos))
+	for i, todo := range todos {
+		added[i] = todo.withDefaults()
+	}
+
+	return db.update(ctx, func(current []Todo) ([]Todo, string) {
+		message := fmt.Sprintf("Add todo: %s", todos[0].Title)
+		if len(todos) > 1 {
+			message = fmt.Sprintf("Add %d todos", len(todos))
+		}
+
+		return append(current, added...), message
+	})
+}
+
+func (db *GitDB) DeleteTodo(ctx context.Context, id string) error {
+	return db.update(ctx, func(current []Todo) ([]Todo, string) {
+		for i, existing := range current {
+			if existing.ID == id {
+				return append(current[:i], current[i+1:]...), fmt.Sprintf("Delete todo: %s", existing.Title)
+			}
+		}
+
+		return current, ""
+	})
+}
+
+func (db *GitDB) DeleteTodos(ctx context.Context, ids []string) error {
+	return db.update(ctx, func(current []Todo) ([]Todo, string) {
+		wanted := idSet(ids)
+		kept := make([]Todo, 0, len(current))
+		for _, existing := range current {
+			if wanted[existing.ID] {
+				delete(wanted, existing.ID)
+				continue
+			}
+			kept = append(kept, existing)
+		}
+
+		if len(kept) == len(current) {
+			return current, ""
+		}
+		return kept, fmt.Sprintf("Delete %d todos", len(current)-len(kept))
+	})
+}
+
+func (db *GitDB) UpdateTodo(ctx context.Context, id string, update func(*Todo)) error {
+	return db.updateTodo(ctx, id, func(todo *Todo) string {
+		update(todo)
+		return fmt.Sprintf("Update todo: %s", todo.Title)
+	})
+}
+
+func (db *GitDB) UpdateTodos(ctx context.Context, ids []string, update func(*Todo)) error {
+	return db.update(ctx, func(current []Todo) ([]Todo, string) {
+		wanted := idSet(ids)
+		updated := 0
+		for i, existing := range current {
+			if wanted[existing.ID] {
+				delete(wanted, existing.ID)
+				current[i] = existing.edited(update)
+				updated++
+			}
+		}
+
+		if updated == 0 {
+			return current, ""
+		}
+		return current, fmt.Sprintf("Update %d todos", updated)
+	})
+}
+
+func (db *GitDB) CompleteTodo(ctx context.Context, id string) error {
+	return db.updateTodo(ctx, id, func(todo *Todo) string {
+		todo.Done = true
+		return fmt.Sprintf("Complete todo: %s", todo.Title)
+	})
+}
+
+func (db *GitDB) CompleteTodos(ctx context.Context, ids []string) error {
+	return db.update(ctx, func(current []Todo) ([]Todo, string) {
+		wanted := idSet(ids)
+		completed := 0
+		for i, existing := range current {
+			if wanted[existing.ID] {
+				delete(wanted, existing.ID)
+				current[i] = existing.edited(func(todo *Todo) {
+					todo.Done = true
+				})
+				completed++
+			}
+		}
+
+		if completed == 0 {
+			return current, ""
+		}
+		return current, fmt.Sprintf("Complete %d todos", completed)
+	})
+}
+
+func (db *GitDB) ReopenTodo(ctx context.Context, id string) error {
+	return db.updateTodo(ctx, id, func(todo *Todo) string {
+		todo.Done = false
+		return fmt.Sprintf("Reopen todo: %s", todo.Title)
+	})
+}
+
+func (db *GitDB) SetDescription(ctx context.Context, id string, description string) error {
+	return db.updateTodo(ctx, id, func(todo *Todo) string {
+		todo.Description = description
+		return fmt.Sprintf("Edit description of todo: %s", todo.Title)
+	})
+}
+
+func (db *GitDB) SetDue(ctx context.Context, id string, due *time.Time) error {
+	return db.updateTodo(ctx, id, func(todo *Todo) string {
+		todo.Due = due
+		if due == nil {
+			return fmt.Sprintf("Remove due date of todo: %s", todo.Title)
+		}
+		return fmt.Sprintf("Set due date of todo: %s", todo.Title)
+	})
+}
+
+func (db *GitDB) SetPriority(ctx context.Context, id string, priority int) error {
+	return db.updateTodo(ctx, id, func(todo *Todo) string {
+		todo.Priority = priority
+		if priority == PriorityNone {
+			return fmt.Sprintf("Remove priority of todo: %s", todo.Title)
+		}
+		return fmt.Sprintf("Set priority of todo to %d: %s", priority, todo.Title)
+	})
+}
+
+func (db *GitDB) SetTags(ctx context.Context, id string, tags []string) error {
+	return db.updateTodo(ctx, id, func(todo *Todo) string {
+		todo.Tags = tags
+		if len(tags) == 0 {
+			return fmt.Sprintf("Remove tags of todo: %s", todo.Title)
+		}
+		return fmt.Sprintf("Tag todo with %s: %s", strings.Join(normalizeTags(tags), ", "), todo.Title)
+	})
+}
+
+func (db *GitDB) SetRecurrence(ctx context.Context, id string, recurrence string) error {
+	return db.updateTodo(ctx, id, func(todo *Todo) string {
+		todo.Recurrence = recurrence
+		if recurrence == "" {
+			return fmt.Sprintf("End recurrence of todo: %s", todo.Title)
+		}
+		return fmt.Sprintf("Repeat todo %s: %s", recurrence, todo.Title)
+	})
+}
+
+func (db *GitDB) AddSubTask(ctx context.Context, id string, title string) (SubTask, error) {
+	var subTask SubTask
+	err := db.updateTodo(ctx, id, func(todo *Todo) string {
+		subTask = todo.addSubTask(title)
+		return fmt.Sprintf("Add sub-task %s: %s", title, todo.Title)
+	})
+
+	return subTask, err
+}
+
+func (db *GitDB) CompleteSubTask(ctx context.Context, id string, subTaskID string) error {
+	found := false
+	err := db.updateTodo(ctx, id, func(todo *Todo) string {
+		if found = todo.completeSubTask(subTaskID); !found {
+			return ""
+		}
+		done, total := todo.SubTaskProgress()
+		return fmt.Sprintf("Complete sub-task %d/%d: %s", done, total, todo.Title)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (db *GitDB) GetSubTasks(ctx context.Context, id string) ([]SubTask, error) {
+	return subTasksOf(ctx, db.ForEachTodo, id)
+}
+
+func (db *GitDB) AddAttachment(ctx context.Context, id string, attachment Attachment) error {
+	return db.updateTodo(ctx, id, func(todo *Todo) string {
+		todo.addAttachment(attachment)
+		return fmt.Sprintf("Attach %s: %s", attachment.Name, todo.Title)
+	})
+}
+
+func (db *GitDB) RemoveAttachment(ctx context.Context, id string, attachmentID string) error {
+	found := false
+	err := db.updateTodo(ctx, id, func(todo *Todo) string {
+		if found = todo.removeAttachment(attachmentID); !found {
+			return ""
+		}
+		return fmt.Sprintf("Remove attachment: %s", todo.Title)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (db *GitDB) MoveTodo(ctx context.Context, id string, position int) error {
+	var moveErr error
+	err := db.update(ctx, func(current []Todo) ([]Todo, string) {
+		order, err := moveOrder(current, id, position)
+		if err != nil {
+			moveErr = err
+			return current, ""
+		}
+
+		for i, old := range order {
+			if current[old].ID == id {
+				return reordered(current, order), fmt.Sprintf("Move todo to position %d: %s", i, current[old].Title)
+			}
+		}
+		return current, ""
+	})
+	if err == nil {
+		return moveErr
+	}
+
+	return err
+}
+
+// updateTodo changes the todo with id by fn, which returns the commit
+// message.
+func (db *GitDB) updateTodo(ctx context.Context, id string, fn func(*Todo) string) error {
+	found := false
+	err := db.update(ctx, func(current []Todo) ([]Todo, string) {
+		for i, existing := range current {
+			if existing.ID == id {
+				found = true
+				var message string
+				current[i] = existing.edited(func(todo *Todo) {
+					message = fn(todo)
+				})
+				return current, message
+			}
+		}
+
+		return current, ""
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (db *GitDB) ReplaceAllTodos(ctx context.Context, todos []Todo) error {
+	replaced := make([]Todo, len(todos))
+	for i, todo := range todos {
+		replaced[i] = todo.withDefaults()
+	}
+
+	return db.update(ctx, func(current []Todo) ([]Todo, string) {
+		return replaced, fmt.Sprintf("Replace all todos with %d todos", len(todos))
+	})
+}
+
+func (db *GitDB) GetUsage(ctx context.Context) (Usage, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return Usage{}, err
+	}
+
+	todos, err := db.readTodos()
+	if err != nil {
+		return Usage{}, err
+	}
+
+	usage := Usage{Todos: int64(len(todos))}
+	for _, todo := range todos {
+		usage.RawBytes += int64(len(marshalTodo(todo)))
+	}
+	if info, err := os.Stat(filepath.Join(db.path, db.file)); err == nil {
+		usage.StoredBytes = info.Size()
+	}
+
+	hostname := getHostname()
+	db.metrics.storageRawBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.RawBytes))
+	db.metrics.storageStoredBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.StoredBytes))
+
+	return usage, nil
+}
+
+func (db *GitDB) GetHealthStatus(ctx context.Context) map[string]string {
+	result := map[string]string{"self": okString, "git-local": okString}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if _, err := db.git(ctx, "status", "--porcelain"); err != nil {
+		result["git-local"] = err.Error()
+	}
+
+	if db.remote != "" {
+		result["git-remote"] = okString
+		if _, err := db.git(ctx, "ls-remote", "--heads", "origin"); err != nil {
+			result["git-remote"] = err.Error()
+		}
+	}
+
+	return result
+}
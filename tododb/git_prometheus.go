@@ -0,0 +1,21 @@
+package tododb
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func (db *GitDB) RegisterMetrics(registerer prometheus.Registerer) error {
+	m := db.metrics
+	err := register(registerer,
+		m.gitCommitsTotal,
+		m.gitPushFailuresTotal,
+		m.storageRawBytes,
+		m.storageStoredBytes,
+	)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Registered Git Metrics")
+	return nil
+}
@@ -0,0 +1,380 @@
+package tododb
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrNotFound is returned by KV for missing or expired keys.
+var ErrNotFound = errors.New("not found")
+
+// KV stores small values next to the todos, like shared snapshots. A ttl of
+// zero keeps the value forever. TakeValue gets and deletes a value at once,
+// of concurrent takes of a key only one gets it, like for tokens that work
+// once.
+type KV interface {
+	GetValue(key string) (string, error)
+	SetValue(key, value string, ttl time.Duration) error
+	DeleteValue(key string) error
+	TakeValue(key string) (string, error)
+	IncrValue(key string, ttl time.Duration) (int64, error)
+}
+
+const kvPrefix = "todo:kv:"
+
+var memoryKV = NewMemoryKV()
+
+// PersistentKV reports whether the values of the backend outlive the
+// process, KVOf falls back to memory otherwise.
+func PersistentKV(db TodoDB) bool {
+	_, ok := db.(KV)
+	return ok
+}
+
+// KVOf returns the KV of the backend, or a process wide one in memory if the
+// backend doesn't implement KV, like git and memory. Values in memory are
+// lost on restart and not shared between replicas.
+func KVOf(db TodoDB) KV {
+	if kv, ok := db.(KV); ok {
+		return kv
+	}
+
+	return memoryKV
+}
+
+func (redisDB RedisDB) GetValue(key string) (string, error) {
+	client, err := redisDB.primary()
+	if err != nil {
+		return "", err
+	}
+
+	value, err := client.Get(context.Background(), kvPrefix+key).Result()
+	if err == redis.Nil {
+		return "", ErrNotFound
+	}
+
+	return value, err
+}
+
+func (redisDB RedisDB) SetValue(key, value string, ttl time.Duration) error {
+	client, err := redisDB.primary()
+	if err != nil {
+		return err
+	}
+
+	return client.Set(context.Background(), kvPrefix+key, value, ttl).Err()
+}
+
+func (redisDB RedisDB) DeleteValue(key string) error {
+	client, err := redisDB.primary()
+	if err != nil {
+		return err
+	}
+
+	return client.Del(context.Background(), kvPrefix+key).Err()
+}
+
+func (redisDB RedisDB) TakeValue(key string) (string, error) {
+	client, err := redisDB.primary()
+	if err != nil {
+		return "", err
+	}
+
+	return takeRedisValue(client, key)
+}
+
+// takeRedisValue gets and deletes key in MULTI/EXEC, GETDEL needs Redis 6.2.
+func takeRedisValue(client redis.Cmdable, key string) (string, error) {
+	var get *redis.StringCmd
+	_, err := client.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		get = pipe.Get(context.Background(), kvPrefix+key)
+		pipe.Del(context.Background(), kvPrefix+key)
+		return nil
+	})
+	if err == redis.Nil {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return get.Val(), nil
+}
+
+func (redisDB RedisDB) IncrValue(key string, ttl time.Duration) (int64, error) {
+	client, err := redisDB.primary()
+	if err != nil {
+		return 0, err
+	}
+
+	var incr *redis.IntCmd
+	_, err = client.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(context.Background(), kvPrefix+key)
+		if ttl > 0 {
+			pipe.Expire(context.Background(), kvPrefix+key, ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return incr.Val(), nil
+}
+
+// The values of RedisClusterDB have no hash tag, each key is in its own slot.
+func (clusterDB RedisClusterDB) GetValue(key string) (string, error) {
+	value, err := clusterDB.client.Get(context.Background(), kvPrefix+key).Result()
+	if err == redis.Nil {
+		return "", ErrNotFound
+	}
+
+	return value, err
+}
+
+func (clusterDB RedisClusterDB) SetValue(key, value string, ttl time.Duration) error {
+	return clusterDB.client.Set(context.Background(), kvPrefix+key, value, ttl).Err()
+}
+
+func (clusterDB RedisClusterDB) DeleteValue(key string) error {
+	return clusterDB.client.Del(context.Background(), kvPrefix+key).Err()
+}
+
+func (clusterDB RedisClusterDB) TakeValue(key string) (string, error) {
+	return takeRedisValue(clusterDB.client, key)
+}
+
+func (clusterDB RedisClusterDB) IncrValue(key string, ttl time.Duration) (int64, error) {
+	var incr *redis.IntCmd
+	_, err := clusterDB.client.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(context.Background(), kvPrefix+key)
+		if ttl > 0 {
+			pipe.Expire(context.Background(), kvPrefix+key, ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return incr.Val(), nil
+}
+
+// The SQL backends keep the values in their kv_values table.
+func (postgresDB *PostgresDB) GetValue(key string) (string, error) {
+	return postgresDB.kv.GetValue(key)
+}
+
+func (postgresDB *PostgresDB) SetValue(key, value string, ttl time.Duration) error {
+	return postgresDB.kv.SetValue(key, value, ttl)
+}
+
+func (postgresDB *PostgresDB) DeleteValue(key string) error {
+	return postgresDB.kv.DeleteValue(key)
+}
+
+func (postgresDB *PostgresDB) TakeValue(key string) (string, error) {
+	return postgresDB.kv.TakeValue(key)
+}
+
+func (postgresDB *PostgresDB) IncrValue(key string, ttl time.Duration) (int64, error) {
+	return postgresDB.kv.IncrValue(key, ttl)
+}
+
+func (cockroachDB *CockroachDB) GetValue(key string) (string, error) {
+	return cockroachDB.kv.GetValue(key)
+}
+
+func (cockroachDB *CockroachDB) SetValue(key, value string, ttl time.Duration) error {
+	return cockroachDB.kv.SetValue(key, value, ttl)
+}
+
+func (cockroachDB *CockroachDB) DeleteValue(key string) error {
+	return cockroachDB.kv.DeleteValue(key)
+}
+
+func (cockroachDB *CockroachDB) TakeValue(key string) (string, error) {
+	return cockroachDB.kv.TakeValue(key)
+}
+
+func (cockroachDB *CockroachDB) IncrValue(key string, ttl time.Duration) (int64, error) {
+	return cockroachDB.kv.IncrValue(key, ttl)
+}
+
+func (mysqlDB *MySQLDB) GetValue(key string) (string, error) {
+	return mysqlDB.kv.GetValue(key)
+}
+
+func (mysqlDB *MySQLDB) SetValue(key, value string, ttl time.Duration) error {
+	return mysqlDB.kv.SetValue(key, value, ttl)
+}
+
+func (mysqlDB *MySQLDB) DeleteValue(key string) error {
+	return mysqlDB.kv.DeleteValue(key)
+}
+
+func (mysqlDB *MySQLDB) TakeValue(key string) (string, error) {
+	return mysqlDB.kv.TakeValue(key)
+}
+
+func (mysqlDB *MySQLDB) IncrValue(key string, ttl time.Duration) (int64, error) {
+	return mysqlDB.kv.IncrValue(key, ttl)
+}
+
+func (sqliteDB *SQLiteDB) GetValue(key string) (string, error) {
+	return sqliteDB.kv.GetValue(key)
+}
+
+func (sqliteDB *SQLiteDB) SetValue(key, value string, ttl time.Duration) error {
+	return sqliteDB.kv.SetValue(key, value, ttl)
+}
+
+func (sqliteDB *SQLiteDB) DeleteValue(key string) error {
+	return sqliteDB.kv.DeleteValue(key)
+}
+
+func (sqliteDB *SQLiteDB) TakeValue(key string) (string, error) {
+	return sqliteDB.kv.TakeValue(key)
+}
+
+func (sqliteDB *SQLiteDB) IncrValue(key string, ttl time.Duration) (int64, error) {
+	return sqliteDB.kv.IncrValue(key, ttl)
+}
+
+// kvEntry is a value of the backends that keep its expiry next to it and
+// count up by compare and swap.
+type kvEntry struct {
+	Value   string    `json:"value"`
+	Expires time.Time `json:"expires"`
+}
+
+// kvIncrAttempts is how often IncrValue of those backends tries when the
+// value keeps changing under it
+const kvIncrAttempts = 10
+
+func (entry kvEntry) expired() bool {
+	return !entry.Expires.IsZero() && !time.Now().Before(entry.Expires)
+}
+
+// incremented counts entry up like IncrValue, entries that expired start
+// over. The expiry is kept if ttl is zero.
+func (entry kvEntry) incremented(ttl time.Duration) (kvEntry, int64, error) {
+	if entry.expired() {
+		entry = kvEntry{}
+	}
+
+	var count int64
+	if entry.Value != "" {
+		var err error
+		if count, err = strconv.ParseInt(entry.Value, 10, 64); err != nil {
+			return kvEntry{}, 0, err
+		}
+	}
+	count++
+
+	entry.Value = strconv.FormatInt(count, 10)
+	if ttl > 0 {
+		entry.Expires = expiry(ttl)
+	}
+
+	return entry, count, nil
+}
+
+type memoryValue struct {
+	value   string
+	expires time.Time
+}
+
+type MemoryKV struct {
+	mu     sync.Mutex
+	values map[string]memoryValue
+}
+
+func NewMemoryKV() *MemoryKV {
+	return &MemoryKV{values: map[string]memoryValue{}}
+}
+
+// get must be called with mu held. Expired values are removed on access.
+func (kv *MemoryKV) get(key string) (memoryValue, bool) {
+	value, exists := kv.values[key]
+	if exists && !value.expires.IsZero() && time.Now().After(value.expires) {
+		delete(kv.values, key)
+		return memoryValue{}, false
+	}
+
+	return value, exists
+}
+
+func (kv *MemoryKV) GetValue(key string) (string, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	value, exists := kv.get(key)
+	if !exists {
+		return "", ErrNotFound
+	}
+
+	return value.value, nil
+}
+
+func (kv *MemoryKV) SetValue(key, value string, ttl time.Duration) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.values[key] = memoryValue{value: value, expires: expiry(ttl)}
+	return nil
+}
+
+func (kv *MemoryKV) DeleteValue(key string) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	delete(kv.values, key)
+	return nil
+}
+
+func (kv *MemoryKV) TakeValue(key string) (string, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	value, exists := kv.get(key)
+	if !exists {
+		return "", ErrNotFound
+	}
+	delete(kv.values, key)
+
+	return value.value, nil
+}
+
+func (kv *MemoryKV) IncrValue(key string, ttl time.Duration) (int64, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	value, _ := kv.get(key)
+	count, err := strconv.ParseInt(value.value, 10, 64)
+	if value.value != "" && err != nil {
+		return 0, err
+	}
+	count++
+
+	expires := value.expires
+	if ttl > 0 {
+		expires = expiry(ttl)
+	}
+	kv.values[key] = memoryValue{value: strconv.FormatInt(count, 10), expires: expires}
+
+	return count, nil
+}
+
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(ttl)
+}
@@ -0,0 +1,135 @@
+package tododb
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// sqlKV is the KV of the SQL backends, a row of the kv_values table per
+// key. expires_at is in unix nanoseconds, zero never expires. Expired rows
+// are ignored and replaced by the next write of their key.
+type sqlKV struct {
+	db   *sql.DB
+	inTx func(ctx context.Context, fn func(*sql.Tx) error) error
+	// queries are in the dialect of the backend, with the arguments in the
+	// order given for each of them
+	queries sqlKVQueries
+}
+
+type sqlKVQueries struct {
+	// get selects the value of name that expires after now: name, now
+	get string
+	// set inserts or replaces the value: name, value, expires_at
+	set string
+	// create inserts a row of "0" that never expires if name has none: name
+	create string
+	// lock selects value and expires_at, locking the row for the
+	// transaction: name
+	lock string
+	// update replaces value and expires_at: value, expires_at, name
+	update string
+	// delete removes the row: name
+	delete string
+}
+
+func (kv sqlKV) GetValue(key string) (string, error) {
+	var value string
+	err := kv.db.QueryRow(kv.queries.get, key, time.Now().UnixNano()).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+
+	return value, err
+}
+
+func (kv sqlKV) SetValue(key, value string, ttl time.Duration) error {
+	_, err := kv.db.Exec(kv.queries.set, key, value, sqlExpiry(ttl))
+	return err
+}
+
+func (kv sqlKV) DeleteValue(key string) error {
+	_, err := kv.db.Exec(kv.queries.delete, key)
+	return err
+}
+
+// TakeValue reads the row locked and deletes it in the same transaction,
+// only the take that deleted the row gets the value.
+func (kv sqlKV) TakeValue(key string) (string, error) {
+	var value string
+	err := kv.inTx(context.Background(), func(tx *sql.Tx) error {
+		var expires int64
+		err := tx.QueryRow(kv.queries.lock, key).Scan(&value, &expires)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(kv.queries.delete, key)
+		if err != nil {
+			return err
+		}
+		if deleted, err := result.RowsAffected(); err != nil || deleted == 0 {
+			if err == nil {
+				err = ErrNotFound
+			}
+			return err
+		}
+		if expires != 0 && expires <= time.Now().UnixNano() {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
+
+// IncrValue creates the row if it's missing and counts up in the same
+// transaction, with the row locked, so concurrent increments all see their
+// own count.
+func (kv sqlKV) IncrValue(key string, ttl time.Duration) (int64, error) {
+	var count int64
+	err := kv.inTx(context.Background(), func(tx *sql.Tx) error {
+		if _, err := tx.Exec(kv.queries.create, key); err != nil {
+			return err
+		}
+
+		var value string
+		var expires int64
+		if err := tx.QueryRow(kv.queries.lock, key).Scan(&value, &expires); err != nil {
+			return err
+		}
+		if expires != 0 && expires <= time.Now().UnixNano() {
+			value, expires = "0", 0
+		}
+
+		var err error
+		count, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		count++
+		if ttl > 0 {
+			expires = sqlExpiry(ttl)
+		}
+
+		_, err = tx.Exec(kv.queries.update, strconv.FormatInt(count, 10), expires, key)
+		return err
+	})
+
+	return count, err
+}
+
+func sqlExpiry(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+
+	return time.Now().Add(ttl).UnixNano()
+}
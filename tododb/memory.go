@@ -0,0 +1,408 @@
+package tododb
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/buildinfo"
+)
+
+const defaultMemorySnapshotInterval = 30
+
+// MemoryDB keeps the todos in memory. With a snapshot file they are written
+// to disk every snapshotInterval seconds if they changed, and read back at
+// startup, so a restart loses at most the last interval.
+type MemoryDB struct {
+	mu       sync.RWMutex
+	todos    []Todo
+	changed  bool
+	snapshot string
+	// snapshotErr is the error of the last snapshot, reported by the health
+	// check until one succeeds
+	snapshotErr error
+	metrics     *Metrics
+}
+
+var _ TodoDB = &MemoryDB{}
+
+func NewMemoryDB(config map[string]string) (*MemoryDB, error) {
+	memoryDB := &MemoryDB{
+		todos:    []Todo{},
+		snapshot: config["snapshot"],
+		metrics:  NewMetrics(),
+	}
+	if memoryDB.snapshot == "" {
+		return memoryDB, nil
+	}
+
+	content, err := ioutil.ReadFile(memoryDB.snapshot)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(content, &memoryDB.todos); err != nil {
+			return nil, err
+		}
+		logger.Infof("Loaded %d todos from %s", len(memoryDB.todos), memoryDB.snapshot)
+	}
+
+	interval := time.Duration(intConfig(config, "snapshotInterval", defaultMemorySnapshotInterval)) * time.Second
+	go func() {
+		for range time.Tick(interval) {
+			memoryDB.writeSnapshot()
+		}
+	}()
+
+	return memoryDB, nil
+}
+
+// writeSnapshot replaces the snapshot file if the todos changed since the
+// last one.
+func (memoryDB *MemoryDB) writeSnapshot() {
+	memoryDB.mu.Lock()
+	if !memoryDB.changed {
+		memoryDB.mu.Unlock()
+		return
+	}
+	content, err := json.MarshalIndent(memoryDB.todos, "", "  ")
+	memoryDB.changed = false
+	memoryDB.mu.Unlock()
+
+	if err == nil {
+		tmp := filepath.Join(filepath.Dir(memoryDB.snapshot), "."+filepath.Base(memoryDB.snapshot)+".tmp")
+		err = ioutil.WriteFile(tmp, append(content, '\n'), 0644)
+		if err == nil {
+			err = os.Rename(tmp, memoryDB.snapshot)
+		}
+	}
+
+	memoryDB.mu.Lock()
+	defer memoryDB.mu.Unlock()
+	memoryDB.snapshotErr = err
+	if err != nil {
+		// try again with the next tick
+		memoryDB.changed = true
+		logger.Errorf("snapshot %s: %v", memoryDB.snapshot, err)
+	}
+}
+
+func (memoryDB *MemoryDB) GetAllTodos(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	memoryDB.mu.RLock()
+	defer memoryDB.mu.RUnlock()
+
+	return filterTodos(append([]Todo{}, memoryDB.todos...), filters), nil
+}
+
+func (memoryDB *MemoryDB) GetTodos(ctx context.Context, offset, limit int, filters ...TodoFilter) ([]Todo, error) {
+	if err := checkPage(offset, limit); err != nil {
+		return nil, err
+	}
+
+	todos, _ := memoryDB.GetAllTodos(ctx, filters...)
+	return pageOf(todos, offset, limit), nil
+}
+
+func (memoryDB *MemoryDB) CountTodos(ctx context.Context, filters ...TodoFilter) (int, error) {
+	if len(filters) == 0 {
+		memoryDB.mu.RLock()
+		defer memoryDB.mu.RUnlock()
+		return len(memoryDB.todos), nil
+	}
+
+	todos, _ := memoryDB.GetAllTodos(ctx, filters...)
+	return len(todos), nil
+}
+
+func (memoryDB *MemoryDB) GetOverdueTodos(ctx context.Context) ([]Todo, error) {
+	return todosByDue(ctx, memoryDB.GetAllTodos, overdueFilter())
+}
+
+func (memoryDB *MemoryDB) GetTodosDueBefore(ctx context.Context, t time.Time) ([]Todo, error) {
+	return todosByDue(ctx, memoryDB.GetAllTodos, TodoFilter{DueBefore: t})
+}
+
+func (memoryDB *MemoryDB) GetTodosByPriority(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	return todosByPriority(ctx, memoryDB.GetAllTodos, filters)
+}
+
+func (memoryDB *MemoryDB) GetTodosByTag(ctx context.Context, tag string) ([]Todo, error) {
+	return todosByTag(ctx, memoryDB.GetAllTodos, tag)
+}
+
+func (memoryDB *MemoryDB) ListTags(ctx context.Context) ([]Tag, error) {
+	return CountTags(ctx, memoryDB.ForEachTodo)
+}
+
+func (memoryDB *MemoryDB) SearchTodos(ctx context.Context, query string) ([]Todo, error) {
+	return searchTodos(ctx, memoryDB.GetAllTodos, query)
+}
+
+// ForEachTodo calls fn on a copy of the todos, fn may change them.
+func (memoryDB *MemoryDB) ForEachTodo(ctx context.Context, fn func(Todo) error) error {
+	todos, _ := memoryDB.GetAllTodos(ctx)
+	for _, todo := range todos {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(todo); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (memoryDB *MemoryDB) SaveTodo(ctx context.Context, todo Todo) error {
+	return memoryDB.SaveTodos(ctx, []Todo{todo})
+}
+
+func (memoryDB *MemoryDB) SaveTodos(ctx context.Context, todos []Todo) error {
+	memoryDB.mu.Lock()
+	defer memoryDB.mu.Unlock()
+
+	for _, todo := range todos {
+		memoryDB.todos = append(memoryDB.todos, todo.withDefaults())
+	}
+	memoryDB.changed = true
+	return nil
+}
+
+func (memoryDB *MemoryDB) DeleteTodo(ctx context.Context, id string) error {
+	memoryDB.mu.Lock()
+	defer memoryDB.mu.Unlock()
+
+	for i, existing := range memoryDB.todos {
+		if existing.ID == id {
+			memoryDB.todos = append(memoryDB.todos[:i:i], memoryDB.todos[i+1:]...)
+			memoryDB.changed = true
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (memoryDB *MemoryDB) DeleteTodos(ctx context.Context, ids []string) error {
+	memoryDB.mu.Lock()
+	defer memoryDB.mu.Unlock()
+
+	wanted := idSet(ids)
+	kept := make([]Todo, 0, len(memoryDB.todos))
+	for _, todo := range memoryDB.todos {
+		if wanted[todo.ID] {
+			delete(wanted, todo.ID)
+			continue
+		}
+		kept = append(kept, todo)
+	}
+	if len(kept) < len(memoryDB.todos) {
+		memoryDB.todos = kept
+		memoryDB.changed = true
+	}
+	return nil
+}
+
+func (memoryDB *MemoryDB) UpdateTodo(ctx context.Context, id string, update func(*Todo)) error {
+	return memoryDB.updateTodo(id, update)
+}
+
+func (memoryDB *MemoryDB) UpdateTodos(ctx context.Context, ids []string, update func(*Todo)) error {
+	memoryDB.mu.Lock()
+	defer memoryDB.mu.Unlock()
+
+	wanted := idSet(ids)
+	for i, existing := range memoryDB.todos {
+		if wanted[existing.ID] {
+			delete(wanted, existing.ID)
+			memoryDB.todos[i] = existing.edited(update)
+			memoryDB.changed = true
+		}
+	}
+	return nil
+}
+
+func (memoryDB *MemoryDB) CompleteTodo(ctx context.Context, id string) error {
+	return memoryDB.updateTodo(id, func(todo *Todo) {
+		todo.Done = true
+	})
+}
+
+func (memoryDB *MemoryDB) CompleteTodos(ctx context.Context, ids []string) error {
+	memoryDB.mu.Lock()
+	defer memoryDB.mu.Unlock()
+
+	wanted := idSet(ids)
+	for i, existing := range memoryDB.todos {
+		if wanted[existing.ID] {
+			delete(wanted, existing.ID)
+			memoryDB.todos[i] = existing.edited(func(todo *Todo) {
+				todo.Done = true
+			})
+			memoryDB.changed = true
+		}
+	}
+	return nil
+}
+
+func (memoryDB *MemoryDB) ReopenTodo(ctx context.Context, id string) error {
+	return memoryDB.updateTodo(id, func(todo *Todo) {
+		todo.Done = false
+	})
+}
+
+func (memoryDB *MemoryDB) SetDescription(ctx context.Context, id string, description string) error {
+	return memoryDB.updateTodo(id, func(todo *Todo) {
+		todo.Description = description
+	})
+}
+
+func (memoryDB *MemoryDB) SetDue(ctx context.Context, id string, due *time.Time) error {
+	return memoryDB.updateTodo(id, func(todo *Todo) {
+		todo.Due = due
+	})
+}
+
+func (memoryDB *MemoryDB) SetPriority(ctx context.Context, id string, priority int) error {
+	return memoryDB.updateTodo(id, func(todo *Todo) {
+		todo.Priority = priority
+	})
+}
+
+func (memoryDB *MemoryDB) SetTags(ctx context.Context, id string, tags []string) error {
+	return memoryDB.updateTodo(id, func(todo *Todo) {
+		todo.Tags = tags
+	})
+}
+
+func (memoryDB *MemoryDB) SetRecurrence(ctx context.Context, id string, recurrence string) error {
+	return memoryDB.updateTodo(id, func(todo *Todo) {
+		todo.Recurrence = recurrence
+	})
+}
+
+func (memoryDB *MemoryDB) AddSubTask(ctx context.Context, id string, title string) (SubTask, error) {
+	var subTask SubTask
+	err := memoryDB.updateTodo(id, func(todo *Todo) {
+		subTask = todo.addSubTask(title)
+	})
+
+	return subTask, err
+}
+
+func (memoryDB *MemoryDB) CompleteSubTask(ctx context.Context, id string, subTaskID string) error {
+	found := false
+	err := memoryDB.updateTodo(id, func(todo *Todo) {
+		found = todo.completeSubTask(subTaskID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (memoryDB *MemoryDB) GetSubTasks(ctx context.Context, id string) ([]SubTask, error) {
+	return subTasksOf(ctx, memoryDB.ForEachTodo, id)
+}
+
+func (memoryDB *MemoryDB) AddAttachment(ctx context.Context, id string, attachment Attachment) error {
+	return memoryDB.updateTodo(id, func(todo *Todo) {
+		todo.addAttachment(attachment)
+	})
+}
+
+func (memoryDB *MemoryDB) RemoveAttachment(ctx context.Context, id string, attachmentID string) error {
+	found := false
+	err := memoryDB.updateTodo(id, func(todo *Todo) {
+		found = todo.removeAttachment(attachmentID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (memoryDB *MemoryDB) MoveTodo(ctx context.Context, id string, position int) error {
+	memoryDB.mu.Lock()
+	defer memoryDB.mu.Unlock()
+
+	order, err := moveOrder(memoryDB.todos, id, position)
+	if err != nil {
+		return err
+	}
+	memoryDB.todos = reordered(memoryDB.todos, order)
+	memoryDB.changed = true
+	return nil
+}
+
+func (memoryDB *MemoryDB) updateTodo(id string, fn func(*Todo)) error {
+	memoryDB.mu.Lock()
+	defer memoryDB.mu.Unlock()
+
+	for i, existing := range memoryDB.todos {
+		if existing.ID == id {
+			memoryDB.todos[i] = existing.edited(fn)
+			memoryDB.changed = true
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
+
+func (memoryDB *MemoryDB) ReplaceAllTodos(ctx context.Context, todos []Todo) error {
+	memoryDB.mu.Lock()
+	defer memoryDB.mu.Unlock()
+
+	memoryDB.todos = make([]Todo, len(todos))
+	for i, todo := range todos {
+		memoryDB.todos[i] = todo.withDefaults()
+	}
+	memoryDB.changed = true
+	return nil
+}
+
+func (memoryDB *MemoryDB) GetUsage(ctx context.Context) (Usage, error) {
+	memoryDB.mu.RLock()
+	usage := Usage{Todos: int64(len(memoryDB.todos))}
+	for _, todo := range memoryDB.todos {
+		usage.RawBytes += int64(len(marshalTodo(todo)))
+	}
+	memoryDB.mu.RUnlock()
+	usage.StoredBytes = usage.RawBytes
+
+	if memoryDB.snapshot != "" {
+		if info, err := os.Stat(memoryDB.snapshot); err == nil {
+			usage.StoredBytes = info.Size()
+		}
+	}
+
+	hostname := getHostname()
+	memoryDB.metrics.storageRawBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.RawBytes))
+	memoryDB.metrics.storageStoredBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.StoredBytes))
+
+	return usage, nil
+}
+
+func (memoryDB *MemoryDB) GetHealthStatus(ctx context.Context) map[string]string {
+	result := map[string]string{"self": okString}
+	if memoryDB.snapshot == "" {
+		return result
+	}
+
+	memoryDB.mu.RLock()
+	defer memoryDB.mu.RUnlock()
+
+	result["snapshot"] = okString
+	if memoryDB.snapshotErr != nil {
+		result["snapshot"] = memoryDB.snapshotErr.Error()
+	}
+
+	return result
+}
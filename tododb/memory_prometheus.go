@@ -0,0 +1,19 @@
+package tododb
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func (memoryDB *MemoryDB) RegisterMetrics(registerer prometheus.Registerer) error {
+	m := memoryDB.metrics
+	err := register(registerer,
+		m.storageRawBytes,
+		m.storageStoredBytes,
+	)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Registered Memory Metrics")
+	return nil
+}
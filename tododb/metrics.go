@@ -0,0 +1,306 @@
+package tododb
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are the collectors of the built-in backends. Every backend creates
+// its own, so several of them can live in one process, e.g. in tests, as long
+// as each registers with its own registry.
+type Metrics struct {
+	redisMastersTotal        *prometheus.GaugeVec
+	redisMastersHealthyTotal *prometheus.GaugeVec
+	redisSlavesTotal         *prometheus.GaugeVec
+	redisSlavesHealthyTotal  *prometheus.GaugeVec
+
+	// Selected fields of INFO, exported so demo setups don't need a
+	// redis_exporter
+	redisInfo              map[string]*prometheus.GaugeVec
+	redisTodoListLength    *prometheus.GaugeVec
+	redisTodoKeyBytes      *prometheus.GaugeVec
+	redisSlowCommandsTotal *prometheus.CounterVec
+
+	redisRetryBudgetUsedRatio *prometheus.GaugeVec
+	redisRetriesTotal         *prometheus.CounterVec
+
+	redisClusterNodesTotal        *prometheus.GaugeVec
+	redisClusterNodesHealthyTotal *prometheus.GaugeVec
+	redisClusterStateOK           *prometheus.GaugeVec
+
+	gitCommitsTotal      *prometheus.CounterVec
+	gitPushFailuresTotal *prometheus.CounterVec
+
+	postgresUp          *prometheus.GaugeVec
+	postgresConnections *prometheus.GaugeVec
+
+	mysqlUp          *prometheus.GaugeVec
+	mysqlConnections *prometheus.GaugeVec
+
+	mongoNodesTotal        *prometheus.GaugeVec
+	mongoNodesHealthyTotal *prometheus.GaugeVec
+
+	etcdUp               *prometheus.GaugeVec
+	etcdWatchEventsTotal *prometheus.CounterVec
+
+	dynamoUp *prometheus.GaugeVec
+
+	cassandraNodesTotal        *prometheus.GaugeVec
+	cassandraNodesHealthyTotal *prometheus.GaugeVec
+
+	cockroachNodesTotal     *prometheus.GaugeVec
+	cockroachNodesLiveTotal *prometheus.GaugeVec
+	cockroachRetriesTotal   *prometheus.CounterVec
+
+	storageRawBytes    *prometheus.GaugeVec
+	storageStoredBytes *prometheus.GaugeVec
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		redisMastersTotal: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_redis_masters_total",
+				Help: "Total count of available redis masters",
+			},
+			[]string{"instance", "version"},
+		),
+		redisMastersHealthyTotal: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_redis_masters_healthy_total",
+				Help: "Total count of healthy redis masters",
+			},
+			[]string{"instance", "version"},
+		),
+		redisSlavesTotal: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_redis_slaves_total",
+				Help: "Total count of available redis slaves",
+			},
+			[]string{"instance", "version"},
+		),
+		redisSlavesHealthyTotal: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_redis_slaves_healthy_total",
+				Help: "Total count of healthy redis slaves",
+			},
+			[]string{"instance", "version"},
+		),
+		redisInfo: map[string]*prometheus.GaugeVec{
+			"used_memory": prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name: "todoapp_redis_used_memory_bytes",
+					Help: "Memory allocated by redis",
+				},
+				[]string{"instance", "version", "role"},
+			),
+			"connected_clients": prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name: "todoapp_redis_connected_clients",
+					Help: "Client connections to redis",
+				},
+				[]string{"instance", "version", "role"},
+			),
+			"evicted_keys": prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name: "todoapp_redis_evicted_keys",
+					Help: "Keys evicted by redis because of the maxmemory limit",
+				},
+				[]string{"instance", "version", "role"},
+			),
+		},
+		redisTodoListLength: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_redis_todo_list_length",
+				Help: "Length of the todo list",
+			},
+			[]string{"instance", "version", "role"},
+		),
+		redisTodoKeyBytes: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_redis_todo_key_memory_bytes",
+				Help: "Memory used by the todo list as reported by MEMORY USAGE",
+			},
+			[]string{"instance", "version", "role"},
+		),
+		redisSlowCommandsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "todoapp_redis_slow_commands_total",
+				Help: "Total count of commands found in the redis slowlog",
+			},
+			[]string{"instance", "version", "role"},
+		),
+		redisRetryBudgetUsedRatio: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_redis_retry_budget_used_ratio",
+				Help: "Share of the retry budget of the current window used up, 1 means failed commands aren't retried anymore",
+			},
+			[]string{"instance", "version"},
+		),
+		redisRetriesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "todoapp_redis_retries_total",
+				Help: "Retries of failed redis commands, allowed or denied by the retry budget",
+			},
+			[]string{"instance", "version", "result"},
+		),
+		gitCommitsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "todoapp_git_commits_total",
+				Help: "Total count of commits written to the todo repository",
+			},
+			[]string{"instance", "version"},
+		),
+		gitPushFailuresTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "todoapp_git_push_failures_total",
+				Help: "Total count of failed pushes to the remote todo repository",
+			},
+			[]string{"instance", "version"},
+		),
+		postgresUp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_postgres_up",
+				Help: "Whether the last health check reached postgres",
+			},
+			[]string{"instance", "version"},
+		),
+		postgresConnections: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_postgres_connections",
+				Help: "Connections of the pool to postgres by state",
+			},
+			[]string{"instance", "version", "state"},
+		),
+		mysqlUp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_mysql_up",
+				Help: "Whether the last health check reached a writable mysql",
+			},
+			[]string{"instance", "version"},
+		),
+		mysqlConnections: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_mysql_connections",
+				Help: "Connections of the pool to mysql by state",
+			},
+			[]string{"instance", "version", "state"},
+		),
+		redisClusterNodesTotal: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_redis_cluster_nodes_total",
+				Help: "Total count of redis cluster nodes known by CLUSTER NODES",
+			},
+			[]string{"instance", "version"},
+		),
+		redisClusterNodesHealthyTotal: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_redis_cluster_nodes_healthy_total",
+				Help: "Total count of redis cluster nodes answering a PING by role",
+			},
+			[]string{"instance", "version", "role"},
+		),
+		redisClusterStateOK: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_redis_cluster_state_ok",
+				Help: "Whether CLUSTER INFO reported cluster_state ok at the last health check",
+			},
+			[]string{"instance", "version"},
+		),
+		mongoNodesTotal: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_mongo_nodes_total",
+				Help: "Total count of known mongo replica set members",
+			},
+			[]string{"instance", "version"},
+		),
+		mongoNodesHealthyTotal: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_mongo_nodes_healthy_total",
+				Help: "Total count of healthy mongo replica set members by role",
+			},
+			[]string{"instance", "version", "role"},
+		),
+		etcdUp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_etcd_up",
+				Help: "Whether the last health check got a lease from etcd",
+			},
+			[]string{"instance", "version"},
+		),
+		etcdWatchEventsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "todoapp_etcd_watch_events_total",
+				Help: "Changes of the todos by all instances seen by the watch, by type",
+			},
+			[]string{"instance", "version", "type"},
+		),
+		dynamoUp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_dynamodb_up",
+				Help: "Whether the last health check found the DynamoDB table active",
+			},
+			[]string{"instance", "version"},
+		),
+		cassandraNodesTotal: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_cassandra_nodes_total",
+				Help: "Total count of cassandra nodes known to the driver by datacenter",
+			},
+			[]string{"instance", "version", "dc"},
+		),
+		cassandraNodesHealthyTotal: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_cassandra_nodes_healthy_total",
+				Help: "Total count of cassandra nodes the driver considers up by datacenter",
+			},
+			[]string{"instance", "version", "dc"},
+		),
+		cockroachNodesTotal: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_cockroach_nodes_total",
+				Help: "Total count of cockroach nodes known by gossip",
+			},
+			[]string{"instance", "version"},
+		),
+		cockroachNodesLiveTotal: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_cockroach_nodes_live_total",
+				Help: "Total count of live cockroach nodes",
+			},
+			[]string{"instance", "version"},
+		),
+		cockroachRetriesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "todoapp_cockroach_transaction_retries_total",
+				Help: "Transactions retried after a serialization failure",
+			},
+			[]string{"instance", "version"},
+		),
+		storageRawBytes: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_storage_raw_bytes",
+				Help: "Size of all todos before compression",
+			},
+			[]string{"instance", "version"},
+		),
+		storageStoredBytes: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "todoapp_storage_stored_bytes",
+				Help: "Size of all todos as stored in the database",
+			},
+			[]string{"instance", "version"},
+		),
+	}
+}
+
+// register stops at the first collector that can't be registered, e.g.
+// because the registry already has one of the same name.
+func register(registerer prometheus.Registerer, collectors ...prometheus.Collector) error {
+	for _, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,785 @@
+package tododb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/johscheuer/todo-app-web/features"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	defaultMongoURL            = "mongodb://localhost:27017/todo-app"
+	defaultMongoCollection     = "todos"
+	defaultMongoReadPreference = "primary"
+	defaultMongoTimeoutSeconds = 5
+)
+
+var mongoReadPreferences = map[string]mgo.Mode{
+	"primary":            mgo.Primary,
+	"primaryPreferred":   mgo.PrimaryPreferred,
+	"secondary":          mgo.Secondary,
+	"secondaryPreferred": mgo.SecondaryPreferred,
+	"nearest":            mgo.Nearest,
+}
+
+// mongoTodo orders the documents by ObjectId, the id of the Todo is kept
+// next to it. Documents of older versions only have a title, their ObjectId
+// is the id of the todo.
+type mongoTodo struct {
+	ObjectID    bson.ObjectId `bson:"_id"`
+	ID          string        `bson:"id,omitempty"`
+	Title       string        `bson:"title"`
+	Description string        `bson:"description,omitempty"`
+	CreatedAt   time.Time     `bson:"createdAt,omitempty"`
+	UpdatedAt   time.Time     `bson:"updatedAt,omitempty"`
+	Done        bool          `bson:"done,omitempty"`
+	Due         *time.Time    `bson:"due,omitempty"`
+	Priority    int           `bson:"priority,omitempty"`
+	Tags        []string      `bson:"tags,omitempty"`
+	SubTasks    []SubTask     `bson:"subTasks,omitempty"`
+	Recurrence  string        `bson:"recurrence,omitempty"`
+	Attachments []Attachment  `bson:"attachments,omitempty"`
+	Estimate    int           `bson:"estimate,omitempty"`
+	Remote      []RemoteLink  `bson:"remote,omitempty"`
+	Owner       string        `bson:"owner,omitempty"`
+}
+
+func newMongoTodo(todo Todo) mongoTodo {
+	todo = todo.withDefaults()
+	return mongoTodo{
+		ObjectID:    bson.NewObjectId(),
+		ID:          todo.ID,
+		Title:       todo.Title,
+		Description: todo.Description,
+		CreatedAt:   todo.CreatedAt,
+		UpdatedAt:   todo.UpdatedAt,
+		Done:        todo.Done,
+		Due:         todo.Due,
+		Priority:    todo.Priority,
+		Tags:        todo.Tags,
+		SubTasks:    todo.SubTasks,
+		Recurrence:  todo.Recurrence,
+		Attachments: todo.Attachments,
+		Estimate:    todo.Estimate,
+		Remote:      todo.Remote,
+		Owner:       todo.Owner,
+	}
+}
+
+func (doc mongoTodo) todo() Todo {
+	id := doc.ID
+	if id == "" {
+		id = doc.ObjectID.Hex()
+	}
+
+	return Todo{
+		ID:          id,
+		Title:       doc.Title,
+		Description: doc.Description,
+		CreatedAt:   doc.CreatedAt,
+		UpdatedAt:   doc.UpdatedAt,
+		Done:        doc.Done,
+		Due:         doc.Due,
+		Priority:    doc.Priority,
+		Tags:        doc.Tags,
+		SubTasks:    doc.SubTasks,
+		Recurrence:  doc.Recurrence,
+		Attachments: doc.Attachments,
+		Estimate:    doc.Estimate,
+		Remote:      doc.Remote,
+		Owner:       doc.Owner,
+	}
+}
+
+// MongoDB keeps every todo as a document, ordered by their ObjectId. Writes
+// go to the primary of the replica set, reads follow the configured read
+// preference like the Redis backend reads from the slave.
+type MongoDB struct {
+	// writes uses mgo.Primary, reads the configured read preference. Both
+	// are copied for every call, which takes a connection from the pool.
+	writes     *mgo.Session
+	reads      *mgo.Session
+	dialInfo   *mgo.DialInfo
+	database   string
+	collection string
+	metrics    *Metrics
+}
+
+var _ TodoDB = &MongoDB{}
+
+func NewMongoDB(config map[string]string) (*MongoDB, error) {
+	url := defaultMongoURL
+	if value, exists := config["url"]; exists {
+		url = value
+	}
+
+	dialInfo, err := mgo.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	dialInfo.Timeout = time.Duration(intConfig(config, "timeout", defaultMongoTimeoutSeconds)) * time.Second
+
+	readPreference := defaultMongoReadPreference
+	if value, exists := config["readPreference"]; exists {
+		readPreference = value
+	}
+	mode, exists := mongoReadPreferences[readPreference]
+	if !exists {
+		names := []string{}
+		for name := range mongoReadPreferences {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown readPreference %q, use one of %s", readPreference, strings.Join(names, ", "))
+	}
+
+	writes, err := mgo.DialWithInfo(dialInfo)
+	if err != nil {
+		return nil, fmt.Errorf("mongo %s: %v", strings.Join(dialInfo.Addrs, ","), err)
+	}
+	writes.SetMode(mgo.Primary, true)
+
+	reads := writes.Copy()
+	reads.SetMode(mode, true)
+
+	database := dialInfo.Database
+	if value, exists := config["database"]; exists {
+		database = value
+	}
+	if database == "" {
+		database = "todo-app"
+	}
+
+	collection := defaultMongoCollection
+	if value, exists := config["collection"]; exists {
+		collection = value
+	}
+
+	mongoDB := &MongoDB{
+		writes:     writes,
+		reads:      reads,
+		dialInfo:   dialInfo,
+		database:   database,
+		collection: collection,
+		metrics:    NewMetrics(),
+	}
+	if err := mongoDB.ensureKVIndex(); err != nil {
+		writes.Close()
+		reads.Close()
+		return nil, fmt.Errorf("mongo %s: %v", mongoKVCollection, err)
+	}
+
+	return mongoDB, nil
+}
+
+// with runs fn on a copy of session. mgo has no contexts, the deadline of
+// ctx becomes the socket timeout of the copy instead.
+func (mongoDB *MongoDB) with(ctx context.Context, session *mgo.Session, fn func(*mgo.Collection) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s := session.Copy()
+	defer s.Close()
+	s.SetSocketTimeout(contextTimeout(ctx, mongoDB.dialInfo.Timeout))
+
+	return fn(s.DB(mongoDB.database).C(mongoDB.collection))
+}
+
+// contextTimeout returns the time left until the deadline of ctx if that is
+// shorter than timeout.
+func contextTimeout(ctx context.Context, timeout time.Duration) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		return time.Until(deadline)
+	}
+
+	return timeout
+}
+
+func (mongoDB *MongoDB) GetAllTodos(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	todos := []Todo{}
+	err := mongoDB.ForEachTodo(ctx, func(todo Todo) error {
+		if matchesAll(filters, todo) {
+			todos = append(todos, todo)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return todos, nil
+}
+
+// GetTodos lets the server skip to the page, unless filters are given.
+func (mongoDB *MongoDB) GetTodos(ctx context.Context, offset, limit int, filters ...TodoFilter) ([]Todo, error) {
+	if len(filters) > 0 || features.Enabled(features.PerfNPlusOne) {
+		return pageTodos(ctx, mongoDB.ForEachTodo, offset, limit, filters)
+	}
+	if err := checkPage(offset, limit); err != nil {
+		return nil, err
+	}
+
+	var docs []mongoTodo
+	err := mongoDB.with(ctx, mongoDB.reads, func(c *mgo.Collection) error {
+		return c.Find(nil).Sort("_id").Skip(offset).Limit(limit).All(&docs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	todos := make([]Todo, len(docs))
+	for i, doc := range docs {
+		todos[i] = doc.todo()
+	}
+
+	return todos, nil
+}
+
+func (mongoDB *MongoDB) CountTodos(ctx context.Context, filters ...TodoFilter) (int, error) {
+	if len(filters) > 0 {
+		return countTodos(ctx, mongoDB.ForEachTodo, filters)
+	}
+
+	var count int
+	err := mongoDB.with(ctx, mongoDB.reads, func(c *mgo.Collection) (err error) {
+		count, err = c.Count()
+		return err
+	})
+
+	return count, err
+}
+
+func (mongoDB *MongoDB) GetOverdueTodos(ctx context.Context) ([]Todo, error) {
+	return mongoDB.findByDue(ctx, bson.M{"due": bson.M{"$lt": time.Now()}, "done": bson.M{"$ne": true}})
+}
+
+func (mongoDB *MongoDB) GetTodosDueBefore(ctx context.Context, t time.Time) ([]Todo, error) {
+	return mongoDB.findByDue(ctx, bson.M{"due": bson.M{"$lt": t}})
+}
+
+// GetTodosByPriority sorts by the ObjectIds within a priority, which are in
+// the order the todos were added.
+func (mongoDB *MongoDB) GetTodosByPriority(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	var docs []mongoTodo
+	err := mongoDB.with(ctx, mongoDB.reads, func(c *mgo.Collection) error {
+		return c.Find(nil).Sort("-priority", "_id").All(&docs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	todos := make([]Todo, len(docs))
+	for i, doc := range docs {
+		todos[i] = doc.todo()
+	}
+
+	return filterTodos(todos, filters), nil
+}
+
+func (mongoDB *MongoDB) GetTodosByTag(ctx context.Context, tag string) ([]Todo, error) {
+	var docs []mongoTodo
+	err := mongoDB.with(ctx, mongoDB.reads, func(c *mgo.Collection) error {
+		return c.Find(bson.M{"tags": NormalizeTag(tag)}).Sort("_id").All(&docs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	todos := make([]Todo, len(docs))
+	for i, doc := range docs {
+		todos[i] = doc.todo()
+	}
+
+	return todos, nil
+}
+
+// ListTags counts the todos of every tag with an aggregation.
+func (mongoDB *MongoDB) ListTags(ctx context.Context) ([]Tag, error) {
+	var results []struct {
+		Name  string `bson:"_id"`
+		Todos int    `bson:"todos"`
+	}
+	err := mongoDB.with(ctx, mongoDB.reads, func(c *mgo.Collection) error {
+		return c.Pipe([]bson.M{
+			{"$unwind": "$tags"},
+			{"$group": bson.M{"_id": "$tags", "todos": bson.M{"$sum": 1}}},
+			{"$sort": bson.M{"_id": 1}},
+		}).All(&results)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]Tag, len(results))
+	for i, result := range results {
+		tags[i] = Tag{Name: result.Name, Todos: result.Todos}
+	}
+
+	return tags, nil
+}
+
+func (mongoDB *MongoDB) SearchTodos(ctx context.Context, query string) ([]Todo, error) {
+	return searchTodos(ctx, mongoDB.GetAllTodos, query)
+}
+
+// findByDue returns the todos matching query, the soonest due first. Due
+// dates are stored as BSON dates, which sort like the times.
+func (mongoDB *MongoDB) findByDue(ctx context.Context, query bson.M) ([]Todo, error) {
+	var docs []mongoTodo
+	err := mongoDB.with(ctx, mongoDB.reads, func(c *mgo.Collection) error {
+		return c.Find(query).Sort("due", "_id").All(&docs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	todos := make([]Todo, len(docs))
+	for i, doc := range docs {
+		todos[i] = doc.todo()
+	}
+
+	return todos, nil
+}
+
+func (mongoDB *MongoDB) ForEachTodo(ctx context.Context, fn func(Todo) error) error {
+	if features.Enabled(features.PerfNPlusOne) {
+		return mongoDB.forEachTodoOneByOne(ctx, fn)
+	}
+
+	return mongoDB.with(ctx, mongoDB.reads, func(c *mgo.Collection) error {
+		iter := c.Find(nil).Sort("_id").Iter()
+		var todo mongoTodo
+		for iter.Next(&todo) {
+			if err := ctx.Err(); err != nil {
+				iter.Close()
+				return err
+			}
+			if err := fn(todo.todo()); err != nil {
+				iter.Close()
+				return err
+			}
+		}
+
+		return iter.Close()
+	})
+}
+
+// forEachTodoOneByOne is the deliberately slow variant of ForEachTodo, with
+// one query per todo.
+func (mongoDB *MongoDB) forEachTodoOneByOne(ctx context.Context, fn func(Todo) error) error {
+	return mongoDB.with(ctx, mongoDB.reads, func(c *mgo.Collection) error {
+		var ids []struct {
+			ObjectID bson.ObjectId `bson:"_id"`
+		}
+		if err := c.Find(nil).Select(bson.M{"_id": 1}).Sort("_id").All(&ids); err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var todo mongoTodo
+			err := c.FindId(id.ObjectID).One(&todo)
+			if err == mgo.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := fn(todo.todo()); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (mongoDB *MongoDB) SaveTodo(ctx context.Context, todo Todo) error {
+	return mongoDB.SaveTodos(ctx, []Todo{todo})
+}
+
+func (mongoDB *MongoDB) SaveTodos(ctx context.Context, todos []Todo) error {
+	if len(todos) == 0 {
+		return nil
+	}
+
+	return mongoDB.with(ctx, mongoDB.writes, func(c *mgo.Collection) error {
+		return insertMongoTodos(c, todos)
+	})
+}
+
+func insertMongoTodos(c *mgo.Collection, todos []Todo) error {
+	docs := make([]interface{}, len(todos))
+	for i, todo := range todos {
+		docs[i] = newMongoTodo(todo)
+	}
+
+	return c.Insert(docs...)
+}
+
+// mongoIDQuery matches the todo with id, documents of older versions have
+// their ObjectId as id.
+func mongoIDQuery(id string) bson.M {
+	query := bson.M{"id": id}
+	if bson.IsObjectIdHex(id) {
+		query = bson.M{"$or": []bson.M{query, {"_id": bson.ObjectIdHex(id), "id": bson.M{"$exists": false}}}}
+	}
+
+	return query
+}
+
+func (mongoDB *MongoDB) DeleteTodo(ctx context.Context, id string) error {
+	return mongoDB.with(ctx, mongoDB.writes, func(c *mgo.Collection) error {
+		var doc mongoTodo
+		err := c.Find(mongoIDQuery(id)).Sort("_id").One(&doc)
+		if err == nil {
+			err = c.RemoveId(doc.ObjectID)
+		}
+		if err == mgo.ErrNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+func (mongoDB *MongoDB) DeleteTodos(ctx context.Context, ids []string) error {
+	return deleteEach(ctx, ids, mongoDB.DeleteTodo)
+}
+
+func (mongoDB *MongoDB) UpdateTodo(ctx context.Context, id string, update func(*Todo)) error {
+	return mongoDB.updateTodo(ctx, id, update)
+}
+
+func (mongoDB *MongoDB) UpdateTodos(ctx context.Context, ids []string, update func(*Todo)) error {
+	return updateEach(ctx, ids, update, mongoDB.UpdateTodo)
+}
+
+func (mongoDB *MongoDB) CompleteTodo(ctx context.Context, id string) error {
+	return mongoDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = true
+	})
+}
+
+func (mongoDB *MongoDB) CompleteTodos(ctx context.Context, ids []string) error {
+	return completeEach(ctx, ids, mongoDB.CompleteTodo)
+}
+
+func (mongoDB *MongoDB) ReopenTodo(ctx context.Context, id string) error {
+	return mongoDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = false
+	})
+}
+
+func (mongoDB *MongoDB) SetDescription(ctx context.Context, id string, description string) error {
+	return mongoDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Description = description
+	})
+}
+
+func (mongoDB *MongoDB) SetDue(ctx context.Context, id string, due *time.Time) error {
+	return mongoDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Due = due
+	})
+}
+
+func (mongoDB *MongoDB) SetPriority(ctx context.Context, id string, priority int) error {
+	return mongoDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Priority = priority
+	})
+}
+
+func (mongoDB *MongoDB) SetTags(ctx context.Context, id string, tags []string) error {
+	return mongoDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Tags = tags
+	})
+}
+
+func (mongoDB *MongoDB) SetRecurrence(ctx context.Context, id string, recurrence string) error {
+	return mongoDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Recurrence = recurrence
+	})
+}
+
+func (mongoDB *MongoDB) AddSubTask(ctx context.Context, id string, title string) (SubTask, error) {
+	var subTask SubTask
+	err := mongoDB.updateTodo(ctx, id, func(todo *Todo) {
+		subTask = todo.addSubTask(title)
+	})
+
+	return subTask, err
+}
+
+func (mongoDB *MongoDB) CompleteSubTask(ctx context.Context, id string, subTaskID string) error {
+	found := false
+	err := mongoDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.completeSubTask(subTaskID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (mongoDB *MongoDB) GetSubTasks(ctx context.Context, id string) ([]SubTask, error) {
+	return subTasksOf(ctx, mongoDB.ForEachTodo, id)
+}
+
+func (mongoDB *MongoDB) AddAttachment(ctx context.Context, id string, attachment Attachment) error {
+	return mongoDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.addAttachment(attachment)
+	})
+}
+
+func (mongoDB *MongoDB) RemoveAttachment(ctx context.Context, id string, attachmentID string) error {
+	found := false
+	err := mongoDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.removeAttachment(attachmentID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// MoveTodo writes the todos from the old to the new position of the todo
+// into the documents of their new places, the ObjectIds keep the order. Like
+// ReplaceAllTodos it isn't atomic.
+func (mongoDB *MongoDB) MoveTodo(ctx context.Context, id string, position int) error {
+	return mongoDB.with(ctx, mongoDB.writes, func(c *mgo.Collection) error {
+		docs := []mongoTodo{}
+		if err := c.Find(nil).Sort("_id").All(&docs); err != nil {
+			return err
+		}
+
+		todos := make([]Todo, len(docs))
+		for i, doc := range docs {
+			todos[i] = doc.todo()
+		}
+		order, err := moveOrder(todos, id, position)
+		if err != nil {
+			return err
+		}
+
+		for i, old := range order {
+			if old == i {
+				continue
+			}
+			moved := newMongoTodo(todos[old])
+			moved.ObjectID = docs[i].ObjectID
+			if err := c.UpdateId(moved.ObjectID, moved); err != nil && err != mgo.ErrNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// updateTodo replaces the document of the todo, keeping its ObjectId and
+// with that its place in the list.
+func (mongoDB *MongoDB) updateTodo(ctx context.Context, id string, fn func(*Todo)) error {
+	return mongoDB.with(ctx, mongoDB.writes, func(c *mgo.Collection) error {
+		var doc mongoTodo
+		err := c.Find(mongoIDQuery(id)).Sort("_id").One(&doc)
+		if err == mgo.ErrNotFound {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		updated := newMongoTodo(doc.todo().edited(fn))
+		updated.ObjectID = doc.ObjectID
+		err = c.UpdateId(doc.ObjectID, updated)
+		if err == mgo.ErrNotFound {
+			return ErrNotFound
+		}
+		return err
+	})
+}
+
+// ReplaceAllTodos isn't atomic, readers can see an empty or partial list
+// while it runs.
+func (mongoDB *MongoDB) ReplaceAllTodos(ctx context.Context, todos []Todo) error {
+	return mongoDB.with(ctx, mongoDB.writes, func(c *mgo.Collection) error {
+		if _, err := c.RemoveAll(nil); err != nil {
+			return err
+		}
+		if len(todos) == 0 {
+			return nil
+		}
+
+		return insertMongoTodos(c, todos)
+	})
+}
+
+func (mongoDB *MongoDB) GetUsage(ctx context.Context) (Usage, error) {
+	var usage Usage
+	err := mongoDB.with(ctx, mongoDB.reads, func(c *mgo.Collection) error {
+		iter := c.Find(nil).Iter()
+		var todo mongoTodo
+		for iter.Next(&todo) {
+			usage.Todos++
+			usage.RawBytes += int64(len(marshalTodo(todo.todo())))
+		}
+		if err := iter.Close(); err != nil {
+			return err
+		}
+
+		var stats struct {
+			Size int64 `bson:"size"`
+		}
+		if err := c.Database.Run(bson.D{{Name: "collStats", Value: c.Name}}, &stats); err != nil {
+			logger.Warnf("collStats %s: %v", c.Name, err)
+		}
+		usage.StoredBytes = stats.Size
+
+		return nil
+	})
+	if err != nil {
+		return Usage{}, err
+	}
+
+	hostname := getHostname()
+	mongoDB.metrics.storageRawBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.RawBytes))
+	mongoDB.metrics.storageStoredBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.StoredBytes))
+
+	return usage, nil
+}
+
+const mongoKVCollection = "kv_values"
+
+// mongoValue is a value of the KV, a TTL index on expires removes it once it
+// expired. Values that never expire have no expires.
+type mongoValue struct {
+	Key     string     `bson:"_id"`
+	Value   string     `bson:"value"`
+	Expires *time.Time `bson:"expires,omitempty"`
+}
+
+func newMongoValue(key string, entry kvEntry) mongoValue {
+	value := mongoValue{Key: key, Value: entry.Value}
+	if !entry.Expires.IsZero() {
+		value.Expires = &entry.Expires
+	}
+
+	return value
+}
+
+func (value mongoValue) entry() kvEntry {
+	entry := kvEntry{Value: value.Value}
+	if value.Expires != nil {
+		entry.Expires = *value.Expires
+	}
+
+	return entry
+}
+
+// withKV runs fn on the kv_values collection of the primary, next to the
+// todos.
+func (mongoDB *MongoDB) withKV(fn func(*mgo.Collection) error) error {
+	s := mongoDB.writes.Copy()
+	defer s.Close()
+
+	return fn(s.DB(mongoDB.database).C(mongoKVCollection))
+}
+
+// ensureKVIndex creates the TTL index of the values, which the server checks
+// about once a minute, reads skip the expired values before that.
+func (mongoDB *MongoDB) ensureKVIndex() error {
+	return mongoDB.withKV(func(c *mgo.Collection) error {
+		return c.EnsureIndex(mgo.Index{Key: []string{"expires"}, ExpireAfter: time.Second})
+	})
+}
+
+func (mongoDB *MongoDB) GetValue(key string) (string, error) {
+	var value mongoValue
+	err := mongoDB.withKV(func(c *mgo.Collection) error {
+		return c.FindId(key).One(&value)
+	})
+	if err == mgo.ErrNotFound || (err == nil && value.entry().expired()) {
+		return "", ErrNotFound
+	}
+
+	return value.Value, err
+}
+
+func (mongoDB *MongoDB) SetValue(key, value string, ttl time.Duration) error {
+	return mongoDB.withKV(func(c *mgo.Collection) error {
+		_, err := c.UpsertId(key, newMongoValue(key, kvEntry{Value: value, Expires: expiry(ttl)}))
+		return err
+	})
+}
+
+func (mongoDB *MongoDB) DeleteValue(key string) error {
+	return mongoDB.withKV(func(c *mgo.Collection) error {
+		if err := c.RemoveId(key); err != mgo.ErrNotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+// TakeValue removes the value with findAndModify, which returns it.
+func (mongoDB *MongoDB) TakeValue(key string) (string, error) {
+	var value mongoValue
+	err := mongoDB.withKV(func(c *mgo.Collection) error {
+		_, err := c.FindId(key).Apply(mgo.Change{Remove: true}, &value)
+		return err
+	})
+	if err == mgo.ErrNotFound || (err == nil && value.entry().expired()) {
+		return "", ErrNotFound
+	}
+
+	return value.Value, err
+}
+
+// IncrValue replaces the value only if it's still the one it counted up
+// from, and starts over otherwise.
+func (mongoDB *MongoDB) IncrValue(key string, ttl time.Duration) (int64, error) {
+	var count int64
+	err := mongoDB.withKV(func(c *mgo.Collection) error {
+		for attempt := 1; attempt <= kvIncrAttempts; attempt++ {
+			var old mongoValue
+			err := c.FindId(key).One(&old)
+			if err != nil && err != mgo.ErrNotFound {
+				return err
+			}
+			exists := err == nil
+
+			var entry kvEntry
+			entry, count, err = old.entry().incremented(ttl)
+			if err != nil {
+				return err
+			}
+
+			if !exists {
+				err = c.Insert(newMongoValue(key, entry))
+				if mgo.IsDup(err) {
+					continue
+				}
+				return err
+			}
+
+			err = c.Update(bson.M{"_id": key, "value": old.Value, "expires": old.Expires}, newMongoValue(key, entry))
+			if err == mgo.ErrNotFound {
+				continue
+			}
+			return err
+		}
+		return fmt.Errorf("value %s kept changing, gave up after %d attempts", key, kvIncrAttempts)
+	})
+
+	return count, err
+}
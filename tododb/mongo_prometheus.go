@@ -0,0 +1,129 @@
+package tododb
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/prometheus/client_golang/prometheus"
+	mgo "gopkg.in/mgo.v2"
+)
+
+var mongoRoles = []string{"primary", "secondary", "other"}
+
+func (mongoDB *MongoDB) RegisterMetrics(registerer prometheus.Registerer) error {
+	m := mongoDB.metrics
+	err := register(registerer,
+		m.mongoNodesTotal,
+		m.mongoNodesHealthyTotal,
+		m.storageRawBytes,
+		m.storageStoredBytes,
+	)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Registered Mongo Metrics")
+	return nil
+}
+
+// nodes are the configured seeds and the members the driver discovered.
+func (mongoDB *MongoDB) nodes() []string {
+	seen := map[string]bool{}
+	nodes := []string{}
+	for _, addr := range append(append([]string{}, mongoDB.dialInfo.Addrs...), mongoDB.writes.LiveServers()...) {
+		if !seen[addr] {
+			seen[addr] = true
+			nodes = append(nodes, addr)
+		}
+	}
+	sort.Strings(nodes)
+
+	return nodes
+}
+
+// GetHealthStatus checks every node of the replica set on its own, like the
+// Redis backend checks every address of master and slave.
+func (mongoDB *MongoDB) GetHealthStatus(ctx context.Context) map[string]string {
+	result := map[string]string{"self": okString}
+	hostname := getHostname()
+
+	type nodeResult struct {
+		addr   string
+		role   string
+		status string
+	}
+
+	nodes := mongoDB.nodes()
+	results := make(chan nodeResult, len(nodes))
+	var wg sync.WaitGroup
+	for _, addr := range nodes {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			role, err := mongoDB.checkNode(ctx, addr)
+			status := okString
+			if err != nil {
+				status = err.Error()
+			}
+			results <- nodeResult{addr: addr, role: role, status: status}
+		}(addr)
+	}
+	wg.Wait()
+	close(results)
+
+	healthy := map[string]int{}
+	for res := range results {
+		result["mongo-"+res.addr] = res.status
+		if res.status == okString {
+			healthy[res.role]++
+		}
+	}
+
+	// A cancelled check says nothing about the nodes
+	if ctx.Err() == nil {
+		mongoDB.metrics.mongoNodesTotal.WithLabelValues(hostname, buildinfo.Version).Set(float64(len(nodes)))
+		for _, role := range mongoRoles {
+			mongoDB.metrics.mongoNodesHealthyTotal.WithLabelValues(hostname, buildinfo.Version, role).Set(float64(healthy[role]))
+		}
+	}
+
+	return result
+}
+
+// checkNode connects to addr alone and asks for its role in the replica set.
+func (mongoDB *MongoDB) checkNode(ctx context.Context, addr string) (string, error) {
+	info := *mongoDB.dialInfo
+	info.Addrs = []string{addr}
+	info.Direct = true
+
+	var status struct {
+		IsMaster  bool `bson:"ismaster"`
+		Secondary bool `bson:"secondary"`
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	info.Timeout = contextTimeout(ctx, info.Timeout)
+
+	session, err := mgo.DialWithInfo(&info)
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	session.SetMode(mgo.Monotonic, true)
+	if err := session.Run("isMaster", &status); err != nil {
+		return "", err
+	}
+
+	switch {
+	case status.IsMaster:
+		return "primary", nil
+	case status.Secondary:
+		return "secondary", nil
+	}
+
+	return "other", nil
+}
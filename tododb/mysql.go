@@ -0,0 +1,632 @@
+package tododb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/johscheuer/todo-app-web/features"
+)
+
+const (
+	defaultMySQLDSN             = "todo-app@tcp(localhost:3306)/todo-app"
+	defaultMySQLMaxOpenConns    = 10
+	defaultMySQLMaxIdleConns    = 5
+	defaultMySQLConnMaxLifetime = 300
+
+	// mysqlMigrationLock serializes the migrations of several replicas
+	// starting at once, DDL commits on its own so a transaction can't
+	mysqlMigrationLock           = "todo-app-migrations"
+	mysqlMigrationLockTimeoutSec = 60
+)
+
+// mysqlMigrations are applied in order, each exactly once. Never change an
+// existing entry, append a new one. They work with MySQL 5.7 and MariaDB
+// 10.2 and newer.
+var mysqlMigrations = []string{
+	`CREATE TABLE todos (
+		id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		title TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	) DEFAULT CHARSET = utf8mb4`,
+	`ALTER TABLE todos ADD COLUMN doc LONGTEXT`,
+	`ALTER TABLE todos ADD COLUMN todo_id VARCHAR(64) AS (JSON_UNQUOTE(JSON_EXTRACT(doc, '$.id'))) STORED`,
+	`CREATE INDEX todos_todo_id ON todos (todo_id)`,
+	`CREATE TABLE kv_values (
+		name VARCHAR(255) NOT NULL PRIMARY KEY,
+		value LONGTEXT NOT NULL,
+		expires_at BIGINT NOT NULL DEFAULT 0
+	) DEFAULT CHARSET = utf8mb4`,
+}
+
+var mysqlKVQueries = sqlKVQueries{
+	get:    "SELECT value FROM kv_values WHERE name = ? AND (expires_at = 0 OR expires_at > ?)",
+	set:    "INSERT INTO kv_values (name, value, expires_at) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE value = VALUES(value), expires_at = VALUES(expires_at)",
+	create: "INSERT IGNORE INTO kv_values (name, value, expires_at) VALUES (?, '0', 0)",
+	lock:   "SELECT value, expires_at FROM kv_values WHERE name = ? FOR UPDATE",
+	update: "UPDATE kv_values SET value = ?, expires_at = ? WHERE name = ?",
+	delete: "DELETE FROM kv_values WHERE name = ?",
+}
+
+// MySQLDB keeps every todo as a row, in the order they were added, in MySQL
+// or MariaDB. The whole Todo is the doc column, its id is indexed as the
+// generated column todo_id. Rows of older versions only have a title.
+type MySQLDB struct {
+	db      *sql.DB
+	addr    string
+	kv      sqlKV
+	metrics *Metrics
+
+	selectTodos  *sql.Stmt
+	selectPage   *sql.Stmt
+	countTodos   *sql.Stmt
+	selectIDs    *sql.Stmt
+	selectTodo   *sql.Stmt
+	insertTodo   *sql.Stmt
+	deleteTodo   *sql.Stmt
+	lockTodo     *sql.Stmt
+	lockTodos    *sql.Stmt
+	updateRow    *sql.Stmt
+	selectUsage  *sql.Stmt
+	deleteTodos  *sql.Stmt
+	checkVersion *sql.Stmt
+}
+
+var _ TodoDB = &MySQLDB{}
+
+func NewMySQLDB(config map[string]string) (*MySQLDB, error) {
+	dsn := defaultMySQLDSN
+	if value, exists := config["dsn"]; exists {
+		dsn = value
+	}
+
+	parsed, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("mysql dsn: %v", err)
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(intConfig(config, "maxOpenConns", defaultMySQLMaxOpenConns))
+	db.SetMaxIdleConns(intConfig(config, "maxIdleConns", defaultMySQLMaxIdleConns))
+	db.SetConnMaxLifetime(time.Duration(intConfig(config, "connMaxLifetime", defaultMySQLConnMaxLifetime)) * time.Second)
+
+	mysqlDB := &MySQLDB{
+		db:      db,
+		addr:    parsed.Addr,
+		metrics: NewMetrics(),
+	}
+	mysqlDB.kv = sqlKV{db: db, inTx: mysqlDB.inTx, queries: mysqlKVQueries}
+
+	if err := mysqlDB.migrate(context.Background()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("mysql %s: %v", parsed.Addr, err)
+	}
+
+	if err := mysqlDB.prepare(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return mysqlDB, nil
+}
+
+// migrate creates the schema or brings it up to date. The lock belongs to
+// the session, so all of it runs on one connection.
+func (mysqlDB *MySQLDB) migrate(ctx context.Context) error {
+	conn, err := mysqlDB.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", mysqlMigrationLock, mysqlMigrationLockTimeoutSec).Scan(&locked); err != nil {
+		return err
+	}
+	if locked.Int64 != 1 {
+		return fmt.Errorf("another instance holds the migration lock %s", mysqlMigrationLock)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", mysqlMigrationLock)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var version int
+	if err := conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(mysqlMigrations); i++ {
+		if _, err := conn.ExecContext(ctx, mysqlMigrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+		if _, err := conn.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES (?)", i+1); err != nil {
+			return err
+		}
+		logger.Infof("Applied schema migration %d", i+1)
+	}
+
+	return nil
+}
+
+func (mysqlDB *MySQLDB) prepare() error {
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&mysqlDB.selectTodos, "SELECT id, title, doc FROM todos ORDER BY id"},
+		{&mysqlDB.selectPage, "SELECT id, title, doc FROM todos ORDER BY id LIMIT ? OFFSET ?"},
+		{&mysqlDB.countTodos, "SELECT COUNT(*) FROM todos"},
+		{&mysqlDB.selectIDs, "SELECT id FROM todos ORDER BY id"},
+		{&mysqlDB.selectTodo, "SELECT id, title, doc FROM todos WHERE id = ?"},
+		{&mysqlDB.insertTodo, "INSERT INTO todos (title, doc) VALUES (?, ?)"},
+		{&mysqlDB.deleteTodo, "DELETE FROM todos WHERE todo_id = ? OR (doc IS NULL AND CAST(id AS CHAR) = ?) ORDER BY id LIMIT 1"},
+		{&mysqlDB.lockTodo, "SELECT id FROM todos WHERE todo_id = ? OR (doc IS NULL AND CAST(id AS CHAR) = ?) ORDER BY id LIMIT 1 FOR UPDATE"},
+		{&mysqlDB.lockTodos, "SELECT id, title, doc FROM todos ORDER BY id FOR UPDATE"},
+		{&mysqlDB.updateRow, "UPDATE todos SET title = ?, doc = ? WHERE id = ?"},
+		{&mysqlDB.selectUsage, `SELECT COUNT(*), COALESCE(SUM(LENGTH(COALESCE(doc, title))), 0),
+			(SELECT COALESCE(MAX(data_length + index_length), 0) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'todos')
+			FROM todos`},
+		{&mysqlDB.deleteTodos, "DELETE FROM todos"},
+		{&mysqlDB.checkVersion, "SELECT VERSION(), @@global.read_only"},
+	}
+
+	for _, statement := range statements {
+		stmt, err := mysqlDB.db.Prepare(statement.query)
+		if err != nil {
+			return fmt.Errorf("prepare %q: %v", statement.query, err)
+		}
+		*statement.stmt = stmt
+	}
+
+	return nil
+}
+
+func (mysqlDB *MySQLDB) GetAllTodos(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	todos := []Todo{}
+	err := mysqlDB.ForEachTodo(ctx, func(todo Todo) error {
+		if matchesAll(filters, todo) {
+			todos = append(todos, todo)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return todos, nil
+}
+
+// GetTodos lets the database skip to the page, unless filters are given.
+func (mysqlDB *MySQLDB) GetTodos(ctx context.Context, offset, limit int, filters ...TodoFilter) ([]Todo, error) {
+	if len(filters) > 0 || features.Enabled(features.PerfNPlusOne) {
+		return pageTodos(ctx, mysqlDB.ForEachTodo, offset, limit, filters)
+	}
+	if err := checkPage(offset, limit); err != nil {
+		return nil, err
+	}
+
+	rows, err := mysqlDB.selectPage.QueryContext(ctx, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanSQLTodos(rows)
+}
+
+func (mysqlDB *MySQLDB) CountTodos(ctx context.Context, filters ...TodoFilter) (int, error) {
+	if len(filters) > 0 {
+		return countTodos(ctx, mysqlDB.ForEachTodo, filters)
+	}
+
+	var count int
+	err := mysqlDB.countTodos.QueryRowContext(ctx).Scan(&count)
+	return count, err
+}
+
+func (mysqlDB *MySQLDB) GetOverdueTodos(ctx context.Context) ([]Todo, error) {
+	return todosByDue(ctx, mysqlDB.GetAllTodos, overdueFilter())
+}
+
+func (mysqlDB *MySQLDB) GetTodosDueBefore(ctx context.Context, t time.Time) ([]Todo, error) {
+	return todosByDue(ctx, mysqlDB.GetAllTodos, TodoFilter{DueBefore: t})
+}
+
+func (mysqlDB *MySQLDB) GetTodosByPriority(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	return todosByPriority(ctx, mysqlDB.GetAllTodos, filters)
+}
+
+func (mysqlDB *MySQLDB) GetTodosByTag(ctx context.Context, tag string) ([]Todo, error) {
+	return todosByTag(ctx, mysqlDB.GetAllTodos, tag)
+}
+
+func (mysqlDB *MySQLDB) ListTags(ctx context.Context) ([]Tag, error) {
+	return CountTags(ctx, mysqlDB.ForEachTodo)
+}
+
+func (mysqlDB *MySQLDB) SearchTodos(ctx context.Context, query string) ([]Todo, error) {
+	return searchTodos(ctx, mysqlDB.GetAllTodos, query)
+}
+
+func (mysqlDB *MySQLDB) ForEachTodo(ctx context.Context, fn func(Todo) error) error {
+	if features.Enabled(features.PerfNPlusOne) {
+		return mysqlDB.forEachTodoOneByOne(ctx, fn)
+	}
+
+	rows, err := mysqlDB.selectTodos.QueryContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		todo, err := scanSQLTodo(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(todo); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// forEachTodoOneByOne is the deliberately slow variant of ForEachTodo, with
+// one query per todo.
+func (mysqlDB *MySQLDB) forEachTodoOneByOne(ctx context.Context, fn func(Todo) error) error {
+	rows, err := mysqlDB.selectIDs.QueryContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		todo, err := scanSQLTodo(mysqlDB.selectTodo.QueryRowContext(ctx, id))
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(todo); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (mysqlDB *MySQLDB) SaveTodo(ctx context.Context, todo Todo) error {
+	_, err := mysqlDB.insertTodo.ExecContext(ctx, sqlTodoArgs(todo)...)
+	return err
+}
+
+func (mysqlDB *MySQLDB) SaveTodos(ctx context.Context, todos []Todo) error {
+	if len(todos) == 0 {
+		return nil
+	}
+
+	return mysqlDB.inTx(ctx, func(tx *sql.Tx) error {
+		return mysqlDB.insert(ctx, tx, todos)
+	})
+}
+
+func (mysqlDB *MySQLDB) DeleteTodo(ctx context.Context, id string) error {
+	result, err := mysqlDB.deleteTodo.ExecContext(ctx, id, id)
+	if err != nil {
+		return err
+	}
+
+	removed, _ := result.RowsAffected()
+	logger.Debugf("Deleted %d todos", removed)
+	return nil
+}
+
+// DeleteTodos deletes the todos in one transaction.
+func (mysqlDB *MySQLDB) DeleteTodos(ctx context.Context, ids []string) error {
+	return mysqlDB.inTx(ctx, func(tx *sql.Tx) error {
+		stmt := tx.StmtContext(ctx, mysqlDB.deleteTodo)
+		for _, id := range ids {
+			result, err := stmt.ExecContext(ctx, id, id)
+			if err != nil {
+				return err
+			}
+			removed, _ := result.RowsAffected()
+			logger.Debugf("Deleted %d todos", removed)
+		}
+		return nil
+	})
+}
+
+func (mysqlDB *MySQLDB) UpdateTodo(ctx context.Context, id string, update func(*Todo)) error {
+	return mysqlDB.updateTodo(ctx, id, update)
+}
+
+func (mysqlDB *MySQLDB) UpdateTodos(ctx context.Context, ids []string, update func(*Todo)) error {
+	return mysqlDB.inTx(ctx, func(tx *sql.Tx) error {
+		for _, id := range ids {
+			if err := mysqlDB.updateInTx(ctx, tx, id, update); err != nil && err != ErrNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (mysqlDB *MySQLDB) CompleteTodo(ctx context.Context, id string) error {
+	return mysqlDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = true
+	})
+}
+
+// CompleteTodos marks the todos done in one transaction.
+func (mysqlDB *MySQLDB) CompleteTodos(ctx context.Context, ids []string) error {
+	return mysqlDB.inTx(ctx, func(tx *sql.Tx) error {
+		for _, id := range ids {
+			err := mysqlDB.updateInTx(ctx, tx, id, func(todo *Todo) {
+				todo.Done = true
+			})
+			if err != nil && err != ErrNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (mysqlDB *MySQLDB) ReopenTodo(ctx context.Context, id string) error {
+	return mysqlDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = false
+	})
+}
+
+func (mysqlDB *MySQLDB) SetDescription(ctx context.Context, id string, description string) error {
+	return mysqlDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Description = description
+	})
+}
+
+func (mysqlDB *MySQLDB) SetDue(ctx context.Context, id string, due *time.Time) error {
+	return mysqlDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Due = due
+	})
+}
+
+func (mysqlDB *MySQLDB) SetPriority(ctx context.Context, id string, priority int) error {
+	return mysqlDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Priority = priority
+	})
+}
+
+func (mysqlDB *MySQLDB) SetTags(ctx context.Context, id string, tags []string) error {
+	return mysqlDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Tags = tags
+	})
+}
+
+func (mysqlDB *MySQLDB) SetRecurrence(ctx context.Context, id string, recurrence string) error {
+	return mysqlDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Recurrence = recurrence
+	})
+}
+
+func (mysqlDB *MySQLDB) AddSubTask(ctx context.Context, id string, title string) (SubTask, error) {
+	var subTask SubTask
+	err := mysqlDB.updateTodo(ctx, id, func(todo *Todo) {
+		subTask = todo.addSubTask(title)
+	})
+
+	return subTask, err
+}
+
+func (mysqlDB *MySQLDB) CompleteSubTask(ctx context.Context, id string, subTaskID string) error {
+	found := false
+	err := mysqlDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.completeSubTask(subTaskID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (mysqlDB *MySQLDB) GetSubTasks(ctx context.Context, id string) ([]SubTask, error) {
+	return subTasksOf(ctx, mysqlDB.ForEachTodo, id)
+}
+
+func (mysqlDB *MySQLDB) AddAttachment(ctx context.Context, id string, attachment Attachment) error {
+	return mysqlDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.addAttachment(attachment)
+	})
+}
+
+func (mysqlDB *MySQLDB) RemoveAttachment(ctx context.Context, id string, attachmentID string) error {
+	found := false
+	err := mysqlDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.removeAttachment(attachmentID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// MoveTodo locks all rows while it rewrites the ones from the old to the
+// new position of the todo, the rows are ordered by their id.
+func (mysqlDB *MySQLDB) MoveTodo(ctx context.Context, id string, position int) error {
+	return mysqlDB.inTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.StmtContext(ctx, mysqlDB.lockTodos).QueryContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		update := tx.StmtContext(ctx, mysqlDB.updateRow)
+		return moveSQLTodo(rows, id, position, func(todo Todo, rowID int64) error {
+			_, err := update.ExecContext(ctx, append(sqlTodoArgs(todo), rowID)...)
+			return err
+		})
+	})
+}
+
+// updateTodo locks the row of the todo until the changed todo is written.
+func (mysqlDB *MySQLDB) updateTodo(ctx context.Context, id string, fn func(*Todo)) error {
+	return mysqlDB.inTx(ctx, func(tx *sql.Tx) error {
+		return mysqlDB.updateInTx(ctx, tx, id, fn)
+	})
+}
+
+func (mysqlDB *MySQLDB) updateInTx(ctx context.Context, tx *sql.Tx, id string, fn func(*Todo)) error {
+	var rowID int64
+	err := tx.StmtContext(ctx, mysqlDB.lockTodo).QueryRowContext(ctx, id, id).Scan(&rowID)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	todo, err := scanSQLTodo(tx.StmtContext(ctx, mysqlDB.selectTodo).QueryRowContext(ctx, rowID))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.StmtContext(ctx, mysqlDB.updateRow).ExecContext(ctx, append(sqlTodoArgs(todo.edited(fn)), rowID)...)
+	return err
+}
+
+func (mysqlDB *MySQLDB) ReplaceAllTodos(ctx context.Context, todos []Todo) error {
+	return mysqlDB.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.StmtContext(ctx, mysqlDB.deleteTodos).ExecContext(ctx); err != nil {
+			return err
+		}
+
+		return mysqlDB.insert(ctx, tx, todos)
+	})
+}
+
+func (mysqlDB *MySQLDB) insert(ctx context.Context, tx *sql.Tx, todos []Todo) error {
+	stmt := tx.StmtContext(ctx, mysqlDB.insertTodo)
+	for _, todo := range todos {
+		if _, err := stmt.ExecContext(ctx, sqlTodoArgs(todo)...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// inTx commits if fn succeeds, otherwise nothing of fn is kept.
+func (mysqlDB *MySQLDB) inTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := mysqlDB.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetUsage reports the size of the table with its indexes as StoredBytes,
+// as estimated by information_schema.
+func (mysqlDB *MySQLDB) GetUsage(ctx context.Context) (Usage, error) {
+	var usage Usage
+	err := mysqlDB.selectUsage.QueryRowContext(ctx).Scan(&usage.Todos, &usage.RawBytes, &usage.StoredBytes)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	hostname := getHostname()
+	mysqlDB.metrics.storageRawBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.RawBytes))
+	mysqlDB.metrics.storageStoredBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.StoredBytes))
+
+	return usage, nil
+}
+
+// GetHealthStatus reports a server that is read only, like a replica after
+// a failover of RDS, as broken since writes would fail. A node of a Galera
+// cluster of MariaDB also has to be ready for queries.
+func (mysqlDB *MySQLDB) GetHealthStatus(ctx context.Context) map[string]string {
+	result := map[string]string{"self": okString, "mysql": okString}
+	hostname := getHostname()
+
+	var serverVersion string
+	var readOnly bool
+	err := mysqlDB.checkVersion.QueryRowContext(ctx).Scan(&serverVersion, &readOnly)
+	if err == nil && readOnly {
+		err = fmt.Errorf("%s %s is read only", mysqlDB.addr, serverVersion)
+	}
+	if err == nil {
+		err = mysqlDB.checkGalera(ctx)
+	}
+
+	if err != nil {
+		result["mysql"] = err.Error()
+		if ctx.Err() == nil {
+			mysqlDB.metrics.mysqlUp.WithLabelValues(hostname, buildinfo.Version).Set(0)
+		}
+	} else {
+		mysqlDB.metrics.mysqlUp.WithLabelValues(hostname, buildinfo.Version).Set(1)
+	}
+
+	stats := mysqlDB.db.Stats()
+	connections := mysqlDB.metrics.mysqlConnections
+	connections.WithLabelValues(hostname, buildinfo.Version, "in_use").Set(float64(stats.InUse))
+	connections.WithLabelValues(hostname, buildinfo.Version, "idle").Set(float64(stats.Idle))
+
+	return result
+}
+
+// checkGalera fails if the server is a Galera node that doesn't accept
+// queries, servers outside of a Galera cluster have no wsrep_ready.
+func (mysqlDB *MySQLDB) checkGalera(ctx context.Context) error {
+	var name, ready string
+	err := mysqlDB.db.QueryRowContext(ctx, "SHOW GLOBAL STATUS LIKE 'wsrep_ready'").Scan(&name, &ready)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(ready, "ON") {
+		return fmt.Errorf("%s is a Galera node with wsrep_ready %s", mysqlDB.addr, ready)
+	}
+
+	return nil
+}
+
+// OpenConnections returns the connections of the pool, in use or idle.
+func (mysqlDB *MySQLDB) OpenConnections() int64 {
+	return int64(mysqlDB.db.Stats().OpenConnections)
+}
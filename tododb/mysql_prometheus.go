@@ -0,0 +1,21 @@
+package tododb
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func (mysqlDB *MySQLDB) RegisterMetrics(registerer prometheus.Registerer) error {
+	m := mysqlDB.metrics
+	err := register(registerer,
+		m.mysqlUp,
+		m.mysqlConnections,
+		m.storageRawBytes,
+		m.storageStoredBytes,
+	)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Registered MySQL Metrics")
+	return nil
+}
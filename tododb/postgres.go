@@ -0,0 +1,629 @@
+package tododb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/johscheuer/todo-app-web/features"
+	// registers the postgres driver with database/sql
+	_ "github.com/lib/pq"
+)
+
+const (
+	defaultPostgresURL             = "postgres://todo-app@localhost:5432/todo-app?sslmode=disable"
+	defaultPostgresMaxOpenConns    = 10
+	defaultPostgresMaxIdleConns    = 5
+	defaultPostgresConnMaxLifetime = 300
+
+	// postgresMigrationLock serializes the migrations of several replicas
+	// starting at once, it is an arbitrary key for pg_advisory_xact_lock
+	postgresMigrationLock = 7242
+)
+
+// postgresMigrations are applied in order, each exactly once. Never change an
+// existing entry, append a new one.
+var postgresMigrations = []string{
+	`CREATE TABLE todos (
+		id BIGSERIAL PRIMARY KEY,
+		title TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`ALTER TABLE todos ADD COLUMN doc JSONB`,
+	`CREATE INDEX todos_doc_id ON todos ((doc->>'id'))`,
+	`CREATE INDEX todos_doc_due ON todos ((doc->>'due'))`,
+	`CREATE INDEX todos_doc_tags ON todos USING GIN ((doc->'tags'))`,
+	`CREATE TABLE kv_values (
+		name TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		expires_at BIGINT NOT NULL DEFAULT 0
+	)`,
+}
+
+var postgresKVQueries = sqlKVQueries{
+	get:    "SELECT value FROM kv_values WHERE name = $1 AND (expires_at = 0 OR expires_at > $2)",
+	set:    "INSERT INTO kv_values (name, value, expires_at) VALUES ($1, $2, $3) ON CONFLICT (name) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at",
+	create: "INSERT INTO kv_values (name, value, expires_at) VALUES ($1, '0', 0) ON CONFLICT (name) DO NOTHING",
+	lock:   "SELECT value, expires_at FROM kv_values WHERE name = $1 FOR UPDATE",
+	update: "UPDATE kv_values SET value = $1, expires_at = $2 WHERE name = $3",
+	delete: "DELETE FROM kv_values WHERE name = $1",
+}
+
+// PostgresDB keeps every todo as a row, in the order they were added. The
+// whole Todo is the doc column, rows of older versions only have a title.
+type PostgresDB struct {
+	db      *sql.DB
+	kv      sqlKV
+	metrics *Metrics
+
+	selectTodos    *sql.Stmt
+	selectPage     *sql.Stmt
+	countTodos     *sql.Stmt
+	selectDue      *sql.Stmt
+	selectOverdue  *sql.Stmt
+	selectPriority *sql.Stmt
+	selectTag      *sql.Stmt
+	countTags      *sql.Stmt
+	selectIDs      *sql.Stmt
+	selectTodo     *sql.Stmt
+	insertTodo     *sql.Stmt
+	deleteTodo     *sql.Stmt
+	lockTodo       *sql.Stmt
+	lockTodos      *sql.Stmt
+	updateRow      *sql.Stmt
+	selectUsage    *sql.Stmt
+	deleteTodos    *sql.Stmt
+	checkVersion   *sql.Stmt
+}
+
+var _ TodoDB = &PostgresDB{}
+
+func NewPostgresDB(config map[string]string) (*PostgresDB, error) {
+	url := defaultPostgresURL
+	if value, exists := config["url"]; exists {
+		url = value
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(intConfig(config, "maxOpenConns", defaultPostgresMaxOpenConns))
+	db.SetMaxIdleConns(intConfig(config, "maxIdleConns", defaultPostgresMaxIdleConns))
+	db.SetConnMaxLifetime(time.Duration(intConfig(config, "connMaxLifetime", defaultPostgresConnMaxLifetime)) * time.Second)
+
+	postgresDB := &PostgresDB{
+		db:      db,
+		metrics: NewMetrics(),
+	}
+	postgresDB.kv = sqlKV{db: db, inTx: postgresDB.inTx, queries: postgresKVQueries}
+
+	if err := postgresDB.migrate(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := postgresDB.prepare(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return postgresDB, nil
+}
+
+// migrate creates the schema or brings it up to date.
+func (postgresDB *PostgresDB) migrate(ctx context.Context) error {
+	tx, err := postgresDB.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", postgresMigrationLock); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var version int
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(postgresMigrations); i++ {
+		if _, err := tx.ExecContext(ctx, postgresMigrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", i+1); err != nil {
+			return err
+		}
+		logger.Infof("Applied schema migration %d", i+1)
+	}
+
+	return tx.Commit()
+}
+
+func (postgresDB *PostgresDB) prepare() error {
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&postgresDB.selectTodos, "SELECT id, title, doc FROM todos ORDER BY id"},
+		{&postgresDB.selectPage, "SELECT id, title, doc FROM todos ORDER BY id LIMIT $1 OFFSET $2"},
+		{&postgresDB.countTodos, "SELECT COUNT(*) FROM todos"},
+		{&postgresDB.selectDue, "SELECT id, title, doc FROM todos WHERE doc->>'due' < $1 ORDER BY doc->>'due', id"},
+		{&postgresDB.selectOverdue, "SELECT id, title, doc FROM todos WHERE doc->>'due' < $1 AND NOT COALESCE((doc->>'done')::boolean, false) ORDER BY doc->>'due', id"},
+		{&postgresDB.selectPriority, "SELECT id, title, doc FROM todos ORDER BY COALESCE((doc->>'priority')::int, 0) DESC, id"},
+		{&postgresDB.selectTag, "SELECT id, title, doc FROM todos WHERE doc->'tags' ? $1 ORDER BY id"},
+		{&postgresDB.countTags, "SELECT tag, COUNT(*) FROM (SELECT jsonb_array_elements_text(doc->'tags') AS tag FROM todos) AS tags GROUP BY tag ORDER BY tag"},
+		{&postgresDB.selectIDs, "SELECT id FROM todos ORDER BY id"},
+		{&postgresDB.selectTodo, "SELECT id, title, doc FROM todos WHERE id = $1"},
+		{&postgresDB.insertTodo, "INSERT INTO todos (title, doc) VALUES ($1, $2)"},
+		{&postgresDB.deleteTodo, "DELETE FROM todos WHERE id = (SELECT id FROM todos WHERE doc->>'id' = $1 OR (doc IS NULL AND id::text = $1) ORDER BY id LIMIT 1)"},
+		{&postgresDB.lockTodo, "SELECT id FROM todos WHERE doc->>'id' = $1 OR (doc IS NULL AND id::text = $1) ORDER BY id LIMIT 1 FOR UPDATE"},
+		{&postgresDB.lockTodos, "SELECT id, title, doc FROM todos ORDER BY id FOR UPDATE"},
+		{&postgresDB.updateRow, "UPDATE todos SET title = $1, doc = $2 WHERE id = $3"},
+		{&postgresDB.selectUsage, "SELECT COUNT(*), COALESCE(SUM(OCTET_LENGTH(COALESCE(doc::text, title))), 0), pg_total_relation_size('todos') FROM todos"},
+		{&postgresDB.deleteTodos, "DELETE FROM todos"},
+		{&postgresDB.checkVersion, "SHOW server_version"},
+	}
+
+	for _, statement := range statements {
+		stmt, err := postgresDB.db.Prepare(statement.query)
+		if err != nil {
+			return fmt.Errorf("prepare %q: %v", statement.query, err)
+		}
+		*statement.stmt = stmt
+	}
+
+	return nil
+}
+
+func (postgresDB *PostgresDB) GetAllTodos(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	todos := []Todo{}
+	err := postgresDB.ForEachTodo(ctx, func(todo Todo) error {
+		if matchesAll(filters, todo) {
+			todos = append(todos, todo)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return todos, nil
+}
+
+// GetTodos lets the database skip to the page, unless filters are given.
+func (postgresDB *PostgresDB) GetTodos(ctx context.Context, offset, limit int, filters ...TodoFilter) ([]Todo, error) {
+	if len(filters) > 0 || features.Enabled(features.PerfNPlusOne) {
+		return pageTodos(ctx, postgresDB.ForEachTodo, offset, limit, filters)
+	}
+	if err := checkPage(offset, limit); err != nil {
+		return nil, err
+	}
+
+	rows, err := postgresDB.selectPage.QueryContext(ctx, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanSQLTodos(rows)
+}
+
+func (postgresDB *PostgresDB) CountTodos(ctx context.Context, filters ...TodoFilter) (int, error) {
+	if len(filters) > 0 {
+		return countTodos(ctx, postgresDB.ForEachTodo, filters)
+	}
+
+	var count int
+	err := postgresDB.countTodos.QueryRowContext(ctx).Scan(&count)
+	return count, err
+}
+
+// GetOverdueTodos and GetTodosDueBefore compare the due dates of the docs
+// as strings, which sort like the times, see Todo.
+func (postgresDB *PostgresDB) GetOverdueTodos(ctx context.Context) ([]Todo, error) {
+	rows, err := postgresDB.selectOverdue.QueryContext(ctx, dueKey(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+
+	return scanSQLTodos(rows)
+}
+
+func (postgresDB *PostgresDB) GetTodosDueBefore(ctx context.Context, t time.Time) ([]Todo, error) {
+	rows, err := postgresDB.selectDue.QueryContext(ctx, dueKey(t))
+	if err != nil {
+		return nil, err
+	}
+
+	return scanSQLTodos(rows)
+}
+
+func (postgresDB *PostgresDB) GetTodosByPriority(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	rows, err := postgresDB.selectPriority.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	todos, err := scanSQLTodos(rows)
+	return filterTodos(todos, filters), err
+}
+
+// GetTodosByTag looks the tag up in the GIN index of the tags.
+func (postgresDB *PostgresDB) GetTodosByTag(ctx context.Context, tag string) ([]Todo, error) {
+	rows, err := postgresDB.selectTag.QueryContext(ctx, NormalizeTag(tag))
+	if err != nil {
+		return nil, err
+	}
+
+	return scanSQLTodos(rows)
+}
+
+func (postgresDB *PostgresDB) ListTags(ctx context.Context) ([]Tag, error) {
+	rows, err := postgresDB.countTags.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanSQLTags(rows)
+}
+
+func (postgresDB *PostgresDB) SearchTodos(ctx context.Context, query string) ([]Todo, error) {
+	return searchTodos(ctx, postgresDB.GetAllTodos, query)
+}
+
+func (postgresDB *PostgresDB) ForEachTodo(ctx context.Context, fn func(Todo) error) error {
+	if features.Enabled(features.PerfNPlusOne) {
+		return postgresDB.forEachTodoOneByOne(ctx, fn)
+	}
+
+	rows, err := postgresDB.selectTodos.QueryContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		todo, err := scanSQLTodo(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(todo); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// forEachTodoOneByOne is the deliberately slow variant of ForEachTodo, with
+// one query per todo.
+func (postgresDB *PostgresDB) forEachTodoOneByOne(ctx context.Context, fn func(Todo) error) error {
+	rows, err := postgresDB.selectIDs.QueryContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		todo, err := scanSQLTodo(postgresDB.selectTodo.QueryRowContext(ctx, id))
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(todo); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (postgresDB *PostgresDB) SaveTodo(ctx context.Context, todo Todo) error {
+	_, err := postgresDB.insertTodo.ExecContext(ctx, sqlTodoArgs(todo)...)
+	return err
+}
+
+func (postgresDB *PostgresDB) SaveTodos(ctx context.Context, todos []Todo) error {
+	if len(todos) == 0 {
+		return nil
+	}
+
+	return postgresDB.inTx(ctx, func(tx *sql.Tx) error {
+		return postgresDB.insert(ctx, tx, todos)
+	})
+}
+
+func (postgresDB *PostgresDB) DeleteTodo(ctx context.Context, id string) error {
+	result, err := postgresDB.deleteTodo.ExecContext(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	removed, _ := result.RowsAffected()
+	logger.Debugf("Deleted %d todos", removed)
+	return nil
+}
+
+// DeleteTodos deletes the todos in one transaction.
+func (postgresDB *PostgresDB) DeleteTodos(ctx context.Context, ids []string) error {
+	return postgresDB.inTx(ctx, func(tx *sql.Tx) error {
+		for _, id := range ids {
+			if err := postgresDB.removeInTx(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (postgresDB *PostgresDB) removeInTx(ctx context.Context, tx *sql.Tx, id string) error {
+	result, err := tx.StmtContext(ctx, postgresDB.deleteTodo).ExecContext(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	removed, _ := result.RowsAffected()
+	logger.Debugf("Deleted %d todos", removed)
+	return nil
+}
+
+func (postgresDB *PostgresDB) UpdateTodo(ctx context.Context, id string, update func(*Todo)) error {
+	return postgresDB.updateTodo(ctx, id, update)
+}
+
+func (postgresDB *PostgresDB) UpdateTodos(ctx context.Context, ids []string, update func(*Todo)) error {
+	return postgresDB.inTx(ctx, func(tx *sql.Tx) error {
+		for _, id := range ids {
+			if err := postgresDB.updateInTx(ctx, tx, id, update); err != nil && err != ErrNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (postgresDB *PostgresDB) CompleteTodo(ctx context.Context, id string) error {
+	return postgresDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = true
+	})
+}
+
+// CompleteTodos marks the todos done in one transaction.
+func (postgresDB *PostgresDB) CompleteTodos(ctx context.Context, ids []string) error {
+	return postgresDB.inTx(ctx, func(tx *sql.Tx) error {
+		for _, id := range ids {
+			err := postgresDB.updateInTx(ctx, tx, id, func(todo *Todo) {
+				todo.Done = true
+			})
+			if err != nil && err != ErrNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (postgresDB *PostgresDB) ReopenTodo(ctx context.Context, id string) error {
+	return postgresDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = false
+	})
+}
+
+func (postgresDB *PostgresDB) SetDescription(ctx context.Context, id string, description string) error {
+	return postgresDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Description = description
+	})
+}
+
+func (postgresDB *PostgresDB) SetDue(ctx context.Context, id string, due *time.Time) error {
+	return postgresDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Due = due
+	})
+}
+
+func (postgresDB *PostgresDB) SetPriority(ctx context.Context, id string, priority int) error {
+	return postgresDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Priority = priority
+	})
+}
+
+func (postgresDB *PostgresDB) SetTags(ctx context.Context, id string, tags []string) error {
+	return postgresDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Tags = tags
+	})
+}
+
+func (postgresDB *PostgresDB) SetRecurrence(ctx context.Context, id string, recurrence string) error {
+	return postgresDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Recurrence = recurrence
+	})
+}
+
+func (postgresDB *PostgresDB) AddSubTask(ctx context.Context, id string, title string) (SubTask, error) {
+	var subTask SubTask
+	err := postgresDB.updateTodo(ctx, id, func(todo *Todo) {
+		subTask = todo.addSubTask(title)
+	})
+
+	return subTask, err
+}
+
+func (postgresDB *PostgresDB) CompleteSubTask(ctx context.Context, id string, subTaskID string) error {
+	found := false
+	err := postgresDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.completeSubTask(subTaskID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (postgresDB *PostgresDB) GetSubTasks(ctx context.Context, id string) ([]SubTask, error) {
+	return subTasksOf(ctx, postgresDB.ForEachTodo, id)
+}
+
+func (postgresDB *PostgresDB) AddAttachment(ctx context.Context, id string, attachment Attachment) error {
+	return postgresDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.addAttachment(attachment)
+	})
+}
+
+func (postgresDB *PostgresDB) RemoveAttachment(ctx context.Context, id string, attachmentID string) error {
+	found := false
+	err := postgresDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.removeAttachment(attachmentID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// MoveTodo locks all rows while it rewrites the ones from the old to the
+// new position of the todo, the rows are ordered by their id.
+func (postgresDB *PostgresDB) MoveTodo(ctx context.Context, id string, position int) error {
+	return postgresDB.inTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.StmtContext(ctx, postgresDB.lockTodos).QueryContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		update := tx.StmtContext(ctx, postgresDB.updateRow)
+		return moveSQLTodo(rows, id, position, func(todo Todo, rowID int64) error {
+			_, err := update.ExecContext(ctx, append(sqlTodoArgs(todo), rowID)...)
+			return err
+		})
+	})
+}
+
+// updateTodo locks the row of the todo until the changed todo is written.
+func (postgresDB *PostgresDB) updateTodo(ctx context.Context, id string, fn func(*Todo)) error {
+	return postgresDB.inTx(ctx, func(tx *sql.Tx) error {
+		return postgresDB.updateInTx(ctx, tx, id, fn)
+	})
+}
+
+func (postgresDB *PostgresDB) updateInTx(ctx context.Context, tx *sql.Tx, id string, fn func(*Todo)) error {
+	var rowID int64
+	err := tx.StmtContext(ctx, postgresDB.lockTodo).QueryRowContext(ctx, id).Scan(&rowID)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	todo, err := scanSQLTodo(tx.StmtContext(ctx, postgresDB.selectTodo).QueryRowContext(ctx, rowID))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.StmtContext(ctx, postgresDB.updateRow).ExecContext(ctx, append(sqlTodoArgs(todo.edited(fn)), rowID)...)
+	return err
+}
+
+func (postgresDB *PostgresDB) ReplaceAllTodos(ctx context.Context, todos []Todo) error {
+	return postgresDB.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.StmtContext(ctx, postgresDB.deleteTodos).ExecContext(ctx); err != nil {
+			return err
+		}
+
+		return postgresDB.insert(ctx, tx, todos)
+	})
+}
+
+func (postgresDB *PostgresDB) insert(ctx context.Context, tx *sql.Tx, todos []Todo) error {
+	stmt := tx.StmtContext(ctx, postgresDB.insertTodo)
+	for _, todo := range todos {
+		if _, err := stmt.ExecContext(ctx, sqlTodoArgs(todo)...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// inTx commits if fn succeeds, otherwise nothing of fn is kept.
+func (postgresDB *PostgresDB) inTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := postgresDB.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (postgresDB *PostgresDB) GetUsage(ctx context.Context) (Usage, error) {
+	var usage Usage
+	err := postgresDB.selectUsage.QueryRowContext(ctx).Scan(&usage.Todos, &usage.RawBytes, &usage.StoredBytes)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	hostname := getHostname()
+	postgresDB.metrics.storageRawBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.RawBytes))
+	postgresDB.metrics.storageStoredBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.StoredBytes))
+
+	return usage, nil
+}
+
+func (postgresDB *PostgresDB) GetHealthStatus(ctx context.Context) map[string]string {
+	result := map[string]string{"self": okString, "postgres": okString}
+	hostname := getHostname()
+
+	var serverVersion string
+	if err := postgresDB.checkVersion.QueryRowContext(ctx).Scan(&serverVersion); err != nil {
+		result["postgres"] = err.Error()
+		if ctx.Err() == nil {
+			postgresDB.metrics.postgresUp.WithLabelValues(hostname, buildinfo.Version).Set(0)
+		}
+	} else {
+		postgresDB.metrics.postgresUp.WithLabelValues(hostname, buildinfo.Version).Set(1)
+	}
+
+	stats := postgresDB.db.Stats()
+	connections := postgresDB.metrics.postgresConnections
+	connections.WithLabelValues(hostname, buildinfo.Version, "in_use").Set(float64(stats.InUse))
+	connections.WithLabelValues(hostname, buildinfo.Version, "idle").Set(float64(stats.Idle))
+
+	return result
+}
+
+// OpenConnections returns the connections of the pool, in use or idle.
+func (postgresDB *PostgresDB) OpenConnections() int64 {
+	return int64(postgresDB.db.Stats().OpenConnections)
+}
@@ -0,0 +1,21 @@
+package tododb
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func (postgresDB *PostgresDB) RegisterMetrics(registerer prometheus.Registerer) error {
+	m := postgresDB.metrics
+	err := register(registerer,
+		m.postgresUp,
+		m.postgresConnections,
+		m.storageRawBytes,
+		m.storageStoredBytes,
+	)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Registered Postgres Metrics")
+	return nil
+}
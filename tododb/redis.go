@@ -1,76 +1,1164 @@
 package tododb
 
 import (
-	"log"
+	"context"
+	"crypto/tls"
+	"fmt"
 	"math"
+	"net"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
 
-	redis "gopkg.in/redis.v5"
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/johscheuer/todo-app-web/features"
+	"github.com/redis/go-redis/v9"
 )
 
 type RedisDB struct {
-	master         string
-	masterPassword string
-	slave          string
-	slavePassword  string
-	appVersion     string
+	master redisEndpoint
+	slave  redisEndpoint
+	// masterPool and slavePool are shared by all calls, each keeps a pool
+	// of connections
+	masterPool *redis.Client
+	slavePool  *redis.Client
+	metrics    *Metrics
+
+	compressionThreshold int
+	infoInterval         time.Duration
 }
 
 const (
 	redisKey string = "todo"
 	okString string = "ok"
+
+	// priorityKey is a sorted set of the ids of the todos with a priority,
+	// scored by it
+	priorityKey string = "todo:priority"
+	// tagsKey is a set of the tags in use, tagKeyPrefix and the tag the key
+	// of the set of ids of the todos tagged with it. Names of tags that
+	// aren't in use anymore are removed by ListTags.
+	tagsKey      string = "todo:tags"
+	tagKeyPrefix string = "todo:tag:"
+	// termsKey is a set of the terms of the search index, termKeyPrefix and
+	// the term the key of the set of ids of the todos with it, see
+	// searchTerms. termsIndexedKey is set once the todos written before the
+	// index existed are in it.
+	termsKey        string = "todo:terms"
+	termKeyPrefix   string = "todo:term:"
+	termsIndexedKey string = "todo:terms:indexed"
+
+	usageKey          string = "todo:usage"
+	usageRawField     string = "raw"
+	usageStoredField  string = "stored"
+	usageCountedField string = "counted"
+
+	streamBatchSize int64 = 1000
+	// redisWatchAttempts is how often an update is tried when the list keeps
+	// changing under it
+	redisWatchAttempts = 5
+
+	defaultInfoIntervalSeconds = 30
+	defaultPoolSize            = 10
+	// Below the timeout of most Redis setups and load balancers
+	defaultIdleTimeoutSeconds = 240
 )
 
 var _ TodoDB = RedisDB{}
 
-func NewRedisDB(config map[string]string, appVersion string) RedisDB {
+func NewRedisDB(config map[string]string) (RedisDB, error) {
 	if _, exists := config["master"]; !exists {
 		config["master"] = "redis-master:6379"
 	}
 
-	if _, exists := config["masterPassword"]; !exists {
-		config["masterPassword"] = ""
-	}
-
 	if _, exists := config["slave"]; !exists {
 		config["slave"] = "redis-slave:6379"
 	}
 
-	if _, exists := config["slavePassword"]; !exists {
-		config["slavePassword"] = ""
+	master, err := parseRedisEndpoint(config["master"], config["masterPassword"])
+	if err != nil {
+		return RedisDB{}, err
+	}
+
+	slave, err := parseRedisEndpoint(config["slave"], config["slavePassword"])
+	if err != nil {
+		return RedisDB{}, err
+	}
+
+	metrics := NewMetrics()
+	pool := poolOptions{
+		size:        intConfig(config, "poolSize", defaultPoolSize),
+		idleTimeout: time.Duration(intConfig(config, "idleTimeout", defaultIdleTimeoutSeconds)) * time.Second,
+		maxRetries:  intConfig(config, "maxRetries", 0),
+		budget:      newRetryBudget(config, metrics),
 	}
 
 	return RedisDB{
-		master:         config["master"],
-		masterPassword: config["masterPassword"],
-		slave:          config["slave"],
-		slavePassword:  config["slavePassword"],
-		appVersion:     appVersion,
+		master:               master,
+		slave:                slave,
+		masterPool:           newPooledClient(master, pool),
+		slavePool:            newPooledClient(slave, pool),
+		metrics:              metrics,
+		compressionThreshold: intConfig(config, "compressionThreshold", 0),
+		infoInterval:         time.Duration(intConfig(config, "infoInterval", defaultInfoIntervalSeconds)) * time.Second,
+	}, nil
+}
+
+// intConfig reads an integer setting, invalid values are logged and replaced
+// by fallback.
+func intConfig(config map[string]string, key string, fallback int) int {
+	value, exists := config[key]
+	if !exists {
+		return fallback
+	}
+
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		logger.Warnf("Invalid %s %q, using %d: %v", key, value, fallback, err)
+		return fallback
 	}
+
+	return result
 }
 
-func createRedisClient(addr, password string) *(redis.Client) {
-	return redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-		DB:       0, // use default DB
-	})
+// poolOptions configure the shared clients with the DBConfig keys poolSize,
+// idleTimeout (seconds) and maxRetries. Both clients share the retry budget.
+type poolOptions struct {
+	size        int
+	idleTimeout time.Duration
+	maxRetries  int
+	budget      *retryBudget
 }
 
-func (redisDB RedisDB) GetAllTodos() ([]string, error) {
-	cmd := createRedisClient(redisDB.slave, redisDB.slavePassword).LRange(redisKey, 0, math.MaxInt64)
+func newPooledClient(endpoint redisEndpoint, pool poolOptions) *redis.Client {
+	options := redisOptions(endpoint)
+	options.PoolSize = pool.size
+	options.ConnMaxIdleTime = pool.idleTimeout
+
+	client := redis.NewClient(options)
+	if pool.maxRetries > 0 {
+		pool.budget.wrap(client, pool.maxRetries)
+	}
+
+	return client
+}
+
+// openClients counts clients created by createRedisClient that were not yet
+// closed with closeRedisClient. A steadily growing value is a leak.
+var openClients int64
+
+// createRedisClient is for single calls that need their own connection,
+// like checking every address of an endpoint. Everything else uses the
+// pooled clients of RedisDB.
+func createRedisClient(endpoint redisEndpoint) *(redis.Client) {
+	atomic.AddInt64(&openClients, 1)
+	options := redisOptions(endpoint)
+	options.PoolSize = 1
+
+	return redis.NewClient(options)
+}
+
+func redisOptions(endpoint redisEndpoint) *redis.Options {
+	return &redis.Options{
+		Addr:     endpoint.Addr(),
+		Password: endpoint.Password,
+		DB:       endpoint.DB,
+		// The retries are up to the retry budget, see retryBudget.wrap
+		MaxRetries: -1,
+		// A done context ends a command right away, its connection is
+		// closed instead of returned to the pool
+		ContextTimeoutEnabled: true,
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if err := drillError(endpoint.Addr()); err != nil {
+				return nil, err
+			}
+
+			conn, err := defaultResolver.dial(endpoint.Addr())
+			if err != nil || !endpoint.TLS {
+				return conn, err
+			}
+			return tls.Client(conn, &tls.Config{ServerName: endpoint.ServerName}), nil
+		},
+	}
+}
+
+func closeRedisClient(client *redis.Client) {
+	atomic.AddInt64(&openClients, -1)
+	if err := client.Close(); err != nil {
+		logger.Warnf("Closing Redis client: %v", err)
+	}
+}
+
+// OpenConnections returns the connections of the pools and of the clients
+// for single calls.
+func (redisDB RedisDB) OpenConnections() int64 {
+	pooled := redisDB.masterPool.PoolStats().TotalConns + redisDB.slavePool.PoolStats().TotalConns
+	return int64(pooled) + atomic.LoadInt64(&openClients)
+}
+
+// primary returns the pooled client of the master. Connections in the pool
+// outlive a failover drill, so it is checked here as well as when dialing.
+func (redisDB RedisDB) primary() (*redis.Client, error) {
+	if err := drillError(redisDB.master.Addr()); err != nil {
+		return nil, err
+	}
+
+	return redisDB.masterPool, nil
+}
+
+func (redisDB RedisDB) GetAllTodos(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	if features.Enabled(features.PerfNPlusOne) {
+		todos, err := redisDB.getAllTodosOneByOne(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return filterTodos(todos, filters), nil
+	}
+
+	cmd := redisDB.slavePool.LRange(ctx, redisKey, 0, math.MaxInt64)
+
+	// Fallback to read from master
+	if cmd.Err() != nil {
+		logger.Warnf("Fallback using Redis Master")
+		master, err := redisDB.primary()
+		if err != nil {
+			return nil, err
+		}
+		cmd = master.LRange(ctx, redisKey, 0, math.MaxInt64)
+	}
+
+	values, err := cmd.Result()
+	if err != nil {
+		return nil, err
+	}
+
+	todos := make([]Todo, len(values))
+	for i, value := range values {
+		todos[i] = unmarshalTodo(decompressValue(value))
+	}
+	logger.Debugf("Read %d todos", len(todos))
+	return filterTodos(todos, filters), nil
+}
+
+// GetTodos reads the page with a single LRANGE, unless filters are given.
+func (redisDB RedisDB) GetTodos(ctx context.Context, offset, limit int, filters ...TodoFilter) ([]Todo, error) {
+	if len(filters) > 0 || features.Enabled(features.PerfNPlusOne) {
+		return pageTodos(ctx, redisDB.ForEachTodo, offset, limit, filters)
+	}
+	if err := checkPage(offset, limit); err != nil {
+		return nil, err
+	}
+
+	start, stop := int64(offset), int64(offset+limit-1)
+	cmd := redisDB.slavePool.LRange(ctx, redisKey, start, stop)
+
+	// Fallback to read from master
+	if cmd.Err() != nil {
+		logger.Warnf("Fallback using Redis Master")
+		master, err := redisDB.primary()
+		if err != nil {
+			return nil, err
+		}
+		cmd = master.LRange(ctx, redisKey, start, stop)
+	}
+
+	values, err := cmd.Result()
+	if err != nil {
+		return nil, err
+	}
+
+	todos := make([]Todo, len(values))
+	for i, value := range values {
+		todos[i] = unmarshalTodo(decompressValue(value))
+	}
+
+	return todos, nil
+}
+
+func (redisDB RedisDB) CountTodos(ctx context.Context, filters ...TodoFilter) (int, error) {
+	if len(filters) > 0 {
+		return countTodos(ctx, redisDB.ForEachTodo, filters)
+	}
+
+	cmd := redisDB.slavePool.LLen(ctx, redisKey)
 
 	// Fallback to read from master
 	if cmd.Err() != nil {
-		log.Println("Fallback using Redis Master")
-		cmd = createRedisClient(redisDB.master, redisDB.masterPassword).LRange(redisKey, 0, math.MaxInt64)
+		logger.Warnf("Fallback using Redis Master")
+		master, err := redisDB.primary()
+		if err != nil {
+			return 0, err
+		}
+		cmd = master.LLen(ctx, redisKey)
+	}
+
+	count, err := cmd.Result()
+	return int(count), err
+}
+
+func (redisDB RedisDB) GetOverdueTodos(ctx context.Context) ([]Todo, error) {
+	return todosByDue(ctx, redisDB.GetAllTodos, overdueFilter())
+}
+
+func (redisDB RedisDB) GetTodosDueBefore(ctx context.Context, t time.Time) ([]Todo, error) {
+	return todosByDue(ctx, redisDB.GetAllTodos, TodoFilter{DueBefore: t})
+}
+
+// GetTodosByPriority orders the list by the scores of the priority set.
+// Both are read in one transaction, so they match.
+func (redisDB RedisDB) GetTodosByPriority(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	var values []string
+	var priorities []redis.Z
+	read := func(client *redis.Client) error {
+		var list *redis.StringSliceCmd
+		var set *redis.ZSliceCmd
+		_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			list = pipe.LRange(ctx, redisKey, 0, math.MaxInt64)
+			set = pipe.ZRevRangeWithScores(ctx, priorityKey, 0, -1)
+			return nil
+		})
+		values, priorities = list.Val(), set.Val()
+		return err
+	}
+
+	if err := read(redisDB.slavePool); err != nil {
+		// Fallback to read from master
+		logger.Warnf("Fallback using Redis Master")
+		master, err := redisDB.primary()
+		if err != nil {
+			return nil, err
+		}
+		if err := read(master); err != nil {
+			return nil, err
+		}
+	}
+
+	scores := make(map[string]float64, len(priorities))
+	for _, priority := range priorities {
+		scores[fmt.Sprint(priority.Member)] = priority.Score
+	}
+
+	todos := make([]Todo, len(values))
+	for i, value := range values {
+		todos[i] = unmarshalTodo(decompressValue(value))
 	}
-	return cmd.Val(), cmd.Err()
+	todos = filterTodos(todos, filters)
+	if len(scores) > 0 {
+		sort.SliceStable(todos, func(i, j int) bool {
+			return scores[todos[i].ID] > scores[todos[j].ID]
+		})
+	}
+
+	return todos, nil
 }
 
-func (redisDB RedisDB) SaveTodo(todo string) error {
-	return createRedisClient(redisDB.master, redisDB.masterPassword).RPush(redisKey, todo).Err()
+// GetTodosByTag reads the set of the tag together with the list, in one
+// transaction so they match.
+func (redisDB RedisDB) GetTodosByTag(ctx context.Context, tag string) ([]Todo, error) {
+	var values, ids []string
+	read := func(client *redis.Client) error {
+		var list, set *redis.StringSliceCmd
+		_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			list = pipe.LRange(ctx, redisKey, 0, math.MaxInt64)
+			set = pipe.SMembers(ctx, tagKey(NormalizeTag(tag)))
+			return nil
+		})
+		values, ids = list.Val(), set.Val()
+		return err
+	}
+
+	if err := read(redisDB.slavePool); err != nil {
+		// Fallback to read from master
+		logger.Warnf("Fallback using Redis Master")
+		master, err := redisDB.primary()
+		if err != nil {
+			return nil, err
+		}
+		if err := read(master); err != nil {
+			return nil, err
+		}
+	}
+
+	tagged := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		tagged[id] = true
+	}
+
+	todos := []Todo{}
+	if len(tagged) == 0 {
+		return todos, nil
+	}
+	for _, value := range values {
+		// A todo of an older version can share the id of a tagged one
+		if todo := unmarshalTodo(decompressValue(value)); tagged[todo.ID] && todo.HasTag(NormalizeTag(tag)) {
+			todos = append(todos, todo)
+		}
+	}
+
+	return todos, nil
 }
 
-func (redisDB RedisDB) DeleteTodo(todo string) error {
-	return createRedisClient(redisDB.master, redisDB.masterPassword).LRem(redisKey, 1, todo).Err()
+// ListTags counts the members of the set of every tag in the set of tags.
+// The sets are watched while tags without todos are removed from it, so a
+// tag that is used again in between stays.
+func (redisDB RedisDB) ListTags(ctx context.Context) ([]Tag, error) {
+	client, err := redisDB.primary()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	names, err := client.SMembers(ctx, tagsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return sortedTags(counts), nil
+	}
+
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = tagKey(name)
+	}
+
+	err = client.Watch(ctx, func(tx *redis.Tx) error {
+		// Not pipelined, EXEC would end the WATCH
+		unused := []interface{}{}
+		for i, name := range names {
+			count, err := tx.SCard(ctx, keys[i]).Result()
+			if err != nil {
+				return err
+			}
+			counts[name] = int(count)
+			if count == 0 {
+				unused = append(unused, name)
+			}
+		}
+		if len(unused) == 0 {
+			return nil
+		}
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SRem(ctx, tagsKey, unused...)
+			return nil
+		})
+		return err
+	}, keys...)
+	// With TxFailedErr the counts are still right, the unused tags are
+	// removed the next time
+	if err != nil && err != redis.TxFailedErr {
+		return nil, err
+	}
+
+	return sortedTags(counts), nil
+}
+
+// SearchTodos intersects the sets of the terms of the query and reads the
+// list in the same transaction. Lists written before the index existed are
+// indexed at the first search.
+func (redisDB RedisDB) SearchTodos(ctx context.Context, query string) ([]Todo, error) {
+	words := SearchWords(query)
+	if len(words) == 0 {
+		return nil, ErrEmptyQuery
+	}
+	keys := make([]string, len(words))
+	for i, word := range words {
+		keys[i] = termKey(queryTerm(word))
+	}
+
+	client, err := redisDB.primary()
+	if err != nil {
+		return nil, err
+	}
+	if err := redisDB.indexExistingTerms(ctx, client); err != nil {
+		return nil, err
+	}
+
+	var values, ids []string
+	read := func(client *redis.Client) error {
+		var list, set *redis.StringSliceCmd
+		_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			list = pipe.LRange(ctx, redisKey, 0, math.MaxInt64)
+			set = pipe.SInter(ctx, keys...)
+			return nil
+		})
+		values, ids = list.Val(), set.Val()
+		return err
+	}
+	if err := read(redisDB.slavePool); err != nil {
+		// Fallback to read from master
+		logger.Warnf("Fallback using Redis Master")
+		if err := read(client); err != nil {
+			return nil, err
+		}
+	}
+
+	found := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		found[id] = true
+	}
+
+	todos := []Todo{}
+	if len(found) == 0 {
+		return todos, nil
+	}
+	for _, value := range values {
+		// The terms only tell the first letters of long words apart
+		if todo := unmarshalTodo(decompressValue(value)); found[todo.ID] && matchesQuery(todo, query) {
+			todos = append(todos, todo)
+		}
+	}
+
+	return todos, nil
+}
+
+// indexExistingTerms adds the todos of the list to the search index once,
+// the list is watched so no todo added in between is missed.
+func (redisDB RedisDB) indexExistingTerms(ctx context.Context, client *redis.Client) error {
+	indexed, err := client.Exists(ctx, termsIndexedKey).Result()
+	if err != nil || indexed > 0 {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := client.Watch(ctx, func(tx *redis.Tx) error {
+			values, err := tx.LRange(ctx, redisKey, 0, math.MaxInt64).Result()
+			if err != nil {
+				return err
+			}
+
+			todos := make([]Todo, len(values))
+			for i, value := range values {
+				todos[i] = unmarshalTodo(decompressValue(value))
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				indexTerms(ctx, pipe, todos)
+				pipe.Set(ctx, termsIndexedKey, "1", 0)
+				return nil
+			})
+			return err
+		}, redisKey)
+		if err != redis.TxFailedErr || attempt >= redisWatchAttempts {
+			if err == nil {
+				logger.Infof("Indexed the words of the todos for the search")
+			}
+			return err
+		}
+		logger.Debugf("Todo list changed during indexing, attempt %d", attempt)
+	}
+}
+
+// getAllTodosOneByOne is the deliberately slow variant of GetAllTodos with a
+// round trip for every single todo.
+func (redisDB RedisDB) getAllTodosOneByOne(ctx context.Context) ([]Todo, error) {
+	client := redisDB.slavePool
+
+	count, err := client.LLen(ctx, redisKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	todos := make([]Todo, 0, count)
+	for i := int64(0); i < count; i++ {
+		value, err := client.LIndex(ctx, redisKey, i).Result()
+		if err == redis.Nil {
+			// The list got shorter in the meantime
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		todos = append(todos, unmarshalTodo(decompressValue(value)))
+	}
+
+	return todos, nil
+}
+
+// ForEachTodo walks the list in batches so large lists can be streamed
+// without holding all of them in memory.
+func (redisDB RedisDB) ForEachTodo(ctx context.Context, fn func(Todo) error) error {
+	client := redisDB.slavePool
+
+	// Fallback to read from master
+	if err := client.Ping(ctx).Err(); err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		logger.Warnf("Fallback using Redis Master")
+		if client, err = redisDB.primary(); err != nil {
+			return err
+		}
+	}
+
+	for start := int64(0); ; start += streamBatchSize {
+		values, err := client.LRange(ctx, redisKey, start, start+streamBatchSize-1).Result()
+		if err != nil {
+			return err
+		}
+
+		for _, value := range values {
+			if err := fn(unmarshalTodo(decompressValue(value))); err != nil {
+				return err
+			}
+		}
+
+		if int64(len(values)) < streamBatchSize {
+			return nil
+		}
+	}
+}
+
+func (redisDB RedisDB) SaveTodo(ctx context.Context, todo Todo) error {
+	return redisDB.SaveTodos(ctx, []Todo{todo})
+}
+
+// SaveTodos appends all todos with a single RPUSH round trip.
+func (redisDB RedisDB) SaveTodos(ctx context.Context, todos []Todo) error {
+	if len(todos) == 0 {
+		return nil
+	}
+
+	todos = withDefaults(todos)
+	values, rawBytes, storedBytes := redisDB.encode(todos)
+
+	client, err := redisDB.primary()
+	if err != nil {
+		return err
+	}
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, redisKey, values...)
+		indexPriorities(ctx, pipe, todos)
+		indexTags(ctx, pipe, todos)
+		indexTerms(ctx, pipe, todos)
+		pipe.HIncrBy(ctx, usageKey, usageRawField, rawBytes)
+		pipe.HIncrBy(ctx, usageKey, usageStoredField, storedBytes)
+		return nil
+	})
+	logger.Debugf("Saved %d todos (%d bytes raw, %d bytes stored)", len(todos), rawBytes, storedBytes)
+	return err
+}
+
+// DeleteTodo removes the todo with id in one transaction, like DeleteTodos.
+func (redisDB RedisDB) DeleteTodo(ctx context.Context, id string) error {
+	return redisDB.DeleteTodos(ctx, []string{id})
+}
+
+// UpdateTodo sets the title of the todo with id in place with LSET. The list
+// is watched, the update starts over if it changed in between.
+func (redisDB RedisDB) UpdateTodo(ctx context.Context, id string, update func(*Todo)) error {
+	return redisDB.updateTodo(ctx, id, update)
+}
+
+func (redisDB RedisDB) CompleteTodo(ctx context.Context, id string) error {
+	return redisDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = true
+	})
+}
+
+func (redisDB RedisDB) ReopenTodo(ctx context.Context, id string) error {
+	return redisDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = false
+	})
+}
+
+func (redisDB RedisDB) SetDescription(ctx context.Context, id string, description string) error {
+	return redisDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Description = description
+	})
+}
+
+func (redisDB RedisDB) SetDue(ctx context.Context, id string, due *time.Time) error {
+	return redisDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Due = due
+	})
+}
+
+func (redisDB RedisDB) SetPriority(ctx context.Context, id string, priority int) error {
+	return redisDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Priority = priority
+	})
+}
+
+func (redisDB RedisDB) SetTags(ctx context.Context, id string, tags []string) error {
+	return redisDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Tags = tags
+	})
+}
+
+func (redisDB RedisDB) SetRecurrence(ctx context.Context, id string, recurrence string) error {
+	return redisDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Recurrence = recurrence
+	})
+}
+
+func (redisDB RedisDB) AddSubTask(ctx context.Context, id string, title string) (SubTask, error) {
+	var subTask SubTask
+	err := redisDB.updateTodo(ctx, id, func(todo *Todo) {
+		subTask = todo.addSubTask(title)
+	})
+
+	return subTask, err
+}
+
+func (redisDB RedisDB) CompleteSubTask(ctx context.Context, id string, subTaskID string) error {
+	found := false
+	err := redisDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.completeSubTask(subTaskID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (redisDB RedisDB) GetSubTasks(ctx context.Context, id string) ([]SubTask, error) {
+	return subTasksOf(ctx, redisDB.ForEachTodo, id)
+}
+
+func (redisDB RedisDB) AddAttachment(ctx context.Context, id string, attachment Attachment) error {
+	return redisDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.addAttachment(attachment)
+	})
+}
+
+func (redisDB RedisDB) RemoveAttachment(ctx context.Context, id string, attachmentID string) error {
+	found := false
+	err := redisDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.removeAttachment(attachmentID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// MoveTodo sets the values from the old to the new position of the todo
+// with LSET, the list is its order. The list is watched, the move starts
+// over if it changed in between.
+func (redisDB RedisDB) MoveTodo(ctx context.Context, id string, position int) error {
+	client, err := redisDB.primary()
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := client.Watch(ctx, func(tx *redis.Tx) error {
+			values, err := tx.LRange(ctx, redisKey, 0, math.MaxInt64).Result()
+			if err != nil {
+				return err
+			}
+
+			todos := make([]Todo, len(values))
+			for i, value := range values {
+				todos[i] = unmarshalTodo(decompressValue(value))
+			}
+			order, err := moveOrder(todos, id, position)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				for i, old := range order {
+					if old != i {
+						pipe.LSet(ctx, redisKey, int64(i), values[old])
+					}
+				}
+				return nil
+			})
+			return err
+		}, redisKey)
+		if err != redis.TxFailedErr || attempt >= redisWatchAttempts {
+			return err
+		}
+		logger.Debugf("Todo list changed during move, attempt %d", attempt)
+	}
+}
+
+func (redisDB RedisDB) updateTodo(ctx context.Context, id string, fn func(*Todo)) error {
+	client, err := redisDB.primary()
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := client.Watch(ctx, func(tx *redis.Tx) error {
+			index, stored, raw, err := findRedisTodo(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				redisDB.setTodo(ctx, pipe, index, stored, raw, fn)
+				return nil
+			})
+			return err
+		}, redisKey, priorityKey)
+		if err != redis.TxFailedErr || attempt >= redisWatchAttempts {
+			return err
+		}
+		logger.Debugf("Todo list changed during update, attempt %d", attempt)
+	}
+}
+
+// setTodo replaces the todo at index, with the value stored and raw
+// decompressed, by the todo changed by fn, and keeps the indexes and the
+// usage in line.
+func (redisDB RedisDB) setTodo(ctx context.Context, pipe redis.Pipeliner, index int64, stored, raw string, fn func(*Todo)) {
+	existing := unmarshalTodo(raw)
+	todo := existing.edited(fn)
+	values, rawBytes, storedBytes := redisDB.encode([]Todo{todo})
+	pipe.LSet(ctx, redisKey, index, values[0])
+	if todo.Priority == PriorityNone {
+		pipe.ZRem(ctx, priorityKey, todo.ID)
+	}
+	indexPriorities(ctx, pipe, []Todo{todo})
+	unindexTags(ctx, pipe, todo.ID, without(existing.Tags, todo.Tags))
+	indexTags(ctx, pipe, []Todo{todo})
+	unindexTerms(ctx, pipe, todo.ID, without(searchTerms(existing), searchTerms(todo)))
+	indexTerms(ctx, pipe, []Todo{todo})
+	pipe.HIncrBy(ctx, usageKey, usageRawField, rawBytes-int64(len(raw)))
+	pipe.HIncrBy(ctx, usageKey, usageStoredField, storedBytes-int64(len(stored)))
+}
+
+// redisDeletedMarker takes the place of the todos removed by DeleteTodos
+// until they are all removed at once.
+const redisDeletedMarker = "\x00deleted"
+
+// DeleteTodos removes the todos in one transaction, the list is read once
+// and watched, the delete starts over if it changed in between.
+func (redisDB RedisDB) DeleteTodos(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return redisDB.batchTodos(ctx, ids, "delete", func(pipe redis.Pipeliner, index int64, stored, raw string) {
+		// The value is replaced by a marker first, LREM of the value itself
+		// could remove an identical todo of older versions instead
+		pipe.LSet(ctx, redisKey, index, redisDeletedMarker)
+		deleted := unmarshalTodo(raw)
+		pipe.ZRem(ctx, priorityKey, deleted.ID)
+		unindexTags(ctx, pipe, deleted.ID, deleted.Tags)
+		unindexTerms(ctx, pipe, deleted.ID, searchTerms(deleted))
+		pipe.HIncrBy(ctx, usageKey, usageRawField, -int64(len(raw)))
+		pipe.HIncrBy(ctx, usageKey, usageStoredField, -int64(len(stored)))
+	}, func(pipe redis.Pipeliner) {
+		pipe.LRem(ctx, redisKey, 0, redisDeletedMarker)
+	})
+}
+
+// UpdateTodos sets the changed todos with LSET in one transaction, like
+// DeleteTodos, and keeps the indexes in line like UpdateTodo.
+func (redisDB RedisDB) UpdateTodos(ctx context.Context, ids []string, update func(*Todo)) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return redisDB.batchTodos(ctx, ids, "update", func(pipe redis.Pipeliner, index int64, stored, raw string) {
+		redisDB.setTodo(ctx, pipe, index, stored, raw, update)
+	}, nil)
+}
+
+// CompleteTodos sets the done todos with LSET in one transaction, like
+// DeleteTodos.
+func (redisDB RedisDB) CompleteTodos(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return redisDB.batchTodos(ctx, ids, "complete", func(pipe redis.Pipeliner, index int64, stored, raw string) {
+		todo := unmarshalTodo(raw).edited(func(todo *Todo) {
+			todo.Done = true
+		})
+		values, rawBytes, storedBytes := redisDB.encode([]Todo{todo})
+		pipe.LSet(ctx, redisKey, index, values[0])
+		pipe.HIncrBy(ctx, usageKey, usageRawField, rawBytes-int64(len(raw)))
+		pipe.HIncrBy(ctx, usageKey, usageStoredField, storedBytes-int64(len(stored)))
+	}, nil)
+}
+
+// batchTodos reads the list once and calls fn in one MULTI for the first
+// todo of each of ids, then done if it isn't nil. The list is watched, the batch starts over
+// if it changed in between.
+func (redisDB RedisDB) batchTodos(ctx context.Context, ids []string, action string, fn func(pipe redis.Pipeliner, index int64, stored, raw string), done func(pipe redis.Pipeliner)) error {
+	client, err := redisDB.primary()
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := client.Watch(ctx, func(tx *redis.Tx) error {
+			values, err := tx.LRange(ctx, redisKey, 0, math.MaxInt64).Result()
+			if err != nil {
+				return err
+			}
+
+			wanted := idSet(ids)
+			matched := 0
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				for i, value := range values {
+					raw := decompressValue(value)
+					if id := unmarshalTodo(raw).ID; wanted[id] {
+						delete(wanted, id)
+						matched++
+						fn(pipe, int64(i), value, raw)
+					}
+				}
+				if done != nil {
+					done(pipe)
+				}
+				return nil
+			})
+			logger.Debugf("Batch %s of %d todos", action, matched)
+			return err
+		}, redisKey, priorityKey)
+		if err != redis.TxFailedErr || attempt >= redisWatchAttempts {
+			return err
+		}
+		logger.Debugf("Todo list changed during batch %s, attempt %d", action, attempt)
+	}
+}
+
+// findRedisTodo returns the index of the first todo with id in the list,
+// its stored value and the value decompressed.
+func findRedisTodo(ctx context.Context, client interface {
+	LRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd
+}, id string) (int64, string, string, error) {
+	for start := int64(0); ; start += streamBatchSize {
+		values, err := client.LRange(ctx, redisKey, start, start+streamBatchSize-1).Result()
+		if err != nil {
+			return 0, "", "", err
+		}
+		for i, value := range values {
+			if decoded := decompressValue(value); unmarshalTodo(decoded).ID == id {
+				return start + int64(i), value, decoded, nil
+			}
+		}
+		if int64(len(values)) < streamBatchSize {
+			return 0, "", "", ErrNotFound
+		}
+	}
+}
+
+// ReplaceAllTodos swaps the whole list in one transaction and resets the
+// usage counters to match the new content. The sets of the tags and terms
+// are watched so none is left behind.
+func (redisDB RedisDB) ReplaceAllTodos(ctx context.Context, todos []Todo) error {
+	todos = withDefaults(todos)
+	values, rawBytes, storedBytes := redisDB.encode(todos)
+
+	client, err := redisDB.primary()
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := client.Watch(ctx, func(tx *redis.Tx) error {
+			names, err := tx.SMembers(ctx, tagsKey).Result()
+			if err != nil {
+				return err
+			}
+			terms, err := tx.SMembers(ctx, termsKey).Result()
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				keys := []string{redisKey, priorityKey, tagsKey, termsKey}
+				for _, name := range names {
+					keys = append(keys, tagKey(name))
+				}
+				for _, term := range terms {
+					keys = append(keys, termKey(term))
+				}
+				pipe.Del(ctx, keys...)
+				if len(values) > 0 {
+					pipe.RPush(ctx, redisKey, values...)
+				}
+				indexPriorities(ctx, pipe, todos)
+				indexTags(ctx, pipe, todos)
+				indexTerms(ctx, pipe, todos)
+				pipe.Set(ctx, termsIndexedKey, "1", 0)
+				pipe.HSet(ctx, usageKey, usageRawField, strconv.FormatInt(rawBytes, 10))
+				pipe.HSet(ctx, usageKey, usageStoredField, strconv.FormatInt(storedBytes, 10))
+				pipe.HSet(ctx, usageKey, usageCountedField, "1")
+				return nil
+			})
+			return err
+		}, tagsKey, termsKey)
+		if err != redis.TxFailedErr || attempt >= redisWatchAttempts {
+			return err
+		}
+		logger.Debugf("Tags or terms changed during replace, attempt %d", attempt)
+	}
+}
+
+// indexPriorities adds the todos with a priority to the priority set.
+func indexPriorities(ctx context.Context, pipe redis.Pipeliner, todos []Todo) {
+	members := []redis.Z{}
+	for _, todo := range todos {
+		if todo.Priority != PriorityNone {
+			members = append(members, redis.Z{Score: float64(todo.Priority), Member: todo.ID})
+		}
+	}
+	if len(members) > 0 {
+		pipe.ZAdd(ctx, priorityKey, members...)
+	}
+}
+
+func tagKey(tag string) string {
+	return tagKeyPrefix + tag
+}
+
+// indexTags adds the todos to the sets of their tags.
+func indexTags(ctx context.Context, pipe redis.Pipeliner, todos []Todo) {
+	names := []interface{}{}
+	for _, todo := range todos {
+		for _, tag := range todo.Tags {
+			pipe.SAdd(ctx, tagKey(tag), todo.ID)
+			names = append(names, tag)
+		}
+	}
+	if len(names) > 0 {
+		pipe.SAdd(ctx, tagsKey, names...)
+	}
+}
+
+// unindexTags removes the todo with id from the sets of tags.
+func unindexTags(ctx context.Context, pipe redis.Pipeliner, id string, tags []string) {
+	for _, tag := range tags {
+		pipe.SRem(ctx, tagKey(tag), id)
+	}
+}
+
+func termKey(term string) string {
+	return termKeyPrefix + term
+}
+
+// indexTerms adds the todos to the sets of their search terms.
+func indexTerms(ctx context.Context, pipe redis.Pipeliner, todos []Todo) {
+	terms := []interface{}{}
+	for _, todo := range todos {
+		for _, term := range searchTerms(todo) {
+			pipe.SAdd(ctx, termKey(term), todo.ID)
+			terms = append(terms, term)
+		}
+	}
+	if len(terms) > 0 {
+		pipe.SAdd(ctx, termsKey, terms...)
+	}
+}
+
+// unindexTerms removes the todo with id from the sets of terms.
+func unindexTerms(ctx context.Context, pipe redis.Pipeliner, id string, terms []string) {
+	for _, term := range terms {
+		pipe.SRem(ctx, termKey(term), id)
+	}
+}
+
+// without returns the values that aren't in removed.
+func without(values, removed []string) []string {
+	kept := []string{}
+	for _, value := range values {
+		found := false
+		for _, r := range removed {
+			found = found || r == value
+		}
+		if !found {
+			kept = append(kept, value)
+		}
+	}
+
+	return kept
+}
+
+// encode returns the list values of todos, JSON documents that are
+// compressed above the threshold, and their size before and after that.
+func (redisDB RedisDB) encode(todos []Todo) ([]interface{}, int64, int64) {
+	var rawBytes, storedBytes int64
+	values := make([]interface{}, len(todos))
+	for i, todo := range todos {
+		raw := marshalTodo(todo.withDefaults())
+		stored := compressValue(raw, redisDB.compressionThreshold)
+		values[i] = stored
+		rawBytes += int64(len(raw))
+		storedBytes += int64(len(stored))
+	}
+
+	return values, rawBytes, storedBytes
+}
+
+// GetUsage returns the byte counters kept up to date by every write. Lists
+// that were written before the counters existed get counted once in full.
+func (redisDB RedisDB) GetUsage(ctx context.Context) (Usage, error) {
+	client, err := redisDB.primary()
+	if err != nil {
+		return Usage{}, err
+	}
+
+	counters, err := client.HGetAll(ctx, usageKey).Result()
+	if err != nil {
+		return Usage{}, err
+	}
+
+	if _, counted := counters[usageCountedField]; !counted {
+		if counters, err = recountUsage(ctx, client); err != nil {
+			return Usage{}, err
+		}
+	}
+
+	count, err := client.LLen(ctx, redisKey).Result()
+	if err != nil {
+		return Usage{}, err
+	}
+
+	usage := Usage{Todos: count}
+	usage.RawBytes, _ = strconv.ParseInt(counters[usageRawField], 10, 64)
+	usage.StoredBytes, _ = strconv.ParseInt(counters[usageStoredField], 10, 64)
+
+	hostname := getHostname()
+	redisDB.metrics.storageRawBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.RawBytes))
+	redisDB.metrics.storageStoredBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.StoredBytes))
+
+	return usage, nil
+}
+
+// GetAccountUsage counts the todos of account, their stored size is the one
+// of the current compressionThreshold.
+func (redisDB RedisDB) GetAccountUsage(ctx context.Context, account string) (Usage, error) {
+	return accountUsage(ctx, redisDB.ForEachTodo, account, redisDB.compressionThreshold)
+}
+
+func recountUsage(ctx context.Context, client *redis.Client) (map[string]string, error) {
+	var rawBytes, storedBytes int64
+	for start := int64(0); ; start += streamBatchSize {
+		todos, err := client.LRange(ctx, redisKey, start, start+streamBatchSize-1).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, stored := range todos {
+			rawBytes += int64(len(decompressValue(stored)))
+			storedBytes += int64(len(stored))
+		}
+
+		if int64(len(todos)) < streamBatchSize {
+			break
+		}
+	}
+
+	counters := map[string]string{
+		usageRawField:     strconv.FormatInt(rawBytes, 10),
+		usageStoredField:  strconv.FormatInt(storedBytes, 10),
+		usageCountedField: "1",
+	}
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for field, value := range counters {
+			pipe.HSet(ctx, usageKey, field, value)
+		}
+		return nil
+	})
+
+	return counters, err
 }
@@ -0,0 +1,510 @@
+package tododb
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultRedisClusterNodes = "redis-cluster:6379"
+
+	// The keys of the todos share the hash tag {todo}, so they are in one
+	// slot and a MULTI or WATCH can span them. The values of KV are spread
+	// over the cluster.
+	clusterListKey  string = "{todo}:list"
+	clusterUsageKey string = "{todo}:usage"
+)
+
+// RedisClusterDB keeps the todos in a list like RedisDB, in a sharded Redis
+// Cluster. The client learns the nodes and slots from the seed nodes and
+// follows MOVED and ASK redirects, reads go to the replicas with
+// readFromReplicas.
+type RedisClusterDB struct {
+	seeds    []string
+	password string
+	client   *redis.ClusterClient
+	metrics  *Metrics
+
+	compressionThreshold int
+}
+
+var _ TodoDB = RedisClusterDB{}
+
+func NewRedisClusterDB(config map[string]string) (RedisClusterDB, error) {
+	nodes := defaultRedisClusterNodes
+	if value, exists := config["nodes"]; exists {
+		nodes = value
+	}
+
+	seeds := []string{}
+	for _, node := range strings.Split(nodes, ",") {
+		node = strings.TrimSpace(node)
+		if node == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(node); err != nil {
+			return RedisClusterDB{}, fmt.Errorf("redis cluster node %q: %v", node, err)
+		}
+		seeds = append(seeds, node)
+	}
+	if len(seeds) == 0 {
+		return RedisClusterDB{}, fmt.Errorf("redis cluster needs at least one node in nodes")
+	}
+
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:        seeds,
+		Password:     config["password"],
+		MaxRedirects: intConfig(config, "maxRedirects", 0),
+		ReadOnly:     config["readFromReplicas"] == "true",
+		PoolSize:     intConfig(config, "poolSize", defaultPoolSize),
+		// Failed commands aren't retried
+		MaxRetries:            -1,
+		ConnMaxIdleTime:       time.Duration(intConfig(config, "idleTimeout", defaultIdleTimeoutSeconds)) * time.Second,
+		ContextTimeoutEnabled: true,
+	})
+
+	return RedisClusterDB{
+		seeds:                seeds,
+		password:             config["password"],
+		client:               client,
+		metrics:              NewMetrics(),
+		compressionThreshold: intConfig(config, "compressionThreshold", 0),
+	}, nil
+}
+
+// OpenConnections returns the connections of the pools of all nodes and of
+// the clients of the health checks.
+func (clusterDB RedisClusterDB) OpenConnections() int64 {
+	return int64(clusterDB.client.PoolStats().TotalConns) + atomic.LoadInt64(&openClients)
+}
+
+func decodeTodos(values []string) []Todo {
+	todos := make([]Todo, len(values))
+	for i, value := range values {
+		todos[i] = unmarshalTodo(decompressValue(value))
+	}
+
+	return todos
+}
+
+// encode returns the list values of todos like RedisDB and their size
+// before and after compression.
+func (clusterDB RedisClusterDB) encode(todos []Todo) ([]interface{}, int64, int64) {
+	var rawBytes, storedBytes int64
+	values := make([]interface{}, len(todos))
+	for i, todo := range todos {
+		raw := marshalTodo(todo.withDefaults())
+		stored := compressValue(raw, clusterDB.compressionThreshold)
+		values[i] = stored
+		rawBytes += int64(len(raw))
+		storedBytes += int64(len(stored))
+	}
+
+	return values, rawBytes, storedBytes
+}
+
+// lrange reads a range of the list, from a replica with readFromReplicas.
+func (clusterDB RedisClusterDB) lrange(ctx context.Context, start, stop int64) ([]string, error) {
+	return clusterDB.client.LRange(ctx, clusterListKey, start, stop).Result()
+}
+
+func (clusterDB RedisClusterDB) GetAllTodos(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	values, err := clusterDB.lrange(ctx, 0, math.MaxInt64)
+	if err != nil {
+		return nil, err
+	}
+
+	return filterTodos(decodeTodos(values), filters), nil
+}
+
+// GetTodos reads the page with a single LRANGE, unless filters are given.
+func (clusterDB RedisClusterDB) GetTodos(ctx context.Context, offset, limit int, filters ...TodoFilter) ([]Todo, error) {
+	if len(filters) > 0 {
+		return pageTodos(ctx, clusterDB.ForEachTodo, offset, limit, filters)
+	}
+	if err := checkPage(offset, limit); err != nil {
+		return nil, err
+	}
+
+	values, err := clusterDB.lrange(ctx, int64(offset), int64(offset+limit-1))
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeTodos(values), nil
+}
+
+func (clusterDB RedisClusterDB) CountTodos(ctx context.Context, filters ...TodoFilter) (int, error) {
+	if len(filters) > 0 {
+		return countTodos(ctx, clusterDB.ForEachTodo, filters)
+	}
+
+	count, err := clusterDB.client.LLen(ctx, clusterListKey).Result()
+	return int(count), err
+}
+
+// ForEachTodo walks the list in batches like RedisDB.
+func (clusterDB RedisClusterDB) ForEachTodo(ctx context.Context, fn func(Todo) error) error {
+	for start := int64(0); ; start += streamBatchSize {
+		values, err := clusterDB.lrange(ctx, start, start+streamBatchSize-1)
+		if err != nil {
+			return err
+		}
+
+		for _, todo := range decodeTodos(values) {
+			if err := fn(todo); err != nil {
+				return err
+			}
+		}
+
+		if int64(len(values)) < streamBatchSize {
+			return nil
+		}
+	}
+}
+
+func (clusterDB RedisClusterDB) GetOverdueTodos(ctx context.Context) ([]Todo, error) {
+	return todosByDue(ctx, clusterDB.GetAllTodos, overdueFilter())
+}
+
+func (clusterDB RedisClusterDB) GetTodosDueBefore(ctx context.Context, t time.Time) ([]Todo, error) {
+	return todosByDue(ctx, clusterDB.GetAllTodos, TodoFilter{DueBefore: t})
+}
+
+func (clusterDB RedisClusterDB) GetTodosByPriority(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	return todosByPriority(ctx, clusterDB.GetAllTodos, filters)
+}
+
+func (clusterDB RedisClusterDB) GetTodosByTag(ctx context.Context, tag string) ([]Todo, error) {
+	return todosByTag(ctx, clusterDB.GetAllTodos, tag)
+}
+
+func (clusterDB RedisClusterDB) ListTags(ctx context.Context) ([]Tag, error) {
+	return CountTags(ctx, clusterDB.ForEachTodo)
+}
+
+func (clusterDB RedisClusterDB) SearchTodos(ctx context.Context, query string) ([]Todo, error) {
+	return searchTodos(ctx, clusterDB.GetAllTodos, query)
+}
+
+func (clusterDB RedisClusterDB) SaveTodo(ctx context.Context, todo Todo) error {
+	return clusterDB.SaveTodos(ctx, []Todo{todo})
+}
+
+// SaveTodos appends all todos and counts their bytes in one transaction,
+// the hash tag keeps list and counters on the same node.
+func (clusterDB RedisClusterDB) SaveTodos(ctx context.Context, todos []Todo) error {
+	if len(todos) == 0 {
+		return nil
+	}
+
+	values, rawBytes, storedBytes := clusterDB.encode(todos)
+
+	_, err := clusterDB.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, clusterListKey, values...)
+		pipe.HIncrBy(ctx, clusterUsageKey, usageRawField, rawBytes)
+		pipe.HIncrBy(ctx, clusterUsageKey, usageStoredField, storedBytes)
+		return nil
+	})
+	logger.Debugf("Saved %d todos (%d bytes raw, %d bytes stored)", len(todos), rawBytes, storedBytes)
+	return err
+}
+
+// watchList calls fn with the list in a WATCH of it, fn queues its writes
+// in a MULTI of tx. It starts over if the list changed in between.
+func (clusterDB RedisClusterDB) watchList(ctx context.Context, action string, fn func(tx *redis.Tx, values []string) error) error {
+	for attempt := 1; ; attempt++ {
+		err := clusterDB.client.Watch(ctx, func(tx *redis.Tx) error {
+			values, err := tx.LRange(ctx, clusterListKey, 0, math.MaxInt64).Result()
+			if err != nil {
+				return err
+			}
+			return fn(tx, values)
+		}, clusterListKey)
+		if err != redis.TxFailedErr || attempt >= redisWatchAttempts {
+			return err
+		}
+		logger.Debugf("Todo list changed during %s, attempt %d", action, attempt)
+	}
+}
+
+// batchTodos calls fn in one MULTI for the first todo of each of ids, then
+// removes the todos fn replaced by redisDeletedMarker.
+func (clusterDB RedisClusterDB) batchTodos(ctx context.Context, ids []string, action string, fn func(pipe redis.Pipeliner, index int64, stored, raw string)) error {
+	return clusterDB.watchList(ctx, action, func(tx *redis.Tx, values []string) error {
+		wanted := idSet(ids)
+		matched := 0
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, value := range values {
+				raw := decompressValue(value)
+				if id := unmarshalTodo(raw).ID; wanted[id] {
+					delete(wanted, id)
+					matched++
+					fn(pipe, int64(i), value, raw)
+				}
+			}
+			pipe.LRem(ctx, clusterListKey, 0, redisDeletedMarker)
+			return nil
+		})
+		logger.Debugf("Batch %s of %d todos", action, matched)
+		return err
+	})
+}
+
+func (clusterDB RedisClusterDB) DeleteTodo(ctx context.Context, id string) error {
+	return clusterDB.DeleteTodos(ctx, []string{id})
+}
+
+// DeleteTodos replaces the todos by a marker and removes all markers in the
+// same transaction, LREM of the values could remove an identical todo of
+// older versions instead.
+func (clusterDB RedisClusterDB) DeleteTodos(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return clusterDB.batchTodos(ctx, ids, "delete", func(pipe redis.Pipeliner, index int64, stored, raw string) {
+		pipe.LSet(ctx, clusterListKey, index, redisDeletedMarker)
+		pipe.HIncrBy(ctx, clusterUsageKey, usageRawField, -int64(len(raw)))
+		pipe.HIncrBy(ctx, clusterUsageKey, usageStoredField, -int64(len(stored)))
+	})
+}
+
+func (clusterDB RedisClusterDB) UpdateTodos(ctx context.Context, ids []string, update func(*Todo)) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return clusterDB.batchTodos(ctx, ids, "update", func(pipe redis.Pipeliner, index int64, stored, raw string) {
+		clusterDB.setTodo(ctx, pipe, index, stored, raw, unmarshalTodo(raw).edited(update))
+	})
+}
+
+func (clusterDB RedisClusterDB) CompleteTodos(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return clusterDB.batchTodos(ctx, ids, "complete", func(pipe redis.Pipeliner, index int64, stored, raw string) {
+		todo := unmarshalTodo(raw).edited(func(todo *Todo) {
+			todo.Done = true
+		})
+		clusterDB.setTodo(ctx, pipe, index, stored, raw, todo)
+	})
+}
+
+// setTodo replaces the todo at index, whose value was stored and raw before.
+func (clusterDB RedisClusterDB) setTodo(ctx context.Context, pipe redis.Pipeliner, index int64, stored, raw string, todo Todo) {
+	values, rawBytes, storedBytes := clusterDB.encode([]Todo{todo})
+	pipe.LSet(ctx, clusterListKey, index, values[0])
+	pipe.HIncrBy(ctx, clusterUsageKey, usageRawField, rawBytes-int64(len(raw)))
+	pipe.HIncrBy(ctx, clusterUsageKey, usageStoredField, storedBytes-int64(len(stored)))
+}
+
+func (clusterDB RedisClusterDB) updateTodo(ctx context.Context, id string, fn func(*Todo)) error {
+	return clusterDB.watchList(ctx, "update", func(tx *redis.Tx, values []string) error {
+		for i, value := range values {
+			raw := decompressValue(value)
+			if existing := unmarshalTodo(raw); existing.ID == id {
+				_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+					clusterDB.setTodo(ctx, pipe, int64(i), value, raw, existing.edited(fn))
+					return nil
+				})
+				return err
+			}
+		}
+		return ErrNotFound
+	})
+}
+
+func (clusterDB RedisClusterDB) UpdateTodo(ctx context.Context, id string, update func(*Todo)) error {
+	return clusterDB.updateTodo(ctx, id, update)
+}
+
+func (clusterDB RedisClusterDB) CompleteTodo(ctx context.Context, id string) error {
+	return clusterDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = true
+	})
+}
+
+func (clusterDB RedisClusterDB) ReopenTodo(ctx context.Context, id string) error {
+	return clusterDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = false
+	})
+}
+
+func (clusterDB RedisClusterDB) SetDescription(ctx context.Context, id string, description string) error {
+	return clusterDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Description = description
+	})
+}
+
+func (clusterDB RedisClusterDB) SetDue(ctx context.Context, id string, due *time.Time) error {
+	return clusterDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Due = due
+	})
+}
+
+func (clusterDB RedisClusterDB) SetPriority(ctx context.Context, id string, priority int) error {
+	return clusterDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Priority = priority
+	})
+}
+
+func (clusterDB RedisClusterDB) SetTags(ctx context.Context, id string, tags []string) error {
+	return clusterDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Tags = tags
+	})
+}
+
+func (clusterDB RedisClusterDB) SetRecurrence(ctx context.Context, id string, recurrence string) error {
+	return clusterDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Recurrence = recurrence
+	})
+}
+
+func (clusterDB RedisClusterDB) AddSubTask(ctx context.Context, id string, title string) (SubTask, error) {
+	var subTask SubTask
+	err := clusterDB.updateTodo(ctx, id, func(todo *Todo) {
+		subTask = todo.addSubTask(title)
+	})
+
+	return subTask, err
+}
+
+func (clusterDB RedisClusterDB) CompleteSubTask(ctx context.Context, id string, subTaskID string) error {
+	found := false
+	err := clusterDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.completeSubTask(subTaskID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (clusterDB RedisClusterDB) GetSubTasks(ctx context.Context, id string) ([]SubTask, error) {
+	return subTasksOf(ctx, clusterDB.ForEachTodo, id)
+}
+
+func (clusterDB RedisClusterDB) AddAttachment(ctx context.Context, id string, attachment Attachment) error {
+	return clusterDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.addAttachment(attachment)
+	})
+}
+
+func (clusterDB RedisClusterDB) RemoveAttachment(ctx context.Context, id string, attachmentID string) error {
+	found := false
+	err := clusterDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.removeAttachment(attachmentID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// MoveTodo sets the values from the old to the new position of the todo
+// with LSET, like RedisDB.
+func (clusterDB RedisClusterDB) MoveTodo(ctx context.Context, id string, position int) error {
+	return clusterDB.watchList(ctx, "move", func(tx *redis.Tx, values []string) error {
+		order, err := moveOrder(decodeTodos(values), id, position)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, old := range order {
+				if old != i {
+					pipe.LSet(ctx, clusterListKey, int64(i), values[old])
+				}
+			}
+			return nil
+		})
+		return err
+	})
+}
+
+// ReplaceAllTodos swaps the whole list and resets the usage counters in one
+// transaction.
+func (clusterDB RedisClusterDB) ReplaceAllTodos(ctx context.Context, todos []Todo) error {
+	values, rawBytes, storedBytes := clusterDB.encode(todos)
+
+	_, err := clusterDB.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, clusterListKey)
+		if len(values) > 0 {
+			pipe.RPush(ctx, clusterListKey, values...)
+		}
+		pipe.HSet(ctx, clusterUsageKey, usageRawField, strconv.FormatInt(rawBytes, 10))
+		pipe.HSet(ctx, clusterUsageKey, usageStoredField, strconv.FormatInt(storedBytes, 10))
+		pipe.HSet(ctx, clusterUsageKey, usageCountedField, "1")
+		return nil
+	})
+	return err
+}
+
+// GetUsage returns the byte counters kept up to date by every write. A list
+// without counters, like one restored from a backup, is counted once.
+func (clusterDB RedisClusterDB) GetUsage(ctx context.Context) (Usage, error) {
+	counters, err := clusterDB.client.HGetAll(ctx, clusterUsageKey).Result()
+	if err != nil {
+		return Usage{}, err
+	}
+	count, err := clusterDB.client.LLen(ctx, clusterListKey).Result()
+	if err != nil {
+		return Usage{}, err
+	}
+
+	usage := Usage{Todos: count}
+	if _, counted := counters[usageCountedField]; counted {
+		usage.RawBytes, _ = strconv.ParseInt(counters[usageRawField], 10, 64)
+		usage.StoredBytes, _ = strconv.ParseInt(counters[usageStoredField], 10, 64)
+	} else if usage, err = clusterDB.recountUsage(ctx); err != nil {
+		return Usage{}, err
+	}
+
+	hostname := getHostname()
+	clusterDB.metrics.storageRawBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.RawBytes))
+	clusterDB.metrics.storageStoredBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.StoredBytes))
+
+	return usage, nil
+}
+
+// GetAccountUsage counts the todos of account like RedisDB.
+func (clusterDB RedisClusterDB) GetAccountUsage(ctx context.Context, account string) (Usage, error) {
+	return accountUsage(ctx, clusterDB.ForEachTodo, account, clusterDB.compressionThreshold)
+}
+
+// recountUsage counts the bytes of the list in a WATCH of it and stores the
+// counters, the next write keeps them up to date.
+func (clusterDB RedisClusterDB) recountUsage(ctx context.Context) (Usage, error) {
+	var usage Usage
+	err := clusterDB.watchList(ctx, "usage count", func(tx *redis.Tx, values []string) error {
+		usage = Usage{Todos: int64(len(values))}
+		for _, stored := range values {
+			usage.RawBytes += int64(len(decompressValue(stored)))
+			usage.StoredBytes += int64(len(stored))
+		}
+
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, clusterUsageKey, usageRawField, strconv.FormatInt(usage.RawBytes, 10))
+			pipe.HSet(ctx, clusterUsageKey, usageStoredField, strconv.FormatInt(usage.StoredBytes, 10))
+			pipe.HSet(ctx, clusterUsageKey, usageCountedField, "1")
+			return nil
+		})
+		return err
+	})
+
+	return usage, err
+}
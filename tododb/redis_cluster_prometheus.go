@@ -0,0 +1,142 @@
+package tododb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var redisClusterRoles = []string{"master", "slave"}
+
+func (clusterDB RedisClusterDB) RegisterMetrics(registerer prometheus.Registerer) error {
+	m := clusterDB.metrics
+	err := register(registerer,
+		m.redisClusterNodesTotal,
+		m.redisClusterNodesHealthyTotal,
+		m.redisClusterStateOK,
+		m.storageRawBytes,
+		m.storageStoredBytes,
+	)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Registered Redis Cluster Metrics")
+	return nil
+}
+
+// redisClusterNode is a line of CLUSTER NODES.
+type redisClusterNode struct {
+	addr string
+	role string
+}
+
+// clusterNodes asks any node for the nodes of the cluster, failed ones
+// included. The seeds are used if none answers.
+func (clusterDB RedisClusterDB) clusterNodes(ctx context.Context) []redisClusterNode {
+	lines, err := clusterDB.client.ClusterNodes(ctx).Result()
+	if err != nil {
+		logger.Warnf("CLUSTER NODES: %v", err)
+		nodes := make([]redisClusterNode, len(clusterDB.seeds))
+		for i, seed := range clusterDB.seeds {
+			nodes[i] = redisClusterNode{addr: seed, role: "seed"}
+		}
+		return nodes
+	}
+
+	return parseClusterNodes(lines)
+}
+
+// parseClusterNodes reads the address and role of the nodes, the address is
+// followed by the cluster bus port and, since Redis 7, the hostname.
+func parseClusterNodes(lines string) []redisClusterNode {
+	nodes := []redisClusterNode{}
+	for _, line := range strings.Split(lines, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+
+		addr := strings.SplitN(strings.SplitN(fields[1], "@", 2)[0], ",", 2)[0]
+		role := "other"
+		for _, flag := range strings.Split(fields[2], ",") {
+			if flag == "master" || flag == "slave" {
+				role = flag
+			}
+		}
+		nodes = append(nodes, redisClusterNode{addr: addr, role: role})
+	}
+
+	return nodes
+}
+
+// GetHealthStatus checks every node of the cluster on its own, like the
+// Redis backend checks every address of master and slave, and whether the
+// cluster serves all slots.
+func (clusterDB RedisClusterDB) GetHealthStatus(ctx context.Context) map[string]string {
+	result := map[string]string{"self": okString}
+	hostname := getHostname()
+
+	info, err := clusterDB.client.ClusterInfo(ctx).Result()
+	state := parseRedisInfo(info)["cluster_state"]
+	switch {
+	case err != nil:
+		result["redis-cluster"] = err.Error()
+	case state != okString:
+		result["redis-cluster"] = fmt.Sprintf("cluster_state %s", state)
+	default:
+		result["redis-cluster"] = okString
+	}
+
+	type nodeResult struct {
+		node   redisClusterNode
+		status string
+	}
+
+	nodes := clusterDB.clusterNodes(ctx)
+	results := make(chan nodeResult, len(nodes))
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node redisClusterNode) {
+			defer wg.Done()
+			var status string
+			if endpoint, err := parseRedisEndpoint(node.addr, clusterDB.password); err != nil {
+				status = err.Error()
+			} else {
+				status = checkConnection(ctx, endpoint)
+			}
+			results <- nodeResult{node: node, status: status}
+		}(node)
+	}
+	wg.Wait()
+	close(results)
+
+	healthy := map[string]int{}
+	for res := range results {
+		result["redis-cluster-"+res.node.addr] = res.status
+		if res.status == okString {
+			healthy[res.node.role]++
+		}
+	}
+
+	// A cancelled check says nothing about the nodes
+	if ctx.Err() == nil {
+		m := clusterDB.metrics
+		m.redisClusterNodesTotal.WithLabelValues(hostname, buildinfo.Version).Set(float64(len(nodes)))
+		for _, role := range redisClusterRoles {
+			m.redisClusterNodesHealthyTotal.WithLabelValues(hostname, buildinfo.Version, role).Set(float64(healthy[role]))
+		}
+		stateOK := 0.0
+		if result["redis-cluster"] == okString {
+			stateOK = 1
+		}
+		m.redisClusterStateOK.WithLabelValues(hostname, buildinfo.Version).Set(stateOK)
+	}
+
+	return result
+}
@@ -0,0 +1,44 @@
+package tododb
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FailoverDriller is implemented by backends that can pretend their primary
+// is gone, to rehearse failover handling and alerting.
+type FailoverDriller interface {
+	BlockPrimary(duration time.Duration) time.Time
+}
+
+var drills = struct {
+	sync.Mutex
+	blockedUntil map[string]time.Time
+}{blockedUntil: map[string]time.Time{}}
+
+// BlockPrimary makes every new connection to the master fail until duration
+// has passed, a duration of zero ends a running drill. The returned time is
+// the end of the drill.
+func (redisDB RedisDB) BlockPrimary(duration time.Duration) time.Time {
+	drills.Lock()
+	defer drills.Unlock()
+
+	until := time.Now().Add(duration)
+	drills.blockedUntil[redisDB.master.Addr()] = until
+	logger.Warnf("Failover drill: connections to %s blocked until %s", redisDB.master.Addr(), until.Format(time.RFC3339))
+
+	return until
+}
+
+func drillError(addr string) error {
+	drills.Lock()
+	defer drills.Unlock()
+
+	until, exists := drills.blockedUntil[addr]
+	if !exists || time.Now().After(until) {
+		return nil
+	}
+
+	return fmt.Errorf("connection to %s blocked by failover drill until %s", addr, until.Format(time.RFC3339))
+}
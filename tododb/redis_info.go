@@ -0,0 +1,85 @@
+package tododb
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+func (redisDB RedisDB) registerInfoMetrics(registerer prometheus.Registerer) error {
+	m := redisDB.metrics
+	for _, gauge := range m.redisInfo {
+		if err := registerer.Register(gauge); err != nil {
+			return err
+		}
+	}
+
+	return register(registerer, m.redisTodoListLength, m.redisTodoKeyBytes, m.redisSlowCommandsTotal)
+}
+
+// collectInfo polls master and slave every infoInterval, including their
+// slowlogs. A poll that takes longer is cut off at the next one.
+func (redisDB RedisDB) collectInfo() {
+	for range time.Tick(redisDB.infoInterval) {
+		ctx, cancel := context.WithTimeout(context.Background(), redisDB.infoInterval)
+		redisDB.collectEndpointInfo(ctx, "master", redisDB.master, redisDB.masterPool)
+		redisDB.collectEndpointInfo(ctx, "slave", redisDB.slave, redisDB.slavePool)
+		cancel()
+	}
+}
+
+func (redisDB RedisDB) collectEndpointInfo(ctx context.Context, role string, endpoint redisEndpoint, client *redis.Client) {
+	hostname := getHostname()
+
+	info, err := client.Info(ctx).Result()
+	if err != nil {
+		logger.Warnf("INFO on %s %s: %v", role, endpoint, err)
+		return
+	}
+
+	redisDB.collectSlowLog(ctx, client, role)
+
+	fields := parseRedisInfo(info)
+	for field, gauge := range redisDB.metrics.redisInfo {
+		value, err := strconv.ParseFloat(fields[field], 64)
+		if err != nil {
+			continue
+		}
+		gauge.WithLabelValues(hostname, buildinfo.Version, role).Set(value)
+	}
+
+	if length, err := client.LLen(ctx, redisKey).Result(); err == nil {
+		redisDB.metrics.redisTodoListLength.WithLabelValues(hostname, buildinfo.Version, role).Set(float64(length))
+	}
+
+	// MEMORY USAGE needs redis 4, older versions just don't get the metric
+	memoryUsage := redis.NewIntCmd(ctx, "memory", "usage", redisKey)
+	if err := client.Process(ctx, memoryUsage); err == nil {
+		redisDB.metrics.redisTodoKeyBytes.WithLabelValues(hostname, buildinfo.Version, role).Set(float64(memoryUsage.Val()))
+	} else if err != redis.Nil {
+		logger.Debugf("MEMORY USAGE on %s %s: %v", role, endpoint, err)
+	}
+}
+
+// parseRedisInfo turns the "field:value" lines of INFO into a map, section
+// headers and empty lines are skipped.
+func parseRedisInfo(info string) map[string]string {
+	fields := map[string]string{}
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if i := strings.Index(line, ":"); i > 0 {
+			fields[line[:i]] = line[i+1:]
+		}
+	}
+
+	return fields
+}
@@ -1,77 +1,69 @@
 package tododb
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"net"
 	"os"
 	"sync"
 
+	"github.com/johscheuer/todo-app-web/buildinfo"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-func (redisDB RedisDB) RegisterMetrics() {
-	log.Println("Registered Redis Metrics")
-	prometheus.MustRegister(redisMastersTotal)
-	prometheus.MustRegister(redisMastersHealthyTotal)
-	prometheus.MustRegister(redisSlavesTotal)
-	prometheus.MustRegister(redisSlavesHealthyTotal)
-}
-
-var redisMastersTotal = prometheus.NewGaugeVec(
-	prometheus.GaugeOpts{
-		Name: "todoapp_redis_masters_total",
-		Help: "Total count of available redis masters",
-	},
-	[]string{"instance", "version"},
-)
-
-var redisMastersHealthyTotal = prometheus.NewGaugeVec(
-	prometheus.GaugeOpts{
-		Name: "todoapp_redis_masters_healthy_total",
-		Help: "Total count of healthy redis masters",
-	},
-	[]string{"instance", "version"},
-)
+func (redisDB RedisDB) RegisterMetrics(registerer prometheus.Registerer) error {
+	m := redisDB.metrics
+	err := register(registerer,
+		m.redisMastersTotal,
+		m.redisMastersHealthyTotal,
+		m.redisSlavesTotal,
+		m.redisSlavesHealthyTotal,
+		m.redisRetryBudgetUsedRatio,
+		m.redisRetriesTotal,
+		m.storageRawBytes,
+		m.storageStoredBytes,
+	)
+	if err != nil {
+		return err
+	}
 
-var redisSlavesTotal = prometheus.NewGaugeVec(
-	prometheus.GaugeOpts{
-		Name: "todoapp_redis_slaves_total",
-		Help: "Total count of available redis slaves",
-	},
-	[]string{"instance", "version"},
-)
+	if redisDB.infoInterval > 0 {
+		if err := redisDB.registerInfoMetrics(registerer); err != nil {
+			return err
+		}
+		go redisDB.collectInfo()
+	}
 
-var redisSlavesHealthyTotal = prometheus.NewGaugeVec(
-	prometheus.GaugeOpts{
-		Name: "todoapp_redis_slaves_healthy_total",
-		Help: "Total count of healthy redis slaves",
-	},
-	[]string{"instance", "version"},
-)
+	logger.Infof("Registered Redis Metrics")
+	return nil
+}
 
-func (redisDB RedisDB) GetHealthStatus() map[string]string {
-	result := map[string]string{"self": okString}
+func getHostname() string {
 	hostname, err := os.Hostname()
-
 	if err != nil { //TODO we'll just ignore any errors :)
 		hostname = "UNKNOWN"
 	}
 
-	redisMasterHost := getHostnameFromConnection(redisDB.master, "redis-master")
-	redisSlaveHost := getHostnameFromConnection(redisDB.slave, "redis-slave")
+	return hostname
+}
+
+func (redisDB RedisDB) GetHealthStatus(ctx context.Context) map[string]string {
+	result := map[string]string{"self": okString}
+	hostname := getHostname()
+
+	redisMasterHost := redisDB.master.Host
+	redisSlaveHost := redisDB.slave.Host
 	var wg sync.WaitGroup
 	results := make(chan *checkConnectionResult, 2)
 	wg.Add(2)
 	go func() {
 
-		results <- checkConnections(redisMasterHost, hostname, redisDB.master, redisDB.masterPassword)
+		results <- checkConnections(ctx, redisMasterHost, hostname, redisDB.master)
 		wg.Done()
 	}()
 
 	go func() {
 
-		results <- checkConnections(redisSlaveHost, hostname, redisDB.slave, redisDB.slavePassword)
+		results <- checkConnections(ctx, redisSlaveHost, hostname, redisDB.slave)
 		wg.Done()
 	}()
 	wg.Wait()
@@ -81,12 +73,12 @@ func (redisDB RedisDB) GetHealthStatus() map[string]string {
 	// Merge Results
 	for res := range results {
 		if res.name == redisMasterHost {
-			redisMastersTotal.WithLabelValues(hostname, redisDB.appVersion).Set(float64(res.total))
-			redisMastersHealthyTotal.WithLabelValues(hostname, redisDB.appVersion).Set(float64(res.healthy))
+			redisDB.metrics.redisMastersTotal.WithLabelValues(hostname, buildinfo.Version).Set(float64(res.total))
+			redisDB.metrics.redisMastersHealthyTotal.WithLabelValues(hostname, buildinfo.Version).Set(float64(res.healthy))
 		}
 		if res.name == redisSlaveHost {
-			redisSlavesTotal.WithLabelValues(hostname, redisDB.appVersion).Set(float64(res.total))
-			redisSlavesHealthyTotal.WithLabelValues(hostname, redisDB.appVersion).Set(float64(res.healthy))
+			redisDB.metrics.redisSlavesTotal.WithLabelValues(hostname, buildinfo.Version).Set(float64(res.total))
+			redisDB.metrics.redisSlavesHealthyTotal.WithLabelValues(hostname, buildinfo.Version).Set(float64(res.healthy))
 		}
 
 		for k, v := range res.results {
@@ -104,16 +96,6 @@ type checkConnectionResult struct {
 	name    string
 }
 
-func getHostnameFromConnection(connection, defaultHost string) string {
-	host, _, err := net.SplitHostPort(connection)
-	if err != nil {
-		host = defaultHost
-		fmt.Println(err)
-	}
-
-	return host
-}
-
 func newCheckConnectionResult(name string) *checkConnectionResult {
 	return &checkConnectionResult{
 		results: map[string]string{},
@@ -123,29 +105,34 @@ func newCheckConnectionResult(name string) *checkConnectionResult {
 	}
 }
 
-func checkConnection(connection string, password string) string {
-	client := createRedisClient(connection, password)
-	defer client.Close()
-	if _, err := client.Ping().Result(); err != nil {
+func checkConnection(ctx context.Context, endpoint redisEndpoint) string {
+	client := createRedisClient(endpoint)
+	defer closeRedisClient(client)
+
+	if err := client.Ping(ctx).Err(); err != nil {
 		return err.Error()
 	}
 
 	return okString
 }
 
-func checkConnections(name, hostname, connection, password string) *checkConnectionResult {
+func checkConnections(ctx context.Context, name, hostname string, endpoint redisEndpoint) *checkConnectionResult {
 	res := newCheckConnectionResult(name)
-	connections, err := getAllConnections(connection)
+	connections, err := getAllConnections(endpoint)
 	if err != nil {
-		log.Println(err)
+		logger.Warnf("%v", err)
 		// Simple fallback
-		connections = []string{connection}
+		connections = []redisEndpoint{endpoint}
 	}
 
 	for index, connection := range connections {
 		conName := fmt.Sprintf("%s-%d", name, index)
-		res.results[conName] = checkConnection(connection, password)
+		res.results[conName] = checkConnection(ctx, connection)
 		res.total++
+		// A cancelled check says nothing about the address
+		if ctx.Err() == nil {
+			defaultResolver.setHealthy(connection.Addr(), res.results[conName] == okString)
+		}
 
 		if res.results[conName] == okString {
 			res.healthy++
@@ -156,21 +143,16 @@ func checkConnections(name, hostname, connection, password string) *checkConnect
 }
 
 //TODO add function with SRV lookup
-func getAllConnections(connection string) ([]string, error) {
-	connections := []string{}
-
-	hostname, port, err := net.SplitHostPort(connection)
-	if err != nil {
-		return connections, err
-	}
+func getAllConnections(endpoint redisEndpoint) ([]redisEndpoint, error) {
+	connections := []redisEndpoint{}
 
-	hosts, err := net.LookupHost(hostname)
+	hosts, err := defaultResolver.lookup(endpoint.Host)
 	if err != nil {
 		return connections, err
 	}
 
 	for _, host := range hosts {
-		connections = append(connections, fmt.Sprintf("%s:%s", host, port))
+		connections = append(connections, endpoint.withHost(host))
 	}
 
 	return connections, nil
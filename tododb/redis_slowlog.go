@@ -0,0 +1,121 @@
+package tododb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/redis/go-redis/v9"
+)
+
+const slowLogSize = 128
+
+// SlowLogEntry is a command that took longer than slowlog-log-slower-than.
+type SlowLogEntry struct {
+	Role           string    `json:"role"`
+	ID             int64     `json:"id"`
+	Time           time.Time `json:"time"`
+	DurationMicros int64     `json:"durationMicros"`
+	Command        []string  `json:"command"`
+}
+
+// SlowLogger is implemented by backends that can report slow commands.
+type SlowLogger interface {
+	SlowLog() []SlowLogEntry
+}
+
+var slowLog = struct {
+	sync.Mutex
+	entries []SlowLogEntry
+	lastID  map[string]int64
+}{lastID: map[string]int64{}}
+
+// SlowLog returns the recently collected slow commands, newest first.
+func (redisDB RedisDB) SlowLog() []SlowLogEntry {
+	slowLog.Lock()
+	defer slowLog.Unlock()
+
+	return append([]SlowLogEntry{}, slowLog.entries...)
+}
+
+func (redisDB RedisDB) collectSlowLog(ctx context.Context, client *redis.Client, role string) {
+	cmd := redis.NewSliceCmd(ctx, "slowlog", "get", slowLogSize)
+	if err := client.Process(ctx, cmd); err != nil {
+		logger.Warnf("SLOWLOG on %s: %v", role, err)
+		return
+	}
+
+	entries := []SlowLogEntry{}
+	for _, reply := range cmd.Val() {
+		entry, err := parseSlowLogEntry(reply)
+		if err != nil {
+			logger.Warnf("SLOWLOG on %s: %v", role, err)
+			continue
+		}
+		entry.Role = role
+		entries = append(entries, entry)
+	}
+
+	slowLog.Lock()
+	defer slowLog.Unlock()
+
+	// The IDs start over after a restart of redis
+	lastID, seen := slowLog.lastID[role]
+	if len(entries) > 0 && entries[0].ID < lastID {
+		lastID = -1
+	}
+
+	fresh := []SlowLogEntry{}
+	for _, entry := range entries {
+		if seen && entry.ID <= lastID {
+			break
+		}
+		fresh = append(fresh, entry)
+	}
+
+	if len(entries) > 0 {
+		slowLog.lastID[role] = entries[0].ID
+	}
+
+	// Entries that were already in the slowlog at startup are shown but not
+	// counted, they may be arbitrarily old.
+	if seen {
+		redisDB.metrics.redisSlowCommandsTotal.WithLabelValues(getHostname(), buildinfo.Version, role).Add(float64(len(fresh)))
+	}
+
+	slowLog.entries = append(fresh, slowLog.entries...)
+	if len(slowLog.entries) > slowLogSize {
+		slowLog.entries = slowLog.entries[:slowLogSize]
+	}
+}
+
+// parseSlowLogEntry reads one reply of SLOWLOG GET: id, unix time, duration
+// in microseconds, the command and since redis 4 client address and name.
+func parseSlowLogEntry(reply interface{}) (SlowLogEntry, error) {
+	fields, ok := reply.([]interface{})
+	if !ok || len(fields) < 4 {
+		return SlowLogEntry{}, fmt.Errorf("unexpected slowlog entry %v", reply)
+	}
+
+	id, idOK := fields[0].(int64)
+	timestamp, timeOK := fields[1].(int64)
+	duration, durationOK := fields[2].(int64)
+	args, argsOK := fields[3].([]interface{})
+	if !idOK || !timeOK || !durationOK || !argsOK {
+		return SlowLogEntry{}, fmt.Errorf("unexpected slowlog entry %v", reply)
+	}
+
+	command := make([]string, len(args))
+	for i, arg := range args {
+		command[i] = fmt.Sprint(arg)
+	}
+
+	return SlowLogEntry{
+		ID:             id,
+		Time:           time.Unix(timestamp, 0).UTC(),
+		DurationMicros: duration,
+		Command:        command,
+	}, nil
+}
@@ -0,0 +1,181 @@
+package tododb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Factory creates a backend from the DBConfig of the config file.
+type Factory func(config map[string]string) (TodoDB, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]Factory{}
+	// options are the DBConfig keys a backend understands, backends without
+	// an entry accept any key
+	options = map[string][]string{}
+)
+
+func init() {
+	Register("redis", func(config map[string]string) (TodoDB, error) {
+		db, err := NewRedisDB(config)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	})
+	RegisterOptions("redis", "master", "masterPassword", "slave", "slavePassword", "compressionThreshold", "infoInterval", "poolSize", "idleTimeout", "maxRetries", "retryBudgetPercent", "retryBudgetWindow", "retryBudgetMinRetries")
+	Register("redis-cluster", func(config map[string]string) (TodoDB, error) {
+		db, err := NewRedisClusterDB(config)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	})
+	RegisterOptions("redis-cluster", "nodes", "password", "readFromReplicas", "maxRedirects", "poolSize", "idleTimeout", "compressionThreshold")
+	Register("git", func(config map[string]string) (TodoDB, error) {
+		db, err := NewGitDB(config)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	})
+	RegisterOptions("git", "path", "remote", "branch", "authorName", "authorEmail")
+	Register("postgres", func(config map[string]string) (TodoDB, error) {
+		db, err := NewPostgresDB(config)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	})
+	RegisterOptions("postgres", "url", "maxOpenConns", "maxIdleConns", "connMaxLifetime")
+	Register("mongo", func(config map[string]string) (TodoDB, error) {
+		db, err := NewMongoDB(config)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	})
+	RegisterOptions("mongo", "url", "database", "collection", "readPreference", "timeout")
+	Register("sqlite", func(config map[string]string) (TodoDB, error) {
+		db, err := NewSQLiteDB(config)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	})
+	RegisterOptions("sqlite", "path", "maxOpenConns", "busyTimeout")
+	Register("memory", func(config map[string]string) (TodoDB, error) {
+		db, err := NewMemoryDB(config)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	})
+	RegisterOptions("memory", "snapshot", "snapshotInterval")
+	Register("etcd", func(config map[string]string) (TodoDB, error) {
+		db, err := NewEtcdDB(config)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	})
+	RegisterOptions("etcd", "endpoints", "prefix", "timeout", "leaseTTL")
+	Register("dynamodb", func(config map[string]string) (TodoDB, error) {
+		db, err := NewDynamoDB(config)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	})
+	RegisterOptions("dynamodb", "table", "region", "endpoint", "profile", "createTable", "timeout")
+	Register("cassandra", func(config map[string]string) (TodoDB, error) {
+		db, err := NewCassandraDB(config)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	})
+	RegisterOptions("cassandra", "hosts", "keyspace", "replication", "readConsistency", "writeConsistency", "localDC", "username", "password", "timeout")
+	Register("cockroach", func(config map[string]string) (TodoDB, error) {
+		db, err := NewCockroachDB(config)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	})
+	RegisterOptions("cockroach", "url", "maxOpenConns", "maxIdleConns", "connMaxLifetime", "maxRetries")
+	Register("mysql", func(config map[string]string) (TodoDB, error) {
+		db, err := NewMySQLDB(config)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	})
+	RegisterOptions("mysql", "dsn", "maxOpenConns", "maxIdleConns", "connMaxLifetime")
+}
+
+// Register makes a backend available as Backend name, backends outside of
+// this package call it from their init function. It panics if the name is
+// already taken, like database/sql does for drivers.
+func Register(name string, factory Factory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	name = strings.ToLower(name)
+	if factory == nil {
+		panic("tododb: Register factory is nil")
+	}
+	if _, dup := backends[name]; dup {
+		panic("tododb: Register called twice for backend " + name)
+	}
+	backends[name] = factory
+}
+
+// RegisterOptions declares the DBConfig keys of backend name so unknown keys
+// can be rejected.
+func RegisterOptions(name string, keys ...string) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	name = strings.ToLower(name)
+	options[name] = append(options[name], keys...)
+}
+
+// Options returns the DBConfig keys declared for backend name and whether
+// any were declared.
+func Options(name string) ([]string, bool) {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	keys, exists := options[strings.ToLower(name)]
+	return append([]string{}, keys...), exists
+}
+
+// Open creates the backend registered as name.
+func Open(name string, config map[string]string) (TodoDB, error) {
+	backendsMu.RLock()
+	factory, exists := backends[strings.ToLower(name)]
+	backendsMu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("Datebase: %s is not supported, use one of %v", name, Names())
+	}
+
+	return factory(config)
+}
+
+func Names() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	list := make([]string, 0, len(backends))
+	for name := range backends {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+
+	return list
+}
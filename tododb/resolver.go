@@ -0,0 +1,337 @@
+package tododb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	minResolverTTL      = time.Second
+	maxResolverTTL      = 5 * time.Minute
+	fallbackResolverTTL = 30 * time.Second
+	resolverIdleTimeout = 10 * time.Minute
+	resolverTick        = time.Second
+	dnsTimeout          = 2 * time.Second
+	dialTimeout         = 5 * time.Second
+	resolvConf          = "/etc/resolv.conf"
+)
+
+// resolver caches lookups as long as their TTL allows and refreshes them in
+// the background, so clients follow DNS changes without resolving on every
+// connection. The health checks mark addresses, dial prefers the healthy
+// ones.
+type resolver struct {
+	mu        sync.Mutex
+	entries   map[string]*resolverEntry
+	unhealthy map[string]bool
+	next      map[string]int
+	start     sync.Once
+}
+
+type resolverEntry struct {
+	addrs    []string
+	expires  time.Time
+	lastUsed time.Time
+}
+
+var defaultResolver = &resolver{
+	entries:   map[string]*resolverEntry{},
+	unhealthy: map[string]bool{},
+	next:      map[string]int{},
+}
+
+// lookup returns the addresses of host, IP addresses are returned as is.
+func (r *resolver) lookup(host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	r.start.Do(func() { go r.refresh() })
+
+	r.mu.Lock()
+	entry, exists := r.entries[host]
+	if exists {
+		entry.lastUsed = time.Now()
+	}
+	r.mu.Unlock()
+
+	if exists && time.Now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+
+	return r.resolve(host)
+}
+
+func (r *resolver) resolve(host string) ([]string, error) {
+	addrs, ttl, err := queryDNS(host)
+	if err != nil {
+		logger.Debugf("DNS lookup of %s failed, using the system resolver: %v", host, err)
+		addrs, err = net.LookupHost(host)
+		ttl = fallbackResolverTTL
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, exists := r.entries[host]
+	if err != nil {
+		if exists {
+			logger.Warnf("Resolving %s: %v, keeping %v", host, err, entry.addrs)
+			return entry.addrs, nil
+		}
+		return nil, err
+	}
+
+	if ttl < minResolverTTL {
+		ttl = minResolverTTL
+	}
+	if ttl > maxResolverTTL {
+		ttl = maxResolverTTL
+	}
+
+	if !exists {
+		entry = &resolverEntry{lastUsed: time.Now()}
+		r.entries[host] = entry
+	} else if strings.Join(entry.addrs, ",") != strings.Join(addrs, ",") {
+		logger.Infof("%s now resolves to %v", host, addrs)
+	}
+	entry.addrs = addrs
+	entry.expires = time.Now().Add(ttl)
+	logger.Debugf("Resolved %s to %v for %s", host, addrs, ttl)
+
+	return addrs, nil
+}
+
+// refresh resolves entries again shortly before they expire and forgets the
+// ones nobody asked for in a while.
+func (r *resolver) refresh() {
+	for range time.Tick(resolverTick) {
+		now := time.Now()
+		due := []string{}
+
+		r.mu.Lock()
+		for host, entry := range r.entries {
+			if now.Sub(entry.lastUsed) > resolverIdleTimeout {
+				delete(r.entries, host)
+				continue
+			}
+			if entry.expires.Sub(now) < 2*resolverTick {
+				due = append(due, host)
+			}
+		}
+		r.mu.Unlock()
+
+		for _, host := range due {
+			r.resolve(host)
+		}
+	}
+}
+
+// setHealthy records the result of a health check of addr (host:port).
+func (r *resolver) setHealthy(addr string, healthy bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if healthy {
+		delete(r.unhealthy, addr)
+	} else {
+		r.unhealthy[addr] = true
+	}
+}
+
+// dial connects to one of the addresses of addr, round robin over the
+// healthy ones first.
+func (r *resolver) dial(addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := r.lookup(host)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	offset := r.next[addr]
+	r.next[addr] = offset + 1
+	var healthy, unhealthy []string
+	for i := range ips {
+		candidate := net.JoinHostPort(ips[(offset+i)%len(ips)], port)
+		if r.unhealthy[candidate] {
+			unhealthy = append(unhealthy, candidate)
+		} else {
+			healthy = append(healthy, candidate)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, candidate := range append(healthy, unhealthy...) {
+		var conn net.Conn
+		if conn, err = net.DialTimeout("tcp", candidate, dialTimeout); err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+type dnsConfig struct {
+	servers []string
+	search  []string
+	ndots   int
+}
+
+func readDNSConfig() (dnsConfig, error) {
+	config := dnsConfig{ndots: 1}
+	f, err := os.Open(resolvConf)
+	if err != nil {
+		return config, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+
+		switch fields[0] {
+		case "nameserver":
+			config.servers = append(config.servers, net.JoinHostPort(fields[1], "53"))
+		case "domain", "search":
+			config.search = fields[1:]
+		case "options":
+			for _, option := range fields[1:] {
+				if strings.HasPrefix(option, "ndots:") {
+					if ndots, err := strconv.Atoi(strings.TrimPrefix(option, "ndots:")); err == nil {
+						config.ndots = ndots
+					}
+				}
+			}
+		}
+	}
+
+	return config, scanner.Err()
+}
+
+// names are the fully qualified names to try for host, in the order of the
+// search list rules of resolv.conf.
+func (config dnsConfig) names(host string) []string {
+	if strings.HasSuffix(host, ".") {
+		return []string{host}
+	}
+
+	names := []string{}
+	for _, domain := range config.search {
+		names = append(names, host+"."+strings.TrimSuffix(domain, ".")+".")
+	}
+
+	if strings.Count(host, ".") >= config.ndots {
+		return append([]string{host + "."}, names...)
+	}
+
+	return append(names, host+".")
+}
+
+// queryDNS asks the name servers of resolv.conf for the A and AAAA records of
+// host. The returned TTL is the lowest of all answers.
+func queryDNS(host string) ([]string, time.Duration, error) {
+	config, err := readDNSConfig()
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(config.servers) == 0 {
+		return nil, 0, errors.New("no name servers configured")
+	}
+
+	err = errors.New("no such host")
+	for _, name := range config.names(host) {
+		for _, server := range config.servers {
+			var addrs []string
+			var ttl time.Duration
+			addrs, ttl, err = queryServer(server, name)
+			if err == nil && len(addrs) > 0 {
+				return addrs, ttl, nil
+			}
+			if err == nil {
+				// The server answered, the name doesn't exist
+				err = errors.New("no such host")
+				break
+			}
+		}
+	}
+
+	return nil, 0, err
+}
+
+func queryServer(server, name string) ([]string, time.Duration, error) {
+	conn, err := net.DialTimeout("udp", server, dnsTimeout)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dnsTimeout))
+
+	addrs := []string{}
+	ttl := maxResolverTTL
+	for _, queryType := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		id := uint16(rand.Intn(1 << 16))
+		query, err := buildDNSQuery(id, name, queryType)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if _, err := conn.Write(query); err != nil {
+			return nil, 0, err
+		}
+
+		answers, answerTTL, err := readAnswer(conn, id)
+		if err != nil {
+			return nil, 0, err
+		}
+		addrs = append(addrs, answers...)
+		if len(answers) > 0 && answerTTL < ttl {
+			ttl = answerTTL
+		}
+	}
+
+	return addrs, ttl, nil
+}
+
+func readAnswer(conn net.Conn, id uint16) ([]string, time.Duration, error) {
+	buf := make([]byte, 1232)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		response, err := parseDNSResponse(buf[:n])
+		if err != nil {
+			return nil, 0, err
+		}
+		// Late answer of an earlier query
+		if response.id != id {
+			continue
+		}
+
+		switch response.rcode {
+		case dnsRCodeSuccess:
+		case dnsRCodeNameError:
+			return nil, 0, nil
+		default:
+			return nil, 0, fmt.Errorf("DNS server answered with rcode %d", response.rcode)
+		}
+
+		return response.addrs, response.ttl, nil
+	}
+}
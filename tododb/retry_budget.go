@@ -0,0 +1,135 @@
+package tododb
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultRetryBudgetPercent    = 10
+	defaultRetryBudgetWindow     = 10
+	defaultRetryBudgetMinRetries = 10
+)
+
+// retryBudget allows retries of up to percent of the commands of a window,
+// and minRetries on top so a quiet instance can retry at all. Once it is used
+// up failed commands fail right away, retries of many instances can't pile
+// up on a struggling Redis.
+type retryBudget struct {
+	percent    int
+	minRetries int
+	window     time.Duration
+	metrics    *Metrics
+
+	mu       sync.Mutex
+	start    time.Time
+	requests int
+	retries  int
+}
+
+func newRetryBudget(config map[string]string, metrics *Metrics) *retryBudget {
+	return &retryBudget{
+		percent:    intConfig(config, "retryBudgetPercent", defaultRetryBudgetPercent),
+		minRetries: intConfig(config, "retryBudgetMinRetries", defaultRetryBudgetMinRetries),
+		window:     time.Duration(intConfig(config, "retryBudgetWindow", defaultRetryBudgetWindow)) * time.Second,
+		metrics:    metrics,
+		start:      time.Now(),
+	}
+}
+
+// update starts a new window when the current one is over and exports how
+// much of the budget is used. The caller holds mu.
+func (budget *retryBudget) update(now time.Time) {
+	if now.Sub(budget.start) >= budget.window {
+		budget.start = now
+		budget.requests = 0
+		budget.retries = 0
+	}
+
+	used := 1.0
+	if allowed := budget.allowed(); allowed > 0 {
+		used = float64(budget.retries) / float64(allowed)
+	}
+	budget.metrics.redisRetryBudgetUsedRatio.WithLabelValues(getHostname(), buildinfo.Version).Set(used)
+}
+
+func (budget *retryBudget) allowed() int {
+	return budget.minRetries + budget.requests*budget.percent/100
+}
+
+func (budget *retryBudget) request() {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+
+	budget.update(time.Now())
+	budget.requests++
+}
+
+// retry takes one retry from the budget, false if none is left.
+func (budget *retryBudget) retry() bool {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+
+	budget.update(time.Now())
+	result := "denied"
+	allowed := budget.retries < budget.allowed()
+	if allowed {
+		budget.retries++
+		result = "allowed"
+		budget.update(time.Now())
+	}
+	budget.metrics.redisRetriesTotal.WithLabelValues(getHostname(), buildinfo.Version, result).Inc()
+
+	return allowed
+}
+
+// isRetryableRedisError is true for network errors like timeouts.
+// Connections blocked by a failover drill aren't retried, the drill is meant
+// to fail them.
+func isRetryableRedisError(err error) bool {
+	if err == io.EOF {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// wrap retries failed commands of a client up to maxRetries times while the
+// budget allows it. It replaces the retries of go-redis, which know no
+// budget.
+func (budget *retryBudget) wrap(client *redis.Client, maxRetries int) {
+	client.AddHook(retryHook{budget: budget, maxRetries: maxRetries})
+}
+
+// retryHook retries single commands, pipelines and transactions aren't
+// retried.
+type retryHook struct {
+	budget     *retryBudget
+	maxRetries int
+}
+
+func (hook retryHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (hook retryHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		hook.budget.request()
+		err := next(ctx, cmd)
+		// A done context fails every retry as well
+		for i := 0; i < hook.maxRetries && ctx.Err() == nil && isRetryableRedisError(err) && hook.budget.retry(); i++ {
+			err = next(ctx, cmd)
+		}
+		return err
+	}
+}
+
+func (hook retryHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
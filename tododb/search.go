@@ -0,0 +1,98 @@
+package tododb
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode"
+)
+
+const (
+	// Words shorter than minSearchTerm are neither indexed nor searched for,
+	// the index keeps the prefixes of the words up to maxSearchTerm
+	minSearchTerm = 2
+	maxSearchTerm = 20
+)
+
+// ErrEmptyQuery is returned by SearchTodos for a query without any words
+// to search for.
+var ErrEmptyQuery = errors.New("the query has no words to search for")
+
+// SearchWords splits text into lower case words of letters and digits,
+// without duplicates and those too short to search for.
+func SearchWords(text string) []string {
+	words := []string{}
+	seen := map[string]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(word)) >= minSearchTerm && !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+
+	return words
+}
+
+// searchText is what a search looks at, the title, the description and the
+// tags of todo.
+func searchText(todo Todo) string {
+	return todo.Title + " " + todo.Description + " " + strings.Join(todo.Tags, " ")
+}
+
+// searchTerms are the terms todo is indexed under, the prefixes of its
+// words from minSearchTerm up to maxSearchTerm letters.
+func searchTerms(todo Todo) []string {
+	terms := []string{}
+	seen := map[string]bool{}
+	for _, word := range SearchWords(searchText(todo)) {
+		runes := []rune(word)
+		for n := minSearchTerm; n <= len(runes) && n <= maxSearchTerm; n++ {
+			if term := string(runes[:n]); !seen[term] {
+				seen[term] = true
+				terms = append(terms, term)
+			}
+		}
+	}
+
+	return terms
+}
+
+// queryTerm is the term of the index a word of a query is looked up with.
+func queryTerm(word string) string {
+	if runes := []rune(word); len(runes) > maxSearchTerm {
+		return string(runes[:maxSearchTerm])
+	}
+
+	return word
+}
+
+// matchesQuery reports whether every word of query starts a word of todo.
+func matchesQuery(todo Todo, query string) bool {
+	words := SearchWords(searchText(todo))
+	for _, wanted := range SearchWords(query) {
+		found := false
+		for _, word := range words {
+			if strings.HasPrefix(word, wanted) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// searchTodos returns the todos of getAll matching query, in the order of
+// the list, for backends without an index of the words.
+func searchTodos(ctx context.Context, getAll func(context.Context, ...TodoFilter) ([]Todo, error), query string) ([]Todo, error) {
+	if len(SearchWords(query)) == 0 {
+		return nil, ErrEmptyQuery
+	}
+
+	return getAll(ctx, TodoFilter{Query: query})
+}
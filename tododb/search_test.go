@@ -0,0 +1,68 @@
+package tododb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSearchWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", []string{}},
+		{"Buy milk, buy MILK!", []string{"buy", "milk"}},
+		{"a to-do list", []string{"to", "do", "list"}},
+		{"Café über 42 x", []string{"café", "über", "42"}},
+		{"e-mail: bob@example.com", []string{"mail", "bob", "example", "com"}},
+	}
+
+	for _, test := range tests {
+		if got := SearchWords(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("SearchWords(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestSearchTerms(t *testing.T) {
+	todo := Todo{Title: "Call Bob", Description: "re ok", Tags: []string{"phone"}}
+	want := []string{"ca", "cal", "call", "bo", "bob", "re", "ok", "ph", "pho", "phon", "phone"}
+	if got := searchTerms(todo); !reflect.DeepEqual(got, want) {
+		t.Errorf("searchTerms() = %q, want %q", got, want)
+	}
+
+	long := Todo{Title: strings.Repeat("x", 30)}
+	terms := searchTerms(long)
+	if len(terms) != maxSearchTerm-minSearchTerm+1 || terms[len(terms)-1] != strings.Repeat("x", maxSearchTerm) {
+		t.Errorf("searchTerms() of a long word = %q", terms)
+	}
+	if got := queryTerm(strings.Repeat("x", 30)); got != strings.Repeat("x", maxSearchTerm) {
+		t.Errorf("queryTerm() = %q, want %d letters", got, maxSearchTerm)
+	}
+}
+
+func TestMatchesQuery(t *testing.T) {
+	todo := Todo{Title: "Buy milk", Description: "at the Farmers' market", Tags: []string{"groceries"}}
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"milk", true},
+		{"MIL", true},
+		{"buy market", true},
+		{"groc", true},
+		{"farmers", true},
+		{"ilk", false},
+		{"milk bread", false},
+		// Too short to search for, every todo matches
+		{"m", true},
+		{"", true},
+	}
+
+	for _, test := range tests {
+		if got := matchesQuery(todo, test.query); got != test.want {
+			t.Errorf("matchesQuery(%q) = %v, want %v", test.query, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,621 @@
+package tododb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/buildinfo"
+	"github.com/johscheuer/todo-app-web/features"
+	// registers the sqlite3 driver with database/sql, it needs cgo
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	defaultSQLitePath         = "todo-app.db"
+	defaultSQLiteMaxOpenConns = 4
+	defaultSQLiteBusyTimeout  = 5000
+
+	// sqliteMemory keeps the todos in memory, they are gone after a restart
+	sqliteMemory = ":memory:"
+)
+
+// sqliteMigrations are applied in order, each exactly once. Never change an
+// existing entry, append a new one.
+var sqliteMigrations = []string{
+	`CREATE TABLE todos (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		title TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`ALTER TABLE todos ADD COLUMN doc TEXT`,
+	`CREATE TABLE kv_values (
+		name TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		expires_at INTEGER NOT NULL DEFAULT 0
+	)`,
+}
+
+// sqliteKVQueries need no row lock, the insert of create already takes the
+// write lock of the database for the transaction.
+var sqliteKVQueries = sqlKVQueries{
+	get:    "SELECT value FROM kv_values WHERE name = ? AND (expires_at = 0 OR expires_at > ?)",
+	set:    "INSERT INTO kv_values (name, value, expires_at) VALUES (?, ?, ?) ON CONFLICT (name) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at",
+	create: "INSERT INTO kv_values (name, value, expires_at) VALUES (?, '0', 0) ON CONFLICT (name) DO NOTHING",
+	lock:   "SELECT value, expires_at FROM kv_values WHERE name = ?",
+	update: "UPDATE kv_values SET value = ?, expires_at = ? WHERE name = ?",
+	delete: "DELETE FROM kv_values WHERE name = ?",
+}
+
+// SQLiteDB keeps every todo as a row of a local database file, or in memory,
+// so the app runs without any database server.
+type SQLiteDB struct {
+	db      *sql.DB
+	path    string
+	kv      sqlKV
+	metrics *Metrics
+
+	selectTodos *sql.Stmt
+	selectPage  *sql.Stmt
+	countTodos  *sql.Stmt
+	selectIDs   *sql.Stmt
+	selectTodo  *sql.Stmt
+	insertTodo  *sql.Stmt
+	deleteTodo  *sql.Stmt
+	updateRow   *sql.Stmt
+	selectUsage *sql.Stmt
+	deleteTodos *sql.Stmt
+}
+
+var _ TodoDB = &SQLiteDB{}
+
+func NewSQLiteDB(config map[string]string) (*SQLiteDB, error) {
+	path := defaultSQLitePath
+	if value, exists := config["path"]; exists {
+		path = value
+	}
+
+	params := url.Values{}
+	params.Set("_busy_timeout", fmt.Sprint(intConfig(config, "busyTimeout", defaultSQLiteBusyTimeout)))
+	maxOpenConns := intConfig(config, "maxOpenConns", defaultSQLiteMaxOpenConns)
+	if path == sqliteMemory {
+		// every connection would open a database of its own
+		maxOpenConns = 1
+	} else {
+		params.Set("_journal_mode", "WAL")
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+	if path == sqliteMemory {
+		// the database is dropped with its last connection
+		db.SetConnMaxLifetime(0)
+		db.SetMaxIdleConns(1)
+	}
+
+	sqliteDB := &SQLiteDB{
+		db:      db,
+		path:    path,
+		metrics: NewMetrics(),
+	}
+	sqliteDB.kv = sqlKV{db: db, inTx: sqliteDB.inTx, queries: sqliteKVQueries}
+
+	if err := sqliteDB.migrate(context.Background()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite %s: %v", path, err)
+	}
+
+	if err := sqliteDB.prepare(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return sqliteDB, nil
+}
+
+// migrate creates the schema or brings it up to date. SQLite locks the whole
+// database for a write transaction, so there is no need for an extra lock.
+func (sqliteDB *SQLiteDB) migrate(ctx context.Context) error {
+	tx, err := sqliteDB.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var version int
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		if _, err := tx.ExecContext(ctx, sqliteMigrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES (?)", i+1); err != nil {
+			return err
+		}
+		logger.Infof("Applied schema migration %d", i+1)
+	}
+
+	return tx.Commit()
+}
+
+func (sqliteDB *SQLiteDB) prepare() error {
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&sqliteDB.selectTodos, "SELECT id, title, doc FROM todos ORDER BY id"},
+		{&sqliteDB.selectPage, "SELECT id, title, doc FROM todos ORDER BY id LIMIT ? OFFSET ?"},
+		{&sqliteDB.countTodos, "SELECT COUNT(*) FROM todos"},
+		{&sqliteDB.selectIDs, "SELECT id FROM todos ORDER BY id"},
+		{&sqliteDB.selectTodo, "SELECT id, title, doc FROM todos WHERE id = ?"},
+		{&sqliteDB.insertTodo, "INSERT INTO todos (title, doc) VALUES (?, ?)"},
+		{&sqliteDB.deleteTodo, "DELETE FROM todos WHERE id = ?"},
+		{&sqliteDB.updateRow, "UPDATE todos SET title = ?, doc = ? WHERE id = ?"},
+		{&sqliteDB.selectUsage, "SELECT COUNT(*), COALESCE(SUM(LENGTH(CAST(COALESCE(doc, title) AS BLOB))), 0) FROM todos"},
+		{&sqliteDB.deleteTodos, "DELETE FROM todos"},
+	}
+
+	for _, statement := range statements {
+		stmt, err := sqliteDB.db.Prepare(statement.query)
+		if err != nil {
+			return fmt.Errorf("prepare %q: %v", statement.query, err)
+		}
+		*statement.stmt = stmt
+	}
+
+	return nil
+}
+
+func (sqliteDB *SQLiteDB) GetAllTodos(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	todos := []Todo{}
+	err := sqliteDB.ForEachTodo(ctx, func(todo Todo) error {
+		if matchesAll(filters, todo) {
+			todos = append(todos, todo)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return todos, nil
+}
+
+// GetTodos lets the database skip to the page, unless filters are given.
+func (sqliteDB *SQLiteDB) GetTodos(ctx context.Context, offset, limit int, filters ...TodoFilter) ([]Todo, error) {
+	if len(filters) > 0 || features.Enabled(features.PerfNPlusOne) {
+		return pageTodos(ctx, sqliteDB.ForEachTodo, offset, limit, filters)
+	}
+	if err := checkPage(offset, limit); err != nil {
+		return nil, err
+	}
+
+	rows, err := sqliteDB.selectPage.QueryContext(ctx, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanSQLTodos(rows)
+}
+
+func (sqliteDB *SQLiteDB) CountTodos(ctx context.Context, filters ...TodoFilter) (int, error) {
+	if len(filters) > 0 {
+		return countTodos(ctx, sqliteDB.ForEachTodo, filters)
+	}
+
+	var count int
+	err := sqliteDB.countTodos.QueryRowContext(ctx).Scan(&count)
+	return count, err
+}
+
+func (sqliteDB *SQLiteDB) GetOverdueTodos(ctx context.Context) ([]Todo, error) {
+	return todosByDue(ctx, sqliteDB.GetAllTodos, overdueFilter())
+}
+
+func (sqliteDB *SQLiteDB) GetTodosDueBefore(ctx context.Context, t time.Time) ([]Todo, error) {
+	return todosByDue(ctx, sqliteDB.GetAllTodos, TodoFilter{DueBefore: t})
+}
+
+func (sqliteDB *SQLiteDB) GetTodosByPriority(ctx context.Context, filters ...TodoFilter) ([]Todo, error) {
+	return todosByPriority(ctx, sqliteDB.GetAllTodos, filters)
+}
+
+func (sqliteDB *SQLiteDB) GetTodosByTag(ctx context.Context, tag string) ([]Todo, error) {
+	return todosByTag(ctx, sqliteDB.GetAllTodos, tag)
+}
+
+func (sqliteDB *SQLiteDB) ListTags(ctx context.Context) ([]Tag, error) {
+	return CountTags(ctx, sqliteDB.ForEachTodo)
+}
+
+func (sqliteDB *SQLiteDB) SearchTodos(ctx context.Context, query string) ([]Todo, error) {
+	return searchTodos(ctx, sqliteDB.GetAllTodos, query)
+}
+
+func (sqliteDB *SQLiteDB) ForEachTodo(ctx context.Context, fn func(Todo) error) error {
+	if features.Enabled(features.PerfNPlusOne) {
+		return sqliteDB.forEachTodoOneByOne(ctx, fn)
+	}
+
+	rows, err := sqliteDB.selectTodos.QueryContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		todo, err := scanSQLTodo(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(todo); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// forEachTodoOneByOne is the deliberately slow variant of ForEachTodo, with
+// one query per todo.
+func (sqliteDB *SQLiteDB) forEachTodoOneByOne(ctx context.Context, fn func(Todo) error) error {
+	rows, err := sqliteDB.selectIDs.QueryContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		todo, err := scanSQLTodo(sqliteDB.selectTodo.QueryRowContext(ctx, id))
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(todo); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (sqliteDB *SQLiteDB) SaveTodo(ctx context.Context, todo Todo) error {
+	_, err := sqliteDB.insertTodo.ExecContext(ctx, sqlTodoArgs(todo)...)
+	return err
+}
+
+func (sqliteDB *SQLiteDB) SaveTodos(ctx context.Context, todos []Todo) error {
+	if len(todos) == 0 {
+		return nil
+	}
+
+	return sqliteDB.inTx(ctx, func(tx *sql.Tx) error {
+		return sqliteDB.insert(ctx, tx, todos)
+	})
+}
+
+// DeleteTodo looks the id up in Go, SQLite is built without its JSON
+// functions.
+func (sqliteDB *SQLiteDB) DeleteTodo(ctx context.Context, id string) error {
+	return sqliteDB.inTx(ctx, func(tx *sql.Tx) error {
+		return sqliteDB.removeInTx(ctx, tx, id)
+	})
+}
+
+// DeleteTodos deletes the todos in one transaction.
+func (sqliteDB *SQLiteDB) DeleteTodos(ctx context.Context, ids []string) error {
+	return sqliteDB.inTx(ctx, func(tx *sql.Tx) error {
+		for _, id := range ids {
+			if err := sqliteDB.removeInTx(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (sqliteDB *SQLiteDB) removeInTx(ctx context.Context, tx *sql.Tx, id string) error {
+	rowID, err := sqliteRowOf(ctx, tx, id)
+	if err != nil || rowID == 0 {
+		return err
+	}
+
+	result, err := tx.StmtContext(ctx, sqliteDB.deleteTodo).ExecContext(ctx, rowID)
+	if err != nil {
+		return err
+	}
+
+	removed, _ := result.RowsAffected()
+	logger.Debugf("Deleted %d todos", removed)
+	return nil
+}
+
+func (sqliteDB *SQLiteDB) UpdateTodo(ctx context.Context, id string, update func(*Todo)) error {
+	return sqliteDB.updateTodo(ctx, id, update)
+}
+
+func (sqliteDB *SQLiteDB) UpdateTodos(ctx context.Context, ids []string, update func(*Todo)) error {
+	return sqliteDB.inTx(ctx, func(tx *sql.Tx) error {
+		for _, id := range ids {
+			if err := sqliteDB.updateInTx(ctx, tx, id, update); err != nil && err != ErrNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (sqliteDB *SQLiteDB) CompleteTodo(ctx context.Context, id string) error {
+	return sqliteDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = true
+	})
+}
+
+// CompleteTodos marks the todos done in one transaction.
+func (sqliteDB *SQLiteDB) CompleteTodos(ctx context.Context, ids []string) error {
+	return sqliteDB.inTx(ctx, func(tx *sql.Tx) error {
+		for _, id := range ids {
+			err := sqliteDB.updateInTx(ctx, tx, id, func(todo *Todo) {
+				todo.Done = true
+			})
+			if err != nil && err != ErrNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (sqliteDB *SQLiteDB) ReopenTodo(ctx context.Context, id string) error {
+	return sqliteDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Done = false
+	})
+}
+
+func (sqliteDB *SQLiteDB) SetDescription(ctx context.Context, id string, description string) error {
+	return sqliteDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Description = description
+	})
+}
+
+func (sqliteDB *SQLiteDB) SetDue(ctx context.Context, id string, due *time.Time) error {
+	return sqliteDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Due = due
+	})
+}
+
+func (sqliteDB *SQLiteDB) SetPriority(ctx context.Context, id string, priority int) error {
+	return sqliteDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Priority = priority
+	})
+}
+
+func (sqliteDB *SQLiteDB) SetTags(ctx context.Context, id string, tags []string) error {
+	return sqliteDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Tags = tags
+	})
+}
+
+func (sqliteDB *SQLiteDB) SetRecurrence(ctx context.Context, id string, recurrence string) error {
+	return sqliteDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.Recurrence = recurrence
+	})
+}
+
+func (sqliteDB *SQLiteDB) AddSubTask(ctx context.Context, id string, title string) (SubTask, error) {
+	var subTask SubTask
+	err := sqliteDB.updateTodo(ctx, id, func(todo *Todo) {
+		subTask = todo.addSubTask(title)
+	})
+
+	return subTask, err
+}
+
+func (sqliteDB *SQLiteDB) CompleteSubTask(ctx context.Context, id string, subTaskID string) error {
+	found := false
+	err := sqliteDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.completeSubTask(subTaskID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (sqliteDB *SQLiteDB) GetSubTasks(ctx context.Context, id string) ([]SubTask, error) {
+	return subTasksOf(ctx, sqliteDB.ForEachTodo, id)
+}
+
+func (sqliteDB *SQLiteDB) AddAttachment(ctx context.Context, id string, attachment Attachment) error {
+	return sqliteDB.updateTodo(ctx, id, func(todo *Todo) {
+		todo.addAttachment(attachment)
+	})
+}
+
+func (sqliteDB *SQLiteDB) RemoveAttachment(ctx context.Context, id string, attachmentID string) error {
+	found := false
+	err := sqliteDB.updateTodo(ctx, id, func(todo *Todo) {
+		found = todo.removeAttachment(attachmentID)
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// MoveTodo rewrites the rows from the old to the new position of the todo,
+// the rows are ordered by their id.
+func (sqliteDB *SQLiteDB) MoveTodo(ctx context.Context, id string, position int) error {
+	return sqliteDB.inTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.StmtContext(ctx, sqliteDB.selectTodos).QueryContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		update := tx.StmtContext(ctx, sqliteDB.updateRow)
+		return moveSQLTodo(rows, id, position, func(todo Todo, rowID int64) error {
+			_, err := update.ExecContext(ctx, append(sqlTodoArgs(todo), rowID)...)
+			return err
+		})
+	})
+}
+
+func (sqliteDB *SQLiteDB) updateTodo(ctx context.Context, id string, fn func(*Todo)) error {
+	return sqliteDB.inTx(ctx, func(tx *sql.Tx) error {
+		return sqliteDB.updateInTx(ctx, tx, id, fn)
+	})
+}
+
+func (sqliteDB *SQLiteDB) updateInTx(ctx context.Context, tx *sql.Tx, id string, fn func(*Todo)) error {
+	rowID, err := sqliteRowOf(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if rowID == 0 {
+		return ErrNotFound
+	}
+
+	todo, err := scanSQLTodo(tx.StmtContext(ctx, sqliteDB.selectTodo).QueryRowContext(ctx, rowID))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.StmtContext(ctx, sqliteDB.updateRow).ExecContext(ctx, append(sqlTodoArgs(todo.edited(fn)), rowID)...)
+	return err
+}
+
+// sqliteRowOf returns the row of the todo with id, or 0 if there is none.
+// Rows without doc have the id of the row as id of the todo.
+func sqliteRowOf(ctx context.Context, tx *sql.Tx, id string) (int64, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT id, doc FROM todos ORDER BY id")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			rowID int64
+			doc   sql.NullString
+		)
+		if err := rows.Scan(&rowID, &doc); err != nil {
+			return 0, err
+		}
+		if doc.Valid && unmarshalTodo(doc.String).ID == id || !doc.Valid && strconv.FormatInt(rowID, 10) == id {
+			return rowID, nil
+		}
+	}
+
+	return 0, rows.Err()
+}
+
+func (sqliteDB *SQLiteDB) ReplaceAllTodos(ctx context.Context, todos []Todo) error {
+	return sqliteDB.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.StmtContext(ctx, sqliteDB.deleteTodos).ExecContext(ctx); err != nil {
+			return err
+		}
+
+		return sqliteDB.insert(ctx, tx, todos)
+	})
+}
+
+func (sqliteDB *SQLiteDB) insert(ctx context.Context, tx *sql.Tx, todos []Todo) error {
+	stmt := tx.StmtContext(ctx, sqliteDB.insertTodo)
+	for _, todo := range todos {
+		if _, err := stmt.ExecContext(ctx, sqlTodoArgs(todo)...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// inTx commits if fn succeeds, otherwise nothing of fn is kept.
+func (sqliteDB *SQLiteDB) inTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := sqliteDB.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetUsage reports the size of the whole database as StoredBytes, the
+// schema and free pages included.
+func (sqliteDB *SQLiteDB) GetUsage(ctx context.Context) (Usage, error) {
+	var usage Usage
+	if err := sqliteDB.selectUsage.QueryRowContext(ctx).Scan(&usage.Todos, &usage.RawBytes); err != nil {
+		return Usage{}, err
+	}
+
+	var pageCount, pageSize int64
+	if err := sqliteDB.db.QueryRowContext(ctx, "PRAGMA page_count").Scan(&pageCount); err != nil {
+		return Usage{}, err
+	}
+	if err := sqliteDB.db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return Usage{}, err
+	}
+	usage.StoredBytes = pageCount * pageSize
+
+	hostname := getHostname()
+	sqliteDB.metrics.storageRawBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.RawBytes))
+	sqliteDB.metrics.storageStoredBytes.WithLabelValues(hostname, buildinfo.Version).Set(float64(usage.StoredBytes))
+
+	return usage, nil
+}
+
+func (sqliteDB *SQLiteDB) GetHealthStatus(ctx context.Context) map[string]string {
+	result := map[string]string{"self": okString, "sqlite": okString}
+
+	var check string
+	if err := sqliteDB.db.QueryRowContext(ctx, "PRAGMA quick_check").Scan(&check); err != nil {
+		result["sqlite"] = err.Error()
+	} else if check != "ok" {
+		result["sqlite"] = fmt.Sprintf("%s: %s", sqliteDB.path, check)
+	}
+
+	return result
+}
+
+// OpenConnections returns the connections of the pool, in use or idle.
+func (sqliteDB *SQLiteDB) OpenConnections() int64 {
+	return int64(sqliteDB.db.Stats().OpenConnections)
+}
@@ -0,0 +1,19 @@
+package tododb
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func (sqliteDB *SQLiteDB) RegisterMetrics(registerer prometheus.Registerer) error {
+	m := sqliteDB.metrics
+	err := register(registerer,
+		m.storageRawBytes,
+		m.storageStoredBytes,
+	)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Registered SQLite Metrics")
+	return nil
+}
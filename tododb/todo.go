@@ -0,0 +1,825 @@
+package tododb
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Todo is a single entry of the list. Backends store it as a JSON document,
+// see marshalTodo. The ID is a random UUID, todos stored before todos had
+// ids get one derived from where they are stored, or from their title.
+// Identical todos of that kind share their id, they can't be told apart
+// anyway. Due is kept in UTC with whole seconds, so the stored RFC 3339
+// strings sort like the times. Priority goes from PriorityNone up to
+// PriorityHigh, the higher the more urgent. Tags are kept normalized, see
+// NormalizeTag, sorted and without duplicates. SubTasks are the checklist of
+// the todo, stored in its document in the order they were added. Recurrence
+// is the rule of a recurring todo, like daily, weekly or a cron expression;
+// the backends keep it as it is, the app interprets it. Attachments are the
+// metadata of the files attached to the todo, their content is in an
+// AttachmentStore. Estimate is the expected effort in minutes, zero if the
+// todo has none. Remote are the issues the todo is mirrored to, one per
+// tracker it's synced with. Owner is the account that owns the todo, empty
+// for the todos that are open to everyone.
+type Todo struct {
+	ID          string       `json:"id"`
+	Title       string       `json:"title"`
+	Description string       `json:"description,omitempty"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	UpdatedAt   time.Time    `json:"updatedAt"`
+	Done        bool         `json:"done"`
+	Due         *time.Time   `json:"due,omitempty"`
+	Priority    int          `json:"priority,omitempty"`
+	Tags        []string     `json:"tags,omitempty"`
+	SubTasks    []SubTask    `json:"subTasks,omitempty"`
+	Recurrence  string       `json:"recurrence,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Estimate    int          `json:"estimate,omitempty"`
+	Remote      []RemoteLink `json:"remote,omitempty"`
+	Owner       string       `json:"owner,omitempty"`
+}
+
+// RemoteLink points to the issue a todo is mirrored to. Tracker names the
+// tracker and project, like github:owner/repo or jira:PROJ, ID is the issue
+// there. Hash is a digest of the fields last written to the issue, they are
+// only written again when it changes.
+type RemoteLink struct {
+	Tracker  string    `json:"tracker"`
+	ID       string    `json:"id"`
+	URL      string    `json:"url,omitempty"`
+	Hash     string    `json:"hash,omitempty"`
+	SyncedAt time.Time `json:"syncedAt"`
+}
+
+// SubTask is an item of the checklist of a todo, done on its own. The ID is
+// a random UUID like those of the todos.
+type SubTask struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Done  bool   `json:"done"`
+}
+
+// Attachment describes a file attached to a todo. The ID is a random UUID,
+// SHA256 the hex digest of the content, which is stored under its Key.
+// Attachments of older versions have no SHA256. Scan is the outcome of the
+// malware scan of the upload, clean or quarantined with the name of the
+// Threat, empty if it wasn't scanned.
+type Attachment struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256,omitempty"`
+	Scan        string    `json:"scan,omitempty"`
+	Threat      string    `json:"threat,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// NewAttachment describes content attached now as a file called name.
+func NewAttachment(name, contentType string, content []byte) Attachment {
+	sum := sha256.Sum256(content)
+	return Attachment{
+		ID:          newUUID(),
+		Name:        name,
+		ContentType: contentType,
+		Size:        int64(len(content)),
+		SHA256:      hex.EncodeToString(sum[:]),
+		CreatedAt:   time.Now().UTC(),
+	}
+}
+
+// Key is where the content of attachment of the todo with todoID is stored,
+// the blob of its digest, the quarantine if a threat was found, or the key
+// of older versions.
+func (attachment Attachment) Key(todoID string) string {
+	switch {
+	case attachment.SHA256 == "":
+		return AttachmentKey(todoID, attachment.ID)
+	case attachment.Threat != "":
+		return QuarantineKey(attachment.SHA256)
+	}
+
+	return BlobKey(attachment.SHA256)
+}
+
+// SubTaskProgress returns how many sub-tasks of todo are done and how many
+// it has.
+func (todo Todo) SubTaskProgress() (done, total int) {
+	for _, subTask := range todo.SubTasks {
+		if subTask.Done {
+			done++
+		}
+	}
+
+	return done, len(todo.SubTasks)
+}
+
+// addSubTask appends an open sub-task with title and returns it. The
+// sub-tasks are copied, copies of todo share them.
+func (todo *Todo) addSubTask(title string) SubTask {
+	subTask := SubTask{ID: newUUID(), Title: title}
+	todo.SubTasks = append(append([]SubTask{}, todo.SubTasks...), subTask)
+
+	return subTask
+}
+
+// addAttachment appends attachment. The attachments are copied, copies of
+// todo share them.
+func (todo *Todo) addAttachment(attachment Attachment) {
+	todo.Attachments = append(append([]Attachment{}, todo.Attachments...), attachment)
+}
+
+// removeAttachment drops the attachment with id, it reports whether todo
+// has one.
+func (todo *Todo) removeAttachment(id string) bool {
+	for i, attachment := range todo.Attachments {
+		if attachment.ID == id {
+			todo.Attachments = append(append([]Attachment{}, todo.Attachments[:i]...), todo.Attachments[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// completeSubTask marks the sub-task with id done, it reports whether todo
+// has one.
+func (todo *Todo) completeSubTask(id string) bool {
+	for i, subTask := range todo.SubTasks {
+		if subTask.ID == id {
+			todo.SubTasks = append([]SubTask{}, todo.SubTasks...)
+			todo.SubTasks[i].Done = true
+			return true
+		}
+	}
+
+	return false
+}
+
+// idSet returns the set of ids, the batch methods take the first todo of
+// each id out of it.
+func idSet(ids []string) map[string]bool {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+
+	return set
+}
+
+// deleteEach deletes the todos one by one with deleteTodo, for backends
+// without batches.
+func deleteEach(ctx context.Context, ids []string, deleteTodo func(context.Context, string) error) error {
+	for _, id := range ids {
+		if err := deleteTodo(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateEach updates the todos one by one with updateTodo, for backends
+// without batches. Ids without a todo are skipped.
+func updateEach(ctx context.Context, ids []string, update func(*Todo), updateTodo func(context.Context, string, func(*Todo)) error) error {
+	for _, id := range ids {
+		if err := updateTodo(ctx, id, update); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// completeEach completes the todos one by one with completeTodo, for
+// backends without batches. Ids without a todo are skipped.
+func completeEach(ctx context.Context, ids []string, completeTodo func(context.Context, string) error) error {
+	for _, id := range ids {
+		if err := completeTodo(ctx, id); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// subTasksOf returns the sub-tasks of the todo with id of forEach.
+func subTasksOf(ctx context.Context, forEach func(context.Context, func(Todo) error) error, id string) ([]SubTask, error) {
+	subTasks := []SubTask{}
+	found := false
+	err := forEach(ctx, func(todo Todo) error {
+		if todo.ID == id && !found {
+			found = true
+			subTasks = append(subTasks, todo.SubTasks...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	return subTasks, nil
+}
+
+// HasTag reports whether todo is tagged with tag, which has to be
+// normalized.
+func (todo Todo) HasTag(tag string) bool {
+	for _, t := range todo.Tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Tag is a tag in use and the number of todos tagged with it.
+type Tag struct {
+	Name  string `json:"name"`
+	Todos int    `json:"todos"`
+}
+
+// NormalizeTag lower cases tag and strips surrounding space and a leading
+// "#", so "#Home" and "home" are the same tag.
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+// normalizeTags returns the normalized tags sorted and without duplicates or
+// empty ones, nil if none are left.
+func normalizeTags(tags []string) []string {
+	var normalized []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = NormalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+
+	return normalized
+}
+
+// The named priorities, the values in between are valid as well.
+const (
+	PriorityNone   = 0
+	PriorityLow    = 1
+	PriorityMedium = 5
+	PriorityHigh   = 9
+)
+
+// TodoStatus is whether a todo is done, as used in filters.
+type TodoStatus string
+
+const (
+	StatusAny  TodoStatus = ""
+	StatusOpen TodoStatus = "open"
+	StatusDone TodoStatus = "done"
+)
+
+// TodoFilter narrows the todos returned by GetAllTodos, the zero value
+// matches all of them. A DueBefore other than zero only matches todos with
+// a due date before it, a Tag other than empty only todos tagged with it and
+// a Query only todos with all of its words, see SearchTodos. A ReadableBy
+// other than nil only matches the todos the Reader may read.
+type TodoFilter struct {
+	Status     TodoStatus
+	DueBefore  time.Time
+	Tag        string
+	Query      string
+	ReadableBy *Reader
+}
+
+// Reader is an account as far as reading todos goes, Shared maps the ids of
+// the todos shared with it to their permission. The Reader with an empty
+// Account stands for the requests that aren't signed in.
+type Reader struct {
+	Account string
+	Shared  map[string]string
+}
+
+// CanRead reports whether the reader may read todo: todos without an owner
+// are open to everyone, owned ones to their owner and the accounts they are
+// shared with.
+func (reader *Reader) CanRead(todo Todo) bool {
+	return todo.Owner == "" || todo.Owner == reader.Account || reader.Shared[todo.ID] != ""
+}
+
+// Matches reports whether todo passes the filter.
+func (filter TodoFilter) Matches(todo Todo) bool {
+	if filter.ReadableBy != nil && !filter.ReadableBy.CanRead(todo) {
+		return false
+	}
+	if !filter.DueBefore.IsZero() && (todo.Due == nil || !todo.Due.Before(filter.DueBefore)) {
+		return false
+	}
+	if filter.Tag != "" && !todo.HasTag(NormalizeTag(filter.Tag)) {
+		return false
+	}
+	if filter.Query != "" && !matchesQuery(todo, filter.Query) {
+		return false
+	}
+
+	switch filter.Status {
+	case StatusOpen:
+		return !todo.Done
+	case StatusDone:
+		return todo.Done
+	}
+
+	return true
+}
+
+// overdueFilter matches the open todos whose due date has passed.
+func overdueFilter() TodoFilter {
+	return TodoFilter{Status: StatusOpen, DueBefore: time.Now()}
+}
+
+// todosByDue returns the todos of getAll matching filter, which needs a
+// DueBefore, the soonest due first.
+func todosByDue(ctx context.Context, getAll func(context.Context, ...TodoFilter) ([]Todo, error), filter TodoFilter) ([]Todo, error) {
+	todos, err := getAll(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(todos, func(i, j int) bool {
+		return todos[i].Due.Before(*todos[j].Due)
+	})
+
+	return todos, nil
+}
+
+// dueKey formats t like the due dates in the stored documents, rounded up
+// to whole seconds so comparing the strings compares the times.
+func dueKey(t time.Time) string {
+	key := t.UTC().Truncate(time.Second)
+	if key.Before(t) {
+		key = key.Add(time.Second)
+	}
+
+	return key.Format(time.RFC3339)
+}
+
+// todosByPriority returns the todos of getAll matching all filters, the
+// highest priority first and in the order of the list within a priority.
+func todosByPriority(ctx context.Context, getAll func(context.Context, ...TodoFilter) ([]Todo, error), filters []TodoFilter) ([]Todo, error) {
+	todos, err := getAll(ctx, filters...)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(todos, func(i, j int) bool {
+		return todos[i].Priority > todos[j].Priority
+	})
+
+	return todos, nil
+}
+
+// todosByTag returns the todos of getAll tagged with tag, in the order of
+// the list.
+func todosByTag(ctx context.Context, getAll func(context.Context, ...TodoFilter) ([]Todo, error), tag string) ([]Todo, error) {
+	return getAll(ctx, TodoFilter{Tag: tag})
+}
+
+// CountTags counts the todos of every tag with forEach, for backends
+// without an index of the tags.
+func CountTags(ctx context.Context, forEach func(context.Context, func(Todo) error) error) ([]Tag, error) {
+	counts := map[string]int{}
+	err := forEach(ctx, func(todo Todo) error {
+		for _, tag := range todo.Tags {
+			counts[tag]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sortedTags(counts), nil
+}
+
+// sortedTags returns the tags with a count above zero, ordered by name.
+func sortedTags(counts map[string]int) []Tag {
+	tags := []Tag{}
+	for name, count := range counts {
+		if count > 0 {
+			tags = append(tags, Tag{Name: name, Todos: count})
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+
+	return tags
+}
+
+func matchesAll(filters []TodoFilter, todo Todo) bool {
+	for _, filter := range filters {
+		if !filter.Matches(todo) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// filterTodos keeps the todos matching all filters, in place.
+func filterTodos(todos []Todo, filters []TodoFilter) []Todo {
+	if len(filters) == 0 {
+		return todos
+	}
+
+	kept := todos[:0]
+	for _, todo := range todos {
+		if matchesAll(filters, todo) {
+			kept = append(kept, todo)
+		}
+	}
+
+	return kept
+}
+
+// ErrInvalidPage is returned by GetTodos for a negative offset or a limit
+// below one.
+var ErrInvalidPage = errors.New("offset must not be negative and limit must be positive")
+
+func checkPage(offset, limit int) error {
+	if offset < 0 || limit < 1 {
+		return ErrInvalidPage
+	}
+
+	return nil
+}
+
+// ErrInvalidPosition is returned by MoveTodo for a negative position.
+var ErrInvalidPosition = errors.New("position must not be negative")
+
+// moveOrder moves the todo with id to position, a position past the end
+// moves it to the end. It returns the old position of every todo in the new
+// order, the todos in between shift by one. It returns ErrNotFound if there
+// is no such todo.
+func moveOrder(todos []Todo, id string, position int) ([]int, error) {
+	if position < 0 {
+		return nil, ErrInvalidPosition
+	}
+
+	from := -1
+	for i, todo := range todos {
+		if todo.ID == id {
+			from = i
+			break
+		}
+	}
+	if from < 0 {
+		return nil, ErrNotFound
+	}
+	if position >= len(todos) {
+		position = len(todos) - 1
+	}
+
+	order := make([]int, 0, len(todos))
+	for i := range todos {
+		if i != from {
+			order = append(order, i)
+		}
+	}
+
+	return append(order[:position], append([]int{from}, order[position:]...)...), nil
+}
+
+// reordered returns todos in the order of moveOrder.
+func reordered(todos []Todo, order []int) []Todo {
+	moved := make([]Todo, len(order))
+	for i, old := range order {
+		moved[i] = todos[old]
+	}
+
+	return moved
+}
+
+// pageOf cuts the page starting at offset out of todos.
+func pageOf(todos []Todo, offset, limit int) []Todo {
+	if offset > len(todos) {
+		offset = len(todos)
+	}
+	end := len(todos)
+	if offset+limit < end {
+		end = offset + limit
+	}
+
+	return todos[offset:end]
+}
+
+// errPageFull ends the ForEachTodo of pageTodos.
+var errPageFull = errors.New("page full")
+
+// pageTodos reads one page of the todos matching all filters with forEach,
+// for backends that can't skip todos themselves. It stops reading once the
+// page is full.
+func pageTodos(ctx context.Context, forEach func(context.Context, func(Todo) error) error, offset, limit int, filters []TodoFilter) ([]Todo, error) {
+	if err := checkPage(offset, limit); err != nil {
+		return nil, err
+	}
+
+	todos := []Todo{}
+	skipped := 0
+	err := forEach(ctx, func(todo Todo) error {
+		if !matchesAll(filters, todo) {
+			return nil
+		}
+		if skipped < offset {
+			skipped++
+			return nil
+		}
+		todos = append(todos, todo)
+		if len(todos) == limit {
+			return errPageFull
+		}
+		return nil
+	})
+	if err != nil && err != errPageFull {
+		return nil, err
+	}
+
+	return todos, nil
+}
+
+// countTodos counts the todos matching all filters with forEach.
+func countTodos(ctx context.Context, forEach func(context.Context, func(Todo) error) error, filters []TodoFilter) (int, error) {
+	count := 0
+	err := forEach(ctx, func(todo Todo) error {
+		if matchesAll(filters, todo) {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// NewTodo returns an open todo with a fresh id.
+func NewTodo(title string) Todo {
+	now := time.Now().UTC()
+	return Todo{
+		ID:        newUUID(),
+		Title:     title,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// NewTodos wraps every title in a NewTodo.
+func NewTodos(titles []string) []Todo {
+	todos := make([]Todo, len(titles))
+	for i, title := range titles {
+		todos[i] = NewTodo(title)
+	}
+
+	return todos
+}
+
+// Titles returns the titles of todos in the same order.
+func Titles(todos []Todo) []string {
+	titles := make([]string, len(todos))
+	for i, todo := range todos {
+		titles[i] = todo.Title
+	}
+
+	return titles
+}
+
+// UnmarshalJSON also accepts a plain string, the format of the exports and
+// the API before todos had more than a title.
+func (todo *Todo) UnmarshalJSON(data []byte) error {
+	var title string
+	if err := json.Unmarshal(data, &title); err == nil {
+		*todo = legacyTodo(title)
+		return nil
+	}
+
+	type plain Todo
+	return json.Unmarshal(data, (*plain)(todo))
+}
+
+// withDefaults fills in what a caller left out, backends call it before
+// storing a todo.
+func (todo Todo) withDefaults() Todo {
+	if todo.ID == "" {
+		todo.ID = newUUID()
+	}
+	if todo.CreatedAt.IsZero() {
+		todo.CreatedAt = time.Now().UTC()
+	}
+	if todo.UpdatedAt.IsZero() {
+		todo.UpdatedAt = todo.CreatedAt
+	}
+	if todo.Due != nil {
+		due := todo.Due.UTC().Truncate(time.Second)
+		todo.Due = &due
+	}
+	todo.Tags = normalizeTags(todo.Tags)
+	// Imported sub-tasks may come without ids
+	subTasks := make([]SubTask, len(todo.SubTasks))
+	for i, subTask := range todo.SubTasks {
+		if subTask.ID == "" {
+			subTask.ID = newUUID()
+		}
+		subTasks[i] = subTask
+	}
+	if len(subTasks) > 0 {
+		todo.SubTasks = subTasks
+	}
+
+	return todo
+}
+
+// withDefaults returns a filled in copy of todos, for backends that need to
+// know the ids generated for them.
+func withDefaults(todos []Todo) []Todo {
+	filled := make([]Todo, len(todos))
+	for i, todo := range todos {
+		filled[i] = todo.withDefaults()
+	}
+
+	return filled
+}
+
+// edited returns todo changed by fn and marked as updated, backends call it
+// on the todo they update.
+func (todo Todo) edited(fn func(*Todo)) Todo {
+	fn(&todo)
+	todo.UpdatedAt = time.Now().UTC()
+
+	return todo.withDefaults()
+}
+
+func marshalTodo(todo Todo) string {
+	data, err := json.Marshal(todo)
+	if err != nil {
+		// A Todo only holds strings, times, numbers and a bool
+		panic(err)
+	}
+
+	return string(data)
+}
+
+// unmarshalTodo reads a document of marshalTodo. Values stored before todos
+// were documents are the bare title.
+func unmarshalTodo(value string) Todo {
+	if !strings.HasPrefix(value, "{") {
+		return legacyTodo(value)
+	}
+
+	var todo Todo
+	if err := json.Unmarshal([]byte(value), &todo); err != nil {
+		return legacyTodo(value)
+	}
+
+	return todo
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+
+	return formatUUID(b, 4)
+}
+
+// legacyTodo is a todo stored as its bare title. Its id is a name based
+// (version 5) UUID of the title, backends that have a key of their own for
+// the todo use that instead.
+func legacyTodo(title string) Todo {
+	sum := sha1.Sum([]byte(title))
+	var b [16]byte
+	copy(b[:], sum[:])
+
+	return Todo{ID: formatUUID(b, 5), Title: title}
+}
+
+func formatUUID(b [16]byte, version byte) string {
+	b[6] = b[6]&0x0f | version<<4
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// sqlTodoArgs are the title and doc columns of todo.
+func sqlTodoArgs(todo Todo) []interface{} {
+	todo = todo.withDefaults()
+	return []interface{}{todo.Title, marshalTodo(todo)}
+}
+
+// scanSQLTodo reads the id, title and doc columns. The doc is NULL in rows
+// written before it existed, the id of the row is the id of the todo then.
+func scanSQLTodo(row interface{ Scan(...interface{}) error }) (Todo, error) {
+	_, todo, err := scanSQLRow(row)
+	return todo, err
+}
+
+// scanSQLRow is scanSQLTodo that returns the id of the row as well.
+func scanSQLRow(row interface{ Scan(...interface{}) error }) (int64, Todo, error) {
+	var (
+		id    int64
+		title string
+		doc   sql.NullString
+	)
+	if err := row.Scan(&id, &title, &doc); err != nil {
+		return 0, Todo{}, err
+	}
+	if !doc.Valid {
+		return id, Todo{ID: strconv.FormatInt(id, 10), Title: title}, nil
+	}
+
+	return id, unmarshalTodo(doc.String), nil
+}
+
+// scanSQLTodos reads all rows with scanSQLTodo and closes them.
+func scanSQLTodos(rows *sql.Rows) ([]Todo, error) {
+	defer rows.Close()
+
+	todos := []Todo{}
+	for rows.Next() {
+		todo, err := scanSQLTodo(rows)
+		if err != nil {
+			return nil, err
+		}
+		todos = append(todos, todo)
+	}
+
+	return todos, rows.Err()
+}
+
+// moveSQLTodo moves the todo with id to position, rows are the id, title
+// and doc of all rows ordered by id. The rows keep their ids and with them
+// the order, update writes the todos that moved to their new rows.
+func moveSQLTodo(rows *sql.Rows, id string, position int, update func(todo Todo, rowID int64) error) error {
+	rowIDs := []int64{}
+	todos := []Todo{}
+	for rows.Next() {
+		rowID, todo, err := scanSQLRow(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		rowIDs = append(rowIDs, rowID)
+		todos = append(todos, todo)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	order, err := moveOrder(todos, id, position)
+	if err != nil {
+		return err
+	}
+	for i, old := range order {
+		if old != i {
+			if err := update(todos[old], rowIDs[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// scanSQLTags reads rows of a tag and its count and closes them.
+func scanSQLTags(rows *sql.Rows) ([]Tag, error) {
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.Name, &tag.Todos); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
@@ -0,0 +1,228 @@
+package tododb
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TrashedTodo is a deleted todo in the trash of the account that deleted it.
+type TrashedTodo struct {
+	Todo
+	DeletedAt time.Time `json:"deletedAt"`
+	// Grants are the accounts the todo was shared with and their
+	// permission, they are given back when it is restored
+	Grants map[string]string `json:"grants,omitempty"`
+}
+
+// UnmarshalJSON decodes DeletedAt and Grants next to the todo, the
+// UnmarshalJSON of the embedded Todo would drop them.
+func (trashed *TrashedTodo) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &trashed.Todo); err != nil {
+		return err
+	}
+
+	var deleted struct {
+		DeletedAt time.Time         `json:"deletedAt"`
+		Grants    map[string]string `json:"grants"`
+	}
+	if err := json.Unmarshal(data, &deleted); err != nil {
+		return err
+	}
+	trashed.DeletedAt = deleted.DeletedAt
+	trashed.Grants = deleted.Grants
+
+	return nil
+}
+
+// Trash keeps deleted todos until they are restored or purged, one trash
+// per account. The owner of deletions without an account is "".
+type Trash interface {
+	// TrashTodos puts todos that were just deleted into the trash of owner,
+	// their DeletedAt is set to now.
+	TrashTodos(owner string, todos []TrashedTodo) error
+	// GetTrash returns the trash of owner, the last deleted todo first.
+	GetTrash(owner string) ([]TrashedTodo, error)
+	// RestoreTodo takes the todo with id out of the trash of owner, to be
+	// saved again with its grants. It returns ErrNotFound if it isn't in
+	// there.
+	RestoreTodo(owner, id string) (TrashedTodo, error)
+	// PurgeTrash removes the todos deleted more than olderThan ago from
+	// every trash and returns them, on an error those purged until then.
+	PurgeTrash(olderThan time.Duration) ([]TrashedTodo, error)
+}
+
+const (
+	trashPrefix    = "trash:list:"
+	trashOwnersKey = "trash:owners"
+)
+
+// trashMu serializes the read-modify-writes of kvTrash in this process.
+var trashMu sync.Mutex
+
+// TrashOf returns the Trash of the backend, or one on top of its KV.
+func TrashOf(db TodoDB) Trash {
+	if trash, ok := db.(Trash); ok {
+		return trash
+	}
+
+	return kvTrash{KVOf(db)}
+}
+
+// kvTrash stores each trash as one JSON value, and the owners with a trash
+// in another one for PurgeTrash. Replicas that trash at the same time can
+// lose the update of one another.
+type kvTrash struct {
+	kv KV
+}
+
+func (trash kvTrash) TrashTodos(owner string, todos []TrashedTodo) error {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+
+	trashed, err := trash.load(trashPrefix + owner)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, todo := range todos {
+		todo.DeletedAt = now
+		trashed = append(trashed, todo)
+	}
+	if err := trash.save(trashPrefix+owner, trashed); err != nil {
+		return err
+	}
+
+	owners, err := trash.owners()
+	if err != nil {
+		return err
+	}
+	if owners[owner] {
+		return nil
+	}
+	owners[owner] = true
+
+	return trash.save(trashOwnersKey, owners)
+}
+
+func (trash kvTrash) GetTrash(owner string) ([]TrashedTodo, error) {
+	trashMu.Lock()
+	trashed, err := trash.load(trashPrefix + owner)
+	trashMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(trashed, func(i, j int) bool {
+		return trashed[i].DeletedAt.After(trashed[j].DeletedAt)
+	})
+	return trashed, nil
+}
+
+// RestoreTodo takes out the last deleted todo with id, the same todo can be
+// in the trash more than once if it was restored and deleted again.
+func (trash kvTrash) RestoreTodo(owner, id string) (TrashedTodo, error) {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+
+	trashed, err := trash.load(trashPrefix + owner)
+	if err != nil {
+		return TrashedTodo{}, err
+	}
+	for i := len(trashed) - 1; i >= 0; i-- {
+		if trashed[i].ID != id {
+			continue
+		}
+
+		todo := trashed[i]
+		trashed = append(trashed[:i:i], trashed[i+1:]...)
+		return todo, trash.save(trashPrefix+owner, trashed)
+	}
+
+	return TrashedTodo{}, ErrNotFound
+}
+
+func (trash kvTrash) PurgeTrash(olderThan time.Duration) ([]TrashedTodo, error) {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+
+	owners, err := trash.owners()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	var purged []TrashedTodo
+	for owner := range owners {
+		trashed, err := trash.load(trashPrefix + owner)
+		if err != nil {
+			return purged, err
+		}
+
+		var kept, gone []TrashedTodo
+		for _, todo := range trashed {
+			if todo.DeletedAt.After(cutoff) {
+				kept = append(kept, todo)
+			} else {
+				gone = append(gone, todo)
+			}
+		}
+		if len(gone) == 0 {
+			continue
+		}
+
+		if len(kept) > 0 {
+			err = trash.save(trashPrefix+owner, kept)
+		} else {
+			err = trash.kv.DeleteValue(trashPrefix + owner)
+			delete(owners, owner)
+		}
+		if err != nil {
+			return purged, err
+		}
+		purged = append(purged, gone...)
+	}
+	if len(purged) == 0 {
+		return nil, nil
+	}
+
+	return purged, trash.save(trashOwnersKey, owners)
+}
+
+func (trash kvTrash) load(key string) ([]TrashedTodo, error) {
+	var trashed []TrashedTodo
+	value, err := trash.kv.GetValue(key)
+	if err == ErrNotFound {
+		return trashed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(value), &trashed)
+	return trashed, err
+}
+
+func (trash kvTrash) owners() (map[string]bool, error) {
+	owners := map[string]bool{}
+	value, err := trash.kv.GetValue(trashOwnersKey)
+	if err == ErrNotFound {
+		return owners, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(value), &owners)
+	return owners, err
+}
+
+func (trash kvTrash) save(key string, value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return trash.kv.SetValue(key, string(encoded), 0)
+}
@@ -0,0 +1,156 @@
+package tododb
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Operation is a change of the todos that can be undone: the todos it
+// created are deleted again, the todos it changed or deleted are put back as
+// they were before.
+type Operation struct {
+	// Kind names the operation, like deleted, updated or bulk
+	Kind string `json:"kind"`
+	// Before are the changed and the deleted todos as they were before
+	Before []Todo `json:"before,omitempty"`
+	// Created are the ids of the todos the operation added
+	Created []string `json:"created,omitempty"`
+	// Grants are the grants of the deleted todos by their id, the accounts
+	// they were shared with and their permission
+	Grants map[string]map[string]string `json:"grants,omitempty"`
+	At     time.Time                    `json:"at"`
+}
+
+// UndoLog keeps the last operations of each account, the latest first. The
+// owner of operations without an account is "".
+type UndoLog interface {
+	// PushOperation adds op to the log of owner, which keeps the last depth
+	// operations.
+	PushOperation(owner string, op Operation, depth int) error
+	// PopOperation takes the latest operation out of the log of owner. It
+	// returns ErrNotFound if there is none.
+	PopOperation(owner string) (Operation, error)
+}
+
+const undoPrefix = "undo:"
+
+// undoMu serializes the read-modify-writes of kvUndoLog in this process.
+var undoMu sync.Mutex
+
+// UndoLogOf returns the UndoLog of the backend, or one on top of its KV.
+func UndoLogOf(db TodoDB) UndoLog {
+	if log, ok := db.(UndoLog); ok {
+		return log
+	}
+
+	return kvUndoLog{KVOf(db)}
+}
+
+// PushOperation keeps the log as a capped list, trimmed in the same
+// transaction.
+func (redisDB RedisDB) PushOperation(owner string, op Operation, depth int) error {
+	client, err := redisDB.primary()
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+
+	key := kvPrefix + undoPrefix + owner
+	_, err = client.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.LPush(context.Background(), key, string(value))
+		pipe.LTrim(context.Background(), key, 0, int64(depth-1))
+		return nil
+	})
+	return err
+}
+
+func (redisDB RedisDB) PopOperation(owner string) (Operation, error) {
+	client, err := redisDB.primary()
+	if err != nil {
+		return Operation{}, err
+	}
+
+	value, err := client.LPop(context.Background(), kvPrefix+undoPrefix+owner).Result()
+	if err == redis.Nil {
+		return Operation{}, ErrNotFound
+	}
+	if err != nil {
+		return Operation{}, err
+	}
+
+	var op Operation
+	err = json.Unmarshal([]byte(value), &op)
+	return op, err
+}
+
+// kvUndoLog stores each log as one JSON value. Replicas that push at the same
+// time can lose the operation of one another.
+type kvUndoLog struct {
+	kv KV
+}
+
+func (log kvUndoLog) PushOperation(owner string, op Operation, depth int) error {
+	undoMu.Lock()
+	defer undoMu.Unlock()
+
+	ops, err := log.load(owner)
+	if err != nil {
+		return err
+	}
+	ops = append([]Operation{op}, ops...)
+	if len(ops) > depth {
+		ops = ops[:depth]
+	}
+
+	return log.save(owner, ops)
+}
+
+func (log kvUndoLog) PopOperation(owner string) (Operation, error) {
+	undoMu.Lock()
+	defer undoMu.Unlock()
+
+	ops, err := log.load(owner)
+	if err != nil {
+		return Operation{}, err
+	}
+	if len(ops) == 0 {
+		return Operation{}, ErrNotFound
+	}
+
+	return ops[0], log.save(owner, ops[1:])
+}
+
+func (log kvUndoLog) load(owner string) ([]Operation, error) {
+	var ops []Operation
+	value, err := log.kv.GetValue(undoPrefix + owner)
+	if err == ErrNotFound {
+		return ops, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(value), &ops)
+	return ops, err
+}
+
+func (log kvUndoLog) save(owner string, ops []Operation) error {
+	if len(ops) == 0 {
+		return log.kv.DeleteValue(undoPrefix + owner)
+	}
+
+	value, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+
+	return log.kv.SetValue(undoPrefix+owner, string(value), 0)
+}
@@ -0,0 +1,96 @@
+package tododb
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrUserExists is returned by CreateUser for a taken username.
+var ErrUserExists = errors.New("user exists")
+
+// User is a local account, for deployments without an identity provider.
+// PasswordHash is encoded by the password package. TOTPSecret is only
+// checked at sign in once TOTPEnabled is set, before that the enrollment
+// isn't confirmed yet.
+type User struct {
+	Name         string    `json:"name"`
+	Email        string    `json:"email,omitempty"`
+	PasswordHash string    `json:"passwordHash"`
+	Disabled     bool      `json:"disabled"`
+	Created      time.Time `json:"created"`
+	TOTPSecret   string    `json:"totpSecret,omitempty"`
+	TOTPEnabled  bool      `json:"totpEnabled,omitempty"`
+	// RecoveryCodes are the SHA-256 hashes of the unused recovery codes
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+}
+
+// UserStore keeps the local accounts. GetUser returns ErrNotFound for
+// unknown users.
+type UserStore interface {
+	GetUser(name string) (User, error)
+	CreateUser(user User) error
+	UpdateUser(name string, update func(*User)) error
+}
+
+const userPrefix = "user:"
+
+// UsersOf returns the UserStore of the backend, or one on top of its KV.
+func UsersOf(db TodoDB) UserStore {
+	if users, ok := db.(UserStore); ok {
+		return users
+	}
+
+	return kvUsers{KVOf(db)}
+}
+
+type kvUsers struct {
+	kv KV
+}
+
+func (users kvUsers) GetUser(name string) (User, error) {
+	value, err := users.kv.GetValue(userPrefix + name)
+	if err != nil {
+		return User{}, err
+	}
+
+	var user User
+	err = json.Unmarshal([]byte(value), &user)
+	return user, err
+}
+
+// CreateUser claims the name with a counter first, so that two concurrent
+// registrations can't both get it.
+func (users kvUsers) CreateUser(user User) error {
+	claims, err := users.kv.IncrValue(userPrefix+"claim:"+user.Name, 0)
+	if err != nil {
+		return err
+	}
+	if claims > 1 {
+		return ErrUserExists
+	}
+
+	return users.set(user)
+}
+
+// UpdateUser is a read-modify-write, the last of two concurrent updates
+// wins.
+func (users kvUsers) UpdateUser(name string, update func(*User)) error {
+	user, err := users.GetUser(name)
+	if err != nil {
+		return err
+	}
+	update(&user)
+	user.Name = name
+
+	return users.set(user)
+}
+
+func (users kvUsers) set(user User) error {
+	value, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	return users.kv.SetValue(userPrefix+user.Name, string(value), 0)
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/jwt"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	signingKeysKey = "jwt:keys"
+	signingKeyBits = 2048
+	// signingKeysReload is how long the signing keys are cached, other
+	// replicas see a rotation after at most that long
+	signingKeysReload = time.Minute
+	// unknownKeyReload is how often an unknown kid reloads the keys early
+	unknownKeyReload = time.Second
+)
+
+// signingKey is a key access tokens are signed with. Only the newest key
+// signs, the older ones verify the tokens they signed until those expired.
+type signingKey struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	// Key is the private key, PKCS #1 DER
+	Key []byte `json:"key"`
+
+	private *rsa.PrivateKey
+}
+
+// keyring caches the signing keys of the key value store.
+type keyring struct {
+	mu     sync.Mutex
+	keys   []signingKey
+	loaded time.Time
+}
+
+var (
+	signingKeys keyring
+	// rotationMu serializes the rotations of a replica, the replicas claim
+	// a rotation in the key value store
+	rotationMu sync.Mutex
+)
+
+func accessTokenTTL() time.Duration {
+	return time.Duration(appConfig.Accounts.AccessTokenMinutes) * time.Minute
+}
+
+func signingKeyRotation() time.Duration {
+	return time.Duration(appConfig.Accounts.SigningKeyDays) * 24 * time.Hour
+}
+
+func readSigningKeys() ([]signingKey, error) {
+	value, err := tododb.KVOf(database).GetValue(signingKeysKey)
+	if err == tododb.ErrNotFound {
+		return []signingKey{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var keys []signingKey
+	if err := json.Unmarshal([]byte(value), &keys); err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		if keys[i].private, err = x509.ParsePKCS1PrivateKey(keys[i].Key); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// get returns the signing keys, the oldest first. maxAge is how old the
+// cached keys may be.
+func (k *keyring) get(maxAge time.Duration) ([]signingKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.keys != nil && time.Since(k.loaded) < maxAge {
+		return k.keys, nil
+	}
+	keys, err := readSigningKeys()
+	if err != nil {
+		return nil, err
+	}
+	k.keys, k.loaded = keys, time.Now()
+
+	return keys, nil
+}
+
+func (k *keyring) set(keys []signingKey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keys, k.loaded = keys, time.Now()
+}
+
+// publicKey returns the key of kid for jwt.Verify. Keys of other replicas
+// that aren't cached yet are loaded at once.
+func (k *keyring) publicKey(kid string) *rsa.PublicKey {
+	for _, maxAge := range []time.Duration{signingKeysReload, unknownKeyReload} {
+		keys, err := k.get(maxAge)
+		if err != nil {
+			logger.Errorf("%v", err)
+			return nil
+		}
+		for _, key := range keys {
+			if key.ID == kid {
+				return &key.private.PublicKey
+			}
+		}
+	}
+
+	return nil
+}
+
+// currentSigningKey returns the key to sign with, a new one if there is
+// none yet or the newest is due for rotation.
+func currentSigningKey() (signingKey, error) {
+	keys, err := signingKeys.get(signingKeysReload)
+	if err != nil {
+		return signingKey{}, err
+	}
+	if len(keys) > 0 && time.Since(keys[len(keys)-1].Created) < signingKeyRotation() {
+		return keys[len(keys)-1], nil
+	}
+
+	if keys, err = rotateSigningKeys(keys); err != nil {
+		return signingKey{}, err
+	}
+
+	return keys[len(keys)-1], nil
+}
+
+// rotateSigningKeys adds a new signing key after the newest of keys and
+// drops the keys no token signed by them is valid anymore. A rotation is
+// claimed in the key value store, so the replicas rotate a key once.
+func rotateSigningKeys(keys []signingKey) ([]signingKey, error) {
+	rotationMu.Lock()
+	defer rotationMu.Unlock()
+
+	newest := ""
+	if len(keys) > 0 {
+		newest = keys[len(keys)-1].ID
+	}
+	claims, err := tododb.KVOf(database).IncrValue("jwt:rotation:"+newest, signingKeysReload)
+	if err != nil {
+		return nil, err
+	}
+	if claims > 1 {
+		// Another replica or a rotation before rotates it
+		keys, err := signingKeys.get(0)
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) == 0 {
+			return nil, errors.New("the first signing key is created by another replica, try again")
+		}
+		return keys, nil
+	}
+
+	private, err := rsa.GenerateKey(rand.Reader, signingKeyBits)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	key := signingKey{
+		ID:      hex.EncodeToString(id),
+		Created: time.Now().UTC(),
+		Key:     x509.MarshalPKCS1PrivateKey(private),
+		private: private,
+	}
+
+	if keys, err = readSigningKeys(); err != nil {
+		return nil, err
+	}
+	// A key verifies until the tokens it signed expired, also those signed
+	// by replicas that didn't see its successor yet
+	kept := []signingKey{}
+	for i, old := range keys {
+		if i == len(keys)-1 || time.Since(keys[i+1].Created) < accessTokenTTL()+signingKeysReload {
+			kept = append(kept, old)
+		}
+	}
+	kept = append(kept, key)
+
+	value, err := json.Marshal(kept)
+	if err != nil {
+		return nil, err
+	}
+	if err := tododb.KVOf(database).SetValue(signingKeysKey, string(value), 0); err != nil {
+		return nil, err
+	}
+	signingKeys.set(kept)
+	logger.Infof("Rotated the signing key of access tokens to %s", key.ID)
+
+	return kept, nil
+}
+
+// tokenIssuer is the iss of the access tokens, the PublicURL if set.
+func tokenIssuer() string {
+	if appConfig.PublicURL != "" {
+		return strings.TrimSuffix(appConfig.PublicURL, "/")
+	}
+
+	return "todo-app"
+}
+
+func refreshTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "jwt-refresh:" + hex.EncodeToString(sum[:])
+}
+
+func refreshTokenUsesKey(token string) string {
+	return refreshTokenKey(token) + ":uses"
+}
+
+// tokenResponse is the token response of OAuth 2 (RFC 6749), as SPA
+// libraries expect it.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// issueTokens returns a new access token and a new refresh token of the
+// session s.
+func issueTokens(s session) (tokenResponse, error) {
+	key, err := currentSigningKey()
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	raw := make([]byte, 48)
+	if _, err := rand.Read(raw); err != nil {
+		return tokenResponse{}, err
+	}
+	now := time.Now()
+	access, err := jwt.Sign(jwt.Claims{
+		Issuer:    tokenIssuer(),
+		Subject:   s.Account,
+		ID:        hex.EncodeToString(raw[32:]),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(accessTokenTTL()).Unix(),
+		Session:   s.ID,
+	}, key.private, key.ID)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	refresh := hex.EncodeToString(raw[:32])
+	if err := tododb.KVOf(database).SetValue(refreshTokenKey(refresh), s.ID, sessionTTL()); err != nil {
+		return tokenResponse{}, err
+	}
+
+	return tokenResponse{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenTTL().Seconds()),
+		RefreshToken: refresh,
+	}, nil
+}
+
+// isJWT tells access tokens from the tokens of sessions, which are hex.
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// authenticateJWT signs the request in with an access token. Besides the
+// signature and the expiry the session of the token is checked like a
+// session token, its access tokens stop working as soon as the session is
+// revoked or the account is disabled.
+func authenticateJWT(c *gin.Context, token string) bool {
+	claims, err := jwt.Verify(token, signingKeys.publicKey, time.Now())
+	if err == nil && claims.Issuer != tokenIssuer() {
+		err = jwt.ErrUnknownKey
+	}
+	if err != nil {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"errors": "invalid or expired access token, refresh it",
+		})
+		return false
+	}
+
+	s, err := loadSession(claims.Session)
+	if err == nil && s.Account != claims.Subject {
+		err = tododb.ErrNotFound
+	}
+	return signInSession(c, s, err)
+}
+
+// createTokensHandler signs in like createSessionHandler and returns an
+// access token and a refresh token of the new session.
+func createTokensHandler(c *gin.Context) {
+	user, ok := signedInUser(c)
+	if !ok {
+		return
+	}
+
+	_, s, err := startSession(c, user.Name)
+	var tokens tokenResponse
+	if err == nil {
+		tokens, err = issueTokens(s)
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	logger.Infof("Started session %s of account %s with tokens", s.ID, user.Name)
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, tokens)
+}
+
+// refreshTokensHandler rotates a refresh token, it returns a new access
+// token and a new refresh token. Every refresh token works once, using one
+// again means it was stolen and revokes the session.
+func refreshTokensHandler(c *gin.Context) {
+	var request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || request.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "send the refresh_token",
+		})
+		return
+	}
+
+	invalid := func(message string) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errors": message,
+		})
+	}
+
+	kv := tododb.KVOf(database)
+	id, err := kv.GetValue(refreshTokenKey(request.RefreshToken))
+	if err == tododb.ErrNotFound {
+		invalid("invalid or expired refresh token, sign in again")
+		return
+	}
+	var uses int64
+	if err == nil {
+		uses, err = kv.IncrValue(refreshTokenUsesKey(request.RefreshToken), sessionTTL())
+	}
+	var s session
+	if err == nil {
+		s, err = loadSession(id)
+	}
+	var generation int64
+	if err == nil {
+		generation, err = sessionGeneration(s.Account)
+	}
+	if err == tododb.ErrNotFound || (err == nil && s.Generation != generation) {
+		invalid("session expired or revoked, sign in again")
+		return
+	}
+
+	if err == nil && uses > 1 {
+		if _, err = revokeSession(s.Account, s.ID); err == nil {
+			recordSecurityEvent(securityEvent{
+				Type:    eventTokenReuse,
+				Account: s.Account,
+				IP:      c.ClientIP(),
+				Detail:  "refresh token of session " + s.ID + " used again, the session is revoked",
+			})
+			invalid("refresh token used already, the session is revoked, sign in again")
+			return
+		}
+	}
+
+	var user tododb.User
+	if err == nil {
+		user, err = tododb.UsersOf(database).GetUser(s.Account)
+	}
+	if err == nil && user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{
+			"errors": "account " + user.Name + " is disabled",
+		})
+		return
+	}
+
+	var tokens tokenResponse
+	if err == nil {
+		s.LastSeen = time.Now().UTC()
+		s.IP = c.ClientIP()
+		err = saveSession(s)
+	}
+	if err == nil {
+		tokens, err = issueTokens(s)
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, tokens)
+}
+
+func jwksOf(keys []signingKey) gin.H {
+	jwks := make([]jwt.JWK, len(keys))
+	for i, key := range keys {
+		jwks[i] = jwt.JWKOf(&key.private.PublicKey, key.ID)
+	}
+
+	return gin.H{"keys": jwks}
+}
+
+// jwksHandler lists the public keys that verify the access tokens, for
+// other services that accept them.
+func jwksHandler(c *gin.Context) {
+	_, err := currentSigningKey()
+	var keys []signingKey
+	if err == nil {
+		keys, err = signingKeys.get(signingKeysReload)
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwksOf(keys))
+}
+
+// rotateSigningKeyHandler lets the admins rotate the signing key before it
+// is due, the old key still verifies the tokens it signed.
+func rotateSigningKeyHandler(c *gin.Context) {
+	keys, err := signingKeys.get(0)
+	if err == nil {
+		keys, err = rotateSigningKeys(keys)
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, jwksOf(keys))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTokens(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+	server.createAccount("jane")
+
+	var tokens tokenResponse
+	server.expect(http.StatusCreated, "POST", "/api/v1/tokens", asAccount("jane"), nil, &tokens)
+	if tokens.TokenType != "Bearer" || !isJWT(tokens.AccessToken) || tokens.RefreshToken == "" {
+		t.Fatalf("the tokens are %+v, want a bearer JWT and a refresh token", tokens)
+	}
+	var own account
+	server.expect(http.StatusOK, "GET", "/api/v1/account", withToken(tokens.AccessToken), nil, &own)
+	if own.Name != "jane" {
+		t.Errorf("the access token signs in %q, want jane", own.Name)
+	}
+
+	parts := strings.Split(tokens.AccessToken, ".")
+	forged := parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2]))
+	server.expect(http.StatusUnauthorized, "GET", "/api/v1/account", withToken(forged), nil, nil)
+
+	var refreshed tokenResponse
+	server.expect(http.StatusOK, "POST", "/api/v1/tokens/refresh", anonymous, gin.H{"refresh_token": tokens.RefreshToken}, &refreshed)
+	server.expect(http.StatusOK, "GET", "/api/v1/account", withToken(refreshed.AccessToken), nil, nil)
+
+	// the first refresh token again was stolen, the session is revoked
+	server.expect(http.StatusUnauthorized, "POST", "/api/v1/tokens/refresh", anonymous, gin.H{"refresh_token": tokens.RefreshToken}, nil)
+	server.expect(http.StatusUnauthorized, "GET", "/api/v1/account", withToken(refreshed.AccessToken), nil, nil)
+	server.expect(http.StatusUnauthorized, "POST", "/api/v1/tokens/refresh", anonymous, gin.H{"refresh_token": refreshed.RefreshToken}, nil)
+}
+
+func TestJWKS(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+	server.createAccount("jane")
+
+	var tokens tokenResponse
+	server.expect(http.StatusCreated, "POST", "/api/v1/tokens", asAccount("jane"), nil, &tokens)
+	var before, after struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+		} `json:"keys"`
+	}
+	server.expect(http.StatusOK, "GET", "/.well-known/jwks.json", anonymous, nil, &before)
+	server.expect(http.StatusUnauthorized, "POST", "/admin/jwt/rotate", asAccount("jane"), nil, nil)
+	server.expect(http.StatusOK, "POST", "/admin/jwt/rotate", asAdmin, nil, &after)
+	if len(before.Keys) != 1 || len(after.Keys) != 2 || after.Keys[0].Kid != before.Keys[0].Kid {
+		t.Errorf("the keys are %+v before the rotation and %+v after, want the old key kept", before.Keys, after.Keys)
+	}
+
+	// the old key still verifies the tokens it signed
+	server.expect(http.StatusOK, "GET", "/api/v1/account", withToken(tokens.AccessToken), nil, nil)
+}
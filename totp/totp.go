@@ -0,0 +1,99 @@
+// Package totp generates and checks time-based one-time passwords (RFC
+// 6238) as authenticator apps show them: HMAC-SHA1, 6 digits and a step of
+// 30 seconds.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Step is how long a code is valid
+	Step   = 30 * time.Second
+	digits = 6
+	// Skew is how many steps a code may be off, for clocks that are a bit
+	// off
+	Skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ErrInvalidSecret is returned for secrets that aren't base32.
+var ErrInvalidSecret = errors.New("totp: invalid secret")
+
+// NewSecret returns a random secret of 160 bits, base32 encoded.
+func NewSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(secret), nil
+}
+
+// StepOf returns the time step of t, the moving factor of the codes.
+func StepOf(t time.Time) int64 {
+	return t.Unix() / int64(Step/time.Second)
+}
+
+// Code returns the code of secret for the time step.
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", ErrInvalidSecret
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", digits, value%1000000), nil
+}
+
+// Validate checks code against the steps around t. It returns the step the
+// code belongs to, callers should accept every step only once.
+func Validate(secret, code string, t time.Time) (int64, bool, error) {
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != digits {
+		return 0, false, nil
+	}
+
+	now := StepOf(t)
+	for step := now - Skew; step <= now+Skew; step++ {
+		expected, err := Code(secret, step)
+		if err != nil {
+			return 0, false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// URI is the otpauth:// URI authenticator apps scan from a QR code.
+func URI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+
+	// Some apps show a + in the query as it is
+	return "otpauth://totp/" + label + "?" + strings.Replace(query.Encode(), "+", "%20", -1)
+}
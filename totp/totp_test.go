@@ -0,0 +1,109 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed of the RFC 6238 test vectors,
+// "12345678901234567890" in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// RFC 6238, appendix B, with the last 6 of the 8 digits
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, test := range tests {
+		code, err := Code(rfcSecret, StepOf(time.Unix(test.time, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != test.code {
+			t.Errorf("Code() at %d = %s, want %s", test.time, code, test.code)
+		}
+	}
+}
+
+func TestCodeSecret(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		err    error
+	}{
+		{name: "lower case", secret: "gezdgnbvgy3tqojqgezdgnbvgy3tqojq"},
+		{name: "padded", secret: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ===="},
+		{name: "not base32", secret: "GEZDGNBVGY3TQOJ1", err: ErrInvalidSecret},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, err := Code(test.secret, 1)
+			if err != test.err {
+				t.Fatalf("Code() error = %v, want %v", err, test.err)
+			}
+			if err == nil && code != "287082" {
+				t.Errorf("Code() = %s, want 287082", code)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	tests := []struct {
+		name string
+		code string
+		step int64
+		ok   bool
+	}{
+		{name: "current step", code: "050471", step: 37037037, ok: true},
+		{name: "with a space", code: "050 471", step: 37037037, ok: true},
+		{name: "previous step", code: "081804", step: 37037036, ok: true},
+		{name: "eight digits", code: "14050471"},
+		{name: "wrong code", code: "050472"},
+		{name: "too old", code: "287082"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			step, ok, err := Validate(rfcSecret, test.code, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != test.ok || step != test.step {
+				t.Errorf("Validate() = %d, %v, want %d, %v", step, ok, test.step, test.ok)
+			}
+		})
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("NewSecret() = %q, want 32 characters", secret)
+	}
+	if _, err := Code(secret, 0); err != nil {
+		t.Errorf("Code() of a new secret: %v", err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	got := URI("Todo App", "jane doe@example.com", rfcSecret)
+	want := "otpauth://totp/Todo%20App:jane%20doe@example.com?issuer=Todo%20App&secret=" + rfcSecret
+	if got != want {
+		t.Errorf("URI() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	defaultTrashDays = 30
+	// trashPurgeInterval is how often the todos older than TrashDays are
+	// purged
+	trashPurgeInterval = time.Hour
+)
+
+// trashTodos puts deleted todos into the trash of the signed in account, with
+// the grants takeGrants took from them. The requests that aren't signed in
+// share the trash of the account "", anyone of them can list and restore what
+// another deleted. The todos are gone already, a failure only loses the way
+// back.
+func trashTodos(c *gin.Context, grants map[string]map[string]string, todos ...tododb.Todo) {
+	trashed := make([]tododb.TrashedTodo, 0, len(todos))
+	for _, todo := range todos {
+		trashed = append(trashed, tododb.TrashedTodo{Todo: todo, Grants: grants[todo.ID]})
+	}
+	if err := tododb.TrashOf(database).TrashTodos(c.GetString(accountKey), trashed); err != nil {
+		logger.Errorf("%v", err)
+	}
+}
+
+// trashAge is the time deleted todos are kept in the trash.
+func trashAge(days int) time.Duration {
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// runTrashPurges purges the todos deleted more than days ago, every
+// trashPurgeInterval.
+func runTrashPurges(days int) {
+	for range time.Tick(trashPurgeInterval) {
+		purged, err := tododb.TrashOf(database).PurgeTrash(trashAge(days))
+		dropAttachments(purged)
+		if err != nil {
+			logger.Errorf("Trash purge failed: %v", err)
+		} else if len(purged) > 0 {
+			logger.Infof("Purged %d todos from the trash", len(purged))
+		}
+	}
+}
+
+func listTrashHandler(c *gin.Context) {
+	trashed, err := tododb.TrashOf(database).GetTrash(c.GetString(accountKey))
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	if trashed == nil {
+		trashed = []tododb.TrashedTodo{}
+	}
+
+	c.JSON(http.StatusOK, trashed)
+}
+
+// restoreTodoHandler takes a todo out of the trash and adds it to the end of
+// the list again, with its id, its owner and its grants. The trash is the one
+// of the account that deleted the todo, it may put it back. The todo is put
+// back into the trash if saving it fails.
+func restoreTodoHandler(c *gin.Context) {
+	owner := c.GetString(accountKey)
+	trash := tododb.TrashOf(database)
+	trashed, err := trash.RestoreTodo(owner, c.Param("id"))
+	if err == tododb.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": "todo not found in the trash",
+		})
+		return
+	}
+	if err == nil {
+		if err = database.SaveTodo(c.Request.Context(), trashed.Todo); err != nil {
+			if err := trash.TrashTodos(owner, []tododb.TrashedTodo{trashed}); err != nil {
+				logger.Errorf("%v", err)
+			}
+		}
+	}
+	if err == nil {
+		err = restoreGrants(trashed.ID, trashed.Grants)
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	publishChange(changeCreated, trashed.Todo)
+	dropCachedSmartLists()
+
+	c.JSON(http.StatusOK, trashed.Todo)
+}
+
+// purgeTrashHandler removes the todos deleted more than ?days= ago from
+// every trash, by default TrashDays, 0 empties them.
+func purgeTrashHandler(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", strconv.Itoa(appConfig.TrashDays)))
+	if err != nil || days < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("invalid days: %q", c.Query("days")),
+		})
+		return
+	}
+
+	purged, err := tododb.TrashOf(database).PurgeTrash(trashAge(days))
+	dropAttachments(purged)
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"purged": len(purged),
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+func TestRestoreKeepsOwner(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+	server.createAccount("jane")
+	server.createAccount("bob")
+	jane, bob := asAccount("jane"), asAccount("bob")
+	todo := server.createTodo(jane, "Renew the passport")
+	server.grant(jane, todo.ID, "bob", permissionRead)
+
+	server.expect(http.StatusNoContent, "DELETE", "/api/v1/todos/"+todo.ID, jane, nil, nil)
+	var shared []grantedTodo
+	server.expect(http.StatusOK, "GET", "/api/v1/granted/todos", bob, nil, &shared)
+	if len(shared) != 0 {
+		t.Errorf("bob still has the grant of the deleted todo: %+v", shared)
+	}
+
+	// the todo is in the trash of jane only
+	for name, authorize := range map[string]authorization{"bob": bob, "anonymous": anonymous} {
+		var trashed []tododb.TrashedTodo
+		server.expect(http.StatusOK, "GET", "/api/v1/trash", authorize, nil, &trashed)
+		if len(trashed) != 0 {
+			t.Errorf("the trash of %s has %+v", name, trashed)
+		}
+		server.expect(http.StatusNotFound, "POST", "/api/v1/trash/"+todo.ID+"/restore", authorize, nil, nil)
+	}
+	var trashed []tododb.TrashedTodo
+	server.expect(http.StatusOK, "GET", "/api/v1/trash", jane, nil, &trashed)
+	if len(trashed) != 1 || trashed[0].ID != todo.ID {
+		t.Fatalf("the trash of jane has %+v, want the deleted todo", trashed)
+	}
+
+	var restored tododb.Todo
+	server.expect(http.StatusOK, "POST", "/api/v1/trash/"+todo.ID+"/restore", jane, nil, &restored)
+	if restored.ID != todo.ID || restored.Owner != "jane" {
+		t.Errorf("the restored todo is %+v, want the one of jane", restored)
+	}
+	if _, found := findTodoIn(server.todos(anonymous), todo.ID); found {
+		t.Errorf("the restored todo is listed anonymously")
+	}
+	server.expect(http.StatusOK, "GET", "/api/v1/granted/todos", bob, nil, &shared)
+	if len(shared) != 1 || shared[0].ID != todo.ID || shared[0].Permission != permissionRead {
+		t.Errorf("bob has the shared todos %+v, want the restored one to read", shared)
+	}
+	server.expect(http.StatusNotFound, "POST", "/api/v1/trash/"+todo.ID+"/restore", jane, nil, nil)
+}
+
+func TestPurgeTrash(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+	todo := server.createTodo(anonymous, "Buy milk")
+	server.expect(http.StatusNoContent, "DELETE", "/api/v1/todos/"+todo.ID, anonymous, nil, nil)
+
+	server.expect(http.StatusBadRequest, "DELETE", "/admin/trash?days=-1", asAdmin, nil, nil)
+	var purged struct {
+		Purged int `json:"purged"`
+	}
+	server.expect(http.StatusOK, "DELETE", "/admin/trash", asAdmin, nil, &purged)
+	if purged.Purged != 0 {
+		t.Errorf("purged %d todos younger than TrashDays", purged.Purged)
+	}
+	server.expect(http.StatusOK, "DELETE", "/admin/trash?days=0", asAdmin, nil, &purged)
+	if purged.Purged != 1 {
+		t.Errorf("purged %d todos with days=0, want 1", purged.Purged)
+	}
+	server.expect(http.StatusNotFound, "POST", "/api/v1/trash/"+todo.ID+"/restore", anonymous, nil, nil)
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/qrcode"
+	"github.com/johscheuer/todo-app-web/tododb"
+	"github.com/johscheuer/todo-app-web/totp"
+)
+
+const (
+	// otpHeader carries the two-factor code or a recovery code at sign in
+	otpHeader = "X-OTP"
+
+	recoveryCodeCount = 10
+	// recoveryCodeUseTTL is how long a used recovery code is remembered, it
+	// is removed from the account right after
+	recoveryCodeUseTTL = 24 * time.Hour
+	enrollmentQRScale  = 4
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// normalizeRecoveryCode drops the dash and the case, as recovery codes are
+// typed in by hand.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCodes returns codes like 7kq2m-x4hpa and their hashes. The
+// codes are random enough that a fast hash is fine for them.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(raw))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// checkSecondFactor accepts a current code of the authenticator app or an
+// unused recovery code. Every code works only once, the used ones are
+// claimed in the KV so two concurrent sign ins can't both use them. Basic
+// auth sends the code again on every request, so with the ip of the client
+// a code is accepted again from the client IP that used it first.
+func checkSecondFactor(user tododb.User, code, ip string) (bool, error) {
+	kv := tododb.KVOf(database)
+	if normalized := strings.Replace(code, " ", "", -1); len(normalized) == 6 {
+		step, valid, err := totp.Validate(user.TOTPSecret, normalized, time.Now())
+		if err != nil || !valid {
+			return false, err
+		}
+
+		key := "totp:used:" + user.Name + ":" + strconv.FormatInt(step, 10)
+		ttl := (2*totp.Skew + 1) * totp.Step
+		claims, err := kv.IncrValue(key, ttl)
+		if err != nil || ip == "" {
+			return claims == 1, err
+		}
+		if claims == 1 {
+			return true, kv.SetValue(key+":ip", ip, ttl)
+		}
+
+		usedBy, err := kv.GetValue(key + ":ip")
+		if err == tododb.ErrNotFound {
+			return false, nil
+		}
+		return usedBy == ip, err
+	}
+
+	hash := hashRecoveryCode(code)
+	unused := false
+	for _, candidate := range user.RecoveryCodes {
+		unused = unused || candidate == hash
+	}
+	if !unused {
+		return false, nil
+	}
+
+	claims, err := kv.IncrValue("recovery:used:"+hash, recoveryCodeUseTTL)
+	if err != nil || claims != 1 {
+		return false, err
+	}
+
+	logger.Infof("Account %s used a recovery code", user.Name)
+	return true, tododb.UsersOf(database).UpdateUser(user.Name, func(user *tododb.User) {
+		kept := []string{}
+		for _, candidate := range user.RecoveryCodes {
+			if candidate != hash {
+				kept = append(kept, candidate)
+			}
+		}
+		user.RecoveryCodes = kept
+	})
+}
+
+// signedInUser returns the account the request is signed in with by
+// accountAuth.
+func signedInUser(c *gin.Context) (tododb.User, bool) {
+	name := c.GetString(accountKey)
+	if name == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errors": "sign in with an account, accountAuth is missing in the account middleware",
+		})
+		return tododb.User{}, false
+	}
+
+	user, err := tododb.UsersOf(database).GetUser(name)
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return tododb.User{}, false
+	}
+
+	return user, true
+}
+
+func ownAccountHandler(c *gin.Context) {
+	user, ok := signedInUser(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, accountOf(user))
+}
+
+// enrollTwoFactorHandler starts the enrollment with a new secret, to be
+// scanned as QR code. The secret is used at sign in once a code of it is
+// confirmed.
+func enrollTwoFactorHandler(c *gin.Context) {
+	user, ok := signedInUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{
+			"errors": "two-factor authentication is enabled already",
+		})
+		return
+	}
+
+	secret, err := totp.NewSecret()
+	var image []byte
+	uri := totp.URI(appConfig.Accounts.TOTPIssuer, user.Name, secret)
+	if err == nil {
+		var code *qrcode.Code
+		if code, err = qrcode.Encode([]byte(uri)); err == nil {
+			image, err = code.PNG(enrollmentQRScale)
+		}
+	}
+	if err == nil {
+		err = tododb.UsersOf(database).UpdateUser(user.Name, func(user *tododb.User) {
+			user.TOTPSecret = secret
+		})
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret": secret,
+		"uri":    uri,
+		"qrCode": "data:image/png;base64," + base64.StdEncoding.EncodeToString(image),
+	})
+}
+
+// confirmTwoFactorHandler enables two-factor authentication with a code of
+// the enrolled secret and returns the recovery codes, the only time they
+// are shown.
+func confirmTwoFactorHandler(c *gin.Context) {
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	user, ok := signedInUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled || user.TOTPSecret == "" {
+		c.JSON(http.StatusConflict, gin.H{
+			"errors": "no enrollment to confirm, start one with POST /api/v1/account/2fa",
+		})
+		return
+	}
+
+	valid, err := checkSecondFactor(user, request.Code, "")
+	if err == nil && !valid {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "invalid code, check the clock of the device",
+		})
+		return
+	}
+
+	var codes, hashes []string
+	if err == nil {
+		codes, hashes, err = newRecoveryCodes()
+	}
+	if err == nil {
+		err = tododb.UsersOf(database).UpdateUser(user.Name, func(user *tododb.User) {
+			user.TOTPEnabled = true
+			user.RecoveryCodes = hashes
+		})
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	logger.Infof("Enabled two-factor authentication of account %s", user.Name)
+	c.JSON(http.StatusOK, gin.H{
+		"recoveryCodes": codes,
+	})
+}
+
+// newRecoveryCodesHandler replaces the recovery codes, the old ones stop
+// working.
+func newRecoveryCodesHandler(c *gin.Context) {
+	user, ok := signedInUser(c)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{
+			"errors": "two-factor authentication is not enabled",
+		})
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err == nil {
+		err = tododb.UsersOf(database).UpdateUser(user.Name, func(user *tododb.User) {
+			user.RecoveryCodes = hashes
+		})
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"recoveryCodes": codes,
+	})
+}
+
+// disableTwoFactorHandler turns two-factor authentication off for the
+// signed in account, whose sign in already needed a code. Admins do the same
+// for any account with resetTwoFactorHandler.
+func disableTwoFactorHandler(c *gin.Context) {
+	user, ok := signedInUser(c)
+	if !ok {
+		return
+	}
+
+	if err := resetTwoFactor(user.Name); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func resetTwoFactorHandler(c *gin.Context) {
+	name := c.Param("name")
+	err := resetTwoFactor(name)
+	if err == tododb.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": "account " + name + " not found",
+		})
+		return
+	} else if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	getAccountHandler(c)
+}
+
+func resetTwoFactor(name string) error {
+	err := tododb.UsersOf(database).UpdateUser(name, func(user *tododb.User) {
+		user.TOTPSecret = ""
+		user.TOTPEnabled = false
+		user.RecoveryCodes = nil
+	})
+	if err == nil {
+		logger.Infof("Disabled two-factor authentication of account %s", name)
+	}
+
+	return err
+}
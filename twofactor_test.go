@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/tododb"
+	"github.com/johscheuer/todo-app-web/totp"
+)
+
+func TestCheckSecondFactorRetry(t *testing.T) {
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := totp.StepOf(time.Now())
+	code, err := totp.Code(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for s := step - totp.Skew; s <= step+totp.Skew; s++ {
+		key := "totp:used:jane:" + strconv.FormatInt(s, 10)
+		keys = append(keys, key, key+":ip")
+	}
+	_, restore := useMemoryDatabase(t, keys)
+	defer restore()
+
+	user := tododb.User{Name: "jane", TOTPSecret: secret, TOTPEnabled: true}
+	tests := []struct {
+		name, ip string
+		want     bool
+	}{
+		{name: "first use", ip: "10.0.0.1", want: true},
+		{name: "basic auth retry", ip: "10.0.0.1", want: true},
+		{name: "other client", ip: "10.0.0.2", want: false},
+		{name: "without client", ip: "", want: false},
+	}
+	for _, test := range tests {
+		valid, err := checkSecondFactor(user, code, test.ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid != test.want {
+			t.Errorf("%s: checkSecondFactor() = %t, want %t", test.name, valid, test.want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const defaultUndoDepth = 20
+
+// The kinds of operations that can be undone.
+const (
+	operationDeleted = "deleted"
+	operationUpdated = "updated"
+	operationBulk    = "bulk"
+)
+
+// recordOperation adds op to the undo log of the signed in account. Requests
+// that aren't signed in can't undo, they have no log. The change is stored
+// already, a failure only loses the undo.
+func recordOperation(c *gin.Context, op tododb.Operation) {
+	account := c.GetString(accountKey)
+	if account == "" {
+		return
+	}
+	op.At = time.Now().UTC()
+	if err := tododb.UndoLogOf(database).PushOperation(account, op, appConfig.UndoDepth); err != nil {
+		logger.Errorf("%v", err)
+	}
+}
+
+// undoHandler reverts the latest operation of the account and answers with
+// the todos it put back. A failed undo stays in the log to be tried again.
+func undoHandler(c *gin.Context) {
+	owner := c.GetString(accountKey)
+	undoLog := tododb.UndoLogOf(database)
+	op, err := undoLog.PopOperation(owner)
+	if err == tododb.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": "nothing to undo",
+		})
+		return
+	}
+
+	var restored []tododb.Todo
+	if err == nil {
+		if restored, err = undoOperation(c.Request.Context(), todosOf(c), owner, op); err != nil {
+			if err := undoLog.PushOperation(owner, op, appConfig.UndoDepth); err != nil {
+				logger.Errorf("%v", err)
+			}
+		}
+	}
+	if err == errNoPermission || err == errNotOwner {
+		c.JSON(http.StatusForbidden, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	dropCachedSmartLists()
+	if restored == nil {
+		restored = []tododb.Todo{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"undone": op.Kind,
+		"at":     op.At,
+		"todos":  restored,
+	})
+}
+
+// undoOperation deletes the todos op created and puts back the todos it
+// changed or deleted, the deleted ones at the end of the list and out of the
+// trash of owner. The changes go through todos, the view of the account, the
+// deleted todos come back as they were with their owner and their grants. It
+// returns the todos put back.
+func undoOperation(ctx context.Context, todos tododb.TodoDB, owner string, op tododb.Operation) ([]tododb.Todo, error) {
+	all, err := database.GetAllTodos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current := map[string]bool{}
+	for _, todo := range all {
+		current[todo.ID] = true
+	}
+
+	var created []tododb.Todo
+	for _, id := range op.Created {
+		for _, todo := range all {
+			if todo.ID == id {
+				created = append(created, todo)
+				break
+			}
+		}
+	}
+	if len(created) > 0 {
+		if err := todos.DeleteTodos(ctx, todoIDs(created)); err != nil {
+			return nil, err
+		}
+		publishChange(changeDeleted, created...)
+	}
+
+	var reverted, readded []tododb.Todo
+	for _, before := range op.Before {
+		if current[before.ID] {
+			if err := revertTodo(ctx, todos, before); err != nil {
+				return nil, err
+			}
+			reverted = append(reverted, before)
+			continue
+		}
+
+		if _, err := tododb.TrashOf(database).RestoreTodo(owner, before.ID); err != nil && err != tododb.ErrNotFound {
+			return nil, err
+		}
+		readded = append(readded, before)
+	}
+	if len(reverted) > 0 {
+		publishChange(changeUpdated, reverted...)
+	}
+	if len(readded) > 0 {
+		if err := database.SaveTodos(ctx, readded); err != nil {
+			return nil, err
+		}
+		for _, todo := range readded {
+			if err := restoreGrants(todo.ID, op.Grants[todo.ID]); err != nil {
+				logger.Errorf("%v", err)
+			}
+		}
+		publishChange(changeCreated, readded...)
+	}
+
+	return append(reverted, readded...), nil
+}
+
+// revertTodo sets the fields of a todo of todos an update can change back to
+// those of before, in one write. Its place in the list stays where it is
+// now.
+func revertTodo(ctx context.Context, todos tododb.TodoDB, before tododb.Todo) error {
+	return todos.UpdateTodo(ctx, before.ID, func(todo *tododb.Todo) {
+		todo.Title = before.Title
+		todo.Description = before.Description
+		todo.Done = before.Done
+		todo.Due = before.Due
+		todo.Priority = before.Priority
+		todo.Tags = before.Tags
+		todo.Recurrence = before.Recurrence
+		todo.Estimate = before.Estimate
+	})
+}
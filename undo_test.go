@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+type undoResponse struct {
+	Undone string        `json:"undone"`
+	Todos  []tododb.Todo `json:"todos"`
+}
+
+func TestUndoDelete(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+	server.createAccount("jane")
+	server.createAccount("bob")
+	jane, bob := asAccount("jane"), asAccount("bob")
+	todo := server.createTodo(jane, "Renew the passport")
+	server.grant(jane, todo.ID, "bob", permissionWrite)
+	server.expect(http.StatusNoContent, "DELETE", "/api/v1/todos/"+todo.ID, jane, nil, nil)
+
+	server.expect(http.StatusUnauthorized, "POST", "/api/undo", anonymous, nil, nil)
+	// the deletion is in the log of jane, bob has nothing to undo
+	server.expect(http.StatusNotFound, "POST", "/api/undo", bob, nil, nil)
+
+	var undone undoResponse
+	server.expect(http.StatusOK, "POST", "/api/undo", jane, nil, &undone)
+	if undone.Undone != operationDeleted || len(undone.Todos) != 1 || undone.Todos[0].Owner != "jane" {
+		t.Errorf("the undo answered %+v, want the deleted todo of jane", undone)
+	}
+	if _, found := findTodoIn(server.todos(anonymous), todo.ID); found {
+		t.Errorf("the todo put back is listed anonymously")
+	}
+	if _, found := findTodoIn(server.todos(bob), todo.ID); !found {
+		t.Errorf("bob lost the grant of the todo put back")
+	}
+	var trashed []tododb.TrashedTodo
+	server.expect(http.StatusOK, "GET", "/api/v1/trash", jane, nil, &trashed)
+	if len(trashed) != 0 {
+		t.Errorf("the todo put back is still in the trash: %+v", trashed)
+	}
+}
+
+func TestUndoUpdate(t *testing.T) {
+	server, restore := newTestServer(t)
+	defer restore()
+	server.createAccount("jane")
+	server.createAccount("bob")
+	jane, bob := asAccount("jane"), asAccount("bob")
+	todo := server.createTodo(jane, "Renew the passport")
+	server.grant(jane, todo.ID, "bob", permissionWrite)
+
+	server.expect(http.StatusOK, "PATCH", "/api/v1/todos/"+todo.ID, bob, gin.H{"title": "Renew the ID card", "done": true}, nil)
+	// bob may only read it anymore, the update can't be undone
+	server.grant(jane, todo.ID, "bob", permissionRead)
+	server.expect(http.StatusForbidden, "POST", "/api/undo", bob, nil, nil)
+
+	server.grant(jane, todo.ID, "bob", permissionWrite)
+	var undone undoResponse
+	server.expect(http.StatusOK, "POST", "/api/undo", bob, nil, &undone)
+	reverted, _ := findTodoIn(server.todos(jane), todo.ID)
+	if undone.Undone != operationUpdated || reverted.Title != "Renew the passport" || reverted.Done || reverted.Owner != "jane" {
+		t.Errorf("the undo answered %+v and left %+v, want the todo of jane as before", undone, reverted)
+	}
+}
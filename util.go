@@ -1,8 +1,13 @@
 package main
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
 )
 
 func getAllAddresses(ifaces []net.Interface) ([]string, error) {
@@ -10,7 +15,7 @@ func getAllAddresses(ifaces []net.Interface) ([]string, error) {
 	for _, i := range ifaces {
 		addrs, err := i.Addrs()
 		if err != nil {
-			fmt.Println(err)
+			logger.Errorf("%v", err)
 			return addresses, err
 		}
 
@@ -21,3 +26,22 @@ func getAllAddresses(ifaces []net.Interface) ([]string, error) {
 
 	return addresses, nil
 }
+
+// writeWithETag sends body with a validator so browsers can revalidate the
+// list with If-None-Match instead of downloading it again on every poll.
+// Handlers with their own cache policy set Cache-Control before.
+func writeWithETag(c *gin.Context, contentType string, body []byte) {
+	sum := sha1.Sum(body)
+	etag := fmt.Sprintf("W/\"%s\"", hex.EncodeToString(sum[:]))
+
+	if c.Writer.Header().Get("Cache-Control") == "" {
+		c.Header("Cache-Control", "no-cache")
+	}
+	c.Header("ETag", etag)
+	if c.Request.Method == http.MethodGet && c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, contentType, body)
+}
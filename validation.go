@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/api"
+)
+
+// validateBody checks the JSON body of a request against the schema of its
+// route, see api.Routes, before the handler reads it again.
+func validateBody(newBody func() api.Validator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		content, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(content))
+
+		body := newBody()
+		if err := json.Unmarshal(content, body); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+		if err := body.Validate(); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"errors": err.Error(),
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+// The views of the UI. The default one is the page in public, the others
+// are rendered by the server: basic works without JavaScript, high-contrast
+// is basic with strong colors and large text, screen-reader lists the open
+// todos first and reads each todo as one sentence.
+const (
+	viewDefault      = "default"
+	viewBasic        = "basic"
+	viewHighContrast = "high-contrast"
+	viewScreenReader = "screen-reader"
+)
+
+var views = []string{viewDefault, viewBasic, viewHighContrast, viewScreenReader}
+
+const (
+	// viewCookie keeps the view of browsers without an account
+	viewCookie = "todo_view"
+	viewMaxAge = 365 * 24 * time.Hour
+)
+
+func viewKey(account string) string {
+	return "view:" + account
+}
+
+// viewOf returns the view of the request. ?view= selects one and keeps it
+// as the preference, of the account if signed in and in a cookie. Without
+// it the preference is used. It answers unknown views itself.
+func viewOf(c *gin.Context) (string, bool) {
+	if view := c.Query("view"); view != "" {
+		if !contains(views, view) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": fmt.Sprintf("unknown view %q, use %s", view, strings.Join(views, ", ")),
+			})
+			return "", false
+		}
+		if err := saveView(c, view); err != nil {
+			logger.Errorf("%v", err)
+		}
+		return view, true
+	}
+
+	return preferredView(c), true
+}
+
+// preferredView is the view the account or the browser chose last.
+func preferredView(c *gin.Context) string {
+	if account := c.GetString(accountKey); account != "" {
+		view, err := tododb.KVOf(database).GetValue(viewKey(account))
+		if err == nil && contains(views, view) {
+			return view
+		}
+		if err != nil && err != tododb.ErrNotFound {
+			logger.Errorf("%v", err)
+		}
+	}
+
+	if view, err := c.Cookie(viewCookie); err == nil && contains(views, view) {
+		return view
+	}
+
+	return viewDefault
+}
+
+func saveView(c *gin.Context, view string) error {
+	c.SetCookie(viewCookie, view, int(viewMaxAge.Seconds()), "/", "", false, true)
+	if account := c.GetString(accountKey); account != "" {
+		return tododb.KVOf(database).SetValue(viewKey(account), view, 0)
+	}
+
+	return nil
+}
+
+func getViewHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"view": preferredView(c),
+	})
+}
+
+func setViewHandler(c *gin.Context) {
+	var request struct {
+		View string `json:"view"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || !contains(views, request.View) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "view must be one of " + strings.Join(views, ", "),
+		})
+		return
+	}
+	if err := saveView(c, request.View); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"view": request.View,
+	})
+}
+
+// describeTodo reads the details of a todo as text, like "due 2019-05-01,
+// high priority, tagged home", without relying on colors.
+func describeTodo(todo tododb.Todo) string {
+	var details []string
+	if day, ok := dueDay(todo); ok {
+		if !todo.Done && day < time.Now().Format(dayFormat) {
+			details = append(details, "overdue since "+day)
+		} else {
+			details = append(details, "due "+day)
+		}
+	}
+	if todo.Priority != tododb.PriorityNone {
+		text := priorityText(todo.Priority)
+		if !strings.HasPrefix(text, "priority") {
+			text += " priority"
+		}
+		details = append(details, text)
+	}
+	if len(todo.Tags) > 0 {
+		details = append(details, "tagged "+strings.Join(todo.Tags, ", "))
+	}
+	if todo.Recurrence != "" {
+		details = append(details, "repeats "+todo.Recurrence)
+	}
+	if done, total := todo.SubTaskProgress(); total > 0 {
+		details = append(details, fmt.Sprintf("%d of %d sub-tasks done", done, total))
+	}
+
+	return strings.Join(details, "; ")
+}
+
+// basicPage is the list as the views of the server render it.
+type basicPage struct {
+	View  string
+	Views []string
+	Error string
+	// Open and Done are the todos in list order, Todos all of them
+	Todos []tododb.Todo
+	Open  []tododb.Todo
+	Done  []tododb.Todo
+}
+
+var basicTemplate = template.Must(template.New("basic").Funcs(brandingFuncs).Funcs(template.FuncMap{"describe": describeTodo}).Parse(`<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>Todo list - {{appName}}</title>
+    <style>
+      body { font-family: sans-serif; max-width: 48em; margin: 1em auto; padding: 0 1em; line-height: 1.5; }
+      h1 { color: {{primaryColor}}; }
+      table { border-collapse: collapse; width: 100%; }
+      th, td { text-align: left; padding: 0.4em; border-bottom: 1px solid #888; vertical-align: top; }
+      form.inline { display: inline; }
+      .done { text-decoration: line-through; }
+      .skip { position: absolute; left: -10000px; }
+      .skip:focus { position: static; }
+      :focus { outline: 3px solid #1a5fb4; outline-offset: 2px; }
+{{if eq .View "high-contrast"}}      body { background: #000; color: #fff; font-size: 1.3em; }
+      a { color: #ff0; }
+      th, td { border-bottom: 2px solid #fff; }
+      input, button { background: #000; color: #fff; border: 2px solid #fff; font-size: 1em; padding: 0.3em 0.6em; }
+      .done { color: #bbb; }
+      .error { color: #000; background: #ff0; padding: 0.3em; }
+      h1 { color: #ff0; }
+      :focus { outline: 4px solid #ff0; }
+{{end}}    </style>
+  </head>
+  <body>
+    <a class="skip" href="#new-todo">Skip to the new todo</a>
+    <h1>Todo list</h1>
+    <p>{{len .Open}} open, {{len .Done}} done.</p>
+{{if .Error}}    <p class="error" role="alert">{{.Error}}</p>
+{{end}}
+{{if eq .View "screen-reader"}}    <h2>Open todos</h2>
+    {{if .Open}}<ol>
+{{range .Open}}      <li>{{.Title}}{{with describe .}}, {{.}}{{end}}.
+        <form class="inline" method="post" action="basic"><input type="hidden" name="id" value="{{.ID}}"><button name="action" value="complete">Complete {{.Title}}</button></form>
+        <form class="inline" method="post" action="basic"><input type="hidden" name="id" value="{{.ID}}"><button name="action" value="delete">Delete {{.Title}}</button></form>
+      </li>
+{{end}}    </ol>{{else}}<p>Nothing to do.</p>{{end}}
+    <h2>Done todos</h2>
+    {{if .Done}}<ol>
+{{range .Done}}      <li>{{.Title}}{{with describe .}}, {{.}}{{end}}.
+        <form class="inline" method="post" action="basic"><input type="hidden" name="id" value="{{.ID}}"><button name="action" value="reopen">Reopen {{.Title}}</button></form>
+        <form class="inline" method="post" action="basic"><input type="hidden" name="id" value="{{.ID}}"><button name="action" value="delete">Delete {{.Title}}</button></form>
+      </li>
+{{end}}    </ol>{{else}}<p>No todo is done yet.</p>{{end}}
+{{else}}    <table>
+      <caption>All todos, in list order</caption>
+      <thead><tr><th scope="col">Todo</th><th scope="col">Details</th><th scope="col">Status</th><th scope="col">Actions</th></tr></thead>
+      <tbody>
+{{range .Todos}}        <tr>
+          <th scope="row"{{if .Done}} class="done"{{end}}>{{.Title}}</th>
+          <td>{{describe .}}</td>
+          <td>{{if .Done}}Done{{else}}Open{{end}}</td>
+          <td>
+            <form class="inline" method="post" action="basic"><input type="hidden" name="id" value="{{.ID}}">{{if .Done}}<button name="action" value="reopen" aria-label="Reopen {{.Title}}">Reopen</button>{{else}}<button name="action" value="complete" aria-label="Complete {{.Title}}">Complete</button>{{end}}</form>
+            <form class="inline" method="post" action="basic"><input type="hidden" name="id" value="{{.ID}}"><button name="action" value="delete" aria-label="Delete {{.Title}}">Delete</button></form>
+          </td>
+        </tr>
+{{else}}        <tr><td colspan="4">Nothing to do.</td></tr>
+{{end}}      </tbody>
+    </table>
+{{end}}
+    <h2 id="new-todo">New todo</h2>
+    <form method="post" action="basic">
+      <label for="title">Todo</label>
+      <input type="text" id="title" name="title" required autocomplete="off">
+      <button name="action" value="add">Add</button>
+    </form>
+
+    <h2>View</h2>
+    <ul>
+{{range .Views}}{{if eq . "default"}}      <li><a href="basic?view=default">The full page, with JavaScript</a></li>
+{{else if eq . $.View}}      <li aria-current="true">{{.}}</li>
+{{else}}      <li><a href="basic?view={{.}}">{{.}}</a></li>
+{{end}}{{end}}    </ul>
+  </body>
+</html>
+`))
+
+// basicTodoHandler renders the list in the view of the request. The default
+// view is the page in public, the request is sent there.
+func basicTodoHandler(c *gin.Context) {
+	view, ok := viewOf(c)
+	if !ok {
+		return
+	}
+	if view == viewDefault {
+		c.Redirect(http.StatusSeeOther, "./")
+		return
+	}
+
+	todos, err := todosOf(c).GetAllTodos(c.Request.Context())
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	page := basicPage{View: view, Views: views, Error: c.Query("error"), Todos: todos}
+	for _, todo := range todos {
+		if todo.Done {
+			page.Done = append(page.Done, todo)
+		} else {
+			page.Open = append(page.Open, todo)
+		}
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := basicTemplate.Execute(c.Writer, page); err != nil {
+		logger.Errorf("%v", err)
+	}
+}
+
+// basicTodoFormHandler takes the forms of the server rendered views, it adds,
+// completes, reopens and deletes todos and goes back to the list. Failures
+// the user can fix are shown on the list.
+func basicTodoFormHandler(c *gin.Context) {
+	action := c.PostForm("action")
+	if !contains([]string{"add", "complete", "reopen", "delete"}, action) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("unknown action %q, use add, complete, reopen or delete", action),
+		})
+		return
+	}
+
+	message, err := basicAction(c, action)
+	if err != nil {
+		logger.Errorf("%v", err)
+		message = "The todo could not be changed, try again."
+	}
+	target := "basic"
+	if message != "" {
+		target += "?error=" + url.QueryEscape(message)
+	}
+
+	c.Redirect(http.StatusSeeOther, target)
+}
+
+// basicAction does action like the JSON handlers would. It returns what to
+// tell the user if the action isn't possible.
+func basicAction(c *gin.Context, action string) (string, error) {
+	ctx := c.Request.Context()
+	if action == "add" {
+		title := strings.TrimSpace(c.PostForm("title"))
+		if title == "" {
+			return "The todo must not be empty.", nil
+		}
+		todo := newTodo(title)
+		todo.Owner = c.GetString(accountKey)
+		if err := todosOf(c).SaveTodo(ctx, todo); err != nil {
+			return "", err
+		}
+		publishChange(changeCreated, todo)
+		queueHooks(hookCreate, todo)
+		if err := recordCreated(recordsNamespace(c), title); err != nil {
+			logger.Errorf("%v", err)
+		}
+		return "", nil
+	}
+
+	id := c.PostForm("id")
+	todo, found, err := findTodo(ctx, todosOf(c), func(todo tododb.Todo) bool {
+		return todo.ID == id
+	})
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "The todo is gone, it was deleted meanwhile.", nil
+	}
+
+	switch action {
+	case "delete":
+		if err := todosOf(c).DeleteTodo(ctx, todo.ID); err != nil {
+			return basicRefusal(err)
+		}
+		publishChange(changeDeleted, todo)
+		grants := takeGrants(todo)
+		trashTodos(c, grants, todo)
+		recordOperation(c, tododb.Operation{Kind: operationDeleted, Before: []tododb.Todo{todo}, Grants: grants})
+		releaseTodo(todo.ID)
+		return "", nil
+	case "complete":
+		if todo.Done {
+			return "", nil
+		}
+		if !appConfig.AllowBlockedCompletion {
+			reader, err := readerOf(c)
+			if err != nil {
+				return "", err
+			}
+			blockers, err := openBlockers(ctx, todo.ID, reader)
+			if err != nil {
+				return "", err
+			}
+			if len(blockers) > 0 {
+				return fmt.Sprintf("%s is blocked by %s.", todo.Title, strings.Join(blockers, ", ")), nil
+			}
+		}
+		err = todosOf(c).CompleteTodo(ctx, todo.ID)
+	case "reopen":
+		if !todo.Done {
+			return "", nil
+		}
+		err = todosOf(c).ReopenTodo(ctx, todo.ID)
+	}
+	if err != nil {
+		return basicRefusal(err)
+	}
+	dropCachedSmartLists()
+	recordOperation(c, tododb.Operation{Kind: operationUpdated, Before: []tododb.Todo{todo}})
+	if action == "complete" {
+		finishTodo(recordsNamespace(c), todo)
+	}
+	todo.Done = action == "complete"
+	publishChange(changeUpdated, todo)
+
+	return "", nil
+}
+
+// basicRefusal tells the user why a change of a todo was refused, other
+// errors are returned as they are.
+func basicRefusal(err error) (string, error) {
+	switch err {
+	case errNotOwner:
+		return "Only the owner of the todo may delete it.", nil
+	case errNoPermission:
+		return "The todo isn't shared with you to change it.", nil
+	case tododb.ErrNotFound:
+		return "The todo is gone, it was deleted meanwhile.", nil
+	}
+
+	return "", err
+}
@@ -0,0 +1,539 @@
+package wasm
+
+import (
+	"encoding/binary"
+)
+
+// The opcodes the decoder and interpreter refer to by name, the numeric ones
+// are handled by ranges. The ones after the 0xFC prefix are stored as
+// opPrefix plus their number.
+const (
+	opUnreachable  = 0x00
+	opNop          = 0x01
+	opBlock        = 0x02
+	opLoop         = 0x03
+	opIf           = 0x04
+	opElse         = 0x05
+	opEnd          = 0x0B
+	opBr           = 0x0C
+	opBrIf         = 0x0D
+	opBrTable      = 0x0E
+	opReturn       = 0x0F
+	opCall         = 0x10
+	opCallIndirect = 0x11
+	opDrop         = 0x1A
+	opSelect       = 0x1B
+	opSelectTyped  = 0x1C
+	opLocalGet     = 0x20
+	opLocalSet     = 0x21
+	opLocalTee     = 0x22
+	opGlobalGet    = 0x23
+	opGlobalSet    = 0x24
+	opI32Load      = 0x28
+	opI64Store32   = 0x3E
+	opMemorySize   = 0x3F
+	opMemoryGrow   = 0x40
+	opI32Const     = 0x41
+	opI64Const     = 0x42
+	opF32Const     = 0x43
+	opF64Const     = 0x44
+	opI32Eqz       = 0x45
+	opI64Extend32S = 0xC4
+	opPrefix       = 0x100
+	opTruncSatLast = opPrefix + 7
+	opMemoryInit   = opPrefix + 8
+	opDataDrop     = opPrefix + 9
+	opMemoryCopy   = opPrefix + 10
+	opMemoryFill   = opPrefix + 11
+
+	// opFunction is the control frame of the function body
+	opFunction = 0x200
+
+	refFunc = 0x70
+)
+
+// instr is a decoded instruction. Blocks, loops and ifs keep the index of
+// their end in a and ifs that of their else in b, or the end without one;
+// their imm holds the number of parameters in the upper and of results in
+// the lower half. The other instructions keep their index or label in a and
+// their constant or memory offset in imm.
+type instr struct {
+	op      uint16
+	a, b    uint32
+	imm     uint64
+	targets []uint32
+}
+
+// opType is the signature of a numeric, load or store instruction.
+type opType struct {
+	params []ValueType
+	result ValueType
+}
+
+var (
+	opTypes [opMemoryFill + 1]opType
+	// memorySizes are the bytes loads and stores access
+	memorySizes [opI64Store32 + 1]uint
+)
+
+func init() {
+	set := func(from, to int, result ValueType, params ...ValueType) {
+		for op := from; op <= to; op++ {
+			opTypes[op] = opType{params: params, result: result}
+		}
+	}
+
+	set(0x28, 0x28, I32, I32)
+	set(0x29, 0x29, I64, I32)
+	set(0x2A, 0x2A, F32, I32)
+	set(0x2B, 0x2B, F64, I32)
+	set(0x2C, 0x2F, I32, I32)
+	set(0x30, 0x35, I64, I32)
+	set(0x36, 0x36, 0, I32, I32)
+	set(0x37, 0x37, 0, I32, I64)
+	set(0x38, 0x38, 0, I32, F32)
+	set(0x39, 0x39, 0, I32, F64)
+	set(0x3A, 0x3B, 0, I32, I32)
+	set(0x3C, 0x3E, 0, I32, I64)
+
+	set(0x45, 0x45, I32, I32)
+	set(0x46, 0x4F, I32, I32, I32)
+	set(0x50, 0x50, I32, I64)
+	set(0x51, 0x5A, I32, I64, I64)
+	set(0x5B, 0x60, I32, F32, F32)
+	set(0x61, 0x66, I32, F64, F64)
+	set(0x67, 0x69, I32, I32)
+	set(0x6A, 0x78, I32, I32, I32)
+	set(0x79, 0x7B, I64, I64)
+	set(0x7C, 0x8A, I64, I64, I64)
+	set(0x8B, 0x91, F32, F32)
+	set(0x92, 0x98, F32, F32, F32)
+	set(0x99, 0x9F, F64, F64)
+	set(0xA0, 0xA6, F64, F64, F64)
+	set(0xA7, 0xA7, I32, I64)
+	set(0xA8, 0xA9, I32, F32)
+	set(0xAA, 0xAB, I32, F64)
+	set(0xAC, 0xAD, I64, I32)
+	set(0xAE, 0xAF, I64, F32)
+	set(0xB0, 0xB1, I64, F64)
+	set(0xB2, 0xB3, F32, I32)
+	set(0xB4, 0xB5, F32, I64)
+	set(0xB6, 0xB6, F32, F64)
+	set(0xB7, 0xB8, F64, I32)
+	set(0xB9, 0xBA, F64, I64)
+	set(0xBB, 0xBB, F64, F32)
+	set(0xBC, 0xBC, I32, F32)
+	set(0xBD, 0xBD, I64, F64)
+	set(0xBE, 0xBE, F32, I32)
+	set(0xBF, 0xBF, F64, I64)
+	set(0xC0, 0xC1, I32, I32)
+	set(0xC2, 0xC4, I64, I64)
+
+	set(opPrefix+0, opPrefix+1, I32, F32)
+	set(opPrefix+2, opPrefix+3, I32, F64)
+	set(opPrefix+4, opPrefix+5, I64, F32)
+	set(opPrefix+6, opPrefix+7, I64, F64)
+	set(opMemoryInit, opMemoryFill, 0, I32, I32, I32)
+
+	for op, size := range []uint{4, 8, 4, 8, 1, 1, 2, 2, 1, 1, 2, 2, 4, 4, 4, 8, 4, 8, 1, 2, 1, 2, 4} {
+		memorySizes[opI32Load+op] = size
+	}
+}
+
+type ctrlFrame struct {
+	op          uint16
+	params      []ValueType
+	results     []ValueType
+	height      int
+	unreachable bool
+	// index is the instruction that opened the block
+	index int
+}
+
+// labelTypes are the values a branch to frame takes.
+func (frame *ctrlFrame) labelTypes() []ValueType {
+	if frame.op == opLoop {
+		return frame.params
+	}
+
+	return frame.results
+}
+
+// validator decodes the code of a function into instructions and checks
+// that they are well typed, with the algorithm of the appendix of the
+// specification.
+type validator struct {
+	r        *reader
+	module   *Module
+	funcType FuncType
+	locals   []ValueType
+	vals     []ValueType
+	ctrls    []ctrlFrame
+	code     []instr
+	fn       *function
+}
+
+func (v *validator) push(t ValueType) {
+	v.vals = append(v.vals, t)
+	if len(v.vals) > v.fn.maxStack {
+		v.fn.maxStack = len(v.vals)
+	}
+}
+
+func (v *validator) pushTypes(types []ValueType) {
+	for _, t := range types {
+		v.push(t)
+	}
+}
+
+func (v *validator) pop() ValueType {
+	frame := &v.ctrls[len(v.ctrls)-1]
+	if len(v.vals) == frame.height {
+		if frame.unreachable {
+			return unknown
+		}
+		v.r.fail("type mismatch: the stack is empty")
+	}
+	t := v.vals[len(v.vals)-1]
+	v.vals = v.vals[:len(v.vals)-1]
+
+	return t
+}
+
+func (v *validator) popExpect(want ValueType) ValueType {
+	got := v.pop()
+	if got != want && got != unknown && want != unknown {
+		v.r.fail("type mismatch: want %s, got %s", want, got)
+	}
+
+	return got
+}
+
+// popTypes pops the values of types and returns their actual types.
+func (v *validator) popTypes(types []ValueType) []ValueType {
+	popped := make([]ValueType, len(types))
+	for i := len(types) - 1; i >= 0; i-- {
+		popped[i] = v.popExpect(types[i])
+	}
+
+	return popped
+}
+
+func (v *validator) pushCtrl(op uint16, params, results []ValueType, index int) {
+	v.ctrls = append(v.ctrls, ctrlFrame{op: op, params: params, results: results, height: len(v.vals), index: index})
+	if len(v.ctrls) > v.fn.maxLabels {
+		v.fn.maxLabels = len(v.ctrls)
+	}
+	v.pushTypes(params)
+}
+
+func (v *validator) popCtrl() ctrlFrame {
+	frame := v.ctrls[len(v.ctrls)-1]
+	v.popTypes(frame.results)
+	if len(v.vals) != frame.height {
+		v.r.fail("type mismatch: values left at the end of a block")
+	}
+	v.ctrls = v.ctrls[:len(v.ctrls)-1]
+
+	return frame
+}
+
+func (v *validator) setUnreachable() {
+	frame := &v.ctrls[len(v.ctrls)-1]
+	v.vals = v.vals[:frame.height]
+	frame.unreachable = true
+}
+
+func (v *validator) label(depth uint32) *ctrlFrame {
+	if int(depth) >= len(v.ctrls) {
+		v.r.fail("unknown label %d", depth)
+	}
+
+	return &v.ctrls[len(v.ctrls)-1-int(depth)]
+}
+
+func (v *validator) emit(in instr) {
+	v.code = append(v.code, in)
+}
+
+// blockType reads the type of a block, empty, a single result or the index
+// of a function type.
+func (v *validator) blockType() (params, results []ValueType) {
+	switch b := v.r.buf[v.r.pos:]; {
+	case len(b) > 0 && b[0] == 0x40:
+		v.r.pos++
+		return nil, nil
+	case len(b) > 0 && (b[0] == byte(I32) || b[0] == byte(I64) || b[0] == byte(F32) || b[0] == byte(F64)):
+		return nil, []ValueType{v.r.valueType()}
+	}
+
+	index := v.r.sleb(33)
+	if index < 0 || index >= int64(len(v.module.types)) {
+		v.r.fail("unknown type %d", index)
+	}
+	t := v.module.types[index]
+
+	return t.Params, t.Results
+}
+
+func (v *validator) needMemory() {
+	if v.module.memory == nil {
+		v.r.fail("memory instruction without a memory")
+	}
+}
+
+func (v *validator) zeroByte() {
+	if v.r.byte() != 0x00 {
+		v.r.fail("only memory and table 0 exist")
+	}
+}
+
+func (m *Module) decodeFunction(r *reader, fn *function) {
+	t := m.types[fn.typeIndex]
+	v := &validator{r: r, module: m, funcType: t, fn: fn}
+	v.locals = append(v.locals, t.Params...)
+	for n := r.count(); n > 0; n-- {
+		count := r.u32()
+		if uint64(len(v.locals))+uint64(count) > maxLocals {
+			r.fail("more than %d locals", maxLocals)
+		}
+		typ := r.valueType()
+		for i := uint32(0); i < count; i++ {
+			v.locals = append(v.locals, typ)
+		}
+	}
+	fn.locals = len(v.locals) - len(t.Params)
+
+	v.pushCtrl(opFunction, nil, t.Results, -1)
+	for len(v.ctrls) > 0 {
+		v.instruction()
+	}
+	if !r.done() {
+		r.fail("code after the end of the function")
+	}
+	fn.code = v.code
+}
+
+// instruction decodes and validates the next instruction.
+func (v *validator) instruction() {
+	r := v.r
+	op := uint16(r.byte())
+	if op == 0xFC {
+		sub := r.u32()
+		if sub > opMemoryFill-opPrefix {
+			r.fail("unknown opcode 0xfc %d", sub)
+		}
+		op = opPrefix + uint16(sub)
+	}
+
+	switch {
+	case op == opUnreachable:
+		v.emit(instr{op: op})
+		v.setUnreachable()
+	case op == opNop:
+	case op == opBlock || op == opLoop || op == opIf:
+		params, results := v.blockType()
+		if op == opIf {
+			v.popExpect(I32)
+		}
+		v.popTypes(params)
+		v.pushCtrl(op, params, results, len(v.code))
+		v.emit(instr{op: op, imm: uint64(len(params))<<32 | uint64(len(results))})
+	case op == opElse:
+		frame := v.popCtrl()
+		if frame.op != opIf {
+			r.fail("else outside of an if")
+		}
+		v.code[frame.index].b = uint32(len(v.code))
+		v.emit(instr{op: op})
+		v.pushCtrl(opElse, frame.params, frame.results, frame.index)
+	case op == opEnd:
+		frame := v.popCtrl()
+		end := uint32(len(v.code))
+		v.emit(instr{op: op})
+		switch frame.op {
+		case opBlock, opLoop:
+			v.code[frame.index].a = end
+		case opIf:
+			if !equalTypes(frame.params, frame.results) {
+				r.fail("type mismatch: an if without else must return its parameters")
+			}
+			v.code[frame.index].a, v.code[frame.index].b = end, end
+		case opElse:
+			v.code[frame.index].a = end
+			v.code[v.code[frame.index].b].a = end
+		}
+		v.pushTypes(frame.results)
+	case op == opBr:
+		depth := r.u32()
+		v.popTypes(v.label(depth).labelTypes())
+		v.emit(instr{op: op, a: depth})
+		v.setUnreachable()
+	case op == opBrIf:
+		depth := r.u32()
+		v.popExpect(I32)
+		types := v.label(depth).labelTypes()
+		v.pushTypes(v.popTypes(types))
+		v.emit(instr{op: op, a: depth})
+	case op == opBrTable:
+		targets := make([]uint32, r.count()+1)
+		for i := range targets {
+			targets[i] = r.u32()
+		}
+		v.popExpect(I32)
+		arity := len(v.label(targets[len(targets)-1]).labelTypes())
+		for _, depth := range targets[:len(targets)-1] {
+			types := v.label(depth).labelTypes()
+			if len(types) != arity {
+				r.fail("type mismatch: the labels of a br_table differ in arity")
+			}
+			v.pushTypes(v.popTypes(types))
+		}
+		v.popTypes(v.label(targets[len(targets)-1]).labelTypes())
+		v.emit(instr{op: op, targets: targets})
+		v.setUnreachable()
+	case op == opReturn:
+		v.popTypes(v.funcType.Results)
+		v.emit(instr{op: op})
+		v.setUnreachable()
+	case op == opCall:
+		index := r.u32()
+		if int(index) >= v.module.numFuncs() {
+			r.fail("unknown function %d", index)
+		}
+		t := v.module.funcType(index)
+		v.popTypes(t.Params)
+		v.pushTypes(t.Results)
+		v.emit(instr{op: op, a: index})
+	case op == opCallIndirect:
+		index := r.u32()
+		if int(index) >= len(v.module.types) {
+			r.fail("unknown type %d", index)
+		}
+		v.zeroByte()
+		if v.module.table == nil {
+			r.fail("call_indirect without a table")
+		}
+		t := v.module.types[index]
+		v.popExpect(I32)
+		v.popTypes(t.Params)
+		v.pushTypes(t.Results)
+		v.emit(instr{op: op, a: index})
+	case op == opDrop:
+		v.pop()
+		v.emit(instr{op: op})
+	case op == opSelect || op == opSelectTyped:
+		want := unknown
+		if op == opSelectTyped {
+			if r.u32() != 1 {
+				r.fail("select must have a single type")
+			}
+			want = r.valueType()
+		}
+		v.popExpect(I32)
+		first, second := v.popExpect(want), v.popExpect(want)
+		if first != second && first != unknown && second != unknown {
+			r.fail("type mismatch: select of %s and %s", second, first)
+		}
+		if first == unknown {
+			first = second
+		}
+		if first == unknown {
+			first = want
+		}
+		v.push(first)
+		v.emit(instr{op: opSelect})
+	case op >= opLocalGet && op <= opLocalTee:
+		index := r.u32()
+		if int(index) >= len(v.locals) {
+			r.fail("unknown local %d", index)
+		}
+		t := v.locals[index]
+		if op != opLocalGet {
+			v.popExpect(t)
+		}
+		if op != opLocalSet {
+			v.push(t)
+		}
+		v.emit(instr{op: op, a: index})
+	case op == opGlobalGet || op == opGlobalSet:
+		index := r.u32()
+		if int(index) >= len(v.module.globals) {
+			r.fail("unknown global %d", index)
+		}
+		g := v.module.globals[index]
+		if op == opGlobalGet {
+			v.push(g.typ)
+		} else {
+			if !g.mutable {
+				r.fail("global %d is immutable", index)
+			}
+			v.popExpect(g.typ)
+		}
+		v.emit(instr{op: op, a: index})
+	case op >= opI32Load && op <= opI64Store32:
+		v.needMemory()
+		align, offset := r.u32(), r.u32()
+		if align >= 32 || 1<<align > memorySizes[op] {
+			r.fail("the alignment must not be larger than natural")
+		}
+		v.numeric(op)
+		v.emit(instr{op: op, imm: uint64(offset)})
+	case op == opMemorySize || op == opMemoryGrow:
+		v.zeroByte()
+		v.needMemory()
+		if op == opMemoryGrow {
+			v.popExpect(I32)
+		}
+		v.push(I32)
+		v.emit(instr{op: op})
+	case op == opI32Const:
+		v.push(I32)
+		v.emit(instr{op: op, imm: uint64(uint32(r.sleb(32)))})
+	case op == opI64Const:
+		v.push(I64)
+		v.emit(instr{op: op, imm: uint64(r.sleb(64))})
+	case op == opF32Const:
+		v.push(F32)
+		v.emit(instr{op: op, imm: uint64(binary.LittleEndian.Uint32(r.bytes(4)))})
+	case op == opF64Const:
+		v.push(F64)
+		v.emit(instr{op: op, imm: binary.LittleEndian.Uint64(r.bytes(8))})
+	case (op >= opI32Eqz && op <= opI64Extend32S) || (op >= opPrefix && op <= opTruncSatLast):
+		v.numeric(op)
+		v.emit(instr{op: op})
+	case op == opMemoryInit || op == opDataDrop:
+		index := r.u32()
+		if v.module.dataCount < 0 {
+			r.fail("memory.init and data.drop need a data count section")
+		}
+		if int64(index) >= v.module.dataCount {
+			r.fail("unknown data segment %d", index)
+		}
+		if op == opMemoryInit {
+			v.zeroByte()
+			v.needMemory()
+			v.numeric(op)
+		}
+		v.emit(instr{op: op, a: index})
+	case op == opMemoryCopy || op == opMemoryFill:
+		v.zeroByte()
+		if op == opMemoryCopy {
+			v.zeroByte()
+		}
+		v.needMemory()
+		v.numeric(op)
+		v.emit(instr{op: op})
+	default:
+		r.fail("unknown opcode 0x%02x", op)
+	}
+}
+
+// numeric checks the operands of an instruction of opTypes.
+func (v *validator) numeric(op uint16) {
+	t := opTypes[op]
+	v.popTypes(t.params)
+	if t.result != 0 {
+		v.push(t.result)
+	}
+}
@@ -0,0 +1,454 @@
+package wasm
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+)
+
+// maxCallDepth is how deep calls can nest before the instance traps.
+const maxCallDepth = 10000
+
+// ErrFuel is returned when an instance executed all of its fuel.
+var ErrFuel = errors.New("wasm: out of fuel")
+
+// Trap is a runtime error of the module, like an access outside of its
+// memory or a division by zero.
+type Trap struct {
+	Reason string
+}
+
+func (t *Trap) Error() string {
+	return "wasm: trap: " + t.Reason
+}
+
+func trap(format string, args ...interface{}) {
+	panic(&Trap{Reason: fmt.Sprintf(format, args...)})
+}
+
+// Limits bound the resources of an instance, zero values don't limit.
+type Limits struct {
+	// MemoryPages is the most pages the memory can grow to
+	MemoryPages uint32
+	// Fuel is the number of instructions the instance can execute over
+	// all of its calls
+	Fuel int64
+}
+
+// HostFunc is a function of the host a module imports. An error returned
+// by Call ends the call of the instance with it.
+type HostFunc struct {
+	Type FuncType
+	Call func(instance *Instance, args []uint64) ([]uint64, error)
+}
+
+// Imports are the host functions by module and name.
+type Imports map[string]map[string]HostFunc
+
+// Instance is an instantiated module with its memory, table and globals.
+// It isn't safe for concurrent use.
+type Instance struct {
+	module   *Module
+	hosts    []HostFunc
+	memory   []byte
+	maxPages uint32
+	table    []int64
+	globals  []uint64
+	data     [][]byte
+	fuel     int64
+	depth    int
+	ctx      context.Context
+}
+
+type label struct {
+	height, arity, target int
+	loop                  bool
+}
+
+// Instantiate resolves the imports of module, initializes its memory, table
+// and globals and runs its start function.
+func Instantiate(ctx context.Context, module *Module, imports Imports, limits Limits) (*Instance, error) {
+	inst := &Instance{module: module, fuel: limits.Fuel, maxPages: maxPages}
+	if limits.Fuel <= 0 {
+		inst.fuel = math.MaxInt64
+	}
+
+	for _, imp := range module.imports {
+		host, exists := imports[imp.Module][imp.Name]
+		if !exists {
+			return nil, fmt.Errorf("wasm: unknown import %s.%s", imp.Module, imp.Name)
+		}
+		if !host.Type.equal(imp.Type) {
+			return nil, fmt.Errorf("wasm: import %s.%s is %v, the module wants %v", imp.Module, imp.Name, host.Type, imp.Type)
+		}
+		inst.hosts = append(inst.hosts, host)
+	}
+
+	inst.globals = make([]uint64, len(module.globals))
+	for i, g := range module.globals {
+		inst.globals[i] = inst.eval(g.init)
+	}
+
+	if memory := module.memory; memory != nil {
+		if memory.hasMax && memory.max < inst.maxPages {
+			inst.maxPages = memory.max
+		}
+		if limits.MemoryPages > 0 && limits.MemoryPages < inst.maxPages {
+			inst.maxPages = limits.MemoryPages
+		}
+		if memory.min > inst.maxPages {
+			return nil, fmt.Errorf("wasm: the module needs %d pages of memory, %d are allowed", memory.min, inst.maxPages)
+		}
+		inst.memory = make([]byte, int(memory.min)*PageSize)
+	}
+	if module.table != nil {
+		inst.table = make([]int64, module.table.min)
+		for i := range inst.table {
+			inst.table[i] = -1
+		}
+	}
+
+	for _, e := range module.elements {
+		offset := uint64(uint32(inst.eval(e.offset)))
+		if offset+uint64(len(e.funcs)) > uint64(len(inst.table)) {
+			return nil, errors.New("wasm: element segment outside of the table")
+		}
+		for i, index := range e.funcs {
+			inst.table[offset+uint64(i)] = int64(index)
+		}
+	}
+	inst.data = make([][]byte, len(module.data))
+	for i, d := range module.data {
+		if !d.active {
+			inst.data[i] = d.data
+			continue
+		}
+		offset := uint64(uint32(inst.eval(d.offset)))
+		if offset+uint64(len(d.data)) > uint64(len(inst.memory)) {
+			return nil, errors.New("wasm: data segment outside of the memory")
+		}
+		copy(inst.memory[offset:], d.data)
+	}
+
+	if module.start >= 0 {
+		if _, err := inst.invoke(ctx, uint32(module.start), nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return inst, nil
+}
+
+func (inst *Instance) eval(expr constExpr) uint64 {
+	if expr.op == opGlobalGet {
+		return inst.globals[expr.value]
+	}
+
+	return expr.value
+}
+
+// Memory returns the linear memory, it changes when the memory grows.
+func (inst *Instance) Memory() []byte {
+	return inst.memory
+}
+
+// Call calls the exported function name with args, i32 and i64 values are
+// passed as their bits, floats as those of math.Float32bits and
+// math.Float64bits. The context ends the call when it is done.
+func (inst *Instance) Call(ctx context.Context, name string, args ...uint64) ([]uint64, error) {
+	e, exists := inst.module.exports[name]
+	if !exists || e.kind != externFunc {
+		return nil, fmt.Errorf("wasm: no function %q is exported", name)
+	}
+	if t := inst.module.funcType(e.index); len(args) != len(t.Params) {
+		return nil, fmt.Errorf("wasm: %s takes %d arguments, not %d", name, len(t.Params), len(args))
+	}
+
+	return inst.invoke(ctx, e.index, args)
+}
+
+// invoke calls the function at index and turns the panics of traps, host
+// errors and the exhausted fuel or context into its error.
+func (inst *Instance) invoke(ctx context.Context, index uint32, args []uint64) (results []uint64, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	inst.ctx, inst.depth = ctx, 0
+	defer func() {
+		inst.ctx = nil
+		r := recover()
+		if r == nil {
+			return
+		}
+		switch r := r.(type) {
+		case runtime.Error:
+			// Only valid code is run, the bounds checks of Go stop what the
+			// validation misses
+			err = &Trap{Reason: r.Error()}
+		case error:
+			err = r
+		default:
+			panic(r)
+		}
+	}()
+
+	results = inst.call(index, args)
+	return append([]uint64(nil), results...), nil
+}
+
+func (inst *Instance) call(index uint32, args []uint64) []uint64 {
+	if int(index) < len(inst.hosts) {
+		host := inst.hosts[index]
+		results, err := host.Call(inst, args)
+		if err != nil {
+			panic(err)
+		}
+		if len(results) != len(host.Type.Results) {
+			trap("the host function returned %d results instead of %d", len(results), len(host.Type.Results))
+		}
+		return results
+	}
+
+	inst.depth++
+	if inst.depth > maxCallDepth {
+		trap("call stack exhausted")
+	}
+	results := inst.exec(&inst.module.funcs[int(index)-len(inst.hosts)], args)
+	inst.depth--
+
+	return results
+}
+
+// address returns the address of size bytes at offset from base, or traps
+// outside of the memory.
+func (inst *Instance) address(base uint64, offset uint64, size uint64) uint64 {
+	address := uint64(uint32(base)) + offset
+	if address+size > uint64(len(inst.memory)) {
+		trap("out of bounds memory access")
+	}
+
+	return address
+}
+
+// exec runs a function of the module. The validation guarantees that the
+// stack holds the values every instruction takes.
+func (inst *Instance) exec(fn *function, args []uint64) []uint64 {
+	t := inst.module.types[fn.typeIndex]
+	locals := make([]uint64, len(args)+fn.locals)
+	copy(locals, args)
+	stack := make([]uint64, fn.maxStack)
+	labels := make([]label, 1, fn.maxLabels)
+	labels[0] = label{arity: len(t.Results), target: len(fn.code)}
+	sp := 0
+
+	code := fn.code
+	for pc := 0; pc < len(code); {
+		in := &code[pc]
+		pc++
+
+		inst.fuel--
+		if inst.fuel < 0 {
+			panic(ErrFuel)
+		}
+		if inst.fuel&0x3FFF == 0 {
+			if err := inst.ctx.Err(); err != nil {
+				panic(err)
+			}
+		}
+
+		switch in.op {
+		case opUnreachable:
+			trap("unreachable")
+		case opBlock:
+			params := int(in.imm >> 32)
+			labels = append(labels, label{height: sp - params, arity: int(uint32(in.imm)), target: int(in.a) + 1})
+		case opLoop:
+			params := int(in.imm >> 32)
+			labels = append(labels, label{height: sp - params, arity: params, target: pc, loop: true})
+		case opIf:
+			params := int(in.imm >> 32)
+			sp--
+			labels = append(labels, label{height: sp - params, arity: int(uint32(in.imm)), target: int(in.a) + 1})
+			if uint32(stack[sp]) == 0 {
+				pc = int(in.b)
+				if code[pc].op == opElse {
+					pc++
+				}
+			}
+		case opElse:
+			pc = int(in.a)
+		case opEnd:
+			labels = labels[:len(labels)-1]
+		case opBr:
+			pc, sp, labels = branch(stack, sp, labels, int(in.a))
+		case opBrIf:
+			sp--
+			if uint32(stack[sp]) != 0 {
+				pc, sp, labels = branch(stack, sp, labels, int(in.a))
+			}
+		case opBrTable:
+			sp--
+			i := uint64(uint32(stack[sp]))
+			if i >= uint64(len(in.targets)) {
+				i = uint64(len(in.targets) - 1)
+			}
+			pc, sp, labels = branch(stack, sp, labels, int(in.targets[i]))
+		case opReturn:
+			pc, sp, labels = branch(stack, sp, labels, len(labels)-1)
+		case opCall, opCallIndirect:
+			index := in.a
+			if in.op == opCallIndirect {
+				sp--
+				i := uint64(uint32(stack[sp]))
+				if i >= uint64(len(inst.table)) {
+					trap("undefined element %d", i)
+				}
+				if inst.table[i] < 0 {
+					trap("uninitialized element %d", i)
+				}
+				index = uint32(inst.table[i])
+				if !inst.module.funcType(index).equal(inst.module.types[in.a]) {
+					trap("indirect call type mismatch")
+				}
+			}
+			callee := inst.module.funcType(index)
+			sp -= len(callee.Params)
+			results := inst.call(index, stack[sp:sp+len(callee.Params)])
+			sp += copy(stack[sp:], results)
+		case opDrop:
+			sp--
+		case opSelect:
+			sp -= 2
+			if uint32(stack[sp+1]) == 0 {
+				stack[sp-1] = stack[sp]
+			}
+		case opLocalGet:
+			stack[sp] = locals[in.a]
+			sp++
+		case opLocalSet:
+			sp--
+			locals[in.a] = stack[sp]
+		case opLocalTee:
+			locals[in.a] = stack[sp-1]
+		case opGlobalGet:
+			stack[sp] = inst.globals[in.a]
+			sp++
+		case opGlobalSet:
+			sp--
+			inst.globals[in.a] = stack[sp]
+		case opMemorySize:
+			stack[sp] = uint64(len(inst.memory) / PageSize)
+			sp++
+		case opMemoryGrow:
+			pages := uint64(len(inst.memory) / PageSize)
+			delta := uint64(uint32(stack[sp-1]))
+			if pages+delta > uint64(inst.maxPages) {
+				stack[sp-1] = uint64(math.MaxUint32)
+				break
+			}
+			inst.memory = append(inst.memory, make([]byte, delta*PageSize)...)
+			stack[sp-1] = pages
+		case opI32Const, opI64Const, opF32Const, opF64Const:
+			stack[sp] = in.imm
+			sp++
+		case opMemoryInit:
+			sp -= 3
+			dst, src, n := uint64(uint32(stack[sp])), uint64(uint32(stack[sp+1])), uint64(uint32(stack[sp+2]))
+			data := inst.data[in.a]
+			if src+n > uint64(len(data)) {
+				trap("out of bounds memory access")
+			}
+			copy(inst.memory[inst.address(dst, 0, n):], data[src:src+n])
+		case opDataDrop:
+			inst.data[in.a] = nil
+		case opMemoryCopy:
+			sp -= 3
+			n := uint64(uint32(stack[sp+2]))
+			dst, src := inst.address(stack[sp], 0, n), inst.address(stack[sp+1], 0, n)
+			copy(inst.memory[dst:dst+n], inst.memory[src:src+n])
+		case opMemoryFill:
+			sp -= 3
+			n := uint64(uint32(stack[sp+2]))
+			dst, value := inst.address(stack[sp], 0, n), byte(stack[sp+1])
+			for i := dst; i < dst+n; i++ {
+				inst.memory[i] = value
+			}
+		default:
+			switch {
+			case in.op >= opI32Load && in.op <= opI64Store32:
+				sp = inst.memoryAccess(in, stack, sp)
+			case len(opTypes[in.op].params) == 1:
+				stack[sp-1] = unary(in.op, stack[sp-1])
+			default:
+				sp--
+				stack[sp-1] = binaryOp(in.op, stack[sp-1], stack[sp])
+			}
+		}
+	}
+
+	return stack[:len(t.Results)]
+}
+
+// branch continues after the block of the label at depth, or at the start
+// of its loop, with the values the label takes.
+func branch(stack []uint64, sp int, labels []label, depth int) (int, int, []label) {
+	l := labels[len(labels)-1-depth]
+	copy(stack[l.height:], stack[sp-l.arity:sp])
+	if l.loop {
+		labels = labels[:len(labels)-depth]
+	} else {
+		labels = labels[:len(labels)-1-depth]
+	}
+
+	return l.target, l.height + l.arity, labels
+}
+
+// memoryAccess runs a load or store and returns the new stack pointer.
+func (inst *Instance) memoryAccess(in *instr, stack []uint64, sp int) int {
+	size := uint64(memorySizes[in.op])
+	if opTypes[in.op].result == 0 {
+		sp -= 2
+		b := inst.memory[inst.address(stack[sp], in.imm, size):]
+		value := stack[sp+1]
+		switch size {
+		case 1:
+			b[0] = byte(value)
+		case 2:
+			binary.LittleEndian.PutUint16(b, uint16(value))
+		case 4:
+			binary.LittleEndian.PutUint32(b, uint32(value))
+		case 8:
+			binary.LittleEndian.PutUint64(b, value)
+		}
+		return sp
+	}
+
+	b := inst.memory[inst.address(stack[sp-1], in.imm, size):]
+	var value uint64
+	switch in.op {
+	case 0x28, 0x2A, 0x35:
+		value = uint64(binary.LittleEndian.Uint32(b))
+	case 0x29, 0x2B:
+		value = binary.LittleEndian.Uint64(b)
+	case 0x2C:
+		value = uint64(uint32(int32(int8(b[0]))))
+	case 0x2D, 0x31:
+		value = uint64(b[0])
+	case 0x2E:
+		value = uint64(uint32(int32(int16(binary.LittleEndian.Uint16(b)))))
+	case 0x2F, 0x33:
+		value = uint64(binary.LittleEndian.Uint16(b))
+	case 0x30:
+		value = uint64(int64(int8(b[0])))
+	case 0x32:
+		value = uint64(int64(int16(binary.LittleEndian.Uint16(b))))
+	case 0x34:
+		value = uint64(int64(int32(binary.LittleEndian.Uint32(b))))
+	}
+	stack[sp-1] = value
+
+	return sp
+}
@@ -0,0 +1,650 @@
+// Package wasm decodes and validates WebAssembly 1.0 binary modules and runs
+// them in an interpreter, limited in the instructions it executes and the
+// memory it grows to. Besides the 1.0 instructions it knows the sign
+// extension, saturating truncation and bulk memory ones. A module can only
+// call the host functions it is instantiated with, like the WASI subset of
+// RunWASI.
+package wasm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// ValueType is the type of a parameter, result, local or global.
+type ValueType byte
+
+// The value types, funcref and externref aren't supported.
+const (
+	I32 ValueType = 0x7F
+	I64 ValueType = 0x7E
+	F32 ValueType = 0x7D
+	F64 ValueType = 0x7C
+)
+
+// unknown is the type of the values popped in unreachable code, it matches
+// every other type.
+const unknown ValueType = 0
+
+func (t ValueType) String() string {
+	switch t {
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	case F32:
+		return "f32"
+	case F64:
+		return "f64"
+	}
+
+	return "unknown"
+}
+
+// PageSize is the size of a page of linear memory.
+const PageSize = 65536
+
+const (
+	magic   = "\x00asm"
+	version = 1
+
+	// maxPages is the most memory a module can address, 4 GiB
+	maxPages = 65536
+	// maxLocals and maxTableSize keep malformed modules from allocating a
+	// lot while they are decoded and instantiated
+	maxLocals    = 50000
+	maxTableSize = 100000
+)
+
+// The ids of the sections
+const (
+	sectionCustom = iota
+	sectionType
+	sectionImport
+	sectionFunction
+	sectionTable
+	sectionMemory
+	sectionGlobal
+	sectionExport
+	sectionStart
+	sectionElement
+	sectionCode
+	sectionData
+	sectionDataCount
+)
+
+// sectionOrder is the position of every section id, the data count section
+// comes before the code.
+var sectionOrder = [...]int{
+	sectionType:      1,
+	sectionImport:    2,
+	sectionFunction:  3,
+	sectionTable:     4,
+	sectionMemory:    5,
+	sectionGlobal:    6,
+	sectionExport:    7,
+	sectionStart:     8,
+	sectionElement:   9,
+	sectionDataCount: 10,
+	sectionCode:      11,
+	sectionData:      12,
+}
+
+// The kinds of imports and exports
+const (
+	externFunc = iota
+	externTable
+	externMemory
+	externGlobal
+)
+
+// FuncType is the signature of a function.
+type FuncType struct {
+	Params  []ValueType
+	Results []ValueType
+}
+
+func (t FuncType) String() string {
+	return fmt.Sprintf("%v -> %v", t.Params, t.Results)
+}
+
+func (t FuncType) equal(other FuncType) bool {
+	return equalTypes(t.Params, other.Params) && equalTypes(t.Results, other.Results)
+}
+
+func equalTypes(a, b []ValueType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Import is a function a module imports.
+type Import struct {
+	Module string
+	Name   string
+	Type   FuncType
+}
+
+type limits struct {
+	min, max uint32
+	hasMax   bool
+}
+
+// constExpr is the initializer of a global or the offset of a segment, a
+// constant or the value of a global.
+type constExpr struct {
+	op    byte
+	value uint64
+}
+
+type global struct {
+	typ     ValueType
+	mutable bool
+	init    constExpr
+}
+
+type export struct {
+	kind  byte
+	index uint32
+}
+
+type element struct {
+	offset constExpr
+	funcs  []uint32
+}
+
+type dataSegment struct {
+	active bool
+	offset constExpr
+	data   []byte
+}
+
+// function is a function of the module, its code decoded and validated.
+type function struct {
+	typeIndex uint32
+	// locals is the number of locals besides the parameters
+	locals int
+	code   []instr
+	// maxStack and maxLabels are the most values and nested blocks the
+	// code needs
+	maxStack  int
+	maxLabels int
+}
+
+// Module is a decoded and validated module, it can be instantiated any
+// number of times.
+type Module struct {
+	types   []FuncType
+	imports []Import
+	// importTypes and funcs are the type indices of the imported functions
+	// and the defined ones, in the order of the function index space
+	importTypes []uint32
+	funcs       []function
+	table       *limits
+	memory      *limits
+	globals     []global
+	exports     map[string]export
+	start       int64
+	elements    []element
+	data        []dataSegment
+	dataCount   int64
+}
+
+// Imports returns the functions the module imports.
+func (m *Module) Imports() []Import {
+	return append([]Import(nil), m.imports...)
+}
+
+// funcType returns the type of the function at index.
+func (m *Module) funcType(index uint32) FuncType {
+	if int(index) < len(m.importTypes) {
+		return m.types[m.importTypes[index]]
+	}
+
+	return m.types[m.funcs[int(index)-len(m.importTypes)].typeIndex]
+}
+
+func (m *Module) numFuncs() int {
+	return len(m.importTypes) + len(m.funcs)
+}
+
+// decodeError ends decoding, Decode returns it.
+type decodeError struct {
+	msg string
+}
+
+func (err *decodeError) Error() string {
+	return "wasm: " + err.msg
+}
+
+// reader reads the binary format, it panics with a *decodeError on malformed
+// input.
+type reader struct {
+	buf []byte
+	pos int
+}
+
+func (r *reader) fail(format string, args ...interface{}) {
+	panic(&decodeError{msg: fmt.Sprintf(format, args...) + fmt.Sprintf(" at byte %d", r.pos)})
+}
+
+func (r *reader) done() bool {
+	return r.pos >= len(r.buf)
+}
+
+func (r *reader) byte() byte {
+	if r.pos >= len(r.buf) {
+		r.fail("unexpected end")
+	}
+	b := r.buf[r.pos]
+	r.pos++
+
+	return b
+}
+
+func (r *reader) bytes(n int) []byte {
+	if n < 0 || n > len(r.buf)-r.pos {
+		r.fail("unexpected end")
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+
+	return b
+}
+
+// uleb reads an unsigned LEB128 integer of up to bits bits.
+func (r *reader) uleb(bits uint) uint64 {
+	var result uint64
+	for shift := uint(0); ; shift += 7 {
+		b := r.byte()
+		if shift >= bits || (bits-shift < 7 && (b&0x7F)>>(bits-shift) != 0) {
+			r.fail("integer too large")
+		}
+		result |= uint64(b&0x7F) << shift
+		if b&0x80 == 0 {
+			return result
+		}
+	}
+}
+
+// sleb reads a signed LEB128 integer of up to bits bits.
+func (r *reader) sleb(bits uint) int64 {
+	var result int64
+	for shift := uint(0); ; shift += 7 {
+		b := r.byte()
+		if shift >= bits {
+			r.fail("integer too large")
+		}
+		if shift == 63 && b != 0x00 && b != 0x7F {
+			r.fail("integer too large")
+		}
+		result |= int64(b&0x7F) << shift
+		if b&0x80 != 0 {
+			continue
+		}
+
+		if shift += 7; shift < 64 && b&0x40 != 0 {
+			result |= -1 << shift
+		}
+		if bits < 64 && (result < -1<<(bits-1) || result >= 1<<(bits-1)) {
+			r.fail("integer too large")
+		}
+		return result
+	}
+}
+
+func (r *reader) u32() uint32 {
+	return uint32(r.uleb(32))
+}
+
+// count reads the length of a vector, every element takes at least a byte.
+func (r *reader) count() int {
+	n := r.u32()
+	if uint64(n) > uint64(len(r.buf)-r.pos) {
+		r.fail("vector longer than its section")
+	}
+
+	return int(n)
+}
+
+func (r *reader) name() string {
+	name := r.bytes(r.count())
+	if !utf8.Valid(name) {
+		r.fail("malformed UTF-8 name")
+	}
+
+	return string(name)
+}
+
+func (r *reader) valueType() ValueType {
+	t := ValueType(r.byte())
+	switch t {
+	case I32, I64, F32, F64:
+		return t
+	}
+	r.pos--
+	r.fail("unknown value type 0x%02x", byte(t))
+
+	return 0
+}
+
+func (r *reader) limits(max uint32, what string) *limits {
+	var l limits
+	switch flags := r.byte(); flags {
+	case 0x00:
+	case 0x01:
+		l.hasMax = true
+	default:
+		r.fail("unknown limits flags 0x%02x", flags)
+	}
+	l.min = r.u32()
+	if l.hasMax {
+		l.max = r.u32()
+	}
+	if l.min > max || (l.hasMax && l.max > max) {
+		r.fail("a %s must not be larger than %d", what, max)
+	}
+	if l.hasMax && l.max < l.min {
+		r.fail("the maximum of a %s is smaller than its minimum", what)
+	}
+
+	return &l
+}
+
+// constExpr reads an initializer of type want, a constant or the value of
+// one of the globals before.
+func (r *reader) constExpr(m *Module, want ValueType, globals int) constExpr {
+	var expr constExpr
+	var got ValueType
+	expr.op = r.byte()
+	switch expr.op {
+	case opI32Const:
+		expr.value, got = uint64(uint32(r.sleb(32))), I32
+	case opI64Const:
+		expr.value, got = uint64(r.sleb(64)), I64
+	case opF32Const:
+		expr.value, got = uint64(binary.LittleEndian.Uint32(r.bytes(4))), F32
+	case opF64Const:
+		expr.value, got = binary.LittleEndian.Uint64(r.bytes(8)), F64
+	case opGlobalGet:
+		index := r.u32()
+		if int(index) >= globals {
+			r.fail("unknown global %d", index)
+		}
+		if m.globals[index].mutable {
+			r.fail("an initializer can't read a mutable global")
+		}
+		expr.value, got = uint64(index), m.globals[index].typ
+	default:
+		r.fail("unsupported initializer opcode 0x%02x", expr.op)
+	}
+	if r.byte() != opEnd {
+		r.fail("an initializer must be a single instruction")
+	}
+	if got != want {
+		r.fail("type mismatch: the initializer is %s, want %s", got, want)
+	}
+
+	return expr
+}
+
+// Decode decodes and validates a binary module.
+func Decode(binaryModule []byte) (module *Module, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			decodeErr, ok := r.(*decodeError)
+			if !ok {
+				panic(r)
+			}
+			module, err = nil, decodeErr
+		}
+	}()
+
+	r := &reader{buf: binaryModule}
+	if len(binaryModule) < 8 || string(binaryModule[:4]) != magic {
+		r.fail("no WebAssembly binary module")
+	}
+	r.pos = 4
+	if v := binary.LittleEndian.Uint32(r.bytes(4)); v != version {
+		r.fail("unsupported version %d", v)
+	}
+
+	m := &Module{exports: map[string]export{}, start: -1, dataCount: -1}
+	var functions []uint32
+	last := 0
+	for !r.done() {
+		id := r.byte()
+		size := int(r.u32())
+		body := &reader{buf: r.bytes(size)}
+		body.buf, body.pos = binaryModule[:r.pos], r.pos-size
+		if id != sectionCustom {
+			if int(id) >= len(sectionOrder) {
+				r.fail("unknown section %d", id)
+			}
+			if sectionOrder[id] <= last {
+				r.fail("section %d out of order", id)
+			}
+			last = sectionOrder[id]
+		}
+
+		switch id {
+		case sectionCustom:
+			body.name()
+			body.pos = len(body.buf)
+		case sectionType:
+			m.decodeTypes(body)
+		case sectionImport:
+			m.decodeImports(body)
+		case sectionFunction:
+			functions = make([]uint32, body.count())
+			for i := range functions {
+				if functions[i] = body.u32(); int(functions[i]) >= len(m.types) {
+					body.fail("unknown type %d", functions[i])
+				}
+			}
+		case sectionTable:
+			for n := body.count(); n > 0; n-- {
+				if m.table != nil {
+					body.fail("multiple tables")
+				}
+				if t := body.byte(); t != refFunc {
+					body.fail("unsupported table type 0x%02x", t)
+				}
+				m.table = body.limits(maxTableSize, "table")
+			}
+		case sectionMemory:
+			for n := body.count(); n > 0; n-- {
+				if m.memory != nil {
+					body.fail("multiple memories")
+				}
+				m.memory = body.limits(maxPages, "memory")
+			}
+		case sectionGlobal:
+			for n := body.count(); n > 0; n-- {
+				g := global{typ: body.valueType()}
+				switch mut := body.byte(); mut {
+				case 0x00:
+				case 0x01:
+					g.mutable = true
+				default:
+					body.fail("unknown mutability 0x%02x", mut)
+				}
+				g.init = body.constExpr(m, g.typ, len(m.globals))
+				m.globals = append(m.globals, g)
+			}
+		case sectionExport:
+			m.decodeExports(body, len(m.importTypes)+len(functions))
+		case sectionStart:
+			index := body.u32()
+			if int(index) >= len(m.importTypes)+len(functions) {
+				body.fail("unknown function %d", index)
+			}
+			m.start = int64(index)
+		case sectionElement:
+			m.decodeElements(body, len(m.importTypes)+len(functions))
+		case sectionDataCount:
+			m.dataCount = int64(body.u32())
+		case sectionCode:
+			if body.count() != len(functions) {
+				body.fail("the function and code sections differ in length")
+			}
+			m.funcs = make([]function, len(functions))
+			for i := range m.funcs {
+				m.funcs[i].typeIndex = functions[i]
+			}
+			for i := range m.funcs {
+				size := int(body.u32())
+				end := body.pos + size
+				if size < 0 || end > len(body.buf) {
+					body.fail("unexpected end")
+				}
+				code := &reader{buf: body.buf[:end], pos: body.pos}
+				m.decodeFunction(code, &m.funcs[i])
+				body.pos = end
+			}
+		case sectionData:
+			m.decodeData(body)
+		}
+		if body.pos != len(body.buf) {
+			body.fail("section %d longer than its contents", id)
+		}
+	}
+
+	if len(functions) > 0 && m.funcs == nil {
+		r.fail("the code section is missing")
+	}
+	if m.dataCount >= 0 && int(m.dataCount) != len(m.data) {
+		r.fail("the data count and data sections differ in length")
+	}
+	if m.start >= 0 {
+		if t := m.funcType(uint32(m.start)); len(t.Params) != 0 || len(t.Results) != 0 {
+			r.fail("the start function must not have parameters or results")
+		}
+	}
+
+	return m, nil
+}
+
+func (m *Module) decodeTypes(r *reader) {
+	m.types = make([]FuncType, r.count())
+	for i := range m.types {
+		if form := r.byte(); form != 0x60 {
+			r.fail("unknown function type form 0x%02x", form)
+		}
+		for n := r.count(); n > 0; n-- {
+			m.types[i].Params = append(m.types[i].Params, r.valueType())
+		}
+		for n := r.count(); n > 0; n-- {
+			m.types[i].Results = append(m.types[i].Results, r.valueType())
+		}
+	}
+}
+
+func (m *Module) decodeImports(r *reader) {
+	for n := r.count(); n > 0; n-- {
+		module, name := r.name(), r.name()
+		if kind := r.byte(); kind != externFunc {
+			r.fail("%s.%s: only functions can be imported", module, name)
+		}
+		index := r.u32()
+		if int(index) >= len(m.types) {
+			r.fail("unknown type %d", index)
+		}
+		m.imports = append(m.imports, Import{Module: module, Name: name, Type: m.types[index]})
+		m.importTypes = append(m.importTypes, index)
+	}
+}
+
+func (m *Module) decodeExports(r *reader, funcs int) {
+	for n := r.count(); n > 0; n-- {
+		name := r.name()
+		e := export{kind: r.byte(), index: r.u32()}
+		if _, exists := m.exports[name]; exists {
+			r.fail("duplicate export %q", name)
+		}
+		var count int
+		switch e.kind {
+		case externFunc:
+			count = funcs
+		case externTable:
+			count = boolInt(m.table != nil)
+		case externMemory:
+			count = boolInt(m.memory != nil)
+		case externGlobal:
+			count = len(m.globals)
+		default:
+			r.fail("unknown export kind 0x%02x", e.kind)
+		}
+		if int(e.index) >= count {
+			r.fail("export %q of an unknown index %d", name, e.index)
+		}
+		m.exports[name] = e
+	}
+}
+
+func (m *Module) decodeElements(r *reader, funcs int) {
+	for n := r.count(); n > 0; n-- {
+		if kind := r.u32(); kind != 0 {
+			r.fail("element segments of kind %d aren't supported", kind)
+		}
+		if m.table == nil {
+			r.fail("element segment without a table")
+		}
+		e := element{offset: r.constExpr(m, I32, len(m.globals))}
+		e.funcs = make([]uint32, r.count())
+		for i := range e.funcs {
+			if e.funcs[i] = r.u32(); int(e.funcs[i]) >= funcs {
+				r.fail("unknown function %d", e.funcs[i])
+			}
+		}
+		m.elements = append(m.elements, e)
+	}
+}
+
+func (m *Module) decodeData(r *reader) {
+	for n := r.count(); n > 0; n-- {
+		var d dataSegment
+		switch kind := r.u32(); kind {
+		case 0:
+			d.active = true
+		case 1:
+		case 2:
+			d.active = true
+			if index := r.u32(); index != 0 {
+				r.fail("unknown memory %d", index)
+			}
+		default:
+			r.fail("unknown data segment kind %d", kind)
+		}
+		if d.active {
+			if m.memory == nil {
+				r.fail("data segment without a memory")
+			}
+			d.offset = r.constExpr(m, I32, len(m.globals))
+		}
+		d.data = r.bytes(r.count())
+		m.data = append(m.data, d)
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// float32Bits and float64Bits store floats as values.
+func float32Bits(f float32) uint64 { return uint64(math.Float32bits(f)) }
+
+func float64Bits(f float64) uint64 { return math.Float64bits(f) }
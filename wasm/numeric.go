@@ -0,0 +1,447 @@
+package wasm
+
+import (
+	"math"
+	"math/bits"
+)
+
+func boolValue(b bool) uint64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func f32(v uint64) float32 { return math.Float32frombits(uint32(v)) }
+
+func f64(v uint64) float64 { return math.Float64frombits(v) }
+
+// truncRange checks that f truncated is in [min, max), it returns -1 below
+// and 1 above for the saturating truncations, the others trap outside.
+func truncRange(f, min, max float64, saturating bool) int {
+	switch {
+	case f != f:
+		if !saturating {
+			trap("invalid conversion to integer")
+		}
+	case math.Trunc(f) < min:
+		if !saturating {
+			trap("integer overflow")
+		}
+		return -1
+	case math.Trunc(f) >= max:
+		if !saturating {
+			trap("integer overflow")
+		}
+		return 1
+	}
+
+	return 0
+}
+
+func truncI32(f float64, signed, saturating bool) uint64 {
+	if signed {
+		switch truncRange(f, -1<<31, 1<<31, saturating) {
+		case -1:
+			return 1 << 31
+		case 1:
+			return math.MaxInt32
+		}
+	} else {
+		switch truncRange(f, 0, 1<<32, saturating) {
+		case -1:
+			return 0
+		case 1:
+			return math.MaxUint32
+		}
+	}
+	if f != f {
+		return 0
+	}
+
+	if signed {
+		return uint64(uint32(int32(math.Trunc(f))))
+	}
+	return uint64(uint32(math.Trunc(f)))
+}
+
+func truncI64(f float64, signed, saturating bool) uint64 {
+	if signed {
+		switch truncRange(f, -1<<63, 1<<63, saturating) {
+		case -1:
+			return 1 << 63
+		case 1:
+			return math.MaxInt64
+		}
+	} else {
+		switch truncRange(f, 0, 1<<64, saturating) {
+		case -1:
+			return 0
+		case 1:
+			return math.MaxUint64
+		}
+	}
+	if f != f {
+		return 0
+	}
+
+	if signed {
+		return uint64(int64(math.Trunc(f)))
+	}
+	return uint64(math.Trunc(f))
+}
+
+// nearest rounds to the nearest integer, ties to even.
+func nearest(f float64) float64 {
+	return math.RoundToEven(f)
+}
+
+// unary runs a numeric instruction with a single operand.
+func unary(op uint16, v uint64) uint64 {
+	switch op {
+	case 0x45:
+		return boolValue(uint32(v) == 0)
+	case 0x50:
+		return boolValue(v == 0)
+	case 0x67:
+		return uint64(bits.LeadingZeros32(uint32(v)))
+	case 0x68:
+		return uint64(bits.TrailingZeros32(uint32(v)))
+	case 0x69:
+		return uint64(bits.OnesCount32(uint32(v)))
+	case 0x79:
+		return uint64(bits.LeadingZeros64(v))
+	case 0x7A:
+		return uint64(bits.TrailingZeros64(v))
+	case 0x7B:
+		return uint64(bits.OnesCount64(v))
+
+	case 0x8B:
+		return v &^ (1 << 31)
+	case 0x8C:
+		return uint64(uint32(v) ^ 1<<31)
+	case 0x8D:
+		return float32Bits(float32(math.Ceil(float64(f32(v)))))
+	case 0x8E:
+		return float32Bits(float32(math.Floor(float64(f32(v)))))
+	case 0x8F:
+		return float32Bits(float32(math.Trunc(float64(f32(v)))))
+	case 0x90:
+		return float32Bits(float32(nearest(float64(f32(v)))))
+	case 0x91:
+		return float32Bits(float32(math.Sqrt(float64(f32(v)))))
+	case 0x99:
+		return v &^ (1 << 63)
+	case 0x9A:
+		return v ^ 1<<63
+	case 0x9B:
+		return float64Bits(math.Ceil(f64(v)))
+	case 0x9C:
+		return float64Bits(math.Floor(f64(v)))
+	case 0x9D:
+		return float64Bits(math.Trunc(f64(v)))
+	case 0x9E:
+		return float64Bits(nearest(f64(v)))
+	case 0x9F:
+		return float64Bits(math.Sqrt(f64(v)))
+
+	case 0xA7:
+		return uint64(uint32(v))
+	case 0xA8, 0xA9:
+		return truncI32(float64(f32(v)), op == 0xA8, false)
+	case 0xAA, 0xAB:
+		return truncI32(f64(v), op == 0xAA, false)
+	case 0xAC:
+		return uint64(int64(int32(v)))
+	case 0xAD:
+		return uint64(uint32(v))
+	case 0xAE, 0xAF:
+		return truncI64(float64(f32(v)), op == 0xAE, false)
+	case 0xB0, 0xB1:
+		return truncI64(f64(v), op == 0xB0, false)
+	case 0xB2:
+		return float32Bits(float32(int32(v)))
+	case 0xB3:
+		return float32Bits(float32(uint32(v)))
+	case 0xB4:
+		return float32Bits(float32(int64(v)))
+	case 0xB5:
+		return float32Bits(float32(v))
+	case 0xB6:
+		return float32Bits(float32(f64(v)))
+	case 0xB7:
+		return float64Bits(float64(int32(v)))
+	case 0xB8:
+		return float64Bits(float64(uint32(v)))
+	case 0xB9:
+		return float64Bits(float64(int64(v)))
+	case 0xBA:
+		return float64Bits(float64(v))
+	case 0xBB:
+		return float64Bits(float64(f32(v)))
+	case 0xBC, 0xBE:
+		return uint64(uint32(v))
+	case 0xBD, 0xBF:
+		return v
+
+	case 0xC0:
+		return uint64(uint32(int32(int8(v))))
+	case 0xC1:
+		return uint64(uint32(int32(int16(v))))
+	case 0xC2:
+		return uint64(int64(int8(v)))
+	case 0xC3:
+		return uint64(int64(int16(v)))
+	case 0xC4:
+		return uint64(int64(int32(v)))
+
+	case opPrefix + 0, opPrefix + 1:
+		return truncI32(float64(f32(v)), op == opPrefix+0, true)
+	case opPrefix + 2, opPrefix + 3:
+		return truncI32(f64(v), op == opPrefix+2, true)
+	case opPrefix + 4, opPrefix + 5:
+		return truncI64(float64(f32(v)), op == opPrefix+4, true)
+	case opPrefix + 6, opPrefix + 7:
+		return truncI64(f64(v), op == opPrefix+6, true)
+	}
+
+	trap("unknown unary opcode 0x%02x", op)
+	return 0
+}
+
+// binaryOp runs a numeric instruction with two operands.
+func binaryOp(op uint16, a, b uint64) uint64 {
+	switch {
+	case op <= 0x4F || (op >= 0x6A && op <= 0x78):
+		return i32Binary(op, uint32(a), uint32(b))
+	case op <= 0x5A || (op >= 0x7C && op <= 0x8A):
+		return i64Binary(op, a, b)
+	case op <= 0x60 || (op >= 0x92 && op <= 0x98):
+		return f32Binary(op, f32(a), f32(b))
+	}
+
+	return f64Binary(op, f64(a), f64(b))
+}
+
+func i32Binary(op uint16, a, b uint32) uint64 {
+	switch op {
+	case 0x46:
+		return boolValue(a == b)
+	case 0x47:
+		return boolValue(a != b)
+	case 0x48:
+		return boolValue(int32(a) < int32(b))
+	case 0x49:
+		return boolValue(a < b)
+	case 0x4A:
+		return boolValue(int32(a) > int32(b))
+	case 0x4B:
+		return boolValue(a > b)
+	case 0x4C:
+		return boolValue(int32(a) <= int32(b))
+	case 0x4D:
+		return boolValue(a <= b)
+	case 0x4E:
+		return boolValue(int32(a) >= int32(b))
+	case 0x4F:
+		return boolValue(a >= b)
+	}
+
+	var result uint32
+	switch op {
+	case 0x6A:
+		result = a + b
+	case 0x6B:
+		result = a - b
+	case 0x6C:
+		result = a * b
+	case 0x6D:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		if int32(a) == math.MinInt32 && int32(b) == -1 {
+			trap("integer overflow")
+		}
+		result = uint32(int32(a) / int32(b))
+	case 0x6E:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		result = a / b
+	case 0x6F:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		if int32(b) != -1 {
+			result = uint32(int32(a) % int32(b))
+		}
+	case 0x70:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		result = a % b
+	case 0x71:
+		result = a & b
+	case 0x72:
+		result = a | b
+	case 0x73:
+		result = a ^ b
+	case 0x74:
+		result = a << (b & 31)
+	case 0x75:
+		result = uint32(int32(a) >> (b & 31))
+	case 0x76:
+		result = a >> (b & 31)
+	case 0x77:
+		result = bits.RotateLeft32(a, int(b&31))
+	case 0x78:
+		result = bits.RotateLeft32(a, -int(b&31))
+	}
+
+	return uint64(result)
+}
+
+func i64Binary(op uint16, a, b uint64) uint64 {
+	switch op {
+	case 0x51:
+		return boolValue(a == b)
+	case 0x52:
+		return boolValue(a != b)
+	case 0x53:
+		return boolValue(int64(a) < int64(b))
+	case 0x54:
+		return boolValue(a < b)
+	case 0x55:
+		return boolValue(int64(a) > int64(b))
+	case 0x56:
+		return boolValue(a > b)
+	case 0x57:
+		return boolValue(int64(a) <= int64(b))
+	case 0x58:
+		return boolValue(a <= b)
+	case 0x59:
+		return boolValue(int64(a) >= int64(b))
+	case 0x5A:
+		return boolValue(a >= b)
+	case 0x7C:
+		return a + b
+	case 0x7D:
+		return a - b
+	case 0x7E:
+		return a * b
+	case 0x7F:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		if int64(a) == math.MinInt64 && int64(b) == -1 {
+			trap("integer overflow")
+		}
+		return uint64(int64(a) / int64(b))
+	case 0x80:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		return a / b
+	case 0x81:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		if int64(b) == -1 {
+			return 0
+		}
+		return uint64(int64(a) % int64(b))
+	case 0x82:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		return a % b
+	case 0x83:
+		return a & b
+	case 0x84:
+		return a | b
+	case 0x85:
+		return a ^ b
+	case 0x86:
+		return a << (b & 63)
+	case 0x87:
+		return uint64(int64(a) >> (b & 63))
+	case 0x88:
+		return a >> (b & 63)
+	case 0x89:
+		return bits.RotateLeft64(a, int(b&63))
+	case 0x8A:
+		return bits.RotateLeft64(a, -int(b&63))
+	}
+
+	trap("unknown binary opcode 0x%02x", op)
+	return 0
+}
+
+func f32Binary(op uint16, a, b float32) uint64 {
+	switch op {
+	case 0x5B:
+		return boolValue(a == b)
+	case 0x5C:
+		return boolValue(a != b)
+	case 0x5D:
+		return boolValue(a < b)
+	case 0x5E:
+		return boolValue(a > b)
+	case 0x5F:
+		return boolValue(a <= b)
+	case 0x60:
+		return boolValue(a >= b)
+	case 0x92:
+		return float32Bits(a + b)
+	case 0x93:
+		return float32Bits(a - b)
+	case 0x94:
+		return float32Bits(a * b)
+	case 0x95:
+		return float32Bits(a / b)
+	case 0x96:
+		return float32Bits(float32(math.Min(float64(a), float64(b))))
+	case 0x97:
+		return float32Bits(float32(math.Max(float64(a), float64(b))))
+	case 0x98:
+		return uint64(math.Float32bits(a)&^(1<<31) | math.Float32bits(b)&(1<<31))
+	}
+
+	trap("unknown binary opcode 0x%02x", op)
+	return 0
+}
+
+func f64Binary(op uint16, a, b float64) uint64 {
+	switch op {
+	case 0x61:
+		return boolValue(a == b)
+	case 0x62:
+		return boolValue(a != b)
+	case 0x63:
+		return boolValue(a < b)
+	case 0x64:
+		return boolValue(a > b)
+	case 0x65:
+		return boolValue(a <= b)
+	case 0x66:
+		return boolValue(a >= b)
+	case 0xA0:
+		return float64Bits(a + b)
+	case 0xA1:
+		return float64Bits(a - b)
+	case 0xA2:
+		return float64Bits(a * b)
+	case 0xA3:
+		return float64Bits(a / b)
+	case 0xA4:
+		return float64Bits(math.Min(a, b))
+	case 0xA5:
+		return float64Bits(math.Max(a, b))
+	case 0xA6:
+		return float64Bits(math.Copysign(a, b))
+	}
+
+	trap("unknown binary opcode 0x%02x", op)
+	return 0
+}
@@ -0,0 +1,294 @@
+package wasm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// wasiModule is the module of the WASI functions.
+const wasiModule = "wasi_snapshot_preview1"
+
+// The WASI errnos the functions return
+const (
+	errnoSuccess = 0
+	errnoBadf    = 8
+	errnoFault   = 21
+	errnoIO      = 29
+	errnoNosys   = 52
+	errnoSpipe   = 70
+)
+
+// WASIConfig are the streams and limits of RunWASI.
+type WASIConfig struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	Limits Limits
+}
+
+// ExitError is returned by RunWASI for modules that exit with a code other
+// than 0.
+type ExitError struct {
+	Code uint32
+}
+
+func (err *ExitError) Error() string {
+	return fmt.Sprintf("wasm: exit status %d", err.Code)
+}
+
+// RunWASI instantiates module and runs its _start function with the WASI
+// functions a filter needs: the standard streams, the clocks and random
+// numbers. There are no files, arguments or environment variables, the
+// other WASI functions return ENOSYS. An error of a stream ends the run with
+// it.
+func RunWASI(ctx context.Context, module *Module, config WASIConfig) error {
+	w := &wasi{config: config}
+	imports := Imports{wasiModule: w.functions()}
+	for _, imp := range module.imports {
+		_, exists := imports[imp.Module][imp.Name]
+		if imp.Module == wasiModule && !exists && equalTypes(imp.Type.Results, []ValueType{I32}) {
+			imports[wasiModule][imp.Name] = HostFunc{Type: imp.Type, Call: w.nosys}
+		}
+	}
+
+	inst, err := Instantiate(ctx, module, imports, config.Limits)
+	if err == nil {
+		_, err = inst.Call(ctx, "_start")
+	}
+	if exit, ok := err.(*ExitError); ok && exit.Code == 0 {
+		return nil
+	}
+
+	return err
+}
+
+type wasi struct {
+	config WASIConfig
+}
+
+func i32s(n int) []ValueType {
+	types := make([]ValueType, n)
+	for i := range types {
+		types[i] = I32
+	}
+
+	return types
+}
+
+func (w *wasi) functions() map[string]HostFunc {
+	errno := []ValueType{I32}
+	return map[string]HostFunc{
+		"fd_write":            {Type: FuncType{Params: i32s(4), Results: errno}, Call: w.fdWrite},
+		"fd_read":             {Type: FuncType{Params: i32s(4), Results: errno}, Call: w.fdRead},
+		"fd_close":            {Type: FuncType{Params: i32s(1), Results: errno}, Call: w.fdClose},
+		"fd_fdstat_get":       {Type: FuncType{Params: i32s(2), Results: errno}, Call: w.fdFdstatGet},
+		"fd_prestat_get":      {Type: FuncType{Params: i32s(2), Results: errno}, Call: w.badf},
+		"fd_prestat_dir_name": {Type: FuncType{Params: i32s(3), Results: errno}, Call: w.badf},
+		"fd_seek":             {Type: FuncType{Params: []ValueType{I32, I64, I32, I32}, Results: errno}, Call: w.fdSeek},
+		"args_sizes_get":      {Type: FuncType{Params: i32s(2), Results: errno}, Call: w.sizesGet},
+		"args_get":            {Type: FuncType{Params: i32s(2), Results: errno}, Call: w.success},
+		"environ_sizes_get":   {Type: FuncType{Params: i32s(2), Results: errno}, Call: w.sizesGet},
+		"environ_get":         {Type: FuncType{Params: i32s(2), Results: errno}, Call: w.success},
+		"clock_time_get":      {Type: FuncType{Params: []ValueType{I32, I64, I32}, Results: errno}, Call: w.clockTimeGet},
+		"random_get":          {Type: FuncType{Params: i32s(2), Results: errno}, Call: w.randomGet},
+		"sched_yield":         {Type: FuncType{Results: errno}, Call: w.success},
+		"proc_exit":           {Type: FuncType{Params: i32s(1)}, Call: w.procExit},
+	}
+}
+
+func result(errno uint64) []uint64 {
+	return []uint64{errno}
+}
+
+// bytesAt returns n bytes of the memory at ptr, false outside of it.
+func bytesAt(inst *Instance, ptr, n uint64) ([]byte, bool) {
+	ptr, n = uint64(uint32(ptr)), uint64(uint32(n))
+	if ptr+n > uint64(len(inst.memory)) {
+		return nil, false
+	}
+
+	return inst.memory[ptr : ptr+n], true
+}
+
+// iovecs returns the buffers of the iovec array at ptr.
+func iovecs(inst *Instance, ptr, n uint64) ([][]byte, bool) {
+	n = uint64(uint32(n))
+	if n > 1024 {
+		return nil, false
+	}
+	vecs, ok := bytesAt(inst, ptr, n*8)
+	if !ok {
+		return nil, false
+	}
+
+	buffers := make([][]byte, n)
+	for i := range buffers {
+		buf := uint64(binary.LittleEndian.Uint32(vecs[i*8:]))
+		length := uint64(binary.LittleEndian.Uint32(vecs[i*8+4:]))
+		if buffers[i], ok = bytesAt(inst, buf, length); !ok {
+			return nil, false
+		}
+	}
+
+	return buffers, true
+}
+
+func putUint32(inst *Instance, ptr uint64, value uint32) bool {
+	b, ok := bytesAt(inst, ptr, 4)
+	if ok {
+		binary.LittleEndian.PutUint32(b, value)
+	}
+
+	return ok
+}
+
+func (w *wasi) fdWrite(inst *Instance, args []uint64) ([]uint64, error) {
+	var out io.Writer
+	switch uint32(args[0]) {
+	case 1:
+		out = w.config.Stdout
+	case 2:
+		out = w.config.Stderr
+	}
+	if out == nil {
+		return result(errnoBadf), nil
+	}
+	buffers, ok := iovecs(inst, args[1], args[2])
+	if !ok {
+		return result(errnoFault), nil
+	}
+
+	written := 0
+	for _, buf := range buffers {
+		n, err := out.Write(buf)
+		written += n
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !putUint32(inst, args[3], uint32(written)) {
+		return result(errnoFault), nil
+	}
+
+	return result(errnoSuccess), nil
+}
+
+func (w *wasi) fdRead(inst *Instance, args []uint64) ([]uint64, error) {
+	if uint32(args[0]) != 0 || w.config.Stdin == nil {
+		return result(errnoBadf), nil
+	}
+	buffers, ok := iovecs(inst, args[1], args[2])
+	if !ok {
+		return result(errnoFault), nil
+	}
+
+	read := 0
+	for _, buf := range buffers {
+		n, err := io.ReadFull(w.config.Stdin, buf)
+		read += n
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return result(errnoIO), nil
+		}
+	}
+	if !putUint32(inst, args[3], uint32(read)) {
+		return result(errnoFault), nil
+	}
+
+	return result(errnoSuccess), nil
+}
+
+func (w *wasi) fdClose(inst *Instance, args []uint64) ([]uint64, error) {
+	if uint32(args[0]) > 2 {
+		return result(errnoBadf), nil
+	}
+
+	return result(errnoSuccess), nil
+}
+
+// fdFdstatGet describes the standard streams as character devices, stdin
+// readable and the others writable.
+func (w *wasi) fdFdstatGet(inst *Instance, args []uint64) ([]uint64, error) {
+	const (
+		characterDevice = 2
+		rightRead       = 1 << 1
+		rightWrite      = 1 << 6
+	)
+
+	fd := uint32(args[0])
+	if fd > 2 {
+		return result(errnoBadf), nil
+	}
+	stat, ok := bytesAt(inst, args[1], 24)
+	if !ok {
+		return result(errnoFault), nil
+	}
+	for i := range stat {
+		stat[i] = 0
+	}
+	stat[0] = characterDevice
+	rights := uint64(rightWrite)
+	if fd == 0 {
+		rights = rightRead
+	}
+	binary.LittleEndian.PutUint64(stat[8:], rights)
+
+	return result(errnoSuccess), nil
+}
+
+func (w *wasi) fdSeek(inst *Instance, args []uint64) ([]uint64, error) {
+	return result(errnoSpipe), nil
+}
+
+// sizesGet has neither arguments nor environment variables.
+func (w *wasi) sizesGet(inst *Instance, args []uint64) ([]uint64, error) {
+	if !putUint32(inst, args[0], 0) || !putUint32(inst, args[1], 0) {
+		return result(errnoFault), nil
+	}
+
+	return result(errnoSuccess), nil
+}
+
+func (w *wasi) clockTimeGet(inst *Instance, args []uint64) ([]uint64, error) {
+	b, ok := bytesAt(inst, args[2], 8)
+	if !ok {
+		return result(errnoFault), nil
+	}
+	binary.LittleEndian.PutUint64(b, uint64(time.Now().UnixNano()))
+
+	return result(errnoSuccess), nil
+}
+
+func (w *wasi) randomGet(inst *Instance, args []uint64) ([]uint64, error) {
+	b, ok := bytesAt(inst, args[0], args[1])
+	if !ok {
+		return result(errnoFault), nil
+	}
+	if _, err := rand.Read(b); err != nil {
+		return result(errnoIO), nil
+	}
+
+	return result(errnoSuccess), nil
+}
+
+func (w *wasi) procExit(inst *Instance, args []uint64) ([]uint64, error) {
+	return nil, &ExitError{Code: uint32(args[0])}
+}
+
+func (w *wasi) success(inst *Instance, args []uint64) ([]uint64, error) {
+	return result(errnoSuccess), nil
+}
+
+func (w *wasi) badf(inst *Instance, args []uint64) ([]uint64, error) {
+	return result(errnoBadf), nil
+}
+
+func (w *wasi) nosys(inst *Instance, args []uint64) ([]uint64, error) {
+	return result(errnoNosys), nil
+}
@@ -0,0 +1,440 @@
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func uleb(v uint64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7F)
+		v >>= 7
+		if v == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func sleb(v int64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7F)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func vec(items ...[]byte) []byte {
+	return concat(append([][]byte{uleb(uint64(len(items)))}, items...)...)
+}
+
+func section(id byte, content []byte) []byte {
+	return concat([]byte{id}, uleb(uint64(len(content))), content)
+}
+
+func name(s string) []byte {
+	return concat(uleb(uint64(len(s))), []byte(s))
+}
+
+func funcType(params, results []ValueType) []byte {
+	p, r := make([][]byte, len(params)), make([][]byte, len(results))
+	for i, t := range params {
+		p[i] = []byte{byte(t)}
+	}
+	for i, t := range results {
+		r[i] = []byte{byte(t)}
+	}
+
+	return concat([]byte{0x60}, vec(p...), vec(r...))
+}
+
+func i32(v int32) []byte { return concat([]byte{opI32Const}, sleb(int64(v))) }
+
+func i64(v int64) []byte { return concat([]byte{opI64Const}, sleb(v)) }
+
+type testImport struct {
+	module, name    string
+	params, results []ValueType
+}
+
+// testFunc is a function of a test module, its code without the final end.
+type testFunc struct {
+	export          string
+	params, results []ValueType
+	locals          []ValueType
+	code            []byte
+}
+
+type testModule struct {
+	imports []testImport
+	funcs   []testFunc
+	// memory is the limits of the memory, none without
+	memory []byte
+	// table holds the functions at index 0 and up
+	table []uint32
+	// data is written to the memory at 0
+	data string
+}
+
+// bytes encodes the module, every import and function has its own type.
+func (m testModule) bytes() []byte {
+	var types, imports, funcs, exports, code [][]byte
+	for _, imp := range m.imports {
+		imports = append(imports, concat(name(imp.module), name(imp.name), []byte{externFunc}, uleb(uint64(len(types)))))
+		types = append(types, funcType(imp.params, imp.results))
+	}
+	for i, f := range m.funcs {
+		funcs = append(funcs, uleb(uint64(len(types))))
+		types = append(types, funcType(f.params, f.results))
+		if f.export != "" {
+			exports = append(exports, concat(name(f.export), []byte{externFunc}, uleb(uint64(len(m.imports)+i))))
+		}
+		var locals [][]byte
+		for _, t := range f.locals {
+			locals = append(locals, []byte{1, byte(t)})
+		}
+		body := concat(vec(locals...), f.code, []byte{opEnd})
+		code = append(code, concat(uleb(uint64(len(body))), body))
+	}
+
+	module := concat([]byte(magic), []byte{version, 0, 0, 0}, section(sectionType, vec(types...)))
+	if len(imports) > 0 {
+		module = concat(module, section(sectionImport, vec(imports...)))
+	}
+	module = concat(module, section(sectionFunction, vec(funcs...)))
+	if m.table != nil {
+		module = concat(module, section(sectionTable, vec(concat([]byte{refFunc, 0}, uleb(uint64(len(m.table)))))))
+	}
+	if m.memory != nil {
+		module = concat(module, section(sectionMemory, vec(m.memory)))
+	}
+	module = concat(module, section(sectionExport, vec(exports...)))
+	if m.table != nil {
+		var indices [][]byte
+		for _, index := range m.table {
+			indices = append(indices, uleb(uint64(index)))
+		}
+		module = concat(module, section(sectionElement, vec(concat([]byte{0}, i32(0), []byte{opEnd}, vec(indices...)))))
+	}
+	module = concat(module, section(sectionCode, vec(code...)))
+	if m.data != "" {
+		module = concat(module, section(sectionData, vec(concat([]byte{0}, i32(0), []byte{opEnd}, name(m.data)))))
+	}
+
+	return module
+}
+
+func instantiate(t *testing.T, m testModule, limits Limits) *Instance {
+	module, err := Decode(m.bytes())
+	if err != nil {
+		t.Fatalf("Decode() = %v", err)
+	}
+	inst, err := Instantiate(context.Background(), module, nil, limits)
+	if err != nil {
+		t.Fatalf("Instantiate() = %v", err)
+	}
+
+	return inst
+}
+
+var (
+	i32i32 = []ValueType{I32, I32}
+	one32  = []ValueType{I32}
+	one64  = []ValueType{I64}
+)
+
+func TestCall(t *testing.T) {
+	m := testModule{
+		memory: []byte{0x00, 1},
+		table:  []uint32{0, 1},
+		funcs: []testFunc{
+			// add
+			{export: "add", params: i32i32, results: one32, code: []byte{opLocalGet, 0, opLocalGet, 1, 0x6A}},
+			// factorial, recursively
+			{export: "fac", params: one64, results: one64, code: concat(
+				[]byte{opLocalGet, 0}, i64(1), []byte{0x57, opIf, byte(I64)}, i64(1),
+				[]byte{opElse, opLocalGet, 0, opLocalGet, 0}, i64(1), []byte{0x7D, opCall, 1, 0x7E, opEnd},
+			)},
+			// sum of 1 to n in a loop
+			{export: "sum", params: one32, results: one32, locals: one32, code: concat(
+				[]byte{opBlock, 0x40, opLoop, 0x40, opLocalGet, 0, opI32Eqz, opBrIf, 1},
+				[]byte{opLocalGet, 1, opLocalGet, 0, 0x6A, opLocalSet, 1},
+				[]byte{opLocalGet, 0}, i32(1), []byte{0x6B, opLocalSet, 0, opBr, 0, opEnd, opEnd, opLocalGet, 1},
+			)},
+			// branch table, 0 -> 10, 1 -> 20, others -> 30
+			{export: "pick", params: one32, results: one32, code: concat(
+				[]byte{opBlock, 0x40, opBlock, 0x40, opBlock, 0x40, opLocalGet, 0, opBrTable, 2, 0, 1, 2, opEnd},
+				i32(10), []byte{opReturn, opEnd}, i32(20), []byte{opReturn, opEnd}, i32(30),
+			)},
+			// stores and loads a value, sign extended
+			{export: "memory", params: one32, results: one64, code: concat(
+				i32(100), []byte{opLocalGet, 0, 0x3A, 0, 3}, i32(100), []byte{0x30, 0, 3},
+			)},
+			// calls the function at index 0 of the table
+			{export: "indirect", params: one32, results: one32, code: concat(
+				i32(2), i32(3), []byte{opLocalGet, 0, opCallIndirect, 0, 0},
+			)},
+			// f64 sqrt of an i32
+			{export: "sqrt", params: one32, results: one32, code: []byte{opLocalGet, 0, 0xB7, 0x9F, 0xAA}},
+		},
+	}
+	inst := instantiate(t, m, Limits{})
+
+	tests := []struct {
+		name string
+		args []uint64
+		want uint64
+	}{
+		{"add", []uint64{2, 3}, 5},
+		{"add", []uint64{math.MaxUint32, 2}, 1},
+		{"fac", []uint64{20}, 2432902008176640000},
+		{"sum", []uint64{100}, 5050},
+		{"pick", []uint64{0}, 10},
+		{"pick", []uint64{1}, 20},
+		{"pick", []uint64{7}, 30},
+		{"memory", []uint64{0xFF}, math.MaxUint64},
+		{"memory", []uint64{0x7F}, 0x7F},
+		{"indirect", []uint64{0}, 5},
+		{"sqrt", []uint64{144}, 12},
+	}
+
+	for _, test := range tests {
+		results, err := inst.Call(context.Background(), test.name, test.args...)
+		if err != nil {
+			t.Errorf("%s(%v) = %v", test.name, test.args, err)
+			continue
+		}
+		if len(results) != 1 || results[0] != test.want {
+			t.Errorf("%s(%v) = %v, want %d", test.name, test.args, results, test.want)
+		}
+	}
+}
+
+func TestTraps(t *testing.T) {
+	m := testModule{
+		memory: []byte{0x00, 1},
+		table:  []uint32{1},
+		funcs: []testFunc{
+			{export: "unreachable", code: []byte{opUnreachable}},
+			{export: "div", params: i32i32, results: one32, code: []byte{opLocalGet, 0, opLocalGet, 1, 0x6D}},
+			{export: "load", params: one32, results: one32, code: []byte{opLocalGet, 0, opI32Load, 2, 0}},
+			{export: "indirect", params: one32, code: []byte{opLocalGet, 0, opCallIndirect, 0, 0}},
+			{export: "recurse", code: []byte{opCall, 4}},
+			{export: "trunc", params: []ValueType{F64}, results: one32, code: []byte{opLocalGet, 0, 0xAA}},
+		},
+	}
+	inst := instantiate(t, m, Limits{})
+
+	tests := []struct {
+		name string
+		args []uint64
+		want string
+	}{
+		{"unreachable", nil, "unreachable"},
+		{"div", []uint64{1, 0}, "integer divide by zero"},
+		{"div", []uint64{1 << 31, math.MaxUint32}, "integer overflow"},
+		{"load", []uint64{PageSize - 3}, "out of bounds memory access"},
+		{"indirect", []uint64{0}, "indirect call type mismatch"},
+		{"indirect", []uint64{1}, "undefined element 1"},
+		{"recurse", nil, "call stack exhausted"},
+		{"trunc", []uint64{math.Float64bits(math.NaN())}, "invalid conversion"},
+		{"trunc", []uint64{math.Float64bits(3e9)}, "integer overflow"},
+	}
+
+	for _, test := range tests {
+		_, err := inst.Call(context.Background(), test.name, test.args...)
+		if _, ok := err.(*Trap); !ok || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s(%v) = %v, want a trap with %q", test.name, test.args, err, test.want)
+		}
+	}
+
+	// A trap leaves the instance usable
+	if results, err := inst.Call(context.Background(), "div", 6, 3); err != nil || results[0] != 2 {
+		t.Errorf("div(6, 3) after the traps = %v, %v", results, err)
+	}
+}
+
+func TestNumeric(t *testing.T) {
+	nan := math.Float64bits(math.NaN())
+	tests := []struct {
+		op   uint16
+		args []uint64
+		want uint64
+	}{
+		{0x67, []uint64{1}, 31},
+		{0x69, []uint64{0xF0F0}, 8},
+		{0x77, []uint64{0x80000001, 1}, 3},
+		{0x75, []uint64{0x80000000, 31}, math.MaxUint32},
+		{0x6F, []uint64{1 << 31, math.MaxUint32}, 0},
+		{0x81, []uint64{1 << 63, math.MaxUint64}, 0},
+		{0x7B, []uint64{math.MaxUint64}, 64},
+		{0xA4, []uint64{nan, math.Float64bits(1)}, nan},
+		{0xA4, []uint64{math.Float64bits(0), math.Float64bits(math.Copysign(0, -1))}, 1 << 63},
+		{0x9E, []uint64{math.Float64bits(2.5)}, math.Float64bits(2)},
+		{0xC0, []uint64{0x80}, 0xFFFFFF80},
+		{0xC4, []uint64{0x80000000}, 0xFFFFFFFF80000000},
+		{0xB1, []uint64{math.Float64bits(1e19)}, 10000000000000000000},
+		{opPrefix + 0, []uint64{uint64(math.Float32bits(float32(math.Inf(-1))))}, 1 << 31},
+		{opPrefix + 3, []uint64{math.Float64bits(-1)}, 0},
+		{opPrefix + 6, []uint64{nan}, 0},
+		{opPrefix + 6, []uint64{math.Float64bits(1e300)}, math.MaxInt64},
+		{opPrefix + 7, []uint64{math.Float64bits(1e300)}, math.MaxUint64},
+	}
+
+	for _, test := range tests {
+		var got uint64
+		if len(test.args) == 1 {
+			got = unary(test.op, test.args[0])
+		} else {
+			got = binaryOp(test.op, test.args[0], test.args[1])
+		}
+		if got != test.want {
+			t.Errorf("op 0x%x(%x) = %x, want %x", test.op, test.args, got, test.want)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	m := testModule{
+		memory: []byte{0x00, 1},
+		funcs: []testFunc{
+			{export: "loop", code: []byte{opLoop, 0x40, opBr, 0, opEnd}},
+			{export: "grow", params: one32, results: one32, code: []byte{opLocalGet, 0, opMemoryGrow, 0}},
+		},
+	}
+
+	inst := instantiate(t, m, Limits{MemoryPages: 4, Fuel: 1000})
+	if _, err := inst.Call(context.Background(), "loop"); err != ErrFuel {
+		t.Errorf("loop() = %v, want %v", err, ErrFuel)
+	}
+
+	inst = instantiate(t, m, Limits{MemoryPages: 4})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := inst.Call(ctx, "loop"); err != context.DeadlineExceeded {
+		t.Errorf("loop() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	for _, test := range []struct{ delta, want uint64 }{{2, 1}, {2, math.MaxUint32}, {1, 3}, {0, 4}} {
+		results, err := inst.Call(context.Background(), "grow", test.delta)
+		if err != nil || results[0] != test.want {
+			t.Errorf("grow(%d) = %v, %v, want %d", test.delta, results, err, test.want)
+		}
+	}
+	if len(inst.Memory()) != 4*PageSize {
+		t.Errorf("the memory has %d bytes, want %d", len(inst.Memory()), 4*PageSize)
+	}
+
+	module, err := Decode(testModule{memory: []byte{0x00, 5}}.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Instantiate(context.Background(), module, nil, Limits{MemoryPages: 4}); err == nil {
+		t.Errorf("Instantiate() of 5 pages with a limit of 4 = nil, want an error")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid := testModule{funcs: []testFunc{{code: nil}}}.bytes()
+
+	tests := []struct {
+		name   string
+		module []byte
+		want   string
+	}{
+		{"magic", []byte("\x00elf\x01\x00\x00\x00"), "no WebAssembly binary module"},
+		{"version", []byte("\x00asm\x02\x00\x00\x00"), "unsupported version"},
+		{"truncated", valid[:len(valid)-2], "unexpected end"},
+		{"type mismatch", testModule{funcs: []testFunc{{results: one32, code: i64(1)}}}.bytes(), "type mismatch"},
+		{"stack left", testModule{funcs: []testFunc{{code: i32(1)}}}.bytes(), "values left"},
+		{"empty stack", testModule{funcs: []testFunc{{code: []byte{opDrop}}}}.bytes(), "the stack is empty"},
+		{"unknown opcode", testModule{funcs: []testFunc{{code: []byte{0xFF}}}}.bytes(), "unknown opcode 0xff"},
+		{"unknown label", testModule{funcs: []testFunc{{code: []byte{opBr, 1}}}}.bytes(), "unknown label"},
+		{"no memory", testModule{funcs: []testFunc{{results: one32, code: []byte{opMemorySize, 0}}}}.bytes(), "without a memory"},
+		{"local", testModule{funcs: []testFunc{{code: []byte{opLocalGet, 0, opDrop}}}}.bytes(), "unknown local"},
+		{"call", testModule{funcs: []testFunc{{code: []byte{opCall, 5}}}}.bytes(), "unknown function"},
+		{"memory size", testModule{memory: []byte{0x00, 0x81, 0x80, 0x04}}.bytes(), "must not be larger"},
+	}
+
+	for _, test := range tests {
+		_, err := Decode(test.module)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: Decode() = %v, want an error with %q", test.name, err, test.want)
+		}
+	}
+}
+
+// echoModule echoes stdin to stdout and exits with the first byte it read.
+func echoModule() []byte {
+	return testModule{
+		imports: []testImport{
+			{wasiModule, "fd_read", i32s(4), one32},
+			{wasiModule, "fd_write", i32s(4), one32},
+			{wasiModule, "proc_exit", one32, nil},
+			{wasiModule, "path_open", i32s(9), one32},
+		},
+		memory: []byte{0x00, 1},
+		// The iovec of 1024 bytes at 16
+		data: "\x10\x00\x00\x00\x00\x04\x00\x00",
+		funcs: []testFunc{{export: "_start", code: concat(
+			i32(0), i32(0), i32(1), i32(8), []byte{opCall, 0, opDrop},
+			i32(0), i32(8), []byte{opI32Load, 2, 0, 0x36, 2, 4},
+			i32(1), i32(0), i32(1), i32(12), []byte{opCall, 1, opDrop},
+			i32(16), []byte{0x2D, 0, 0, opCall, 2},
+		)}},
+	}.bytes()
+}
+
+func TestRunWASI(t *testing.T) {
+	module, err := Decode(echoModule())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	err = RunWASI(context.Background(), module, WASIConfig{Stdin: strings.NewReader("\x00 hello"), Stdout: &stdout})
+	if err != nil || stdout.String() != "\x00 hello" {
+		t.Errorf("RunWASI() = %v with %q, want nil with the input", err, stdout.String())
+	}
+
+	stdout.Reset()
+	err = RunWASI(context.Background(), module, WASIConfig{Stdin: strings.NewReader("\x03"), Stdout: &stdout})
+	if exit, ok := err.(*ExitError); !ok || exit.Code != 3 {
+		t.Errorf("RunWASI() = %v, want exit status 3", err)
+	}
+
+	err = RunWASI(context.Background(), module, WASIConfig{Stdin: strings.NewReader("\x00"), Stdout: failingWriter{}})
+	if err != errWrite {
+		t.Errorf("RunWASI() with a failing stdout = %v, want %v", err, errWrite)
+	}
+
+	module, err = Decode(testModule{
+		imports: []testImport{{"env", "missing", nil, nil}},
+		funcs:   []testFunc{{export: "_start"}},
+	}.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RunWASI(context.Background(), module, WASIConfig{}); err == nil || !strings.Contains(err.Error(), "unknown import env.missing") {
+		t.Errorf("RunWASI() = %v, want an unknown import", err)
+	}
+}
+
+var errWrite = &Trap{Reason: "write failed"}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errWrite }
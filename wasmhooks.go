@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+	"github.com/johscheuer/todo-app-web/wasm"
+)
+
+const (
+	// The events hooks run on
+	hookCreate   = "create"
+	hookComplete = "complete"
+
+	wasmHooksKey = "wasmhooks"
+
+	defaultHookTimeoutMillis = 1000
+	defaultHookMemoryMB      = 16
+	defaultHookFuel          = 10000000
+	defaultHookModuleBytes   = 1 << 20
+	// hookOutputBytes is the most a hook can write to stdout
+	hookOutputBytes = 64 << 10
+	// hookQueueLength is how many todos can wait for their hooks, more are
+	// skipped
+	hookQueueLength = 1000
+)
+
+var hookNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// WasmHooksConfig runs the WebAssembly modules uploaded to /admin/hooks in
+// the embedded WASI runtime, as hooks on the creation and completion of
+// todos. Without Enabled no hooks run and none can be uploaded.
+type WasmHooksConfig struct {
+	Enabled bool
+	// Runtime and Args ran the hooks with an external runtime, they are
+	// deprecated and ignored. A Runtime enables the hooks
+	Runtime string
+	Args    []string
+	// TimeoutMillis is how long a hook can run, 1000 by default
+	TimeoutMillis int
+	// MemoryMB is the linear memory a hook can grow to, 16 by default
+	MemoryMB int
+	// Fuel is the number of instructions a hook can run, 10000000 by default
+	Fuel int64
+	// MaxModuleBytes is the largest module that can be uploaded, 1 MiB by
+	// default
+	MaxModuleBytes int64
+}
+
+// wasmHook is an uploaded module and the events it runs on.
+type wasmHook struct {
+	Name       string    `json:"name"`
+	Events     []string  `json:"events"`
+	SHA256     string    `json:"sha256"`
+	Size       int       `json:"size"`
+	UploadedAt time.Time `json:"uploadedAt"`
+	Module     []byte    `json:"module,omitempty"`
+}
+
+// hookInput is written to the stdin of a hook.
+type hookInput struct {
+	Event string      `json:"event"`
+	Todo  tododb.Todo `json:"todo"`
+}
+
+// hookOutput is what a hook writes to stdout, the fields it changes. No
+// output changes nothing.
+type hookOutput struct {
+	Title       *string   `json:"title"`
+	Description *string   `json:"description"`
+	Tags        *[]string `json:"tags"`
+	Priority    *int      `json:"priority"`
+}
+
+type hookJob struct {
+	event string
+	todo  tododb.Todo
+}
+
+var (
+	hooksConfig WasmHooksConfig
+	hookJobs    = make(chan hookJob, hookQueueLength)
+	// wasmHooksMu serializes the uploads and deletes of this instance, the
+	// hooks are read, changed and written back as a whole
+	wasmHooksMu sync.Mutex
+)
+
+// withHookDefaults fills in the defaults of config.
+func withHookDefaults(config WasmHooksConfig) WasmHooksConfig {
+	if config.TimeoutMillis <= 0 {
+		config.TimeoutMillis = defaultHookTimeoutMillis
+	}
+	if config.MemoryMB <= 0 {
+		config.MemoryMB = defaultHookMemoryMB
+	}
+	if config.Fuel <= 0 {
+		config.Fuel = defaultHookFuel
+	}
+	if config.MaxModuleBytes <= 0 {
+		config.MaxModuleBytes = defaultHookModuleBytes
+	}
+
+	return config
+}
+
+func loadWasmHooks() (map[string]wasmHook, error) {
+	hooks := map[string]wasmHook{}
+	value, err := tododb.KVOf(database).GetValue(wasmHooksKey)
+	if err == tododb.ErrNotFound {
+		return hooks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return hooks, json.Unmarshal([]byte(value), &hooks)
+}
+
+func saveWasmHooks(hooks map[string]wasmHook) error {
+	value, err := json.Marshal(hooks)
+	if err != nil {
+		return err
+	}
+
+	return tododb.KVOf(database).SetValue(wasmHooksKey, string(value), 0)
+}
+
+// queueHooks makes runWasmHooks run the hooks of event on todos. A full
+// queue skips them.
+func queueHooks(event string, todos ...tododb.Todo) {
+	if !hooksConfig.Enabled {
+		return
+	}
+
+	for _, todo := range todos {
+		select {
+		case hookJobs <- hookJob{event: event, todo: todo}:
+		default:
+			logger.Warnf("Skipped the %s hooks of todo %s, %d are queued already", event, todo.ID, hookQueueLength)
+		}
+	}
+}
+
+// runWasmHooks runs the hooks of the queued todos, one after the other in the
+// order of their names, each gets the todo as the one before left it. The
+// changes are written at once, a hook that fails is skipped.
+func runWasmHooks() {
+	for job := range hookJobs {
+		hooks, err := loadWasmHooks()
+		if err != nil {
+			logger.Errorf("Loading the hooks: %v", err)
+			continue
+		}
+		names := []string{}
+		for name, hook := range hooks {
+			if hookRunsOn(hook, job.event) {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		sort.Strings(names)
+
+		todo, changes := job.todo, hookOutput{}
+		for _, name := range names {
+			output, err := runWasmHook(hooks[name], hookInput{Event: job.event, Todo: todo})
+			if err == nil {
+				err = applyHookOutput(&todo, output)
+			}
+			if err != nil {
+				logger.Errorf("Hook %s on %s of todo %s: %v", name, job.event, job.todo.ID, err)
+				continue
+			}
+			changes = changes.merge(output)
+		}
+		if changes == (hookOutput{}) {
+			continue
+		}
+
+		// Only the fields the hooks changed are written, edits made while
+		// they ran are kept
+		var updated tododb.Todo
+		err = database.UpdateTodo(context.Background(), todo.ID, func(stored *tododb.Todo) {
+			applyHookOutput(stored, changes)
+			updated = *stored
+		})
+		if err != nil && err != tododb.ErrNotFound {
+			logger.Errorf("Saving the hooks of todo %s: %v", todo.ID, err)
+		}
+		if err == nil {
+			dropCachedSmartLists()
+			publishChange(changeUpdated, updated)
+		}
+	}
+}
+
+// merge returns the changes of output on top of those of changes.
+func (changes hookOutput) merge(output hookOutput) hookOutput {
+	if output.Title != nil {
+		changes.Title = output.Title
+	}
+	if output.Description != nil {
+		changes.Description = output.Description
+	}
+	if output.Tags != nil {
+		changes.Tags = output.Tags
+	}
+	if output.Priority != nil {
+		changes.Priority = output.Priority
+	}
+
+	return changes
+}
+
+func hookRunsOn(hook wasmHook, event string) bool {
+	for _, candidate := range hook.Events {
+		if candidate == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyHookOutput checks the fields a hook changed and sets them on todo,
+// none if one of them is invalid.
+func applyHookOutput(todo *tododb.Todo, output hookOutput) error {
+	if output.Title != nil && strings.TrimSpace(*output.Title) == "" {
+		return errors.New("the title must not be empty")
+	}
+	if output.Priority != nil && (*output.Priority < tododb.PriorityNone || *output.Priority > tododb.PriorityHigh) {
+		return fmt.Errorf("the priority must be between %d and %d", tododb.PriorityNone, tododb.PriorityHigh)
+	}
+	tags := todo.Tags
+	if output.Tags != nil {
+		var err error
+		if tags, err = parseTags(*output.Tags); err != nil {
+			return err
+		}
+	}
+
+	todo.Tags = tags
+	if output.Title != nil {
+		todo.Title = *output.Title
+	}
+	if output.Description != nil {
+		todo.Description = *output.Description
+	}
+	if output.Priority != nil {
+		todo.Priority = *output.Priority
+	}
+
+	return nil
+}
+
+// errHookOutput ends hooks that write more than hookOutputBytes.
+var errHookOutput = fmt.Errorf("the hook wrote more than %d bytes", hookOutputBytes)
+
+// limitedBuffer keeps up to limit bytes. The buffer isn't embedded, its
+// ReadFrom would let io.Copy bypass the limit.
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	limit    int
+	exceeded bool
+}
+
+func (buf *limitedBuffer) Write(p []byte) (int, error) {
+	if buf.buf.Len()+len(p) > buf.limit {
+		buf.exceeded = true
+		return 0, errHookOutput
+	}
+
+	return buf.buf.Write(p)
+}
+
+func (buf *limitedBuffer) Bytes() []byte { return buf.buf.Bytes() }
+
+func (buf *limitedBuffer) String() string { return buf.buf.String() }
+
+// runWasmHook runs hook in the embedded runtime with input on stdin, with
+// the limits of the config. A module that doesn't match its digest isn't
+// run.
+func runWasmHook(hook wasmHook, input hookInput) (hookOutput, error) {
+	sum := sha256.Sum256(hook.Module)
+	if hex.EncodeToString(sum[:]) != hook.SHA256 {
+		return hookOutput{}, errors.New("the module doesn't match its SHA-256")
+	}
+	module, err := wasm.Decode(hook.Module)
+	if err != nil {
+		return hookOutput{}, err
+	}
+	stdin, err := json.Marshal(input)
+	if err != nil {
+		return hookOutput{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(hooksConfig.TimeoutMillis)*time.Millisecond)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: hookOutputBytes}
+	stderr := &limitedBuffer{limit: hookOutputBytes}
+	err = wasm.RunWASI(ctx, module, wasm.WASIConfig{
+		Stdin:  bytes.NewReader(stdin),
+		Stdout: stdout,
+		Stderr: stderr,
+		Limits: wasm.Limits{
+			MemoryPages: uint32(hooksConfig.MemoryMB << 20 / wasm.PageSize),
+			Fuel:        hooksConfig.Fuel,
+		},
+	})
+	switch {
+	case err == context.DeadlineExceeded:
+		return hookOutput{}, fmt.Errorf("the hook ran longer than %dms", hooksConfig.TimeoutMillis)
+	case err == errHookOutput:
+		return hookOutput{}, err
+	case err != nil && stderr.buf.Len() > 0:
+		return hookOutput{}, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	case err != nil:
+		return hookOutput{}, err
+	}
+
+	var output hookOutput
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return output, nil
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return hookOutput{}, fmt.Errorf("invalid output: %v", err)
+	}
+
+	return output, nil
+}
+
+// listHooksHandler lists the uploaded hooks without their modules.
+func listHooksHandler(c *gin.Context) {
+	hooks, err := loadWasmHooks()
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	list := []wasmHook{}
+	for _, hook := range hooks {
+		hook.Module = nil
+		list = append(list, hook)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	c.JSON(http.StatusOK, list)
+}
+
+// uploadHookHandler stores the module in the body as the hook name, on the
+// events of ?events=, a comma separated list of create and complete.
+func uploadHookHandler(c *gin.Context) {
+	if !hooksConfig.Enabled {
+		c.JSON(http.StatusConflict, gin.H{
+			"errors": "WasmHooks.Enabled isn't set",
+		})
+		return
+	}
+	name := c.Param("name")
+	if !hookNamePattern.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": "the name must be up to 64 lowercase letters, digits and dashes",
+		})
+		return
+	}
+	events := []string{}
+	for _, event := range strings.Split(c.Query("events"), ",") {
+		event = strings.TrimSpace(event)
+		if event != hookCreate && event != hookComplete {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": fmt.Sprintf("unknown event %q, use create or complete", event),
+			})
+			return
+		}
+		events = append(events, event)
+	}
+
+	module, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, hooksConfig.MaxModuleBytes+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	if int64(len(module)) > hooksConfig.MaxModuleBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"errors": fmt.Sprintf("a module must not be larger than %d bytes", hooksConfig.MaxModuleBytes),
+		})
+		return
+	}
+	if _, err := wasm.Decode(module); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("the body is no valid WebAssembly module: %v", err),
+		})
+		return
+	}
+
+	sum := sha256.Sum256(module)
+	hook := wasmHook{
+		Name:       name,
+		Events:     events,
+		SHA256:     hex.EncodeToString(sum[:]),
+		Size:       len(module),
+		UploadedAt: time.Now().UTC(),
+		Module:     module,
+	}
+
+	wasmHooksMu.Lock()
+	defer wasmHooksMu.Unlock()
+
+	hooks, err := loadWasmHooks()
+	if err == nil {
+		hooks[name] = hook
+		err = saveWasmHooks(hooks)
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	logger.Infof("Uploaded hook %s on %s", name, strings.Join(events, ", "))
+	hook.Module = nil
+	c.JSON(http.StatusOK, hook)
+}
+
+func deleteHookHandler(c *gin.Context) {
+	name := c.Param("name")
+
+	wasmHooksMu.Lock()
+	defer wasmHooksMu.Unlock()
+
+	hooks, err := loadWasmHooks()
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+	if _, exists := hooks[name]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": fmt.Sprintf("no hook called %q", name),
+		})
+		return
+	}
+	delete(hooks, name)
+	if err := saveWasmHooks(hooks); err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	logger.Infof("Deleted hook %s", name)
+	c.Status(http.StatusNoContent)
+}
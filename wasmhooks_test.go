@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+func stringPtr(s string) *string { return &s }
+
+func intPtr(i int) *int { return &i }
+
+func TestWithHookDefaults(t *testing.T) {
+	got := withHookDefaults(WasmHooksConfig{Enabled: true, MemoryMB: 64})
+	want := WasmHooksConfig{
+		Enabled:        true,
+		TimeoutMillis:  defaultHookTimeoutMillis,
+		MemoryMB:       64,
+		Fuel:           defaultHookFuel,
+		MaxModuleBytes: defaultHookModuleBytes,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("withHookDefaults() = %+v, want %+v", got, want)
+	}
+}
+
+func TestHookOutputMerge(t *testing.T) {
+	tags := []string{"work"}
+	first := hookOutput{Title: stringPtr("first"), Priority: intPtr(1)}
+	second := hookOutput{Title: stringPtr("second"), Tags: &tags}
+
+	got := first.merge(second)
+	if *got.Title != "second" || *got.Priority != 1 || got.Tags != &tags || got.Description != nil {
+		t.Errorf("merge() = %+v, want the title and tags of the second and the priority of the first", got)
+	}
+	if *first.Title != "first" {
+		t.Errorf("merge() changed the receiver to %q", *first.Title)
+	}
+}
+
+func TestHookRunsOn(t *testing.T) {
+	hook := wasmHook{Events: []string{changeCreated}}
+	if !hookRunsOn(hook, changeCreated) || hookRunsOn(hook, webhookCompleted) {
+		t.Errorf("hookRunsOn(%v) is wrong", hook.Events)
+	}
+}
+
+func TestApplyHookOutput(t *testing.T) {
+	badTags := []string{"has space"}
+	tags := []string{"Work", "home"}
+
+	tests := []struct {
+		name   string
+		output hookOutput
+		want   tododb.Todo
+		err    bool
+	}{
+		{name: "nothing", output: hookOutput{}, want: tododb.Todo{Title: "todo", Tags: []string{"old"}}},
+		{
+			name:   "everything",
+			output: hookOutput{Title: stringPtr("new"), Description: stringPtr("desc"), Tags: &tags, Priority: intPtr(tododb.PriorityHigh)},
+			want:   tododb.Todo{Title: "new", Description: "desc", Tags: []string{"work", "home"}, Priority: tododb.PriorityHigh},
+		},
+		{name: "empty title", output: hookOutput{Title: stringPtr(" "), Description: stringPtr("desc")}, err: true},
+		{name: "priority", output: hookOutput{Priority: intPtr(tododb.PriorityHigh + 1)}, err: true},
+		{name: "tags", output: hookOutput{Title: stringPtr("new"), Tags: &badTags}, err: true},
+	}
+
+	for _, test := range tests {
+		todo := tododb.Todo{Title: "todo", Tags: []string{"old"}}
+		err := applyHookOutput(&todo, test.output)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: applyHookOutput() = nil, want an error", test.name)
+			}
+			if todo.Title != "todo" || todo.Description != "" || !reflect.DeepEqual(todo.Tags, []string{"old"}) {
+				t.Errorf("%s: applyHookOutput() changed the todo to %+v", test.name, todo)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: applyHookOutput() = %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(todo, test.want) {
+			t.Errorf("%s: applyHookOutput() = %+v, want %+v", test.name, todo, test.want)
+		}
+	}
+}
+
+func TestMigrateWasmHooksRuntime(t *testing.T) {
+	config := &TodoAppConfig{WasmHooks: WasmHooksConfig{Runtime: "wasmtime", Args: []string{"run"}}}
+	migrateWasmHooksRuntime(config)
+	if !config.WasmHooks.Enabled || config.WasmHooks.Runtime != "" || config.WasmHooks.Args != nil {
+		t.Errorf("migrateWasmHooksRuntime() = %+v, want the hooks enabled without the runtime", config.WasmHooks)
+	}
+
+	config = &TodoAppConfig{}
+	migrateWasmHooksRuntime(config)
+	if config.WasmHooks.Enabled {
+		t.Errorf("migrateWasmHooksRuntime() enabled the hooks without a runtime")
+	}
+}
+
+func uleb(n int) []byte {
+	var b []byte
+	for ; n >= 0x80; n >>= 7 {
+		b = append(b, byte(n)|0x80)
+	}
+
+	return append(b, byte(n))
+}
+
+func wasmVec(items ...[]byte) []byte {
+	return append(uleb(len(items)), bytes.Join(items, nil)...)
+}
+
+func wasmName(s string) []byte {
+	return append(uleb(len(s)), s...)
+}
+
+func wasmSection(id byte, content []byte) []byte {
+	return append(append([]byte{id}, uleb(len(content))...), content...)
+}
+
+// The code of the _start functions of hookModule
+var (
+	// hookRead reads stdin into the buffer and writes as much of it
+	hookRead = []byte{0x41, 0, 0x41, 0, 0x41, 1, 0x41, 8, 0x10, 0, 0x1A, 0x41, 0, 0x41, 8, 0x28, 2, 0, 0x36, 2, 4}
+	// hookSpin loops forever
+	hookSpin = []byte{0x03, 0x40, 0x0C, 0, 0x0B}
+)
+
+// hookWrite writes the buffer to fd.
+func hookWrite(fd byte) []byte {
+	return []byte{0x41, fd, 0x41, 0, 0x41, 1, 0x41, 12, 0x10, 1, 0x1A}
+}
+
+func hookExit(code byte) []byte {
+	return []byte{0x41, code, 0x10, 2}
+}
+
+func hookLoop(code []byte) []byte {
+	return append(append([]byte{0x03, 0x40}, code...), 0x0C, 0, 0x0B)
+}
+
+// hookModule builds a WASI module with pages of memory that runs code as
+// _start. Its buffer holds text, or 4096 bytes for stdin without it.
+func hookModule(pages byte, text string, code ...[]byte) []byte {
+	length := len(text)
+	if text == "" {
+		length = 4096
+	}
+	data := append([]byte{16, 0, 0, 0, byte(length), byte(length >> 8), 0, 0}, make([]byte, 8)...)
+	wasi := wasmName("wasi_snapshot_preview1")
+	body := append(append([]byte{0}, bytes.Join(code, nil)...), 0x0B)
+
+	return bytes.Join([][]byte{
+		[]byte("\x00asm\x01\x00\x00\x00"),
+		wasmSection(1, wasmVec(
+			[]byte{0x60, 4, 0x7F, 0x7F, 0x7F, 0x7F, 1, 0x7F},
+			[]byte{0x60, 1, 0x7F, 0},
+			[]byte{0x60, 0, 0},
+		)),
+		wasmSection(2, wasmVec(
+			append(append(wasi, wasmName("fd_read")...), 0, 0),
+			append(append(wasi, wasmName("fd_write")...), 0, 0),
+			append(append(wasi, wasmName("proc_exit")...), 0, 1),
+		)),
+		wasmSection(3, wasmVec([]byte{2})),
+		wasmSection(5, wasmVec([]byte{0, pages})),
+		wasmSection(7, wasmVec(append(wasmName("_start"), 0, 3))),
+		wasmSection(10, wasmVec(append(uleb(len(body)), body...))),
+		wasmSection(11, wasmVec(append([]byte{0, 0x41, 0, 0x0B}, wasmName(string(data)+text)...))),
+	}, nil)
+}
+
+func newTestHook(module []byte) wasmHook {
+	sum := sha256.Sum256(module)
+	return wasmHook{Name: "test", SHA256: hex.EncodeToString(sum[:]), Module: module}
+}
+
+func TestRunWasmHook(t *testing.T) {
+	input := hookInput{Event: changeCreated, Todo: tododb.Todo{Title: "todo"}}
+	tampered := newTestHook(hookModule(1, `{"title": "changed"}`, hookWrite(1)))
+	tampered.Module = hookModule(1, `{"title": "tampered"}`, hookWrite(1))
+
+	tests := []struct {
+		name string
+		hook wasmHook
+		want hookOutput
+		err  string
+	}{
+		{name: "no output", hook: newTestHook(hookModule(1, "", hookRead))},
+		{
+			name: "output",
+			hook: newTestHook(hookModule(1, `{"title": "changed"}`, hookWrite(1))),
+			want: hookOutput{Title: stringPtr("changed")},
+		},
+		{name: "input", hook: newTestHook(hookModule(1, "", hookRead, hookWrite(2), hookExit(1))), err: `"event":"created"`},
+		{name: "invalid output", hook: newTestHook(hookModule(1, "nope", hookWrite(1))), err: "invalid output"},
+		{name: "failure", hook: newTestHook(hookModule(1, "trapped", hookWrite(2), hookExit(3))), err: "exit status 3: trapped"},
+		{name: "too much output", hook: newTestHook(hookModule(1, "yes", hookLoop(hookWrite(1)))), err: errHookOutput.Error()},
+		{name: "fuel", hook: newTestHook(hookModule(1, "", hookSpin)), err: "out of fuel"},
+		{name: "memory", hook: newTestHook(hookModule(17, "", hookRead)), err: "16 are allowed"},
+		{name: "digest", hook: tampered, err: "doesn't match its SHA-256"},
+	}
+
+	previous := hooksConfig
+	defer func() { hooksConfig = previous }()
+	hooksConfig = withHookDefaults(WasmHooksConfig{Enabled: true, MemoryMB: 1})
+
+	for _, test := range tests {
+		got, err := runWasmHook(test.hook, input)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: runWasmHook() = %v, want an error with %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: runWasmHook() = %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: runWasmHook() = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+
+	hooksConfig = withHookDefaults(WasmHooksConfig{Enabled: true, TimeoutMillis: 50, Fuel: 1 << 62})
+	if _, err := runWasmHook(newTestHook(hookModule(1, "", hookSpin)), input); err == nil || !strings.Contains(err.Error(), "longer than 50ms") {
+		t.Errorf("runWasmHook() of a hook that spins = %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/logging"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+var watchdogLogger = logging.New("watchdog")
+
+type watchdogSample struct {
+	Goroutines         int
+	OpenFDs            int
+	BackendConnections int64
+}
+
+func takeWatchdogSample(db tododb.TodoDB) watchdogSample {
+	sample := watchdogSample{
+		Goroutines:         runtime.NumGoroutine(),
+		OpenFDs:            -1,
+		BackendConnections: -1,
+	}
+
+	// Only available on Linux
+	if fds, err := ioutil.ReadDir("/proc/self/fd"); err == nil {
+		sample.OpenFDs = len(fds)
+	}
+
+	if counter, ok := db.(tododb.ConnectionCounter); ok {
+		sample.BackendConnections = counter.OpenConnections()
+	}
+
+	return sample
+}
+
+// runWatchdog samples resource usage every interval and warns while one of
+// them is above its threshold. The goroutine dump is only logged when a
+// threshold is crossed, not on every sample.
+func runWatchdog(config WatchdogConfig, metrics *Metrics) {
+	exceeded := map[string]bool{}
+	for range time.Tick(time.Duration(config.IntervalSeconds) * time.Second) {
+		sample := takeWatchdogSample(database)
+		metrics.backendConnections.Set(float64(sample.BackendConnections))
+
+		checks := []struct {
+			resource string
+			value    int64
+			limit    int64
+		}{
+			{"goroutines", int64(sample.Goroutines), int64(config.MaxGoroutines)},
+			{"open_fds", int64(sample.OpenFDs), int64(config.MaxOpenFDs)},
+			{"backend_connections", sample.BackendConnections, int64(config.MaxBackendConnections)},
+		}
+
+		for _, check := range checks {
+			if check.limit <= 0 || check.value <= check.limit {
+				exceeded[check.resource] = false
+				continue
+			}
+
+			metrics.watchdogAlertsTotal.WithLabelValues(check.resource).Inc()
+			if exceeded[check.resource] {
+				watchdogLogger.Warnf("%s still at %d, threshold is %d", check.resource, check.value, check.limit)
+				continue
+			}
+
+			exceeded[check.resource] = true
+			watchdogLogger.Warnf("%s at %d exceeds threshold of %d, goroutines:\n%s",
+				check.resource, check.value, check.limit, goroutineDump())
+		}
+	}
+}
+
+func goroutineDump() string {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return err.Error()
+	}
+
+	return buf.String()
+}
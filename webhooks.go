@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	// webhookCompleted is sent when a todo is done, next to its update
+	webhookCompleted = "completed"
+	// webhookDigest is sent with the digest of Digest.Period
+	webhookDigest = "digest"
+
+	defaultWebhookTimeout     = 10 * time.Second
+	defaultWebhookContentType = "application/json"
+	// webhookQueueLength is how many events can wait for their delivery,
+	// more are dropped
+	webhookQueueLength = 1000
+)
+
+// webhookEvents are the event types hooks can subscribe to.
+var webhookEvents = map[string]bool{
+	changeCreated:    true,
+	changeUpdated:    true,
+	changeDeleted:    true,
+	webhookCompleted: true,
+	webhookDigest:    true,
+}
+
+// WebhookConfig posts events to URL. Without Events a hook gets every event
+// but digests, without Tags the events of every todo.
+type WebhookConfig struct {
+	URL string
+	// Events are the event types: created, updated, deleted, completed and
+	// digest
+	Events []string
+	// Tags only passes the events of todos with a tag matching one of the
+	// patterns, like work or project-*, digests don't pass
+	Tags []string
+	// Template is a text/template of the body, executed with the event. The
+	// event as JSON is posted if empty
+	Template string
+	// ContentType of the body, application/json by default
+	ContentType string
+	// Headers are sent with every request, e.g. an Authorization
+	Headers map[string]string
+}
+
+// webhookEvent is what a hook is sent, and what its template is executed
+// with. Todo is set for the todo events, Digest and Text, the digest
+// rendered with Digest.Template, for digests.
+type webhookEvent struct {
+	Type   string       `json:"type"`
+	List   string       `json:"list"`
+	Todo   *tododb.Todo `json:"todo,omitempty"`
+	Digest *digest      `json:"digest,omitempty"`
+	Text   string       `json:"text,omitempty"`
+	Time   time.Time    `json:"time"`
+}
+
+// webhook is a configured hook, ready to filter and render events.
+type webhook struct {
+	config   WebhookConfig
+	events   map[string]bool
+	template *template.Template
+}
+
+// webhookFuncs are available to the templates, json quotes a value for a
+// JSON body.
+var webhookFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+	"join": strings.Join,
+}
+
+// webhooks are the hooks of the config, see newWebhooks.
+var webhooks []webhook
+
+var webhookQueue = make(chan webhookDelivery, webhookQueueLength)
+
+type webhookDelivery struct {
+	hook  webhook
+	event webhookEvent
+}
+
+// newWebhooks checks the events, tag patterns and templates of configs.
+func newWebhooks(configs []WebhookConfig) ([]webhook, error) {
+	hooks := []webhook{}
+	for i, config := range configs {
+		if config.URL == "" {
+			return nil, fmt.Errorf("Webhooks[%d]: URL is missing", i)
+		}
+
+		hook := webhook{config: config, events: map[string]bool{}}
+		for _, event := range config.Events {
+			if !webhookEvents[event] {
+				return nil, fmt.Errorf("Webhooks[%d]: unknown event %q, use created, updated, deleted, completed or digest", i, event)
+			}
+			hook.events[event] = true
+		}
+		if len(hook.events) == 0 {
+			for event := range webhookEvents {
+				hook.events[event] = event != webhookDigest
+			}
+		}
+		for _, pattern := range config.Tags {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("Webhooks[%d]: invalid tag pattern %q", i, pattern)
+			}
+		}
+		if config.Template != "" {
+			tmpl, err := template.New(fmt.Sprintf("webhook %d", i)).Funcs(webhookFuncs).Parse(config.Template)
+			if err != nil {
+				return nil, fmt.Errorf("Webhooks[%d]: %v", i, err)
+			}
+			hook.template = tmpl
+		}
+		hooks = append(hooks, hook)
+	}
+
+	return hooks, nil
+}
+
+// matches reports whether the hook wants event.
+func (hook webhook) matches(event webhookEvent) bool {
+	if !hook.events[event.Type] {
+		return false
+	}
+	if len(hook.config.Tags) == 0 {
+		return true
+	}
+	if event.Todo == nil {
+		return false
+	}
+
+	for _, pattern := range hook.config.Tags {
+		for _, tag := range event.Todo.Tags {
+			if matched, _ := path.Match(pattern, tag); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// body renders event with the template of the hook.
+func (hook webhook) body(event webhookEvent) ([]byte, error) {
+	if hook.template == nil {
+		return json.Marshal(event)
+	}
+
+	var buf bytes.Buffer
+	if err := hook.template.Execute(&buf, event); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// send posts event to the hook.
+func (hook webhook) send(event webhookEvent) error {
+	body, err := hook.body(event)
+	if err != nil {
+		return err
+	}
+
+	contentType := hook.config.ContentType
+	if contentType == "" {
+		contentType = defaultWebhookContentType
+	}
+
+	return postWebhook(hook.config.URL, contentType, hook.config.Headers, body)
+}
+
+// postWebhook posts body to url, answers other than 2xx are errors.
+func postWebhook(url, contentType string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	client := &http.Client{Timeout: defaultWebhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s answered with %s", url, resp.Status)
+	}
+
+	return nil
+}
+
+// queueWebhooks queues an event of kind per todo for the hooks that want
+// it. Changes without a todo, like resets, aren't sent.
+func queueWebhooks(kind string, todos ...tododb.Todo) {
+	now := time.Now().UTC()
+	for i := range todos {
+		queueWebhookEvent(webhookEvent{Type: kind, List: changeFeed.list, Todo: &todos[i], Time: now})
+	}
+}
+
+// queueWebhookEvent queues event for the hooks that want it, a full queue
+// drops it.
+func queueWebhookEvent(event webhookEvent) {
+	for _, hook := range webhooks {
+		if !hook.matches(event) {
+			continue
+		}
+		select {
+		case webhookQueue <- webhookDelivery{hook: hook, event: event}:
+		default:
+			logger.Warnf("Dropped the %s event for %s, %d are queued already", event.Type, hook.config.URL, webhookQueueLength)
+		}
+	}
+}
+
+// runWebhooks delivers the queued events one after the other, in the order
+// they happened.
+func runWebhooks() {
+	for delivery := range webhookQueue {
+		if err := delivery.hook.send(delivery.event); err != nil {
+			logger.Errorf("%s webhook: %v", delivery.event.Type, err)
+		}
+	}
+}
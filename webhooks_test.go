@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+func TestNewWebhooksRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		config WebhookConfig
+	}{
+		{name: "no url", config: WebhookConfig{Events: []string{changeCreated}}},
+		{name: "unknown event", config: WebhookConfig{URL: "http://hooks", Events: []string{"archived"}}},
+		{name: "bad tag pattern", config: WebhookConfig{URL: "http://hooks", Tags: []string{"work["}}},
+		{name: "bad template", config: WebhookConfig{URL: "http://hooks", Template: "{{.Todo.Title"}},
+		{name: "unknown function", config: WebhookConfig{URL: "http://hooks", Template: "{{upper .Type}}"}},
+	}
+
+	for _, test := range tests {
+		if _, err := newWebhooks([]WebhookConfig{test.config}); err == nil {
+			t.Errorf("%s: newWebhooks() = nil, want an error", test.name)
+		}
+	}
+}
+
+func TestWebhookMatches(t *testing.T) {
+	hooks, err := newWebhooks([]WebhookConfig{
+		{URL: "http://all"},
+		{URL: "http://completed", Events: []string{webhookCompleted, webhookDigest}},
+		{URL: "http://projects", Tags: []string{"project-*", "work"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, completed, projects := hooks[0], hooks[1], hooks[2]
+
+	tagged := &tododb.Todo{Title: "ship it", Tags: []string{"home", "project-x"}}
+	untagged := &tododb.Todo{Title: "water the plants"}
+
+	tests := []struct {
+		name  string
+		hook  webhook
+		event webhookEvent
+		want  bool
+	}{
+		{name: "all created", hook: all, event: webhookEvent{Type: changeCreated, Todo: untagged}, want: true},
+		{name: "all deleted", hook: all, event: webhookEvent{Type: changeDeleted, Todo: untagged}, want: true},
+		{name: "all digest", hook: all, event: webhookEvent{Type: webhookDigest}, want: false},
+		{name: "completed created", hook: completed, event: webhookEvent{Type: changeCreated, Todo: tagged}, want: false},
+		{name: "completed completed", hook: completed, event: webhookEvent{Type: webhookCompleted, Todo: tagged}, want: true},
+		{name: "completed digest", hook: completed, event: webhookEvent{Type: webhookDigest}, want: true},
+		{name: "projects tagged", hook: projects, event: webhookEvent{Type: changeUpdated, Todo: tagged}, want: true},
+		{name: "projects untagged", hook: projects, event: webhookEvent{Type: changeUpdated, Todo: untagged}, want: false},
+		{name: "projects digest", hook: projects, event: webhookEvent{Type: webhookDigest}, want: false},
+	}
+
+	for _, test := range tests {
+		if got := test.hook.matches(test.event); got != test.want {
+			t.Errorf("%s: matches() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWebhookSend(t *testing.T) {
+	var contentType, authorization, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		authorization = r.Header.Get("Authorization")
+		content, _ := ioutil.ReadAll(r.Body)
+		body = string(content)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	hooks, err := newWebhooks([]WebhookConfig{{
+		URL:         server.URL,
+		Template:    `{"text": {{json .Todo.Title}}, "tags": "{{join .Todo.Tags ", "}}"}`,
+		ContentType: "application/vnd.chat+json",
+		Headers:     map[string]string{"Authorization": "Bearer secret"},
+	}, {
+		URL: server.URL + "/fail",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := webhookEvent{Type: changeCreated, List: "home", Todo: &tododb.Todo{Title: `say "hi"`, Tags: []string{"a", "b"}}}
+	if err := hooks[0].send(event); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"text": "say \"hi\"", "tags": "a, b"}`; body != want {
+		t.Errorf("body = %s, want %s", body, want)
+	}
+	if contentType != "application/vnd.chat+json" || authorization != "Bearer secret" {
+		t.Errorf("headers = %q, %q, want the configured ones", contentType, authorization)
+	}
+
+	if err := hooks[1].send(event); err == nil {
+		t.Error("send() to a 502 = nil, want an error")
+	}
+	if contentType != defaultWebhookContentType {
+		t.Errorf("Content-Type = %q, want %q", contentType, defaultWebhookContentType)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johscheuer/todo-app-web/tododb"
+)
+
+const (
+	defaultWorkloadDays  = 7
+	maxWorkloadDays      = 92
+	defaultWorkloadHours = 8
+)
+
+type workload struct {
+	Todos            int   `json:"todos"`
+	EstimatedMinutes int64 `json:"estimatedMinutes"`
+	// Unestimated todos are counted, but add no effort
+	Unestimated int `json:"unestimated"`
+}
+
+type workloadDay struct {
+	Day string `json:"day"`
+	workload
+	OverScheduled bool `json:"overScheduled"`
+}
+
+// parseEstimate parses an effort estimate like 30m or 1h30m into minutes,
+// empty removes the estimate.
+func parseEstimate(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	estimate, err := time.ParseDuration(value)
+	if err != nil || estimate < time.Minute || estimate%time.Minute != 0 {
+		return 0, fmt.Errorf("invalid estimate %q, use whole minutes like 30m or 1h30m", value)
+	}
+
+	return int(estimate / time.Minute), nil
+}
+
+func (w *workload) add(todo tododb.Todo) {
+	w.Todos++
+	if todo.Estimate > 0 {
+		w.EstimatedMinutes += int64(todo.Estimate)
+	} else {
+		w.Unestimated++
+	}
+}
+
+// dayRange parses ?from= (default today) and ?to= (default defaultDays from
+// on), it answers 400 itself if they aren't a range of at most maxDays days.
+func dayRange(c *gin.Context, defaultDays, maxDays int) (today, from, to time.Time, ok bool) {
+	now := time.Now()
+	today = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	from, err := time.ParseInLocation(dayFormat, c.DefaultQuery("from", today.Format(dayFormat)), today.Location())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("invalid from: %q", c.Query("from")),
+		})
+		return today, from, to, false
+	}
+
+	to, err = time.ParseInLocation(dayFormat, c.DefaultQuery("to", from.AddDate(0, 0, defaultDays-1).Format(dayFormat)), today.Location())
+	if err != nil || to.Before(from) || to.After(from.AddDate(0, 0, maxDays-1)) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": fmt.Sprintf("to must be a day within %d days after from", maxDays),
+		})
+		return today, from, to, false
+	}
+
+	return today, from, to, true
+}
+
+// workloadHandler sums up the estimates of the todos due per day from ?from=
+// to ?to=, and warns about days with more than WorkloadHoursPerDay.
+func workloadHandler(c *gin.Context) {
+	today, from, to, ok := dayRange(c, defaultWorkloadDays, maxWorkloadDays)
+	if !ok {
+		return
+	}
+
+	todos, err := todosOf(c).GetAllTodos(c.Request.Context(), tododb.TodoFilter{Status: tododb.StatusOpen})
+	if err != nil {
+		logger.Errorf("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": err.Error(),
+		})
+		return
+	}
+
+	days := []*workloadDay{}
+	byDay := map[string]*workloadDay{}
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		entry := &workloadDay{Day: day.Format(dayFormat)}
+		days = append(days, entry)
+		byDay[entry.Day] = entry
+	}
+
+	var overdue, unscheduled workload
+	for _, todo := range todos {
+		day, ok := dueDay(todo)
+		if !ok {
+			unscheduled.add(todo)
+			continue
+		}
+
+		if entry, exists := byDay[day]; exists {
+			entry.add(todo)
+		}
+		if day < today.Format(dayFormat) {
+			overdue.add(todo)
+		}
+	}
+
+	budget := int64(appConfig.WorkloadHoursPerDay) * 60
+	warnings := []string{}
+	for _, entry := range days {
+		if entry.EstimatedMinutes > budget {
+			entry.OverScheduled = true
+			warnings = append(warnings, fmt.Sprintf("%s is over-scheduled: %s estimated, %dh available",
+				entry.Day, time.Duration(entry.EstimatedMinutes)*time.Minute, appConfig.WorkloadHoursPerDay))
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"list":        defaultListName,
+		"hoursPerDay": appConfig.WorkloadHoursPerDay,
+		"days":        days,
+		"overdue":     overdue,
+		"unscheduled": unscheduled,
+		"warnings":    warnings,
+	})
+}